@@ -0,0 +1,296 @@
+// Accounting sessions and interim records
+package client
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/clock"
+	"github.com/IbrahimShahzad/diameter/message"
+	fsm "github.com/IbrahimShahzad/diameter/state"
+)
+
+// AccountingOptions configures a session started with StartAccounting.
+type AccountingOptions struct {
+	// DestinationRealm defaults to the realm of the peer.
+	DestinationRealm string
+	// Interval is the interim interval used until an ACA carries an
+	// Acct-Interim-Interval. 0 sends no interim records.
+	Interval time.Duration
+	// AVPs, when set, returns the AVPs to add to each record, such as
+	// usage counters, given its Accounting-Record-Type.
+	AVPs func(recordType uint32) []*message.AVP
+	// Class holds the Class values of the authorization answer of the
+	// session, to be echoed in every record.
+	Class [][]byte
+	// Realtime is the Accounting-Realtime-Required of the session, which
+	// an ACA carrying the AVP overrides. It defaults to DeliverAndGrant.
+	Realtime RealtimePolicy
+	// OnDisconnect, when set, is called when an interim record is
+	// rejected or, under DeliverAndGrant, cannot be delivered: the session
+	// ends and the service is to be withdrawn from the user.
+	OnDisconnect func(err error)
+}
+
+// AccountingSession sends the accounting records of one session. Interim
+// records follow the Acct-Interim-Interval of the latest ACA, as RFC 6733
+// section 9.8.2 requires: an ACA may raise or lower the interval at any
+// time, and 0 stops interim records. The Class AVPs of the latest answer
+// carrying any are echoed in the following records, as RFC 6733 section
+// 8.20 requires; each record also carries an Event-Timestamp. The session
+// follows the accounting state machine of RFC 6733 section 8.2, see
+// RealtimePolicy for records that cannot be delivered.
+type AccountingSession struct {
+	client *Client
+	id     string
+	opts   AccountingOptions
+	fsm    *fsm.FSM
+	// sendMu serializes the records of the session.
+	sendMu sync.Mutex
+
+	mu       sync.Mutex
+	number   uint32
+	interval time.Duration
+	timer    clock.Timer
+	stopped  bool
+	class    [][]byte
+	policy   RealtimePolicy
+}
+
+// StartAccounting sends the START_RECORD ACR of the session id and returns
+// the session once it is answered with success. An answer with another
+// result is returned as a message.ProtocolError. A record that cannot be
+// delivered fails the start under DeliverAndGrant only.
+func (c *Client) StartAccounting(ctx context.Context, id string, opts AccountingOptions) (*AccountingSession, error) {
+	s := c.newAccountingSession(id, opts)
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	s.trigger(acctEventStart)
+	ans, err := s.record(ctx, message.START_RECORD)
+	if err != nil {
+		return nil, err
+	}
+	if ans == nil {
+		s.mu.Lock()
+		s.schedule()
+		s.mu.Unlock()
+		return s, nil
+	}
+	s.update(ans)
+	return s, nil
+}
+
+// SendAccountingEvent sends the EVENT_RECORD ACR of the one-time event
+// id. An answer other than success is returned as a
+// message.ProtocolError; a record that cannot be delivered is an error
+// under DeliverAndGrant only.
+func (c *Client) SendAccountingEvent(ctx context.Context, id string, opts AccountingOptions) error {
+	s := c.newAccountingSession(id, opts)
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	s.trigger(acctEventEvent)
+	_, err := s.record(ctx, message.EVENT_RECORD)
+	return err
+}
+
+func (c *Client) newAccountingSession(id string, opts AccountingOptions) *AccountingSession {
+	s := &AccountingSession{
+		client:   c,
+		id:       id,
+		opts:     opts,
+		interval: c.limitInterim(opts.Interval),
+		class:    opts.Class,
+		policy:   opts.Realtime,
+	}
+	if s.policy == 0 {
+		s.policy = DeliverAndGrant
+	}
+	s.initFSM()
+	return s
+}
+
+// SessionID returns the Session-Id of the records.
+func (s *AccountingSession) SessionID() string {
+	return s.id
+}
+
+// Interval returns the current interim interval, 0 when no interim records
+// are sent.
+func (s *AccountingSession) Interval() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.interval
+}
+
+// Stop cancels the interim records and sends the STOP_RECORD ACR, once
+// any interim record being sent is answered.
+func (s *AccountingSession) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return ErrAccountingStopped
+	}
+	s.stopped = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.mu.Unlock()
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if s.fsm.GetState() != AccountingOpen {
+		return ErrAccountingStopped
+	}
+	s.trigger(acctEventStop)
+	_, err := s.record(ctx, message.STOP_RECORD)
+	return err
+}
+
+// next returns the Accounting-Record-Number of the next record. Callers
+// must hold s.mu.
+func (s *AccountingSession) next() uint32 {
+	number := s.number
+	s.number++
+	return number
+}
+
+// interim sends an INTERIM_RECORD ACR when the interim timer fires. A
+// record stored or lost keeps the cadence; a record rejected, or not
+// delivered under DeliverAndGrant, ends the session.
+func (s *AccountingSession) interim() {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	timeout := s.interval
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	s.trigger(acctEventInterim)
+	ans, err := s.record(ctx, message.INTERIM_RECORD)
+	switch {
+	case err != nil:
+		log.Printf("Error sending interim record of session %s, disconnecting: %v", s.id, err)
+		s.mu.Lock()
+		s.stopped = true
+		s.mu.Unlock()
+		if s.opts.OnDisconnect != nil {
+			s.opts.OnDisconnect(err)
+		}
+	case ans == nil:
+		s.mu.Lock()
+		s.schedule()
+		s.mu.Unlock()
+	default:
+		s.update(ans)
+	}
+}
+
+// update applies the Acct-Interim-Interval, Accounting-Realtime-Required
+// and Class AVPs of ans, if any, and restarts the interim timer.
+func (s *AccountingSession) update(ans *message.DiameterMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	var class [][]byte
+	for _, avp := range ans.AVPs {
+		if avp.Code != message.AVP_CLASS {
+			continue
+		}
+		if value, err := avp.Bytes(); err == nil {
+			class = append(class, value)
+		}
+	}
+	if len(class) > 0 {
+		s.class = class
+	}
+	if avp := ans.GetAVP(message.AVP_ACCOUNTING_REALTIME_REQUIRED); avp != nil {
+		if value, err := avp.Uint32(); err == nil && value >= uint32(DeliverAndGrant) && value <= uint32(GrantAndLose) {
+			s.policy = RealtimePolicy(value)
+		}
+	}
+	if avp := ans.GetAVP(message.AVP_ACCT_INTERIM_INTERVAL); avp != nil {
+		if seconds, err := avp.Uint32(); err == nil {
+			s.interval = s.client.limitInterim(time.Duration(seconds) * time.Second)
+		}
+	}
+	s.schedule()
+}
+
+// schedule restarts the interim timer for the current interval. Callers
+// must hold s.mu.
+func (s *AccountingSession) schedule() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if s.stopped || s.interval <= 0 {
+		return
+	}
+	s.timer = s.client.clock.AfterFunc(s.client.interimDelay(s.interval), s.interim)
+}
+
+// newACR builds the ACR of recordType with the Accounting-Record-Number
+// number.
+func (s *AccountingSession) newACR(recordType, number uint32) (*message.DiameterMessage, error) {
+	realm := s.opts.DestinationRealm
+	if realm == "" {
+		realm = s.client.PeerIdentity().Realm
+	}
+	var b message.MessageBuilder
+	b.Add(message.AVP_SESSION_ID, s.id, message.MANDATORY_FLAG).
+		AddAVPs(s.client.node().IdentityAVPs()).
+		Add(message.AVP_DESTINATION_REALM, realm, message.MANDATORY_FLAG).
+		Add(message.AVP_EVENT_TIMESTAMP, s.client.clock.Now(), message.MANDATORY_FLAG).
+		Add(message.AVP_ACCOUNTING_RECORD_TYPE, recordType, message.MANDATORY_FLAG).
+		Add(message.AVP_ACCOUNTING_RECORD_NUMBER, number, message.MANDATORY_FLAG).
+		Add(message.AVP_ACCT_APPLICATION_ID, message.APPLICATION_ID_BASE_ACCOUNTING, message.MANDATORY_FLAG)
+	s.mu.Lock()
+	class := s.class
+	s.mu.Unlock()
+	for _, value := range class {
+		b.Add(message.AVP_CLASS, string(value), message.MANDATORY_FLAG)
+	}
+	if s.opts.AVPs != nil {
+		b.AddAVP(s.opts.AVPs(recordType)...)
+	}
+	avps, err := b.AVPs()
+	if err != nil {
+		return nil, err
+	}
+	return s.client.NewRequest(message.COMMAND_CODE_ACCOUNTING, message.WithAVPs(avps...))
+}
+
+// limitInterim applies the limits of WithInterimLimits to a non-zero
+// interim interval.
+func (c *Client) limitInterim(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+	if interval < c.interimFloor {
+		interval = c.interimFloor
+	}
+	if c.interimCap > 0 && interval > c.interimCap {
+		interval = c.interimCap
+	}
+	return interval
+}
+
+// interimDelay returns the delay before the next interim record: interval
+// less a random jitter, so that the record is never later than the
+// interval the server asked for.
+func (c *Client) interimDelay(interval time.Duration) time.Duration {
+	jitter := min(c.interimJitter, interval/2)
+	if jitter <= 0 {
+		return interval
+	}
+	return interval - time.Duration(rand.Int64N(int64(jitter)+1))
+}
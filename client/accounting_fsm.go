@@ -0,0 +1,281 @@
+// Accounting client state machine (RFC 6733 section 8.2)
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	fsm "github.com/IbrahimShahzad/diameter/state"
+)
+
+const (
+	// Accounting session states
+	_ fsm.State = iota
+	AccountingIdle
+	AccountingPendingS
+	AccountingPendingI
+	AccountingPendingE
+	AccountingPendingL
+	AccountingOpen
+)
+
+const (
+	// Accounting session events
+	_ fsm.Event = iota
+	acctEventStart
+	acctEventEvent
+	acctEventInterim
+	acctEventStop
+	// acctEventAnswered is a successful ACA.
+	acctEventAnswered
+	// acctEventRejected is an ACA with an error result.
+	acctEventRejected
+	// acctEventUndelivered is a record that could not be delivered and
+	// denies the access, under DELIVER_AND_GRANT.
+	acctEventUndelivered
+	// acctEventStored is a record that could not be delivered and was
+	// stored or lost, granting the access.
+	acctEventStored
+)
+
+// RealtimePolicy is the Accounting-Realtime-Required of a session: what
+// becomes of the service when its records cannot be delivered.
+type RealtimePolicy uint32
+
+const (
+	// DeliverAndGrant grants the service only while records are
+	// delivered. It is the default.
+	DeliverAndGrant RealtimePolicy = 1
+	// GrantAndStore grants the service and stores undelivered records
+	// for later delivery.
+	GrantAndStore RealtimePolicy = 2
+	// GrantAndLose grants the service and drops undelivered records.
+	GrantAndLose RealtimePolicy = 3
+)
+
+func (p RealtimePolicy) String() string {
+	switch p {
+	case DeliverAndGrant:
+		return "DELIVER_AND_GRANT"
+	case GrantAndStore:
+		return "GRANT_AND_STORE"
+	case GrantAndLose:
+		return "GRANT_AND_LOSE"
+	}
+	return fmt.Sprintf("RealtimePolicy(%d)", uint32(p))
+}
+
+// ErrRecordBufferFull is returned by RecordBuffer.Put when no more
+// records can be stored.
+var ErrRecordBufferFull = errors.New("accounting record buffer full")
+
+// RecordBuffer stores the accounting records that could not be delivered
+// under GRANT_AND_STORE until the peer answers again. Implementations may
+// persist the records, for instance encoded, to survive restarts; they
+// must be safe for concurrent use.
+type RecordBuffer interface {
+	// Put stores record after the records already stored, or returns
+	// ErrRecordBufferFull.
+	Put(record *message.DiameterMessage) error
+	// Peek returns the oldest record without removing it.
+	Peek() (*message.DiameterMessage, bool)
+	// Drop removes the oldest record.
+	Drop()
+	// Len returns the number of records stored.
+	Len() int
+}
+
+// DefaultRecordBufferSize is the capacity of the record buffer of a
+// client created without WithRecordBuffer.
+const DefaultRecordBufferSize = 1024
+
+// storedRecordTimeout bounds the wait for the answer to a stored record.
+const storedRecordTimeout = 30 * time.Second
+
+// memoryRecordBuffer is a bounded in-memory RecordBuffer.
+type memoryRecordBuffer struct {
+	mu      sync.Mutex
+	size    int
+	records []*message.DiameterMessage
+}
+
+// NewMemoryRecordBuffer returns a RecordBuffer keeping up to size records
+// in memory.
+func NewMemoryRecordBuffer(size int) RecordBuffer {
+	return &memoryRecordBuffer{size: size}
+}
+
+func (b *memoryRecordBuffer) Put(record *message.DiameterMessage) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.records) >= b.size {
+		return ErrRecordBufferFull
+	}
+	b.records = append(b.records, record)
+	return nil
+}
+
+func (b *memoryRecordBuffer) Peek() (*message.DiameterMessage, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.records) == 0 {
+		return nil, false
+	}
+	return b.records[0], true
+}
+
+func (b *memoryRecordBuffer) Drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.records) > 0 {
+		b.records[0] = nil
+		b.records = b.records[1:]
+	}
+}
+
+func (b *memoryRecordBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.records)
+}
+
+// initFSM sets up the client accounting state machine of RFC 6733 section
+// 8.2. The records are sent outside the state machine, whose transitions
+// have no actions: the outcome of each record is fed back as an event,
+// already resolved against the realtime policy.
+func (s *AccountingSession) initFSM() {
+	s.fsm = fsm.NewFSM(AccountingIdle)
+	s.fsm.SetClock(s.client.clock)
+
+	// State: Idle
+	s.fsm.AddTransition(AccountingIdle, AccountingPendingS, acctEventStart, nil)
+	s.fsm.AddTransition(AccountingIdle, AccountingPendingE, acctEventEvent, nil)
+
+	// State: PendingS
+	s.fsm.AddTransition(AccountingPendingS, AccountingOpen, acctEventAnswered, nil)
+	s.fsm.AddTransition(AccountingPendingS, AccountingOpen, acctEventStored, nil)
+	s.fsm.AddTransition(AccountingPendingS, AccountingIdle, acctEventRejected, nil)
+	s.fsm.AddTransition(AccountingPendingS, AccountingIdle, acctEventUndelivered, nil)
+
+	// State: Open
+	s.fsm.AddTransition(AccountingOpen, AccountingPendingI, acctEventInterim, nil)
+	s.fsm.AddTransition(AccountingOpen, AccountingPendingL, acctEventStop, nil)
+
+	// State: PendingI
+	s.fsm.AddTransition(AccountingPendingI, AccountingOpen, acctEventAnswered, nil)
+	s.fsm.AddTransition(AccountingPendingI, AccountingOpen, acctEventStored, nil)
+	s.fsm.AddTransition(AccountingPendingI, AccountingIdle, acctEventRejected, nil)
+	s.fsm.AddTransition(AccountingPendingI, AccountingIdle, acctEventUndelivered, nil)
+	// A stop requested while an interim record is pending.
+	s.fsm.AddTransition(AccountingPendingI, AccountingPendingL, acctEventStop, nil)
+
+	// State: PendingE and PendingL end in Idle whatever the outcome.
+	for _, state := range []fsm.State{AccountingPendingE, AccountingPendingL} {
+		for _, event := range []fsm.Event{acctEventAnswered, acctEventStored, acctEventRejected, acctEventUndelivered} {
+			s.fsm.AddTransition(state, AccountingIdle, event, nil)
+		}
+	}
+}
+
+// State returns the state of the session in the accounting state machine.
+func (s *AccountingSession) State() fsm.State {
+	return s.fsm.GetState()
+}
+
+// record sends the ACR of recordType, already announced to the state
+// machine, and feeds the outcome back. An undelivered record is stored or
+// dropped according to the realtime policy; the error returned is nil
+// when the service is granted anyway.
+func (s *AccountingSession) record(ctx context.Context, recordType uint32) (*message.DiameterMessage, error) {
+	s.mu.Lock()
+	number := s.next()
+	policy := s.policy
+	s.mu.Unlock()
+	req, err := s.newACR(recordType, number)
+	if err != nil {
+		s.trigger(acctEventRejected)
+		return nil, err
+	}
+	ans, err := s.client.deliverACR(ctx, req)
+	var protocolErr *message.ProtocolError
+	switch {
+	case err == nil:
+		s.trigger(acctEventAnswered)
+		s.client.flushRecords()
+		return ans, nil
+	case errors.As(err, &protocolErr):
+		s.trigger(acctEventRejected)
+		return nil, err
+	case policy == GrantAndStore:
+		if putErr := s.client.recordBuffer.Put(req); putErr != nil {
+			log.Printf("Dropping record %d of session %s: %v", number, s.id, putErr)
+		}
+	case policy == GrantAndLose:
+		log.Printf("Dropping record %d of session %s: %v", number, s.id, err)
+	default:
+		s.trigger(acctEventUndelivered)
+		return nil, err
+	}
+	s.trigger(acctEventStored)
+	return nil, nil
+}
+
+func (s *AccountingSession) trigger(event fsm.Event) {
+	if err := s.fsm.Trigger(event); err != nil {
+		log.Printf("Error handling accounting event %d of session %s: %v", event, s.id, err)
+	}
+}
+
+// deliverACR sends req and checks that it was answered with success.
+func (c *Client) deliverACR(ctx context.Context, req *message.DiameterMessage) (*message.DiameterMessage, error) {
+	ans, err := c.Request(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	result, err := message.GetResult(ans)
+	if err != nil {
+		return nil, err
+	}
+	if result.Class() != message.ResultClassSuccess {
+		return nil, &message.ProtocolError{ResultCode: result.Code}
+	}
+	return ans, nil
+}
+
+// flushRecords retransmits the stored records, oldest first and with the
+// T flag set, on a goroutine of its own. It stops at the first record
+// that is not delivered; records rejected by the peer are dropped.
+func (c *Client) flushRecords() {
+	if c.recordBuffer.Len() == 0 || !c.flushing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer c.flushing.Store(false)
+		for {
+			record, ok := c.recordBuffer.Peek()
+			if !ok {
+				return
+			}
+			retry := record.Clone()
+			retry.Header.CommandFlags = retry.Header.CommandFlags.With(message.FlagRetransmitted)
+			ctx, cancel := context.WithTimeout(context.Background(), storedRecordTimeout)
+			_, err := c.deliverACR(ctx, retry)
+			cancel()
+			var protocolErr *message.ProtocolError
+			switch {
+			case err == nil:
+			case errors.As(err, &protocolErr):
+				log.Printf("Stored accounting record rejected: %v", err)
+			default:
+				log.Printf("Error sending stored accounting record: %v", err)
+				return
+			}
+			c.recordBuffer.Drop()
+		}
+	}()
+}
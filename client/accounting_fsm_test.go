@@ -0,0 +1,288 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+	"github.com/IbrahimShahzad/diameter/message"
+	fsm "github.com/IbrahimShahzad/diameter/state"
+)
+
+// unreachableInterval is the interim interval of the tests of the state
+// machine. An interim record waits for its answer as long as the interval,
+// in real time, so it is kept short.
+const unreachableInterval = 100 * time.Millisecond
+
+// serveUnreachable is serveAccounting with a peer that stops answering
+// ACRs while down is set, as a server that cannot be reached would. The
+// ACRs are still sent on the returned channel.
+func serveUnreachable(peer *testPeer, conn net.Conn, down *atomic.Bool, avps func(acr *message.DiameterMessage) []*message.AVP) <-chan *message.DiameterMessage {
+	acrs := make(chan *message.DiameterMessage, 16)
+	go func() {
+		defer close(acrs)
+		for {
+			req, err := readTestMessage(conn)
+			if err != nil {
+				return
+			}
+			var ans *message.DiameterMessage
+			switch req.Header.CommandCode {
+			case message.COMMAND_CODE_DWR:
+				ans, err = peer.node.BuildDWA(req)
+			case message.COMMAND_CODE_ACCOUNTING:
+				acrs <- req
+				if down.Load() {
+					continue
+				}
+				ans, err = peer.node.BuildAnswer(req, message.DIAMETER_SUCCESS)
+				if err == nil && avps != nil {
+					ans.AVPs = append(ans.AVPs, avps(req)...)
+				}
+			default:
+				continue
+			}
+			if err != nil {
+				return
+			}
+			data, err := ans.Encode()
+			if err != nil {
+				return
+			}
+			if _, err := conn.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+	return acrs
+}
+
+// recordNumber returns the Accounting-Record-Number of acr.
+func recordNumber(t *testing.T, acr *message.DiameterMessage) uint32 {
+	t.Helper()
+	value, err := acr.GetAVP(message.AVP_ACCOUNTING_RECORD_NUMBER).Uint32()
+	if err != nil {
+		t.Fatalf("Accounting-Record-Number: %v", err)
+	}
+	return value
+}
+
+// checkState checks that s is in state want.
+func checkState(t *testing.T, s *AccountingSession, want fsm.State, when string) {
+	t.Helper()
+	if got := s.State(); got != want {
+		t.Errorf("%s: state %v, want %v", when, got, want)
+	}
+}
+
+// startUnreachable starts an accounting session of a client whose peer
+// can be made unreachable with the returned flag.
+func startUnreachable(t *testing.T, opts AccountingOptions, clientOpts ...ClientOptionsFunc) (*Client, *AccountingSession, *fakeclock.Clock, *atomic.Bool, <-chan *message.DiameterMessage) {
+	t.Helper()
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), append([]ClientOptionsFunc{WithClock(clk), WithWatchdogTTL(watchdogOff)}, clientOpts...)...)
+	down := new(atomic.Bool)
+	acrs := serveUnreachable(peer, peer.connect(c), down, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	opts.Interval = unreachableInterval
+	s, err := c.StartAccounting(ctx, "client.example.com;1;acct", opts)
+	if err != nil {
+		t.Fatalf("StartAccounting: %v", err)
+	}
+	nextRecord(t, acrs)
+	checkState(t, s, AccountingOpen, "after START")
+	return c, s, clk, down, acrs
+}
+
+// TestAccountingGrantAndStore makes the server unreachable for an interim
+// record, which is stored while the session stays open, and checks that
+// it is retransmitted with the T flag once the server answers again.
+func TestAccountingGrantAndStore(t *testing.T) {
+	c, s, clk, down, acrs := startUnreachable(t, AccountingOptions{Realtime: GrantAndStore})
+
+	down.Store(true)
+	clk.Advance(unreachableInterval)
+	lost := nextRecord(t, acrs)
+	if got := recordType(t, lost); got != message.INTERIM_RECORD {
+		t.Fatalf("record of type %d, want INTERIM_RECORD", got)
+	}
+	checkState(t, s, AccountingOpen, "after an undelivered interim")
+	if n := c.recordBuffer.Len(); n != 1 {
+		t.Fatalf("%d records stored, want 1", n)
+	}
+
+	down.Store(false)
+	clk.Advance(unreachableInterval)
+	if got := nextRecord(t, acrs); recordNumber(t, got) != 2 || got.Header.CommandFlags.Retransmitted() {
+		t.Errorf("record %d sent with flags %v, want the next interim", recordNumber(t, got), got.Header.CommandFlags)
+	}
+	retry := nextRecord(t, acrs)
+	if recordNumber(t, retry) != recordNumber(t, lost) || !retry.Header.CommandFlags.Retransmitted() {
+		t.Errorf("record %d sent with flags %v, want record %d retransmitted", recordNumber(t, retry), retry.Header.CommandFlags, recordNumber(t, lost))
+	}
+	eventually(t, "the stored record to be dropped", func() bool { return c.recordBuffer.Len() == 0 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := s.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	checkState(t, s, AccountingIdle, "after STOP")
+}
+
+// TestAccountingDeliverAndGrant checks that an interim record that cannot
+// be delivered ends the session under the default policy, and that a
+// START that cannot be delivered fails.
+func TestAccountingDeliverAndGrant(t *testing.T) {
+	disconnected := make(chan error, 1)
+	c, s, clk, down, acrs := startUnreachable(t, AccountingOptions{OnDisconnect: func(err error) { disconnected <- err }})
+
+	down.Store(true)
+	clk.Advance(unreachableInterval)
+	nextRecord(t, acrs)
+	checkState(t, s, AccountingIdle, "after an undelivered interim")
+	select {
+	case err := <-disconnected:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("OnDisconnect(%v), want the interim timed out", err)
+		}
+	default:
+		t.Error("OnDisconnect not called")
+	}
+	if n := c.recordBuffer.Len(); n != 0 {
+		t.Errorf("%d records stored", n)
+	}
+	clk.Advance(time.Hour)
+	noRecord(t, acrs, "after the session ended")
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := s.Stop(ctx); err != ErrAccountingStopped {
+		t.Errorf("Stop = %v, want ErrAccountingStopped", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), unreachableInterval)
+	defer cancel()
+	if _, err := c.StartAccounting(ctx, "client.example.com;1;other", AccountingOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("StartAccounting with the server unreachable = %v", err)
+	}
+}
+
+// TestAccountingGrantAndLose checks that the Accounting-Realtime-Required
+// of the START ACA overrides the policy of the session, here to drop the
+// records that cannot be delivered and carry on.
+func TestAccountingGrantAndLose(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithClock(clk), WithWatchdogTTL(watchdogOff))
+	down := new(atomic.Bool)
+	acrs := serveUnreachable(peer, peer.connect(c), down, func(acr *message.DiameterMessage) []*message.AVP {
+		if value, _ := acr.GetAVP(message.AVP_ACCOUNTING_RECORD_TYPE).Uint32(); value != message.START_RECORD {
+			return nil
+		}
+		return []*message.AVP{message.MustNewAVP(message.AVP_ACCOUNTING_REALTIME_REQUIRED, uint32(GrantAndLose), message.MANDATORY_FLAG)}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	s, err := c.StartAccounting(ctx, "client.example.com;1;acct", AccountingOptions{Interval: unreachableInterval})
+	if err != nil {
+		t.Fatalf("StartAccounting: %v", err)
+	}
+	nextRecord(t, acrs)
+
+	down.Store(true)
+	clk.Advance(unreachableInterval)
+	nextRecord(t, acrs)
+	checkState(t, s, AccountingOpen, "after a lost interim")
+	if n := c.recordBuffer.Len(); n != 0 {
+		t.Errorf("%d records stored under GRANT_AND_LOSE", n)
+	}
+	// The cadence is kept.
+	clk.Advance(unreachableInterval)
+	if got := recordType(t, nextRecord(t, acrs)); got != message.INTERIM_RECORD {
+		t.Errorf("record of type %d, want INTERIM_RECORD", got)
+	}
+}
+
+// TestAccountingRejected checks that an ACA with an error result ends the
+// session in Idle, whatever the policy.
+func TestAccountingRejected(t *testing.T) {
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithWatchdogTTL(watchdogOff))
+	conn := peer.connect(c)
+	go func() {
+		acr, err := readTestMessage(conn)
+		if err != nil {
+			return
+		}
+		ans, err := peer.node.BuildAnswer(acr, message.DIAMETER_UNABLE_TO_COMPLY)
+		if err != nil {
+			return
+		}
+		if data, err := ans.Encode(); err == nil {
+			conn.Write(data)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	_, err := c.StartAccounting(ctx, "client.example.com;1;acct", AccountingOptions{Realtime: GrantAndStore})
+	var protocolErr *message.ProtocolError
+	if !errors.As(err, &protocolErr) || protocolErr.ResultCode != message.DIAMETER_UNABLE_TO_COMPLY {
+		t.Errorf("StartAccounting = %v, want DIAMETER_UNABLE_TO_COMPLY", err)
+	}
+	if n := c.recordBuffer.Len(); n != 0 {
+		t.Errorf("%d rejected records stored", n)
+	}
+}
+
+// TestAccountingStoreFull checks that records past the capacity of the
+// buffer are dropped, the service being granted anyway.
+func TestAccountingStoreFull(t *testing.T) {
+	c, s, clk, down, acrs := startUnreachable(t, AccountingOptions{Realtime: GrantAndStore}, WithRecordBuffer(NewMemoryRecordBuffer(1)))
+	down.Store(true)
+	for range 2 {
+		clk.Advance(unreachableInterval)
+		nextRecord(t, acrs)
+	}
+	checkState(t, s, AccountingOpen, "with the buffer full")
+	if n := c.recordBuffer.Len(); n != 1 {
+		t.Errorf("%d records stored, want 1", n)
+	}
+	if record, ok := c.recordBuffer.Peek(); !ok || recordNumber(t, record) != 1 {
+		t.Errorf("stored record %v, want the first interim", record)
+	}
+}
+
+func TestMemoryRecordBuffer(t *testing.T) {
+	b := NewMemoryRecordBuffer(2)
+	records := make([]*message.DiameterMessage, 3)
+	for i := range records {
+		records[i] = &message.DiameterMessage{Header: &message.DiameterHeader{HopByHopID: uint32(i)}}
+	}
+	if _, ok := b.Peek(); ok {
+		t.Error("Peek of an empty buffer found a record")
+	}
+	b.Drop()
+	for _, record := range records[:2] {
+		if err := b.Put(record); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	if err := b.Put(records[2]); err != ErrRecordBufferFull {
+		t.Errorf("Put past the capacity = %v, want ErrRecordBufferFull", err)
+	}
+	for _, want := range records[:2] {
+		if got, ok := b.Peek(); !ok || got != want {
+			t.Fatalf("Peek = %v, %v; want record %d", got, ok, want.Header.HopByHopID)
+		}
+		b.Drop()
+	}
+	if n := b.Len(); n != 0 {
+		t.Errorf("Len = %d after dropping every record", n)
+	}
+}
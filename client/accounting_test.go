@@ -0,0 +1,256 @@
+package client
+
+import (
+	"context"
+	"net"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+// watchdogOff is a watchdog TTL long enough for the fake clock of the
+// accounting tests never to reach it, so that no DWR is left unanswered
+// while the clock jumps.
+const watchdogOff = 24 * time.Hour
+
+// serveAccounting answers the DWRs and ACRs read from conn until reading
+// fails, each ACA carrying the AVPs avps returns for its ACR. The ACRs are
+// sent on the returned channel before they are answered.
+func serveAccounting(peer *testPeer, conn net.Conn, avps func(acr *message.DiameterMessage) []*message.AVP) <-chan *message.DiameterMessage {
+	return serveUnreachable(peer, conn, new(atomic.Bool), avps)
+}
+
+// recordType returns the Accounting-Record-Type of acr.
+func recordType(t *testing.T, acr *message.DiameterMessage) uint32 {
+	t.Helper()
+	value, err := acr.GetAVP(message.AVP_ACCOUNTING_RECORD_TYPE).Uint32()
+	if err != nil {
+		t.Fatalf("Accounting-Record-Type: %v", err)
+	}
+	return value
+}
+
+// nextRecord returns the next ACR read by serveAccounting.
+func nextRecord(t *testing.T, acrs <-chan *message.DiameterMessage) *message.DiameterMessage {
+	t.Helper()
+	select {
+	case acr, ok := <-acrs:
+		if !ok {
+			t.Fatal("connection closed")
+		}
+		return acr
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for an ACR")
+	}
+	return nil
+}
+
+// noRecord checks that no ACR was read. The interim timer runs on the
+// goroutine advancing the fake clock and waits for the answer, so any
+// record due has been read once Advance returns.
+func noRecord(t *testing.T, acrs <-chan *message.DiameterMessage, when string) {
+	t.Helper()
+	select {
+	case acr := <-acrs:
+		t.Errorf("%s: record of type %d sent", when, recordType(t, acr))
+	default:
+	}
+}
+
+// TestInterimCadence checks that the interim records follow the
+// Acct-Interim-Interval of the latest ACA as the server lowers and raises
+// it, and that 0 stops them.
+func TestInterimCadence(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithClock(clk), WithWatchdogTTL(watchdogOff))
+	var interval atomic.Uint32
+	interval.Store(60)
+	acrs := serveAccounting(peer, peer.connect(c), func(*message.DiameterMessage) []*message.AVP {
+		return []*message.AVP{message.MustNewAVP(message.AVP_ACCT_INTERIM_INTERVAL, interval.Load(), message.MANDATORY_FLAG)}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	s, err := c.StartAccounting(ctx, "client.example.com;1;acct", AccountingOptions{Interval: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("StartAccounting: %v", err)
+	}
+	if got := recordType(t, nextRecord(t, acrs)); got != message.START_RECORD {
+		t.Fatalf("first record of type %d, want START_RECORD", got)
+	}
+	if got := s.Interval(); got != time.Minute {
+		t.Fatalf("interval %v after the START ACA, want 1m", got)
+	}
+
+	for _, step := range []struct {
+		// next is the interval the ACA of the interim asks for.
+		next uint32
+		want time.Duration
+	}{
+		{20, time.Minute},
+		{120, 20 * time.Second},
+		{0, 2 * time.Minute},
+	} {
+		interval.Store(step.next)
+		clk.Advance(step.want - time.Second)
+		noRecord(t, acrs, "before the interval")
+		clk.Advance(time.Second)
+		if got := recordType(t, nextRecord(t, acrs)); got != message.INTERIM_RECORD {
+			t.Fatalf("record of type %d after %v, want INTERIM_RECORD", got, step.want)
+		}
+		if got, want := s.Interval(), time.Duration(step.next)*time.Second; got != want {
+			t.Errorf("interval %v after an ACA asking for %ds, want %v", got, step.next, want)
+		}
+	}
+	clk.Advance(time.Hour)
+	noRecord(t, acrs, "after an interval of 0")
+}
+
+// TestInterimStop checks that no interim record is sent after STOP.
+func TestInterimStop(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithClock(clk), WithWatchdogTTL(watchdogOff))
+	acrs := serveAccounting(peer, peer.connect(c), nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	s, err := c.StartAccounting(ctx, "client.example.com;1;acct", AccountingOptions{Interval: time.Minute})
+	if err != nil {
+		t.Fatalf("StartAccounting: %v", err)
+	}
+	nextRecord(t, acrs)
+	clk.Advance(time.Minute)
+	if got := recordType(t, nextRecord(t, acrs)); got != message.INTERIM_RECORD {
+		t.Fatalf("record of type %d, want INTERIM_RECORD", got)
+	}
+	if err := s.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if got := recordType(t, nextRecord(t, acrs)); got != message.STOP_RECORD {
+		t.Fatalf("record of type %d, want STOP_RECORD", got)
+	}
+	clk.Advance(time.Hour)
+	noRecord(t, acrs, "after STOP")
+	if err := s.Stop(ctx); err != ErrAccountingStopped {
+		t.Errorf("second Stop = %v, want ErrAccountingStopped", err)
+	}
+}
+
+// classes returns the Class values of acr.
+func classes(t *testing.T, acr *message.DiameterMessage) []string {
+	t.Helper()
+	var values []string
+	for _, avp := range acr.AVPs {
+		if avp.Code != message.AVP_CLASS {
+			continue
+		}
+		value, err := avp.Bytes()
+		if err != nil {
+			t.Fatalf("Class: %v", err)
+		}
+		values = append(values, string(value))
+	}
+	return values
+}
+
+// TestClassEcho checks that the records of a session echo the Class values
+// of the authorization answer until an ACA carries its own, which then
+// replace them, and that every record carries the Event-Timestamp of the
+// time it was sent.
+func TestClassEcho(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithClock(clk), WithWatchdogTTL(watchdogOff))
+	// answered maps the Accounting-Record-Number of a record to the Class
+	// values of its ACA.
+	answered := map[uint32][]string{0: {"a", "b"}, 2: {"c"}}
+	acrs := serveAccounting(peer, peer.connect(c), func(acr *message.DiameterMessage) []*message.AVP {
+		number, _ := acr.GetAVP(message.AVP_ACCOUNTING_RECORD_NUMBER).Uint32()
+		var avps []*message.AVP
+		for _, value := range answered[number] {
+			avps = append(avps, message.MustNewAVP(message.AVP_CLASS, value, message.MANDATORY_FLAG))
+		}
+		return avps
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	s, err := c.StartAccounting(ctx, "client.example.com;1;acct", AccountingOptions{
+		Interval: time.Minute,
+		Class:    [][]byte{[]byte("auth")},
+	})
+	if err != nil {
+		t.Fatalf("StartAccounting: %v", err)
+	}
+	check := func(acr *message.DiameterMessage, want uint32, class []string) {
+		t.Helper()
+		if got := recordType(t, acr); got != want {
+			t.Fatalf("record of type %d, want %d", got, want)
+		}
+		if got := classes(t, acr); !slices.Equal(got, class) {
+			t.Errorf("record of type %d carries Class %q, want %q", want, got, class)
+		}
+		if got, err := acr.GetAVP(message.AVP_EVENT_TIMESTAMP).Time(); err != nil || !got.Equal(clk.Now()) {
+			t.Errorf("record of type %d has Event-Timestamp %v, %v; want %v", want, got, err, clk.Now())
+		}
+	}
+	check(nextRecord(t, acrs), message.START_RECORD, []string{"auth"})
+	clk.Advance(time.Minute)
+	check(nextRecord(t, acrs), message.INTERIM_RECORD, []string{"a", "b"})
+	// The ACA of the first interim carries no Class, which keeps a and b.
+	clk.Advance(time.Minute)
+	check(nextRecord(t, acrs), message.INTERIM_RECORD, []string{"a", "b"})
+	if err := s.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	check(nextRecord(t, acrs), message.STOP_RECORD, []string{"c"})
+}
+
+func TestInterimLimits(t *testing.T) {
+	c := newTestClient(t, "127.0.0.1:3868", WithInterimLimits(30*time.Second, 5*time.Minute))
+	for _, tc := range []struct {
+		interval, want time.Duration
+	}{
+		{0, 0},
+		{10 * time.Second, 30 * time.Second},
+		{time.Minute, time.Minute},
+		{time.Hour, 5 * time.Minute},
+	} {
+		if got := c.limitInterim(tc.interval); got != tc.want {
+			t.Errorf("limitInterim(%v) = %v, want %v", tc.interval, got, tc.want)
+		}
+	}
+}
+
+// TestInterimJitter checks that interims are sent early by at most the
+// jitter, capped at half the interval, and never late.
+func TestInterimJitter(t *testing.T) {
+	c := newTestClient(t, "127.0.0.1:3868", WithInterimJitter(10*time.Second))
+	for _, tc := range []struct {
+		interval, earliest time.Duration
+	}{
+		{time.Minute, 50 * time.Second},
+		{4 * time.Second, 2 * time.Second},
+	} {
+		seen := make(map[time.Duration]bool)
+		for range 1000 {
+			d := c.interimDelay(tc.interval)
+			if d < tc.earliest || d > tc.interval {
+				t.Fatalf("interim after %v with an interval of %v, want between %v and %v", d, tc.interval, tc.earliest, tc.interval)
+			}
+			seen[d] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("interval of %v always delayed by %v", tc.interval, c.interimDelay(tc.interval))
+		}
+	}
+	if d := newTestClient(t, "127.0.0.1:3868").interimDelay(time.Minute); d != time.Minute {
+		t.Errorf("interim after %v without jitter, want 1m", d)
+	}
+}
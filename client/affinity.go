@@ -0,0 +1,161 @@
+// Session affinity of a Pool
+package client
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+// FailoverPolicy decides what becomes of a session bound to a peer that is
+// no longer available.
+type FailoverPolicy int
+
+const (
+	// RebindOnFailover binds the session to the next available peer and
+	// carries on.
+	RebindOnFailover FailoverPolicy = iota
+	// TerminateOnFailover ends the session with an STR sent to the next
+	// available peer and fails the request with ErrSessionTerminated.
+	TerminateOnFailover
+)
+
+func (p FailoverPolicy) String() string {
+	switch p {
+	case RebindOnFailover:
+		return "rebind"
+	case TerminateOnFailover:
+		return "terminate"
+	}
+	return fmt.Sprintf("FailoverPolicy(%d)", int(p))
+}
+
+// affinity binds sessions to the identity of the peer they were first
+// sent to.
+type affinity struct {
+	policy   FailoverPolicy
+	mu       sync.Mutex
+	sessions map[string]message.PeerIdentity
+}
+
+// SetSessionAffinity makes every request carrying a Session-Id go to the
+// peer the session was first sent to, while requests without one keep
+// following the failover order. When that peer is no longer available the
+// session is rebound or terminated according to policy. An STR ends the
+// binding of its session.
+func (p *Pool) SetSessionAffinity(policy FailoverPolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.affinity = &affinity{policy: policy, sessions: make(map[string]message.PeerIdentity)}
+}
+
+// SessionPeer returns the identity of the peer sessionID is bound to.
+func (p *Pool) SessionPeer(sessionID string) (message.PeerIdentity, bool) {
+	a := p.getAffinity()
+	if a == nil {
+		return message.PeerIdentity{}, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	id, ok := a.sessions[sessionID]
+	return id, ok
+}
+
+// Unbind forgets the peer sessionID is bound to, for sessions that end
+// without an STR.
+func (p *Pool) Unbind(sessionID string) {
+	if a := p.getAffinity(); a != nil {
+		a.mu.Lock()
+		delete(a.sessions, sessionID)
+		a.mu.Unlock()
+	}
+}
+
+func (p *Pool) getAffinity() *affinity {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.affinity
+}
+
+// pickSession returns the peer msg, a request of sessionID, is to be sent
+// to, binding the session on its first request.
+func (p *Pool) pickSession(a *affinity, sessionID string, msg *message.DiameterMessage) (*Client, error) {
+	c, bound, err := a.route(p, sessionID, msg)
+	if err != nil || bound.IsZero() {
+		return c, err
+	}
+	log.Printf("Peer %s of session %s is unavailable, terminating the session.", bound, sessionID)
+	if err := c.terminateSession(sessionID, msg); err != nil {
+		log.Printf("Error sending STR for session %s: %v", sessionID, err)
+	}
+	return nil, fmt.Errorf("%w: %s was bound to %s", ErrSessionTerminated, sessionID, bound)
+}
+
+// route picks the peer of sessionID and updates its binding. When the
+// session is to be terminated, it returns the peer to send the STR to and
+// the identity of the unavailable peer.
+func (a *affinity) route(p *Pool, sessionID string, msg *message.DiameterMessage) (*Client, message.PeerIdentity, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	bound, ok := a.sessions[sessionID]
+	if ok {
+		c, err := p.pick(func(c *Client) bool { return c.PeerIdentity() == bound && c.Supports(msg) })
+		if err == nil {
+			a.release(sessionID, msg)
+			return c, message.PeerIdentity{}, nil
+		}
+	}
+	c, err := p.PickFor(msg)
+	if err != nil {
+		return nil, message.PeerIdentity{}, err
+	}
+	// An STR is delivered through the new peer rather than terminating
+	// the session a second time.
+	if ok && a.policy == TerminateOnFailover && msg.Header.CommandCode != message.COMMAND_CODE_SESSION_TERMINATION {
+		delete(a.sessions, sessionID)
+		return c, bound, nil
+	}
+	if ok {
+		log.Printf("Peer %s of session %s is unavailable, rebinding it to %s.", bound, sessionID, c.PeerIdentity())
+	}
+	a.sessions[sessionID] = c.PeerIdentity()
+	a.release(sessionID, msg)
+	return c, message.PeerIdentity{}, nil
+}
+
+// release ends the binding of sessionID when msg is its STR. Callers must
+// hold a.mu.
+func (a *affinity) release(sessionID string, msg *message.DiameterMessage) {
+	if msg.Header.CommandCode == message.COMMAND_CODE_SESSION_TERMINATION {
+		delete(a.sessions, sessionID)
+	}
+}
+
+// terminateSession sends an STR with DIAMETER_LINK_BROKEN for sessionID,
+// in the application of req.
+func (c *Client) terminateSession(sessionID string, req *message.DiameterMessage) error {
+	realm := c.PeerIdentity().Realm
+	if avp := req.GetAVP(message.AVP_DESTINATION_REALM); avp != nil {
+		if r, ok := avp.Data.(*message.DiameterIdentity); ok {
+			realm = r.Data
+		}
+	}
+	var b message.MessageBuilder
+	avps, err := b.Add(message.AVP_SESSION_ID, sessionID, message.MANDATORY_FLAG).
+		AddAVPs(c.node().IdentityAVPs()).
+		Add(message.AVP_DESTINATION_REALM, realm, message.MANDATORY_FLAG).
+		Add(message.AVP_AUTH_APPLICATION_ID, req.Header.ApplicationID, message.MANDATORY_FLAG).
+		Add(message.AVP_TERMINATION_CAUSE, message.DIAMETER_LINK_BROKEN, message.MANDATORY_FLAG).
+		AVPs()
+	if err != nil {
+		return err
+	}
+	str, err := c.NewRequest(message.COMMAND_CODE_SESSION_TERMINATION,
+		message.WithApplication(req.Header.ApplicationID), message.WithAVPs(avps...))
+	if err != nil {
+		return err
+	}
+	return c.SendMessage(str)
+}
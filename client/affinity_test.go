@@ -0,0 +1,210 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+// serveRecorded answers every request read from conn with DIAMETER_SUCCESS
+// until reading fails, sending those other than DWRs on the returned
+// channel first.
+func serveRecorded(peer *testPeer, conn net.Conn) <-chan *message.DiameterMessage {
+	reqs := make(chan *message.DiameterMessage, 16)
+	go func() {
+		defer close(reqs)
+		for {
+			req, err := readTestMessage(conn)
+			if err != nil {
+				return
+			}
+			var ans *message.DiameterMessage
+			if req.Header.CommandCode == message.COMMAND_CODE_DWR {
+				ans, err = peer.node.BuildDWA(req)
+			} else {
+				reqs <- req
+				ans, err = peer.node.BuildAnswer(req, message.DIAMETER_SUCCESS)
+			}
+			if err != nil {
+				return
+			}
+			data, err := ans.Encode()
+			if err != nil {
+				return
+			}
+			if _, err := conn.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+	return reqs
+}
+
+// nextRequest returns the next request read by serveRecorded.
+func nextRequest(t *testing.T, reqs <-chan *message.DiameterMessage) *message.DiameterMessage {
+	t.Helper()
+	select {
+	case req, ok := <-reqs:
+		if !ok {
+			t.Fatal("connection closed")
+		}
+		return req
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for a request")
+	}
+	return nil
+}
+
+// affinityPool is a Pool of two clients connected to peers with distinct
+// identities, with the requests each peer reads.
+type affinityPool struct {
+	pool         *Pool
+	c1, c2       *Client
+	peer1        *testPeer
+	conn1        net.Conn
+	reqs1, reqs2 <-chan *message.DiameterMessage
+}
+
+// newAffinityPool returns a Pool with session affinity under policy, the
+// first peer first. The clocks of the clients are fake so that the first
+// one does not reconnect on its own once its peer is killed.
+func newAffinityPool(t *testing.T, policy FailoverPolicy) *affinityPool {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	peer1, peer2 := newTestPeer(t), newTestPeer(t)
+	peer1.node.OriginHost, peer2.node.OriginHost = "peer1.example.com", "peer2.example.com"
+	a := &affinityPool{
+		c1:    newTestClient(t, peer1.addr(), WithClock(clk)),
+		c2:    newTestClient(t, peer2.addr(), WithClock(clk)),
+		peer1: peer1,
+	}
+	a.conn1 = peer1.connect(a.c1)
+	a.reqs1 = serveRecorded(peer1, a.conn1)
+	a.reqs2 = serveRecorded(peer2, peer2.connect(a.c2))
+	a.pool = NewPool(a.c1, a.c2)
+	a.pool.SetSessionAffinity(policy)
+	return a
+}
+
+// request sends req through the pool.
+func (a *affinityPool) request(req *message.DiameterMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	_, err := a.pool.Request(ctx, req)
+	return err
+}
+
+// kill closes the listener and the connection of the first peer and waits
+// for its client to report it down.
+func (a *affinityPool) kill(t *testing.T) {
+	t.Helper()
+	a.peer1.ln.Close()
+	a.conn1.Close()
+	eventually(t, "the first peer to be DOWN", func() bool { return a.c1.watchdog.Status() == WatchdogDown })
+}
+
+// newSessionless returns a CCR of c without a Session-Id, which the pool
+// routes in failover order whatever the affinity.
+func newSessionless(t *testing.T, c *Client) *message.DiameterMessage {
+	t.Helper()
+	req := newTestCCR(t, c, "client.example.com;1;none")
+	req.AVPs = req.AVPs[1:]
+	if _, err := message.GetSessionID(req); err == nil {
+		t.Fatal("request carries a Session-Id")
+	}
+	return req
+}
+
+// TestSessionAffinityRebind kills the peer a session is bound to and
+// checks that the session moves to the other peer, where it then stays.
+func TestSessionAffinityRebind(t *testing.T) {
+	a := newAffinityPool(t, RebindOnFailover)
+	const session = "client.example.com;1;bound"
+	peer1, peer2 := message.NewPeerIdentity("peer1.example.com", "example.com"), message.NewPeerIdentity("peer2.example.com", "example.com")
+
+	if err := a.request(newTestCCR(t, a.c1, session)); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	nextRequest(t, a.reqs1)
+	if id, ok := a.pool.SessionPeer(session); !ok || id != peer1 {
+		t.Fatalf("session bound to %v, %v; want %v", id, ok, peer1)
+	}
+	if err := a.request(newSessionless(t, a.c1)); err != nil {
+		t.Fatal(err)
+	}
+	nextRequest(t, a.reqs1)
+
+	a.kill(t)
+	if err := a.request(newTestCCR(t, a.c2, session)); err != nil {
+		t.Fatalf("request after the failover: %v", err)
+	}
+	if req := nextRequest(t, a.reqs2); req.Header.CommandCode != message.COMMAND_CODE_CREDIT_CONTROL {
+		t.Errorf("second peer got %s, want the CCR", req.CommandName())
+	}
+	if id, ok := a.pool.SessionPeer(session); !ok || id != peer2 {
+		t.Errorf("session rebound to %v, %v; want %v", id, ok, peer2)
+	}
+	if err := a.request(newSessionless(t, a.c2)); err != nil {
+		t.Fatal(err)
+	}
+	nextRequest(t, a.reqs2)
+
+	// The STR of the session ends the binding.
+	str, err := a.c2.NewRequest(message.COMMAND_CODE_SESSION_TERMINATION, message.WithAVPs(newTestCCR(t, a.c2, session).AVPs...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.request(str); err != nil {
+		t.Fatalf("STR: %v", err)
+	}
+	nextRequest(t, a.reqs2)
+	if id, ok := a.pool.SessionPeer(session); ok {
+		t.Errorf("session still bound to %v after its STR", id)
+	}
+}
+
+// TestSessionAffinityTerminate kills the peer a session is bound to and
+// checks that the next request of the session fails, the session being
+// ended with an STR through the other peer.
+func TestSessionAffinityTerminate(t *testing.T) {
+	a := newAffinityPool(t, TerminateOnFailover)
+	const session = "client.example.com;1;bound"
+	if err := a.request(newTestCCR(t, a.c1, session)); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	nextRequest(t, a.reqs1)
+
+	a.kill(t)
+	err := a.request(newTestCCR(t, a.c2, session))
+	if !errors.Is(err, ErrSessionTerminated) {
+		t.Fatalf("request after the failover = %v, want ErrSessionTerminated", err)
+	}
+	str := nextRequest(t, a.reqs2)
+	if str.Header.CommandCode != message.COMMAND_CODE_SESSION_TERMINATION {
+		t.Fatalf("second peer got %s, want the STR", str.CommandName())
+	}
+	if id, err := message.GetSessionID(str); err != nil || id != session {
+		t.Errorf("STR of session %q, %v; want %q", id, err, session)
+	}
+	if cause, err := str.GetAVP(message.AVP_TERMINATION_CAUSE).Uint32(); err != nil || cause != message.DIAMETER_LINK_BROKEN {
+		t.Errorf("STR Termination-Cause %d, %v; want DIAMETER_LINK_BROKEN", cause, err)
+	}
+	if str.Header.ApplicationID != message.APPLICATION_ID_CREDIT_CONTROL {
+		t.Errorf("STR in application %d, want that of the session", str.Header.ApplicationID)
+	}
+	if id, ok := a.pool.SessionPeer(session); ok {
+		t.Errorf("terminated session still bound to %v", id)
+	}
+
+	// Requests without a session are not affected.
+	if err := a.request(newSessionless(t, a.c2)); err != nil {
+		t.Fatalf("request without a session: %v", err)
+	}
+	if req := nextRequest(t, a.reqs2); req.Header.CommandCode != message.COMMAND_CODE_CREDIT_CONTROL {
+		t.Errorf("second peer got %s, want the CCR", req.CommandName())
+	}
+}
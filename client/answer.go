@@ -0,0 +1,81 @@
+// Inline answers to requests received from the peer
+package client
+
+import (
+	"log"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/transport"
+)
+
+// answer sends an answer to req on conn, carrying resultCode and the
+// client identity. Protocol errors are sent with the 'E' bit set.
+func (c *Client) answer(conn *transport.DiameterConnection, req *message.DiameterMessage, resultCode message.ResultCode) error {
+	ans, err := c.node().BuildAnswer(req, resultCode)
+	if err != nil {
+		return err
+	}
+	return c.writeTo(c.writerFor(conn), ans)
+}
+
+// OnInboundRequest registers fn to observe every request received from
+// the peer, whether or not the client handles its command, for instance
+// to mirror RARs and ASRs to an audit pipeline. fn is called with its own
+// clone of the request once the request has been answered, so it cannot
+// change how the client responds. Observers run on the read loop, in the
+// order they were registered, and must not block; a panicking observer is
+// logged and counted in PeerStatus.ObserverPanics.
+func (c *Client) OnInboundRequest(fn func(msg *message.DiameterMessage)) {
+	c.inboundRequests.Add(fn)
+}
+
+// handleRequest answers a request received from the peer on conn. It runs
+// on the read loop so that the answer goes out without waiting for any
+// outstanding request of our own.
+func (c *Client) handleRequest(conn *transport.DiameterConnection, req *message.DiameterMessage) {
+	defer c.inboundRequests.Notify(req)
+	switch req.Header.CommandCode {
+	case message.COMMAND_CODE_DWR:
+		log.Println("Sending Device-Watchdog-Answer (DWA) to server.")
+		if err := c.answer(conn, req, message.DIAMETER_SUCCESS); err != nil {
+			log.Printf("Error sending DWA: %v", err)
+		}
+	case message.COMMAND_CODE_DISCONNECT_PEER:
+		log.Println("Sending Disconnect-Peer-Answer (DPA) to server.")
+		if err := c.answer(conn, req, message.DIAMETER_SUCCESS); err != nil {
+			log.Printf("Error sending DPA: %v", err)
+		}
+		// A DPR on a connection retired by Rotate leaves the client open.
+		if c.getConn() != conn {
+			return
+		}
+		disconnect := disconnectCause(req)
+		c.setDisconnectCause(disconnect.Cause)
+		c.setCause(disconnect)
+		c.setBusy(disconnect.Cause)
+		c.triggerLogged(EventReceiveDPR)
+		c.scheduleReconnect(disconnect.Cause)
+	case message.COMMAND_CODE_RE_AUTH:
+		resultCode := message.DIAMETER_SUCCESS
+		if c.reAuthHandler != nil {
+			resultCode = c.reAuthHandler(req)
+		}
+		if err := c.answer(conn, req, resultCode); err != nil {
+			log.Printf("Error sending RAA: %v", err)
+		}
+	default:
+		c.errorLog.Printf(c.serverAddr, "command unsupported", "Unsupported request %s from %s", req.CommandName(), c.serverAddr)
+		if err := c.answer(conn, req, message.DIAMETER_COMMAND_UNSUPPORTED); err != nil {
+			log.Printf("Error sending answer: %v", err)
+		}
+	}
+}
+
+// disconnectCause returns the DisconnectError described by a DPR.
+func disconnectCause(dpr *message.DiameterMessage) *DisconnectError {
+	err := &DisconnectError{Cause: message.DISCONNECT_CAUSE_REBOOTING}
+	if cause, convErr := message.GetDisconnectCause(dpr); convErr == nil {
+		err.Cause = cause
+	}
+	return err
+}
@@ -2,16 +2,34 @@
 package client
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/IbrahimShahzad/diameter/clock"
+	"github.com/IbrahimShahzad/diameter/internal/observers"
+	"github.com/IbrahimShahzad/diameter/internal/pending"
+	"github.com/IbrahimShahzad/diameter/internal/ratelog"
 	"github.com/IbrahimShahzad/diameter/message"
 	fsm "github.com/IbrahimShahzad/diameter/state"
+	"github.com/IbrahimShahzad/diameter/stats"
+	"github.com/IbrahimShahzad/diameter/tap"
 	"github.com/IbrahimShahzad/diameter/transport"
 )
 
 const eventBufferSize = 10
 const messageQueueSize = 10
-const watchdogTTL = 10
+const watchdogTTL = 30 * time.Second
+const defaultRetryLimit = 3
+const defaultRetryBackoff = 100 * time.Millisecond
 
 type ClientOptionsFunc func(*ClientOptions)
 
@@ -20,6 +38,51 @@ type ClientOptions struct {
 	protocol          transport.ProtocolType
 	connectionTimeout time.Duration
 	watchdogTTL       time.Duration
+	clock             clock.Clock
+	applications      message.Applications
+	messageTap        tap.Func
+	orphanHandler     func(peer string, msg *message.DiameterMessage)
+	connEvents        func(transport.ConnEvent)
+	originHost        string
+	originRealm       string
+	productName       string
+	vendorID          uint32
+	firmwareRevision  uint32
+	originStateID     uint32
+	reAuthHandler     func(rar *message.DiameterMessage) message.ResultCode
+	socketOptions     transport.SocketOptions
+	idGenerator       message.IDGenerator
+	writeBatch        transport.BatchOptions
+	writeTimeout      time.Duration
+	linger            time.Duration
+	decodeOptions     message.DecodeOptions
+	retryClasses      []message.ResultClass
+	retryLimit        int
+	retryBackoff      time.Duration
+	stateless         bool
+	strictWatchdog    bool
+	skipSelfTest      bool
+	tlsConfig         *tls.Config
+	tlsIdentity       transport.TLSIdentityMode
+	interimFloor      time.Duration
+	interimCap        time.Duration
+	interimJitter     time.Duration
+	reconnectDelays   map[uint32]time.Duration
+	slowPeerThreshold time.Duration
+	slowPeerAction    SlowPeerAction
+	messageQueueSize  int
+	eventBufferSize   int
+	eventTimestamps   bool
+	recordBuffer      RecordBuffer
+	dialOptions       transport.DialOptions
+	expectedPeer      message.PeerIdentity
+	statsDumpWriter   io.Writer
+	statsDumpInterval time.Duration
+	statsDumpFormat   stats.Format
+	resyncWindow      int
+	errorLogInterval  time.Duration
+	busyCooldown      time.Duration
+	outboundCheck     message.ValidationMode
 }
 
 func defaultClientOptions() ClientOptions {
@@ -28,6 +91,21 @@ func defaultClientOptions() ClientOptions {
 		protocol:          transport.Proto_TCP,
 		connectionTimeout: 5 * time.Second,
 		watchdogTTL:       watchdogTTL,
+		clock:             clock.Real,
+		originHost:        "localhost",
+		originRealm:       "localdomain",
+		productName:       message.DefaultProductName(),
+		firmwareRevision:  message.DefaultFirmwareRevision(),
+		linger:            transport.DefaultLinger,
+		retryLimit:        defaultRetryLimit,
+		retryBackoff:      defaultRetryBackoff,
+		reconnectDelays:   defaultReconnectDelays(),
+		errorLogInterval:  10 * time.Second,
+		outboundCheck:     message.ValidationWarn,
+		applications: message.Applications{
+			Auth: message.NewApplicationSet(),
+			Acct: message.NewApplicationSet(),
+		},
 	}
 }
 
@@ -49,24 +127,517 @@ func WithTCP() ClientOptionsFunc {
 	}
 }
 
+// WithConnectionTimeout bounds the dial to the peer. It defaults to 5
+// seconds; 0 means no timeout.
 func WithConnectionTimeout(timeout time.Duration) ClientOptionsFunc {
 	return func(o *ClientOptions) {
 		o.connectionTimeout = timeout
 	}
 }
 
+// WithWatchdogTTL sets the Device-Watchdog interval Twinit. It defaults to
+// 30 seconds and must be at least MinWatchdogTTL.
 func WithWatchdogTTL(ttl time.Duration) ClientOptionsFunc {
 	return func(o *ClientOptions) {
 		o.watchdogTTL = ttl
 	}
 }
 
+// WithClock sets the clock used for all client timers. It defaults to the
+// real clock and is intended for tests.
+func WithClock(c clock.Clock) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.clock = c
+	}
+}
+
+// WithAuthApplications adds authentication applications advertised in the
+// CER.
+func WithAuthApplications(ids ...uint32) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		for _, id := range ids {
+			o.applications.Auth[id] = struct{}{}
+		}
+	}
+}
+
+// WithAcctApplications adds accounting applications advertised in the CER.
+func WithAcctApplications(ids ...uint32) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		for _, id := range ids {
+			o.applications.Acct[id] = struct{}{}
+		}
+	}
+}
+
+// WithVendorSpecificApplication advertises a Vendor-Specific-Application-Id
+// in the CER.
+func WithVendorSpecificApplication(vendorID, applicationID uint32, accounting bool) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.applications.VendorSpecific = append(o.applications.VendorSpecific, message.VendorApplication{
+			VendorID:      vendorID,
+			ApplicationID: applicationID,
+			Accounting:    accounting,
+		})
+	}
+}
+
+// WithMessageTap invokes fn for every frame received from or sent to the
+// peer. fn runs on a dedicated goroutine and never blocks the connection;
+// frames are dropped when it falls behind.
+func WithMessageTap(fn tap.Func) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.messageTap = fn
+	}
+}
+
+// WithStreamResync makes the client look for the next message within
+// window bytes when a frame header read from the peer does not parse,
+// such as after a message with a wrong Message-Length, instead of closing
+// the connection with ErrStreamDesync at once. The messages skipped are
+// lost. It defaults to 0, no resynchronization.
+func WithStreamResync(window int) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.resyncWindow = window
+	}
+}
+
+// WithErrorLogInterval logs an error of a kind, such as failing to decode
+// a message, at most once per interval, so that a peer in a crash loop or
+// sending junk cannot flood the logs. The lines left out are counted in
+// PeerStatus.SuppressedLogs and reported with the next line logged for
+// that error. It defaults to 10 seconds; 0 logs every error.
+func WithErrorLogInterval(interval time.Duration) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.errorLogInterval = interval
+	}
+}
+
+// WithStatsDump writes StatsSnapshot to w in format every interval, and a
+// last time when the client is closed, for soak tests run without a
+// metrics system. Snapshots are written on a goroutine of their own; one
+// due while w is still busy with the previous one is dropped, and the
+// number dropped is reported in the next.
+func WithStatsDump(w io.Writer, interval time.Duration, format stats.Format) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.statsDumpWriter = w
+		o.statsDumpInterval = interval
+		o.statsDumpFormat = format
+	}
+}
+
+// WithOrphanAnswerHandler calls fn for every answer whose Hop-by-Hop
+// Identifier matches no outstanding request, such as an answer arriving
+// after its request timed out. fn runs on its own goroutine with a copy of
+// the answer; peer is the identity of the peer, or its address before the
+// capabilities exchange.
+func WithOrphanAnswerHandler(fn func(peer string, msg *message.DiameterMessage)) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.orphanHandler = fn
+	}
+}
+
+// WithConnEventHandler subscribes SCTP connections to association and
+// peer address change notifications, such as a failover to another path
+// of a multihomed peer, and calls fn for each. fn runs on the read loop
+// and must not block. Where transport.SCTPEventsSupported is false no
+// events are delivered.
+func WithConnEventHandler(fn func(transport.ConnEvent)) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.connEvents = fn
+	}
+}
+
+// WithOriginHost sets the Origin-Host the client advertises.
+func WithOriginHost(host string) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.originHost = host
+	}
+}
+
+// WithOriginRealm sets the Origin-Realm the client advertises.
+func WithOriginRealm(realm string) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.originRealm = realm
+	}
+}
+
+// WithExpectedPeer sets the identity the server must announce in its CEA.
+// A CEA from another host or realm fails the capabilities exchange and
+// closes the connection. An empty host or realm is not checked.
+func WithExpectedPeer(host, realm string) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.expectedPeer = message.NewPeerIdentity(host, realm)
+	}
+}
+
+// WithProductName sets the Product-Name sent in CERs. It defaults to
+// message.DefaultProductName.
+func WithProductName(name string) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.productName = name
+	}
+}
+
+// WithFirmwareRevision sets the Firmware-Revision sent in CERs. It
+// defaults to message.DefaultFirmwareRevision; 0 leaves the AVP out.
+func WithFirmwareRevision(revision uint32) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.firmwareRevision = revision
+	}
+}
+
+// WithVendorID sets the Vendor-Id sent in CERs.
+func WithVendorID(vendorID uint32) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.vendorID = vendorID
+	}
+}
+
+// WithOriginStateID sets the Origin-State-Id sent in CERs and DWRs. It
+// defaults to the time the client was created in seconds, so that peers
+// notice a restart.
+func WithOriginStateID(id uint32) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.originStateID = id
+	}
+}
+
+// WithStrictWatchdog disconnects from a peer whose DWR or DWA carries an
+// Origin-Host other than the one of its CEA, or lacks Origin-Host or
+// Origin-Realm, answering such a DWR with DIAMETER_UNABLE_TO_COMPLY.
+// Without it such messages are only logged.
+func WithStrictWatchdog() ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.strictWatchdog = true
+	}
+}
+
+// WithTLS runs the connection over TLS with config, as RFC 6733 section
+// 2.1 expects on port 5868. Only TCP is supported.
+func WithTLS(config *tls.Config) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.tlsConfig = config
+	}
+}
+
+// WithTLSIdentityBinding compares the certificate of the server with the
+// Origin-Host of its CEA. In TLSIdentityEnforce mode a certificate that
+// does not name it fails the capabilities exchange.
+func WithTLSIdentityBinding(mode transport.TLSIdentityMode) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.tlsIdentity = mode
+	}
+}
+
+// WithInterimLimits bounds the interim interval of accounting sessions,
+// whether configured locally or set by the Acct-Interim-Interval of an
+// ACA. A limit of 0 leaves that side unbounded.
+func WithInterimLimits(floor, cap time.Duration) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.interimFloor = floor
+		o.interimCap = cap
+	}
+}
+
+// WithInterimJitter sends each interim record up to jitter early, so that
+// sessions started together do not send their interims together. It is
+// capped at half the interval.
+func WithInterimJitter(jitter time.Duration) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.interimJitter = jitter
+	}
+}
+
+// WithoutSelfTest stops Connect from running Validate before dialing.
+func WithoutSelfTest() ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.skipSelfTest = true
+	}
+}
+
+// WithReAuthHandler sets the function deciding the Result-Code of the RAA
+// sent for every Re-Auth-Request received from the peer. Without a handler
+// RARs are answered with DIAMETER_SUCCESS.
+func WithReAuthHandler(fn func(rar *message.DiameterMessage) message.ResultCode) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.reAuthHandler = fn
+	}
+}
+
+// WithTCPKeepalive enables TCP keepalive probes every interval so that
+// idle connections through firewalls are not silently dropped.
+func WithTCPKeepalive(interval time.Duration) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.socketOptions.KeepAlive = interval
+	}
+}
+
+// WithNoDelay enables or disables Nagle's algorithm on the connection.
+func WithNoDelay(noDelay bool) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.socketOptions.NoDelay = &noDelay
+	}
+}
+
+// WithSendBuffer sets the size of the socket send buffer in bytes.
+func WithSendBuffer(bytes int) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.socketOptions.SendBuffer = bytes
+	}
+}
+
+// WithReceiveBuffer sets the size of the socket receive buffer in bytes.
+func WithReceiveBuffer(bytes int) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.socketOptions.ReceiveBuffer = bytes
+	}
+}
+
+// WithIDGenerator sets the generator of the Hop-by-Hop and End-to-End
+// Identifiers of the requests built by the client. It defaults to a
+// generator seeded from the client clock.
+func WithIDGenerator(ids message.IDGenerator) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.idGenerator = ids
+	}
+}
+
+// WithWriteBatching sets how outbound messages are coalesced into a single
+// write: up to maxBytes per write, waiting at most flushInterval for
+// further messages after the first. With a flushInterval of 0, the default,
+// only messages already queued are coalesced.
+func WithWriteBatching(maxBytes int, flushInterval time.Duration) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.writeBatch.MaxBytes = maxBytes
+		o.writeBatch.FlushInterval = flushInterval
+	}
+}
+
+// WithWriteTimeout bounds every write to the peer, so that a peer which
+// stops reading cannot block the client forever. It defaults to 0, no
+// timeout.
+func WithWriteTimeout(timeout time.Duration) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.writeTimeout = timeout
+	}
+}
+
+// WithLinger sets how long the messages still queued for a peer that
+// closed its side of the connection get to be written before the
+// connection is closed. It defaults to transport.DefaultLinger; 0 closes
+// the connection at once.
+func WithLinger(linger time.Duration) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.linger = linger
+	}
+}
+
+// WithWriteFailureThreshold sets how many consecutive writes may time out
+// before the peer is declared down. A reset or broken connection declares
+// it down at once. It defaults to transport.DefaultWriteFailureThreshold.
+func WithWriteFailureThreshold(n int) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.writeBatch.FailureThreshold = n
+	}
+}
+
+// WithRetryOn makes Request send a request again when its answer carries
+// a result of one of classes, typically message.ResultClassTransient.
+// Permanent failures are never retried. Each retry is a new request with
+// fresh identifiers, so duplicate detection does not replay the failed
+// answer.
+func WithRetryOn(classes ...message.ResultClass) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.retryClasses = append(o.retryClasses, classes...)
+	}
+}
+
+// WithRetryLimit sets how many times Request retries a request and the
+// delay before the first retry, doubled for every further one. It
+// defaults to 3 retries starting at 100 milliseconds.
+func WithRetryLimit(retries int, backoff time.Duration) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.retryLimit = retries
+		o.retryBackoff = backoff
+	}
+}
+
+// WithStatelessSessions adds Auth-Session-State set to NO_STATE_MAINTAINED
+// to the application requests built by NewRequest, for applications that
+// keep no session state.
+func WithStatelessSessions() ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.stateless = true
+	}
+}
+
+// WithReconnectDelay sets how long the client waits before reconnecting
+// after the peer disconnected with the given Disconnect-Cause. A delay of
+// 0 disables reconnection for that cause. By default the client
+// reconnects after DefaultTc when the peer is REBOOTING, after 5 minutes
+// when it is BUSY, and never when it does not want to talk to us.
+func WithReconnectDelay(cause uint32, delay time.Duration) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.reconnectDelays[cause] = delay
+	}
+}
+
+// WithBusyCooldown makes a Pool try the peer after all others for
+// cooldown once it is open again after disconnecting with Disconnect-Cause
+// BUSY, so that it is readmitted gradually after the reconnect delay. A
+// cool-down of 0, the default, readmits it at once.
+func WithBusyCooldown(cooldown time.Duration) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.busyCooldown = cooldown
+	}
+}
+
+// WithOutboundValidation checks the requests passed to Request and
+// SendMessage with message.ValidateOutbound before they are sent. With
+// message.ValidationWarn, the default, an invalid request is logged,
+// counted in PeerStatus.InvalidRequests and sent; with
+// message.ValidationEnforce it is counted and not sent, and the error
+// returned wraps ErrInvalidRequest and the message.ValidationError.
+func WithOutboundValidation(mode message.ValidationMode) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.outboundCheck = mode
+	}
+}
+
+// WithDecodeOptions sets how messages from the peer are decoded. The
+// default tolerates bad AVP values in answers only.
+func WithDecodeOptions(opts message.DecodeOptions) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.decodeOptions = opts
+	}
+}
+
+// WithSlowPeerThreshold watches the p95 answer latency of the peer, over
+// windows of 100 answers, and applies action whenever it goes above
+// threshold or back below it. Latency runs from the request being written
+// to its answer being decoded. A threshold of 0, the default, disables the
+// detection.
+func WithSlowPeerThreshold(threshold time.Duration, action SlowPeerAction) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.slowPeerThreshold = threshold
+		o.slowPeerAction = action
+	}
+}
+
+// WithMessageQueueSize sets how many messages passed to SendMessage may
+// wait for the event loop. It defaults to 10; 0 keeps the default.
+func WithMessageQueueSize(size int) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.messageQueueSize = size
+	}
+}
+
+// WithEventBufferSize sets how many events may wait for the event loop
+// in EventChan. It defaults to 10; 0 keeps the default.
+func WithEventBufferSize(size int) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.eventBufferSize = size
+	}
+}
+
+// WithEventTimestamps adds an Event-Timestamp AVP with the current time
+// to every message sent to the peer, requests and answers alike, unless it
+// already carries one or its command forbids it. The time is taken when
+// the message is queued for writing, so write batching delays it by at
+// most the flush interval. The request passed in is not modified.
+func WithEventTimestamps(enabled bool) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.eventTimestamps = enabled
+	}
+}
+
+// WithDialAttempts bounds each connection attempt when the server address
+// resolves to several addresses, so that the next address is tried
+// before the connection timeout runs out. A positive fallbackDelay starts
+// the next attempt after that delay while the previous one is still
+// running, alternating IPv6 and IPv4 addresses as RFC 8305 does.
+func WithDialAttempts(attemptTimeout, fallbackDelay time.Duration) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.dialOptions.AttemptTimeout = attemptTimeout
+		o.dialOptions.FallbackDelay = fallbackDelay
+	}
+}
+
+// WithResolver sets the resolver of the server host name. It defaults to
+// net.DefaultResolver.
+func WithResolver(r transport.Resolver) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.dialOptions.Resolver = r
+	}
+}
+
+// WithRecordBuffer sets where accounting records that cannot be delivered
+// under GrantAndStore are kept until the peer answers again. It defaults
+// to an in-memory buffer of DefaultRecordBufferSize records.
+func WithRecordBuffer(buf RecordBuffer) ClientOptionsFunc {
+	return func(o *ClientOptions) {
+		o.recordBuffer = buf
+	}
+}
+
 type Client struct {
 	ClientOptions
-	conn         *transport.DiameterConnection
-	fsm          *fsm.FSM
-	EventChan    chan fsm.Event
-	messageQueue chan *message.DiameterMessage
+	mu      sync.Mutex
+	runOnce sync.Once
+	conn    *transport.DiameterConnection
+	writer  *transport.BatchWriter
+	retired *retiredConn
+	rotate  sync.Mutex
+	// stateMu guards the state change notifications below.
+	stateMu             sync.Mutex
+	stateChanged        chan struct{}
+	stateChanges        chan StateChange
+	droppedStateChanges atomic.Uint64
+	cause               error
+	state               fsm.State
+	closed              bool
+	writeBatches        transport.BatchStats
+	counters            transport.Counters
+	fsm                 *fsm.FSM
+	watchdog            *watchdog
+	negotiated          message.Applications
+	peerIdentity        message.PeerIdentity
+	capabilities        message.PeerCapabilities
+	peerLoad            *message.Load
+	disconnectCause     *uint32
+	reconnectTimer      clock.Timer
+	tap                 *tap.Tap
+	pending             *pending.Table
+	latency             *stats.Histogram
+	queueWait           *stats.Histogram
+	EventChan           chan fsm.Event
+	messageQueue        chan *message.DiameterMessage
+	// queueDown is closed when the writer of the current connection
+	// gives up on the peer, releasing the callers of SendMessage. It is
+	// guarded by mu and replaced by setConn.
+	queueDown chan struct{}
+	// slowMu guards the slow peer detection below.
+	slowMu      sync.Mutex
+	slowWindow  *stats.Histogram
+	slowSamples int
+	slow        bool
+	// flushing is set while stored accounting records are retransmitted.
+	flushing atomic.Bool
+	// invalidRequests counts the requests that failed outbound
+	// validation.
+	invalidRequests atomic.Uint64
+	// inboundRequests observes the requests received from the peer.
+	inboundRequests observers.List
+	// dumper is nil unless WithStatsDump is set.
+	dumper *stats.Dumper
+	// errorLog rate-limits the logging of errors repeated by the peer.
+	errorLog *ratelog.Limiter
+	// busyReopen is set when the peer disconnected as BUSY, and busyUntil
+	// ends the cool-down that starts once it is open again. Both are
+	// guarded by stateMu.
+	busyReopen bool
+	busyUntil  time.Time
 }
 
 // NewClient creates a new Client instance with the provided options.
@@ -77,38 +648,259 @@ func NewClient(opts ...ClientOptionsFunc) (*Client, error) {
 	for _, opt := range opts {
 		opt(&o)
 	}
-	return &Client{
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	if o.idGenerator == nil {
+		o.idGenerator = message.NewIDGenerator(o.clock)
+	}
+	if o.originStateID == 0 {
+		o.originStateID = uint32(o.clock.Now().Unix())
+	}
+	// Both channels are filled by the goroutine calling SendMessage before
+	// the event loop drains them, so they must never be unbuffered.
+	if o.messageQueueSize == 0 {
+		o.messageQueueSize = messageQueueSize
+	}
+	if o.eventBufferSize == 0 {
+		o.eventBufferSize = eventBufferSize
+	}
+	if o.recordBuffer == nil {
+		o.recordBuffer = NewMemoryRecordBuffer(DefaultRecordBufferSize)
+	}
+	c := &Client{
 		conn:          nil,
-		fsm:           fsm.NewFSM(fsm.StateClosed),
-		EventChan:     make(chan fsm.Event, eventBufferSize),
-		messageQueue:  make(chan *message.DiameterMessage, messageQueueSize),
+		EventChan:     make(chan fsm.Event, o.eventBufferSize),
+		messageQueue:  make(chan *message.DiameterMessage, o.messageQueueSize),
+		queueDown:     make(chan struct{}),
+		pending:       pending.New(o.clock),
+		latency:       stats.NewHistogram(0),
+		queueWait:     stats.NewHistogram(0),
+		slowWindow:    stats.NewHistogram(slowPeerWindow),
+		stateChanged:  make(chan struct{}),
+		stateChanges:  make(chan StateChange, stateChangeBufferSize),
+		state:         StateClosed,
 		ClientOptions: o,
-	}, nil
+	}
+	c.writeBatch.Stats = &c.writeBatches
+	c.errorLog = ratelog.New(o.errorLogInterval, o.clock)
+	if o.messageTap != nil {
+		c.tap = tap.New(o.messageTap, 0)
+	}
+	if o.statsDumpWriter != nil {
+		c.dumper = stats.NewDumper(o.statsDumpWriter, o.statsDumpInterval, o.statsDumpFormat, o.clock, c.StatsSnapshot)
+	}
+	c.InitializeFSM()
+	c.watchdog = newWatchdog(o.clock, o.watchdogTTL, watchdogHooks{
+		sendDWR: c.sendDWR,
+		failover: func() {
+			log.Printf("Peer %s is suspect, failing over.", c.serverAddr)
+			c.onWatchdogChange(WatchdogSuspect, "watchdog: peer suspect")
+		},
+		failback: func() {
+			log.Printf("Peer %s is available again.", c.serverAddr)
+			c.onWatchdogChange(WatchdogOkay, "watchdog: peer available")
+		},
+		closeConn: func() {
+			c.closeConn()
+			c.onWatchdogChange(WatchdogDown, "watchdog: peer down")
+		},
+		attemptOpen: func() { go c.reopen() },
+	})
+	return c, nil
 }
 
+// Connect dials the peer and starts the capabilities exchange. It returns
+// once the CER has been sent; the client reaches I-Open when the CEA
+// arrives. Unless WithoutSelfTest is set, a configuration that fails
+// Validate is reported before anything is dialed.
 func (c *Client) Connect() error {
-	conn, err := transport.NewDiameterConnection(c.serverAddr, c.protocol, c.connectionTimeout)
+	if !c.skipSelfTest {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+	}
+	c.runOnce.Do(func() { go c.Run() })
+	c.cancelReconnect()
+
+	if err := c.fsm.Trigger(EventStart); err != nil {
+		return err
+	}
+	conn, err := c.dial()
 	if err != nil {
+		c.setCause(err)
+		if nackErr := c.fsm.Trigger(EventConnNack); nackErr != nil {
+			log.Printf("Error triggering ConnNack event: %v", nackErr)
+		}
 		return err
 	}
-	c.conn = conn
-	c.fsm = fsm.NewFSM(fsm.StateClosed)
+	c.setConn(conn)
+	go c.readLoop(conn)
+	return c.fsm.Trigger(EventConnAck)
+}
 
-	// Start event loop in the background
-	go c.Run()
+// NewRequest builds a request for code with identifiers taken from the
+// client's IDGenerator, marked stateless with WithStatelessSessions.
+func (c *Client) NewRequest(code uint32, opts ...message.RequestOption) (*message.DiameterMessage, error) {
+	defaults := []message.RequestOption{message.WithIDGenerator(c.idGenerator)}
+	if c.stateless {
+		defaults = append(defaults, message.WithStateless())
+	}
+	return message.NewRequest(code, append(defaults, opts...)...)
+}
 
-	c.EventChan <- EventStart
-	return nil
+// dial connects to the peer and applies the configured socket options.
+func (c *Client) dial() (*transport.DiameterConnection, error) {
+	var conn *transport.DiameterConnection
+	var err error
+	switch {
+	case c.tlsConfig != nil:
+		conn, err = transport.NewDiameterTLSConnection(c.serverAddr, c.tlsConfig, c.connectionTimeout)
+	case c.connEvents != nil:
+		conn, err = transport.NewDiameterSCTPEventConnection(c.serverAddr)
+	default:
+		opts := c.dialOptions
+		opts.Timeout = c.connectionTimeout
+		conn, err = transport.DialDiameterConnection(c.serverAddr, c.protocol, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.ApplySocketOptions(c.socketOptions); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetCounters(&c.counters)
+	conn.SetTimeouts(0, c.writeTimeout)
+	conn.SetEventHandler(c.connEvents)
+	conn.SetResyncWindow(c.resyncWindow)
+	return conn, nil
 }
 
-// // SendMessage sends a Diameter message to the server.
+// Request sends req and waits for the answer with the same Hop-by-Hop
+// Identifier. Requests received from the peer while waiting, such as DWRs,
+// are answered by the read loop and do not disturb the correlation. If the
+// Hop-by-Hop Identifier of req is still in use by an earlier request, a
+// fresh one is assigned. Answers in a class set with WithRetryOn are
+// retried; the last answer is returned when the retries run out, and the
+// error of ctx when it ends during a backoff.
+func (c *Client) Request(ctx context.Context, req *message.DiameterMessage) (*message.DiameterMessage, error) {
+	if err := c.checkOutbound(req); err != nil {
+		return nil, err
+	}
+	ans, err := c.request(ctx, req)
+	for attempt := 0; err == nil && attempt < c.retryLimit && c.shouldRetry(ans); attempt++ {
+		select {
+		case <-c.clock.After(c.retryBackoff << attempt):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		retry := req.Clone()
+		retry.Header.HopByHopID = c.idGenerator.HopByHopID()
+		retry.Header.EndToEndID = c.idGenerator.EndToEndID()
+		ans, err = c.request(ctx, retry)
+	}
+	return ans, err
+}
+
+// shouldRetry reports whether the result of ans is in a class configured
+// with WithRetryOn.
+func (c *Client) shouldRetry(ans *message.DiameterMessage) bool {
+	if len(c.retryClasses) == 0 {
+		return false
+	}
+	result, err := message.GetResult(ans)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(c.retryClasses, result.Class())
+}
+
+func (c *Client) request(ctx context.Context, req *message.DiameterMessage) (*message.DiameterMessage, error) {
+	writer, ch, err := c.reserve(req)
+	if err != nil {
+		return nil, err
+	}
+	hopByHopID := req.Header.HopByHopID
+	queued := c.clock.Now()
+	if err := c.writeTo(writer, req); err != nil {
+		c.pending.Remove(hopByHopID)
+		return nil, &message.PeerError{Identity: c.PeerIdentity(), Op: "request", Err: err}
+	}
+	written := c.clock.Now()
+	select {
+	case r := <-ch:
+		if r.Err == nil {
+			c.observeLatency(written.Sub(queued), c.clock.Now().Sub(written))
+		}
+		return r.Answer, r.Err
+	case <-ctx.Done():
+		c.pending.Remove(hopByHopID)
+		return nil, ctx.Err()
+	}
+}
+
+// reserve registers req as pending on the current connection and returns
+// the writer to send it with. Both are taken under the same lock so that a
+// Rotate sees every request sent on the connection it retires.
+func (c *Client) reserve(req *message.DiameterMessage) (*transport.BatchWriter, <-chan pending.Result, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch, err := c.pending.ReserveFor(c.conn, req, c.idGenerator.HopByHopID, 0)
+	return c.writer, ch, err
+}
+
+// SendMessage queues a Diameter message for the server. It blocks while
+// the queue is full, and returns ErrPeerDown once the writer has given up
+// on the peer, until the next connection; the messages still queued then
+// are dropped.
 func (c *Client) SendMessage(msg *message.DiameterMessage) error {
-	c.messageQueue <- msg
+	if err := c.checkOutbound(msg); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	down := c.queueDown
+	c.mu.Unlock()
+	select {
+	case <-down:
+		return ErrPeerDown
+	default:
+	}
+	select {
+	case c.messageQueue <- msg:
+	case <-down:
+		return ErrPeerDown
+	}
+	select {
+	case c.EventChan <- EventSendMessage:
+	case <-down:
+		return ErrPeerDown
+	}
+	return nil
+}
+
+// checkOutbound validates req as WithOutboundValidation selects, and
+// returns an error only when an invalid request must not be sent.
+func (c *Client) checkOutbound(req *message.DiameterMessage) error {
+	if c.outboundCheck == message.ValidationOff {
+		return nil
+	}
+	err := message.ValidateOutbound(req)
+	if err == nil {
+		return nil
+	}
+	c.invalidRequests.Add(1)
+	if c.outboundCheck == message.ValidationEnforce {
+		return fmt.Errorf("%w %s: %w", ErrInvalidRequest, req.CommandName(), err)
+	}
+	c.errorLog.Printf(c.serverAddr, "invalid request", "Sending invalid %s to %s: %v", req.CommandName(), c.serverAddr, err)
 	return nil
 }
 
-// Disconnect cleanly disconnects from the server.
+// Disconnect cleanly disconnects from the server. A reconnection scheduled
+// after the peer disconnected is cancelled.
 func (c *Client) Disconnect() error {
+	c.cancelReconnect()
 	c.EventChan <- EventDisconnect
 	return nil
 }
@@ -116,6 +908,424 @@ func (c *Client) Disconnect() error {
 // Run listens for and processes client events.
 func (c *Client) Run() {
 	for event := range c.EventChan {
-		c.fsm.Trigger(event)
+		if err := c.fsm.Trigger(event); err != nil {
+			log.Printf("Error handling event %d: %v", event, err)
+		}
+	}
+}
+
+// setConn makes conn the current connection, with a new writer in front
+// of it. The writer of the previous connection is stopped.
+func (c *Client) setConn(conn *transport.DiameterConnection) {
+	writer := c.newWriter(conn)
+	c.mu.Lock()
+	old := c.writer
+	c.conn = conn
+	c.writer = writer
+	select {
+	case <-c.queueDown:
+		c.queueDown = make(chan struct{})
+	default:
+	}
+	c.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+}
+
+// newWriter returns the writer sending the messages of conn.
+func (c *Client) newWriter(conn *transport.DiameterConnection) *transport.BatchWriter {
+	opts := c.writeBatch
+	opts.OnPeerDown = func(err error) { c.peerDown(conn, err) }
+	return transport.NewBatchWriter(conn, opts)
+}
+
+// peerDown is called by the writer of conn when it gives up on the peer.
+// The requests outstanding on conn fail with err, which wraps transport.ErrPeerDown,
+// and EventPeerDisc is fired from a new goroutine since the write that
+// failed may have been issued by an FSM action or the watchdog. If conn is
+// the current connection, the callers blocked in SendMessage get
+// ErrPeerDown and the message queue is emptied.
+func (c *Client) peerDown(conn *transport.DiameterConnection, err error) {
+	log.Printf("Peer %s is down: %v", c.serverAddr, err)
+	c.pending.FailOwner(conn, &message.PeerError{Identity: c.PeerIdentity(), Op: "request", Err: err})
+	c.mu.Lock()
+	current := c.conn == conn
+	if current {
+		select {
+		case <-c.queueDown:
+		default:
+			close(c.queueDown)
+		}
+	}
+	c.mu.Unlock()
+	if !current {
+		return
+	}
+	if dropped := c.drainQueue(); dropped > 0 {
+		log.Printf("Dropped %d messages queued for %s.", dropped, c.serverAddr)
+	}
+	c.setCause(err)
+	go c.triggerLogged(EventPeerDisc)
+}
+
+// drainQueue empties the message queue and returns the number of
+// messages dropped.
+func (c *Client) drainQueue() int {
+	for n := 0; ; n++ {
+		select {
+		case <-c.messageQueue:
+		default:
+			return n
+		}
+	}
+}
+
+// peerClosed is called by the read loop of conn when the peer closed its
+// side of the connection. The messages still queued get up to the linger
+// time to be written, then EventPeerDisc closes the connection unless the
+// client is done with it already.
+func (c *Client) peerClosed(conn *transport.DiameterConnection) {
+	log.Printf("Peer %s closed its side of the connection.", c.serverAddr)
+	if c.getConn() != conn {
+		return
+	}
+	if writer := c.getWriter(); writer != nil && c.linger > 0 {
+		if err := writer.Drain(c.linger); err != nil {
+			log.Printf("Error flushing messages to %s: %v", c.serverAddr, err)
+		}
+	}
+	if c.getConn() != conn || c.fsm.GetState() == StateClosed {
+		return
+	}
+	c.setCause(ErrPeerClosed)
+	c.triggerLogged(EventPeerDisc)
+}
+
+// connLost gives up on conn after an error that leaves it unreadable.
+// The current connection is closed like a broken one, and the watchdog
+// opens a new one; any other is just closed.
+func (c *Client) connLost(conn *transport.DiameterConnection, err error) {
+	if c.getConn() != conn || c.fsm.GetState() == StateClosed {
+		conn.Close()
+		return
+	}
+	c.setCause(err)
+	c.triggerLogged(EventPeerDisc)
+}
+
+func (c *Client) getWriter() *transport.BatchWriter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writer
+}
+
+func (c *Client) getConn() *transport.DiameterConnection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+// writerFor returns the writer of conn, which is either the current
+// connection or the one being retired by Rotate.
+func (c *Client) writerFor(conn *transport.DiameterConnection) *transport.BatchWriter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.retired != nil && c.retired.conn == conn {
+		return c.retired.writer
+	}
+	return c.writer
+}
+
+// writeMessage encodes msg and writes it to the current connection.
+func (c *Client) writeMessage(msg *message.DiameterMessage) error {
+	return c.writeTo(c.getWriter(), msg)
+}
+
+// writeTo encodes msg and writes it with writer.
+func (c *Client) writeTo(writer *transport.BatchWriter, msg *message.DiameterMessage) error {
+	if writer == nil {
+		return ErrNotConnected
+	}
+	encoded, err := msg.Encode()
+	if err != nil {
+		return err
+	}
+	if c.eventTimestamps {
+		if encoded, err = message.StampEventTimestamp(encoded, msg, c.clock.Now()); err != nil {
+			return err
+		}
 	}
+	c.tap.Observe(tap.Outbound, c.serverAddr, encoded, msg)
+	return writer.Write(encoded)
+}
+
+// WriteBatchStats reports how outbound messages were coalesced into
+// writes, across every connection of the client.
+func (c *Client) WriteBatchStats() stats.WriteBatchStats {
+	batches, messages, bytes := c.writeBatches.Load()
+	return stats.WriteBatchStats{Batches: batches, Messages: messages, Bytes: bytes}
+}
+
+// readLoop reads messages from conn until it fails, feeding every message
+// to the watchdog. Requests from the peer are answered inline and answers
+// are matched to the outstanding requests. Only the requests sent on conn
+// fail when it ends.
+func (c *Client) readLoop(conn *transport.DiameterConnection) {
+	defer func() {
+		c.pending.FailOwner(conn, &message.PeerError{Identity: c.PeerIdentity(), Op: "request", Err: ErrNotConnected})
+	}()
+	for {
+		frame, err := conn.ReadFrame()
+		if errors.Is(err, io.EOF) {
+			c.peerClosed(conn)
+			return
+		}
+		if errors.Is(err, ErrStreamDesync) {
+			log.Printf("Closing the connection to %s: %v", c.serverAddr, err)
+			c.connLost(conn, err)
+			return
+		}
+		if err != nil {
+			c.errorLog.Printf(c.serverAddr, "read", "Error reading from peer %s: %v", c.serverAddr, err)
+			c.connLost(conn, err)
+			return
+		}
+		msg, err := message.DecodeMessage(frame, message.WithDecodeOptions(c.decodeOptions))
+		if err != nil {
+			c.errorLog.Printf(c.serverAddr, "decode", "Error decoding message from peer %s: %v", c.serverAddr, err)
+			c.connLost(conn, err)
+			return
+		}
+		c.tap.Observe(tap.Inbound, c.serverAddr, frame, msg)
+		if msg.Header.CommandCode == message.COMMAND_CODE_DWR {
+			if err := c.checkWatchdog(conn, msg); err != nil {
+				if c.getConn() == conn {
+					c.setCause(err)
+					c.triggerLogged(EventPeerDisc)
+				}
+				return
+			}
+		}
+		isDWA := msg.Header.CommandCode == message.COMMAND_CODE_DWR && !msg.IsRequest()
+		c.watchdog.received(isDWA)
+
+		// A CER looped back by the peer while the client waits for its CEA
+		// fails the capabilities exchange instead of being answered.
+		if msg.IsRequest() && (msg.Header.CommandCode != message.COMMAND_CODE_CER || c.fsm.GetState() != StateWaitCEA) {
+			c.handleRequest(conn, msg)
+			continue
+		}
+		if load, ok := message.ParseLoad(msg); ok {
+			c.setPeerLoad(load)
+		}
+		switch msg.Header.CommandCode {
+		case message.COMMAND_CODE_CER:
+			if err := c.handleCEA(conn, msg); err != nil {
+				log.Printf("Capabilities exchange with %s failed: %v", c.serverAddr, err)
+				c.setCause(err)
+				c.triggerLogged(EventNonCEAReceived)
+				return
+			}
+			if state := c.fsm.GetState(); state == StateWaitCEA {
+				c.triggerLogged(EventCEAReceived)
+			}
+		case message.COMMAND_CODE_DISCONNECT_PEER:
+			// The DPA on a connection retired by Rotate answers its DPR.
+			if c.getConn() != conn {
+				c.deliver(msg)
+				continue
+			}
+			c.triggerLogged(EventReceiveDPA)
+		case message.COMMAND_CODE_DWR:
+		default:
+			c.deliver(msg)
+		}
+	}
+}
+
+// deliver hands ans to the request waiting for it, or to the orphan answer
+// handler when there is none.
+func (c *Client) deliver(ans *message.DiameterMessage) {
+	if c.pending.Deliver(ans) {
+		return
+	}
+	c.errorLog.Printf(c.serverAddr, "orphaned answer", "Received unsolicited %s from %s", ans.CommandName(), c.serverAddr)
+	if fn := c.orphanHandler; fn != nil {
+		go fn(c.peerName(), ans.Clone())
+	}
+}
+
+func (c *Client) setPeerLoad(load message.Load) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.peerLoad = &load
+}
+
+// PeerLoad returns the load last reported by the peer in an RFC 8583 Load
+// AVP, and false if the peer never reported one.
+func (c *Client) PeerLoad() (message.Load, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.peerLoad == nil {
+		return message.Load{}, false
+	}
+	return *c.peerLoad, true
+}
+
+// triggerLogged fires event on the FSM, logging instead of returning a
+// failed transition.
+func (c *Client) triggerLogged(event fsm.Event) {
+	if err := c.fsm.Trigger(event); err != nil {
+		log.Printf("Error handling event %d: %v", event, err)
+	}
+}
+
+// handleCEA records the applications negotiated with the peer.
+// Authentication and accounting applications are negotiated independently,
+// so a peer sharing only accounting applications is still usable.
+func (c *Client) handleCEA(conn *transport.DiameterConnection, msg *message.DiameterMessage) error {
+	cea, err := message.ParseCEA(msg)
+	if err != nil {
+		return err
+	}
+	if err := c.checkExpectedPeer(cea.Peer.Identity); err != nil {
+		return err
+	}
+	if err := c.checkTLSIdentity(conn, cea.Peer.Identity.Host); err != nil {
+		return err
+	}
+	offer, err := message.ParseOffer(msg)
+	if err != nil {
+		log.Printf("Ignoring invalid capabilities in CEA from %s: %v", c.serverAddr, err)
+	}
+	negotiated := message.Capabilities{Applications: c.applications}.Intersect(offer)
+	if negotiated.IsEmpty() {
+		return ErrNoCommonApplication
+	}
+	c.mu.Lock()
+	c.negotiated = negotiated.Applications
+	c.peerIdentity = cea.Peer.Identity
+	c.capabilities = cea.Peer
+	c.mu.Unlock()
+	return nil
+}
+
+// checkExpectedPeer compares id, the identity announced in a CEA, with the
+// one set with WithExpectedPeer.
+func (c *Client) checkExpectedPeer(id message.PeerIdentity) error {
+	want := c.expectedPeer
+	if want.Host != "" && id.Host != want.Host {
+		return fmt.Errorf("%w: Origin-Host %s, expected %s", ErrPeerMismatch, id.Host, want.Host)
+	}
+	if want.Realm != "" && id.Realm != want.Realm {
+		return fmt.Errorf("%w: Origin-Realm %s, expected %s", ErrPeerMismatch, id.Realm, want.Realm)
+	}
+	return nil
+}
+
+// checkTLSIdentity compares the certificate of the server on conn with
+// host, the Origin-Host of its CEA, as set with WithTLSIdentityBinding.
+func (c *Client) checkTLSIdentity(conn *transport.DiameterConnection, host string) error {
+	if c.tlsIdentity == transport.TLSIdentityOff || !conn.IsTLS() {
+		return nil
+	}
+	err := conn.VerifyPeerIdentity(host)
+	if err == nil {
+		return nil
+	}
+	log.Printf("Invalid TLS identity of %s: %v", c.serverAddr, err)
+	if c.tlsIdentity != transport.TLSIdentityEnforce {
+		return nil
+	}
+	return &message.ProtocolError{ResultCode: message.DIAMETER_UNKNOWN_PEER, Err: err}
+}
+
+// checkWatchdog verifies the identity carried by a DWR or DWA received on
+// conn against the one the peer announced in its CEA. A mismatch is
+// logged; with WithStrictWatchdog a DWR is then answered with
+// DIAMETER_UNABLE_TO_COMPLY and the error is returned so that the client
+// disconnects.
+func (c *Client) checkWatchdog(conn *transport.DiameterConnection, msg *message.DiameterMessage) error {
+	err := message.CheckOrigin(msg, c.PeerIdentity())
+	if err == nil {
+		return nil
+	}
+	c.errorLog.Printf(c.serverAddr, "watchdog origin", "Invalid %s from %s: %v", msg.CommandName(), c.serverAddr, err)
+	if !c.strictWatchdog {
+		return nil
+	}
+	if msg.IsRequest() {
+		if err := c.answer(conn, msg, message.DIAMETER_UNABLE_TO_COMPLY); err != nil {
+			log.Printf("Error sending DWA: %v", err)
+		}
+	}
+	return &message.ProtocolError{ResultCode: message.DIAMETER_UNABLE_TO_COMPLY, Err: err}
+}
+
+// peerName returns the identity of the peer, or its address before the
+// capabilities exchange.
+func (c *Client) peerName() string {
+	if id := c.PeerIdentity(); !id.IsZero() {
+		return id.String()
+	}
+	return c.serverAddr
+}
+
+// LocalIdentity returns the Origin-Host and Origin-Realm of the client.
+func (c *Client) LocalIdentity() message.PeerIdentity {
+	return c.node().Identity()
+}
+
+// node returns the identity the client advertises, with the local address
+// of the current connection as Host-IP-Address.
+func (c *Client) node() message.Node {
+	node := message.Node{
+		OriginHost:       c.originHost,
+		OriginRealm:      c.originRealm,
+		VendorID:         c.vendorID,
+		ProductName:      c.productName,
+		FirmwareRevision: c.firmwareRevision,
+		OriginStateID:    c.originStateID,
+	}
+	if conn := c.getConn(); conn != nil {
+		if ip := transport.HostIP(conn.LocalAddr()); ip != nil {
+			node.HostIPAddresses = []net.IP{ip}
+		}
+	}
+	return node
+}
+
+// PeerIdentity returns the identity the peer announced in its CEA. It is
+// the zero value until the capabilities exchange has completed.
+func (c *Client) PeerIdentity() message.PeerIdentity {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.peerIdentity
+}
+
+// PeerCapabilities returns the product, vendor, firmware and addresses the
+// peer announced in its CEA. It is the zero value until the capabilities
+// exchange has completed.
+func (c *Client) PeerCapabilities() message.PeerCapabilities {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.capabilities
+}
+
+// Applications returns the applications negotiated with the peer.
+func (c *Client) Applications() message.Applications {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.negotiated
+}
+
+// Supports reports whether msg may be sent to the peer given the
+// negotiated applications, checking the accounting applications for
+// accounting commands.
+func (c *Client) Supports(msg *message.DiameterMessage) bool {
+	return c.Applications().Supports(
+		msg.Header.ApplicationID,
+		message.IsAccountingCommand(msg.Header.CommandCode),
+	)
 }
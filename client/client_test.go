@@ -0,0 +1,746 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+	"github.com/IbrahimShahzad/diameter/message"
+	fsm "github.com/IbrahimShahzad/diameter/state"
+	"github.com/IbrahimShahzad/diameter/stats"
+	"github.com/IbrahimShahzad/diameter/tap"
+)
+
+// undecodableAnswer returns a CCA frame whose only AVP claims more bytes
+// than the message holds.
+func undecodableAnswer() []byte {
+	frame := make([]byte, message.DIAMETER_HEADER_SIZE+message.AVPHeaderLength)
+	binary.BigEndian.PutUint32(frame[0:], uint32(len(frame)))
+	frame[0] = message.DIAMETER_VERSION
+	binary.BigEndian.PutUint32(frame[4:], message.COMMAND_CODE_CREDIT_CONTROL)
+	binary.BigEndian.PutUint32(frame[8:], message.APPLICATION_ID_CREDIT_CONTROL)
+	avp := frame[message.DIAMETER_HEADER_SIZE:]
+	binary.BigEndian.PutUint32(avp[0:], message.AVP_RESULT_CODE)
+	binary.BigEndian.PutUint32(avp[4:], 200)
+	avp[4] = message.MANDATORY_FLAG
+	return frame
+}
+
+func TestReadLoopDropsUndecodableConnection(t *testing.T) {
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr())
+	conn := peer.connect(c)
+
+	if _, err := conn.Write(undecodableAnswer()); err != nil {
+		t.Fatalf("writing frame: %v", err)
+	}
+	// The client closes the connection instead of leaving it unread until
+	// the watchdog notices, and the watchdog opens a new one.
+	for {
+		_, err := readTestMessage(conn)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("connection not closed by the client: %v", err)
+		}
+	}
+	peer.exchange(peer.accept())
+}
+
+// TestReadLoopStreamDesync writes bytes that are not a message before the
+// answer to a request: the client closes the connection with
+// ErrStreamDesync, unless a resync window lets it find the answer.
+func TestReadLoopStreamDesync(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []ClientOptionsFunc
+		// resync tells whether the answer is read past the garbage.
+		resync bool
+	}{
+		{name: "closed"},
+		{name: "resynchronized", opts: []ClientOptionsFunc{WithStreamResync(16)}, resync: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			peer := newTestPeer(t)
+			c := newTestClient(t, peer.addr(), tc.opts...)
+			conn := peer.connect(c)
+			transitions(t, c, 3)
+			answered := make(chan error, 1)
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+				defer cancel()
+				_, err := c.Request(ctx, newTestCCR(t, c, "client.example.com;1;1"))
+				answered <- err
+			}()
+			ccr := peer.read(conn)
+			ans, err := peer.node.BuildAnswer(ccr, message.DIAMETER_SUCCESS)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ans.Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := conn.Write(append([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, data...)); err != nil {
+				t.Fatalf("writing: %v", err)
+			}
+
+			err = <-answered
+			if tc.resync {
+				if err != nil {
+					t.Fatalf("request answered past the garbage: %v", err)
+				}
+				if got := c.PeerStatus().Counters; got.Resyncs != 1 {
+					t.Errorf("%d resyncs, want 1", got.Resyncs)
+				}
+				return
+			}
+			if err == nil {
+				t.Error("request answered on a desynchronized connection")
+			}
+			// The watchdog handles the connection lost in I-Open.
+			eventually(t, "the connection to be lost with ErrStreamDesync", func() bool {
+				select {
+				case change := <-c.StateChanges():
+					return change.To == StateIOpen && errors.Is(change.Err, ErrStreamDesync)
+				default:
+					return false
+				}
+			})
+			// The bytes left unread make the close a reset.
+			var netErr net.Error
+			if _, err := readTestMessage(conn); err == nil || errors.As(err, &netErr) && netErr.Timeout() {
+				t.Errorf("connection not closed by the client: %v", err)
+			}
+			// The watchdog opens a new connection.
+			peer.exchange(peer.accept())
+		})
+	}
+}
+
+func TestPoolSkipsSuspectPeer(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	peer1, peer2 := newTestPeer(t), newTestPeer(t)
+	c1 := newTestClient(t, peer1.addr(), WithClock(clk))
+	c2 := newTestClient(t, peer2.addr())
+	conn1 := peer1.connect(c1)
+	peer2.connect(c2)
+	pool := NewPool(c1, c2)
+	if got, err := pool.Pick(); err != nil || got != c1 {
+		t.Fatalf("Pick() = %p, %v; want the first peer", got, err)
+	}
+
+	fire(t, c1.watchdog, clk)
+	dwr := peer1.read(conn1)
+	if dwr.Header.CommandCode != message.COMMAND_CODE_DWR {
+		t.Fatalf("read %s, want DWR", dwr.CommandName())
+	}
+	fire(t, c1.watchdog, clk)
+	if got := c1.watchdog.Status(); got != WatchdogSuspect {
+		t.Fatalf("first peer is %v, want SUSPECT", got)
+	}
+	if got, err := pool.Pick(); err != nil || got != c2 {
+		t.Fatalf("Pick() = %p, %v; want the second peer", got, err)
+	}
+
+	// The late DWA brings the first peer back.
+	dwa, err := peer1.node.BuildDWA(dwr)
+	if err != nil {
+		t.Fatalf("building DWA: %v", err)
+	}
+	peer1.write(conn1, dwa)
+	eventually(t, "the first peer to be OKAY", func() bool { return c1.watchdog.Status() == WatchdogOkay })
+	if got, err := pool.Pick(); err != nil || got != c1 {
+		t.Fatalf("Pick() = %p, %v; want the first peer again", got, err)
+	}
+}
+
+// answerBusy answers the CCR read from conn with DIAMETER_OUT_OF_SPACE and
+// returns it.
+func answerBusy(t *testing.T, peer *testPeer, conn net.Conn) *message.DiameterMessage {
+	t.Helper()
+	req := peer.read(conn)
+	if req.Header.CommandCode != message.COMMAND_CODE_CREDIT_CONTROL {
+		t.Fatalf("read %s, want CCR", req.CommandName())
+	}
+	ans, err := peer.node.BuildAnswer(req, message.DIAMETER_OUT_OF_SPACE)
+	if err != nil {
+		t.Fatalf("building answer: %v", err)
+	}
+	peer.write(conn, ans)
+	return req
+}
+
+func TestRequestRetryBackoff(t *testing.T) {
+	const backoff = 100 * time.Millisecond
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithClock(clk),
+		WithRetryOn(message.ResultClassTransient), WithRetryLimit(2, backoff))
+	conn := peer.connect(c)
+	timers := clk.Pending()
+
+	type result struct {
+		ans *message.DiameterMessage
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ans, err := c.Request(context.Background(), newTestCCR(t, c, "client.example.com;1;1"))
+		done <- result{ans, err}
+	}()
+
+	first := answerBusy(t, peer, conn)
+	var last *message.DiameterMessage
+	for attempt, delay := range []time.Duration{backoff, 2 * backoff} {
+		eventually(t, "the backoff timer", func() bool { return clk.Pending() == timers+1 })
+		clk.Advance(delay - time.Nanosecond)
+		if clk.Pending() != timers+1 {
+			t.Fatalf("retry %d sent before %v", attempt+1, delay)
+		}
+		clk.Advance(time.Nanosecond)
+		if attempt == 0 {
+			last = answerBusy(t, peer, conn)
+		} else {
+			last = peer.read(conn)
+			peer.answer(conn, last)
+		}
+		if last.Header.HopByHopID == first.Header.HopByHopID || last.Header.EndToEndID == first.Header.EndToEndID {
+			t.Errorf("retry %d reuses the identifiers of the request", attempt+1)
+		}
+	}
+
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("Request: %v", r.err)
+	}
+	if code, _, err := message.GetResultCode(r.ans); err != nil || code != message.DIAMETER_SUCCESS {
+		t.Errorf("Result-Code %v, %v; want the answer to the last retry", code, err)
+	}
+}
+
+func TestRequestRetryCancelled(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithClock(clk),
+		WithRetryOn(message.ResultClassTransient), WithRetryLimit(3, time.Second))
+	conn := peer.connect(c)
+	timers := clk.Pending()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	type result struct {
+		ans *message.DiameterMessage
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ans, err := c.Request(ctx, newTestCCR(t, c, "client.example.com;1;1"))
+		done <- result{ans, err}
+	}()
+
+	answerBusy(t, peer, conn)
+	eventually(t, "the backoff timer", func() bool { return clk.Pending() == timers+1 })
+	cancel()
+	r := <-done
+	if !errors.Is(r.err, context.Canceled) || r.ans != nil {
+		t.Errorf("Request = %v, %v; want context.Canceled", r.ans, r.err)
+	}
+}
+
+// TestInboundDWRDuringRequest has the peer send a DWR, the answer to a slow
+// CCR and another DWR, and checks that both DWRs are answered in turn, the
+// CCA reaches the caller and each message counts as traffic from the peer.
+func TestInboundDWRDuringRequest(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithClock(clk))
+	conn := peer.connect(c)
+
+	type result struct {
+		ans *message.DiameterMessage
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ans, err := c.Request(context.Background(), newTestCCR(t, c, "client.example.com;1;1"))
+		done <- result{ans, err}
+	}()
+	ccr := peer.read(conn)
+
+	sendDWR := func(id uint32) {
+		t.Helper()
+		clk.Advance(time.Second)
+		dwr, err := peer.node.BuildDWR()
+		if err != nil {
+			t.Fatalf("building DWR: %v", err)
+		}
+		dwr.Header.HopByHopID = id
+		peer.write(conn, dwr)
+		dwa := peer.read(conn)
+		if dwa.Header.CommandCode != message.COMMAND_CODE_DWR || dwa.IsRequest() || dwa.Header.HopByHopID != id {
+			t.Fatalf("read %s %d, want the DWA to DWR %d", dwa.CommandName(), dwa.Header.HopByHopID, id)
+		}
+		if id, err := message.OriginIdentity(dwa); err != nil || id.Host != c.originHost {
+			t.Errorf("DWA from %v, %v; want %s", id, err, c.originHost)
+		}
+		now := clk.Now()
+		eventually(t, "the DWR to count as traffic", func() bool { return c.watchdog.LastReceived().Equal(now) })
+	}
+
+	sendDWR(101)
+	clk.Advance(time.Second)
+	peer.answer(conn, ccr)
+	sendDWR(102)
+
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("Request: %v", r.err)
+	}
+	if r.ans.Header.CommandCode != message.COMMAND_CODE_CREDIT_CONTROL || r.ans.Header.HopByHopID != ccr.Header.HopByHopID {
+		t.Errorf("Request returned %s %d, want the CCA %d", r.ans.CommandName(), r.ans.Header.HopByHopID, ccr.Header.HopByHopID)
+	}
+}
+
+// TestCERProductName checks the Product-Name and Firmware-Revision of the
+// CER, by default and when configured.
+func TestCERProductName(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		opts     []ClientOptionsFunc
+		product  string
+		firmware uint32
+	}{
+		{"default", nil, message.DefaultProductName(), message.DefaultFirmwareRevision()},
+		{"configured", []ClientOptionsFunc{WithProductName("pcef"), WithFirmwareRevision(3)}, "pcef", 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			peer := newTestPeer(t)
+			c := newTestClient(t, peer.addr(), tc.opts...)
+			if err := c.Connect(); err != nil {
+				t.Fatalf("connecting: %v", err)
+			}
+			cer := peer.read(peer.accept())
+			software := message.ParsePeerSoftware(cer)
+			if software.ProductName != tc.product || software.FirmwareRevision != tc.firmware {
+				t.Errorf("CER advertises %+v, want Product-Name %q and Firmware-Revision %d", software, tc.product, tc.firmware)
+			}
+		})
+	}
+}
+
+// TestPeerStatusSoftware checks that PeerStatus reports the software the
+// peer advertised in its CEA.
+func TestPeerStatusSoftware(t *testing.T) {
+	peer := newTestPeer(t)
+	peer.node.VendorID, peer.node.FirmwareRevision = message.VENDOR_HUAWEI, 800
+	c := newTestClient(t, peer.addr())
+	peer.connect(c)
+	got := c.PeerStatus().Software
+	if got.VendorID != message.VENDOR_HUAWEI || got.VendorName != "Huawei" || got.ProductName != "test peer" || got.FirmwareRevision != 800 {
+		t.Errorf("PeerStatus reports the software %+v, want that of the CEA", got)
+	}
+}
+
+func TestOriginStateID(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	for _, tc := range []struct {
+		name string
+		opts []ClientOptionsFunc
+		want uint32
+	}{
+		{"start time", nil, 1_700_000_000},
+		{"configured", []ClientOptionsFunc{WithOriginStateID(7)}, 7},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			peer := newTestPeer(t)
+			c := newTestClient(t, peer.addr(), append([]ClientOptionsFunc{WithClock(clk)}, tc.opts...)...)
+			if err := c.Connect(); err != nil {
+				t.Fatalf("connecting: %v", err)
+			}
+			conn := peer.accept()
+			cer := peer.read(conn)
+			cea, err := peer.node.BuildCEA(cer, message.ParseApplications(cer), message.DIAMETER_SUCCESS)
+			if err != nil {
+				t.Fatal(err)
+			}
+			peer.write(conn, cea)
+			waitReady(t, c)
+			clk.Advance(time.Minute)
+			dwr := peer.read(conn)
+			for _, msg := range []*message.DiameterMessage{cer, dwr} {
+				if id, err := msg.GetAVP(message.AVP_ORIGIN_STATE_ID).Uint32(); err != nil || id != tc.want {
+					t.Errorf("%s Origin-State-Id %d, %v; want %d", msg.CommandName(), id, err, tc.want)
+				}
+			}
+		})
+	}
+}
+
+// TestLateAnswerOrphaned lets a request time out and has the peer answer
+// it afterwards: the answer goes to the orphan handler with the
+// identifiers of the request and is counted.
+func TestLateAnswerOrphaned(t *testing.T) {
+	type orphan struct {
+		peer string
+		msg  *message.DiameterMessage
+	}
+	orphans := make(chan orphan, 1)
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithOrphanAnswerHandler(func(name string, msg *message.DiameterMessage) {
+		orphans <- orphan{name, msg}
+	}))
+	conn := peer.connect(c)
+
+	ccr := newTestCCR(t, c, "client.example.com;1;late")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Request(ctx, ccr); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Request = %v, want context.DeadlineExceeded", err)
+	}
+	peer.answer(conn, peer.read(conn))
+
+	select {
+	case got := <-orphans:
+		if got.msg.Header.EndToEndID != ccr.Header.EndToEndID {
+			t.Errorf("orphan handler got End-to-End %#x, want %#x", got.msg.Header.EndToEndID, ccr.Header.EndToEndID)
+		}
+		if want := peer.node.Identity().String(); got.peer != want {
+			t.Errorf("orphan handler got peer %q, want %q", got.peer, want)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("orphan handler not called")
+	}
+	if got := c.PeerStatus().OrphanedAnswers; got != 1 {
+		t.Errorf("OrphanedAnswers = %d, want 1", got)
+	}
+}
+
+func TestStatelessSessions(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		opts      []ClientOptionsFunc
+		stateless bool
+	}{
+		{"default", nil, false},
+		{"stateless", []ClientOptionsFunc{WithStatelessSessions()}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestClient(t, "127.0.0.1:3868", tc.opts...)
+			ccr, err := c.NewRequest(message.COMMAND_CODE_CREDIT_CONTROL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ccr.IsStateless() != tc.stateless {
+				t.Errorf("CCR carries Auth-Session-State %v", ccr.GetAVP(message.AVP_AUTH_SESSION_STATE))
+			}
+			// The base protocol has no authorization session.
+			dwr, err := c.NewRequest(message.COMMAND_CODE_DWR)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if avp := dwr.GetAVP(message.AVP_AUTH_SESSION_STATE); avp != nil {
+				t.Errorf("DWR carries %v", avp)
+			}
+		})
+	}
+}
+
+func TestEventTimestamps(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	for _, tc := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"disabled", false},
+		{"enabled", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			peer := newTestPeer(t)
+			c := newTestClient(t, peer.addr(), WithClock(clk), WithEventTimestamps(tc.enabled))
+			if err := c.Connect(); err != nil {
+				t.Fatalf("connecting: %v", err)
+			}
+			conn := peer.accept()
+			cer := peer.read(conn)
+			cea, err := peer.node.BuildCEA(cer, message.ParseApplications(cer), message.DIAMETER_SUCCESS)
+			if err != nil {
+				t.Fatal(err)
+			}
+			peer.write(conn, cea)
+			waitReady(t, c)
+
+			ccr := newTestCCR(t, c, "client.example.com;1;1")
+			done := make(chan error, 1)
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+				defer cancel()
+				_, err := c.Request(ctx, ccr)
+				done <- err
+			}()
+			sent := peer.read(conn)
+			peer.answer(conn, sent)
+			if err := <-done; err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			if avp := ccr.GetAVP(message.AVP_EVENT_TIMESTAMP); avp != nil {
+				t.Errorf("request passed in changed to carry %v", avp)
+			}
+			for _, msg := range []*message.DiameterMessage{cer, sent} {
+				avp := msg.GetAVP(message.AVP_EVENT_TIMESTAMP)
+				if !tc.enabled {
+					if avp != nil {
+						t.Errorf("%s carries %v", msg.CommandName(), avp)
+					}
+					continue
+				}
+				if got, err := avp.Time(); err != nil || !got.Equal(clk.Now()) {
+					t.Errorf("%s Event-Timestamp %v, %v; want %v", msg.CommandName(), got, err, clk.Now())
+				}
+			}
+		})
+	}
+}
+
+// loopbackResolver resolves every host name to the loopback addresses in
+// order.
+type loopbackResolver []string
+
+func (r loopbackResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs := make([]net.IPAddr, 0, len(r))
+	for _, ip := range r {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+// TestDialResolvedAddresses resolves the server host name to an address
+// refusing the connection and then the peer, and checks that the client
+// connects to the peer.
+func TestDialResolvedAddresses(t *testing.T) {
+	peer := newTestPeer(t)
+	_, port, _ := net.SplitHostPort(peer.addr())
+	if conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.2", port)); err == nil {
+		conn.Close()
+		t.Skip("127.0.0.2 reaches the peer")
+	}
+	c := newTestClient(t, net.JoinHostPort("dra.example.com", port),
+		WithResolver(loopbackResolver{"127.0.0.2", "127.0.0.1"}),
+		WithDialAttempts(time.Second, 0))
+	peer.connect(c)
+	if got := c.PeerStatus().RemoteAddr; got != peer.addr() {
+		t.Errorf("connected to %s, want %s", got, peer.addr())
+	}
+}
+
+// TestExpectedPeer connects to a peer announcing peer.example.com in the
+// realm example.com, stripping drop from its CEA, and checks the outcome
+// of the capabilities exchange against the identity expected.
+func TestExpectedPeer(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		host, realm string
+		// drop is an AVP removed from the CEA.
+		drop uint32
+		err  error
+	}{
+		{name: "match", host: "Peer.Example.COM.", realm: "example.com"},
+		{name: "host only", host: "peer.example.com"},
+		{name: "not checked"},
+		{name: "other host", host: "other.example.com", realm: "example.com", err: ErrPeerMismatch},
+		{name: "other realm", realm: "other.example.com", err: ErrPeerMismatch},
+		{name: "missing Origin-Host", host: "peer.example.com", drop: message.AVP_ORIGIN_HOST, err: message.MissingOriginHostError},
+		{name: "missing Origin-Realm", realm: "example.com", drop: message.AVP_ORIGIN_REALM, err: message.MissingOriginRealmError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			peer := newTestPeer(t)
+			c := newTestClient(t, peer.addr(), WithExpectedPeer(tc.host, tc.realm))
+			if tc.err == nil {
+				peer.connect(c)
+				if id := c.PeerIdentity(); id != message.NewPeerIdentity("peer.example.com", "example.com") {
+					t.Errorf("connected to %v", id)
+				}
+				return
+			}
+			if err := c.Connect(); err != nil {
+				t.Fatalf("connecting: %v", err)
+			}
+			conn := peer.accept()
+			cer := peer.read(conn)
+			cea, err := peer.node.BuildCEA(cer, message.ParseApplications(cer), message.DIAMETER_SUCCESS)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cea.AVPs = slices.DeleteFunc(cea.AVPs, func(avp *message.AVP) bool { return avp.Code == tc.drop })
+			peer.write(conn, cea)
+			got := transitions(t, c, 3)
+			if want := []fsm.State{StateWaitConnAck, StateWaitCEA, StateClosed}; !slices.Equal(states(got), want) {
+				t.Fatalf("capabilities exchange went through %v, want %v", states(got), want)
+			}
+			if !errors.Is(got[2].Err, tc.err) {
+				t.Errorf("closed with %v, want %v", got[2].Err, tc.err)
+			}
+			if id := c.PeerIdentity(); !id.IsZero() {
+				t.Errorf("peer identity %v recorded", id)
+			}
+		})
+	}
+}
+
+// TestInboundRequestObservers sends an RAR and an ASR to a client with two
+// observers, the first panicking, and checks that each request is answered
+// as without observers, observed, and that the panics are only counted.
+func TestInboundRequestObservers(t *testing.T) {
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr())
+	observed := make(chan *message.DiameterMessage, 4)
+	c.OnInboundRequest(func(msg *message.DiameterMessage) { panic("audit pipeline down") })
+	c.OnInboundRequest(func(msg *message.DiameterMessage) { observed <- msg })
+	conn := peer.connect(c)
+
+	for _, tc := range []struct {
+		code uint32
+		want message.ResultCode
+	}{
+		{message.COMMAND_CODE_RE_AUTH, message.DIAMETER_SUCCESS},
+		{message.COMMAND_CODE_ABORT_SESSION, message.DIAMETER_COMMAND_UNSUPPORTED},
+	} {
+		req, err := message.NewRequest(tc.code, message.WithApplication(message.APPLICATION_ID_CREDIT_CONTROL), message.WithAVPs(
+			message.MustNewAVP(message.AVP_SESSION_ID, "client.example.com;1;1", message.MANDATORY_FLAG),
+			message.MustNewAVP(message.AVP_ORIGIN_HOST, peer.node.OriginHost, message.MANDATORY_FLAG),
+			message.MustNewAVP(message.AVP_ORIGIN_REALM, peer.node.OriginRealm, message.MANDATORY_FLAG),
+			message.MustNewAVP(message.AVP_DESTINATION_HOST, "client.example.com", message.MANDATORY_FLAG),
+		))
+		if err != nil {
+			t.Fatal(err)
+		}
+		peer.write(conn, req)
+		ans := peer.read(conn)
+		if code, _, _ := message.GetResultCode(ans); ans.Header.HopByHopID != req.Header.HopByHopID || code != tc.want {
+			t.Errorf("%s answered with %v, want %v", req.CommandName(), code, tc.want)
+		}
+		select {
+		case msg := <-observed:
+			if msg.Header.CommandCode != tc.code || msg.Header.HopByHopID != req.Header.HopByHopID {
+				t.Errorf("observed %s %d, want %s %d", msg.CommandName(), msg.Header.HopByHopID, req.CommandName(), req.Header.HopByHopID)
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("%s not observed", req.CommandName())
+		}
+	}
+	eventually(t, "the panics to be counted", func() bool { return c.PeerStatus().ObserverPanics == 2 })
+	// The client still works.
+	go func() {
+		if ccr, err := readTestMessage(conn); err == nil {
+			ans, _ := peer.node.BuildAnswer(ccr, message.DIAMETER_SUCCESS)
+			data, _ := ans.Encode()
+			conn.Write(data)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if _, err := c.Request(ctx, newTestCCR(t, c, "client.example.com;1;2")); err != nil {
+		t.Errorf("request after the panics: %v", err)
+	}
+}
+
+// dumpWriter sends every statistics dump written to it on the channel.
+type dumpWriter chan string
+
+func (w dumpWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+// TestStatsDump checks that a client dumps its statistics as text at every
+// interval of its clock and a last time when closed.
+func TestStatsDump(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0).UTC())
+	w := make(dumpWriter, 4)
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithClock(clk), WithStatsDump(w, time.Minute, stats.FormatText))
+	peer.connect(c)
+	next := func() string {
+		t.Helper()
+		select {
+		case dump := <-w:
+			return dump
+		case <-time.After(testTimeout):
+			t.Fatal("no statistics dumped")
+		}
+		return ""
+	}
+
+	clk.Advance(time.Minute)
+	dump := next()
+	if first, _, _ := strings.Cut(dump, "\n"); !strings.HasPrefix(first, "2023-11-14T22:14:20Z orphaned=0 ") || strings.HasSuffix(first, " final") {
+		t.Errorf("dump at the tick starts with %q", first)
+	}
+	if !strings.Contains(dump, "\n  "+peer.addr()+" 1 ") {
+		t.Errorf("dump\n%s\nhas no row for the peer and its CEA", dump)
+	}
+
+	c.Close()
+	// Close returns once the final dump is written.
+	select {
+	case dump := <-w:
+		if first, _, _ := strings.Cut(dump, "\n"); !strings.HasSuffix(first, " final") {
+			t.Errorf("final dump starts with %q", first)
+		}
+	default:
+		t.Fatal("no final dump on Close")
+	}
+}
+
+// TestCloseDrainsTap has the message tap stall on the first frame, and
+// checks that Close waits for the frames queued meanwhile to be delivered
+// and that the tap sees no frame once Close has returned.
+func TestCloseDrainsTap(t *testing.T) {
+	release := make(chan struct{})
+	seen := make(chan uint32, 8)
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithMessageTap(func(_ tap.Direction, _ string, _ []byte, msg *message.DiameterMessage) {
+		<-release
+		seen <- msg.Header.CommandCode
+	}))
+	peer.connect(c)
+
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the tap delivered its frames")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(testTimeout):
+		t.Fatal("Close did not return once the tap caught up")
+	}
+
+	c.tap.Observe(tap.Inbound, peer.addr(), nil, nil)
+	close(seen)
+	var got []uint32
+	for code := range seen {
+		got = append(got, code)
+	}
+	// The CER and its CEA.
+	if want := []uint32{message.COMMAND_CODE_CER, message.COMMAND_CODE_CER}; !slices.Equal(got, want) {
+		t.Errorf("tap saw commands %v, want %v", got, want)
+	}
+	if n := c.tap.Dropped(); n != 1 {
+		t.Errorf("%d frames dropped, want the one observed after Close", n)
+	}
+}
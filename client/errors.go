@@ -0,0 +1,39 @@
+package client
+
+import (
+	"errors"
+
+	"github.com/IbrahimShahzad/diameter/transport"
+)
+
+var (
+	ErrNotConnected        = errors.New("client is not connected")
+	ErrNoAvailablePeer     = errors.New("no available peer")
+	ErrPeerClosed          = errors.New("peer closed the connection")
+	ErrNoCommonApplication = errors.New("no common application with peer")
+	// ErrSlowPeer is wrapped by the error of the StateChange published
+	// when the peer goes above the slow peer threshold.
+	ErrSlowPeer = errors.New("slow peer")
+	// ErrInvalidOptions is wrapped by the errors NewClient returns for
+	// options that are out of range or contradict each other.
+	ErrInvalidOptions = errors.New("invalid client options")
+	// ErrAccountingStopped is returned by AccountingSession.Stop for a
+	// session already stopped.
+	ErrAccountingStopped = errors.New("accounting session stopped")
+	// ErrSessionTerminated is returned by Pool for a request of a session
+	// terminated because its peer failed, see TerminateOnFailover.
+	ErrSessionTerminated = errors.New("session terminated on failover")
+	// ErrPeerMismatch is returned when the identity in a CEA is not the
+	// one set with WithExpectedPeer.
+	ErrPeerMismatch = errors.New("peer identity mismatch")
+	// ErrStreamDesync is the cause of the disconnection when a frame
+	// header read from the peer does not parse, see WithStreamResync.
+	ErrStreamDesync = transport.ErrStreamDesync
+	// ErrPeerDown is returned by SendMessage, and wrapped by the errors of
+	// the requests outstanding, once the writer has given up on the peer
+	// after write timeouts or a broken connection.
+	ErrPeerDown = transport.ErrPeerDown
+	// ErrInvalidRequest is returned for a request that fails validation,
+	// see WithOutboundValidation.
+	ErrInvalidRequest = errors.New("invalid request")
+)
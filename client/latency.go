@@ -0,0 +1,106 @@
+// Per-peer answer latency and slow peer detection
+package client
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/stats"
+)
+
+// slowPeerWindow is the number of answers over which the p95 latency is
+// compared with the slow peer threshold. Each window starts afresh, so a
+// peer getting slow is noticed within one window whatever its history.
+const slowPeerWindow = 100
+
+// SlowPeerAction is what the client does when the p95 latency of its peer
+// goes above the threshold set with WithSlowPeerThreshold.
+type SlowPeerAction int
+
+const (
+	// SlowPeerNotify publishes a StateChange when the peer becomes slow
+	// and when it recovers.
+	SlowPeerNotify SlowPeerAction = iota
+	// SlowPeerDemote also moves the peer behind every other peer of a
+	// Pool in the failover order until it recovers, which takes a window
+	// of answers under the threshold to requests sent to it directly or
+	// while no other peer was available.
+	SlowPeerDemote
+)
+
+func (a SlowPeerAction) String() string {
+	switch a {
+	case SlowPeerNotify:
+		return "notify"
+	case SlowPeerDemote:
+		return "demote"
+	}
+	return fmt.Sprintf("SlowPeerAction(%d)", int(a))
+}
+
+// observeLatency records the answer to a request. queueWait runs from the
+// call to Request until the request was written, latency from then until
+// the answer was decoded.
+func (c *Client) observeLatency(queueWait, latency time.Duration) {
+	c.queueWait.Observe(queueWait)
+	c.latency.Observe(latency)
+	if c.slowPeerThreshold == 0 {
+		return
+	}
+	c.slowMu.Lock()
+	c.slowWindow.Observe(latency)
+	c.slowSamples++
+	if c.slowSamples < slowPeerWindow {
+		c.slowMu.Unlock()
+		return
+	}
+	p95 := c.slowWindow.Snapshot().P95
+	c.slowWindow = stats.NewHistogram(slowPeerWindow)
+	c.slowSamples = 0
+	slow := p95 > c.slowPeerThreshold
+	changed := slow != c.slow
+	c.slow = slow
+	c.slowMu.Unlock()
+	if changed {
+		c.onSlowPeerChange(slow, p95)
+	}
+}
+
+// isSlow reports whether the p95 latency of the last window was above the
+// slow peer threshold.
+func (c *Client) isSlow() bool {
+	c.slowMu.Lock()
+	defer c.slowMu.Unlock()
+	return c.slow
+}
+
+// demoted reports whether a Pool should try the peer after all others.
+func (c *Client) demoted() bool {
+	return c.slowPeerAction == SlowPeerDemote && c.isSlow() ||
+		c.clock.Now().Before(c.coolingDownUntil())
+}
+
+// onSlowPeerChange publishes the peer becoming slow or recovering.
+func (c *Client) onSlowPeerChange(slow bool, p95 time.Duration) {
+	reason := "peer no longer slow"
+	var err error
+	if slow {
+		reason = "slow peer"
+		err = fmt.Errorf("%w: p95 latency %v above %v", ErrSlowPeer, p95, c.slowPeerThreshold)
+		log.Printf("Peer %s is slow: p95 latency %v above %v.", c.serverAddr, p95, c.slowPeerThreshold)
+	} else {
+		log.Printf("Peer %s is no longer slow: p95 latency %v.", c.serverAddr, p95)
+	}
+	c.stateMu.Lock()
+	current := c.state
+	c.stateMu.Unlock()
+	c.publish(StateChange{
+		From:     current,
+		To:       current,
+		Watchdog: c.watchdog.Status(),
+		Time:     c.clock.Now(),
+		Reason:   reason,
+		Err:      err,
+	})
+}
@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+// slowDelay is how long the delayed peer holds the answers to requests
+// whose session ends in ";slow".
+const slowDelay = 50 * time.Millisecond
+
+// serveDelayed answers every request read from conn, holding back the
+// answers to sessions ending in ";slow" for slowDelay, until the connection
+// fails or a DPR is answered.
+func serveDelayed(peer *testPeer, conn net.Conn) {
+	for {
+		req, err := readTestMessage(conn)
+		if err != nil {
+			return
+		}
+		var ans *message.DiameterMessage
+		switch req.Header.CommandCode {
+		case message.COMMAND_CODE_DWR:
+			ans, err = peer.node.BuildDWA(req)
+		case message.COMMAND_CODE_DISCONNECT_PEER:
+			ans, err = peer.node.BuildDPA(req)
+		default:
+			if session, _ := message.GetSessionID(req); strings.HasSuffix(session, ";slow") {
+				time.Sleep(slowDelay)
+			}
+			ans, err = peer.node.BuildAnswer(req, message.DIAMETER_SUCCESS)
+		}
+		if err != nil {
+			return
+		}
+		data, err := ans.Encode()
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write(data); err != nil || req.Header.CommandCode == message.COMMAND_CODE_DISCONNECT_PEER {
+			return
+		}
+	}
+}
+
+// sendRequests sends n requests on c, one at a time, the last slow of them
+// to sessions served slowly.
+func sendRequests(t *testing.T, c *Client, n, slow int) {
+	t.Helper()
+	for i := range n {
+		session := fmt.Sprintf("client.example.com;1;%d", i)
+		if i >= n-slow {
+			session += ";slow"
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		_, err := c.Request(ctx, newTestCCR(t, c, session))
+		cancel()
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+}
+
+// slowPeerChange waits for the state change published when c becomes slow
+// or recovers.
+func slowPeerChange(t *testing.T, c *Client) StateChange {
+	t.Helper()
+	for {
+		select {
+		case change := <-c.StateChanges():
+			if change.From == change.To && strings.Contains(change.Reason, "slow") {
+				return change
+			}
+		case <-time.After(testTimeout):
+			t.Fatal("timed out waiting for a slow peer state change")
+		}
+	}
+}
+
+func TestPeerLatency(t *testing.T) {
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr())
+	go serveDelayed(peer, peer.connect(c))
+
+	// With 10 of 100 answers delayed the p95 is one of them and the p50
+	// one of the others.
+	sendRequests(t, c, 100, 10)
+	status := c.PeerStatus()
+	if status.Latency.Count != 100 || status.QueueWait.Count != 100 {
+		t.Fatalf("%d latencies and %d queue waits recorded, want 100", status.Latency.Count, status.QueueWait.Count)
+	}
+	if status.Latency.P95 < slowDelay || status.Latency.Max < slowDelay {
+		t.Errorf("p95 latency %v and maximum %v, want at least %v", status.Latency.P95, status.Latency.Max, slowDelay)
+	}
+	if status.Latency.P50 >= slowDelay {
+		t.Errorf("p50 latency %v, want less than %v", status.Latency.P50, slowDelay)
+	}
+	// Queue wait ends when the request is written, before the peer
+	// delays its answer.
+	if status.QueueWait.Max >= slowDelay {
+		t.Errorf("maximum queue wait %v includes the delay of the peer", status.QueueWait.Max)
+	}
+	if status.Slow {
+		t.Error("peer reported slow without a threshold")
+	}
+}
+
+func TestSlowPeerDemote(t *testing.T) {
+	peer1, peer2 := newTestPeer(t), newTestPeer(t)
+	c1 := newTestClient(t, peer1.addr(), WithSlowPeerThreshold(slowDelay/2, SlowPeerDemote))
+	c2 := newTestClient(t, peer2.addr())
+	go serveDelayed(peer1, peer1.connect(c1))
+	peer2.connect(c2)
+	pool := NewPool(c1, c2)
+
+	// A window with fewer answers than the p95 needs stays fast.
+	sendRequests(t, c1, slowPeerWindow, 5)
+	if c1.PeerStatus().Slow {
+		t.Fatal("peer slow with 5 slow answers of 100")
+	}
+	sendRequests(t, c1, slowPeerWindow, 10)
+	change := slowPeerChange(t, c1)
+	if !errors.Is(change.Err, ErrSlowPeer) || change.To != StateIOpen {
+		t.Errorf("slow peer published as %+v", change)
+	}
+	if !c1.PeerStatus().Slow {
+		t.Error("PeerStatus does not report the peer slow")
+	}
+	if got, err := pool.Pick(); err != nil || got != c2 {
+		t.Errorf("Pick() = %p, %v; want the second peer", got, err)
+	}
+	// A demoted peer is still used when no other peer is available.
+	if got, err := NewPool(c1).Pick(); err != nil || got != c1 {
+		t.Errorf("Pick() of the slow peer alone = %p, %v", got, err)
+	}
+
+	sendRequests(t, c1, slowPeerWindow, 0)
+	if change := slowPeerChange(t, c1); change.Err != nil || change.Reason != "peer no longer slow" {
+		t.Errorf("recovery published as %+v", change)
+	}
+	if got, err := pool.Pick(); err != nil || got != c1 {
+		t.Errorf("Pick() = %p, %v; want the first peer again", got, err)
+	}
+}
+
+func TestSlowPeerNotify(t *testing.T) {
+	peer1, peer2 := newTestPeer(t), newTestPeer(t)
+	c1 := newTestClient(t, peer1.addr(), WithSlowPeerThreshold(slowDelay/2, SlowPeerNotify))
+	c2 := newTestClient(t, peer2.addr())
+	go serveDelayed(peer1, peer1.connect(c1))
+	peer2.connect(c2)
+
+	sendRequests(t, c1, slowPeerWindow, 10)
+	if change := slowPeerChange(t, c1); !errors.Is(change.Err, ErrSlowPeer) {
+		t.Errorf("slow peer published as %+v", change)
+	}
+	if got, err := NewPool(c1, c2).Pick(); err != nil || got != c1 {
+		t.Errorf("Pick() = %p, %v; want the slow peer, which is not demoted", got, err)
+	}
+}
@@ -0,0 +1,209 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+// TestPeerStopsReading connects to a peer that stops reading once the
+// capabilities are exchanged, and checks that the write timeouts escalate
+// to peer down: the request outstanding and the callers of SendMessage
+// get ErrPeerDown, and the message queue is emptied.
+func TestPeerStopsReading(t *testing.T) {
+	const writeTimeout, threshold = 50 * time.Millisecond, 2
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(),
+		WithWriteTimeout(writeTimeout),
+		WithWriteFailureThreshold(threshold),
+		WithSendBuffer(4096),
+		WithMessageQueueSize(4),
+		WithWatchdogTTL(time.Hour),
+	)
+	peer.connect(c)
+
+	requestErr := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+		_, err := c.Request(ctx, newTestCCR(t, c, "client.example.com;1;request"))
+		requestErr <- err
+	}()
+
+	// Large messages fill the socket buffers of both ends, then the
+	// queue, until the writer gives up.
+	filler := strings.Repeat("x", 64<<10)
+	start := time.Now()
+	sendErr := make(chan error, 1)
+	go func() {
+		for {
+			req := newTestCCR(t, c, "client.example.com;1;"+filler)
+			if err := c.SendMessage(req); err != nil {
+				sendErr <- err
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-sendErr:
+		if !errors.Is(err, ErrPeerDown) {
+			t.Errorf("SendMessage = %v, want ErrPeerDown", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("SendMessage still blocked on a peer that stopped reading")
+	}
+	// The socket buffers take a few writes to fill, each of which may
+	// take up to the write timeout before the threshold is reached.
+	if elapsed := time.Since(start); elapsed > 20*writeTimeout {
+		t.Errorf("peer declared down after %v", elapsed)
+	}
+	if err := <-requestErr; !errors.Is(err, ErrPeerDown) {
+		t.Errorf("outstanding request = %v, want ErrPeerDown", err)
+	}
+	if n := len(c.messageQueue); n != 0 {
+		t.Errorf("%d messages left in the queue", n)
+	}
+}
+
+// TestPeerHalfCloses has the peer send a DWR and shut down its side of the
+// connection, and checks that the DWA still reaches it before the client
+// closes the connection, takes the peer down and connects again.
+func TestPeerHalfCloses(t *testing.T) {
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithWatchdogTTL(time.Hour))
+	conn := peer.connect(c)
+
+	dwr, err := peer.node.BuildDWR()
+	if err != nil {
+		t.Fatalf("building DWR: %v", err)
+	}
+	peer.write(conn, dwr)
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	dwa := peer.read(conn)
+	if dwa.Header.CommandCode != message.COMMAND_CODE_DWR || dwa.IsRequest() || dwa.Header.HopByHopID != dwr.Header.HopByHopID {
+		t.Fatalf("read %s, want the DWA", dwa.CommandName())
+	}
+	if _, err := readTestMessage(conn); !errors.Is(err, io.EOF) {
+		t.Errorf("read %v after the DWA, want the connection closed", err)
+	}
+	eventually(t, "the peer to be down", func() bool { return c.watchdog.Status() != WatchdogOkay })
+	peer.accept()
+}
+
+// TestWatchdogOrigin has the peer send a DWR, or answer the DWR of the
+// client, with an Origin-Host other than the one of its CEA, and checks
+// that WithStrictWatchdog drops the connection while by default the
+// message is only logged.
+func TestWatchdogOrigin(t *testing.T) {
+	spoofed := message.Node{OriginHost: "spoofed.example.com", OriginRealm: "example.com"}
+	for _, tc := range []struct {
+		name   string
+		strict bool
+		// dwa has the peer answer a DWR of the client instead of sending
+		// its own.
+		dwa bool
+	}{
+		{"DWR", false, false},
+		{"DWR strict", true, false},
+		{"DWA", false, true},
+		{"DWA strict", true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+			opts := []ClientOptionsFunc{WithClock(clk)}
+			if tc.strict {
+				opts = append(opts, WithStrictWatchdog())
+			}
+			peer := newTestPeer(t)
+			c := newTestClient(t, peer.addr(), opts...)
+			conn := peer.connect(c)
+
+			if tc.dwa {
+				clk.Advance(time.Minute)
+				dwr := peer.read(conn)
+				dwa, err := spoofed.BuildDWA(dwr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				peer.write(conn, dwa)
+			} else {
+				dwr, err := spoofed.BuildDWR()
+				if err != nil {
+					t.Fatal(err)
+				}
+				peer.write(conn, dwr)
+				want := message.DIAMETER_SUCCESS
+				if tc.strict {
+					want = message.DIAMETER_UNABLE_TO_COMPLY
+				}
+				if code, _, err := message.GetResultCode(peer.read(conn)); err != nil || code != want {
+					t.Fatalf("DWA Result-Code %v, %v; want %v", code, err, want)
+				}
+			}
+
+			if tc.strict {
+				if _, err := readTestMessage(conn); !errors.Is(err, io.EOF) {
+					t.Errorf("read %v, want the connection closed", err)
+				}
+				return
+			}
+			// The connection is still used.
+			done := make(chan error, 1)
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+				defer cancel()
+				_, err := c.Request(ctx, newTestCCR(t, c, "client.example.com;1;1"))
+				done <- err
+			}()
+			peer.answer(conn, peer.read(conn))
+			if err := <-done; err != nil {
+				t.Errorf("request after the %s: %v", tc.name, err)
+			}
+		})
+	}
+}
+
+// TestReopenRejectedCEA has the peer close the connection and reject the
+// CER of the reopened one, and checks that the client closes it and stays
+// DOWN until Tw expires, then reopens again and only tells the watchdog the
+// connection is up once the capabilities are exchanged.
+func TestReopenRejectedCEA(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithClock(clk))
+	peer.connect(c).Close()
+
+	conn := peer.accept()
+	cer := peer.read(conn)
+	cea, err := peer.node.BuildCEA(cer, message.ParseApplications(cer), message.DIAMETER_UNABLE_TO_COMPLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer.write(conn, cea)
+	if _, err := readTestMessage(conn); !errors.Is(err, io.EOF) {
+		t.Fatalf("read %v after the rejected CEA, want the connection closed", err)
+	}
+	if got := c.watchdog.Status(); got != WatchdogDown {
+		t.Errorf("watchdog %v after the rejected CEA, want DOWN", got)
+	}
+
+	clk.Advance(time.Minute)
+	conn = peer.accept()
+	peer.exchange(conn)
+	if dwr := peer.read(conn); dwr.Header.CommandCode != message.COMMAND_CODE_DWR || !dwr.IsRequest() {
+		t.Fatalf("read %s after the CEA, want the DWR of REOPEN", dwr.CommandName())
+	}
+	if got := c.watchdog.Status(); got != WatchdogReopen {
+		t.Errorf("watchdog %v after the CEA, want REOPEN", got)
+	}
+}
@@ -0,0 +1,243 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+// testTimeout bounds every wait on the network in the tests.
+const testTimeout = 5 * time.Second
+
+// testPeer is a Diameter peer scripted by the test, for the exchanges the
+// server package cannot be made to produce, such as malformed frames.
+type testPeer struct {
+	t     testing.TB
+	ln    net.Listener
+	node  message.Node
+	conns chan net.Conn
+	mu    sync.Mutex
+	all   []net.Conn
+}
+
+func newTestPeer(t testing.TB) *testPeer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	return listenPeer(t, ln)
+}
+
+// newTLSTestPeer returns a test peer running the TLS handshake with config
+// on every connection.
+func newTLSTestPeer(t testing.TB, config *tls.Config) *testPeer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	return listenPeer(t, tls.NewListener(ln, config))
+}
+
+// listenPeer returns a test peer accepting connections on ln.
+func listenPeer(t testing.TB, ln net.Listener) *testPeer {
+	p := &testPeer{
+		t:     t,
+		ln:    ln,
+		node:  message.Node{OriginHost: "peer.example.com", OriginRealm: "example.com", ProductName: "test peer"},
+		conns: make(chan net.Conn, 8),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				close(p.conns)
+				return
+			}
+			p.mu.Lock()
+			p.all = append(p.all, conn)
+			p.mu.Unlock()
+			// Connect returns once the handshake is done, so the peer
+			// runs it before the test gets the connection.
+			if tc, ok := conn.(*tls.Conn); ok {
+				tc.SetDeadline(time.Now().Add(testTimeout))
+				tc.Handshake()
+				tc.SetDeadline(time.Time{})
+			}
+			p.conns <- conn
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for _, conn := range p.all {
+			conn.Close()
+		}
+	})
+	return p
+}
+
+func (p *testPeer) addr() string {
+	return p.ln.Addr().String()
+}
+
+// accept returns the next connection of the client.
+func (p *testPeer) accept() net.Conn {
+	p.t.Helper()
+	select {
+	case conn, ok := <-p.conns:
+		if !ok {
+			p.t.Fatal("listener closed")
+		}
+		return conn
+	case <-time.After(testTimeout):
+		p.t.Fatal("timed out waiting for a connection")
+	}
+	return nil
+}
+
+// read returns the next message on conn.
+func (p *testPeer) read(conn net.Conn) *message.DiameterMessage {
+	p.t.Helper()
+	msg, err := readTestMessage(conn)
+	if err != nil {
+		p.t.Fatalf("reading message: %v", err)
+	}
+	return msg
+}
+
+// readTestMessage reads one message from conn within testTimeout.
+func readTestMessage(conn net.Conn) (*message.DiameterMessage, error) {
+	conn.SetReadDeadline(time.Now().Add(testTimeout))
+	header := make([]byte, message.DIAMETER_HEADER_SIZE)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header) & 0xffffff
+	frame := make([]byte, length)
+	copy(frame, header)
+	if _, err := io.ReadFull(conn, frame[message.DIAMETER_HEADER_SIZE:]); err != nil {
+		return nil, err
+	}
+	return message.DecodeMessage(frame)
+}
+
+// write sends msg on conn.
+func (p *testPeer) write(conn net.Conn, msg *message.DiameterMessage) {
+	p.t.Helper()
+	data, err := msg.Encode()
+	if err != nil {
+		p.t.Fatalf("encoding %s: %v", msg.CommandName(), err)
+	}
+	if _, err := conn.Write(data); err != nil {
+		p.t.Fatalf("writing %s: %v", msg.CommandName(), err)
+	}
+}
+
+// exchange answers the CER of the client on conn, offering the
+// applications it asked for.
+func (p *testPeer) exchange(conn net.Conn) {
+	p.t.Helper()
+	cer := p.read(conn)
+	if cer.Header.CommandCode != message.COMMAND_CODE_CER {
+		p.t.Fatalf("first message is %s, want CER", cer.CommandName())
+	}
+	cea, err := p.node.BuildCEA(cer, message.ParseApplications(cer), message.DIAMETER_SUCCESS)
+	if err != nil {
+		p.t.Fatalf("building CEA: %v", err)
+	}
+	p.write(conn, cea)
+}
+
+// answer sends the DIAMETER_SUCCESS answer to req on conn.
+func (p *testPeer) answer(conn net.Conn, req *message.DiameterMessage) {
+	p.t.Helper()
+	ans, err := p.node.BuildAnswer(req, message.DIAMETER_SUCCESS)
+	if err != nil {
+		p.t.Fatalf("building answer: %v", err)
+	}
+	p.write(conn, ans)
+}
+
+// newTestClient returns a client of addr supporting the Diameter Credit
+// Control application, closed when the test ends.
+func newTestClient(t testing.TB, addr string, opts ...ClientOptionsFunc) *Client {
+	t.Helper()
+	defaults := []ClientOptionsFunc{
+		WithServerAddr(addr),
+		WithOriginHost("client.example.com"),
+		WithOriginRealm("example.com"),
+		WithAuthApplications(message.APPLICATION_ID_CREDIT_CONTROL),
+	}
+	c, err := NewClient(append(defaults, opts...)...)
+	if err != nil {
+		t.Fatalf("creating client: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// connect connects c to the peer and waits for it to reach I-Open. It
+// returns the connection of the peer.
+func (p *testPeer) connect(c *Client) net.Conn {
+	p.t.Helper()
+	if err := c.Connect(); err != nil {
+		p.t.Fatalf("connecting: %v", err)
+	}
+	conn := p.accept()
+	p.exchange(conn)
+	waitReady(p.t, c)
+	return conn
+}
+
+// waitReady waits for c to reach I-Open.
+func waitReady(t testing.TB, c *Client) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := c.WaitReady(ctx); err != nil {
+		t.Fatalf("waiting for I-Open: %v", err)
+	}
+}
+
+// newTestCCR returns a CCR of c for session.
+func newTestCCR(t testing.TB, c *Client, session string) *message.DiameterMessage {
+	t.Helper()
+	var b message.MessageBuilder
+	avps, err := b.Add(message.AVP_SESSION_ID, session, message.MANDATORY_FLAG).
+		Add(message.AVP_ORIGIN_HOST, c.originHost, message.MANDATORY_FLAG).
+		Add(message.AVP_ORIGIN_REALM, c.originRealm, message.MANDATORY_FLAG).
+		Add(message.AVP_DESTINATION_REALM, "example.com", message.MANDATORY_FLAG).
+		Add(message.AVP_AUTH_APPLICATION_ID, message.APPLICATION_ID_CREDIT_CONTROL, message.MANDATORY_FLAG).
+		AVPs()
+	if err != nil {
+		t.Fatalf("building CCR AVPs: %v", err)
+	}
+	req, err := c.NewRequest(message.COMMAND_CODE_CREDIT_CONTROL, message.WithAVPs(avps...))
+	if err != nil {
+		t.Fatalf("building CCR: %v", err)
+	}
+	return req
+}
+
+// eventually waits for cond to hold, failing the test with what after
+// testTimeout.
+func eventually(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
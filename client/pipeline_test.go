@@ -0,0 +1,86 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+// echoAnswers answers every request read from conn after the capabilities
+// exchange by sending it back with the 'R' bit cleared, which is enough
+// for the client to correlate it and cheap enough for the peer not to be
+// the bottleneck.
+func echoAnswers(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	var header [message.DIAMETER_HEADER_SIZE]byte
+	var frame []byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return
+		}
+		length := int(binary.BigEndian.Uint32(header[:]) & 0xffffff)
+		if cap(frame) < length {
+			frame = make([]byte, length)
+		}
+		frame = frame[:length]
+		copy(frame, header[:])
+		if _, err := io.ReadFull(r, frame[len(header):]); err != nil {
+			return
+		}
+		frame[4] &^= byte(message.FlagRequest)
+		if _, err := w.Write(frame); err != nil {
+			return
+		}
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// BenchmarkClientPipelined measures requests and answers per second over
+// one connection with a number of goroutines sending requests at once,
+// each waiting for its answer before sending the next.
+func BenchmarkClientPipelined(b *testing.B) {
+	for _, concurrency := range []int{1, 16, 256} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			peer := newTestPeer(b)
+			c := newTestClient(b, peer.addr(), WithWatchdogTTL(time.Hour))
+			conn := peer.connect(c)
+			go echoAnswers(conn)
+
+			reqs := make(chan struct{})
+			var wg sync.WaitGroup
+			for range concurrency {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range reqs {
+						req := newTestCCR(b, c, "client.example.com;1;1")
+						if _, err := c.Request(context.Background(), req); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				}()
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				reqs <- struct{}{}
+			}
+			close(reqs)
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,112 @@
+// Multi-peer client with failover
+package client
+
+import (
+	"context"
+	"sync"
+
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+// Pool sends requests over a set of peers in failover order. The first
+// peer whose watchdog reports it as available is used; SUSPECT, DOWN and
+// REOPEN peers are skipped until they recover. Peers demoted for being slow,
+// see WithSlowPeerThreshold, or cooling down after disconnecting as BUSY,
+// see WithBusyCooldown, are only used when no other peer is available.
+type Pool struct {
+	mu    sync.RWMutex
+	peers []*Client
+	// affinity is nil unless session affinity is enabled.
+	affinity *affinity
+}
+
+// NewPool creates a Pool from the given clients, in failover order.
+func NewPool(peers ...*Client) *Pool {
+	return &Pool{peers: peers}
+}
+
+// Add appends a peer at the end of the failover order.
+func (p *Pool) Add(c *Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.peers = append(p.peers, c)
+}
+
+// Peers returns the status of every peer in failover order.
+func (p *Pool) Peers() []PeerStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	statuses := make([]PeerStatus, 0, len(p.peers))
+	for _, c := range p.peers {
+		statuses = append(statuses, c.PeerStatus())
+	}
+	return statuses
+}
+
+// Pick returns the first available peer.
+func (p *Pool) Pick() (*Client, error) {
+	return p.pick(func(*Client) bool { return true })
+}
+
+// PickFor returns the first available peer that negotiated the
+// application of msg.
+func (p *Pool) PickFor(msg *message.DiameterMessage) (*Client, error) {
+	return p.pick(func(c *Client) bool { return c.Supports(msg) })
+}
+
+// route returns the peer msg is to be sent to: the peer its session is
+// bound to with session affinity, the first available peer supporting
+// its application otherwise.
+func (p *Pool) route(msg *message.DiameterMessage) (*Client, error) {
+	if a := p.getAffinity(); a != nil && msg.IsRequest() {
+		if id, err := message.GetSessionID(msg); err == nil {
+			return p.pickSession(a, id, msg)
+		}
+	}
+	return p.PickFor(msg)
+}
+
+// pick returns the first available peer accepted by ok, trying demoted
+// peers last.
+func (p *Pool) pick(ok func(*Client) bool) (*Client, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var demoted *Client
+	for _, c := range p.peers {
+		// The watchdog is asked directly since a full PeerStatus also
+		// summarizes the latency histograms.
+		if c.watchdog.Status() != WatchdogOkay || !ok(c) {
+			continue
+		}
+		if !c.demoted() {
+			return c, nil
+		}
+		if demoted == nil {
+			demoted = c
+		}
+	}
+	if demoted != nil {
+		return demoted, nil
+	}
+	return nil, ErrNoAvailablePeer
+}
+
+// SendMessage sends msg to the first available peer supporting its
+// application, or to the peer its session is bound to, see
+// SetSessionAffinity.
+func (p *Pool) SendMessage(msg *message.DiameterMessage) error {
+	c, err := p.route(msg)
+	if err != nil {
+		return err
+	}
+	return c.SendMessage(msg)
+}
+
+// Request sends req like SendMessage and waits for the answer.
+func (p *Pool) Request(ctx context.Context, req *message.DiameterMessage) (*message.DiameterMessage, error) {
+	c, err := p.route(req)
+	if err != nil {
+		return nil, err
+	}
+	return c.Request(ctx, req)
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/transport"
+)
+
+func TestQueueSizes(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		opts          []ClientOptionsFunc
+		queue, events int
+	}{
+		{"unset", nil, messageQueueSize, eventBufferSize},
+		{"zero", []ClientOptionsFunc{WithMessageQueueSize(0), WithEventBufferSize(0)}, messageQueueSize, eventBufferSize},
+		{"one", []ClientOptionsFunc{WithMessageQueueSize(1), WithEventBufferSize(1)}, 1, 1},
+		{"large", []ClientOptionsFunc{WithMessageQueueSize(1000), WithEventBufferSize(500)}, 1000, 500},
+	} {
+		c := newTestClient(t, "127.0.0.1:3868", tc.opts...)
+		if cap(c.messageQueue) != tc.queue || cap(c.EventChan) != tc.events {
+			t.Errorf("%s: queue %d and event buffer %d, want %d and %d",
+				tc.name, cap(c.messageQueue), cap(c.EventChan), tc.queue, tc.events)
+		}
+	}
+
+	for _, opt := range []ClientOptionsFunc{WithMessageQueueSize(-1), WithEventBufferSize(-1)} {
+		_, err := NewClient(WithServerAddr("127.0.0.1:3868"), WithOriginHost("client.example.com"),
+			WithOriginRealm("example.com"), opt)
+		if err == nil || !strings.Contains(err.Error(), "must not be negative") {
+			t.Errorf("negative size: %v, want a validation error", err)
+		}
+	}
+}
+
+// TestQueueSizeOne sends messages one after the other from a single
+// goroutine through a queue and an event buffer of one: each must reach
+// the peer without the sender waiting on itself.
+func TestQueueSizeOne(t *testing.T) {
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithMessageQueueSize(1), WithEventBufferSize(1), WithWatchdogTTL(time.Hour))
+	conn := peer.connect(c)
+
+	const n = 20
+	sent := make(chan error, 1)
+	go func() {
+		for range n {
+			if err := c.SendMessage(newTestCCR(t, c, "client.example.com;1;1")); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- nil
+	}()
+	for i := range n {
+		if req := peer.read(conn); req.Header.CommandCode != message.COMMAND_CODE_CREDIT_CONTROL {
+			t.Fatalf("message %d: read %s", i, req.CommandName())
+		}
+	}
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("SendMessage blocked")
+	}
+}
+
+// TestSendMessageDuringPeerDown sends from several goroutines while the
+// peer is declared down, which empties the queue; run with -race. Every
+// call must return, either queued or with ErrPeerDown.
+func TestSendMessageDuringPeerDown(t *testing.T) {
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithMessageQueueSize(1), WithEventBufferSize(1), WithWatchdogTTL(time.Hour))
+	conn := peer.connect(c)
+	go func() {
+		for {
+			if _, err := readTestMessage(conn); err != nil {
+				return
+			}
+		}
+	}()
+
+	const senders, perSender = 8, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, senders*perSender)
+	for range senders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range perSender {
+				errs <- c.SendMessage(newTestCCR(t, c, "client.example.com;1;1"))
+			}
+		}()
+	}
+	c.peerDown(c.getConn(), transport.ErrPeerDown)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("SendMessage blocked after the peer went down")
+	}
+	close(errs)
+	for err := range errs {
+		if err != nil && !errors.Is(err, ErrPeerDown) {
+			t.Errorf("SendMessage = %v, want nil or ErrPeerDown", err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Reconnection after the peer disconnected
+package client
+
+import (
+	"log"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+const (
+	// DefaultTc is the connection retry interval Tc recommended by RFC 6733
+	// section 12, used after a peer disconnected because it was rebooting.
+	DefaultTc = 30 * time.Second
+	// defaultBusyReconnectDelay gives a busy peer time to shed load before
+	// it is connected to again.
+	defaultBusyReconnectDelay = 5 * time.Minute
+)
+
+// defaultReconnectDelays follows RFC 6733 section 5.4.3: a rebooting peer
+// is expected back, a busy one is left alone for longer and one that does
+// not want to talk to us is not reconnected to.
+func defaultReconnectDelays() map[uint32]time.Duration {
+	return map[uint32]time.Duration{
+		message.DISCONNECT_CAUSE_REBOOTING:                  DefaultTc,
+		message.DISCONNECT_CAUSE_BUSY:                       defaultBusyReconnectDelay,
+		message.DISCONNECT_CAUSE_DO_NOT_WANT_TO_TALK_TO_YOU: 0,
+	}
+}
+
+func (c *Client) setDisconnectCause(cause uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.disconnectCause = &cause
+}
+
+func (c *Client) lastDisconnectCause() *uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.disconnectCause == nil {
+		return nil
+	}
+	cause := *c.disconnectCause
+	return &cause
+}
+
+// setBusy arranges for the cool-down of WithBusyCooldown to start when
+// the peer is open again, if it disconnected as BUSY.
+func (c *Client) setBusy(cause uint32) {
+	if cause != message.DISCONNECT_CAUSE_BUSY || c.busyCooldown <= 0 {
+		return
+	}
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.busyReopen = true
+}
+
+// coolingDownUntil returns the end of the cool-down of a peer back from
+// a BUSY disconnection, or the zero time.
+func (c *Client) coolingDownUntil() time.Time {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.busyUntil
+}
+
+// scheduleReconnect arranges for the client to connect again after the
+// delay configured for cause, if any.
+func (c *Client) scheduleReconnect(cause uint32) {
+	delay := c.reconnectDelays[cause]
+	if delay <= 0 {
+		log.Printf("Not reconnecting to %s after Disconnect-Cause %d.", c.serverAddr, cause)
+		return
+	}
+	log.Printf("Reconnecting to %s in %v after Disconnect-Cause %d.", c.serverAddr, delay, cause)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.reconnectTimer != nil {
+		c.reconnectTimer.Stop()
+	}
+	c.reconnectTimer = c.clock.AfterFunc(delay, func() { c.reconnect(cause) })
+}
+
+// reconnect connects to the peer again, retrying after the same delay
+// while the peer cannot be reached. It gives up if the client was closed
+// or connected in the meantime.
+func (c *Client) reconnect(cause uint32) {
+	c.stateMu.Lock()
+	closed := c.closed
+	c.stateMu.Unlock()
+	if closed || c.fsm.GetState() != StateClosed {
+		return
+	}
+	if err := c.Connect(); err != nil {
+		log.Printf("Error reconnecting to %s: %v", c.serverAddr, err)
+		c.scheduleReconnect(cause)
+	}
+}
+
+// cancelReconnect stops a scheduled reconnection.
+func (c *Client) cancelReconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.reconnectTimer != nil {
+		c.reconnectTimer.Stop()
+		c.reconnectTimer = nil
+	}
+}
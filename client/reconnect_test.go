@@ -0,0 +1,243 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+// disconnect sends a DPR giving cause on conn and waits for the client to
+// answer it and schedule what follows.
+func disconnect(t *testing.T, peer *testPeer, conn net.Conn, c *Client, cause uint32) {
+	t.Helper()
+	dpr, err := peer.node.BuildDPR(cause)
+	if err != nil {
+		t.Fatalf("building DPR: %v", err)
+	}
+	peer.write(conn, dpr)
+	if dpa := peer.read(conn); dpa.Header.CommandCode != message.COMMAND_CODE_DISCONNECT_PEER || dpa.IsRequest() {
+		t.Fatalf("read %s, want DPA", dpa.CommandName())
+	}
+	eventually(t, "the client to close", func() bool { return c.fsm.GetState() == StateClosed })
+}
+
+// noConnection checks that the client has not connected to peer again.
+func noConnection(t *testing.T, peer *testPeer) {
+	t.Helper()
+	select {
+	case <-peer.conns:
+		t.Fatal("client reconnected")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestReconnectAfterRebooting(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithClock(clk))
+	conn := peer.connect(c)
+	disconnect(t, peer, conn, c, message.DISCONNECT_CAUSE_REBOOTING)
+	eventually(t, "a reconnection to be scheduled", func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.reconnectTimer != nil
+	})
+
+	clk.Advance(DefaultTc - time.Second)
+	noConnection(t, peer)
+	clk.Advance(time.Second)
+	peer.exchange(peer.accept())
+	waitReady(t, c)
+}
+
+func TestReconnectDelayPerCause(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithClock(clk), WithReconnectDelay(message.DISCONNECT_CAUSE_BUSY, time.Minute))
+	conn := peer.connect(c)
+	disconnect(t, peer, conn, c, message.DISCONNECT_CAUSE_BUSY)
+	eventually(t, "a reconnection to be scheduled", func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.reconnectTimer != nil
+	})
+
+	clk.Advance(DefaultTc)
+	noConnection(t, peer)
+	clk.Advance(time.Minute - DefaultTc)
+	peer.exchange(peer.accept())
+	waitReady(t, c)
+}
+
+func TestNoReconnectWhenNotWanted(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithClock(clk))
+	conn := peer.connect(c)
+	disconnect(t, peer, conn, c, message.DISCONNECT_CAUSE_DO_NOT_WANT_TO_TALK_TO_YOU)
+
+	clk.Advance(time.Hour)
+	noConnection(t, peer)
+	if cause := c.lastDisconnectCause(); cause == nil || *cause != message.DISCONNECT_CAUSE_DO_NOT_WANT_TO_TALK_TO_YOU {
+		t.Errorf("last Disconnect-Cause = %v", cause)
+	}
+}
+
+func TestReconnectRetriesUnreachablePeer(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithClock(clk))
+	conn := peer.connect(c)
+	disconnect(t, peer, conn, c, message.DISCONNECT_CAUSE_REBOOTING)
+	eventually(t, "a reconnection to be scheduled", func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.reconnectTimer != nil
+	})
+	// Nothing listens on the address while the peer reboots.
+	addr := peer.addr()
+	peer.ln.Close()
+	clk.Advance(DefaultTc)
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("cannot listen on %s again: %v", addr, err)
+	}
+	defer ln.Close()
+	clk.Advance(DefaultTc)
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	select {
+	case conn := <-accepted:
+		defer conn.Close()
+		peer.exchange(conn)
+		waitReady(t, c)
+	case <-time.After(testTimeout):
+		t.Fatal("client did not retry after Tc")
+	}
+}
+
+func TestCloseCancelsReconnect(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithClock(clk))
+	conn := peer.connect(c)
+	disconnect(t, peer, conn, c, message.DISCONNECT_CAUSE_REBOOTING)
+	eventually(t, "a reconnection to be scheduled", func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.reconnectTimer != nil
+	})
+
+	c.Close()
+	clk.Advance(DefaultTc)
+	noConnection(t, peer)
+}
+
+// TestReconnectDefaultsPerCause drives each Disconnect-Cause with the
+// default delays and checks the cause published in the state change and
+// PeerStatus, and when the client dials again, if ever.
+func TestReconnectDefaultsPerCause(t *testing.T) {
+	for _, tc := range []struct {
+		cause uint32
+		// delay is 0 when the client must not reconnect.
+		delay time.Duration
+	}{
+		{message.DISCONNECT_CAUSE_REBOOTING, DefaultTc},
+		{message.DISCONNECT_CAUSE_BUSY, 5 * time.Minute},
+		{message.DISCONNECT_CAUSE_DO_NOT_WANT_TO_TALK_TO_YOU, 0},
+	} {
+		t.Run(message.DisconnectCauseName(tc.cause), func(t *testing.T) {
+			clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+			peer := newTestPeer(t)
+			c := newTestClient(t, peer.addr(), WithClock(clk))
+			if cause := c.PeerStatus().DisconnectCause; cause != nil {
+				t.Errorf("DisconnectCause %d before any DPR", *cause)
+			}
+			conn := peer.connect(c)
+			transitions(t, c, 3)
+			disconnect(t, peer, conn, c, tc.cause)
+
+			down := transitions(t, c, 1)[0]
+			if down.DisconnectCause == nil || *down.DisconnectCause != tc.cause {
+				t.Errorf("state change DisconnectCause = %v, want %d", down.DisconnectCause, tc.cause)
+			}
+			if cause := c.PeerStatus().DisconnectCause; cause == nil || *cause != tc.cause {
+				t.Errorf("PeerStatus DisconnectCause = %v, want %d", cause, tc.cause)
+			}
+
+			if tc.delay == 0 {
+				clk.Advance(24 * time.Hour)
+				noConnection(t, peer)
+				return
+			}
+			eventually(t, "a reconnection to be scheduled", func() bool {
+				c.mu.Lock()
+				defer c.mu.Unlock()
+				return c.reconnectTimer != nil
+			})
+			clk.Advance(tc.delay - time.Second)
+			noConnection(t, peer)
+			clk.Advance(time.Second)
+			peer.exchange(peer.accept())
+			waitReady(t, c)
+		})
+	}
+}
+
+// TestBusyCooldown disconnects the first peer of a pool as BUSY and checks
+// that the client waits before reconnecting, that traffic goes to the
+// second peer meanwhile and during the cool-down, and that the first peer
+// is readmitted once the cool-down ends.
+func TestBusyCooldown(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	peer1, peer2 := newTestPeer(t), newTestPeer(t)
+	// The watchdog of the first client stays quiet while the clock moves.
+	c1 := newTestClient(t, peer1.addr(), WithClock(clk), WithWatchdogTTL(time.Hour),
+		WithReconnectDelay(message.DISCONNECT_CAUSE_BUSY, time.Minute), WithBusyCooldown(10*time.Minute))
+	c2 := newTestClient(t, peer2.addr())
+	conn1 := peer1.connect(c1)
+	reqs2 := serveRecorded(peer2, peer2.connect(c2))
+	pool := NewPool(c1, c2)
+	pick := func(want *Client, when string) {
+		t.Helper()
+		if got, err := pool.Pick(); err != nil || got != want {
+			t.Errorf("%s: Pick() = %p, %v; want %p", when, got, err, want)
+		}
+	}
+	pick(c1, "before the DPR")
+
+	disconnect(t, peer1, conn1, c1, message.DISCONNECT_CAUSE_BUSY)
+	pick(c2, "after the DPR")
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if _, err := pool.Request(ctx, newTestCCR(t, c2, "client.example.com;1;1")); err != nil {
+		t.Fatalf("request failed over: %v", err)
+	}
+	nextRequest(t, reqs2)
+	eventually(t, "a reconnection to be scheduled", func() bool {
+		c1.mu.Lock()
+		defer c1.mu.Unlock()
+		return c1.reconnectTimer != nil
+	})
+	clk.Advance(time.Minute - time.Second)
+	noConnection(t, peer1)
+	clk.Advance(time.Second)
+	peer1.exchange(peer1.accept())
+	waitReady(t, c1)
+
+	if until, want := c1.PeerStatus().BusyUntil, clk.Now().Add(10*time.Minute); !until.Equal(want) {
+		t.Errorf("BusyUntil %v, want %v", until, want)
+	}
+	pick(c2, "during the cool-down")
+	clk.Advance(10 * time.Minute)
+	pick(c1, "after the cool-down")
+}
@@ -0,0 +1,147 @@
+// Make-before-break replacement of the peer connection
+package client
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/tap"
+	"github.com/IbrahimShahzad/diameter/transport"
+)
+
+// rotateDrainInterval is how often Rotate checks whether the requests sent
+// on the retired connection have been answered.
+const rotateDrainInterval = 10 * time.Millisecond
+
+// retiredConn is the connection Rotate moved new requests away from. It
+// stays open until the requests sent on it have been answered.
+type retiredConn struct {
+	conn   *transport.DiameterConnection
+	writer *transport.BatchWriter
+}
+
+// Rotate replaces the connection to the peer without failing requests, for
+// instance ahead of planned maintenance. A new connection is opened and
+// goes through the capabilities exchange, new requests are sent on it and
+// the old connection is closed with a DPR once every request sent on it
+// has been answered. Answers are read from the connection their request
+// went out on throughout the overlap. ctx bounds the whole rotation: when
+// it ends before the old connection is drained, the requests still
+// outstanding on it fail as it is closed.
+func (c *Client) Rotate(ctx context.Context) error {
+	c.rotate.Lock()
+	defer c.rotate.Unlock()
+	if c.fsm.GetState() != StateIOpen {
+		return ErrNotConnected
+	}
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	if err := c.exchangeCapabilities(ctx, conn); err != nil {
+		conn.Close()
+		return err
+	}
+	old := c.retire(conn)
+	go c.readLoop(conn)
+	log.Printf("Moved traffic to %s onto a new connection, draining the old one.", c.serverAddr)
+
+	err = c.drain(ctx, old.conn)
+	if err == nil {
+		err = c.disconnectRetired(ctx, old)
+	}
+	c.mu.Lock()
+	c.retired = nil
+	c.mu.Unlock()
+	old.writer.Close()
+	old.conn.Close()
+	return err
+}
+
+// exchangeCapabilities sends a CER on conn and reads the CEA before any
+// other message uses conn.
+func (c *Client) exchangeCapabilities(ctx context.Context, conn *transport.DiameterConnection) error {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	cer, err := c.newCER()
+	if err != nil {
+		return err
+	}
+	encoded, err := cer.Encode()
+	if err != nil {
+		return err
+	}
+	c.tap.Observe(tap.Outbound, c.serverAddr, encoded, cer)
+	if _, err := conn.Write(encoded); err != nil {
+		return contextOr(ctx, err)
+	}
+	frame, err := conn.ReadFrame()
+	if err != nil {
+		return contextOr(ctx, err)
+	}
+	cea, err := message.DecodeMessage(frame, message.WithDecodeOptions(c.decodeOptions))
+	if err != nil {
+		return err
+	}
+	c.tap.Observe(tap.Inbound, c.serverAddr, frame, cea)
+	return c.handleCEA(conn, cea)
+}
+
+// contextOr returns the error of ctx if it has ended, err otherwise.
+func contextOr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// retire makes conn the current connection and keeps the previous one
+// open as the retired connection.
+func (c *Client) retire(conn *transport.DiameterConnection) *retiredConn {
+	writer := c.newWriter(conn)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.retired = &retiredConn{conn: c.conn, writer: c.writer}
+	c.conn = conn
+	c.writer = writer
+	return c.retired
+}
+
+// drain waits until no request sent on conn is outstanding.
+func (c *Client) drain(ctx context.Context, conn *transport.DiameterConnection) error {
+	for c.pending.LenOwner(conn) > 0 {
+		select {
+		case <-c.clock.After(rotateDrainInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// disconnectRetired sends a DPR on the retired connection and waits for
+// the DPA.
+func (c *Client) disconnectRetired(ctx context.Context, old *retiredConn) error {
+	log.Println("Sending Disconnect-Peer-Request (DPR) on the retired connection.")
+	dpr, err := c.node().BuildDPR(message.DISCONNECT_CAUSE_REBOOTING, message.WithIDGenerator(c.idGenerator))
+	if err != nil {
+		return err
+	}
+	ch, err := c.pending.ReserveFor(old.conn, dpr, c.idGenerator.HopByHopID, 0)
+	if err != nil {
+		return err
+	}
+	if err := c.writeTo(old.writer, dpr); err != nil {
+		c.pending.Remove(dpr.Header.HopByHopID)
+		return err
+	}
+	select {
+	case r := <-ch:
+		return r.Err
+	case <-ctx.Done():
+		c.pending.Remove(dpr.Header.HopByHopID)
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/tap"
+)
+
+// TestRotateUnderLoad rotates the connection while requests are sent
+// continuously, some of them answered slowly so that they are outstanding
+// on the old connection during the swap, and checks that none fails.
+func TestRotateUnderLoad(t *testing.T) {
+	var dprs atomic.Int32
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithMessageTap(func(direction tap.Direction, _ string, _ []byte, msg *message.DiameterMessage) {
+		if direction == tap.Outbound && msg.Header.CommandCode == message.COMMAND_CODE_DISCONNECT_PEER {
+			dprs.Add(1)
+		}
+	}))
+	oldConn := peer.connect(c)
+	oldDone := make(chan struct{})
+	go func() {
+		serveDelayed(peer, oldConn)
+		close(oldDone)
+	}()
+
+	var (
+		stop     atomic.Bool
+		sent     atomic.Int32
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failures []error
+	)
+	for w := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; !stop.Load(); i++ {
+				session := fmt.Sprintf("client.example.com;%d;%d", w, i)
+				if i%5 == 0 {
+					session += ";slow"
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+				_, err := c.Request(ctx, newTestCCR(t, c, session))
+				cancel()
+				sent.Add(1)
+				if err != nil {
+					mu.Lock()
+					failures = append(failures, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	eventually(t, "requests sent before the rotation", func() bool { return sent.Load() >= 20 })
+
+	rotated := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+		rotated <- c.Rotate(ctx)
+	}()
+	newConn := peer.accept()
+	peer.exchange(newConn)
+	go serveDelayed(peer, newConn)
+	if err := <-rotated; err != nil {
+		t.Errorf("Rotate: %v", err)
+	}
+	select {
+	case <-oldDone:
+	case <-time.After(testTimeout):
+		t.Fatal("old connection still served after the rotation")
+	}
+	if n := dprs.Load(); n != 1 {
+		t.Errorf("%d DPRs sent, want 1 on the old connection", n)
+	}
+
+	after := sent.Load()
+	eventually(t, "requests sent after the rotation", func() bool { return sent.Load() >= after+20 })
+	stop.Store(true)
+	wg.Wait()
+
+	if len(failures) != 0 {
+		t.Errorf("%d of %d requests failed, first: %v", len(failures), sent.Load(), failures[0])
+	}
+	if state := c.fsm.GetState(); state != StateIOpen {
+		t.Errorf("client in state %v after the rotation", state)
+	}
+}
+
+func TestRotateNotConnected(t *testing.T) {
+	c := newTestClient(t, newTestPeer(t).addr())
+	if err := c.Rotate(context.Background()); err != ErrNotConnected {
+		t.Errorf("Rotate() = %v, want ErrNotConnected", err)
+	}
+}
@@ -0,0 +1,183 @@
+// Connection state change notifications
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	fsm "github.com/IbrahimShahzad/diameter/state"
+)
+
+// stateChangeBufferSize is the number of state changes kept for a slow
+// consumer of StateChanges before further changes are dropped.
+const stateChangeBufferSize = 32
+
+// StateChange describes a change of the connection to the peer: either an
+// FSM transition, in which case From and To differ, or a change of the
+// watchdog state within I-Open.
+type StateChange struct {
+	From     fsm.State
+	To       fsm.State
+	Watchdog WatchdogState
+	Time     time.Time
+	// Reason is a short description of what caused the change.
+	Reason string
+	// Err is the error behind the change, if any: a failed dial, a
+	// rejected capabilities exchange or a DisconnectError.
+	Err error
+	// DisconnectCause is the Disconnect-Cause of the DPR behind the
+	// change, when Err is a DisconnectError.
+	DisconnectCause *uint32
+}
+
+// DisconnectError reports a Disconnect-Peer-Request received from the
+// peer.
+type DisconnectError struct {
+	Cause uint32
+}
+
+func (e *DisconnectError) Error() string {
+	return "peer disconnected: " + message.DisconnectCauseName(e.Cause)
+}
+
+var eventReasons = map[fsm.Event]string{
+	EventStart:          "connecting",
+	EventConnAck:        "transport connected",
+	EventConnNack:       "connection failed",
+	EventCEAReceived:    "capabilities exchanged",
+	EventNonCEAReceived: "capabilities exchange failed",
+	EventTimeout:        "timeout",
+	EventDisconnect:     "disconnect requested",
+	EventReceiveDPR:     "disconnected by peer",
+	EventReceiveDPA:     "disconnected",
+	EventPeerDisc:       "peer down",
+}
+
+// StateChanges returns the channel on which state changes are published.
+// Changes are dropped, and counted by DroppedStateChanges, when the
+// consumer falls behind. The channel is closed by Close.
+func (c *Client) StateChanges() <-chan StateChange {
+	return c.stateChanges
+}
+
+// DroppedStateChanges returns the number of state changes dropped because
+// the StateChanges channel was full.
+func (c *Client) DroppedStateChanges() uint64 {
+	return c.droppedStateChanges.Load()
+}
+
+// setCause records err as the cause of the next FSM transition.
+func (c *Client) setCause(err error) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.cause = err
+}
+
+// onStateChange publishes an FSM transition. It runs with the FSM locked.
+func (c *Client) onStateChange(from, to fsm.State, event fsm.Event, at time.Time) {
+	c.stateMu.Lock()
+	err := c.cause
+	c.cause = nil
+	c.state = to
+	if to == StateIOpen && c.busyReopen {
+		c.busyReopen = false
+		c.busyUntil = at.Add(c.busyCooldown)
+	}
+	c.stateMu.Unlock()
+	var cause *uint32
+	var disconnect *DisconnectError
+	if errors.As(err, &disconnect) {
+		value := disconnect.Cause
+		cause = &value
+	}
+	c.publish(StateChange{
+		From:            from,
+		To:              to,
+		Watchdog:        c.watchdog.Status(),
+		Time:            at,
+		Reason:          eventReasons[event],
+		Err:             err,
+		DisconnectCause: cause,
+	})
+}
+
+// onWatchdogChange publishes a watchdog state change. It runs with the
+// watchdog locked, so the watchdog state is passed in and the FSM state is
+// the one last published, since FSM actions lock the watchdog in turn. A
+// cause set for a transport failure is reported with it.
+func (c *Client) onWatchdogChange(state WatchdogState, reason string) {
+	c.stateMu.Lock()
+	current := c.state
+	err := c.cause
+	c.cause = nil
+	c.stateMu.Unlock()
+	c.publish(StateChange{
+		From:     current,
+		To:       current,
+		Watchdog: state,
+		Time:     c.clock.Now(),
+		Reason:   reason,
+		Err:      err,
+	})
+}
+
+// publish wakes the callers of WaitReady and hands change to the
+// StateChanges consumer without blocking.
+func (c *Client) publish(change StateChange) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	close(c.stateChanged)
+	c.stateChanged = make(chan struct{})
+	if c.closed {
+		return
+	}
+	select {
+	case c.stateChanges <- change:
+	default:
+		c.droppedStateChanges.Add(1)
+	}
+}
+
+// WaitReady blocks until the capabilities exchange started by Connect has
+// completed and the client is in I-Open. It returns ErrNotConnected if the
+// connection attempt ended in Closed instead.
+func (c *Client) WaitReady(ctx context.Context) error {
+	for {
+		c.stateMu.Lock()
+		changed := c.stateChanged
+		c.stateMu.Unlock()
+		switch c.fsm.GetState() {
+		case StateIOpen:
+			return nil
+		case StateClosed:
+			return ErrNotConnected
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close shuts the client down for good: the watchdog is stopped, the
+// connection closed without a DPR, the final statistics dumped, the frames
+// queued for the message tap delivered and the StateChanges channel
+// closed. Use Disconnect first for an orderly
+// shutdown.
+func (c *Client) Close() error {
+	c.cancelReconnect()
+	c.watchdog.stop()
+	c.closeConn()
+	c.dumper.Stop()
+	c.tap.Close()
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.stateChanges)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+	"github.com/IbrahimShahzad/diameter/message"
+	fsm "github.com/IbrahimShahzad/diameter/state"
+)
+
+// transitions reads the state changes of c until it has seen n FSM
+// transitions, and returns them, skipping watchdog changes.
+func transitions(t *testing.T, c *Client, n int) []StateChange {
+	t.Helper()
+	var got []StateChange
+	for len(got) < n {
+		select {
+		case change, ok := <-c.StateChanges():
+			if !ok {
+				t.Fatalf("state changes closed after %v", got)
+			}
+			if change.From != change.To {
+				got = append(got, change)
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("timed out after state changes %v", got)
+		}
+	}
+	return got
+}
+
+// states returns the states changes lead to.
+func states(changes []StateChange) []fsm.State {
+	var s []fsm.State
+	for _, change := range changes {
+		s = append(s, change.To)
+	}
+	return s
+}
+
+func TestStateChangesConnectDisconnectReconnect(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithClock(clk))
+
+	conn := peer.connect(c)
+	connected := transitions(t, c, 3)
+	if want := []fsm.State{StateWaitConnAck, StateWaitCEA, StateIOpen}; !slices.Equal(states(connected), want) {
+		t.Errorf("connecting went through %v, want %v", states(connected), want)
+	}
+	for _, change := range connected {
+		if !change.Time.Equal(clk.Now()) || change.Err != nil {
+			t.Errorf("change %+v, want one at %v without error", change, clk.Now())
+		}
+	}
+	if connected[2].Reason != "capabilities exchanged" {
+		t.Errorf("I-Open reached with reason %q", connected[2].Reason)
+	}
+
+	disconnect(t, peer, conn, c, message.DISCONNECT_CAUSE_REBOOTING)
+	down := transitions(t, c, 1)[0]
+	var dpr *DisconnectError
+	if down.To != StateClosed || !errors.As(down.Err, &dpr) || dpr.Cause != message.DISCONNECT_CAUSE_REBOOTING {
+		t.Errorf("DPR from the peer published as %+v", down)
+	}
+	if down.DisconnectCause == nil || *down.DisconnectCause != message.DISCONNECT_CAUSE_REBOOTING {
+		t.Errorf("DisconnectCause = %v, want REBOOTING", down.DisconnectCause)
+	}
+
+	eventually(t, "a reconnection to be scheduled", func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.reconnectTimer != nil
+	})
+	clk.Advance(DefaultTc)
+	peer.exchange(peer.accept())
+	waitReady(t, c)
+	if got, want := states(transitions(t, c, 3)), []fsm.State{StateWaitConnAck, StateWaitCEA, StateIOpen}; !slices.Equal(got, want) {
+		t.Errorf("reconnecting went through %v, want %v", got, want)
+	}
+
+	c.Close()
+	eventually(t, "the state changes to be closed", func() bool {
+		for {
+			select {
+			case _, ok := <-c.StateChanges():
+				if !ok {
+					return true
+				}
+			default:
+				return false
+			}
+		}
+	})
+}
+
+func TestStateChangesDialFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	c := newTestClient(t, addr)
+	if err := c.Connect(); err == nil {
+		t.Fatal("Connect to a closed port succeeded")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := c.WaitReady(ctx); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("WaitReady = %v, want ErrNotConnected", err)
+	}
+	got := transitions(t, c, 2)
+	if want := []fsm.State{StateWaitConnAck, StateClosed}; !slices.Equal(states(got), want) {
+		t.Errorf("failed dial went through %v, want %v", states(got), want)
+	}
+	if got[1].Err == nil || got[1].Reason != "connection failed" {
+		t.Errorf("failed dial published as %+v", got[1])
+	}
+}
+
+func TestWaitReadyContext(t *testing.T) {
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr())
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	// The CER is never answered.
+	peer.read(peer.accept())
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := c.WaitReady(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitReady = %v, want DeadlineExceeded", err)
+	}
+}
+
+// TestStateChangesDropped checks that a consumer that does not keep up
+// loses changes instead of blocking the client.
+func TestStateChangesDropped(t *testing.T) {
+	c := newTestClient(t, "127.0.0.1:3868")
+	const extra = 5
+	for range stateChangeBufferSize + extra {
+		c.publish(StateChange{From: StateClosed, To: StateWaitConnAck})
+	}
+	if got := c.DroppedStateChanges(); got != extra {
+		t.Errorf("DroppedStateChanges = %d, want %d", got, extra)
+	}
+	if got := len(c.StateChanges()); got != stateChangeBufferSize {
+		t.Errorf("%d state changes buffered, want %d", got, stateChangeBufferSize)
+	}
+}
@@ -2,6 +2,7 @@
 package client
 
 import (
+	"context"
 	"log"
 
 	"github.com/IbrahimShahzad/diameter/message"
@@ -33,14 +34,28 @@ const (
 	EventDisconnect
 	EventReceiveDPR
 	EventReceiveDPA
+	// EventPeerDisc is fired when writes to the peer keep timing out or
+	// the connection breaks under a write.
+	EventPeerDisc
 )
 
 // InitializeFSM sets up the client FSM with specific states, events, and actions.
+// The connect flow follows RFC 6733 section 5.6: Start moves the client to
+// Wait-Conn-Ack while the transport is dialed, a successful dial fires
+// EventConnAck which sends the CER and waits for the CEA, and a failed dial
+// fires EventConnNack back to Closed.
+//
+// Actions run with the FSM locked and must not trigger further events.
+// They reach the connection through the client rather than a context
+// value, and those writing to the peer return ErrNotConnected when there
+// is none, so an event triggered without a connection fails cleanly.
 func (c *Client) InitializeFSM() {
 	c.fsm = fsm.NewFSM(StateClosed)
+	c.fsm.SetClock(c.clock)
+	c.fsm.OnChange(c.onStateChange)
 
 	// State: Closed
-	c.fsm.AddTransition(StateClosed, StateWaitConnAck, EventStart, c.sendConnRequest)
+	c.fsm.AddTransition(StateClosed, StateWaitConnAck, EventStart, nil)
 
 	// State: Wait-Conn-Ack
 	c.fsm.AddTransition(StateWaitConnAck, StateWaitCEA, EventConnAck, c.sendCER)
@@ -56,107 +71,126 @@ func (c *Client) InitializeFSM() {
 
 	c.fsm.AddTransition(StateWaitCEA, StateClosed, EventNonCEAReceived, c.cleanup)
 	c.fsm.AddTransition(StateWaitCEA, StateClosed, EventTimeout, c.cleanup)
+	c.fsm.AddTransition(StateWaitCEA, StateClosed, EventPeerDisc, c.cleanup)
 
 	// State: I-Open
 	c.fsm.AddTransition(StateIOpen, StateIOpen, EventSendMessage, c.sendMessage)
-	c.fsm.AddTransition(StateIOpen, StateIOpen, EventReceiveDWR, c.sendDWA)
-	c.fsm.AddTransition(StateIOpen, StateClosing, EventDisconnect, func() error {
-		c.sendDPR()
-		c.cleanup()
-		return nil
-	})
-	c.fsm.AddTransition(StateIOpen, StateClosing, EventReceiveDPR, func() error {
-		c.sendDPA()
-		c.cleanup()
+	c.fsm.AddTransition(StateIOpen, StateClosing, EventDisconnect, c.sendDPR)
+	// The DPA has already been sent by the read loop.
+	c.fsm.AddTransition(StateIOpen, StateClosed, EventReceiveDPR, c.cleanup)
+	// A lost peer is handled by the watchdog, which closes the connection
+	// and reopens it.
+	c.fsm.AddTransition(StateIOpen, StateIOpen, EventPeerDisc, func() error {
+		c.watchdog.connectionDown()
 		return nil
 	})
 
 	// State: Closing
 	c.fsm.AddTransition(StateClosing, StateClosed, EventReceiveDPA, c.cleanup)
 	c.fsm.AddTransition(StateClosing, StateClosed, EventTimeout, c.cleanup)
+	c.fsm.AddTransition(StateClosing, StateClosed, EventPeerDisc, c.cleanup)
 }
 
 // Helper functions for transitions
 
-func (c *Client) sendConnRequest() error {
-	log.Println("Sending connection request to server.")
-	return c.Connect()
-}
-
 func (c *Client) sendCER() error {
 	log.Println("Sending Capabilities-Exchange-Request (CER) to server.")
-	message, err := message.NewCER()
+	cer, err := c.newCER()
 	if err != nil {
 		log.Printf("Error creating CER message: %v", err)
 		return err
 	}
-	c.SendMessage(message)
-	if err := c.fsm.Trigger(EventCEAReceived); err != nil {
-		log.Printf("Error triggering CEAReceived event: %v", err)
-		return err
-	}
-	return nil
+	return c.writeMessage(cer)
+}
+
+// newCER builds the CER advertising the client identity and the
+// configured applications.
+func (c *Client) newCER() (*message.DiameterMessage, error) {
+	return c.node().BuildCER(c.applications, message.WithIDGenerator(c.idGenerator))
 }
 
 func (c *Client) startWatchdog() {
 	log.Println("Starting Watchdog.")
-	// TODO: Code to start Watchdog timer and send DWR periodically
+	c.watchdog.connectionUp()
 }
 
-// sendMessage sends a Diameter message to the server.
-// The message is taken from the client's message queue.
-func (c *Client) sendMessage() error {
-	log.Println("Sending Diameter message.")
-	for msg := range c.messageQueue {
-		if state := c.fsm.GetState(); state != StateIOpen {
-			log.Printf("Client not in open state. Current state: %v", state)
-			break
-		}
-		encodedMsg, err := msg.Encode()
-		if err != nil {
-			log.Printf("Error encoding message: %v", err)
-			return err
-		}
-		_, err = c.conn.Write(encodedMsg)
-		if err != nil {
-			log.Printf("Error sending message: %v", err)
-			return err
-		}
+func (c *Client) sendDWR() error {
+	log.Println("Sending Device-Watchdog-Request (DWR) to server.")
+	dwr, err := c.node().BuildDWR(message.WithIDGenerator(c.idGenerator))
+	if err != nil {
+		return err
 	}
-	return nil
+	return c.writeMessage(dwr)
 }
 
-func (c *Client) sendDWA() error {
-	log.Println("Sending Diameter Watchdog Answer (DWA) in response to DWR.")
-	// TODO: Code to send a DWA message
-	return nil
+// closeConn closes the transport connection without touching the FSM, so
+// that the watchdog can reopen it later.
+func (c *Client) closeConn() {
+	if conn := c.getConn(); conn != nil {
+		conn.Close()
+	}
+	if writer := c.getWriter(); writer != nil {
+		writer.Close()
+	}
 }
 
-func (c *Client) sendDPR() {
-	log.Println("Sending Disconnect-Peer-Request (DPR) to server.")
-	// TODO: Code to send a DPR message
+// reopen re-establishes the transport connection after the watchdog has
+// declared the peer DOWN. The connection is used, and the watchdog told it
+// is up, only once the peer has answered the CER with an acceptable CEA.
+// Otherwise the watchdog stays DOWN and attempts again when Tw expires.
+func (c *Client) reopen() {
+	conn, err := c.dial()
+	if err != nil {
+		log.Printf("Error reopening connection to %s: %v", c.serverAddr, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.watchdog.twInit)
+	defer cancel()
+	if err := c.exchangeCapabilities(ctx, conn); err != nil {
+		log.Printf("Capabilities exchange with %s failed on reopen: %v", c.serverAddr, err)
+		conn.Close()
+		return
+	}
+	if c.fsm.GetState() != StateIOpen {
+		conn.Close()
+		return
+	}
+	c.setConn(conn)
+	go c.readLoop(conn)
+	c.watchdog.connectionUp()
 }
 
-func (c *Client) sendDPA() {
-	log.Println("Sending Disconnect-Peer-Answer (DPA) in response to DPR.")
-	// TODO: Code to send a DPA message
+// sendMessage sends the Diameter messages waiting in the client's message
+// queue.
+func (c *Client) sendMessage() error {
+	log.Println("Sending Diameter message.")
+	for {
+		select {
+		case msg := <-c.messageQueue:
+			if err := c.writeMessage(msg); err != nil {
+				log.Printf("Error sending message: %v", err)
+				return err
+			}
+		default:
+			return nil
+		}
+	}
 }
 
-func (c *Client) cleanup() error {
-	log.Println("Cleaning up resources and resetting client state.")
-	if c.conn != nil {
-		c.conn.Close()
+func (c *Client) sendDPR() error {
+	log.Println("Sending Disconnect-Peer-Request (DPR) to server.")
+	dpr, err := c.node().BuildDPR(message.DISCONNECT_CAUSE_REBOOTING, message.WithIDGenerator(c.idGenerator))
+	if err != nil {
+		return err
 	}
-	c.fsm.SetState(StateClosed)
-	// reset message queue
-	c.messageQueue = make(chan *message.DiameterMessage, messageQueueSize)
-	// trigger initialisation of FSM
-	c.EventChan <- EventStart
-	return nil
+	return c.writeMessage(dpr)
 }
 
-// Any special handling for errors can be done here.
-func (c *Client) handleError() error {
-	log.Println("Handling error and resetting to closed state.")
-	return c.cleanup()
+// cleanup stops the watchdog and closes the connection. The FSM moves to
+// Closed through the transition running it.
+func (c *Client) cleanup() error {
+	log.Println("Cleaning up resources and resetting client state.")
+	c.watchdog.stop()
+	c.closeConn()
+	return nil
 }
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	fsm "github.com/IbrahimShahzad/diameter/state"
+)
+
+// TestActionsWithoutConnection triggers the events whose actions write to
+// the peer on a client that has no connection: they fail with
+// ErrNotConnected and leave the state alone.
+func TestActionsWithoutConnection(t *testing.T) {
+	c := newTestClient(t, "127.0.0.1:3868")
+	for _, tc := range []struct {
+		from  fsm.State
+		event fsm.Event
+	}{
+		{StateWaitConnAck, EventConnAck},
+		{StateIOpen, EventDisconnect},
+	} {
+		c.fsm.SetState(tc.from)
+		if err := c.fsm.Trigger(tc.event); !errors.Is(err, ErrNotConnected) {
+			t.Errorf("event %d in state %d: %v, want ErrNotConnected", tc.event, tc.from, err)
+		}
+		if got := c.fsm.GetState(); got != tc.from {
+			t.Errorf("event %d moved state %d to %d", tc.event, tc.from, got)
+		}
+	}
+}
+
+// TestActionsUseConnection checks that an action writes on the
+// connection the client holds: a Disconnect sends the DPR to the peer.
+func TestActionsUseConnection(t *testing.T) {
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr())
+	conn := peer.connect(c)
+	if err := c.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	dpr := peer.read(conn)
+	if dpr.Header.CommandCode != message.COMMAND_CODE_DISCONNECT_PEER || !dpr.IsRequest() {
+		t.Fatalf("read %s, want DPR", dpr.CommandName())
+	}
+	if host, _ := message.GetOriginHost(dpr); host != "client.example.com" {
+		t.Errorf("DPR from %q", host)
+	}
+	eventually(t, "Closing", func() bool { return c.fsm.GetState() == StateClosing })
+}
+
+// TestEveryEventWithoutConnection triggers every event in every state on
+// a client that never connected, with a message queued for sending. No
+// action may panic; those that fail leave the state alone, and those
+// that write to the peer fail with ErrNotConnected.
+func TestEveryEventWithoutConnection(t *testing.T) {
+	type transition struct {
+		from  fsm.State
+		event fsm.Event
+	}
+	writes := map[transition]bool{
+		{StateWaitConnAck, EventConnAck}: true,
+		{StateIOpen, EventSendMessage}:   true,
+		{StateIOpen, EventDisconnect}:    true,
+	}
+	for from := StateClosed; from <= StateClosing; from++ {
+		for event := EventStart; event <= EventPeerDisc; event++ {
+			c := newTestClient(t, "127.0.0.1:3868")
+			c.messageQueue <- newTestCCR(t, c, "client.example.com;1;1")
+			c.fsm.SetState(from)
+			err := func() (err error) {
+				defer func() {
+					if r := recover(); r != nil {
+						err = fmt.Errorf("panic: %v", r)
+						t.Errorf("event %d in state %d panicked: %v", event, from, r)
+					}
+				}()
+				return c.fsm.Trigger(event)
+			}()
+			if err == nil {
+				if writes[transition{from, event}] {
+					t.Errorf("event %d in state %d succeeded without a connection", event, from)
+				}
+				continue
+			}
+			if got := c.fsm.GetState(); got != from {
+				t.Errorf("event %d in state %d failed with %v and moved to %d", event, from, err, got)
+			}
+			if writes[transition{from, event}] && !errors.Is(err, ErrNotConnected) {
+				t.Errorf("event %d in state %d: %v, want ErrNotConnected", event, from, err)
+			}
+		}
+	}
+}
+
+// TestConnectThroughWaitConnAck follows the client through Wait-Conn-Ack
+// and Wait-I-CEA to a CEA refusing the capabilities, which closes it.
+func TestConnectThroughWaitConnAck(t *testing.T) {
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr())
+	if err := c.Connect(); err != nil {
+		t.Fatalf("connecting: %v", err)
+	}
+	conn := peer.accept()
+	cer := peer.read(conn)
+	if cer.Header.CommandCode != message.COMMAND_CODE_CER || !cer.IsRequest() {
+		t.Fatalf("first message is %s, want CER", cer.CommandName())
+	}
+	got := transitions(t, c, 2)
+	if want := []fsm.State{StateWaitConnAck, StateWaitCEA}; !slices.Equal(states(got), want) {
+		t.Fatalf("sending the CER went through %v, want %v", states(got), want)
+	}
+	if got[0].From != StateClosed || got[1].From != StateWaitConnAck {
+		t.Errorf("transitions %+v do not start from Closed", got)
+	}
+
+	cea, err := peer.node.BuildCEA(cer, message.Applications{}, message.DIAMETER_NO_COMMON_APPLICATION)
+	if err != nil {
+		t.Fatalf("building CEA: %v", err)
+	}
+	peer.write(conn, cea)
+	if closed := transitions(t, c, 1)[0]; closed.From != StateWaitCEA || closed.To != StateClosed {
+		t.Errorf("refused CEA moved %v to %v, want Wait-I-CEA to Closed", closed.From, closed.To)
+	}
+}
+
+// TestLoopedBackCER has the peer send the CER of the client back to it: a
+// request with the command code of a CEA must not open the connection.
+func TestLoopedBackCER(t *testing.T) {
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr())
+	if err := c.Connect(); err != nil {
+		t.Fatalf("connecting: %v", err)
+	}
+	conn := peer.accept()
+	peer.write(conn, peer.read(conn))
+	got := transitions(t, c, 3)
+	if want := []fsm.State{StateWaitConnAck, StateWaitCEA, StateClosed}; !slices.Equal(states(got), want) {
+		t.Fatalf("looped back CER went through %v, want %v", states(got), want)
+	}
+	if !errors.Is(got[2].Err, message.UnexpectedRequestError) {
+		t.Errorf("closed with %v, want UnexpectedRequestError", got[2].Err)
+	}
+}
@@ -0,0 +1,122 @@
+// Peer status reporting
+package client
+
+import (
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	fsm "github.com/IbrahimShahzad/diameter/state"
+	"github.com/IbrahimShahzad/diameter/stats"
+)
+
+// PeerStatus is a snapshot of the client's view of its peer.
+type PeerStatus struct {
+	Addr         string
+	Identity     message.PeerIdentity
+	Capabilities message.PeerCapabilities
+	// Software describes the software of the peer, from its CEA.
+	Software     message.PeerSoftwareInfo
+	State        fsm.State
+	Watchdog     WatchdogState
+	LastActivity time.Time
+	// RemoteAddr is the address of the current connection, which may be
+	// any of the addresses Addr resolves to; empty when not connected.
+	RemoteAddr string
+	// Load is the load last reported by the peer, nil if it never
+	// reported one.
+	Load *message.Load
+	// Counters reports the traffic exchanged with the peer, across
+	// reconnects, since the client was created or ResetCounters was
+	// called.
+	Counters stats.PeerCounters
+	// DisconnectCause is the Disconnect-Cause of the last DPR received
+	// from the peer, nil if it never sent one.
+	DisconnectCause *uint32
+	// Latency summarizes the time from a request being written to its
+	// answer being decoded.
+	Latency stats.HistogramSnapshot
+	// QueueWait summarizes the time requests spent being encoded and
+	// queued for writing, not included in Latency.
+	QueueWait stats.HistogramSnapshot
+	// OrphanedAnswers counts answers whose Hop-by-Hop Identifier matched
+	// no outstanding request.
+	OrphanedAnswers uint64
+	// Slow reports whether the p95 latency went above the threshold set
+	// with WithSlowPeerThreshold in the last window of answers.
+	Slow bool
+	// ObserverPanics counts the calls of OnInboundRequest observers that
+	// panicked.
+	ObserverPanics uint64
+	// SuppressedLogs counts the error lines left out of the logs because
+	// the peer repeated the error within the error log interval.
+	SuppressedLogs uint64
+	// BusyUntil is the end of the cool-down of a peer back from a BUSY
+	// disconnection, see WithBusyCooldown, or the zero time.
+	BusyUntil time.Time
+	// InvalidRequests counts the requests that failed outbound
+	// validation, whether they were sent anyway or refused.
+	InvalidRequests uint64
+}
+
+// Available reports whether new requests may be sent to the peer. Per
+// RFC 3539 only peers whose watchdog is OKAY receive new traffic.
+func (s PeerStatus) Available() bool {
+	return s.Watchdog == WatchdogOkay
+}
+
+// PeerStatus returns the current status of the connection to the peer.
+func (c *Client) PeerStatus() PeerStatus {
+	var load *message.Load
+	if l, ok := c.PeerLoad(); ok {
+		load = &l
+	}
+	var remote string
+	if conn := c.getConn(); conn != nil {
+		remote = conn.RemoteAddr().String()
+	}
+	caps := c.PeerCapabilities()
+	return PeerStatus{
+		Addr:            c.serverAddr,
+		RemoteAddr:      remote,
+		Identity:        c.PeerIdentity(),
+		Capabilities:    caps,
+		Software:        caps.Software(),
+		State:           c.fsm.GetState(),
+		Watchdog:        c.watchdog.Status(),
+		LastActivity:    c.watchdog.LastReceived(),
+		Load:            load,
+		Counters:        c.counters.Snapshot(),
+		DisconnectCause: c.lastDisconnectCause(),
+		Latency:         c.latency.Snapshot(),
+		QueueWait:       c.queueWait.Snapshot(),
+		OrphanedAnswers: c.pending.Orphaned(),
+		Slow:            c.isSlow(),
+		ObserverPanics:  c.inboundRequests.Panics(),
+		SuppressedLogs:  c.errorLog.Suppressed(),
+		BusyUntil:       c.coolingDownUntil(),
+		InvalidRequests: c.invalidRequests.Load(),
+	}
+}
+
+// StatsSnapshot returns the counters of the client in the form of the
+// server statistics, with the traffic exchanged with the peer as the only
+// entry of Peers. Per-command statistics are not collected by the client.
+func (c *Client) StatsSnapshot() stats.Snapshot {
+	counters := c.counters.Snapshot()
+	counters.Peer = c.serverAddr
+	return stats.Snapshot{
+		Timestamp:        c.clock.Now(),
+		OrphanedAnswers:  c.pending.Orphaned(),
+		TimedOutRequests: c.pending.TimedOut(),
+		ObserverPanics:   c.inboundRequests.Panics(),
+		SuppressedLogs:   c.errorLog.Suppressed(),
+		WriteBatches:     c.WriteBatchStats(),
+		Peers:            []stats.PeerCounters{counters},
+	}
+}
+
+// ResetCounters sets the traffic counters reported in PeerStatus back to
+// zero.
+func (c *Client) ResetCounters() {
+	c.counters.Reset()
+}
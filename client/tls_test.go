@@ -0,0 +1,60 @@
+package client
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/IbrahimShahzad/diameter/internal/testing/testcert"
+	"github.com/IbrahimShahzad/diameter/message"
+	fsm "github.com/IbrahimShahzad/diameter/state"
+	"github.com/IbrahimShahzad/diameter/transport"
+)
+
+// TestTLSIdentityBinding connects over TLS to a peer announcing
+// peer.example.com with certificates naming it or another node, and checks
+// the outcome of the capabilities exchange in each mode.
+func TestTLSIdentityBinding(t *testing.T) {
+	ca := testcert.NewAuthority(t)
+	clientCert := ca.Issue(t, "client.example.com")
+	for _, tc := range []struct {
+		name string
+		mode transport.TLSIdentityMode
+		// names are the names of the certificate of the peer, the first
+		// being verified by the TLS handshake.
+		names []string
+		ok    bool
+	}{
+		{"off, mismatch", transport.TLSIdentityOff, []string{"other.example.com"}, true},
+		{"warn, mismatch", transport.TLSIdentityWarn, []string{"other.example.com"}, true},
+		{"enforce, match", transport.TLSIdentityEnforce, []string{"peer.example.com"}, true},
+		{"enforce, wildcard", transport.TLSIdentityEnforce, []string{"diameter.example.com", "*.example.com"}, true},
+		{"enforce, mismatch", transport.TLSIdentityEnforce, []string{"other.example.com"}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			peer := newTLSTestPeer(t, ca.ServerConfig(ca.Issue(t, tc.names...)))
+			c := newTestClient(t, peer.addr(),
+				WithTLS(ca.ClientConfig(clientCert, tc.names[0])),
+				WithTLSIdentityBinding(tc.mode))
+			if tc.ok {
+				peer.connect(c)
+				if host := c.PeerIdentity().Host; host != "peer.example.com" {
+					t.Errorf("connected to %q", host)
+				}
+				return
+			}
+			if err := c.Connect(); err != nil {
+				t.Fatalf("connecting: %v", err)
+			}
+			peer.exchange(peer.accept())
+			got := transitions(t, c, 3)
+			if want := []fsm.State{StateWaitConnAck, StateWaitCEA, StateClosed}; !slices.Equal(states(got), want) {
+				t.Fatalf("mismatching certificate went through %v, want %v", states(got), want)
+			}
+			var perr *message.ProtocolError
+			if !errors.As(got[2].Err, &perr) || perr.ResultCode != message.DIAMETER_UNKNOWN_PEER || !errors.Is(got[2].Err, transport.ErrCertificateIdentity) {
+				t.Errorf("closed with %v, want DIAMETER_UNKNOWN_PEER for the certificate", got[2].Err)
+			}
+		})
+	}
+}
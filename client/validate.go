@@ -0,0 +1,170 @@
+// Validation of client options
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/stats"
+	"github.com/IbrahimShahzad/diameter/transport"
+)
+
+// MinWatchdogTTL is the lowest watchdog interval accepted. RFC 3539
+// section 3.4.1 recommends at least 6 seconds, and the interval must stay
+// positive once the +/- 2 second jitter is applied.
+const MinWatchdogTTL = 6 * time.Second
+
+// Validate checks the configuration without opening a connection and
+// returns every problem found: options out of range or contradicting each
+// other, an Origin-Host or Origin-Realm that is not a valid
+// DiameterIdentity, a server address that does not parse for the protocol
+// and AVPs of registered commands missing from the dictionary. Connect
+// runs it first unless WithoutSelfTest is set.
+func (c *Client) Validate() error {
+	return errors.Join(c.validate(), c.selfTest())
+}
+
+// selfTest reports the problems validate leaves for startup.
+func (o *ClientOptions) selfTest() error {
+	var errs []error
+	for _, id := range []struct{ name, value string }{
+		{"Origin-Host", o.originHost},
+		{"Origin-Realm", o.originRealm},
+	} {
+		if id.value == "" {
+			continue // reported by validate
+		}
+		if err := message.ValidateDiameterIdentity(id.value); err != nil {
+			errs = append(errs, fmt.Errorf("%w: %s: %w", ErrInvalidOptions, id.name, err))
+		}
+	}
+	if o.serverAddr != "" {
+		if err := transport.CheckAddr(o.serverAddr, o.protocol); err != nil {
+			errs = append(errs, fmt.Errorf("%w: server address: %w", ErrInvalidOptions, err))
+		}
+	}
+	if err := message.CheckDictionary(); err != nil {
+		errs = append(errs, fmt.Errorf("dictionary: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// validate reports every contradictory or out of range option. A
+// connection timeout of 0 means no timeout.
+func (o *ClientOptions) validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: "+format, append([]any{ErrInvalidOptions}, args...)...))
+	}
+	if o.serverAddr == "" {
+		invalid("server address is empty")
+	}
+	if o.protocol != transport.Proto_TCP && o.protocol != transport.Proto_SCTP {
+		invalid("unknown protocol %v", o.protocol)
+	}
+	if o.connectionTimeout < 0 {
+		invalid("connection timeout %v is negative", o.connectionTimeout)
+	}
+	if o.watchdogTTL < MinWatchdogTTL {
+		invalid("watchdog TTL %v is below %v", o.watchdogTTL, MinWatchdogTTL)
+	}
+	if o.clock == nil {
+		invalid("clock is nil")
+	}
+	if o.originHost == "" || o.originRealm == "" {
+		invalid("Origin-Host and Origin-Realm must be set")
+	}
+	if o.expectedPeer.Host != "" {
+		if err := message.ValidateDiameterIdentity(o.expectedPeer.Host); err != nil {
+			invalid("expected peer: %v", err)
+		}
+	}
+	if o.productName == "" {
+		invalid("Product-Name must be set")
+	}
+	if o.socketOptions.KeepAlive < 0 || o.socketOptions.SendBuffer < 0 || o.socketOptions.ReceiveBuffer < 0 {
+		invalid("socket options must not be negative")
+	}
+	if o.protocol == transport.Proto_SCTP && o.socketOptions.KeepAlive > 0 {
+		invalid("TCP keepalive cannot be used with SCTP, which has its own heartbeat")
+	}
+	if o.writeBatch.MaxBytes < 0 || o.writeBatch.FlushInterval < 0 {
+		invalid("write batching limits must not be negative")
+	}
+	if o.writeTimeout < 0 {
+		invalid("write timeout %v is negative", o.writeTimeout)
+	}
+	if o.linger < 0 {
+		invalid("linger %v is negative", o.linger)
+	}
+	if o.writeBatch.FailureThreshold < 0 {
+		invalid("write failure threshold %d is negative", o.writeBatch.FailureThreshold)
+	}
+	if o.decodeOptions.MaxGroupDepth < 0 {
+		invalid("maximum Grouped AVP depth %d is negative", o.decodeOptions.MaxGroupDepth)
+	}
+	for cause, delay := range o.reconnectDelays {
+		if delay < 0 {
+			invalid("reconnect delay %v for Disconnect-Cause %d is negative", delay, cause)
+		}
+	}
+	if o.outboundCheck < message.ValidationOff || o.outboundCheck > message.ValidationEnforce {
+		invalid("unknown outbound validation mode %v", o.outboundCheck)
+	}
+	if o.busyCooldown < 0 {
+		invalid("busy cool-down %v is negative", o.busyCooldown)
+	}
+	if o.slowPeerThreshold < 0 {
+		invalid("slow peer threshold %v is negative", o.slowPeerThreshold)
+	}
+	if o.slowPeerAction != SlowPeerNotify && o.slowPeerAction != SlowPeerDemote {
+		invalid("unknown slow peer action %v", o.slowPeerAction)
+	}
+	if o.dialOptions.AttemptTimeout < 0 || o.dialOptions.FallbackDelay < 0 {
+		invalid("dial attempt timeout and fallback delay must not be negative")
+	}
+	if o.messageQueueSize < 0 || o.eventBufferSize < 0 {
+		invalid("message queue and event buffer sizes must not be negative")
+	}
+	if o.retryLimit < 0 || o.retryBackoff < 0 {
+		invalid("retry limit and backoff must not be negative")
+	}
+	if o.resyncWindow < 0 {
+		invalid("resync window %d is negative", o.resyncWindow)
+	}
+	if o.errorLogInterval < 0 {
+		invalid("error log interval %v is negative", o.errorLogInterval)
+	}
+	if o.statsDumpWriter != nil && o.statsDumpInterval <= 0 {
+		invalid("statistics dump interval %v must be positive", o.statsDumpInterval)
+	}
+	if o.statsDumpFormat != stats.FormatJSON && o.statsDumpFormat != stats.FormatText {
+		invalid("unknown statistics format %v", o.statsDumpFormat)
+	}
+	if o.tlsConfig != nil && o.protocol != transport.Proto_TCP {
+		invalid("TLS is only supported over TCP")
+	}
+	if o.tlsIdentity < transport.TLSIdentityOff || o.tlsIdentity > transport.TLSIdentityEnforce {
+		invalid("unknown TLS identity binding mode %v", o.tlsIdentity)
+	}
+	if o.tlsIdentity != transport.TLSIdentityOff && o.tlsConfig == nil {
+		invalid("TLS identity binding requires WithTLS")
+	}
+	if o.connEvents != nil && o.protocol != transport.Proto_SCTP {
+		invalid("connection events are only reported for SCTP")
+	}
+	if o.interimFloor < 0 || o.interimCap < 0 || o.interimJitter < 0 {
+		invalid("interim limits and jitter must not be negative")
+	}
+	if o.interimCap > 0 && o.interimCap < o.interimFloor {
+		invalid("interim cap %v is below the floor %v", o.interimCap, o.interimFloor)
+	}
+	for _, class := range o.retryClasses {
+		if class != message.ResultClassProtocolError && class != message.ResultClassTransient {
+			invalid("results of class %s cannot be retried", class)
+		}
+	}
+	return errors.Join(errs...)
+}
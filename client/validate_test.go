@@ -0,0 +1,293 @@
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/stats"
+	"github.com/IbrahimShahzad/diameter/transport"
+)
+
+// resolved is the part of the configuration NewClient resolves from its
+// options.
+type resolved struct {
+	protocol          transport.ProtocolType
+	connectionTimeout time.Duration
+	watchdogTTL       time.Duration
+	messageQueueSize  int
+	eventBufferSize   int
+}
+
+// TestNewClientOptions documents the defaults of the client and the
+// option combinations NewClient rejects.
+func TestNewClientOptions(t *testing.T) {
+	defaults := resolved{
+		protocol:          transport.Proto_TCP,
+		connectionTimeout: 5 * time.Second,
+		watchdogTTL:       30 * time.Second,
+		messageQueueSize:  messageQueueSize,
+		eventBufferSize:   eventBufferSize,
+	}
+	with := func(change func(*resolved)) resolved {
+		r := defaults
+		change(&r)
+		return r
+	}
+	for _, tc := range []struct {
+		name string
+		opts []ClientOptionsFunc
+		want resolved
+		// errs are the problems reported, none when the options are
+		// valid.
+		errs []string
+	}{
+		{name: "defaults", want: defaults},
+		{
+			name: "connection timeout as a duration",
+			opts: []ClientOptionsFunc{WithConnectionTimeout(1500 * time.Millisecond)},
+			want: with(func(r *resolved) { r.connectionTimeout = 1500 * time.Millisecond }),
+		},
+		{
+			name: "no connection timeout",
+			opts: []ClientOptionsFunc{WithConnectionTimeout(0)},
+			want: with(func(r *resolved) { r.connectionTimeout = 0 }),
+		},
+		{
+			name: "minimum watchdog TTL",
+			opts: []ClientOptionsFunc{WithWatchdogTTL(MinWatchdogTTL)},
+			want: with(func(r *resolved) { r.watchdogTTL = MinWatchdogTTL }),
+		},
+		{
+			name: "zero queue sizes take the defaults",
+			opts: []ClientOptionsFunc{WithMessageQueueSize(0), WithEventBufferSize(0)},
+			want: defaults,
+		},
+		{
+			name: "queue sizes",
+			opts: []ClientOptionsFunc{WithMessageQueueSize(100), WithEventBufferSize(20)},
+			want: with(func(r *resolved) { r.messageQueueSize, r.eventBufferSize = 100, 20 }),
+		},
+		{
+			name: "SCTP",
+			opts: []ClientOptionsFunc{WithSCTP()},
+			want: with(func(r *resolved) { r.protocol = transport.Proto_SCTP }),
+		},
+		{
+			name: "watchdog TTL of 0",
+			opts: []ClientOptionsFunc{WithWatchdogTTL(0)},
+			errs: []string{"watchdog TTL 0s is below 6s"},
+		},
+		{
+			name: "negative connection timeout",
+			opts: []ClientOptionsFunc{WithConnectionTimeout(-time.Second)},
+			errs: []string{"connection timeout -1s is negative"},
+		},
+		{
+			name: "negative queue size",
+			opts: []ClientOptionsFunc{WithMessageQueueSize(-1)},
+			errs: []string{"message queue and event buffer sizes must not be negative"},
+		},
+		{
+			name: "negative reconnect delay",
+			opts: []ClientOptionsFunc{WithReconnectDelay(message.DISCONNECT_CAUSE_BUSY, -time.Second)},
+			errs: []string{"reconnect delay -1s for Disconnect-Cause 1 is negative"},
+		},
+		{
+			name: "negative slow peer threshold",
+			opts: []ClientOptionsFunc{WithSlowPeerThreshold(-time.Second, SlowPeerDemote)},
+			errs: []string{"slow peer threshold -1s is negative"},
+		},
+		{
+			name: "unknown slow peer action",
+			opts: []ClientOptionsFunc{WithSlowPeerThreshold(time.Second, SlowPeerDemote+1)},
+			errs: []string{"unknown slow peer action SlowPeerAction(2)"},
+		},
+		{
+			name: "no Product-Name",
+			opts: []ClientOptionsFunc{WithProductName("")},
+			errs: []string{"Product-Name must be set"},
+		},
+		{
+			name: "negative linger",
+			opts: []ClientOptionsFunc{WithLinger(-time.Second)},
+			errs: []string{"linger -1s is negative"},
+		},
+		{
+			name: "negative interim jitter",
+			opts: []ClientOptionsFunc{WithInterimJitter(-time.Second)},
+			errs: []string{"interim limits and jitter must not be negative"},
+		},
+		{
+			name: "interim cap below the floor",
+			opts: []ClientOptionsFunc{WithInterimLimits(time.Minute, time.Second)},
+			errs: []string{"interim cap 1s is below the floor 1m0s"},
+		},
+		{
+			name: "connection events over TCP",
+			opts: []ClientOptionsFunc{WithConnEventHandler(func(transport.ConnEvent) {})},
+			errs: []string{"connection events are only reported for SCTP"},
+		},
+		{
+			name: "negative dial attempt timeout",
+			opts: []ClientOptionsFunc{WithDialAttempts(-time.Second, 0)},
+			errs: []string{"dial attempt timeout and fallback delay must not be negative"},
+		},
+		{
+			name: "negative busy cool-down",
+			opts: []ClientOptionsFunc{WithBusyCooldown(-time.Second)},
+			errs: []string{"busy cool-down -1s is negative"},
+		},
+		{
+			name: "negative resync window",
+			opts: []ClientOptionsFunc{WithStreamResync(-1)},
+			errs: []string{"resync window -1 is negative"},
+		},
+		{
+			name: "statistics dump without interval",
+			opts: []ClientOptionsFunc{WithStatsDump(io.Discard, 0, stats.FormatText)},
+			errs: []string{"statistics dump interval 0s must be positive"},
+		},
+		{
+			name: "unknown statistics format",
+			opts: []ClientOptionsFunc{WithStatsDump(io.Discard, time.Minute, stats.Format(2))},
+			errs: []string{"unknown statistics format Format(2)"},
+		},
+		{
+			name: "invalid expected peer",
+			opts: []ClientOptionsFunc{WithExpectedPeer("peer_1.example.com", "")},
+			errs: []string{"expected peer: invalid DiameterIdentity"},
+		},
+		{
+			name: "TLS over SCTP",
+			opts: []ClientOptionsFunc{WithSCTP(), WithTLS(&tls.Config{})},
+			errs: []string{"TLS is only supported over TCP"},
+		},
+		{
+			name: "TCP keepalive over SCTP",
+			opts: []ClientOptionsFunc{WithSCTP(), WithTCPKeepalive(time.Second)},
+			errs: []string{"TCP keepalive cannot be used with SCTP"},
+		},
+		{
+			name: "no identity",
+			opts: []ClientOptionsFunc{WithOriginHost("")},
+			errs: []string{"Origin-Host and Origin-Realm must be set"},
+		},
+		{
+			name: "every problem reported",
+			opts: []ClientOptionsFunc{WithServerAddr(""), WithWatchdogTTL(time.Second), WithSCTP(), WithTLS(&tls.Config{})},
+			errs: []string{"server address is empty", "watchdog TTL 1s is below 6s", "TLS is only supported over TCP"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := NewClient(tc.opts...)
+			if tc.errs != nil {
+				if !errors.Is(err, ErrInvalidOptions) {
+					t.Fatalf("error %v, want ErrInvalidOptions", err)
+				}
+				for _, want := range tc.errs {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("error %q does not report %q", err, want)
+					}
+				}
+				if n := strings.Count(err.Error(), ErrInvalidOptions.Error()); n != len(tc.errs) {
+					t.Errorf("%d problems reported, want %d: %v", n, len(tc.errs), err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			got := resolved{
+				protocol:          c.protocol,
+				connectionTimeout: c.connectionTimeout,
+				watchdogTTL:       c.watchdogTTL,
+				messageQueueSize:  cap(c.messageQueue),
+				eventBufferSize:   cap(c.EventChan),
+			}
+			if got != tc.want {
+				t.Errorf("resolved %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestSelfTest feeds Validate configurations NewClient accepts but that
+// cannot work, and checks that each problem is reported.
+func TestSelfTest(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []ClientOptionsFunc
+		errs []string
+	}{
+		{name: "valid"},
+		{
+			name: "invalid Origin-Host",
+			opts: []ClientOptionsFunc{WithOriginHost("client_1.example.com")},
+			errs: []string{"Origin-Host: invalid DiameterIdentity"},
+		},
+		{
+			name: "invalid Origin-Realm",
+			opts: []ClientOptionsFunc{WithOriginRealm("example..com")},
+			errs: []string{"Origin-Realm: invalid DiameterIdentity"},
+		},
+		{
+			name: "server address without a port",
+			opts: []ClientOptionsFunc{WithServerAddr("127.0.0.1")},
+			errs: []string{`server address: invalid address "127.0.0.1"`},
+		},
+		{
+			name: "SCTP server address with a port",
+			opts: []ClientOptionsFunc{WithSCTP(), WithServerAddr("127.0.0.1:3868")},
+			errs: []string{"SCTP needs an IP address"},
+		},
+		{
+			name: "every problem reported",
+			opts: []ClientOptionsFunc{WithOriginHost("-client.example.com"), WithOriginRealm("example..com"), WithServerAddr("127.0.0.1")},
+			errs: []string{"Origin-Host: invalid DiameterIdentity", "Origin-Realm: invalid DiameterIdentity", "server address"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestClient(t, "127.0.0.1:3868", tc.opts...)
+			err := c.Validate()
+			if tc.errs == nil {
+				if err != nil {
+					t.Errorf("Validate: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidOptions) {
+				t.Fatalf("error %v, want ErrInvalidOptions", err)
+			}
+			for _, want := range tc.errs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not report %q", err, want)
+				}
+			}
+			if n := strings.Count(err.Error(), ErrInvalidOptions.Error()); n != len(tc.errs) {
+				t.Errorf("%d problems reported, want %d: %v", n, len(tc.errs), err)
+			}
+		})
+	}
+}
+
+// TestConnectSelfTest checks that Connect runs Validate before dialing
+// unless WithoutSelfTest is set.
+func TestConnectSelfTest(t *testing.T) {
+	peer := newTestPeer(t)
+	c := newTestClient(t, peer.addr(), WithOriginHost("client_1.example.com"))
+	if err := c.Connect(); !errors.Is(err, message.InvalidDiameterIdentityError) {
+		t.Errorf("Connect = %v, want the invalid Origin-Host reported", err)
+	}
+	noConnection(t, peer)
+
+	c = newTestClient(t, peer.addr(), WithOriginHost("client_1.example.com"), WithoutSelfTest())
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect without self-test: %v", err)
+	}
+	peer.accept()
+}
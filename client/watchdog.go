@@ -0,0 +1,271 @@
+// Device watchdog (RFC 3539) for the client connection
+package client
+
+import (
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/clock"
+)
+
+// WatchdogState is the RFC 3539 status of the connection to a peer.
+type WatchdogState int
+
+const (
+	WatchdogInitial WatchdogState = iota
+	WatchdogOkay
+	WatchdogSuspect
+	WatchdogDown
+	WatchdogReopen
+)
+
+func (s WatchdogState) String() string {
+	switch s {
+	case WatchdogInitial:
+		return "INITIAL"
+	case WatchdogOkay:
+		return "OKAY"
+	case WatchdogSuspect:
+		return "SUSPECT"
+	case WatchdogDown:
+		return "DOWN"
+	case WatchdogReopen:
+		return "REOPEN"
+	}
+	return "UNKNOWN"
+}
+
+const (
+	// minWatchdogTTL is the lowest Twinit allowed by RFC 3539 section 3.4.1.
+	minWatchdogTTL = 6 * time.Second
+	// watchdogJitter is the maximum deviation applied to Twinit on each
+	// timer start so that peers do not send their DWRs in lockstep.
+	watchdogJitter = 2 * time.Second
+	// watchdogReopenDWA is the number of DWAs required in REOPEN before
+	// the connection is considered OKAY again.
+	watchdogReopenDWA = 3
+)
+
+// watchdogHooks are the actions the watchdog asks its owner to perform.
+// They are invoked once the watchdog lock is released, so that a stalled
+// write or a slow hook does not hold up the Tw timer or DWA handling, in
+// the order the watchdog decided on them.
+type watchdogHooks struct {
+	sendDWR     func() error
+	failover    func()
+	failback    func()
+	closeConn   func()
+	attemptOpen func()
+}
+
+// hookCalls collects the hooks decided on under the watchdog lock.
+type hookCalls []func()
+
+func (h *hookCalls) add(fn func()) {
+	if fn != nil {
+		*h = append(*h, fn)
+	}
+}
+
+// run calls the collected hooks. It is deferred before the watchdog lock
+// is taken, so that it runs after the lock is released.
+func (h *hookCalls) run() {
+	for _, fn := range *h {
+		fn()
+	}
+}
+
+// watchdog implements the algorithm of RFC 3539 section 3.4.1. It is driven
+// by the Tw timer and by calls to received() for every inbound message.
+type watchdog struct {
+	mu           sync.Mutex
+	clock        clock.Clock
+	state        WatchdogState
+	twInit       time.Duration
+	pending      bool
+	numDWA       int
+	timer        clock.Timer
+	lastReceived time.Time
+	stopped      bool
+	hooks        watchdogHooks
+}
+
+func newWatchdog(clk clock.Clock, twInit time.Duration, hooks watchdogHooks) *watchdog {
+	if twInit < minWatchdogTTL {
+		twInit = minWatchdogTTL
+	}
+	return &watchdog{
+		clock:  clk,
+		state:  WatchdogInitial,
+		twInit: twInit,
+		hooks:  hooks,
+	}
+}
+
+// tw returns Twinit with a random jitter of up to +/- 2 seconds.
+func (w *watchdog) tw() time.Duration {
+	jitter := time.Duration(rand.Int64N(int64(2*watchdogJitter)+1)) - watchdogJitter
+	return w.twInit + jitter
+}
+
+// setWatchdog restarts the Tw timer. Callers must hold w.mu.
+func (w *watchdog) setWatchdog() {
+	if w.stopped {
+		return
+	}
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.timer = w.clock.AfterFunc(w.tw(), w.onTimer)
+}
+
+// Status returns the current watchdog state.
+func (w *watchdog) Status() WatchdogState {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.state
+}
+
+// LastReceived returns the time at which traffic was last seen from the peer.
+func (w *watchdog) LastReceived() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastReceived
+}
+
+// connectionUp is called once capability exchange has completed. A fresh
+// connection goes straight to OKAY while a connection recovering from DOWN
+// has to prove itself in REOPEN first.
+func (w *watchdog) connectionUp() {
+	var hooks hookCalls
+	defer hooks.run()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stopped = false
+	w.pending = false
+	w.lastReceived = w.clock.Now()
+	if w.state == WatchdogDown {
+		w.state = WatchdogReopen
+		w.numDWA = 0
+		w.sendDWR(&hooks)
+	} else {
+		w.state = WatchdogOkay
+	}
+	w.setWatchdog()
+}
+
+// received must be called for every message read from the peer.
+func (w *watchdog) received(isDWA bool) {
+	var hooks hookCalls
+	defer hooks.run()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastReceived = w.clock.Now()
+	if isDWA {
+		w.pending = false
+	}
+
+	switch w.state {
+	case WatchdogReopen:
+		if isDWA {
+			w.numDWA++
+			if w.numDWA == watchdogReopenDWA {
+				w.state = WatchdogOkay
+				w.setWatchdog()
+				hooks.add(w.hooks.failback)
+			}
+		}
+	case WatchdogSuspect:
+		w.state = WatchdogOkay
+		w.setWatchdog()
+		hooks.add(w.hooks.failback)
+	case WatchdogOkay:
+		w.setWatchdog()
+	}
+}
+
+// onTimer handles expiry of the Tw timer.
+func (w *watchdog) onTimer() {
+	var hooks hookCalls
+	defer hooks.run()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped {
+		return
+	}
+
+	switch w.state {
+	case WatchdogOkay:
+		if w.pending {
+			log.Println("Watchdog: no DWA received, peer is SUSPECT.")
+			w.state = WatchdogSuspect
+			hooks.add(w.hooks.failover)
+		} else {
+			w.sendDWR(&hooks)
+		}
+	case WatchdogSuspect:
+		log.Println("Watchdog: second DWA missed, peer is DOWN.")
+		w.state = WatchdogDown
+		hooks.add(w.hooks.closeConn)
+	case WatchdogInitial, WatchdogDown:
+		hooks.add(w.hooks.attemptOpen)
+	case WatchdogReopen:
+		if w.pending {
+			if w.numDWA < 0 {
+				w.state = WatchdogDown
+				hooks.add(w.hooks.closeConn)
+			} else {
+				w.numDWA = -1
+			}
+		} else {
+			w.sendDWR(&hooks)
+		}
+	}
+	w.setWatchdog()
+}
+
+// connectionDown is called when the transport has failed. The connection
+// is closed as if the peer had missed its DWAs, and reopened at once.
+func (w *watchdog) connectionDown() {
+	var hooks hookCalls
+	defer hooks.run()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped || w.state == WatchdogInitial || w.state == WatchdogDown {
+		return
+	}
+	w.state = WatchdogDown
+	hooks.add(w.hooks.closeConn)
+	hooks.add(w.hooks.attemptOpen)
+	w.setWatchdog()
+}
+
+// sendDWR marks a watchdog request outstanding and adds sending it to
+// hooks, so that it is written once w.mu is released. Callers must hold
+// w.mu.
+func (w *watchdog) sendDWR(hooks *hookCalls) {
+	if send := w.hooks.sendDWR; send != nil {
+		hooks.add(func() {
+			if err := send(); err != nil {
+				log.Printf("Watchdog: error sending DWR: %v", err)
+			}
+		})
+	}
+	w.pending = true
+}
+
+// stop halts the Tw timer and returns the watchdog to INITIAL, so that a
+// closed connection, such as one the peer disconnected with a DPR, is not
+// reported OKAY. The watchdog can be restarted with connectionUp.
+func (w *watchdog) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stopped = true
+	w.state = WatchdogInitial
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+}
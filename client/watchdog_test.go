@@ -0,0 +1,284 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+)
+
+// watchdogCalls records the hooks invoked by a watchdog under test.
+type watchdogCalls struct {
+	dwrs, failovers, failbacks, closes, opens int
+}
+
+func newTestWatchdog(twInit time.Duration) (*watchdog, *fakeclock.Clock, *watchdogCalls) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	calls := &watchdogCalls{}
+	w := newWatchdog(clk, twInit, watchdogHooks{
+		sendDWR:     func() error { calls.dwrs++; return nil },
+		failover:    func() { calls.failovers++ },
+		failback:    func() { calls.failbacks++ },
+		closeConn:   func() { calls.closes++ },
+		attemptOpen: func() { calls.opens++ },
+	})
+	return w, clk, calls
+}
+
+// fire advances clk until the Tw timer of w has fired once. The steps are
+// short enough for the timer, restarted with at least Twinit - 2s, not to
+// fire twice.
+func fire(t *testing.T, w *watchdog, clk *fakeclock.Clock) {
+	t.Helper()
+	w.mu.Lock()
+	timer := w.timer
+	w.mu.Unlock()
+	for waited := time.Duration(0); waited <= w.twInit+watchdogJitter; waited += 100 * time.Millisecond {
+		clk.Advance(100 * time.Millisecond)
+		w.mu.Lock()
+		fired := w.timer != timer
+		w.mu.Unlock()
+		if fired {
+			return
+		}
+	}
+	t.Fatal("Tw timer did not fire")
+}
+
+func TestWatchdogJitter(t *testing.T) {
+	w, _, _ := newTestWatchdog(30 * time.Second)
+	seen := map[bool]bool{}
+	for range 1000 {
+		tw := w.tw()
+		if tw < 28*time.Second || tw > 32*time.Second {
+			t.Fatalf("tw() = %v, want 30s +/- 2s", tw)
+		}
+		seen[tw < 30*time.Second] = true
+	}
+	if len(seen) != 2 {
+		t.Error("tw() is not jittered on both sides of Twinit")
+	}
+}
+
+func TestWatchdogMinimumTwinit(t *testing.T) {
+	w, _, _ := newTestWatchdog(time.Second)
+	if w.twInit != minWatchdogTTL {
+		t.Errorf("twInit = %v, want %v", w.twInit, minWatchdogTTL)
+	}
+}
+
+func TestWatchdogOkaySuspectDown(t *testing.T) {
+	const twInit = 10 * time.Second
+	w, clk, calls := newTestWatchdog(twInit)
+	w.connectionUp()
+	if got := w.Status(); got != WatchdogOkay {
+		t.Fatalf("after connectionUp: %v, want OKAY", got)
+	}
+
+	fire(t, w, clk)
+	if calls.dwrs != 1 || w.Status() != WatchdogOkay {
+		t.Fatalf("after first Tw: %d DWRs, %v; want 1 DWR, OKAY", calls.dwrs, w.Status())
+	}
+
+	fire(t, w, clk)
+	if got := w.Status(); got != WatchdogSuspect {
+		t.Fatalf("after one missed DWA: %v, want SUSPECT", got)
+	}
+	if calls.failovers != 1 {
+		t.Errorf("failovers = %d, want 1", calls.failovers)
+	}
+
+	fire(t, w, clk)
+	if got := w.Status(); got != WatchdogDown {
+		t.Fatalf("after two missed DWAs: %v, want DOWN", got)
+	}
+	if calls.closes != 1 {
+		t.Errorf("closes = %d, want 1", calls.closes)
+	}
+
+	fire(t, w, clk)
+	if calls.opens != 1 {
+		t.Errorf("opens while DOWN = %d, want 1", calls.opens)
+	}
+}
+
+func TestWatchdogDWAKeepsOkay(t *testing.T) {
+	const twInit = 10 * time.Second
+	w, clk, calls := newTestWatchdog(twInit)
+	w.connectionUp()
+	for i := 0; i < 5; i++ {
+		fire(t, w, clk)
+		w.received(true)
+	}
+	if got := w.Status(); got != WatchdogOkay {
+		t.Fatalf("status = %v, want OKAY", got)
+	}
+	if calls.dwrs != 5 || calls.failovers != 0 {
+		t.Errorf("%d DWRs and %d failovers, want 5 and 0", calls.dwrs, calls.failovers)
+	}
+}
+
+func TestWatchdogTrafficDefersDWR(t *testing.T) {
+	const twInit = 10 * time.Second
+	w, clk, calls := newTestWatchdog(twInit)
+	w.connectionUp()
+	for i := 0; i < 10; i++ {
+		clk.Advance(twInit / 2)
+		w.received(false)
+	}
+	if calls.dwrs != 0 {
+		t.Errorf("DWRs sent despite traffic: %d", calls.dwrs)
+	}
+}
+
+func TestWatchdogSuspectRecoversOnTraffic(t *testing.T) {
+	const twInit = 10 * time.Second
+	w, clk, calls := newTestWatchdog(twInit)
+	w.connectionUp()
+	fire(t, w, clk)
+	fire(t, w, clk)
+	if got := w.Status(); got != WatchdogSuspect {
+		t.Fatalf("status = %v, want SUSPECT", got)
+	}
+
+	w.received(false)
+	if got := w.Status(); got != WatchdogOkay {
+		t.Fatalf("after traffic from a SUSPECT peer: %v, want OKAY", got)
+	}
+	if calls.failbacks != 1 {
+		t.Errorf("failbacks = %d, want 1", calls.failbacks)
+	}
+}
+
+func TestWatchdogReopen(t *testing.T) {
+	const twInit = 10 * time.Second
+	w, clk, calls := newTestWatchdog(twInit)
+	w.connectionUp()
+	for range 3 {
+		fire(t, w, clk)
+	}
+	if got := w.Status(); got != WatchdogDown {
+		t.Fatalf("status = %v, want DOWN", got)
+	}
+
+	dwrs := calls.dwrs
+	w.connectionUp()
+	if got := w.Status(); got != WatchdogReopen {
+		t.Fatalf("after reconnecting: %v, want REOPEN", got)
+	}
+	if calls.dwrs != dwrs+1 {
+		t.Errorf("no DWR sent on entering REOPEN")
+	}
+	for i := 1; i < watchdogReopenDWA; i++ {
+		w.received(true)
+		if got := w.Status(); got != WatchdogReopen {
+			t.Fatalf("after %d DWAs: %v, want REOPEN", i, got)
+		}
+		fire(t, w, clk)
+	}
+	w.received(true)
+	if got := w.Status(); got != WatchdogOkay {
+		t.Fatalf("after %d DWAs: %v, want OKAY", watchdogReopenDWA, got)
+	}
+	if calls.failbacks != 1 {
+		t.Errorf("failbacks = %d, want 1", calls.failbacks)
+	}
+}
+
+func TestWatchdogReopenMissedDWAs(t *testing.T) {
+	const twInit = 10 * time.Second
+	w, clk, calls := newTestWatchdog(twInit)
+	w.connectionUp()
+	for range 3 {
+		fire(t, w, clk)
+	}
+	w.connectionUp()
+	closes := calls.closes
+
+	fire(t, w, clk)
+	if got := w.Status(); got != WatchdogReopen {
+		t.Fatalf("after one missed DWA in REOPEN: %v, want REOPEN", got)
+	}
+	fire(t, w, clk)
+	if got := w.Status(); got != WatchdogDown {
+		t.Fatalf("after two missed DWAs in REOPEN: %v, want DOWN", got)
+	}
+	if calls.closes != closes+1 {
+		t.Errorf("connection not closed on going DOWN from REOPEN")
+	}
+}
+
+func TestWatchdogConnectionDown(t *testing.T) {
+	w, _, calls := newTestWatchdog(10 * time.Second)
+	w.connectionDown()
+	if calls.closes != 0 || w.Status() != WatchdogInitial {
+		t.Fatalf("connectionDown acted on a connection never up")
+	}
+
+	w.connectionUp()
+	w.connectionDown()
+	if got := w.Status(); got != WatchdogDown {
+		t.Fatalf("status = %v, want DOWN", got)
+	}
+	if calls.closes != 1 || calls.opens != 1 {
+		t.Errorf("%d closes and %d opens, want 1 and 1", calls.closes, calls.opens)
+	}
+}
+
+func TestWatchdogStop(t *testing.T) {
+	const twInit = 10 * time.Second
+	w, clk, calls := newTestWatchdog(twInit)
+	w.connectionUp()
+	w.stop()
+	if got := w.Status(); got != WatchdogInitial {
+		t.Fatalf("after stop: %v, want INITIAL", got)
+	}
+	clk.Advance(3 * (twInit + watchdogJitter))
+	if calls.dwrs != 0 || calls.opens != 0 {
+		t.Errorf("stopped watchdog sent %d DWRs and made %d open attempts", calls.dwrs, calls.opens)
+	}
+	if clk.Pending() != 0 {
+		t.Errorf("%d timers left after stop", clk.Pending())
+	}
+}
+
+// TestWatchdogHooksRunUnlocked blocks the DWR write and checks that the
+// watchdog still takes the DWA and reports its state meanwhile.
+func TestWatchdogHooksRunUnlocked(t *testing.T) {
+	const twInit = 10 * time.Second
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	writing, release := make(chan struct{}), make(chan struct{})
+	w := newWatchdog(clk, twInit, watchdogHooks{
+		sendDWR: func() error {
+			close(writing)
+			<-release
+			return nil
+		},
+	})
+	w.connectionUp()
+	go clk.Advance(twInit + watchdogJitter)
+	select {
+	case <-writing:
+	case <-time.After(time.Second):
+		t.Fatal("DWR not sent")
+	}
+	defer close(release)
+
+	done := make(chan struct{})
+	go func() {
+		w.received(true)
+		w.Status()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watchdog blocked by the DWR write")
+	}
+	w.mu.Lock()
+	pending := w.pending
+	w.mu.Unlock()
+	if pending {
+		t.Error("DWA taken during the write left the DWR outstanding")
+	}
+}
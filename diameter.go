@@ -1,26 +1,218 @@
+// Package diameter is the entry point for the common case: dialing a peer
+// and sending requests, or serving requests from peers. It is a thin layer
+// over the client, server and message packages, which remain available
+// for anything it does not cover.
 package diameter
 
-// import (
-// 	"diameter/client"
-// 	"diameter/message"
-// 	"diameter/server"
-// )
-//
-// // Client API
-// func NewClient(addr string) *client.Client {
-// 	return client.New(addr)
-// }
-//
-// // Server API
-// func NewServer(addr string) *server.Server {
-// 	return server.New(addr)
-// }
-//
-// // Message/AVP API for constructing Diameter messages
-// func NewDiameterMessage(cmdCode uint32, isRequest bool) *message.DiameterMessage {
-// 	return message.NewDiameterMessage(cmdCode, isRequest)
-// }
-//
-// func NewAVP(code uint32, value interface{}) *message.AVP {
-// 	return message.NewAVP(code, value)
-// }
+import (
+	"context"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/client"
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+)
+
+// DefaultRequestTimeout bounds Conn.Do when the context has no deadline.
+const DefaultRequestTimeout = 30 * time.Second
+
+// DefaultDialTimeout bounds Dial, including the capabilities exchange.
+const DefaultDialTimeout = 10 * time.Second
+
+type (
+	Message        = message.DiameterMessage
+	AVP            = message.AVP
+	ResultCode     = message.ResultCode
+	ResponseWriter = server.ResponseWriter
+)
+
+type config struct {
+	client         []client.ClientOptionsFunc
+	server         []server.ServerOptionsFunc
+	requestTimeout time.Duration
+	dialTimeout    time.Duration
+}
+
+// Option configures Dial and NewServer. Options that only make sense on
+// one side are ignored by the other.
+type Option func(*config)
+
+func newConfig(opts []Option) config {
+	c := config{
+		requestTimeout: DefaultRequestTimeout,
+		dialTimeout:    DefaultDialTimeout,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// WithOriginHost sets the Origin-Host advertised to peers.
+func WithOriginHost(host string) Option {
+	return func(c *config) {
+		c.client = append(c.client, client.WithOriginHost(host))
+		c.server = append(c.server, server.WithOriginHost(host))
+	}
+}
+
+// WithOriginRealm sets the Origin-Realm advertised to peers.
+func WithOriginRealm(realm string) Option {
+	return func(c *config) {
+		c.client = append(c.client, client.WithOriginRealm(realm))
+		c.server = append(c.server, server.WithOriginRealm(realm))
+	}
+}
+
+// WithAuthApplications advertises authentication applications.
+func WithAuthApplications(ids ...uint32) Option {
+	return func(c *config) {
+		c.client = append(c.client, client.WithAuthApplications(ids...))
+		c.server = append(c.server, server.WithAuthApplications(ids...))
+	}
+}
+
+// WithAcctApplications advertises accounting applications.
+func WithAcctApplications(ids ...uint32) Option {
+	return func(c *config) {
+		c.client = append(c.client, client.WithAcctApplications(ids...))
+		c.server = append(c.server, server.WithAcctApplications(ids...))
+	}
+}
+
+// WithWatchdogInterval sets the Device-Watchdog interval.
+func WithWatchdogInterval(interval time.Duration) Option {
+	return func(c *config) {
+		c.client = append(c.client, client.WithWatchdogTTL(interval))
+		c.server = append(c.server, server.WithWatchdogTTL(interval))
+	}
+}
+
+// WithSCTP uses SCTP instead of TCP.
+func WithSCTP() Option {
+	return func(c *config) {
+		c.client = append(c.client, client.WithSCTP())
+		c.server = append(c.server, server.WithSCTP())
+	}
+}
+
+// WithRequestTimeout sets how long a request waits for its answer when
+// the caller's context has no deadline.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.requestTimeout = timeout
+		c.server = append(c.server, server.WithRequestTimeout(timeout))
+	}
+}
+
+// WithDialTimeout bounds Dial, including the capabilities exchange.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.dialTimeout = timeout
+		c.client = append(c.client, client.WithConnectionTimeout(timeout))
+	}
+}
+
+// WithClientOptions passes options to the underlying client.
+func WithClientOptions(opts ...client.ClientOptionsFunc) Option {
+	return func(c *config) {
+		c.client = append(c.client, opts...)
+	}
+}
+
+// WithServerOptions passes options to the underlying server.
+func WithServerOptions(opts ...server.ServerOptionsFunc) Option {
+	return func(c *config) {
+		c.server = append(c.server, opts...)
+	}
+}
+
+// Conn is an open connection to a peer.
+type Conn struct {
+	*client.Client
+	requestTimeout time.Duration
+}
+
+// Dial connects to the peer at addr and returns once the capabilities
+// exchange has completed.
+func Dial(addr string, opts ...Option) (*Conn, error) {
+	cfg := newConfig(opts)
+	c, err := client.NewClient(append([]client.ClientOptionsFunc{client.WithServerAddr(addr)}, cfg.client...)...)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Connect(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.dialTimeout)
+	defer cancel()
+	// The client may still wait for the CEA, where no DPR can be sent:
+	// Close drops the connection whatever the state.
+	if err := c.WaitReady(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return &Conn{Client: c, requestTimeout: cfg.requestTimeout}, nil
+}
+
+// NewRequest builds a request for code carrying the local Origin-Host and
+// Origin-Realm, the peer's realm as Destination-Realm, and avps.
+func (c *Conn) NewRequest(code uint32, avps ...*AVP) (*Message, error) {
+	local := c.LocalIdentity()
+	identity, err := message.Node{OriginHost: local.Host, OriginRealm: local.Realm}.IdentityAVPs()
+	if err != nil {
+		return nil, err
+	}
+	destRealm, err := message.NewAVP(message.AVP_DESTINATION_REALM, c.PeerIdentity().Realm, message.MANDATORY_FLAG)
+	if err != nil {
+		return nil, err
+	}
+	identity = append(identity, destRealm)
+	req, err := c.Client.NewRequest(code, message.WithAVPs(append(identity, avps...)...))
+	if err != nil {
+		return nil, err
+	}
+	// Session-Id, when among avps, has to lead the message.
+	req.Normalize()
+	return req, nil
+}
+
+// Do sends req and waits for its answer, for at most the request timeout
+// unless ctx carries an earlier deadline.
+func (c *Conn) Do(ctx context.Context, req *Message) (*Message, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.requestTimeout)
+		defer cancel()
+	}
+	return c.Request(ctx, req)
+}
+
+// Close disconnects from the peer with a Disconnect-Peer-Request.
+func (c *Conn) Close() error {
+	return c.Disconnect()
+}
+
+// Server serves requests from peers. Register handlers with HandleFunc and
+// start it with ListenAndServe.
+type Server struct {
+	*server.Server
+}
+
+// NewServer creates a server listening on addr.
+func NewServer(addr string, opts ...Option) (*Server, error) {
+	cfg := newConfig(opts)
+	s, err := server.NewServer(append([]server.ServerOptionsFunc{server.WithServerAddr(addr)}, cfg.server...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{Server: s}, nil
+}
+
+// Answer builds an answer to req carrying resultCode, the server's
+// Origin-Host and Origin-Realm, and avps. Protocol errors get the 'E' bit.
+func (s *Server) Answer(req *Message, resultCode ResultCode, avps ...*AVP) (*Message, error) {
+	local := s.LocalIdentity()
+	return message.Node{OriginHost: local.Host, OriginRealm: local.Realm}.BuildAnswer(req, resultCode, avps...)
+}
@@ -0,0 +1,186 @@
+package diameter_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter"
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+var serverOpts = []diameter.Option{
+	diameter.WithOriginHost("server.example.com"),
+	diameter.WithOriginRealm("example.com"),
+	diameter.WithAuthApplications(message.APPLICATION_ID_CREDIT_CONTROL),
+}
+
+// startServer starts a facade server with handler for Credit-Control on
+// a loopback port and returns its address.
+func startServer(t *testing.T, handler func(*diameter.Server, diameter.ResponseWriter, *diameter.Message), opts ...diameter.Option) string {
+	t.Helper()
+	s, err := diameter.NewServer("127.0.0.1:0", append(serverOpts, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL,
+		func(w diameter.ResponseWriter, req *diameter.Message) { handler(s, w, req) })
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve() }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+		<-served
+	})
+	return s.ListenerAddrs()[0].String()
+}
+
+func dial(t *testing.T, addr string, opts ...diameter.Option) *diameter.Conn {
+	t.Helper()
+	conn, err := diameter.Dial(addr, append([]diameter.Option{
+		diameter.WithOriginHost("client.example.com"),
+		diameter.WithOriginRealm("example.com"),
+		diameter.WithAuthApplications(message.APPLICATION_ID_CREDIT_CONTROL),
+		diameter.WithDialTimeout(5 * time.Second),
+	}, opts...)...)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func newCCR(t *testing.T, conn *diameter.Conn, session string) *diameter.Message {
+	t.Helper()
+	req, err := conn.NewRequest(message.COMMAND_CODE_CREDIT_CONTROL,
+		message.MustNewAVP(message.AVP_AUTH_APPLICATION_ID, message.APPLICATION_ID_CREDIT_CONTROL, message.MANDATORY_FLAG),
+		message.MustNewAVP(message.AVP_SESSION_ID, session, message.MANDATORY_FLAG),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestDialAndDo(t *testing.T) {
+	var received *diameter.Message
+	addr := startServer(t, func(s *diameter.Server, w diameter.ResponseWriter, req *diameter.Message) {
+		received = req
+		ans, err := s.Answer(req, message.DIAMETER_SUCCESS, req.FindAVP(message.AVP_SESSION_ID))
+		if err == nil {
+			err = w.WriteMessage(ans)
+		}
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	conn := dial(t, addr)
+
+	req := newCCR(t, conn, "client.example.com;1;1")
+	if req.AVPs[0].Code != message.AVP_SESSION_ID {
+		t.Errorf("request starts with AVP %d, want Session-Id", req.AVPs[0].Code)
+	}
+	ans, err := conn.Do(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if code, _, _ := message.GetResultCode(ans); code != message.DIAMETER_SUCCESS {
+		t.Errorf("answer with %v", code)
+	}
+	if host, _ := message.GetOriginHost(ans); host != "server.example.com" {
+		t.Errorf("answer from %q", host)
+	}
+	if session, _ := message.GetSessionID(ans); session != "client.example.com;1;1" {
+		t.Errorf("answer for session %q", session)
+	}
+	for code, want := range map[uint32]string{
+		message.AVP_ORIGIN_HOST:       "client.example.com",
+		message.AVP_ORIGIN_REALM:      "example.com",
+		message.AVP_DESTINATION_REALM: "example.com",
+	} {
+		avp := received.FindAVP(code)
+		if avp == nil || avp.Data.String() != want {
+			t.Errorf("request AVP %d = %v, want %q", code, avp, want)
+		}
+	}
+}
+
+// TestDoRequestTimeout checks that Do gives up after the request timeout
+// when the context has no deadline, and after the deadline of the context
+// when it is earlier.
+func TestDoRequestTimeout(t *testing.T) {
+	addr := startServer(t, func(*diameter.Server, diameter.ResponseWriter, *diameter.Message) {})
+	conn := dial(t, addr, diameter.WithRequestTimeout(50*time.Millisecond))
+
+	start := time.Now()
+	_, err := conn.Do(context.Background(), newCCR(t, conn, "client.example.com;1;1"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Do without deadline = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Do returned after %v", elapsed)
+	}
+
+	conn = dial(t, addr, diameter.WithRequestTimeout(time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := conn.Do(ctx, newCCR(t, conn, "client.example.com;1;2")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Do with deadline = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestDialFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	conn, err := diameter.Dial(addr, diameter.WithOriginHost("client.example.com"),
+		diameter.WithOriginRealm("example.com"), diameter.WithDialTimeout(time.Second))
+	if err == nil {
+		conn.Close()
+		t.Fatal("Dial to a closed port succeeded")
+	}
+}
+
+// TestDialNoCEA dials a peer that never answers the CER, and checks that
+// Dial gives up after its timeout and closes the connection.
+func TestDialNoCEA(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	conn, err := diameter.Dial(ln.Addr().String(), diameter.WithOriginHost("client.example.com"),
+		diameter.WithOriginRealm("example.com"), diameter.WithDialTimeout(100*time.Millisecond))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		if err == nil {
+			conn.Close()
+		}
+		t.Fatalf("Dial = %v, want DeadlineExceeded", err)
+	}
+
+	peer := <-accepted
+	defer peer.Close()
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// The CER is read, then the connection ends.
+	if _, err := io.Copy(io.Discard, peer); err != nil {
+		t.Errorf("reading from the client: %v, want the connection closed", err)
+	}
+}
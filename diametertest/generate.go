@@ -0,0 +1,249 @@
+// Package diametertest provides helpers for testing Diameter applications.
+package diametertest
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net"
+
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+var ErrUnknownCommand = errors.New("command is not in the dictionary")
+
+// Violation is the rule violation introduced into a generated message.
+type Violation int
+
+const (
+	NoViolation Violation = iota
+	// MissingMandatoryAVP drops one of the required AVPs.
+	MissingMandatoryAVP
+	// WrongType replaces the value of a fixed size AVP with data of the
+	// wrong length.
+	WrongType
+	// OversizedValue replaces the value of an AVP with a value larger than
+	// the default maximum message size.
+	OversizedValue
+)
+
+func (v Violation) String() string {
+	switch v {
+	case NoViolation:
+		return "none"
+	case MissingMandatoryAVP:
+		return "missing mandatory AVP"
+	case WrongType:
+		return "wrong type"
+	case OversizedValue:
+		return "oversized value"
+	}
+	return "unknown"
+}
+
+const (
+	// DefaultOptionalProbability is the chance of each optional AVP being
+	// included.
+	DefaultOptionalProbability = 0.5
+	// violationProbability is the chance of a message breaking a rule when
+	// violations are enabled.
+	violationProbability = 0.25
+	maxRandomLength      = 32
+	oversizedValueLength = 70000
+)
+
+type generateOptions struct {
+	optionalProbability float64
+	violations          bool
+}
+
+// GenerateOption configures GenerateMessage.
+type GenerateOption func(*generateOptions)
+
+// WithOptionalProbability sets the chance, between 0 and 1, of each
+// optional AVP being included.
+func WithOptionalProbability(p float64) GenerateOption {
+	return func(o *generateOptions) {
+		o.optionalProbability = p
+	}
+}
+
+// WithViolations makes the generator occasionally break a command rule.
+func WithViolations() GenerateOption {
+	return func(o *generateOptions) {
+		o.violations = true
+	}
+}
+
+// GenerateMessage returns a random request for the command code using the
+// rules of the command dictionary: every required AVP is present with a
+// random value of its type and optional AVPs are added at random.
+func GenerateMessage(rng *rand.Rand, appID, code uint32, opts ...GenerateOption) (*message.DiameterMessage, error) {
+	msg, _, err := Generate(rng, appID, code, opts...)
+	return msg, err
+}
+
+// Generate is like GenerateMessage but also reports the rule violation, if
+// any, introduced into the message.
+func Generate(rng *rand.Rand, appID, code uint32, opts ...GenerateOption) (*message.DiameterMessage, Violation, error) {
+	o := generateOptions{optionalProbability: DefaultOptionalProbability}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	cmd, ok := message.LookupApplicationCommand(appID, code)
+	if !ok {
+		return nil, NoViolation, ErrUnknownCommand
+	}
+
+	avps := make([]*message.AVP, 0, len(cmd.RequiredAVPs)+len(cmd.OptionalAVPs))
+	for _, avpCode := range cmd.RequiredAVPs {
+		avp, err := randomAVP(rng, avpCode)
+		if err != nil {
+			return nil, NoViolation, err
+		}
+		avps = append(avps, avp)
+	}
+	for _, avpCode := range cmd.OptionalAVPs {
+		if rng.Float64() >= o.optionalProbability {
+			continue
+		}
+		avp, err := randomAVP(rng, avpCode)
+		if err != nil {
+			return nil, NoViolation, err
+		}
+		avps = append(avps, avp)
+	}
+
+	violation := NoViolation
+	if o.violations && rng.Float64() < violationProbability {
+		avps, violation = violate(rng, avps, len(cmd.RequiredAVPs))
+	}
+
+	msg, err := message.NewRequest(code, message.WithApplication(appID), message.WithAVPs(avps...))
+	if err != nil {
+		return nil, NoViolation, err
+	}
+	return msg, violation, nil
+}
+
+// violate breaks one rule in avps, whose first required entries are the
+// required AVPs.
+func violate(rng *rand.Rand, avps []*message.AVP, required int) ([]*message.AVP, Violation) {
+	switch Violation(1 + rng.IntN(3)) {
+	case WrongType:
+		if i, ok := pickFixedSize(rng, avps); ok {
+			avps[i] = withData(avps[i], &message.OctetString{Data: randomBytes(rng, 1+rng.IntN(3))})
+			return avps, WrongType
+		}
+	case OversizedValue:
+		if len(avps) > 0 {
+			i := rng.IntN(len(avps))
+			avps[i] = withData(avps[i], &message.OctetString{Data: randomBytes(rng, oversizedValueLength)})
+			return avps, OversizedValue
+		}
+	}
+	if required == 0 {
+		return avps, NoViolation
+	}
+	i := rng.IntN(required)
+	return append(avps[:i], avps[i+1:]...), MissingMandatoryAVP
+}
+
+// pickFixedSize returns the index of a random AVP whose type has a fixed
+// length.
+func pickFixedSize(rng *rand.Rand, avps []*message.AVP) (int, bool) {
+	var candidates []int
+	for i, avp := range avps {
+		if fixedSize(avp.Data) {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return 0, false
+	}
+	return candidates[rng.IntN(len(candidates))], true
+}
+
+func fixedSize(data message.AVPData) bool {
+	switch data.(type) {
+	case *message.Integer32, *message.Integer64, *message.Unsigned32, *message.Unsigned64,
+		*message.Float32, *message.Float64, *message.Enumerated, *message.Time,
+		*message.AppId, *message.VendorId, *message.Address:
+		return true
+	}
+	return false
+}
+
+func randomAVP(rng *rand.Rand, code uint32) (*message.AVP, error) {
+	data, ok := message.NewAVPData(code)
+	if !ok {
+		data = &message.OctetString{}
+	}
+	if err := data.SetData(randomValue(rng, data)); err != nil {
+		return nil, err
+	}
+	return withData(&message.AVP{Code: code, Flags: message.MANDATORY_FLAG}, data), nil
+}
+
+// withData returns a copy of avp carrying data with a matching length.
+func withData(avp *message.AVP, data message.AVPData) *message.AVP {
+	headerLen := message.AVPHeaderLength
+	if avp.Flags&message.VENDOR_FLAG != 0 {
+		headerLen = message.AVPHeaderLengthWithV
+	}
+	return &message.AVP{
+		Code:      avp.Code,
+		Flags:     avp.Flags,
+		AVPlength: uint32(headerLen) + data.Length(),
+		VendorID:  avp.VendorID,
+		Data:      data,
+	}
+}
+
+// randomValue returns a random value accepted by the SetData method of
+// data.
+func randomValue(rng *rand.Rand, data message.AVPData) interface{} {
+	switch data.(type) {
+	case *message.Integer32:
+		return rng.Int32()
+	case *message.Integer64:
+		return rng.Int64()
+	case *message.Unsigned32, *message.Enumerated, *message.Time,
+		*message.AppId, *message.VendorId, *message.IPFilterRule:
+		return rng.Uint32()
+	case *message.Unsigned64:
+		return rng.Uint64()
+	case *message.Float32:
+		return rng.Float32()
+	case *message.Float64:
+		return rng.Float64()
+	case *message.Address:
+		return net.IPv4(byte(rng.IntN(256)), byte(rng.IntN(256)), byte(rng.IntN(256)), byte(rng.IntN(256)))
+	case *message.DiameterIdentity:
+		return randomString(rng, 1+rng.IntN(maxRandomLength)) + ".example.net"
+	case *message.DiameterURI:
+		return "aaa://" + randomString(rng, 1+rng.IntN(maxRandomLength)) + ".example.net"
+	case *message.UTF8String:
+		return randomString(rng, 1+rng.IntN(maxRandomLength))
+	case *message.Grouped:
+		return []*message.AVP{}
+	}
+	return randomBytes(rng, rng.IntN(maxRandomLength))
+}
+
+const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+func randomString(rng *rand.Rand, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = letters[rng.IntN(len(letters))]
+	}
+	return string(b)
+}
+
+func randomBytes(rng *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(rng.Uint32())
+	}
+	return b
+}
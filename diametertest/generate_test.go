@@ -0,0 +1,174 @@
+package diametertest
+
+import (
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+// generatedCommands are the commands of the dictionary the tests generate,
+// with their application.
+var generatedCommands = []struct {
+	appID, code uint32
+}{
+	{message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL},
+	{message.APPLICATION_ID_BASE_ACCOUNTING, message.COMMAND_CODE_ACCOUNTING},
+	{message.APPLICATION_ID_DIAMETER_COMMON_MESSAGES, message.COMMAND_CODE_CAPABILITIES_EXCHANGE},
+	{message.APPLICATION_ID_DIAMETER_COMMON_MESSAGES, message.COMMAND_CODE_DEVICE_WATCHDOG},
+}
+
+func codes(msg *message.DiameterMessage) []uint32 {
+	var codes []uint32
+	for _, avp := range msg.AVPs {
+		codes = append(codes, avp.Code)
+	}
+	return codes
+}
+
+func TestGenerateFollowsRules(t *testing.T) {
+	for _, c := range generatedCommands {
+		cmd, _ := message.LookupCommand(c.code)
+		for seed := range uint64(100) {
+			rng := rand.New(rand.NewPCG(seed, uint64(c.code)))
+			msg, err := GenerateMessage(rng, c.appID, c.code)
+			if err != nil {
+				t.Fatalf("command %d, seed %d: %v", c.code, seed, err)
+			}
+			if !msg.Header.CommandFlags.Request() || msg.Header.ApplicationID != c.appID {
+				t.Fatalf("command %d, seed %d: not a request of application %d", c.code, seed, c.appID)
+			}
+			got := codes(msg)
+			for _, code := range cmd.RequiredAVPs {
+				if !slices.Contains(got, code) {
+					t.Errorf("command %d, seed %d: required AVP %d missing", c.code, seed, code)
+				}
+			}
+			for _, code := range got {
+				if !slices.Contains(cmd.RequiredAVPs, code) && !slices.Contains(cmd.OptionalAVPs, code) {
+					t.Errorf("command %d, seed %d: AVP %d not in the command", c.code, seed, code)
+				}
+			}
+
+			encoded, err := msg.Encode()
+			if err != nil {
+				t.Fatalf("command %d, seed %d: encoding: %v", c.code, seed, err)
+			}
+			decoded, err := message.DecodeMessage(encoded)
+			if err != nil {
+				t.Fatalf("command %d, seed %d: decoding: %v", c.code, seed, err)
+			}
+			if len(decoded.AVPs) != len(msg.AVPs) {
+				t.Errorf("command %d, seed %d: %d AVPs decoded, %d generated", c.code, seed, len(decoded.AVPs), len(msg.AVPs))
+			}
+		}
+	}
+}
+
+func TestGenerateOptionalProbability(t *testing.T) {
+	cmd, _ := message.LookupCommand(message.COMMAND_CODE_CREDIT_CONTROL)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, tc := range []struct {
+		p    float64
+		want int
+	}{
+		{0, len(cmd.RequiredAVPs)},
+		{1, len(cmd.RequiredAVPs) + len(cmd.OptionalAVPs)},
+	} {
+		msg, err := GenerateMessage(rng, message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, WithOptionalProbability(tc.p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msg.AVPs) != tc.want {
+			t.Errorf("probability %v: %d AVPs, want %d", tc.p, len(msg.AVPs), tc.want)
+		}
+	}
+}
+
+func TestGenerateViolations(t *testing.T) {
+	cmd, _ := message.LookupCommand(message.COMMAND_CODE_CREDIT_CONTROL)
+	seen := map[Violation]int{}
+	for seed := range uint64(400) {
+		rng := rand.New(rand.NewPCG(seed, 0))
+		msg, violation, err := Generate(rng, message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL,
+			WithOptionalProbability(1), WithViolations())
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		seen[violation]++
+		got := codes(msg)
+		switch violation {
+		case NoViolation:
+			if len(got) != len(cmd.RequiredAVPs)+len(cmd.OptionalAVPs) {
+				t.Errorf("seed %d: %d AVPs without a violation", seed, len(got))
+			}
+		case MissingMandatoryAVP:
+			missing := 0
+			for _, code := range cmd.RequiredAVPs {
+				if !slices.Contains(got, code) {
+					missing++
+				}
+			}
+			if missing != 1 {
+				t.Errorf("seed %d: %d required AVPs missing, want 1", seed, missing)
+			}
+		case WrongType:
+			if !slices.ContainsFunc(msg.AVPs, func(avp *message.AVP) bool {
+				_, octets := avp.Data.(*message.OctetString)
+				want, _ := message.NewAVPData(avp.Code)
+				return octets && fixedSize(want) && avp.Data.Length() < 4
+			}) {
+				t.Errorf("seed %d: no fixed size AVP with a short value", seed)
+			}
+		case OversizedValue:
+			if !slices.ContainsFunc(msg.AVPs, func(avp *message.AVP) bool {
+				return avp.Data.Length() == oversizedValueLength
+			}) {
+				t.Errorf("seed %d: no oversized value", seed)
+			}
+		}
+		for _, avp := range msg.AVPs {
+			if avp.AVPlength != uint32(message.AVPHeaderLength)+avp.Data.Length() {
+				t.Errorf("seed %d: AVP %d length %d for %d bytes of data", seed, avp.Code, avp.AVPlength, avp.Data.Length())
+			}
+		}
+	}
+	for _, v := range []Violation{NoViolation, MissingMandatoryAVP, WrongType, OversizedValue} {
+		if seen[v] == 0 {
+			t.Errorf("no message generated with violation %q", v)
+		}
+	}
+}
+
+func TestWithDataLength(t *testing.T) {
+	data := &message.OctetString{Data: []byte("abc")}
+	for _, tc := range []struct {
+		flags uint8
+		want  uint32
+	}{
+		{message.MANDATORY_FLAG, message.AVPHeaderLength + 3},
+		{message.MANDATORY_FLAG | message.VENDOR_FLAG, message.AVPHeaderLengthWithV + 3},
+	} {
+		avp := withData(&message.AVP{Code: 1, Flags: tc.flags, VendorID: message.VENDOR_3GPP}, data)
+		if avp.AVPlength != tc.want {
+			t.Errorf("flags %#x: length %d, want %d", tc.flags, avp.AVPlength, tc.want)
+		}
+		encoded, err := avp.Encode()
+		if err != nil {
+			t.Fatalf("flags %#x: encoding: %v", tc.flags, err)
+		}
+		var decoded message.AVP
+		if err := decoded.Decode(encoded); err != nil {
+			t.Errorf("flags %#x: decoding: %v", tc.flags, err)
+		}
+	}
+}
+
+func TestGenerateUnknownCommand(t *testing.T) {
+	_, err := GenerateMessage(rand.New(rand.NewPCG(0, 0)), 0, 9999)
+	if !errors.Is(err, ErrUnknownCommand) {
+		t.Errorf("GenerateMessage = %v, want ErrUnknownCommand", err)
+	}
+}
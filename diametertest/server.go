@@ -0,0 +1,45 @@
+// Servers on ephemeral ports for tests
+package diametertest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/server"
+)
+
+// shutdownTimeout bounds the wait for the handlers of a test server when
+// the test ends.
+const shutdownTimeout = 5 * time.Second
+
+// StartServer starts a server configured by opts on a port of the
+// loopback interface chosen by the system, and shuts it down when the test
+// ends. It returns the server and the address to connect to, so that
+// tests using it can run in parallel. opts may set another address with
+// server.WithServerAddr; a port of 0 is still reported correctly.
+func StartServer(t testing.TB, opts ...server.ServerOptionsFunc) (*server.Server, string) {
+	t.Helper()
+	s, err := server.NewServer(append([]server.ServerOptionsFunc{server.WithServerAddr("127.0.0.1:0")}, opts...)...)
+	if err != nil {
+		t.Fatalf("creating server: %v", err)
+	}
+	if err := s.Listen(); err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	addrs := s.ListenerAddrs()
+	if len(addrs) == 0 {
+		t.Fatal("server has no listener address")
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve() }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			t.Errorf("shutting down server: %v", err)
+		}
+		<-served
+	})
+	return s, addrs[0].String()
+}
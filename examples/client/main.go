@@ -0,0 +1,34 @@
+// Command client sends one Credit-Control-Request and prints the answer.
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/IbrahimShahzad/diameter"
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+func main() {
+	conn, err := diameter.Dial("localhost:3868",
+		diameter.WithOriginHost("client.example.com"),
+		diameter.WithOriginRealm("example.com"),
+		diameter.WithAuthApplications(message.APPLICATION_ID_CREDIT_CONTROL),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	sessionID, _ := message.NewAVP(message.AVP_SESSION_ID, "client.example.com;1;1", message.MANDATORY_FLAG)
+	appID, _ := message.NewAVP(message.AVP_AUTH_APPLICATION_ID, message.APPLICATION_ID_CREDIT_CONTROL, message.MANDATORY_FLAG)
+	ccr, err := conn.NewRequest(message.COMMAND_CODE_CREDIT_CONTROL, sessionID, appID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cca, err := conn.Do(context.Background(), ccr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Received CCA:\n%s", cca)
+}
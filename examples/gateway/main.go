@@ -0,0 +1,144 @@
+// Command gateway exposes server-originated Re-Auth-Requests over HTTP.
+//
+// A POST to /rar?peer=<origin-host> carrying a JSON message, as produced by
+// message.DiameterMessage.MarshalJSON, sends a RAR with the given
+// application and AVPs to the named peer and returns the RAA as JSON:
+//
+//	curl -d '{"application_id":4,"avps":[{"code":263,"flags":64,"value":"s1"}]}' \
+//		'localhost:8080/rar?peer=client.example.com'
+//
+// A peer that is not connected yields 503, a timeout 504 and an answer
+// with a 3xxx protocol error 502 with the Result-Code in the body.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+)
+
+type gateway struct {
+	server  *server.Server
+	timeout time.Duration
+}
+
+type errorBody struct {
+	Error      string `json:"error"`
+	ResultCode uint32 `json:"result_code,omitempty"`
+}
+
+func main() {
+	diameterAddr := flag.String("diameter", "localhost:3868", "Diameter listen address")
+	httpAddr := flag.String("http", "localhost:8080", "HTTP listen address")
+	timeout := flag.Duration("timeout", 5*time.Second, "RAR answer timeout")
+	flag.Parse()
+
+	s, err := server.NewServer(
+		server.WithServerAddr(*diameterAddr),
+		server.WithOriginHost("gateway.example.com"),
+		server.WithOriginRealm("example.com"),
+		server.WithAuthApplications(message.APPLICATION_ID_CREDIT_CONTROL),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create server: %v", err)
+	}
+	go func() {
+		log.Fatal(s.ListenAndServe())
+	}()
+
+	g := &gateway{server: s, timeout: *timeout}
+	http.HandleFunc("/rar", g.handleRAR)
+	log.Fatal(http.ListenAndServe(*httpAddr, nil))
+}
+
+func (g *gateway) handleRAR(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, errorBody{Error: "POST required"})
+		return
+	}
+	var body message.DiameterMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorBody{Error: err.Error()})
+		return
+	}
+	id, ok := g.server.LookupPeer(r.URL.Query().Get("peer"))
+	if !ok {
+		writeJSON(w, http.StatusServiceUnavailable, errorBody{Error: server.ErrUnknownPeer.Error()})
+		return
+	}
+
+	rar, err := g.newRAR(id, &body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorBody{Error: err.Error()})
+		return
+	}
+	raa, err := g.server.RequestWithTimeout(id, rar, g.timeout)
+	var answerErr *server.AnswerError
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, raa)
+	case errors.Is(err, server.ErrUnknownPeer), errors.Is(err, server.ErrPeerDisconnected):
+		writeJSON(w, http.StatusServiceUnavailable, errorBody{Error: err.Error()})
+	case errors.Is(err, server.ErrRequestTimeout):
+		writeJSON(w, http.StatusGatewayTimeout, errorBody{Error: err.Error()})
+	case errors.As(err, &answerErr) && answerErr.ProtocolError():
+		writeJSON(w, http.StatusBadGateway, errorBody{
+			Error:      err.Error(),
+			ResultCode: uint32(answerErr.ResultCode),
+		})
+	case errors.As(err, &answerErr):
+		// Permanent and transient failures are valid answers for the
+		// caller to interpret.
+		writeJSON(w, http.StatusOK, raa)
+	default:
+		writeJSON(w, http.StatusBadGateway, errorBody{Error: err.Error()})
+	}
+}
+
+// newRAR builds a RAR for the peer from the application and AVPs of body,
+// adding the identity AVPs the caller left out.
+func (g *gateway) newRAR(peer message.PeerIdentity, body *message.DiameterMessage) (*message.DiameterMessage, error) {
+	avps := body.AVPs
+	local := g.server.LocalIdentity()
+	for _, a := range []struct {
+		code  uint32
+		value string
+	}{
+		{message.AVP_ORIGIN_HOST, local.Host},
+		{message.AVP_ORIGIN_REALM, local.Realm},
+		{message.AVP_DESTINATION_HOST, peer.Host},
+		{message.AVP_DESTINATION_REALM, peer.Realm},
+	} {
+		if body.GetAVP(a.code) != nil {
+			continue
+		}
+		avp, err := message.NewAVP(a.code, a.value, message.MANDATORY_FLAG)
+		if err != nil {
+			return nil, err
+		}
+		avps = append(avps, avp)
+	}
+	var appID uint32
+	if body.Header != nil {
+		appID = body.Header.ApplicationID
+	}
+	return g.server.NewRequest(
+		message.COMMAND_CODE_RE_AUTH,
+		message.WithApplication(appID),
+		message.WithAVPs(avps...),
+	)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
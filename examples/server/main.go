@@ -0,0 +1,30 @@
+// Command server answers every Credit-Control-Request with DIAMETER_SUCCESS.
+package main
+
+import (
+	"log"
+
+	"github.com/IbrahimShahzad/diameter"
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+func main() {
+	s, err := diameter.NewServer("localhost:3868",
+		diameter.WithOriginHost("server.example.com"),
+		diameter.WithOriginRealm("example.com"),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL,
+		func(w diameter.ResponseWriter, req *diameter.Message) {
+			ans, err := s.Answer(req, message.DIAMETER_SUCCESS)
+			if err == nil {
+				err = w.WriteMessage(ans)
+			}
+			if err != nil {
+				log.Printf("Error answering CCR: %v", err)
+			}
+		})
+	log.Fatal(s.ListenAndServe())
+}
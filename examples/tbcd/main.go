@@ -0,0 +1,116 @@
+// Command tbcd registers a TBCD value type for the 3GPP MSISDN AVP and
+// round-trips an IMSI through it.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+// AVP_MSISDN is MSISDN of 3GPP TS 29.329, an OctetString holding the
+// digits in TBCD.
+const AVP_MSISDN = uint32(701)
+
+// tbcdDigits maps nibbles to the characters of TBCD, 3GPP TS 29.002.
+// Nibble 0xF fills the last octet of an odd number of digits.
+const tbcdDigits = "0123456789*#abc"
+
+// TBCD is a string of digits encoded two per octet, the first in the low
+// nibble.
+type TBCD struct {
+	Digits string
+}
+
+func (t *TBCD) SetData(data interface{}) error {
+	s, ok := data.(string)
+	if !ok {
+		return fmt.Errorf("TBCD from %T", data)
+	}
+	for _, c := range s {
+		if !strings.ContainsRune(tbcdDigits, c) {
+			return fmt.Errorf("invalid TBCD digit %q", c)
+		}
+	}
+	t.Digits = s
+	return nil
+}
+
+func (t *TBCD) Length() uint32 {
+	return uint32(len(t.Digits)+1) / 2
+}
+
+func (t *TBCD) Encode() ([]byte, error) {
+	b := make([]byte, t.Length())
+	for i := range b {
+		b[i] = 0xf0
+	}
+	for i := 0; i < len(t.Digits); i++ {
+		n := strings.IndexByte(tbcdDigits, t.Digits[i])
+		if n < 0 {
+			return nil, fmt.Errorf("invalid TBCD digit %q", t.Digits[i])
+		}
+		if i%2 == 0 {
+			b[i/2] = b[i/2]&0xf0 | byte(n)
+		} else {
+			b[i/2] = b[i/2]&0x0f | byte(n)<<4
+		}
+	}
+	return b, nil
+}
+
+func (t *TBCD) Decode(data []byte) error {
+	var sb strings.Builder
+	for i, b := range data {
+		low, high := b&0x0f, b>>4
+		if low == 0xf {
+			return errors.New("TBCD filler in the low nibble")
+		}
+		sb.WriteByte(tbcdDigits[low])
+		if high == 0xf {
+			if i != len(data)-1 {
+				return errors.New("TBCD filler before the last octet")
+			}
+			break
+		}
+		sb.WriteByte(tbcdDigits[high])
+	}
+	t.Digits = sb.String()
+	return nil
+}
+
+func (t *TBCD) String() string {
+	return t.Digits
+}
+
+func main() {
+	factory := func() message.AVPData { return &TBCD{} }
+	if err := message.CheckAVPData(factory, "", "1", "12", "001010123456789"); err != nil {
+		log.Fatal(err)
+	}
+	if err := message.RegisterAVPType(AVP_MSISDN, message.VENDOR_3GPP, factory); err != nil {
+		log.Fatal(err)
+	}
+
+	const imsi = "001010123456789"
+	avp, err := message.NewAVP(AVP_MSISDN, imsi, message.MANDATORY_FLAG|message.VENDOR_FLAG, message.VENDOR_3GPP)
+	if err != nil {
+		log.Fatal(err)
+	}
+	encoded, err := avp.Encode()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var decoded message.AVP
+	if err := decoded.Decode(encoded); err != nil {
+		log.Fatal(err)
+	}
+	tbcd, ok := decoded.Data.(*TBCD)
+	if !ok || tbcd.Digits != imsi {
+		log.Fatalf("IMSI %s decoded as %T %v", imsi, decoded.Data, decoded.Data)
+	}
+	fmt.Printf("IMSI %s encodes as %x and decodes back\n", imsi, encoded)
+}
@@ -0,0 +1,65 @@
+// Package observers runs the functions applications register to observe
+// messages, isolating the client and server from their failures.
+package observers
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+// Func observes a message. The message belongs to the observer and may be
+// retained.
+type Func func(msg *message.DiameterMessage)
+
+// List is a set of observers. The zero value is an empty list ready to
+// use.
+type List struct {
+	mu     sync.Mutex
+	fns    []Func
+	panics atomic.Uint64
+}
+
+// Add registers fn.
+func (l *List) Add(fn Func) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fns = append(l.fns[:len(l.fns):len(l.fns)], fn)
+}
+
+// Len returns the number of observers, so that callers can skip the work
+// of preparing a message nobody observes.
+func (l *List) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.fns)
+}
+
+// Notify calls every observer, in the order they were added, with its own
+// clone of msg. A panicking observer is logged and counted and does not
+// keep the others from running.
+func (l *List) Notify(msg *message.DiameterMessage) {
+	l.mu.Lock()
+	fns := l.fns
+	l.mu.Unlock()
+	for _, fn := range fns {
+		l.call(fn, msg.Clone())
+	}
+}
+
+func (l *List) call(fn Func, msg *message.DiameterMessage) {
+	defer func() {
+		if r := recover(); r != nil {
+			l.panics.Add(1)
+			log.Printf("Observer of %s panicked: %v", msg.CommandName(), r)
+		}
+	}()
+	fn(msg)
+}
+
+// Panics returns the number of observer calls that panicked.
+func (l *List) Panics() uint64 {
+	return l.panics.Load()
+}
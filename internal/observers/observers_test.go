@@ -0,0 +1,54 @@
+package observers
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+func TestList(t *testing.T) {
+	var l List
+	msg, err := message.NewRequest(message.COMMAND_CODE_RE_AUTH, message.WithAVPs(
+		message.MustNewAVP(message.AVP_SESSION_ID, "server.example.com;1;1", message.MANDATORY_FLAG),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Notify(msg)
+	if l.Len() != 0 || l.Panics() != 0 {
+		t.Fatalf("empty list: Len %d, Panics %d", l.Len(), l.Panics())
+	}
+
+	var calls []string
+	var seen []*message.DiameterMessage
+	l.Add(func(m *message.DiameterMessage) {
+		calls = append(calls, "first")
+		seen = append(seen, m)
+		m.AVPs = nil
+		panic("observer failed")
+	})
+	l.Add(func(m *message.DiameterMessage) {
+		calls = append(calls, "second")
+		seen = append(seen, m)
+	})
+	if n := l.Len(); n != 2 {
+		t.Fatalf("Len = %d, want 2", n)
+	}
+	for range 2 {
+		l.Notify(msg)
+	}
+	if want := []string{"first", "second", "first", "second"}; !slices.Equal(calls, want) {
+		t.Errorf("observers called %v, want %v", calls, want)
+	}
+	if n := l.Panics(); n != 2 {
+		t.Errorf("Panics = %d, want 2", n)
+	}
+	// Each observer gets a clone of its own.
+	if seen[0] == msg || seen[0] == seen[1] {
+		t.Error("observers share the message")
+	}
+	if len(msg.AVPs) != 1 || len(seen[1].AVPs) != 1 {
+		t.Errorf("an observer changed the message seen by others")
+	}
+}
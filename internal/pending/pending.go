@@ -0,0 +1,255 @@
+// Package pending correlates answers with outstanding requests by
+// Hop-by-Hop Identifier. It is shared by the client and the server so that
+// both directions of a connection use the same bookkeeping.
+package pending
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/clock"
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+var (
+	ErrTimeout   = errors.New("request timed out")
+	ErrDuplicate = errors.New("hop-by-hop identifier already pending")
+)
+
+// Result is the outcome of an outstanding request: either its answer or
+// the error that ended the wait.
+type Result struct {
+	Answer *message.DiameterMessage
+	Err    error
+}
+
+type entry struct {
+	ch    chan Result
+	timer clock.Timer
+	// owner is what the request was sent on, usually its connection.
+	owner any
+}
+
+const (
+	// shardCount is the number of independently locked parts of a Table.
+	// Hop-by-Hop Identifiers are usually consecutive, so their low bits
+	// spread requests evenly over the shards.
+	shardCount = 64
+	// shardCapacity is the initial size of the map of a shard.
+	shardCapacity = 16
+)
+
+// shard is one part of a Table. It is padded to a cache line so that
+// neighbouring shards are not contended through false sharing.
+type shard struct {
+	mu      sync.Mutex
+	entries map[uint32]*entry
+	_       [48]byte
+}
+
+// Table holds the requests of one connection that are waiting for an
+// answer. It is split into shards by Hop-by-Hop Identifier so that
+// concurrent requests rarely contend for the same lock.
+type Table struct {
+	clock  clock.Clock
+	shards [shardCount]shard
+
+	orphaned atomic.Uint64
+	timedOut atomic.Uint64
+}
+
+// New returns an empty Table whose timeouts run on clk.
+func New(clk clock.Clock) *Table {
+	t := &Table{clock: clk}
+	for i := range t.shards {
+		t.shards[i].entries = make(map[uint32]*entry, shardCapacity)
+	}
+	return t
+}
+
+func (t *Table) shard(hopByHopID uint32) *shard {
+	return &t.shards[hopByHopID%shardCount]
+}
+
+// Add registers a request awaiting an answer. The returned channel
+// receives exactly one Result. A timeout of 0 disables the sweep for this
+// request; the caller then has to Remove it when giving up.
+func (t *Table) Add(hopByHopID uint32, timeout time.Duration) (<-chan Result, error) {
+	return t.add(nil, hopByHopID, timeout)
+}
+
+func (t *Table) add(owner any, hopByHopID uint32, timeout time.Duration) (<-chan Result, error) {
+	s := t.shard(hopByHopID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[hopByHopID]; ok {
+		return nil, ErrDuplicate
+	}
+	e := &entry{ch: make(chan Result, 1), owner: owner}
+	if timeout > 0 {
+		e.timer = t.clock.AfterFunc(timeout, func() { t.expire(hopByHopID, e) })
+	}
+	s.entries[hopByHopID] = e
+	return e.ch, nil
+}
+
+// maxReserveAttempts bounds the identifiers Reserve draws before giving
+// up. With a counter-based generator only a table holding that many
+// consecutive identifiers can exhaust it.
+const maxReserveAttempts = 1024
+
+// Reserve registers req like Add. When its Hop-by-Hop Identifier is still
+// pending, for instance after the generator wrapped around while an old
+// request was outstanding, further identifiers are drawn from next and the
+// first free one is written into the request header, which must not have
+// been sent yet.
+func (t *Table) Reserve(req *message.DiameterMessage, next func() uint32, timeout time.Duration) (<-chan Result, error) {
+	return t.ReserveFor(nil, req, next, timeout)
+}
+
+// ReserveFor is like Reserve but records owner, typically the connection
+// req goes out on, so that the requests of a table shared by several
+// connections can be counted and failed per connection.
+func (t *Table) ReserveFor(owner any, req *message.DiameterMessage, next func() uint32, timeout time.Duration) (<-chan Result, error) {
+	ch, err := t.add(owner, req.Header.HopByHopID, timeout)
+	for attempt := 0; err == ErrDuplicate && attempt < maxReserveAttempts; attempt++ {
+		id := next()
+		if ch, err = t.add(owner, id, timeout); err == nil {
+			req.Header.HopByHopID = id
+		}
+	}
+	return ch, err
+}
+
+// expire sweeps e out of the table once its timeout has passed.
+func (t *Table) expire(hopByHopID uint32, e *entry) {
+	s := t.shard(hopByHopID)
+	s.mu.Lock()
+	if s.entries[hopByHopID] != e {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.entries, hopByHopID)
+	s.mu.Unlock()
+	t.timedOut.Add(1)
+	e.ch <- Result{Err: ErrTimeout}
+}
+
+// Remove forgets the request with hopByHopID without delivering a result.
+func (t *Table) Remove(hopByHopID uint32) {
+	s := t.shard(hopByHopID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[hopByHopID]; ok {
+		if e.timer != nil {
+			e.timer.Stop()
+		}
+		delete(s.entries, hopByHopID)
+	}
+}
+
+// Deliver hands ans to the request with the same Hop-by-Hop Identifier. It
+// reports false, and counts the answer as orphaned, when no such request
+// is pending. A message with the 'R' bit set is never taken for an answer.
+func (t *Table) Deliver(ans *message.DiameterMessage) bool {
+	if ans.IsRequest() {
+		return false
+	}
+	s := t.shard(ans.Header.HopByHopID)
+	s.mu.Lock()
+	e, ok := s.entries[ans.Header.HopByHopID]
+	if ok {
+		delete(s.entries, ans.Header.HopByHopID)
+	}
+	s.mu.Unlock()
+	if !ok {
+		t.orphaned.Add(1)
+		return false
+	}
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	e.ch <- Result{Answer: ans}
+	return true
+}
+
+// FailAll ends every pending request with err, typically after the
+// connection was lost.
+func (t *Table) FailAll(err error) {
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.mu.Lock()
+		entries := s.entries
+		s.entries = make(map[uint32]*entry, shardCapacity)
+		s.mu.Unlock()
+		for _, e := range entries {
+			if e.timer != nil {
+				e.timer.Stop()
+			}
+			e.ch <- Result{Err: err}
+		}
+	}
+}
+
+// FailOwner ends the pending requests of owner with err, typically after
+// its connection was lost, leaving the requests of other owners pending.
+func (t *Table) FailOwner(owner any, err error) {
+	for i := range t.shards {
+		s := &t.shards[i]
+		var failed []*entry
+		s.mu.Lock()
+		for id, e := range s.entries {
+			if e.owner == owner {
+				failed = append(failed, e)
+				delete(s.entries, id)
+			}
+		}
+		s.mu.Unlock()
+		for _, e := range failed {
+			if e.timer != nil {
+				e.timer.Stop()
+			}
+			e.ch <- Result{Err: err}
+		}
+	}
+}
+
+// LenOwner returns the number of pending requests of owner.
+func (t *Table) LenOwner(owner any) int {
+	n := 0
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.mu.Lock()
+		for _, e := range s.entries {
+			if e.owner == owner {
+				n++
+			}
+		}
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// Len returns the number of pending requests.
+func (t *Table) Len() int {
+	n := 0
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.mu.Lock()
+		n += len(s.entries)
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// Orphaned returns the number of answers that matched no pending request.
+func (t *Table) Orphaned() uint64 {
+	return t.orphaned.Load()
+}
+
+// TimedOut returns the number of requests swept after their timeout.
+func (t *Table) TimedOut() uint64 {
+	return t.timedOut.Load()
+}
@@ -0,0 +1,354 @@
+package pending
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/clock"
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+func newTestTable() (*Table, *fakeclock.Clock) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	return New(clk), clk
+}
+
+// answerTo returns an answer with hopByHopID.
+func answerTo(hopByHopID uint32) *message.DiameterMessage {
+	return &message.DiameterMessage{Header: &message.DiameterHeader{HopByHopID: hopByHopID}}
+}
+
+// result returns the Result waiting on ch, failing if there is none.
+func result(t *testing.T, ch <-chan Result) Result {
+	t.Helper()
+	select {
+	case r := <-ch:
+		return r
+	default:
+		t.Fatal("no result delivered")
+		return Result{}
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	table, _ := newTestTable()
+	ch, err := table.Add(7, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := table.Add(7, time.Second); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("second Add of 7 = %v, want ErrDuplicate", err)
+	}
+	ans := answerTo(7)
+	if !table.Deliver(ans) {
+		t.Fatal("answer to a pending request not delivered")
+	}
+	if r := result(t, ch); r.Answer != ans || r.Err != nil {
+		t.Errorf("result = %+v, want the answer", r)
+	}
+	if table.Len() != 0 {
+		t.Errorf("Len = %d after delivery", table.Len())
+	}
+}
+
+func TestDeliverOrphan(t *testing.T) {
+	table, _ := newTestTable()
+	if table.Deliver(answerTo(1)) {
+		t.Fatal("answer delivered without a pending request")
+	}
+	if got := table.Orphaned(); got != 1 {
+		t.Errorf("Orphaned = %d, want 1", got)
+	}
+
+	if _, err := table.Add(2, 0); err != nil {
+		t.Fatal(err)
+	}
+	req := answerTo(2)
+	req.Header.CommandFlags = req.Header.CommandFlags.With(message.FlagRequest)
+	if table.Deliver(req) {
+		t.Error("request taken for an answer")
+	}
+	if table.Len() != 1 {
+		t.Error("request removed the pending entry")
+	}
+}
+
+func TestTimeoutSweep(t *testing.T) {
+	table, clk := newTestTable()
+	short, _ := table.Add(1, time.Second)
+	long, _ := table.Add(2, 3*time.Second)
+	untimed, _ := table.Add(3, 0)
+
+	clk.Advance(time.Second)
+	if r := result(t, short); !errors.Is(r.Err, ErrTimeout) {
+		t.Errorf("result = %+v, want ErrTimeout", r)
+	}
+	if table.Len() != 2 || table.TimedOut() != 1 {
+		t.Fatalf("Len %d, TimedOut %d; want 2 and 1", table.Len(), table.TimedOut())
+	}
+
+	if !table.Deliver(answerTo(2)) {
+		t.Fatal("answer before the timeout not delivered")
+	}
+	result(t, long)
+	clk.Advance(time.Hour)
+	if table.TimedOut() != 1 {
+		t.Errorf("delivered request timed out too")
+	}
+	select {
+	case r := <-untimed:
+		t.Errorf("request without timeout ended with %+v", r)
+	default:
+	}
+	if table.Deliver(answerTo(1)) {
+		t.Error("late answer delivered after the timeout")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	table, clk := newTestTable()
+	ch, _ := table.Add(1, time.Second)
+	table.Remove(1)
+	clk.Advance(time.Minute)
+	select {
+	case r := <-ch:
+		t.Errorf("removed request ended with %+v", r)
+	default:
+	}
+	if clk.Pending() != 0 {
+		t.Errorf("%d timers left after Remove", clk.Pending())
+	}
+}
+
+func TestReserve(t *testing.T) {
+	table, _ := newTestTable()
+	if _, err := table.Add(10, 0); err != nil {
+		t.Fatal(err)
+	}
+	next := uint32(10)
+	draw := func() uint32 { next++; return next }
+	table.Add(11, 0)
+
+	req := answerTo(10)
+	if _, err := table.Reserve(req, draw, 0); err != nil {
+		t.Fatal(err)
+	}
+	if req.Header.HopByHopID != 12 {
+		t.Errorf("reserved Hop-by-Hop Identifier %d, want 12", req.Header.HopByHopID)
+	}
+
+	busy := func() uint32 { return 10 }
+	if _, err := table.Reserve(answerTo(10), busy, 0); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Reserve with no free identifier = %v, want ErrDuplicate", err)
+	}
+}
+
+// TestReserveWraparound runs identifiers from a 4-bit counter, standing
+// for the 32-bit one of the generator, around several times while some
+// requests stay outstanding, and checks that no identifier is handed out
+// twice while pending.
+func TestReserveWraparound(t *testing.T) {
+	const width = 4
+	table, _ := newTestTable()
+	counter := uint32(13)
+	next := func() uint32 {
+		counter = (counter + 1) & (1<<width - 1)
+		return counter
+	}
+
+	// Three requests stay outstanding throughout.
+	stuck := map[uint32]bool{}
+	for range 3 {
+		req := answerTo(next())
+		if _, err := table.Reserve(req, next, 0); err != nil {
+			t.Fatal(err)
+		}
+		stuck[req.Header.HopByHopID] = true
+	}
+	for i := range 5 << width {
+		req := answerTo(next())
+		ch, err := table.Reserve(req, next, 0)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		id := req.Header.HopByHopID
+		if stuck[id] {
+			t.Fatalf("request %d got Hop-by-Hop Identifier %d of an outstanding request", i, id)
+		}
+		if !table.Deliver(answerTo(id)) || result(t, ch).Answer.Header.HopByHopID != id {
+			t.Fatalf("request %d: answer %d not delivered", i, id)
+		}
+	}
+
+	// Fill the identifier space: the last reservation has nothing left.
+	for range 1<<width - len(stuck) {
+		if _, err := table.Reserve(answerTo(next()), next, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := table.Reserve(answerTo(next()), next, 0); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Reserve with every identifier pending = %v, want ErrDuplicate", err)
+	}
+	if got := table.Len(); got != 1<<width {
+		t.Errorf("%d requests pending, want %d", got, 1<<width)
+	}
+}
+
+func TestFailOwner(t *testing.T) {
+	table, _ := newTestTable()
+	first, second := "conn 1", "conn 2"
+	next := func() uint32 { return 0 }
+	ch1, _ := table.ReserveFor(first, answerTo(1), next, 0)
+	ch2, _ := table.ReserveFor(second, answerTo(2), next, 0)
+	if table.LenOwner(first) != 1 || table.LenOwner(second) != 1 {
+		t.Fatalf("LenOwner = %d and %d, want 1 and 1", table.LenOwner(first), table.LenOwner(second))
+	}
+
+	lost := errors.New("connection lost")
+	table.FailOwner(first, lost)
+	if r := result(t, ch1); !errors.Is(r.Err, lost) {
+		t.Errorf("result = %+v, want the owner error", r)
+	}
+	if table.Len() != 1 {
+		t.Errorf("FailOwner ended the requests of another owner")
+	}
+
+	table.FailAll(lost)
+	if r := result(t, ch2); !errors.Is(r.Err, lost) {
+		t.Errorf("result = %+v, want the FailAll error", r)
+	}
+	if table.Len() != 0 {
+		t.Errorf("Len = %d after FailAll", table.Len())
+	}
+}
+
+// TestConcurrentDeliveries adds, answers, times out and fails requests
+// from many goroutines; run with -race.
+func TestConcurrentDeliveries(t *testing.T) {
+	table, clk := newTestTable()
+	const workers, perWorker = 8, 500
+	var wg sync.WaitGroup
+	for w := range uint32(workers) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range uint32(perWorker) {
+				id := w*perWorker + i
+				ch, err := table.Add(id, time.Second)
+				if err != nil {
+					t.Errorf("Add(%d): %v", id, err)
+					return
+				}
+				switch i % 3 {
+				case 0:
+					table.Deliver(answerTo(id))
+				case 1:
+					table.Remove(id)
+					continue
+				case 2:
+					clk.Advance(time.Millisecond)
+				}
+				go func() { <-ch }()
+			}
+		}()
+	}
+	wg.Wait()
+	clk.Advance(time.Hour)
+	table.FailAll(errors.New("closed"))
+	if table.Len() != 0 {
+		t.Errorf("Len = %d at the end", table.Len())
+	}
+}
+
+// TestShardIsolation answers and removes requests whose Hop-by-Hop
+// Identifiers share a shard with, or sit next to, requests left pending.
+func TestShardIsolation(t *testing.T) {
+	table, _ := newTestTable()
+	ids := []uint32{5, 5 + shardCount, 5 + 2*shardCount, 6, 4, 5 + shardCount<<20}
+	chans := make(map[uint32]<-chan Result, len(ids))
+	for _, id := range ids {
+		ch, err := table.Add(id, 0)
+		if err != nil {
+			t.Fatalf("Add(%d): %v", id, err)
+		}
+		chans[id] = ch
+	}
+	if !table.Deliver(answerTo(5 + shardCount)) {
+		t.Fatalf("answer to %d not delivered", 5+shardCount)
+	}
+	result(t, chans[5+shardCount])
+	table.Remove(5 + 2*shardCount)
+	if table.Deliver(answerTo(5 + 3*shardCount)) {
+		t.Errorf("answer to %d delivered without a pending request", 5+3*shardCount)
+	}
+
+	for _, id := range []uint32{5, 6, 4, 5 + shardCount<<20} {
+		select {
+		case r := <-chans[id]:
+			t.Errorf("request %d ended with %+v", id, r)
+		default:
+		}
+		if !table.Deliver(answerTo(id)) {
+			t.Errorf("answer to %d not delivered", id)
+		}
+		if r := result(t, chans[id]); r.Answer.Header.HopByHopID != id {
+			t.Errorf("request %d got the answer to %d", id, r.Answer.Header.HopByHopID)
+		}
+	}
+	if table.Len() != 0 {
+		t.Errorf("Len = %d at the end", table.Len())
+	}
+}
+
+// TestTimeoutSweepAllShards times out requests in every shard.
+func TestTimeoutSweepAllShards(t *testing.T) {
+	table, clk := newTestTable()
+	chans := make([]<-chan Result, 2*shardCount)
+	for i := range chans {
+		var err error
+		if chans[i], err = table.Add(uint32(i), time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clk.Advance(time.Second)
+	for i, ch := range chans {
+		if r := result(t, ch); !errors.Is(r.Err, ErrTimeout) {
+			t.Errorf("request %d: result %+v, want ErrTimeout", i, r)
+		}
+	}
+	if table.Len() != 0 || table.TimedOut() != uint64(len(chans)) || clk.Pending() != 0 {
+		t.Errorf("Len %d, TimedOut %d, timers %d; want 0, %d and 0",
+			table.Len(), table.TimedOut(), clk.Pending(), len(chans))
+	}
+	for i := range shardCount {
+		if n := len(table.shards[i].entries); n != 0 {
+			t.Errorf("shard %d holds %d entries", i, n)
+		}
+	}
+}
+
+// BenchmarkTableParallel adds and answers requests from all Ps at once,
+// the access pattern of many goroutines sharing one connection.
+func BenchmarkTableParallel(b *testing.B) {
+	table := New(clock.Real)
+	var next atomic.Uint32
+	ans := answerTo(0)
+	b.RunParallel(func(pb *testing.PB) {
+		ans := *ans.Header
+		for pb.Next() {
+			id := next.Add(1)
+			ch, err := table.Add(id, time.Minute)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			ans.HopByHopID = id
+			table.Deliver(&message.DiameterMessage{Header: &ans})
+			<-ch
+		}
+	})
+}
@@ -0,0 +1,93 @@
+// Package ratelog limits the logging of errors that repeat, so that a
+// peer in a crash loop or sending junk cannot flood the logs.
+package ratelog
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/clock"
+)
+
+// sweepAt is the number of tracked errors above which those not seen for
+// an interval are forgotten.
+const sweepAt = 4096
+
+type key struct {
+	peer, class string
+}
+
+type entry struct {
+	logged     time.Time
+	suppressed uint64
+}
+
+// Limiter logs an error of a given class from a given peer at most once
+// per interval. The occurrences in between are counted, and reported with
+// the next line logged for them. A nil Limiter, or one with a zero
+// interval, logs every error.
+type Limiter struct {
+	interval   time.Duration
+	clk        clock.Clock
+	mu         sync.Mutex
+	entries    map[key]entry
+	suppressed atomic.Uint64
+}
+
+// New returns a Limiter logging each error class of each peer at most once
+// per interval of clk.
+func New(interval time.Duration, clk clock.Clock) *Limiter {
+	return &Limiter{interval: interval, clk: clk, entries: make(map[key]entry)}
+}
+
+// Printf logs like log.Printf unless an error of class from peer was
+// logged less than an interval ago. class is a short constant naming what
+// failed, such as "decode", so that errors differing only in their
+// details are grouped; peer is the identity or address of the peer.
+func (l *Limiter) Printf(peer, class, format string, args ...any) {
+	if l == nil || l.interval <= 0 {
+		log.Printf(format, args...)
+		return
+	}
+	now := l.clk.Now()
+	k := key{peer, class}
+	l.mu.Lock()
+	e, ok := l.entries[k]
+	if ok && now.Sub(e.logged) < l.interval {
+		e.suppressed++
+		l.entries[k] = e
+		l.mu.Unlock()
+		l.suppressed.Add(1)
+		return
+	}
+	if !ok && len(l.entries) >= sweepAt {
+		l.sweep(now)
+	}
+	l.entries[k] = entry{logged: now}
+	l.mu.Unlock()
+	if e.suppressed == 0 {
+		log.Printf(format, args...)
+		return
+	}
+	log.Printf(format+" (suppressed %d similar)", append(args, e.suppressed)...)
+}
+
+// sweep forgets the errors not logged for an interval, along with the
+// count of their suppressed occurrences.
+func (l *Limiter) sweep(now time.Time) {
+	for k, e := range l.entries {
+		if now.Sub(e.logged) >= l.interval {
+			delete(l.entries, k)
+		}
+	}
+}
+
+// Suppressed returns the number of lines that were not logged.
+func (l *Limiter) Suppressed() uint64 {
+	if l == nil {
+		return 0
+	}
+	return l.suppressed.Load()
+}
@@ -0,0 +1,115 @@
+package ratelog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+)
+
+// captureLog redirects the standard logger to a buffer until the test
+// ends. The logger serializes its writes, so the buffer needs no lock of
+// its own as long as it is read once the logging is done.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var b bytes.Buffer
+	flags := log.Flags()
+	log.SetOutput(&b)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	})
+	return &b
+}
+
+func TestLimiterRepeatedErrors(t *testing.T) {
+	const (
+		interval   = time.Second
+		rounds     = 10
+		goroutines = 8
+		perRound   = 1000
+	)
+	logs := captureLog(t)
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	l := New(interval, clk)
+	base := io.ErrUnexpectedEOF
+
+	for round := range rounds {
+		var wg sync.WaitGroup
+		for g := range goroutines {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := g; i < perRound; i += goroutines {
+					// The details differ, the class does not.
+					var err error
+					switch i % 3 {
+					case 0:
+						err = base
+					case 1:
+						err = fmt.Errorf("AVP %d: %w", i, base)
+					default:
+						err = errors.Join(base, fmt.Errorf("frame %d", i))
+					}
+					l.Printf("peer.example.com", "decode", "Error decoding message: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		if round < rounds-1 {
+			clk.Advance(interval)
+		}
+	}
+
+	// Joined errors span several lines, each logged error starts one.
+	lines := strings.Split(logs.String(), "Error decoding message")[1:]
+	if len(lines) != rounds {
+		t.Fatalf("%d lines logged for %d errors, want %d", len(lines), rounds*perRound, rounds)
+	}
+	for i, line := range lines[1:] {
+		if !strings.Contains(line, fmt.Sprintf("(suppressed %d similar)", perRound-1)) {
+			t.Errorf("line %d does not report the %d errors suppressed: %s", i+2, perRound-1, line)
+		}
+	}
+	if got, want := l.Suppressed(), uint64(rounds*(perRound-1)); got != want {
+		t.Errorf("Suppressed() = %d, want %d", got, want)
+	}
+}
+
+func TestLimiterKeys(t *testing.T) {
+	logs := captureLog(t)
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	l := New(time.Second, clk)
+	for range 100 {
+		l.Printf("a.example.com", "decode", "decode error")
+		l.Printf("a.example.com", "read", "read error")
+		l.Printf("b.example.com", "decode", "decode error")
+	}
+	if n := strings.Count(logs.String(), "\n"); n != 3 {
+		t.Errorf("%d lines logged, want one per peer and class:\n%s", n, logs)
+	}
+	if got := l.Suppressed(); got != 297 {
+		t.Errorf("Suppressed() = %d, want 297", got)
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	logs := captureLog(t)
+	for _, l := range []*Limiter{nil, New(0, fakeclock.New(time.Unix(1_700_000_000, 0)))} {
+		logs.Reset()
+		for range 10 {
+			l.Printf("peer.example.com", "decode", "decode error")
+		}
+		if n := strings.Count(logs.String(), "\n"); n != 10 || l.Suppressed() != 0 {
+			t.Errorf("%d lines logged, %d suppressed; want all 10 logged", n, l.Suppressed())
+		}
+	}
+}
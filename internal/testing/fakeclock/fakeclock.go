@@ -0,0 +1,177 @@
+// Package fakeclock provides a manually advanced clock.Clock for tests.
+package fakeclock
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/clock"
+)
+
+// Clock is a clock.Clock whose time only moves when Advance is called.
+// Timers due at or before the new time fire in deadline order; AfterFunc
+// callbacks run synchronously on the goroutine calling Advance.
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*timer
+}
+
+// New returns a fake clock set to start.
+func New(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Clock) NewTimer(d time.Duration) clock.Timer {
+	return c.add(d, 0, nil)
+}
+
+func (c *Clock) NewTicker(d time.Duration) clock.Ticker {
+	return &ticker{c.add(d, d, nil)}
+}
+
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *Clock) AfterFunc(d time.Duration, f func()) clock.Timer {
+	return c.add(d, 0, f)
+}
+
+// Advance moves the clock forward by d, firing every timer that becomes due.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	c.mu.Unlock()
+
+	for {
+		c.mu.Lock()
+		t := c.next(target)
+		if t == nil {
+			c.now = target
+			c.mu.Unlock()
+			return
+		}
+		c.now = t.deadline
+		now := c.now
+		if t.period > 0 {
+			t.deadline = t.deadline.Add(t.period)
+		} else {
+			t.active = false
+			c.remove(t)
+		}
+		c.mu.Unlock()
+
+		if t.f != nil {
+			t.f()
+		} else {
+			select {
+			case t.ch <- now:
+			default:
+			}
+		}
+	}
+}
+
+// Pending returns the number of active timers and tickers.
+func (c *Clock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// next returns the earliest timer due at or before target. Callers must
+// hold c.mu.
+func (c *Clock) next(target time.Time) *timer {
+	sort.SliceStable(c.timers, func(i, j int) bool {
+		return c.timers[i].deadline.Before(c.timers[j].deadline)
+	})
+	if len(c.timers) == 0 || c.timers[0].deadline.After(target) {
+		return nil
+	}
+	return c.timers[0]
+}
+
+func (c *Clock) add(d, period time.Duration, f func()) *timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &timer{
+		clock:    c,
+		deadline: c.now.Add(d),
+		period:   period,
+		f:        f,
+		ch:       make(chan time.Time, 1),
+		active:   true,
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// remove drops t from the active set. Callers must hold c.mu.
+func (c *Clock) remove(t *timer) {
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return
+		}
+	}
+}
+
+type timer struct {
+	clock    *Clock
+	deadline time.Time
+	period   time.Duration
+	f        func()
+	ch       chan time.Time
+	active   bool
+}
+
+func (t *timer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *timer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	wasActive := t.active
+	t.active = false
+	t.clock.remove(t)
+	return wasActive
+}
+
+func (t *timer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	wasActive := t.active
+	t.clock.remove(t)
+	t.deadline = t.clock.now.Add(d)
+	if t.period > 0 {
+		t.period = d
+	}
+	t.active = true
+	t.clock.timers = append(t.clock.timers, t)
+	return wasActive
+}
+
+type ticker struct {
+	t *timer
+}
+
+func (k *ticker) C() <-chan time.Time {
+	return k.t.ch
+}
+
+func (k *ticker) Stop() {
+	k.t.Stop()
+}
+
+func (k *ticker) Reset(d time.Duration) {
+	k.t.Reset(d)
+}
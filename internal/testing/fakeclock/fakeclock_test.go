@@ -0,0 +1,138 @@
+package fakeclock
+
+import (
+	"testing"
+	"time"
+)
+
+var start = time.Unix(1_700_000_000, 0)
+
+func TestAdvanceFiresDueTimersInOrder(t *testing.T) {
+	c := New(start)
+	var fired []int
+	c.AfterFunc(3*time.Second, func() { fired = append(fired, 3) })
+	c.AfterFunc(time.Second, func() { fired = append(fired, 1) })
+	c.AfterFunc(2*time.Second, func() { fired = append(fired, 2) })
+	c.AfterFunc(5*time.Second, func() { fired = append(fired, 5) })
+
+	c.Advance(3 * time.Second)
+	if len(fired) != 3 || fired[0] != 1 || fired[1] != 2 || fired[2] != 3 {
+		t.Fatalf("fired %v, want [1 2 3]", fired)
+	}
+	if got := c.Now(); !got.Equal(start.Add(3 * time.Second)) {
+		t.Errorf("Now() = %v, want start + 3s", got)
+	}
+	if c.Pending() != 1 {
+		t.Errorf("Pending() = %d, want 1", c.Pending())
+	}
+}
+
+func TestCallbackSeesItsDeadline(t *testing.T) {
+	c := New(start)
+	var at time.Time
+	c.AfterFunc(time.Second, func() { at = c.Now() })
+	c.Advance(time.Minute)
+	if !at.Equal(start.Add(time.Second)) {
+		t.Errorf("Now() in callback = %v, want start + 1s", at)
+	}
+}
+
+func TestCallbackSchedulingTimer(t *testing.T) {
+	c := New(start)
+	count := 0
+	var tick func()
+	tick = func() {
+		count++
+		c.AfterFunc(time.Second, tick)
+	}
+	c.AfterFunc(time.Second, tick)
+	c.Advance(10 * time.Second)
+	if count != 10 {
+		t.Errorf("callback ran %d times, want 10", count)
+	}
+}
+
+func TestTimerChannel(t *testing.T) {
+	c := New(start)
+	timer := c.NewTimer(time.Second)
+	c.Advance(999 * time.Millisecond)
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired early")
+	default:
+	}
+	c.Advance(time.Millisecond)
+	select {
+	case at := <-timer.C():
+		if !at.Equal(start.Add(time.Second)) {
+			t.Errorf("fired at %v, want start + 1s", at)
+		}
+	default:
+		t.Fatal("timer did not fire")
+	}
+}
+
+func TestTimerStopAndReset(t *testing.T) {
+	c := New(start)
+	fired := 0
+	timer := c.AfterFunc(time.Second, func() { fired++ })
+	if !timer.Stop() {
+		t.Error("Stop() of an active timer = false")
+	}
+	if timer.Stop() {
+		t.Error("Stop() of a stopped timer = true")
+	}
+	c.Advance(time.Minute)
+	if fired != 0 {
+		t.Fatal("stopped timer fired")
+	}
+
+	if timer.Reset(time.Second) {
+		t.Error("Reset() of a stopped timer = true")
+	}
+	c.Advance(time.Second)
+	if fired != 1 {
+		t.Fatalf("reset timer fired %d times, want 1", fired)
+	}
+}
+
+func TestTicker(t *testing.T) {
+	c := New(start)
+	ticker := c.NewTicker(time.Second)
+	for i := 1; i <= 3; i++ {
+		c.Advance(time.Second)
+		select {
+		case at := <-ticker.C():
+			if want := start.Add(time.Duration(i) * time.Second); !at.Equal(want) {
+				t.Errorf("tick %d at %v, want %v", i, at, want)
+			}
+		default:
+			t.Fatalf("tick %d missing", i)
+		}
+	}
+	ticker.Reset(5 * time.Second)
+	c.Advance(4 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticker fired before its new period")
+	default:
+	}
+	ticker.Stop()
+	c.Advance(time.Minute)
+	select {
+	case <-ticker.C():
+		t.Fatal("stopped ticker fired")
+	default:
+	}
+}
+
+func TestAfter(t *testing.T) {
+	c := New(start)
+	ch := c.After(time.Second)
+	c.Advance(time.Second)
+	select {
+	case <-ch:
+	default:
+		t.Fatal("After channel not ready")
+	}
+}
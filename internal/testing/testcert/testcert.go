@@ -0,0 +1,107 @@
+// Package testcert issues TLS certificates from a throwaway authority for
+// tests.
+package testcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Authority is a certificate authority that only lives as long as the test
+// creating it.
+type Authority struct {
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	pool   *x509.CertPool
+	serial atomic.Int64
+}
+
+// NewAuthority creates a self-signed authority.
+func NewAuthority(t testing.TB) *Authority {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating authority key: %v", err)
+	}
+	a := &Authority{key: key, pool: x509.NewCertPool()}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(a.serial.Add(1)),
+		Subject:               pkix.Name{CommonName: "test authority"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating authority certificate: %v", err)
+	}
+	if a.cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatalf("parsing authority certificate: %v", err)
+	}
+	a.pool.AddCert(a.cert)
+	return a
+}
+
+// Pool returns a pool holding the certificate of the authority, for the
+// RootCAs or ClientCAs of a tls.Config.
+func (a *Authority) Pool() *x509.CertPool {
+	return a.pool
+}
+
+// Issue returns a certificate signed by the authority for the DNS names,
+// usable by both clients and servers.
+func (a *Authority) Issue(t testing.TB, names ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(a.serial.Add(1)),
+		Subject:      pkix.Name{CommonName: "test certificate"},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &key.PublicKey, a.key)
+	if err != nil {
+		t.Fatalf("creating certificate for %v: %v", names, err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing certificate for %v: %v", names, err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// ServerConfig returns a TLS configuration presenting cert and requiring a
+// client certificate signed by the authority.
+func (a *Authority) ServerConfig(cert tls.Certificate) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    a.pool,
+	}
+}
+
+// ClientConfig returns a TLS configuration presenting cert, trusting the
+// authority and verifying the server certificate against serverName.
+func (a *Authority) ClientConfig(cert tls.Certificate, serverName string) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      a.pool,
+		ServerName:   serverName,
+	}
+}
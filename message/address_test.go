@@ -0,0 +1,163 @@
+package message
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+)
+
+// hostIPAddress returns a Host-IP-Address AVP holding value, a net.IP or a
+// RawAddress.
+func hostIPAddress(t *testing.T, value any) *AVP {
+	t.Helper()
+	data := &Address{}
+	if err := data.SetData(value); err != nil {
+		t.Fatalf("SetData(%v): %v", value, err)
+	}
+	return &AVP{Code: AVP_HOST_IP_ADDRESS, Flags: MANDATORY_FLAG, Data: data}
+}
+
+func TestAddressFamilies(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		value any
+		// wire is the encoded Address.
+		wire   []byte
+		family uint16
+		ip     net.IP
+		str    string
+	}{
+		{
+			name:   "IPv4",
+			value:  net.ParseIP("192.0.2.1"),
+			wire:   []byte{0, 1, 192, 0, 2, 1},
+			family: AddressFamilyIPv4,
+			ip:     net.ParseIP("192.0.2.1"),
+			str:    "192.0.2.1",
+		},
+		{
+			name:   "IPv6",
+			value:  net.ParseIP("2001:db8::1"),
+			wire:   append([]byte{0, 2}, net.ParseIP("2001:db8::1")...),
+			family: AddressFamilyIPv6,
+			ip:     net.ParseIP("2001:db8::1"),
+			str:    "2001:db8::1",
+		},
+		{
+			name:   "IPv4 given raw",
+			value:  RawAddress{Family: AddressFamilyIPv4, Data: []byte{192, 0, 2, 1}},
+			wire:   []byte{0, 1, 192, 0, 2, 1},
+			family: AddressFamilyIPv4,
+			ip:     net.ParseIP("192.0.2.1"),
+			str:    "192.0.2.1",
+		},
+		{
+			name:   "E.164",
+			value:  RawAddress{Family: AddressFamilyE164, Data: []byte("123456789")},
+			wire:   append([]byte{0, 8}, "123456789"...),
+			family: AddressFamilyE164,
+			str:    "family=8 0x313233343536373839",
+		},
+		{
+			name:   "unknown family",
+			value:  RawAddress{Family: 0xabcd, Data: []byte{1, 2, 3}},
+			wire:   []byte{0xab, 0xcd, 1, 2, 3},
+			family: 0xabcd,
+			str:    "family=43981 0x010203",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			avp := hostIPAddress(t, tc.value)
+			encoded := encodeAVP(t, avp)
+			if got := encoded[AVPHeaderLength : AVPHeaderLength+len(tc.wire)]; !bytes.Equal(got, tc.wire) {
+				t.Errorf("encoded as %x, want %x", got, tc.wire)
+			}
+			if avp.Length() != AVPHeaderLength+uint32(len(tc.wire)) {
+				t.Errorf("AVP length %d, want %d", avp.Length(), AVPHeaderLength+len(tc.wire))
+			}
+
+			decoded, err := DecodeAVP(encoded)
+			if err != nil {
+				t.Fatalf("DecodeAVP: %v", err)
+			}
+			address := decoded.Data.(*Address)
+			if got := address.AddressFamily(); got != tc.family {
+				t.Errorf("family %d, want %d", got, tc.family)
+			}
+			if got := address.String(); got != tc.str {
+				t.Errorf("String() = %q, want %q", got, tc.str)
+			}
+			ip, err := decoded.IP()
+			if tc.ip == nil {
+				if address.IP() != nil || !errors.Is(err, UnknownAddressTypeError) {
+					t.Errorf("IP() = %v, %v; want UnknownAddressTypeError", ip, err)
+				}
+			} else if err != nil || !ip.Equal(tc.ip) {
+				t.Errorf("IP() = %v, %v; want %v", ip, err, tc.ip)
+			}
+			if reencoded := encodeAVP(t, decoded); !bytes.Equal(reencoded, encoded) {
+				t.Errorf("decoded AVP encodes as\n%x\nwant\n%x", reencoded, encoded)
+			}
+		})
+	}
+}
+
+func TestAddressInvalid(t *testing.T) {
+	var a Address
+	for _, value := range []RawAddress{
+		{Family: AddressFamilyIPv4, Data: []byte{192, 0, 2}},
+		{Family: AddressFamilyIPv6, Data: net.ParseIP("192.0.2.1").To4()},
+	} {
+		if err := a.SetData(value); !errors.Is(err, InvalidAddressLengthError) {
+			t.Errorf("SetData(%+v) = %v, want InvalidAddressLengthError", value, err)
+		}
+	}
+	if err := a.SetData("192.0.2.1"); err == nil {
+		t.Error("SetData of a string succeeded")
+	}
+	if err := a.Decode([]byte{0}); !errors.Is(err, InvalidAddressLengthError) {
+		t.Errorf("Decode of one byte = %v, want InvalidAddressLengthError", err)
+	}
+	if err := a.Decode([]byte{0, 1, 192, 0, 2}); !errors.Is(err, InvalidIPv4AddressLengthError) {
+		t.Errorf("Decode of a short IPv4 address = %v, want InvalidIPv4AddressLengthError", err)
+	}
+}
+
+// TestAddressE164InMessage checks that an E.164 address no longer fails
+// the decoding of the message carrying it, and that it survives cloning
+// and JSON.
+func TestAddressE164InMessage(t *testing.T) {
+	msg := newTestCCR(t)
+	msg.AVPs = append(msg.AVPs, hostIPAddress(t, RawAddress{Family: AddressFamilyE164, Data: []byte("4412345")}))
+	decoded := roundTrip(t, msg)
+	if errs := decoded.DecodeErrors(); len(errs) > 0 {
+		t.Fatalf("decode errors %v", errs)
+	}
+	avp := decoded.GetAVP(AVP_HOST_IP_ADDRESS)
+	if got := avp.Data.String(); got != "family=8 0x34343132333435" {
+		t.Errorf("Host-IP-Address %s", got)
+	}
+
+	clone := decoded.Clone()
+	clone.GetAVP(AVP_HOST_IP_ADDRESS).Data.(*Address).Raw[0] = '0'
+	if got := avp.Data.(*Address).Raw[0]; got != '4' {
+		t.Errorf("changing the clone changed the original to %q", got)
+	}
+
+	data, err := json.Marshal(avp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"code":257,"flags":64,"value":{"family":8,"data":"NDQxMjM0NQ=="}}`; string(data) != want {
+		t.Errorf("marshalled as %s, want %s", data, want)
+	}
+	var unmarshalled AVP
+	if err := json.Unmarshal(data, &unmarshalled); err != nil {
+		t.Fatalf("unmarshalling %s: %v", data, err)
+	}
+	if got, want := encodeAVP(t, &unmarshalled), encodeAVP(t, avp); !bytes.Equal(got, want) {
+		t.Errorf("unmarshalled AVP encodes as\n%x\nwant\n%x", got, want)
+	}
+}
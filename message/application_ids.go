@@ -0,0 +1,22 @@
+package message
+
+// Diameter Application IDs
+const (
+	APPLICATION_ID_DIAMETER_COMMON_MESSAGES = uint32(0)
+	APPLICATION_ID_NASREQ                   = uint32(1)
+	APPLICATION_ID_MOBILE_IPV4              = uint32(2)
+	APPLICATION_ID_BASE_ACCOUNTING          = uint32(3)
+	APPLICATION_ID_CREDIT_CONTROL           = uint32(4)
+	APPLICATION_ID_EAP                      = uint32(5)
+	APPLICATION_ID_SIP                      = uint32(6)
+	APPLICATION_ID_3GPP_CX                  = uint32(16777216)
+	APPLICATION_ID_3GPP_SH                  = uint32(16777217)
+	APPLICATION_ID_3GPP_RX                  = uint32(16777236)
+	APPLICATION_ID_3GPP_GX                  = uint32(16777238)
+	APPLICATION_ID_3GPP_S6A                 = uint32(16777251)
+	APPLICATION_ID_3GPP_S13                 = uint32(16777252)
+	APPLICATION_ID_3GPP_SLG                 = uint32(16777255)
+	APPLICATION_ID_3GPP_SWX                 = uint32(16777265)
+	APPLICATION_ID_3GPP_S6T                 = uint32(16777345)
+	APPLICATION_ID_RELAY                    = uint32(0xffffffff)
+)
@@ -0,0 +1,225 @@
+// Application sets for capability negotiation
+package message
+
+import (
+	"slices"
+	"sort"
+)
+
+// ApplicationSet is a set of Application-IDs. A set containing the relay
+// Application-ID (0xffffffff) contains every application.
+type ApplicationSet map[uint32]struct{}
+
+// NewApplicationSet creates a set holding ids.
+func NewApplicationSet(ids ...uint32) ApplicationSet {
+	s := make(ApplicationSet, len(ids))
+	for _, id := range ids {
+		s[id] = struct{}{}
+	}
+	return s
+}
+
+// IsRelay reports whether the set holds the relay Application-ID.
+func (s ApplicationSet) IsRelay() bool {
+	_, ok := s[APPLICATION_ID_RELAY]
+	return ok
+}
+
+// Contains reports whether id is in the set, honouring the relay wildcard.
+func (s ApplicationSet) Contains(id uint32) bool {
+	if s.IsRelay() {
+		return true
+	}
+	_, ok := s[id]
+	return ok
+}
+
+// Intersect returns the applications present in both sets. When one side
+// is a relay the other side is returned unchanged.
+func (s ApplicationSet) Intersect(other ApplicationSet) ApplicationSet {
+	switch {
+	case s.IsRelay():
+		return NewApplicationSet(other.IDs()...)
+	case other.IsRelay():
+		return NewApplicationSet(s.IDs()...)
+	}
+	result := make(ApplicationSet)
+	for id := range s {
+		if _, ok := other[id]; ok {
+			result[id] = struct{}{}
+		}
+	}
+	return result
+}
+
+// IsEmpty reports whether the set holds no application.
+func (s ApplicationSet) IsEmpty() bool {
+	return len(s) == 0
+}
+
+// IDs returns the Application-IDs of the set in ascending order.
+func (s ApplicationSet) IDs() []uint32 {
+	ids := make([]uint32, 0, len(s))
+	for id := range s {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// VendorApplication is the content of a Vendor-Specific-Application-Id AVP.
+// Accounting tells whether ApplicationID was carried as an
+// Acct-Application-Id rather than an Auth-Application-Id.
+type VendorApplication struct {
+	VendorID      uint32
+	ApplicationID uint32
+	Accounting    bool
+}
+
+// Applications holds the authentication, accounting and vendor-specific
+// applications advertised or negotiated during capability exchange.
+type Applications struct {
+	Auth           ApplicationSet
+	Acct           ApplicationSet
+	VendorSpecific []VendorApplication
+}
+
+// IsEmpty reports whether no application of any kind is present.
+func (a Applications) IsEmpty() bool {
+	return a.Auth.IsEmpty() && a.Acct.IsEmpty() && len(a.VendorSpecific) == 0
+}
+
+// Supports reports whether applicationID may be used, looking at the
+// accounting or the authentication applications as requested. The base
+// protocol application is always supported.
+func (a Applications) Supports(applicationID uint32, accounting bool) bool {
+	if applicationID == APPLICATION_ID_DIAMETER_COMMON_MESSAGES {
+		return true
+	}
+	set := a.Auth
+	if accounting {
+		set = a.Acct
+	}
+	if set.Contains(applicationID) {
+		return true
+	}
+	for _, v := range a.VendorSpecific {
+		if v.Accounting == accounting && v.ApplicationID == applicationID {
+			return true
+		}
+	}
+	return false
+}
+
+// Intersect returns the applications supported by both a and b, treating
+// the authentication and accounting sets independently. A vendor-specific
+// application of either side is kept when the other side advertises it
+// too, either as the same Vendor-Specific-Application-Id or as a plain
+// Application-ID of the same kind, which includes the relay wildcard.
+func (a Applications) Intersect(b Applications) Applications {
+	result := Applications{
+		Auth: a.Auth.Intersect(b.Auth),
+		Acct: a.Acct.Intersect(b.Acct),
+	}
+	for _, v := range a.VendorSpecific {
+		if b.offers(v) {
+			result.VendorSpecific = append(result.VendorSpecific, v)
+		}
+	}
+	for _, v := range b.VendorSpecific {
+		if a.offers(v) && !slices.Contains(result.VendorSpecific, v) {
+			result.VendorSpecific = append(result.VendorSpecific, v)
+		}
+	}
+	return result
+}
+
+// offers reports whether a advertises the vendor-specific application v.
+func (a Applications) offers(v VendorApplication) bool {
+	if slices.Contains(a.VendorSpecific, v) {
+		return true
+	}
+	set := a.Auth
+	if v.Accounting {
+		set = a.Acct
+	}
+	return set.Contains(v.ApplicationID)
+}
+
+// ParseApplications collects the Auth-Application-Id, Acct-Application-Id
+// and Vendor-Specific-Application-Id AVPs of a CER or CEA. Duplicates are
+// merged and invalid Vendor-Specific-Application-Ids skipped; use
+// ParseOffer to have them reported.
+func ParseApplications(msg *DiameterMessage) Applications {
+	offer, _ := parseOffer(msg)
+	return offer.Applications
+}
+
+// parseVendorApplication decodes the content of a
+// Vendor-Specific-Application-Id AVP. A repeated Vendor-Id, as RFC 3588
+// allowed, is ignored after the first.
+func parseVendorApplication(group *Grouped) (VendorApplication, error) {
+	var v VendorApplication
+	hasVendor, apps := false, 0
+	for _, avp := range group.AVPs {
+		switch d := avp.Data.(type) {
+		case *VendorId:
+			if avp.Code == AVP_VENDOR_ID && !hasVendor {
+				v.VendorID, hasVendor = d.Data, true
+			}
+		case *AppId:
+			switch avp.Code {
+			case AVP_AUTH_APPLICATION_ID:
+				v.ApplicationID, v.Accounting = d.Data, false
+				apps++
+			case AVP_ACCT_APPLICATION_ID:
+				v.ApplicationID, v.Accounting = d.Data, true
+				apps++
+			}
+		}
+	}
+	if !hasVendor || apps != 1 {
+		return v, InvalidVendorApplicationError
+	}
+	return v, nil
+}
+
+// AVPs returns the Auth-Application-Id, Acct-Application-Id and
+// Vendor-Specific-Application-Id AVPs advertising a.
+func (a Applications) AVPs() ([]*AVP, error) {
+	var avps []*AVP
+	for _, id := range a.Auth.IDs() {
+		avp, err := NewAVP(AVP_AUTH_APPLICATION_ID, id, MANDATORY_FLAG)
+		if err != nil {
+			return nil, err
+		}
+		avps = append(avps, avp)
+	}
+	for _, id := range a.Acct.IDs() {
+		avp, err := NewAVP(AVP_ACCT_APPLICATION_ID, id, MANDATORY_FLAG)
+		if err != nil {
+			return nil, err
+		}
+		avps = append(avps, avp)
+	}
+	for _, v := range a.VendorSpecific {
+		vendorID, err := NewAVP(AVP_VENDOR_ID, v.VendorID, MANDATORY_FLAG)
+		if err != nil {
+			return nil, err
+		}
+		code := AVP_AUTH_APPLICATION_ID
+		if v.Accounting {
+			code = AVP_ACCT_APPLICATION_ID
+		}
+		appID, err := NewAVP(code, v.ApplicationID, MANDATORY_FLAG)
+		if err != nil {
+			return nil, err
+		}
+		group, err := NewGroupedAVP(AVP_VENDOR_SPECIFIC_APPLICATION_ID, MANDATORY_FLAG, 0, vendorID, appID)
+		if err != nil {
+			return nil, err
+		}
+		avps = append(avps, group)
+	}
+	return avps, nil
+}
@@ -0,0 +1,68 @@
+package message
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestApplicationsIntersect(t *testing.T) {
+	const gx = uint32(16777238)
+	local := Applications{
+		Auth:           NewApplicationSet(APPLICATION_ID_CREDIT_CONTROL),
+		Acct:           NewApplicationSet(APPLICATION_ID_BASE_ACCOUNTING),
+		VendorSpecific: []VendorApplication{{VendorID: 10415, ApplicationID: gx}},
+	}
+	for _, tc := range []struct {
+		name       string
+		peer       Applications
+		auth, acct []uint32
+		vendor     []VendorApplication
+	}{
+		{"auth only", Applications{Auth: NewApplicationSet(APPLICATION_ID_CREDIT_CONTROL)}, []uint32{APPLICATION_ID_CREDIT_CONTROL}, nil, nil},
+		{"acct only", Applications{Acct: NewApplicationSet(APPLICATION_ID_BASE_ACCOUNTING)}, nil, []uint32{APPLICATION_ID_BASE_ACCOUNTING}, nil},
+		{"relay", Applications{Auth: NewApplicationSet(APPLICATION_ID_RELAY)}, []uint32{APPLICATION_ID_CREDIT_CONTROL}, nil, local.VendorSpecific},
+		{"vendor-specific", Applications{VendorSpecific: local.VendorSpecific}, nil, nil, local.VendorSpecific},
+		{"vendor-specific as accounting", Applications{VendorSpecific: []VendorApplication{{VendorID: 10415, ApplicationID: gx, Accounting: true}}}, nil, nil, nil},
+		{"none", Applications{Auth: NewApplicationSet(APPLICATION_ID_BASE_ACCOUNTING)}, nil, nil, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := local.Intersect(tc.peer)
+			if !slices.Equal(got.Auth.IDs(), tc.auth) {
+				t.Errorf("auth %v, want %v", got.Auth.IDs(), tc.auth)
+			}
+			if !slices.Equal(got.Acct.IDs(), tc.acct) {
+				t.Errorf("acct %v, want %v", got.Acct.IDs(), tc.acct)
+			}
+			if !slices.Equal(got.VendorSpecific, tc.vendor) {
+				t.Errorf("vendor-specific %v, want %v", got.VendorSpecific, tc.vendor)
+			}
+			empty := tc.auth == nil && tc.acct == nil && tc.vendor == nil
+			if got.IsEmpty() != empty {
+				t.Errorf("IsEmpty() = %t, want %t", got.IsEmpty(), empty)
+			}
+			// Routing looks at the set of the kind of the command.
+			if ok := got.Supports(APPLICATION_ID_CREDIT_CONTROL, false); ok != (tc.auth != nil) {
+				t.Errorf("Supports(Credit-Control) = %t", ok)
+			}
+			if ok := got.Supports(APPLICATION_ID_BASE_ACCOUNTING, true); ok != (tc.acct != nil) {
+				t.Errorf("Supports(base accounting) = %t", ok)
+			}
+		})
+	}
+}
+
+func TestApplicationsAVPsRoundTrip(t *testing.T) {
+	apps := Applications{
+		Auth:           NewApplicationSet(APPLICATION_ID_CREDIT_CONTROL),
+		Acct:           NewApplicationSet(APPLICATION_ID_BASE_ACCOUNTING),
+		VendorSpecific: []VendorApplication{{VendorID: 10415, ApplicationID: 16777238}, {VendorID: 10415, ApplicationID: 16777239, Accounting: true}},
+	}
+	cer, err := Node{OriginHost: "client.example.com", OriginRealm: "example.com"}.BuildCER(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := ParseApplications(cer)
+	if !slices.Equal(got.Auth.IDs(), apps.Auth.IDs()) || !slices.Equal(got.Acct.IDs(), apps.Acct.IDs()) || !slices.Equal(got.VendorSpecific, apps.VendorSpecific) {
+		t.Errorf("parsed %+v, want %+v", got, apps)
+	}
+}
@@ -2,9 +2,11 @@
 package message
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/IbrahimShahzad/diameter/utils"
 	"golang.org/x/exp/constraints"
@@ -23,13 +25,12 @@ const (
 )
 
 const (
-	AVP_CODE_LENGTH        = 4
-	AVP_FLAGS_LENGTH       = 1
-	AVP_LENGTH_LENGTH      = 3
-	AVP_VENDOR_ID_LENGTH   = 4
-	AVP_UNPROTECTED_LENGTH = 8
-	AVP_PROTECTED_LENGTH   = 12
-	AVP_PROTECTION_LENGTH  = 4
+	AVP_CODE_LENGTH      = 4
+	AVP_FLAGS_LENGTH     = 1
+	AVP_LENGTH_LENGTH    = 3
+	AVP_VENDOR_ID_LENGTH = 4
+	// Deprecated: the 'P' bit does not add any bytes to an AVP.
+	AVP_PROTECTION_LENGTH = 4
 )
 
 // AVP represents a Diameter Attribute-Value Pair.
@@ -44,9 +45,13 @@ const (
 type AVP struct {
 	Code      uint32
 	Flags     uint8
-	AVPlength uint32 // Contains the length of the AVP header and data
+	AVPlength uint32 // Length of the AVP header and data, excluding padding
 	VendorID  uint32 // This is optional
 	Data      AVPData
+	// decodeErr is set when tolerant decoding kept the data raw.
+	decodeErr error
+	// raw holds the AVP as received when decoding retained it.
+	raw []byte
 }
 
 type AVPData interface {
@@ -61,6 +66,22 @@ func (a *AVP) Length() uint32 {
 	return a.AVPlength
 }
 
+// Refresh recomputes AVPlength from the current data, descending into
+// Grouped AVPs so that the length at every nesting level is correct after
+// the group was modified in place. Encode does the same implicitly.
+func (a *AVP) Refresh() uint32 {
+	if g, ok := a.Data.(*Grouped); ok {
+		for _, avp := range g.AVPs {
+			avp.Refresh()
+		}
+	}
+	a.AVPlength = uint32(a.getHeaderLength())
+	if a.Data != nil {
+		a.AVPlength += a.Data.Length()
+	}
+	return a.AVPlength
+}
+
 func (a *AVP) String() string {
 	return fmt.Sprintf(
 		"AVP{Code: %d, Flags: %d, Length: %d, VendorID: %d, Data: %s}",
@@ -79,50 +100,189 @@ func (a *AVP) getHeaderLength() int {
 	return AVPHeaderLength
 }
 
+// Encode returns the wire form of the AVP. The AVP Length field covers the
+// header and data only; the padding needed to reach a 32-bit boundary is
+// appended after the data and is not included in the length.
 func (a *AVP) Encode() ([]byte, error) {
+	return a.encode(false)
+}
+
+// encode encodes the AVP, with the flags, and the order of Grouped
+// members, made canonical when canonical is set.
+func (a *AVP) encode(canonical bool) ([]byte, error) {
+	flags := a.Flags
+	var data []byte
+	var err error
+	if canonical {
+		flags = canonicalFlags(a)
+		data, err = canonicalData(a.Data)
+	} else {
+		data, err = utils.Encode(a.Data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	headerLen := AVPHeaderLength
+	if flags&VENDOR_FLAG != 0 {
+		headerLen = AVPHeaderLengthWithV
+	}
+	// As for the message length, the AVP is only written to when its
+	// length changed.
+	if length := uint32(headerLen + len(data)); a.AVPlength != length {
+		a.AVPlength = length
+	}
 
-	header := make([]byte, a.getHeaderLength())
+	buffer := make([]byte, headerLen, int(a.AVPlength)+getPadding(int(a.AVPlength)))
+	header := buffer
 	byteCount := 0
-	copy(header, utils.ToBytes(a.Code, AVP_CODE_LENGTH))
+	utils.PutUint32(header, a.Code)
 	byteCount += AVP_CODE_LENGTH
-	header[byteCount] = a.Flags
+	header[byteCount] = flags
 	byteCount += AVP_FLAGS_LENGTH
-	copy(header[byteCount:], utils.ToBytes(a.AVPlength, AVP_LENGTH_LENGTH))
+	utils.PutUint24(header[byteCount:], a.AVPlength)
 	byteCount += AVP_LENGTH_LENGTH
-	if a.isFlagSet(VENDOR_FLAG) {
-		copy(header[byteCount:], utils.ToBytes(a.VendorID, AVP_VENDOR_ID_LENGTH))
+	if flags&VENDOR_FLAG != 0 {
+		utils.PutUint32(header[byteCount:], a.VendorID)
 	}
 
-	data, err := utils.Encode(a.Data)
-	if err != nil {
-		return nil, err
-	}
-	return append(header, data...), nil
+	buffer = append(buffer, data...)
+	return append(buffer, make([]byte, getPadding(len(buffer)))...), nil
 }
 
 func (a *AVP) Decode(data []byte) error {
-	if len(data) < a.getHeaderLength() {
-		return fmt.Errorf("AVP Decode: Insufficient data")
+	return a.decode(data, DecodeOptions{}, false, 0)
+}
+
+// decode decodes the AVP from data with the checks of opts. When tolerant
+// a value that fails to decode as its registered type, or fails a check,
+// is kept as a raw OctetString and the error is recorded on the AVP
+// instead of being returned; errors in the AVP header are always returned.
+// The members of a Grouped AVP are decoded strictly, so a bad member
+// keeps the whole group raw. depth is the number of Grouped AVPs enclosing
+// this one and is checked against the maximum group depth of opts.
+func (a *AVP) decode(data []byte, opts DecodeOptions, tolerant bool, depth int) error {
+	if len(data) < AVPHeaderLength {
+		var code uint32
+		if len(data) >= AVP_CODE_LENGTH {
+			code = utils.Uint32(data)
+		}
+		return &AVPError{Code: code, Reason: fmt.Errorf("%w: %d bytes left for an AVP header", InvalidAVPLengthError, len(data))}
 	}
 
-	a.Code = utils.FromBytes(data[0:AVP_CODE_LENGTH])
+	a.Code = utils.Uint32(data)
 	byteCount := AVP_CODE_LENGTH
 
 	a.Flags = data[byteCount]
 	byteCount += AVP_FLAGS_LENGTH
 
-	a.AVPlength = utils.FromBytes(data[byteCount : byteCount+AVP_LENGTH_LENGTH])
+	a.AVPlength = utils.Uint24(data[byteCount:])
 	byteCount += AVP_LENGTH_LENGTH
 
+	headerLen := a.getHeaderLength()
+	if len(data) < headerLen {
+		return &AVPError{Code: a.Code, Reason: fmt.Errorf("%w: %d bytes left for an AVP header", InvalidAVPLengthError, len(data))}
+	}
 	if a.isFlagSet(VENDOR_FLAG) {
-		a.VendorID = utils.FromBytes(data[byteCount : byteCount+AVP_VENDOR_ID_LENGTH])
+		a.VendorID = utils.Uint32(data[byteCount:])
 	}
 
+	if a.AVPlength < uint32(headerLen) {
+		return &AVPError{Code: a.Code, Vendor: a.VendorID, Reason: InvalidAVPLengthError}
+	}
 	if len(data) < int(a.AVPlength) {
-		return fmt.Errorf("AVP Decode: Insufficient data")
+		return &AVPError{Code: a.Code, Vendor: a.VendorID, Reason: fmt.Errorf("%w: %d exceeds the %d bytes left", InvalidAVPLengthError, a.AVPlength, len(data))}
+	}
+
+	if opts.RetainRawAVPBytes {
+		end := min(int(a.AVPlength)+getPadding(int(a.AVPlength)), len(data))
+		if depth == 0 {
+			// Members of a Grouped AVP share the copy of the group.
+			data = append([]byte(nil), data[:end]...)
+		}
+		a.raw = data[:end:end]
 	}
 
-	return utils.Decode(a.Data, data[a.getHeaderLength():])
+	value := data[headerLen:a.AVPlength]
+	f, custom := customAVPType(a.Code, a.VendorID)
+	known := custom
+	if custom {
+		// The builtin types copy what they keep of value, registered types
+		// may not; the frame value is sliced from can be reused as soon as
+		// the message is decoded.
+		value = bytes.Clone(value)
+	} else {
+		f, known = avpTypeMap[a.Code]
+	}
+	if known {
+		a.Data = f()
+	} else {
+		a.Data = &OctetString{}
+	}
+	var err error
+	if g, ok := a.Data.(*Grouped); ok {
+		if depth >= opts.maxGroupDepth() {
+			return &AVPError{Code: a.Code, Vendor: a.VendorID, Reason: GroupTooDeepError}
+		}
+		g.AVPs, err = decodeAVPs(value, opts, false, depth+1)
+	} else {
+		err = utils.Decode(a.Data, value)
+	}
+	if err == nil {
+		err = opts.checkValue(a, known)
+	}
+	if err == nil {
+		return nil
+	}
+	err = &AVPError{Code: a.Code, Vendor: a.VendorID, Reason: err}
+	if !tolerant {
+		return err
+	}
+	raw := &OctetString{}
+	if rawErr := raw.Decode(value); rawErr != nil {
+		return err
+	}
+	a.Data = raw
+	a.decodeErr = err
+	return nil
+}
+
+// DecodeError returns the error that made tolerant decoding keep the value
+// of the AVP as a raw OctetString, or nil.
+func (a *AVP) DecodeError() error {
+	return a.decodeErr
+}
+
+// Raw returns the AVP exactly as received, header, data and padding, when
+// it was decoded with RetainRawAVPBytes, and nil otherwise. The bytes are
+// a copy of the input, shared with the members of a Grouped AVP, and must
+// not be modified. They do not follow later changes to the AVP.
+func (a *AVP) Raw() []byte {
+	return a.raw
+}
+
+// newAVPData returns an empty value of the type registered for code of
+// vendorID. Unknown AVPs are kept as OctetString.
+func newAVPData(code, vendorID uint32) AVPData {
+	if f, ok := lookupAVPType(code, vendorID); ok {
+		return f()
+	}
+	return &OctetString{}
+}
+
+// NewAVPData returns an empty value of the type registered for code and
+// whether code is known.
+func NewAVPData(code uint32) (AVPData, bool) {
+	return newAVPDataFor(code, 0)
+}
+
+// newAVPDataFor is NewAVPData for an AVP of vendorID, whose type may have
+// been registered with RegisterAVPType.
+func newAVPDataFor(code, vendorID uint32) (AVPData, bool) {
+	f, ok := lookupAVPType(code, vendorID)
+	if !ok {
+		return nil, false
+	}
+	return f(), true
 }
 
 func (a *AVP) setFlag(flag uint8) {
@@ -151,6 +311,7 @@ func (a *AVP) resetFlag(flag uint8) {
 //		-  int64 for Integer64 AVP.
 //		-  uint32 for Unsigned32 AVP.
 //		-  uint64 for Unsigned64 AVP.
+//		-  time.Time for Time AVP.
 //		-  *AVP for nested AVPs.
 //		-  *Grouped for grouped AVPs.
 //	flag: The AVP flags.
@@ -159,18 +320,36 @@ func (a *AVP) resetFlag(flag uint8) {
 // Returns:
 //
 //	A pointer to the newly created AVP and an error if the creation fails.
-func NewAVP[T constraints.Ordered | net.IP](
+func NewAVP[T avpValue](
 	code uint32,
 	value T,
 	flag uint8,
 	vendorID ...uint32,
 ) (*AVP, error) {
+	return newAVP(code, value, flag, vendorID...)
+}
+
+// avpValue constrains the values NewAVP accepts.
+type avpValue interface {
+	constraints.Ordered | net.IP | time.Time
+}
+
+// newAVP is NewAVP for values whose type is only known at run time.
+func newAVP(code uint32, value any, flag uint8, vendorID ...uint32) (*AVP, error) {
 	headerLen := AVPHeaderLength
 	if flag&VENDOR_FLAG != 0 {
 		headerLen = AVPHeaderLengthWithV
 	}
 
-	f, ok := avpTypeMap[code]
+	vID := uint32(0)
+	if flag&VENDOR_FLAG != 0 {
+		if len(vendorID) == 0 {
+			return nil, VendorIDRequiredError
+		}
+		vID = vendorID[0]
+	}
+
+	f, ok := lookupAVPType(code, vID)
 	if !ok {
 		return nil, errors.New("Unsupported AVP code")
 	}
@@ -180,23 +359,30 @@ func NewAVP[T constraints.Ordered | net.IP](
 		return nil, err
 	}
 
+	// The AVP Length covers the header and the unpadded data. The 'P' bit
+	// does not change the layout of the AVP.
 	length := uint32(headerLen) + data.Length()
 
-	// NOTE: Do i need to do this since i am already checking in the switch case
-	// for the octet string
-	if flag&PROTECTED_FLAG != 0 {
-		length += AVP_PROTECTION_LENGTH
-	}
+	return &AVP{
+		Code:      code,
+		Flags:     flag,
+		AVPlength: length,
+		VendorID:  vID,
+		Data:      data,
+	}, nil
 
+}
+
+// NewGroupedAVP creates a Grouped AVP holding avps. vendorID is only used
+// when the vendor flag is set.
+func NewGroupedAVP(code uint32, flag uint8, vendorID uint32, avps ...*AVP) (*AVP, error) {
+	data := &Grouped{AVPs: avps}
+	length := uint32(AVPHeaderLength) + data.Length()
 	vID := uint32(0)
 	if flag&VENDOR_FLAG != 0 {
-		if len(vendorID) == 0 {
-			return nil, VendorIDRequiredError
-		}
-		vID = vendorID[0]
-		length += AVP_VENDOR_ID_LENGTH
+		vID = vendorID
+		length = uint32(AVPHeaderLengthWithV) + data.Length()
 	}
-
 	return &AVP{
 		Code:      code,
 		Flags:     flag,
@@ -204,7 +390,6 @@ func NewAVP[T constraints.Ordered | net.IP](
 		VendorID:  vID,
 		Data:      data,
 	}, nil
-
 }
 
 func getPadding(length int) int {
@@ -212,16 +397,22 @@ func getPadding(length int) int {
 }
 
 func extractAVPs(data []byte) ([]*AVP, error) {
+	return decodeAVPs(data, DecodeOptions{}, false, 0)
+}
+
+// decodeAVPs decodes consecutive AVPs, tolerating value errors when
+// tolerant is set. depth is the number of Grouped AVPs enclosing data.
+func decodeAVPs(data []byte, opts DecodeOptions, tolerant bool, depth int) ([]*AVP, error) {
 	avps := make([]*AVP, 0)
 	offset := 0
 	for offset < len(data) {
 		avp := &AVP{}
-		if err := avp.Decode(data[offset:]); err != nil {
+		if err := avp.decode(data[offset:], opts, tolerant, depth); err != nil {
 			return nil, err
 		}
 		avps = append(avps, avp)
-		// Move to the next AVP
-		// Take care of padding
+		// Move to the next AVP, skipping the padding which is not
+		// included in the AVP Length
 		offset += int(avp.AVPlength) + getPadding(int(avp.AVPlength))
 
 	}
@@ -238,3 +429,92 @@ func (msg *DiameterMessage) GetAVP(code uint32) *AVP {
 	}
 	return nil
 }
+
+// Clone returns a deep copy of the AVP.
+func (a *AVP) Clone() *AVP {
+	clone := *a
+	if a.Data != nil {
+		clone.Data = cloneAVPData(a.Code, a.VendorID, a.Data)
+	}
+	return &clone
+}
+
+func cloneAVPs(avps []*AVP) []*AVP {
+	clone := make([]*AVP, len(avps))
+	for i, avp := range avps {
+		clone[i] = avp.Clone()
+	}
+	return clone
+}
+
+// cloneAVPData deep copies the value of an AVP. Types without reference
+// fields are copied by value; unknown implementations are round-tripped
+// through their wire encoding.
+func cloneAVPData(code, vendorID uint32, data AVPData) AVPData {
+	switch d := data.(type) {
+	case *OctetString:
+		c := *d
+		c.Data = append([]byte(nil), d.Data...)
+		return &c
+	case *Address:
+		c := *d
+		c.Data = append(net.IP(nil), d.Data...)
+		if d.Raw != nil {
+			c.Raw = append([]byte{}, d.Raw...)
+		}
+		return &c
+	case *Grouped:
+		return &Grouped{AVPs: cloneAVPs(d.AVPs)}
+	case *Integer32:
+		c := *d
+		return &c
+	case *Integer64:
+		c := *d
+		return &c
+	case *Unsigned32:
+		c := *d
+		return &c
+	case *Unsigned64:
+		c := *d
+		return &c
+	case *Float32:
+		c := *d
+		return &c
+	case *Float64:
+		c := *d
+		return &c
+	case *UTF8String:
+		c := *d
+		return &c
+	case *Enumerated:
+		c := *d
+		return &c
+	case *Time:
+		c := *d
+		return &c
+	case *DiameterIdentity:
+		c := *d
+		return &c
+	case *DiameterURI:
+		c := *d
+		return &c
+	case *AppId:
+		c := *d
+		return &c
+	case *VendorId:
+		c := *d
+		return &c
+	case *IPFilterRule:
+		c := *d
+		return &c
+	}
+	encoded, err := data.Encode()
+	if err != nil {
+		return data
+	}
+	clone := newAVPData(code, vendorID)
+	if err := clone.Decode(encoded); err != nil {
+		return data
+	}
+	return clone
+}
@@ -0,0 +1,84 @@
+// Default AVP flag registry
+package message
+
+import "sync"
+
+var (
+	avpFlagsMu  sync.RWMutex
+	avpFlagsMap = map[avpKey]uint8{
+		// RFC 6733 section 4.5: every base protocol AVP has the 'M' bit.
+		{0, AVP_USER_NAME}:                      MANDATORY_FLAG,
+		{0, AVP_CLASS}:                          MANDATORY_FLAG,
+		{0, AVP_SESSION_TIMEOUT}:                MANDATORY_FLAG,
+		{0, AVP_PROXY_STATE}:                    MANDATORY_FLAG,
+		{0, AVP_ACCT_SESSION_ID}:                MANDATORY_FLAG,
+		{0, AVP_ACCOUNTING_MULTI_SESSION_ID}:    MANDATORY_FLAG,
+		{0, AVP_EVENT_TIMESTAMP}:                MANDATORY_FLAG,
+		{0, AVP_ACCT_INTERIM_INTERVAL}:          MANDATORY_FLAG,
+		{0, AVP_HOST_IP_ADDRESS}:                MANDATORY_FLAG,
+		{0, AVP_AUTH_APPLICATION_ID}:            MANDATORY_FLAG,
+		{0, AVP_ACCT_APPLICATION_ID}:            MANDATORY_FLAG,
+		{0, AVP_VENDOR_SPECIFIC_APPLICATION_ID}: MANDATORY_FLAG,
+		{0, AVP_REDIRECT_HOST_USAGE}:            MANDATORY_FLAG,
+		{0, AVP_REDIRECT_MAX_CACHE_TIME}:        MANDATORY_FLAG,
+		{0, AVP_SESSION_ID}:                     MANDATORY_FLAG,
+		{0, AVP_ORIGIN_HOST}:                    MANDATORY_FLAG,
+		{0, AVP_SUPPORTED_VENDOR_ID}:            MANDATORY_FLAG,
+		{0, AVP_VENDOR_ID}:                      MANDATORY_FLAG,
+		{0, AVP_FIRMWARE_REVISION}:              MANDATORY_FLAG,
+		{0, AVP_RESULT_CODE}:                    MANDATORY_FLAG,
+		{0, AVP_PRODUCT_NAME}:                   MANDATORY_FLAG,
+		{0, AVP_SESSION_BINDING}:                MANDATORY_FLAG,
+		{0, AVP_SESSION_SERVER_FAILOVER}:        MANDATORY_FLAG,
+		{0, AVP_MULTI_ROUND_TIME_OUT}:           MANDATORY_FLAG,
+		{0, AVP_DISCONNECT_CAUSE}:               MANDATORY_FLAG,
+		{0, AVP_AUTH_REQUEST_TYPE}:              MANDATORY_FLAG,
+		{0, AVP_AUTH_GRACE_PERIOD}:              MANDATORY_FLAG,
+		{0, AVP_AUTH_SESSION_STATE}:             MANDATORY_FLAG,
+		{0, AVP_ORIGIN_STATE_ID}:                MANDATORY_FLAG,
+		{0, AVP_FAILED_AVP}:                     MANDATORY_FLAG,
+		{0, AVP_PROXY_HOST}:                     MANDATORY_FLAG,
+		{0, AVP_ERROR_MESSAGE}:                  MANDATORY_FLAG,
+		{0, AVP_ROUTE_RECORD}:                   MANDATORY_FLAG,
+		{0, AVP_DESTINATION_REALM}:              MANDATORY_FLAG,
+		{0, AVP_PROXY_INFO}:                     MANDATORY_FLAG,
+		{0, AVP_RE_AUTH_REQUEST_TYPE}:           MANDATORY_FLAG,
+		{0, AVP_ACCOUNTING_SUB_SESSION_ID}:      MANDATORY_FLAG,
+		{0, AVP_AUTHORIZATION_LIFETIME}:         MANDATORY_FLAG,
+		{0, AVP_REDIRECT_HOST}:                  MANDATORY_FLAG,
+		{0, AVP_DESTINATION_HOST}:               MANDATORY_FLAG,
+		{0, AVP_ERROR_REPORTING_HOST}:           MANDATORY_FLAG,
+		{0, AVP_TERMINATION_CAUSE}:              MANDATORY_FLAG,
+		{0, AVP_ORIGIN_REALM}:                   MANDATORY_FLAG,
+		{0, AVP_EXPERIMENTAL_RESULT}:            MANDATORY_FLAG,
+		{0, AVP_EXPERIMENTAL_RESULT_CODE}:       MANDATORY_FLAG,
+		{0, AVP_INBAND_SECURITY_ID}:             MANDATORY_FLAG,
+		{0, AVP_ACCOUNTING_RECORD_TYPE}:         MANDATORY_FLAG,
+		{0, AVP_ACCOUNTING_REALTIME_REQUIRED}:   MANDATORY_FLAG,
+		{0, AVP_ACCOUNTING_RECORD_NUMBER}:       MANDATORY_FLAG,
+		// RFC 8583 section 7: the load AVPs are sent without the 'M' bit.
+		{0, AVP_SOURCE_ID}:  0,
+		{0, AVP_LOAD}:       0,
+		{0, AVP_LOAD_TYPE}:  0,
+		{0, AVP_LOAD_VALUE}: 0,
+	}
+)
+
+// RegisterAVPFlags sets the flags canonical encoding gives the AVP code
+// of vendorID, 0 for IETF AVPs, when it is built without flags, or with
+// only the vendor flag its Vendor-ID needs. The vendor flag is added for
+// vendor-specific AVPs.
+func RegisterAVPFlags(code, vendorID uint32, flags uint8) {
+	avpFlagsMu.Lock()
+	defer avpFlagsMu.Unlock()
+	avpFlagsMap[avpKey{vendorID, code}] = flags
+}
+
+// LookupAVPFlags returns the registered default flags of the AVP code of
+// vendorID.
+func LookupAVPFlags(code, vendorID uint32) (uint8, bool) {
+	avpFlagsMu.RLock()
+	defer avpFlagsMu.RUnlock()
+	flags, ok := avpFlagsMap[avpKey{vendorID, code}]
+	return flags, ok
+}
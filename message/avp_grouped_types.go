@@ -0,0 +1,17 @@
+package message
+
+// Grouped AVPs of the base protocol. These are kept apart from the
+// generated type map, which only knows about scalar types.
+var groupedAVPCodes = []uint32{
+	AVP_VENDOR_SPECIFIC_APPLICATION_ID,
+	AVP_FAILED_AVP,
+	AVP_PROXY_INFO,
+	AVP_EXPERIMENTAL_RESULT,
+	AVP_E2E_SEQUENCE,
+}
+
+func init() {
+	for _, code := range groupedAVPCodes {
+		avpTypeMap[code] = func() AVPData { return &Grouped{} }
+	}
+}
@@ -0,0 +1,141 @@
+// AVP and vendor name registry
+package message
+
+import (
+	"fmt"
+	"sync"
+)
+
+type avpKey struct {
+	vendorID uint32
+	code     uint32
+}
+
+var (
+	avpNamesMu sync.RWMutex
+	avpNameMap = map[avpKey]string{
+		// RFC 6733
+		{0, AVP_USER_NAME}:                      "User-Name",
+		{0, AVP_CLASS}:                          "Class",
+		{0, AVP_SESSION_TIMEOUT}:                "Session-Timeout",
+		{0, AVP_PROXY_STATE}:                    "Proxy-State",
+		{0, AVP_ACCT_SESSION_ID}:                "Accounting-Session-Id",
+		{0, AVP_ACCOUNTING_MULTI_SESSION_ID}:    "Acct-Multi-Session-Id",
+		{0, AVP_EVENT_TIMESTAMP}:                "Event-Timestamp",
+		{0, AVP_ACCT_INTERIM_INTERVAL}:          "Acct-Interim-Interval",
+		{0, AVP_HOST_IP_ADDRESS}:                "Host-IP-Address",
+		{0, AVP_AUTH_APPLICATION_ID}:            "Auth-Application-Id",
+		{0, AVP_ACCT_APPLICATION_ID}:            "Acct-Application-Id",
+		{0, AVP_VENDOR_SPECIFIC_APPLICATION_ID}: "Vendor-Specific-Application-Id",
+		{0, AVP_REDIRECT_HOST_USAGE}:            "Redirect-Host-Usage",
+		{0, AVP_REDIRECT_MAX_CACHE_TIME}:        "Redirect-Max-Cache-Time",
+		{0, AVP_SESSION_ID}:                     "Session-Id",
+		{0, AVP_ORIGIN_HOST}:                    "Origin-Host",
+		{0, AVP_SUPPORTED_VENDOR_ID}:            "Supported-Vendor-Id",
+		{0, AVP_VENDOR_ID}:                      "Vendor-Id",
+		{0, AVP_FIRMWARE_REVISION}:              "Firmware-Revision",
+		{0, AVP_RESULT_CODE}:                    "Result-Code",
+		{0, AVP_PRODUCT_NAME}:                   "Product-Name",
+		{0, AVP_SESSION_BINDING}:                "Session-Binding",
+		{0, AVP_SESSION_SERVER_FAILOVER}:        "Session-Server-Failover",
+		{0, AVP_MULTI_ROUND_TIME_OUT}:           "Multi-Round-Time-Out",
+		{0, AVP_DISCONNECT_CAUSE}:               "Disconnect-Cause",
+		{0, AVP_AUTH_REQUEST_TYPE}:              "Auth-Request-Type",
+		{0, AVP_AUTH_GRACE_PERIOD}:              "Auth-Grace-Period",
+		{0, AVP_AUTH_SESSION_STATE}:             "Auth-Session-State",
+		{0, AVP_ORIGIN_STATE_ID}:                "Origin-State-Id",
+		{0, AVP_FAILED_AVP}:                     "Failed-AVP",
+		{0, AVP_PROXY_HOST}:                     "Proxy-Host",
+		{0, AVP_ERROR_MESSAGE}:                  "Error-Message",
+		{0, AVP_ROUTE_RECORD}:                   "Route-Record",
+		{0, AVP_DESTINATION_REALM}:              "Destination-Realm",
+		{0, AVP_PROXY_INFO}:                     "Proxy-Info",
+		{0, AVP_RE_AUTH_REQUEST_TYPE}:           "Re-Auth-Request-Type",
+		{0, AVP_ACCOUNTING_SUB_SESSION_ID}:      "Accounting-Sub-Session-Id",
+		{0, AVP_AUTHORIZATION_LIFETIME}:         "Authorization-Lifetime",
+		{0, AVP_REDIRECT_HOST}:                  "Redirect-Host",
+		{0, AVP_DESTINATION_HOST}:               "Destination-Host",
+		{0, AVP_ERROR_REPORTING_HOST}:           "Error-Reporting-Host",
+		{0, AVP_TERMINATION_CAUSE}:              "Termination-Cause",
+		{0, AVP_ORIGIN_REALM}:                   "Origin-Realm",
+		{0, AVP_EXPERIMENTAL_RESULT}:            "Experimental-Result",
+		{0, AVP_EXPERIMENTAL_RESULT_CODE}:       "Experimental-Result-Code",
+		{0, AVP_INBAND_SECURITY_ID}:             "Inband-Security-Id",
+		{0, AVP_ACCOUNTING_RECORD_TYPE}:         "Accounting-Record-Type",
+		{0, AVP_ACCOUNTING_REALTIME_REQUIRED}:   "Accounting-Realtime-Required",
+		{0, AVP_ACCOUNTING_RECORD_NUMBER}:       "Accounting-Record-Number",
+		// RFC 8583
+		{0, AVP_SOURCE_ID}:  "SourceID",
+		{0, AVP_LOAD}:       "Load",
+		{0, AVP_LOAD_TYPE}:  "Load-Type",
+		{0, AVP_LOAD_VALUE}: "Load-Value",
+	}
+	vendorNameMap = map[uint32]string{
+		VENDOR_HEWLETT_PACKARD:      "Hewlett-Packard",
+		VENDOR_SUN_MICROSYSTEMS_INC: "Sun Microsystems",
+		VENDOR_MERIT_NETWORKS:       "Merit Networks",
+		VENDOR_NOKIA:                "Nokia",
+		VENDOR_ERICSSON:             "Ericsson",
+		VENDOR_US_ROBOTICS_CORP:     "US Robotics",
+		VENDOR_LUCENT_TECHNOLOGIES:  "Lucent Technologies",
+		VENDOR_HUAWEI:               "Huawei",
+		VENDOR_DEUTSCHE_TELEKOM_AG:  "Deutsche Telekom",
+		VENDOR_3GPP2:                "3GPP2",
+		VENDOR_CISCO:                "Cisco",
+		VENDOR_SK_TELECOM:           "SK Telecom",
+		VENDOR_3GPP:                 "3GPP",
+		VENDOR_VODAFONE:             "Vodafone",
+		VENDOR_VERIZON_WIRELESS:     "Verizon Wireless",
+		VENDOR_ETSI:                 "ETSI",
+	}
+)
+
+// RegisterAVPName sets the name under which the AVP code of vendorID, 0
+// for IETF AVPs, is shown, replacing any existing name.
+func RegisterAVPName(code, vendorID uint32, name string) {
+	avpNamesMu.Lock()
+	defer avpNamesMu.Unlock()
+	avpNameMap[avpKey{vendorID, code}] = name
+}
+
+// LookupAVPName returns the registered name of the AVP code of vendorID.
+func LookupAVPName(code, vendorID uint32) (string, bool) {
+	avpNamesMu.RLock()
+	defer avpNamesMu.RUnlock()
+	name, ok := avpNameMap[avpKey{vendorID, code}]
+	return name, ok
+}
+
+// AVPName is like LookupAVPName but returns "Unknown" for unregistered
+// AVPs, as Wireshark does.
+func AVPName(code, vendorID uint32) string {
+	if name, ok := LookupAVPName(code, vendorID); ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// RegisterVendorName sets the name under which vendorID is shown,
+// replacing any existing name.
+func RegisterVendorName(vendorID uint32, name string) {
+	avpNamesMu.Lock()
+	defer avpNamesMu.Unlock()
+	vendorNameMap[vendorID] = name
+}
+
+// LookupVendorName returns the registered name of vendorID.
+func LookupVendorName(vendorID uint32) (string, bool) {
+	avpNamesMu.RLock()
+	defer avpNamesMu.RUnlock()
+	name, ok := vendorNameMap[vendorID]
+	return name, ok
+}
+
+// VendorName is like LookupVendorName but returns the number for
+// unregistered vendors.
+func VendorName(vendorID uint32) string {
+	if name, ok := LookupVendorName(vendorID); ok {
+		return name
+	}
+	return fmt.Sprint(vendorID)
+}
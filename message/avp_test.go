@@ -0,0 +1,99 @@
+package message
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// TestAVPLengthExcludesPadding encodes a Vendor-Specific, protected
+// User-Name whose value is not a multiple of 4 bytes long.
+func TestAVPLengthExcludesPadding(t *testing.T) {
+	want := []byte{
+		0x00, 0x00, 0x00, 0x01, // code
+		0xe0, 0x00, 0x00, 0x11, // V, M and P bits, length 17
+		0x00, 0x00, 0x28, 0xaf, // vendor 10415
+		'a', 'b', 'c', 'd', 'e', 0x00, 0x00, 0x00, // value and padding
+	}
+	avp, err := NewAVP(AVP_USER_NAME, "abcde", VENDOR_FLAG|MANDATORY_FLAG|PROTECTED_FLAG, 10415)
+	if err != nil {
+		t.Fatalf("NewAVP: %v", err)
+	}
+	if avp.Length() != 17 {
+		t.Errorf("AVP Length %d, want 17", avp.Length())
+	}
+	got, err := avp.Encode()
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("encoded as\n%x\nwant\n%x", got, want)
+	}
+
+	decoded, err := DecodeAVP(want)
+	if err != nil {
+		t.Fatalf("DecodeAVP: %v", err)
+	}
+	if decoded.Length() != 17 || decoded.VendorID != 10415 || decoded.Flags != VENDOR_FLAG|MANDATORY_FLAG|PROTECTED_FLAG {
+		t.Errorf("decoded length %d, vendor %d, flags %#x", decoded.Length(), decoded.VendorID, decoded.Flags)
+	}
+	if s, err := decoded.Str(); err != nil || s != "abcde" {
+		t.Errorf("decoded value %q, %v; want \"abcde\"", s, err)
+	}
+}
+
+// TestPassthroughAVPsRoundTrip round-trips the AVPs applications carry on
+// behalf of the session, each built from the Go value NewAVP takes for its
+// type and read back with the matching accessor.
+func TestPassthroughAVPsRoundTrip(t *testing.T) {
+	// The second timestamp is past 7 February 2036, where the NTP seconds
+	// wrap around and lose their most significant bit.
+	for _, when := range []time.Time{
+		time.Date(2026, 10, 16, 12, 30, 15, 0, time.UTC),
+		time.Date(2040, 1, 2, 3, 4, 5, 0, time.UTC),
+	} {
+		avp := roundTripAVP(t, MustNewAVP(AVP_EVENT_TIMESTAMP, when.Add(900*time.Millisecond), MANDATORY_FLAG))
+		if _, ok := avp.Data.(*Time); !ok {
+			t.Fatalf("Event-Timestamp decoded as %T", avp.Data)
+		}
+		if got, err := avp.Time(); err != nil || !got.Equal(when) {
+			t.Errorf("Event-Timestamp %v, %v; want %v", got, err, when)
+		}
+	}
+
+	avp := roundTripAVP(t, MustNewAVP(AVP_USER_NAME, "alice@example.com", MANDATORY_FLAG))
+	if _, ok := avp.Data.(*UTF8String); !ok {
+		t.Fatalf("User-Name decoded as %T", avp.Data)
+	}
+	if got, err := avp.Str(); err != nil || got != "alice@example.com" {
+		t.Errorf("User-Name %q, %v", got, err)
+	}
+
+	for _, code := range []uint32{AVP_CLASS, AVP_PROXY_STATE} {
+		// Opaque values need not be UTF-8 and are set as bytes.
+		value := []byte{0x00, 0xff, 'c', 0x80, 0x01}
+		avp := &AVP{Code: code, Flags: MANDATORY_FLAG, Data: &OctetString{}}
+		if err := avp.Data.SetData(value); err != nil {
+			t.Fatal(err)
+		}
+		decoded := roundTripAVP(t, avp)
+		if _, ok := decoded.Data.(*OctetString); !ok {
+			t.Fatalf("AVP %d decoded as %T", code, decoded.Data)
+		}
+		if got, err := decoded.Bytes(); err != nil || !bytes.Equal(got, value) {
+			t.Errorf("AVP %d value %x, %v; want %x", code, got, err, value)
+		}
+		decoded = roundTripAVP(t, MustNewAVP(code, "state", MANDATORY_FLAG))
+		if got, err := decoded.Bytes(); err != nil || string(got) != "state" {
+			t.Errorf("AVP %d built from a string decoded as %q, %v", code, got, err)
+		}
+	}
+
+	avp = roundTripAVP(t, MustNewAVP(AVP_TERMINATION_CAUSE, DIAMETER_SESSION_TIMEOUT, MANDATORY_FLAG))
+	if _, ok := avp.Data.(*Enumerated); !ok {
+		t.Fatalf("Termination-Cause decoded as %T", avp.Data)
+	}
+	if got, err := avp.Uint32(); err != nil || got != DIAMETER_SESSION_TIMEOUT {
+		t.Errorf("Termination-Cause %d, %v; want %d", got, err, DIAMETER_SESSION_TIMEOUT)
+	}
+}
@@ -162,7 +162,7 @@ var avpTypeMap = map[uint32]func() AVPData{
     AVP_AUTH_REQUEST_TYPE                   : func() AVPData { return &Unsigned32{} },
     AVP_ALTERNATE_PEER                      : func() AVPData { return &DiameterIdentity{} },
     AVP_AUTH_GRACE_PERIOD                   : func() AVPData { return &Unsigned32{} },
-    AVP_AUTH_SESSION_STATE                  : func() AVPData { return &Unsigned32{} },
+    AVP_AUTH_SESSION_STATE                  : func() AVPData { return &Enumerated{} },
     AVP_ORIGIN_STATE_ID                     : func() AVPData { return &Unsigned32{} },
     AVP_PROXY_HOST                          : func() AVPData { return &DiameterIdentity{} },
     AVP_ERROR_MESSAGE                       : func() AVPData { return &UTF8String{} },
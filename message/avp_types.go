@@ -0,0 +1,125 @@
+// Registry of AVP value types defined by applications
+package message
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	avpTypesMu sync.Mutex
+	// customAVPTypes holds the factories registered with RegisterAVPType.
+	// It is replaced as a whole on registration, so that decoding looks
+	// types up without locking.
+	customAVPTypes atomic.Pointer[map[avpKey]func() AVPData]
+)
+
+// RegisterAVPType makes the AVP code of vendorID, 0 for IETF AVPs, decode
+// into the values returned by factory, and NewAVP build them with their
+// SetData, taking precedence over the type in the dictionary. factory is
+// first checked with CheckAVPData, and nothing is registered if the check
+// fails. It is meant for value types the dictionary cannot express, such
+// as TBCD-encoded digits; see examples/tbcd.
+//
+// When decoding, the Decode method of the values gets a copy of the AVP
+// data of its own, which it may keep: unlike the builtin types, it is not
+// handed a slice of the frame being decoded, which the server reuses for
+// the next message.
+func RegisterAVPType(code, vendorID uint32, factory func() AVPData) error {
+	if err := CheckAVPData(factory); err != nil {
+		return &AVPError{Code: code, Vendor: vendorID, Reason: err}
+	}
+	avpTypesMu.Lock()
+	defer avpTypesMu.Unlock()
+	types := make(map[avpKey]func() AVPData)
+	if current := customAVPTypes.Load(); current != nil {
+		maps.Copy(types, *current)
+	}
+	types[avpKey{vendorID, code}] = factory
+	customAVPTypes.Store(&types)
+	return nil
+}
+
+// CheckAVPData verifies that the values returned by factory keep the
+// contract of AVPData that encoding, decoding and Clone rely on:
+//   - every call returns a new, non-nil value,
+//   - Length is the length of the encoding, without padding, and
+//   - decoding the encoding into a new value and encoding it again gives
+//     the same bytes.
+//
+// The empty value is checked, and a value set with SetData from each of
+// samples.
+func CheckAVPData(factory func() AVPData, samples ...any) error {
+	if factory == nil {
+		return errors.New("AVPData factory is nil")
+	}
+	first, second := factory(), factory()
+	if first == nil || second == nil {
+		return errors.New("AVPData factory returned nil")
+	}
+	if first == second {
+		return errors.New("AVPData factory returned the same value twice")
+	}
+	if err := checkRoundTrip(first, factory); err != nil {
+		return fmt.Errorf("empty %T: %w", first, err)
+	}
+	for _, sample := range samples {
+		data := factory()
+		if err := data.SetData(sample); err != nil {
+			return fmt.Errorf("%T from %v: %w", data, sample, err)
+		}
+		if err := checkRoundTrip(data, factory); err != nil {
+			return fmt.Errorf("%T from %v: %w", data, sample, err)
+		}
+	}
+	return nil
+}
+
+// checkRoundTrip checks data against the contract of CheckAVPData.
+func checkRoundTrip(data AVPData, factory func() AVPData) error {
+	encoded, err := data.Encode()
+	if err != nil {
+		return fmt.Errorf("encoding: %w", err)
+	}
+	if int(data.Length()) != len(encoded) {
+		return fmt.Errorf("Length %d does not match the %d bytes encoded", data.Length(), len(encoded))
+	}
+	decoded := factory()
+	if err := decoded.Decode(encoded); err != nil {
+		return fmt.Errorf("decoding its encoding: %w", err)
+	}
+	again, err := decoded.Encode()
+	if err != nil {
+		return fmt.Errorf("encoding the decoded value: %w", err)
+	}
+	if !bytes.Equal(encoded, again) {
+		return fmt.Errorf("decoded value encodes to %x instead of %x", again, encoded)
+	}
+	return nil
+}
+
+// lookupAVPType returns the factory of the values of the AVP code of
+// vendorID: the one registered with RegisterAVPType, else the one of the
+// dictionary, which is indexed by code alone.
+func lookupAVPType(code, vendorID uint32) (func() AVPData, bool) {
+	if f, ok := customAVPType(code, vendorID); ok {
+		return f, true
+	}
+	f, ok := avpTypeMap[code]
+	return f, ok
+}
+
+// customAVPType returns the factory registered with RegisterAVPType for
+// the AVP code of vendorID, if any.
+func customAVPType(code, vendorID uint32) (func() AVPData, bool) {
+	types := customAVPTypes.Load()
+	if types == nil {
+		return nil, false
+	}
+	f, ok := (*types)[avpKey{vendorID, code}]
+	return f, ok
+}
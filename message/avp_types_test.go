@@ -0,0 +1,178 @@
+package message
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Codes registered by the tests, under a vendor no dictionary uses.
+const (
+	testVendor       = uint32(99999)
+	testAVPTBCD      = uint32(701)
+	testAVPKeptBytes = uint32(702)
+)
+
+// tbcdDigits maps nibbles to the characters of TBCD, 3GPP TS 29.002.
+const tbcdDigits = "0123456789*#abc"
+
+// tbcd is the TBCD type of examples/tbcd.
+type tbcd struct {
+	digits string
+}
+
+func (t *tbcd) SetData(data interface{}) error {
+	s, ok := data.(string)
+	if !ok {
+		return fmt.Errorf("TBCD from %T", data)
+	}
+	for _, c := range s {
+		if !strings.ContainsRune(tbcdDigits, c) {
+			return fmt.Errorf("invalid TBCD digit %q", c)
+		}
+	}
+	t.digits = s
+	return nil
+}
+
+func (t *tbcd) Length() uint32 {
+	return uint32(len(t.digits)+1) / 2
+}
+
+func (t *tbcd) Encode() ([]byte, error) {
+	b := bytes.Repeat([]byte{0xf0}, int(t.Length()))
+	for i := 0; i < len(t.digits); i++ {
+		n := byte(strings.IndexByte(tbcdDigits, t.digits[i]))
+		if i%2 == 0 {
+			b[i/2] = b[i/2]&0xf0 | n
+		} else {
+			b[i/2] = b[i/2]&0x0f | n<<4
+		}
+	}
+	return b, nil
+}
+
+func (t *tbcd) Decode(data []byte) error {
+	var sb strings.Builder
+	for i, b := range data {
+		low, high := b&0x0f, b>>4
+		if low == 0xf {
+			return errors.New("TBCD filler in the low nibble")
+		}
+		sb.WriteByte(tbcdDigits[low])
+		if high == 0xf {
+			if i != len(data)-1 {
+				return errors.New("TBCD filler before the last octet")
+			}
+			break
+		}
+		sb.WriteByte(tbcdDigits[high])
+	}
+	t.digits = sb.String()
+	return nil
+}
+
+func (t *tbcd) String() string {
+	return t.digits
+}
+
+// keptBytes keeps the slice it decodes, as RegisterAVPType allows.
+type keptBytes struct {
+	b []byte
+}
+
+func (k *keptBytes) SetData(data interface{}) error {
+	s, ok := data.(string)
+	if !ok {
+		return fmt.Errorf("keptBytes from %T", data)
+	}
+	k.b = []byte(s)
+	return nil
+}
+
+func (k *keptBytes) Length() uint32          { return uint32(len(k.b)) }
+func (k *keptBytes) Encode() ([]byte, error) { return k.b, nil }
+func (k *keptBytes) String() string          { return fmt.Sprintf("%x", k.b) }
+
+func (k *keptBytes) Decode(data []byte) error {
+	k.b = data
+	return nil
+}
+
+func init() {
+	if err := RegisterAVPType(testAVPTBCD, testVendor, func() AVPData { return &tbcd{} }); err != nil {
+		panic(err)
+	}
+	if err := RegisterAVPType(testAVPKeptBytes, testVendor, func() AVPData { return &keptBytes{} }); err != nil {
+		panic(err)
+	}
+}
+
+func TestRegisteredTBCDRoundTripsIMSI(t *testing.T) {
+	const imsi = "001010123456789"
+	avp, err := NewAVP(testAVPTBCD, imsi, MANDATORY_FLAG|VENDOR_FLAG, testVendor)
+	if err != nil {
+		t.Fatalf("NewAVP: %v", err)
+	}
+	if _, ok := avp.Data.(*tbcd); !ok {
+		t.Fatalf("NewAVP built %T, want the registered type", avp.Data)
+	}
+	encoded, err := avp.Encode()
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	value := encoded[AVPHeaderLengthWithV:avp.AVPlength]
+	if want := []byte{0x00, 0x01, 0x01, 0x21, 0x43, 0x65, 0x87, 0xf9}; !bytes.Equal(value, want) {
+		t.Errorf("IMSI encodes as %x, want %x", value, want)
+	}
+
+	var decoded AVP
+	if err := decoded.Decode(encoded); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	got, ok := decoded.Data.(*tbcd)
+	if !ok {
+		t.Fatalf("decoded as %T, want the registered type", decoded.Data)
+	}
+	if got.digits != imsi {
+		t.Errorf("decoded IMSI %q, want %q", got.digits, imsi)
+	}
+}
+
+func TestRegisteredTypeDecodesOwnCopy(t *testing.T) {
+	const want = "kept after decoding"
+	avp, err := NewAVP(testAVPKeptBytes, want, VENDOR_FLAG, testVendor)
+	if err != nil {
+		t.Fatalf("NewAVP: %v", err)
+	}
+	frame, err := avp.Encode()
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	var decoded AVP
+	if err := decoded.Decode(frame); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	// The frame is reused, as the server does with its pooled buffers.
+	for i := range frame {
+		frame[i] = 0xff
+	}
+	if got := string(decoded.Data.(*keptBytes).b); got != want {
+		t.Errorf("value changed with the frame: %q, want %q", got, want)
+	}
+}
+
+func TestRegisterAVPTypeRejectsBrokenFactory(t *testing.T) {
+	shared := &tbcd{}
+	err := RegisterAVPType(703, testVendor, func() AVPData { return shared })
+	var aerr *AVPError
+	if !errors.As(err, &aerr) || aerr.Code != 703 || aerr.Vendor != testVendor {
+		t.Fatalf("RegisterAVPType = %v, want an AVPError for 703", err)
+	}
+	if _, ok := customAVPType(703, testVendor); ok {
+		t.Error("type registered despite failing CheckAVPData")
+	}
+}
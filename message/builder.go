@@ -0,0 +1,84 @@
+// Error-accumulating construction of AVPs and messages
+package message
+
+import "errors"
+
+// MustNewAVP is like NewAVP but panics if the AVP cannot be built. It is
+// meant for AVPs built from constants, such as package-level variables,
+// where an error is a programming mistake.
+func MustNewAVP[T avpValue](code uint32, value T, flag uint8, vendorID ...uint32) *AVP {
+	avp, err := NewAVP(code, value, flag, vendorID...)
+	if err != nil {
+		panic(err)
+	}
+	return avp
+}
+
+// MessageBuilder accumulates the AVPs of a message and the errors met
+// building them, so that a sequence of Add calls needs a single error
+// check, in AVPs or Build. The zero value builds AVPs only.
+type MessageBuilder struct {
+	code uint32
+	opts []RequestOption
+	avps []*AVP
+	errs []error
+}
+
+// NewMessageBuilder returns a builder of a request for code, created by
+// Build with opts.
+func NewMessageBuilder(code uint32, opts ...RequestOption) *MessageBuilder {
+	return &MessageBuilder{code: code, opts: opts}
+}
+
+// Add appends the AVP built by NewAVP from its arguments. value must be
+// one of the types NewAVP accepts. An error is recorded, wrapped in an
+// AVPError, and the AVP skipped.
+func (b *MessageBuilder) Add(code uint32, value any, flag uint8, vendorID ...uint32) *MessageBuilder {
+	avp, err := newAVP(code, value, flag, vendorID...)
+	if err != nil {
+		var vendor uint32
+		if len(vendorID) > 0 {
+			vendor = vendorID[0]
+		}
+		b.errs = append(b.errs, &AVPError{Code: code, Vendor: vendor, Reason: err})
+		return b
+	}
+	b.avps = append(b.avps, avp)
+	return b
+}
+
+// AddAVP appends avps as they are.
+func (b *MessageBuilder) AddAVP(avps ...*AVP) *MessageBuilder {
+	b.avps = append(b.avps, avps...)
+	return b
+}
+
+// AddAVPs appends avps, or records err, taking the results of functions
+// returning AVPs, such as Applications.AVPs, directly.
+func (b *MessageBuilder) AddAVPs(avps []*AVP, err error) *MessageBuilder {
+	if err != nil {
+		b.errs = append(b.errs, err)
+		return b
+	}
+	return b.AddAVP(avps...)
+}
+
+// AVPs returns the AVPs added so far, in order, and the errors recorded,
+// joined in the order they occurred.
+func (b *MessageBuilder) AVPs() ([]*AVP, error) {
+	if err := errors.Join(b.errs...); err != nil {
+		return nil, err
+	}
+	return b.avps, nil
+}
+
+// Build returns the request carrying the AVPs added, or the errors
+// recorded, joined in the order they occurred.
+func (b *MessageBuilder) Build() (*DiameterMessage, error) {
+	avps, err := b.AVPs()
+	if err != nil {
+		return nil, err
+	}
+	opts := append(b.opts[:len(b.opts):len(b.opts)], WithAVPs(avps...))
+	return NewRequest(b.code, opts...)
+}
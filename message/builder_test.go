@@ -0,0 +1,99 @@
+package message
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMustNewAVP(t *testing.T) {
+	avp := MustNewAVP(AVP_ORIGIN_HOST, "client.example.com", MANDATORY_FLAG)
+	if host, err := avp.Str(); err != nil || host != "client.example.com" {
+		t.Errorf("MustNewAVP built %v", avp)
+	}
+	defer func() {
+		err, _ := recover().(error)
+		if !errors.Is(err, VendorIDRequiredError) {
+			t.Errorf("MustNewAVP panicked with %v, want VendorIDRequiredError", err)
+		}
+	}()
+	MustNewAVP(AVP_ORIGIN_HOST, "client.example.com", VENDOR_FLAG)
+	t.Error("MustNewAVP of an invalid AVP did not panic")
+}
+
+// TestMessageBuilderErrors checks that the errors of the AVPs that cannot
+// be built are all reported, in order, and that the others do not make a
+// message.
+func TestMessageBuilderErrors(t *testing.T) {
+	b := NewMessageBuilder(COMMAND_CODE_CREDIT_CONTROL).
+		Add(AVP_SESSION_ID, "client.example.com;1;1", MANDATORY_FLAG).
+		Add(AVP_ORIGIN_HOST, "client.example.com", VENDOR_FLAG).
+		Add(AVP_ORIGIN_REALM, "example.com", MANDATORY_FLAG).
+		Add(AVP_ORIGIN_STATE_ID, struct{}{}, MANDATORY_FLAG).
+		AddAVPs(nil, InvalidAVPLengthError)
+	msg, err := b.Build()
+	if msg != nil || err == nil {
+		t.Fatalf("Build = %v, %v; want the errors", msg, err)
+	}
+	errs := err.(interface{ Unwrap() []error }).Unwrap()
+	var codes []uint32
+	for _, e := range errs {
+		var avpErr *AVPError
+		if errors.As(e, &avpErr) {
+			codes = append(codes, avpErr.Code)
+		}
+	}
+	if len(errs) != 3 || len(codes) != 2 || codes[0] != AVP_ORIGIN_HOST || codes[1] != AVP_ORIGIN_STATE_ID || errs[2] != InvalidAVPLengthError {
+		t.Errorf("Build reported %v, want Origin-Host, Origin-State-Id and the error added, in order", errs)
+	}
+	if !errors.Is(err, VendorIDRequiredError) {
+		t.Errorf("Build error %v does not unwrap to the cause", err)
+	}
+	if avps, err := b.AVPs(); avps != nil || err == nil {
+		t.Errorf("AVPs = %v, %v; want the errors", avps, err)
+	}
+}
+
+// TestMessageBuilderEqualsVerbose checks that a built message is the one
+// built AVP by AVP.
+func TestMessageBuilderEqualsVerbose(t *testing.T) {
+	newAVP := func(avp *AVP, err error) *AVP {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return avp
+	}
+	verbose, err := NewRequest(COMMAND_CODE_CREDIT_CONTROL,
+		WithApplication(APPLICATION_ID_CREDIT_CONTROL),
+		WithAVPs(
+			newAVP(NewAVP(AVP_SESSION_ID, "client.example.com;1;1", MANDATORY_FLAG)),
+			newAVP(NewAVP(AVP_ORIGIN_HOST, "client.example.com", MANDATORY_FLAG)),
+			newAVP(NewAVP(AVP_ORIGIN_REALM, "example.com", MANDATORY_FLAG)),
+			newAVP(NewAVP(AVP_AUTH_APPLICATION_ID, APPLICATION_ID_CREDIT_CONTROL, MANDATORY_FLAG)),
+			newAVP(NewAVP(AVP_CC_REQUEST_TYPE, INITIAL_REQUEST, MANDATORY_FLAG)),
+		))
+	if err != nil {
+		t.Fatal(err)
+	}
+	built, err := NewMessageBuilder(COMMAND_CODE_CREDIT_CONTROL, WithApplication(APPLICATION_ID_CREDIT_CONTROL)).
+		Add(AVP_SESSION_ID, "client.example.com;1;1", MANDATORY_FLAG).
+		AddAVP(MustNewAVP(AVP_ORIGIN_HOST, "client.example.com", MANDATORY_FLAG)).
+		AddAVPs([]*AVP{MustNewAVP(AVP_ORIGIN_REALM, "example.com", MANDATORY_FLAG)}, nil).
+		Add(AVP_AUTH_APPLICATION_ID, APPLICATION_ID_CREDIT_CONTROL, MANDATORY_FLAG).
+		Add(AVP_CC_REQUEST_TYPE, INITIAL_REQUEST, MANDATORY_FLAG).
+		Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if !Equal(built, verbose, IgnoreIDs()) {
+		t.Errorf("built message differs:\n%s", Diff(built, verbose, IgnoreIDs()))
+	}
+
+	// The zero value builds AVPs.
+	var b MessageBuilder
+	avps, err := b.Add(AVP_ORIGIN_HOST, "client.example.com", MANDATORY_FLAG).AVPs()
+	if err != nil || len(avps) != 1 || !bytes.Equal(encodeAVP(t, avps[0]), encodeAVP(t, verbose.GetAVP(AVP_ORIGIN_HOST))) {
+		t.Errorf("AVPs = %v, %v", avps, err)
+	}
+}
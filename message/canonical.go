@@ -0,0 +1,71 @@
+// Canonical encoding
+package message
+
+import "sort"
+
+// avpFlagsMask covers the AVP flags defined by RFC 6733; the other bits
+// are reserved.
+const avpFlagsMask = VENDOR_FLAG | MANDATORY_FLAG | PROTECTED_FLAG
+
+// commandFlagsMask covers the command flags defined by RFC 6733.
+const commandFlagsMask = FlagRequest | FlagProxiable | FlagError | FlagRetransmitted
+
+// canonicalFlags returns the flags of a with the reserved bits cleared,
+// the registered default flags when a has none but the vendor flag its
+// Vendor-ID requires, and the vendor flag set exactly when a has a
+// Vendor-ID.
+func canonicalFlags(a *AVP) uint8 {
+	flags := a.Flags & avpFlagsMask
+	if flags&^VENDOR_FLAG == 0 {
+		flags, _ = LookupAVPFlags(a.Code, a.VendorID)
+	}
+	if a.VendorID != 0 {
+		return flags | VENDOR_FLAG
+	}
+	return flags &^ VENDOR_FLAG
+}
+
+// canonicalData encodes data, encoding the members of a Grouped value
+// canonically and in canonical order.
+func canonicalData(data AVPData) ([]byte, error) {
+	g, ok := data.(*Grouped)
+	if !ok {
+		return data.Encode()
+	}
+	var buffer []byte
+	for _, avp := range canonicalOrder(g.AVPs, nil) {
+		encoded, err := avp.encode(true)
+		if err != nil {
+			return nil, err
+		}
+		buffer = append(buffer, encoded...)
+	}
+	return buffer, nil
+}
+
+// canonicalOrder returns a copy of avps with the AVPs of fixed first, in
+// that order, and the others sorted by vendor and code. Repeated AVPs
+// keep their relative order, which may be significant, as for
+// Route-Record.
+func canonicalOrder(avps []*AVP, fixed []uint32) []*AVP {
+	rank := func(avp *AVP) int {
+		for i, code := range fixed {
+			if avp.Code == code {
+				return i
+			}
+		}
+		return len(fixed)
+	}
+	sorted := append([]*AVP(nil), avps...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if ra, rb := rank(a), rank(b); ra != rb {
+			return ra < rb
+		}
+		if a.VendorID != b.VendorID {
+			return a.VendorID < b.VendorID
+		}
+		return a.Code < b.Code
+	})
+	return sorted
+}
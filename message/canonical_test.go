@@ -0,0 +1,159 @@
+package message
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+// canonicalPair builds the same CCR twice: once with every flag set as
+// the dictionary has it and the AVPs in one order, once without flags but
+// the vendor flag, with reserved bits set and the AVPs, and Grouped
+// members, in another.
+func canonicalPair(t *testing.T) (*DiameterMessage, *DiameterMessage) {
+	t.Helper()
+	qci, ul := uint32(9), uint32(1_000_000)
+	build := func(flags uint8, reserved uint8, reversed bool) *DiameterMessage {
+		vendorFlags := flags | VENDOR_FLAG
+		members := []*AVP{
+			MustNewAVP(AVP_QOS_CLASS_IDENTIFIER, qci, vendorFlags, VENDOR_3GPP),
+			MustNewAVP(AVP_MAX_REQUESTED_BANDWIDTH_UL, ul, vendorFlags|reserved, VENDOR_3GPP),
+		}
+		avps := []*AVP{
+			MustNewAVP(AVP_SESSION_ID, "client.example.com;1;1", flags),
+			MustNewAVP(AVP_ORIGIN_HOST, "client.example.com", flags|reserved),
+			MustNewAVP(AVP_ORIGIN_REALM, "example.com", flags),
+			MustNewAVP(AVP_DESTINATION_REALM, "example.com", flags),
+			MustNewAVP(AVP_AUTH_APPLICATION_ID, APPLICATION_ID_CREDIT_CONTROL, flags),
+			MustNewAVP(AVP_ROUTE_RECORD, "a.example.com", flags),
+			MustNewAVP(AVP_ROUTE_RECORD, "b.example.com", flags),
+		}
+		if reversed {
+			slices.Reverse(members)
+			// Session-Id stays first, which the default encoding keeps
+			// anyway; the Route-Records keep their order.
+			slices.Reverse(avps[1:5])
+		}
+		qos, err := NewGroupedAVP(AVP_QOS_INFORMATION, vendorFlags, VENDOR_3GPP, members...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reversed {
+			avps = slices.Insert(avps, 1, qos)
+		} else {
+			avps = append(avps, qos)
+		}
+		msg, err := NewRequest(COMMAND_CODE_CREDIT_CONTROL, WithAVPs(avps...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Header.HopByHopID, msg.Header.EndToEndID = 1, 2
+		msg.Header.CommandFlags |= CommandFlags(reserved)
+		return msg
+	}
+	return build(MANDATORY_FLAG, 0, false), build(0, 0x01, true)
+}
+
+func encode(t *testing.T, msg *DiameterMessage, opts ...EncodeOption) []byte {
+	t.Helper()
+	data, err := EncodeMessage(msg, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCanonicalEncoding(t *testing.T) {
+	a, b := canonicalPair(t)
+	if bytes.Equal(encode(t, a), encode(t, b)) {
+		t.Fatal("the two builds encode the same by default")
+	}
+	canonical := encode(t, a, WithCanonical())
+	if got := encode(t, b, WithCanonical()); !bytes.Equal(got, canonical) {
+		t.Fatalf("canonical encodings differ:\n%x\n%x", canonical, got)
+	}
+	decoded, err := DecodeMessage(canonical)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Header.CommandFlags != a.Header.CommandFlags {
+		t.Errorf("command flags %s, want the reserved bit cleared", decoded.Header.CommandFlags)
+	}
+	want := []uint32{AVP_SESSION_ID, AVP_AUTH_APPLICATION_ID, AVP_ORIGIN_HOST, AVP_ROUTE_RECORD, AVP_ROUTE_RECORD,
+		AVP_DESTINATION_REALM, AVP_ORIGIN_REALM, AVP_QOS_INFORMATION}
+	if got := codes(decoded.AVPs); !slices.Equal(got, want) {
+		t.Errorf("canonical order %v, want %v", got, want)
+	}
+	if got := RouteRecords(decoded); !slices.Equal(got, []string{"a.example.com", "b.example.com"}) {
+		t.Errorf("Route-Records reordered to %v", got)
+	}
+	qos := group(t, decoded.GetAVP(AVP_QOS_INFORMATION))
+	if got := codes(qos.AVPs); !slices.Equal(got, []uint32{AVP_MAX_REQUESTED_BANDWIDTH_UL, AVP_QOS_CLASS_IDENTIFIER}) {
+		t.Errorf("canonical member order %v", got)
+	}
+	for path, avp := range decoded.Walk() {
+		want := uint8(MANDATORY_FLAG)
+		if avp.VendorID != 0 {
+			want |= VENDOR_FLAG
+		}
+		if avp.Flags != want {
+			t.Errorf("AVP %v has flags %#x, want %#x", path, avp.Flags, want)
+		}
+	}
+	if !Equal(a, decoded, IgnoreAVPOrder()) {
+		t.Errorf("canonical encoding changed the content:\n%s", Diff(a, decoded, IgnoreAVPOrder()))
+	}
+	if again := encode(t, decoded, WithCanonical()); !bytes.Equal(again, canonical) {
+		t.Error("canonical encoding of a canonical message differs")
+	}
+}
+
+// TestCanonicalFlags checks the flags canonical encoding gives single
+// AVPs.
+func TestCanonicalFlags(t *testing.T) {
+	unknown, err := DecodeAVP(rawAVP(4000, 0, 12, []byte{0, 0, 0, 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		avp  *AVP
+		want uint8
+	}{
+		{"as set", MustNewAVP(AVP_USER_NAME, "bob", MANDATORY_FLAG|PROTECTED_FLAG), MANDATORY_FLAG | PROTECTED_FLAG},
+		{"reserved bits", MustNewAVP(AVP_USER_NAME, "bob", MANDATORY_FLAG|0x1f), MANDATORY_FLAG},
+		{"reserved bits alone", MustNewAVP(AVP_USER_NAME, "bob", 0x1f), MANDATORY_FLAG},
+		{"registered default", MustNewAVP(AVP_USER_NAME, "bob", 0), MANDATORY_FLAG},
+		{"registered without M", MustNewAVP(AVP_LOAD_VALUE, uint64(1), 0), 0},
+		{"3GPP default", MustNewAVP(AVP_QOS_CLASS_IDENTIFIER, uint32(1), VENDOR_FLAG, VENDOR_3GPP), VENDOR_FLAG | MANDATORY_FLAG},
+		{"3GPP default without M", MustNewAVP(AVP_PRIORITY_LEVEL, uint32(1), VENDOR_FLAG, VENDOR_3GPP), VENDOR_FLAG},
+		{"V bit without a vendor", func() *AVP {
+			avp := MustNewAVP(AVP_USER_NAME, "bob", MANDATORY_FLAG)
+			avp.Flags |= VENDOR_FLAG
+			return avp
+		}(), MANDATORY_FLAG},
+		{"unregistered", unknown, 0},
+	} {
+		if got := canonicalFlags(tc.avp); got != tc.want {
+			t.Errorf("%s: flags %#x, want %#x", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestCanonicalPadding checks that an AVP decoded with non-zero padding
+// is encoded with zero padding in both forms.
+func TestCanonicalPadding(t *testing.T) {
+	clean := fixture(t, true, rawAVP(AVP_USER_NAME, MANDATORY_FLAG, 11, []byte("bob")))
+	padded := slices.Clone(clean)
+	padded[len(padded)-1] = 0xff
+	msg, err := DecodeMessage(padded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := encode(t, msg, WithKeepOrder()); !bytes.Equal(got, clean) {
+		t.Errorf("default encoding\n%x\nwant\n%x", got, clean)
+	}
+	if got := encode(t, msg, WithCanonical()); got[len(got)-1] != 0 {
+		t.Errorf("canonical encoding padded with %#x", got[len(got)-1])
+	}
+}
@@ -0,0 +1,205 @@
+// Peer capabilities advertised in CER/CEA
+package message
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+)
+
+// Capabilities is what a CER or CEA offers for negotiation: the
+// applications and the Supported-Vendor-Ids. Client and server negotiate
+// by intersecting their own Capabilities with the offer of the peer.
+type Capabilities struct {
+	Applications
+	SupportedVendorIDs []uint32
+}
+
+// ParseOffer collects the negotiable capabilities of a CER or CEA.
+// Repeated AVPs are merged. It returns InvalidCommandCodeError for other
+// commands, and an AVPError for every invalid
+// Vendor-Specific-Application-Id, which is left out of the capabilities
+// returned alongside.
+func ParseOffer(msg *DiameterMessage) (Capabilities, error) {
+	if msg.Header.CommandCode != COMMAND_CODE_CAPABILITIES_EXCHANGE {
+		return Capabilities{}, InvalidCommandCodeError
+	}
+	return parseOffer(msg)
+}
+
+func parseOffer(msg *DiameterMessage) (Capabilities, error) {
+	offer := Capabilities{
+		Applications: Applications{
+			Auth: make(ApplicationSet),
+			Acct: make(ApplicationSet),
+		},
+	}
+	var errs []error
+	for _, avp := range msg.AVPs {
+		switch avp.Code {
+		case AVP_AUTH_APPLICATION_ID:
+			if id, err := avp.Uint32(); err == nil {
+				offer.Auth[id] = struct{}{}
+			}
+		case AVP_ACCT_APPLICATION_ID:
+			if id, err := avp.Uint32(); err == nil {
+				offer.Acct[id] = struct{}{}
+			}
+		case AVP_SUPPORTED_VENDOR_ID:
+			if id, err := avp.Uint32(); err == nil && !slices.Contains(offer.SupportedVendorIDs, id) {
+				offer.SupportedVendorIDs = append(offer.SupportedVendorIDs, id)
+			}
+		case AVP_VENDOR_SPECIFIC_APPLICATION_ID:
+			group, err := avp.Group()
+			if err != nil {
+				continue
+			}
+			v, err := parseVendorApplication(group)
+			if err != nil {
+				errs = append(errs, &AVPError{Code: avp.Code, Vendor: avp.VendorID, Reason: err})
+				continue
+			}
+			if !slices.Contains(offer.VendorSpecific, v) {
+				offer.VendorSpecific = append(offer.VendorSpecific, v)
+			}
+		}
+	}
+	return offer, errors.Join(errs...)
+}
+
+// Intersect returns the capabilities shared by c and other: the
+// intersection of their applications, see Applications.Intersect, and the
+// Supported-Vendor-Ids of c also supported by other.
+func (c Capabilities) Intersect(other Capabilities) Capabilities {
+	result := Capabilities{Applications: c.Applications.Intersect(other.Applications)}
+	for _, id := range c.SupportedVendorIDs {
+		if slices.Contains(other.SupportedVendorIDs, id) {
+			result.SupportedVendorIDs = append(result.SupportedVendorIDs, id)
+		}
+	}
+	return result
+}
+
+// IsEmpty reports whether no application is shared. Supported-Vendor-Ids
+// alone do not make a usable peer.
+func (c Capabilities) IsEmpty() bool {
+	return c.Applications.IsEmpty()
+}
+
+// PeerCapabilities describes a peer as advertised in its CER or CEA,
+// beyond the applications it supports. Optional AVPs that are absent
+// leave their field at the zero value.
+type PeerCapabilities struct {
+	Identity           PeerIdentity
+	HostIPAddresses    []net.IP
+	VendorID           uint32
+	ProductName        string
+	FirmwareRevision   uint32
+	OriginStateID      uint32
+	SupportedVendorIDs []uint32
+}
+
+// ParseCapabilities extracts the capabilities from a CER or CEA.
+func ParseCapabilities(msg *DiameterMessage) PeerCapabilities {
+	var caps PeerCapabilities
+	caps.Identity, _ = OriginIdentity(msg)
+	for _, avp := range msg.AVPs {
+		switch avp.Code {
+		case AVP_HOST_IP_ADDRESS:
+			if ip, err := avp.IP(); err == nil {
+				caps.HostIPAddresses = append(caps.HostIPAddresses, ip)
+			}
+		case AVP_VENDOR_ID:
+			caps.VendorID = avpUint32(avp)
+		case AVP_PRODUCT_NAME:
+			caps.ProductName, _ = avp.Str()
+		case AVP_FIRMWARE_REVISION:
+			caps.FirmwareRevision = avpUint32(avp)
+		case AVP_ORIGIN_STATE_ID:
+			caps.OriginStateID = avpUint32(avp)
+		case AVP_SUPPORTED_VENDOR_ID:
+			caps.SupportedVendorIDs = append(caps.SupportedVendorIDs, avpUint32(avp))
+		}
+	}
+	return caps
+}
+
+// PeerSoftwareInfo describes the software a peer runs, as advertised in
+// its CER or CEA, for inventories of the peers of a node.
+type PeerSoftwareInfo struct {
+	VendorID uint32
+	// VendorName is the registered name of VendorID, see
+	// RegisterVendorName, and empty for unregistered vendors.
+	VendorName         string
+	ProductName        string
+	FirmwareRevision   uint32
+	SupportedVendorIDs []uint32
+}
+
+// ParsePeerSoftware extracts the software description from a CER or CEA.
+func ParsePeerSoftware(msg *DiameterMessage) PeerSoftwareInfo {
+	return ParseCapabilities(msg).Software()
+}
+
+// Software returns the part of c describing the software of the peer.
+func (c PeerCapabilities) Software() PeerSoftwareInfo {
+	name, _ := LookupVendorName(c.VendorID)
+	return PeerSoftwareInfo{
+		VendorID:           c.VendorID,
+		VendorName:         name,
+		ProductName:        c.ProductName,
+		FirmwareRevision:   c.FirmwareRevision,
+		SupportedVendorIDs: slices.Clone(c.SupportedVendorIDs),
+	}
+}
+
+func (s PeerSoftwareInfo) String() string {
+	vendor := fmt.Sprint(s.VendorID)
+	if s.VendorName != "" {
+		vendor = fmt.Sprintf("%s (%d)", s.VendorName, s.VendorID)
+	}
+	str := fmt.Sprintf("%q from vendor %s", s.ProductName, vendor)
+	if s.FirmwareRevision != 0 {
+		str += fmt.Sprintf(", firmware revision %d", s.FirmwareRevision)
+	}
+	return str
+}
+
+// CEA is the content of a Capabilities-Exchange-Answer.
+type CEA struct {
+	ResultCode   ResultCode
+	ErrorMessage string
+	Peer         PeerCapabilities
+}
+
+// ParseCEA reads a CEA. It checks with CheckAnswer that msg is an answer
+// to a CER and fails if the Result-Code is missing or not
+// DIAMETER_SUCCESS, or the origin identity is incomplete. The CEA parsed
+// so far is returned with the error of an unsuccessful Result-Code.
+func ParseCEA(msg *DiameterMessage) (CEA, error) {
+	if err := CheckAnswer(msg, COMMAND_CODE_CER); err != nil {
+		return CEA{}, err
+	}
+	var cea CEA
+	var err error
+	if cea.ResultCode, _, err = GetResultCode(msg); err != nil {
+		return CEA{}, err
+	}
+	if _, err := OriginIdentity(msg); err != nil {
+		return CEA{}, err
+	}
+	cea.ErrorMessage, _ = msg.GetAVP(AVP_ERROR_MESSAGE).Str()
+	cea.Peer = ParseCapabilities(msg)
+	if cea.ResultCode != DIAMETER_SUCCESS {
+		return cea, fmt.Errorf("CEA failed with Result-Code: %d (%s)", cea.ResultCode, cea.ResultCode)
+	}
+	return cea, nil
+}
+
+// avpUint32 returns the value of an AVP holding a 32-bit unsigned value,
+// or 0 for other types.
+func avpUint32(avp *AVP) uint32 {
+	v, _ := avp.Uint32()
+	return v
+}
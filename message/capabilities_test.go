@@ -0,0 +1,326 @@
+package message
+
+import (
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// huaweiCEA returns the CEA of testdata/huawei-cea.hex, as sent by a
+// Huawei charging gateway: besides the capabilities it carries a
+// proprietary AVP with the 'M' bit set that no dictionary here knows.
+func huaweiCEA(t *testing.T) []byte {
+	t.Helper()
+	return hexFixture(t, "huawei-cea.hex")
+}
+
+// hexFixture returns the frame written in hexadecimal in testdata/name.
+func hexFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	text, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := hex.DecodeString(strings.Join(strings.Fields(string(text)), ""))
+	if err != nil {
+		t.Fatalf("decoding the fixture: %v", err)
+	}
+	return frame
+}
+
+func TestParseCapabilitiesHuaweiCEA(t *testing.T) {
+	frame := huaweiCEA(t)
+	if _, err := DecodeMessage(frame, WithDecodeOptions(StrictDecodeOptions())); !errors.Is(err, UnknownMandatoryAVPError) {
+		t.Errorf("strict decoding: error %v, want UnknownMandatoryAVPError", err)
+	}
+	cea, err := DecodeMessage(frame, WithDecodeOptions(LenientDecodeOptions()))
+	if err != nil {
+		t.Fatalf("tolerant decoding: %v", err)
+	}
+	if cea.Header.CommandCode != COMMAND_CODE_CER || cea.IsRequest() {
+		t.Fatalf("decoded %s, want CEA", cea.CommandName())
+	}
+
+	caps := ParseCapabilities(cea)
+	if want := NewPeerIdentity("ocs01.huawei.example.net", "huawei.example.net"); caps.Identity != want {
+		t.Errorf("Identity %v, want %v", caps.Identity, want)
+	}
+	wantIPs := []net.IP{net.ParseIP("192.0.2.10"), net.ParseIP("2001:db8::10")}
+	if !slices.EqualFunc(caps.HostIPAddresses, wantIPs, net.IP.Equal) {
+		t.Errorf("HostIPAddresses %v, want %v", caps.HostIPAddresses, wantIPs)
+	}
+	if caps.VendorID != 2011 {
+		t.Errorf("VendorID %d, want 2011", caps.VendorID)
+	}
+	if caps.ProductName != "HUAWEI CG" {
+		t.Errorf("ProductName %q, want \"HUAWEI CG\"", caps.ProductName)
+	}
+	if caps.FirmwareRevision != 800 {
+		t.Errorf("FirmwareRevision %d, want 800", caps.FirmwareRevision)
+	}
+	if caps.OriginStateID != 1698765432 {
+		t.Errorf("OriginStateID %d, want 1698765432", caps.OriginStateID)
+	}
+	if want := []uint32{10415, 2011}; !slices.Equal(caps.SupportedVendorIDs, want) {
+		t.Errorf("SupportedVendorIDs %v, want %v", caps.SupportedVendorIDs, want)
+	}
+}
+
+// TestParsePeerSoftware parses the software of the peers of three
+// different Diameter stacks from their CER or CEA.
+func TestParsePeerSoftware(t *testing.T) {
+	for _, tc := range []struct {
+		fixture string
+		want    PeerSoftwareInfo
+		str     string
+	}{
+		{
+			fixture: "huawei-cea.hex",
+			want:    PeerSoftwareInfo{VendorID: 2011, VendorName: "Huawei", ProductName: "HUAWEI CG", FirmwareRevision: 800, SupportedVendorIDs: []uint32{10415, 2011}},
+			str:     `"HUAWEI CG" from vendor Huawei (2011), firmware revision 800`,
+		},
+		{
+			fixture: "ericsson-cer.hex",
+			want:    PeerSoftwareInfo{VendorID: 193, VendorName: "Ericsson", ProductName: "Ericsson SAPC", FirmwareRevision: 1, SupportedVendorIDs: []uint32{10415, 193}},
+			str:     `"Ericsson SAPC" from vendor Ericsson (193), firmware revision 1`,
+		},
+		{
+			// Vendor-Id 0 is not a registered vendor.
+			fixture: "freediameter-cea.hex",
+			want:    PeerSoftwareInfo{ProductName: "freeDiameter", FirmwareRevision: 10500},
+			str:     `"freeDiameter" from vendor 0, firmware revision 10500`,
+		},
+	} {
+		t.Run(tc.fixture, func(t *testing.T) {
+			msg, err := DecodeMessage(hexFixture(t, tc.fixture), WithDecodeOptions(LenientDecodeOptions()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ParsePeerSoftware(msg)
+			if got.VendorID != tc.want.VendorID || got.VendorName != tc.want.VendorName || got.ProductName != tc.want.ProductName ||
+				got.FirmwareRevision != tc.want.FirmwareRevision || !slices.Equal(got.SupportedVendorIDs, tc.want.SupportedVendorIDs) {
+				t.Errorf("ParsePeerSoftware = %+v, want %+v", got, tc.want)
+			}
+			if s := got.String(); s != tc.str {
+				t.Errorf("String() = %q, want %q", s, tc.str)
+			}
+		})
+	}
+
+	// The software does not share the vendors of the capabilities.
+	cea, err := DecodeMessage(huaweiCEA(t), WithDecodeOptions(LenientDecodeOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	caps := ParseCapabilities(cea)
+	caps.Software().SupportedVendorIDs[0] = 0
+	if caps.SupportedVendorIDs[0] != 10415 {
+		t.Error("changing the software changed the capabilities")
+	}
+}
+
+func TestParseCapabilitiesOptionalAbsent(t *testing.T) {
+	cea, err := NewRequest(COMMAND_CODE_CER, WithAVPs(
+		MustNewAVP(AVP_ORIGIN_HOST, "peer.example.com", MANDATORY_FLAG),
+		MustNewAVP(AVP_ORIGIN_REALM, "example.com", MANDATORY_FLAG),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	caps := ParseCapabilities(cea)
+	if caps.Identity != NewPeerIdentity("peer.example.com", "example.com") {
+		t.Errorf("Identity %v", caps.Identity)
+	}
+	if caps.HostIPAddresses != nil || caps.VendorID != 0 || caps.ProductName != "" || caps.FirmwareRevision != 0 || caps.SupportedVendorIDs != nil {
+		t.Errorf("absent AVPs parsed as %+v", caps)
+	}
+}
+
+func TestParseOffer(t *testing.T) {
+	const gx, rx = uint32(16777238), uint32(16777236)
+	vsai := func(avps ...*AVP) *AVP {
+		avp, err := NewGroupedAVP(AVP_VENDOR_SPECIFIC_APPLICATION_ID, MANDATORY_FLAG, 0, avps...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return avp
+	}
+	vendor := MustNewAVP(AVP_VENDOR_ID, uint32(VENDOR_3GPP), MANDATORY_FLAG)
+	cer, err := NewRequest(COMMAND_CODE_CER, WithAVPs(
+		MustNewAVP(AVP_ORIGIN_HOST, "peer.example.com", MANDATORY_FLAG),
+		MustNewAVP(AVP_ORIGIN_REALM, "example.com", MANDATORY_FLAG),
+		MustNewAVP(AVP_AUTH_APPLICATION_ID, APPLICATION_ID_CREDIT_CONTROL, MANDATORY_FLAG),
+		MustNewAVP(AVP_AUTH_APPLICATION_ID, APPLICATION_ID_CREDIT_CONTROL, MANDATORY_FLAG),
+		MustNewAVP(AVP_ACCT_APPLICATION_ID, APPLICATION_ID_BASE_ACCOUNTING, MANDATORY_FLAG),
+		MustNewAVP(AVP_SUPPORTED_VENDOR_ID, uint32(VENDOR_3GPP), MANDATORY_FLAG),
+		MustNewAVP(AVP_SUPPORTED_VENDOR_ID, uint32(VENDOR_3GPP), MANDATORY_FLAG),
+		vsai(vendor, MustNewAVP(AVP_AUTH_APPLICATION_ID, gx, MANDATORY_FLAG)),
+		vsai(vendor, MustNewAVP(AVP_AUTH_APPLICATION_ID, gx, MANDATORY_FLAG)),
+		// Both an Auth- and an Acct-Application-Id.
+		vsai(vendor, MustNewAVP(AVP_AUTH_APPLICATION_ID, rx, MANDATORY_FLAG), MustNewAVP(AVP_ACCT_APPLICATION_ID, rx, MANDATORY_FLAG)),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offer, err := ParseOffer(cer)
+	var aerr *AVPError
+	if !errors.As(err, &aerr) || aerr.Code != AVP_VENDOR_SPECIFIC_APPLICATION_ID || !errors.Is(err, InvalidVendorApplicationError) {
+		t.Errorf("error %v, want an AVPError for the invalid Vendor-Specific-Application-Id", err)
+	}
+	if !slices.Equal(offer.Auth.IDs(), []uint32{APPLICATION_ID_CREDIT_CONTROL}) || !slices.Equal(offer.Acct.IDs(), []uint32{APPLICATION_ID_BASE_ACCOUNTING}) {
+		t.Errorf("applications %v and %v", offer.Auth.IDs(), offer.Acct.IDs())
+	}
+	if want := []VendorApplication{{VendorID: VENDOR_3GPP, ApplicationID: gx}}; !slices.Equal(offer.VendorSpecific, want) {
+		t.Errorf("vendor-specific %v, want %v", offer.VendorSpecific, want)
+	}
+	if want := []uint32{VENDOR_3GPP}; !slices.Equal(offer.SupportedVendorIDs, want) {
+		t.Errorf("Supported-Vendor-Ids %v, want %v", offer.SupportedVendorIDs, want)
+	}
+
+	dwr, err := NewRequest(COMMAND_CODE_DWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseOffer(dwr); !errors.Is(err, InvalidCommandCodeError) {
+		t.Errorf("ParseOffer(DWR) = %v, want InvalidCommandCodeError", err)
+	}
+}
+
+func TestCapabilitiesIntersect(t *testing.T) {
+	const gx = uint32(16777238)
+	local := Capabilities{
+		Applications: Applications{
+			Auth:           NewApplicationSet(APPLICATION_ID_CREDIT_CONTROL),
+			Acct:           NewApplicationSet(APPLICATION_ID_BASE_ACCOUNTING),
+			VendorSpecific: []VendorApplication{{VendorID: VENDOR_3GPP, ApplicationID: gx}},
+		},
+		SupportedVendorIDs: []uint32{VENDOR_3GPP, 5535},
+	}
+	for _, tc := range []struct {
+		name       string
+		peer       Capabilities
+		auth, acct []uint32
+		vendor     []VendorApplication
+		vendors    []uint32
+	}{
+		{
+			name: "everything",
+			peer: local,
+			auth: []uint32{APPLICATION_ID_CREDIT_CONTROL}, acct: []uint32{APPLICATION_ID_BASE_ACCOUNTING},
+			vendor: local.VendorSpecific, vendors: local.SupportedVendorIDs,
+		},
+		{
+			name:   "vendor-specific only",
+			peer:   Capabilities{Applications: Applications{VendorSpecific: local.VendorSpecific}, SupportedVendorIDs: []uint32{VENDOR_3GPP}},
+			vendor: local.VendorSpecific, vendors: []uint32{VENDOR_3GPP},
+		},
+		{
+			name:   "vendor-specific offered as a plain application",
+			peer:   Capabilities{Applications: Applications{Auth: NewApplicationSet(gx)}},
+			vendor: local.VendorSpecific,
+		},
+		{
+			name:   "relay",
+			peer:   Capabilities{Applications: Applications{Auth: NewApplicationSet(APPLICATION_ID_RELAY)}},
+			auth:   []uint32{APPLICATION_ID_CREDIT_CONTROL},
+			vendor: local.VendorSpecific,
+		},
+		{
+			name:    "vendors only",
+			peer:    Capabilities{Applications: Applications{Auth: NewApplicationSet(APPLICATION_ID_BASE_ACCOUNTING)}, SupportedVendorIDs: []uint32{5535, 10}},
+			vendors: []uint32{5535},
+		},
+		{name: "nothing", peer: Capabilities{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := local.Intersect(tc.peer)
+			if !slices.Equal(got.Auth.IDs(), tc.auth) || !slices.Equal(got.Acct.IDs(), tc.acct) {
+				t.Errorf("applications %v and %v, want %v and %v", got.Auth.IDs(), got.Acct.IDs(), tc.auth, tc.acct)
+			}
+			if !slices.Equal(got.VendorSpecific, tc.vendor) {
+				t.Errorf("vendor-specific %v, want %v", got.VendorSpecific, tc.vendor)
+			}
+			if !slices.Equal(got.SupportedVendorIDs, tc.vendors) {
+				t.Errorf("Supported-Vendor-Ids %v, want %v", got.SupportedVendorIDs, tc.vendors)
+			}
+			// Shared vendors alone do not make a usable peer.
+			if empty := tc.auth == nil && tc.acct == nil && tc.vendor == nil; got.IsEmpty() != empty {
+				t.Errorf("IsEmpty() = %t, want %t", got.IsEmpty(), empty)
+			}
+		})
+	}
+}
+
+func TestParseCEA(t *testing.T) {
+	node := Node{OriginHost: "server.example.com", OriginRealm: "example.com", ProductName: "test"}
+	apps := Applications{Auth: NewApplicationSet(APPLICATION_ID_CREDIT_CONTROL)}
+	cer, err := node.BuildCER(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	build := func(code ResultCode, extra ...*AVP) *DiameterMessage {
+		t.Helper()
+		cea, err := node.BuildCEA(cer, apps, code, extra...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cea
+	}
+	dwr, err := node.BuildDWR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dwa, err := node.BuildDWA(dwr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cea, err := ParseCEA(roundTrip(t, build(DIAMETER_SUCCESS)))
+	if err != nil {
+		t.Fatalf("ParseCEA: %v", err)
+	}
+	if cea.ResultCode != DIAMETER_SUCCESS || cea.Peer.Identity != NewPeerIdentity("server.example.com", "example.com") || cea.Peer.ProductName != "test" {
+		t.Errorf("parsed %+v", cea)
+	}
+
+	failed := build(DIAMETER_NO_COMMON_APPLICATION, MustNewAVP(AVP_ERROR_MESSAGE, "no Gx", 0))
+	if cea, err := ParseCEA(failed); err == nil || cea.ResultCode != DIAMETER_NO_COMMON_APPLICATION || cea.ErrorMessage != "no Gx" {
+		t.Errorf("failed CEA parsed as %+v, %v", cea, err)
+	}
+
+	// A CER looped back by the peer has the command code of a CEA but the
+	// 'R' bit set.
+	_, err = ParseCEA(roundTrip(t, cer))
+	if !errors.Is(err, UnexpectedRequestError) {
+		t.Errorf("looped back CER: %v, want UnexpectedRequestError", err)
+	}
+	if code := ResultCodeForError(err); code != DIAMETER_INVALID_HDR_BITS {
+		t.Errorf("looped back CER maps to %s, want DIAMETER_INVALID_HDR_BITS", code)
+	}
+	if _, err := ParseCEA(dwa); !errors.Is(err, InvalidCommandCodeError) {
+		t.Errorf("DWA: %v, want InvalidCommandCodeError", err)
+	}
+	noOrigin := build(DIAMETER_SUCCESS)
+	noOrigin.AVPs = slices.DeleteFunc(noOrigin.AVPs, func(avp *AVP) bool { return avp.Code == AVP_ORIGIN_HOST })
+	if _, err := ParseCEA(noOrigin); !errors.Is(err, MissingOriginHostError) {
+		t.Errorf("CEA without Origin-Host: %v, want MissingOriginHostError", err)
+	}
+
+	// The deprecated ReadCEA and ValidateSuccessfulResponse refuse the
+	// looped back request too.
+	if _, err := ReadCEA(*cer); !errors.Is(err, UnexpectedRequestError) {
+		t.Errorf("ReadCEA of a CER: %v, want UnexpectedRequestError", err)
+	}
+	if err := ValidateSuccessfulResponse(cer); !errors.Is(err, UnexpectedRequestError) {
+		t.Errorf("ValidateSuccessfulResponse of a CER: %v, want UnexpectedRequestError", err)
+	}
+	if err := ValidateSuccessfulResponse(dwa); err != nil {
+		t.Errorf("ValidateSuccessfulResponse of a DWA: %v", err)
+	}
+}
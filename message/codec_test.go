@@ -0,0 +1,70 @@
+package message
+
+import "testing"
+
+func benchmarkHeader() *DiameterHeader {
+	return &DiameterHeader{
+		Version:       DIAMETER_VERSION,
+		MessageLength: 180,
+		CommandFlags:  FlagRequest,
+		CommandCode:   COMMAND_CODE_CREDIT_CONTROL,
+		ApplicationID: APPLICATION_ID_CREDIT_CONTROL,
+		HopByHopID:    0x1234,
+		EndToEndID:    0xabcd0001,
+	}
+}
+
+func BenchmarkHeaderEncode(b *testing.B) {
+	h := benchmarkHeader()
+	b.ReportAllocs()
+	for range b.N {
+		h.Encode()
+	}
+}
+
+func BenchmarkHeaderDecode(b *testing.B) {
+	data := benchmarkHeader().Encode()
+	var h DiameterHeader
+	b.ReportAllocs()
+	for range b.N {
+		if err := h.Decode(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAVPEncode(b *testing.B) {
+	for _, avp := range []*AVP{
+		MustNewAVP(AVP_RESULT_CODE, uint32(DIAMETER_SUCCESS), MANDATORY_FLAG),
+		MustNewAVP(AVP_ORIGIN_HOST, "server.example.com", MANDATORY_FLAG),
+	} {
+		b.Run(AVPName(avp.Code, avp.VendorID), func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				if _, err := avp.Encode(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAVPDecode(b *testing.B) {
+	for _, avp := range []*AVP{
+		MustNewAVP(AVP_RESULT_CODE, uint32(DIAMETER_SUCCESS), MANDATORY_FLAG),
+		MustNewAVP(AVP_ORIGIN_HOST, "server.example.com", MANDATORY_FLAG),
+	} {
+		data, err := avp.Encode()
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(AVPName(avp.Code, avp.VendorID), func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				if _, err := DecodeAVP(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
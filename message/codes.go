@@ -1,15 +1,22 @@
 // Definitions for common codes (e.g., Result-Code)
 package message
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 type ResultCode uint32
 
 const (
 	DIAMETER_MULTI_ROUND_AUTH ResultCode = 1001
 	DIAMETER_SUCCESS          ResultCode = 2001
-	DIMAETER_LIMITED_SUCCESS  ResultCode = 2002
+	DIAMETER_LIMITED_SUCCESS  ResultCode = 2002
 )
+
+// Deprecated: Use DIAMETER_LIMITED_SUCCESS.
+const DIMAETER_LIMITED_SUCCESS = DIAMETER_LIMITED_SUCCESS
+
 const (
 	DIAMETER_COMMAND_UNSUPPORTED ResultCode = 3001 + iota
 	DIAMETER_UNABLE_TO_DELIVER
@@ -21,6 +28,7 @@ const (
 	DIAMETER_INVALID_HDR_BITS
 	DIAMETER_INVALID_AVP_BITS
 	DIAMETER_UNKNOWN_PEER
+	DIAMETER_REALM_REDIRECT_INDICATION // RFC 7075
 )
 
 const (
@@ -49,9 +57,51 @@ const (
 	DIAMETER_NO_COMMON_SECURITY
 )
 
+// Credit-control result codes (RFC 4006 section 9.1)
+const (
+	DIAMETER_END_USER_SERVICE_DENIED       ResultCode = 4010
+	DIAMETER_CREDIT_CONTROL_NOT_APPLICABLE ResultCode = 4011
+	DIAMETER_CREDIT_LIMIT_REACHED          ResultCode = 4012
+	DIAMETER_USER_UNKNOWN                  ResultCode = 5030
+	DIAMETER_RATING_FAILED                 ResultCode = 5031
+)
+
+// Disconnect-Cause AVP values (RFC 6733 section 5.4.3)
+const (
+	DISCONNECT_CAUSE_REBOOTING                  = uint32(0)
+	DISCONNECT_CAUSE_BUSY                       = uint32(1)
+	DISCONNECT_CAUSE_DO_NOT_WANT_TO_TALK_TO_YOU = uint32(2)
+)
+
+// Auth-Session-State AVP values (RFC 6733 section 8.11)
+const (
+	STATE_MAINTAINED    = uint32(0)
+	NO_STATE_MAINTAINED = uint32(1)
+)
+
+// Termination-Cause AVP values (RFC 6733 section 8.15)
+const (
+	DIAMETER_LOGOUT               = uint32(1)
+	DIAMETER_SERVICE_NOT_PROVIDED = uint32(2)
+	DIAMETER_BAD_ANSWER           = uint32(3)
+	DIAMETER_ADMINISTRATIVE       = uint32(4)
+	DIAMETER_LINK_BROKEN          = uint32(5)
+	DIAMETER_AUTH_EXPIRED         = uint32(6)
+	DIAMETER_USER_MOVED           = uint32(7)
+	DIAMETER_SESSION_TIMEOUT      = uint32(8)
+)
+
+// Accounting-Record-Type AVP values (RFC 6733 section 9.8.1)
+const (
+	EVENT_RECORD   = uint32(1)
+	START_RECORD   = uint32(2)
+	INTERIM_RECORD = uint32(3)
+	STOP_RECORD    = uint32(4)
+)
+
 var ResultCodeToName map[ResultCode]string = map[ResultCode]string{
 	DIAMETER_SUCCESS:                   "DIAMETER_SUCCESS",
-	DIMAETER_LIMITED_SUCCESS:           "DIMAETER_LIMITED_SUCCESS",
+	DIAMETER_LIMITED_SUCCESS:           "DIAMETER_LIMITED_SUCCESS",
 	DIAMETER_MULTI_ROUND_AUTH:          "DIAMETER_MULTI_ROUND_AUTH",
 	DIAMETER_COMMAND_UNSUPPORTED:       "DIAMETER_COMMAND_UNSUPPORTED",
 	DIAMETER_UNABLE_TO_DELIVER:         "DIAMETER_UNABLE_TO_DELIVER",
@@ -63,6 +113,7 @@ var ResultCodeToName map[ResultCode]string = map[ResultCode]string{
 	DIAMETER_INVALID_HDR_BITS:          "DIAMETER_INVALID_HDR_BITS",
 	DIAMETER_INVALID_AVP_BITS:          "DIAMETER_INVALID_AVP_BITS",
 	DIAMETER_UNKNOWN_PEER:              "DIAMETER_UNKNOWN_PEER",
+	DIAMETER_REALM_REDIRECT_INDICATION: "DIAMETER_REALM_REDIRECT_INDICATION",
 	DIAMETER_AUTHENTICATION_REJECTED:   "DIAMETER_AUTHENTICATION_REJECTED",
 	DIAMETER_OUT_OF_SPACE:              "DIAMETER_OUT_OF_SPACE",
 	DIAMETER_ELECTION_LOST:             "DIAMETER_ELECTION_LOST",
@@ -83,35 +134,49 @@ var ResultCodeToName map[ResultCode]string = map[ResultCode]string{
 	DIAMETER_INVALID_MESSAGE_LENGTH:    "DIAMETER_INVALID_MESSAGE_LENGTH",
 	DIAMETER_INVALID_AVP_BIT_COMBO:     "DIAMETER_INVALID_AVP_BIT_COMBO",
 	DIAMETER_NO_COMMON_SECURITY:        "DIAMETER_NO_COMMON_SECURITY",
+	// RFC 4006
+	DIAMETER_END_USER_SERVICE_DENIED:       "DIAMETER_END_USER_SERVICE_DENIED",
+	DIAMETER_CREDIT_CONTROL_NOT_APPLICABLE: "DIAMETER_CREDIT_CONTROL_NOT_APPLICABLE",
+	DIAMETER_CREDIT_LIMIT_REACHED:          "DIAMETER_CREDIT_LIMIT_REACHED",
+	DIAMETER_USER_UNKNOWN:                  "DIAMETER_USER_UNKNOWN",
+	DIAMETER_RATING_FAILED:                 "DIAMETER_RATING_FAILED",
 }
 
-// 7.1.  Result-Code AVP
+// String returns the name of r, or UNKNOWN(n) for a code without one.
+func (r ResultCode) String() string {
+	if name, ok := ResultCodeToName[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", uint32(r))
+}
+
+// 7.1.  Result-Code AVP
 //
-//	The Result-Code AVP (AVP Code 268) is of type Unsigned32 and
-//	indicates whether a particular request was completed successfully or
-//	whether an error occurred.  All Diameter answer messages defined in
-//	IETF applications MUST include one Result-Code AVP.  A non-successful
-//	Result-Code AVP (one containing a non 2xxx value other than
-//	DIAMETER_REDIRECT_INDICATION) MUST include the Error-Reporting-Host
-//	AVP if the host setting the Result-Code AVP is different from the
-//	identity encoded in the Origin-Host AVP.
+//	The Result-Code AVP (AVP Code 268) is of type Unsigned32 and
+//	indicates whether a particular request was completed successfully or
+//	whether an error occurred.  All Diameter answer messages defined in
+//	IETF applications MUST include one Result-Code AVP.  A non-successful
+//	Result-Code AVP (one containing a non 2xxx value other than
+//	DIAMETER_REDIRECT_INDICATION) MUST include the Error-Reporting-Host
+//	AVP if the host setting the Result-Code AVP is different from the
+//	identity encoded in the Origin-Host AVP.
 //
-//	The Result-Code data field contains an IANA-managed 32-bit address
-//	space representing errors (see Section 11.4).  Diameter provides the
-//	following classes of errors, all identified by the thousands digit in
-//	the decimal notation:
+//	The Result-Code data field contains an IANA-managed 32-bit address
+//	space representing errors (see Section 11.4).  Diameter provides the
+//	following classes of errors, all identified by the thousands digit in
+//	the decimal notation:
 //
-//	   -  1xxx (Informational)
-//	   -  2xxx (Success)
-//	   -  3xxx (Protocol Errors)
-//	   -  4xxx (Transient Failures)
+//	   -  1xxx (Informational)
+//	   -  2xxx (Success)
+//	   -  3xxx (Protocol Errors)
+//	   -  4xxx (Transient Failures)
 //	   -  5xxx (Permanent Failure)
 func GetResultCode(msg *DiameterMessage) (ResultCode, string, error) {
 	// TODO: check for error bit
 	for _, avp := range msg.AVPs {
 		if avp.Code == AVP_RESULT_CODE {
-			if value, ok := avp.Data.(*Unsigned32); ok {
-				return ResultCode(value.Data), ResultCodeToName[ResultCode(value.Data)], nil
+			if value, err := avp.Uint32(); err == nil {
+				return ResultCode(value), ResultCodeToName[ResultCode(value)], nil
 			}
 		}
 	}
@@ -119,7 +184,12 @@ func GetResultCode(msg *DiameterMessage) (ResultCode, string, error) {
 	return ResultCode(0), "", errors.New("Result-Code AVP not found")
 }
 
+// ValidateSuccessfulResponse checks that msg is an answer carrying a
+// Result-Code.
 func ValidateSuccessfulResponse(msg *DiameterMessage) error {
+	if msg.IsRequest() {
+		return fmt.Errorf("%w: %s", UnexpectedRequestError, msg.CommandName())
+	}
 	_, _, err := GetResultCode(msg)
 	return err
 }
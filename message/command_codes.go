@@ -5,6 +5,7 @@ const (
 	COMMAND_CODE_CAPABILITIES_EXCHANGE                = uint32(257)
 	COMMAND_CODE_RE_AUTH                              = uint32(258)
 	COMMAND_CODE_ACCOUNTING                           = uint32(271)
+	COMMAND_CODE_CREDIT_CONTROL                       = uint32(272)
 	COMMAND_CODE_ABORT_SESSION                        = uint32(274)
 	COMMAND_CODE_SESSION_TERMINATION                  = uint32(275)
 	COMMAND_CODE_DEVICE_WATCHDOG                      = uint32(280)
@@ -0,0 +1,93 @@
+// Command name registry
+package message
+
+import (
+	"fmt"
+	"sync"
+)
+
+type commandNames struct {
+	request string
+	answer  string
+}
+
+var (
+	commandNamesMu sync.RWMutex
+	commandNameMap = map[uint32]commandNames{
+		// RFC 6733
+		COMMAND_CODE_CAPABILITIES_EXCHANGE: {"Capabilities-Exchange-Request", "Capabilities-Exchange-Answer"},
+		COMMAND_CODE_RE_AUTH:               {"Re-Auth-Request", "Re-Auth-Answer"},
+		COMMAND_CODE_ACCOUNTING:            {"Accounting-Request", "Accounting-Answer"},
+		COMMAND_CODE_ABORT_SESSION:         {"Abort-Session-Request", "Abort-Session-Answer"},
+		COMMAND_CODE_SESSION_TERMINATION:   {"Session-Termination-Request", "Session-Termination-Answer"},
+		COMMAND_CODE_DEVICE_WATCHDOG:       {"Device-Watchdog-Request", "Device-Watchdog-Answer"},
+		COMMAND_CODE_DISCONNECT_PEER:       {"Disconnect-Peer-Request", "Disconnect-Peer-Answer"},
+		// RFC 4006
+		COMMAND_CODE_CREDIT_CONTROL: {"Credit-Control-Request", "Credit-Control-Answer"},
+		// RFC 5866 and RFC 6737
+		COMMAND_CODE_QOS_AUTHORIZATION:   {"QoS-Authorization-Request", "QoS-Authorization-Answer"},
+		COMMAND_CODE_QOS_INSTALL:         {"QoS-Install-Request", "QoS-Install-Answer"},
+		COMMAND_CODE_CAPABILITIES_UPDATE: {"Capabilities-Update-Request", "Capabilities-Update-Answer"},
+		// 3GPP TS 29.272, 29.172, 29.173, 29.219, 29.336 and 29.337
+		COMMAND_CODE_3GPP_UPDATE_LOCATION:            {"Update-Location-Request", "Update-Location-Answer"},
+		COMMAND_CODE_3GPP_CANCEL_LOCATION:            {"Cancel-Location-Request", "Cancel-Location-Answer"},
+		COMMAND_CODE_3GPP_AUTHENTICATION_INFORMATION: {"Authentication-Information-Request", "Authentication-Information-Answer"},
+		COMMAND_CODE_3GPP_INSERT_SUBSCRIBER_DATA:     {"Insert-Subscriber-Data-Request", "Insert-Subscriber-Data-Answer"},
+		COMMAND_CODE_3GPP_DELETE_SUBSCRIBER_DATA:     {"Delete-Subscriber-Data-Request", "Delete-Subscriber-Data-Answer"},
+		COMMAND_CODE_3GPP_PURGE_UE:                   {"Purge-UE-Request", "Purge-UE-Answer"},
+		COMMAND_CODE_3GPP_RESET:                      {"Reset-Request", "Reset-Answer"},
+		COMMAND_CODE_3GPP_NOTIFY:                     {"Notify-Request", "Notify-Answer"},
+		COMMAND_CODE_3GPP_ME_IDENTITY_CHECK:          {"ME-Identity-Check-Request", "ME-Identity-Check-Answer"},
+		COMMAND_CODE_3GPP_PROVIDE_LOCATION:           {"Provide-Location-Request", "Provide-Location-Answer"},
+		COMMAND_CODE_3GPP_LOCATION_REPORT:            {"Location-Report-Request", "Location-Report-Answer"},
+		COMMAND_CODE_3GPP_LCS_ROUTING_INFO:           {"LCS-Routing-Info-Request", "LCS-Routing-Info-Answer"},
+		COMMAND_CODE_SPENDING_LIMIT:                  {"Spending-Limit-Request", "Spending-Limit-Answer"},
+		COMMAND_CODE_SPENDING_STATUS_NOTIFICATION:    {"Spending-Status-Notification-Request", "Spending-Status-Notification-Answer"},
+		COMMAND_CODE_3GPP_DEVICE_ACTION:              {"Device-Action-Request", "Device-Action-Answer"},
+		COMMAND_CODE_3GPP_DEVICE_NOTIFICATION:        {"Device-Notification-Request", "Device-Notification-Answer"},
+		COMMAND_CODE_3GPP_SUBSCRIBER_INFORMATION:     {"Subscriber-Information-Request", "Subscriber-Information-Answer"},
+		COMMAND_CODE_3GPP_DEVICE_TRIGGER:             {"Device-Trigger-Request", "Device-Trigger-Answer"},
+		COMMAND_CODE_3GPP_DELIVERY_REPORT:            {"Delivery-Report-Request", "Delivery-Report-Answer"},
+	}
+)
+
+// RegisterCommandName sets the names under which the requests and answers
+// of code are logged and reported, replacing any existing names.
+func RegisterCommandName(code uint32, requestName, answerName string) {
+	commandNamesMu.Lock()
+	defer commandNamesMu.Unlock()
+	commandNameMap[code] = commandNames{request: requestName, answer: answerName}
+}
+
+// LookupCommandName returns the registered name of the request or answer
+// of code, depending on the 'R' bit of flags.
+func LookupCommandName(code uint32, flags CommandFlags) (string, bool) {
+	commandNamesMu.RLock()
+	names, ok := commandNameMap[code]
+	commandNamesMu.RUnlock()
+	if !ok {
+		return "", false
+	}
+	if flags.Request() {
+		return names.request, true
+	}
+	return names.answer, true
+}
+
+// CommandName is like LookupCommandName but falls back to a name built
+// from the code for unregistered commands.
+func CommandName(code uint32, flags CommandFlags) string {
+	if name, ok := LookupCommandName(code, flags); ok {
+		return name
+	}
+	if flags.Request() {
+		return fmt.Sprintf("Command-%d-Request", code)
+	}
+	return fmt.Sprintf("Command-%d-Answer", code)
+}
+
+// CommandName returns the name of the message's command, telling requests
+// and answers apart.
+func (msg *DiameterMessage) CommandName() string {
+	return CommandName(msg.Header.CommandCode, msg.Header.CommandFlags)
+}
@@ -0,0 +1,69 @@
+package message
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCommandNames(t *testing.T) {
+	for _, tc := range []struct {
+		code            uint32
+		request, answer string
+	}{
+		{COMMAND_CODE_CAPABILITIES_EXCHANGE, "Capabilities-Exchange-Request", "Capabilities-Exchange-Answer"},
+		{COMMAND_CODE_DEVICE_WATCHDOG, "Device-Watchdog-Request", "Device-Watchdog-Answer"},
+		{COMMAND_CODE_DISCONNECT_PEER, "Disconnect-Peer-Request", "Disconnect-Peer-Answer"},
+		{COMMAND_CODE_RE_AUTH, "Re-Auth-Request", "Re-Auth-Answer"},
+		{COMMAND_CODE_ACCOUNTING, "Accounting-Request", "Accounting-Answer"},
+		{COMMAND_CODE_CREDIT_CONTROL, "Credit-Control-Request", "Credit-Control-Answer"},
+		{COMMAND_CODE_ABORT_SESSION, "Abort-Session-Request", "Abort-Session-Answer"},
+		{COMMAND_CODE_SESSION_TERMINATION, "Session-Termination-Request", "Session-Termination-Answer"},
+		{COMMAND_CODE_3GPP_UPDATE_LOCATION, "Update-Location-Request", "Update-Location-Answer"},
+	} {
+		if got := CommandName(tc.code, FlagRequest); got != tc.request {
+			t.Errorf("request %d named %q, want %q", tc.code, got, tc.request)
+		}
+		if got := CommandName(tc.code, 0); got != tc.answer {
+			t.Errorf("answer %d named %q, want %q", tc.code, got, tc.answer)
+		}
+		// Only the 'R' bit tells requests and answers apart.
+		if got := CommandName(tc.code, FlagProxiable|FlagError); got != tc.answer {
+			t.Errorf("answer %d with 'P' and 'E' named %q, want %q", tc.code, got, tc.answer)
+		}
+	}
+}
+
+func TestCommandNameUnregistered(t *testing.T) {
+	const code = 16777001
+	if name, ok := LookupCommandName(code, FlagRequest); ok {
+		t.Fatalf("%d already registered as %q", code, name)
+	}
+	if got := CommandName(code, FlagRequest); got != "Command-16777001-Request" {
+		t.Errorf("request named %q", got)
+	}
+	if got := CommandName(code, 0); got != "Command-16777001-Answer" {
+		t.Errorf("answer named %q", got)
+	}
+
+	RegisterCommandName(code, "Example-Request", "Example-Answer")
+	if got, ok := LookupCommandName(code, FlagRequest); !ok || got != "Example-Request" {
+		t.Errorf("LookupCommandName = %q, %v after RegisterCommandName", got, ok)
+	}
+	if got := CommandName(code, 0); got != "Example-Answer" {
+		t.Errorf("answer named %q after RegisterCommandName", got)
+	}
+}
+
+func TestHeaderStringCommandName(t *testing.T) {
+	node := Node{OriginHost: "server.example.com", OriginRealm: "example.com"}
+	ans, err := node.BuildAnswer(newTestCCR(t), DIAMETER_SUCCESS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ans.CommandName(); got != "Credit-Control-Answer" {
+		t.Errorf("CommandName = %q", got)
+	}
+	if s := ans.String(); !strings.Contains(s, "CommandCode: 272 (Credit-Control-Answer)") {
+		t.Errorf("command name missing from\n%s", s)
+	}
+}
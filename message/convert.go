@@ -0,0 +1,186 @@
+// Conversion of AVP values to Go types
+package message
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"time"
+	"unicode/utf8"
+)
+
+// ntpEraOffset is the number of seconds between the NTP epoch, 1 January
+// 1900, and the Unix epoch.
+const ntpEraOffset = 2208988800
+
+// conversionError describes why the value of a cannot be read as target.
+func (a *AVP) conversionError(target string) error {
+	if a == nil {
+		return fmt.Errorf("%w: missing AVP to %s", InvalidConversionError, target)
+	}
+	return fmt.Errorf("%w: AVP %d of type %T to %s", InvalidConversionError, a.Code, a.Data, target)
+}
+
+// rangeError reports a value that does not fit in target.
+func (a *AVP) rangeError(value any, target string) error {
+	return fmt.Errorf("%w: AVP %d value %v as %s", ValueOutOfRangeError, a.Code, value, target)
+}
+
+// Int64 returns the value of an integer AVP: Integer32, Integer64,
+// Unsigned32, Unsigned64, Enumerated, AppId or VendorId.
+func (a *AVP) Int64() (int64, error) {
+	return a.integer("int64")
+}
+
+// Int32 returns the value of an integer AVP that fits in an int32.
+func (a *AVP) Int32() (int32, error) {
+	v, err := a.integer("int32")
+	if err != nil {
+		return 0, err
+	}
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		return 0, a.rangeError(v, "int32")
+	}
+	return int32(v), nil
+}
+
+// Uint64 returns the value of an integer AVP that is not negative.
+func (a *AVP) Uint64() (uint64, error) {
+	if u, ok := a.dataOf().(*Unsigned64); ok {
+		return u.Data, nil
+	}
+	v, err := a.integer("uint64")
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 {
+		return 0, a.rangeError(v, "uint64")
+	}
+	return uint64(v), nil
+}
+
+// Uint32 returns the value of an integer AVP that fits in a uint32. An
+// Enumerated is returned as its raw value, so that an application using
+// Unsigned32 and one using Enumerated for the same AVP read it alike.
+func (a *AVP) Uint32() (uint32, error) {
+	if e, ok := a.dataOf().(*Enumerated); ok {
+		return e.Data, nil
+	}
+	v, err := a.integer("uint32")
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 || v > math.MaxUint32 {
+		return 0, a.rangeError(v, "uint32")
+	}
+	return uint32(v), nil
+}
+
+// integer returns the value of an integer AVP as an int64. Unsigned64
+// values above math.MaxInt64 are out of range for every target but uint64,
+// which reads them directly.
+func (a *AVP) integer(target string) (int64, error) {
+	switch d := a.dataOf().(type) {
+	case *Integer32:
+		return int64(d.Data), nil
+	case *Integer64:
+		return d.Data, nil
+	case *Unsigned32:
+		return int64(d.Data), nil
+	case *Unsigned64:
+		if d.Data > math.MaxInt64 {
+			return 0, a.rangeError(d.Data, target)
+		}
+		return int64(d.Data), nil
+	case *Enumerated:
+		// Enumerated is derived from Integer32.
+		return int64(int32(d.Data)), nil
+	case *AppId:
+		return int64(d.Data), nil
+	case *VendorId:
+		return int64(d.Data), nil
+	}
+	return 0, a.conversionError(target)
+}
+
+// Str returns the value of a UTF8String, DiameterIdentity or DiameterURI
+// AVP, or of an OctetString holding valid UTF-8.
+func (a *AVP) Str() (string, error) {
+	switch d := a.dataOf().(type) {
+	case *UTF8String:
+		return d.Data, nil
+	case *DiameterIdentity:
+		return d.Data, nil
+	case *DiameterURI:
+		return d.Data, nil
+	case *OctetString:
+		if !utf8.Valid(d.Data) {
+			return "", fmt.Errorf("%w: AVP %d", InvalidUTF8Error, a.Code)
+		}
+		return string(d.Data), nil
+	}
+	return "", a.conversionError("string")
+}
+
+// Bytes returns the octets of an OctetString AVP or of a type derived from
+// it: UTF8String, DiameterIdentity, DiameterURI, Address and Time. The
+// slice of an OctetString is returned as is and must not be modified.
+func (a *AVP) Bytes() ([]byte, error) {
+	switch d := a.dataOf().(type) {
+	case *OctetString:
+		return d.Data, nil
+	case *UTF8String, *DiameterIdentity, *DiameterURI, *Address, *Time:
+		return d.Encode()
+	}
+	return nil, a.conversionError("bytes")
+}
+
+// IP returns the value of an IPv4 or IPv6 Address AVP, or of an
+// OctetString holding a four or sixteen octet address as applications
+// carrying RADIUS attributes do.
+func (a *AVP) IP() (net.IP, error) {
+	switch d := a.dataOf().(type) {
+	case *Address:
+		if ip := d.IP(); ip != nil {
+			return ip, nil
+		}
+		return nil, fmt.Errorf("%w: AVP %d has address family %d", UnknownAddressTypeError, a.Code, d.AddressFamily())
+	case *OctetString:
+		if len(d.Data) == IPv4AddressLength || len(d.Data) == IPv6AddressLength {
+			return net.IP(d.Data), nil
+		}
+		return nil, fmt.Errorf("%w: AVP %d has %d octets", InvalidAddressLengthError, a.Code, len(d.Data))
+	}
+	return nil, a.conversionError("IP")
+}
+
+// Time returns the value of a Time AVP. Values with the most significant
+// bit clear are taken to be after 7 February 2036, as RFC 6733 section
+// 4.3.1 requires through the procedure of RFC 5905.
+func (a *AVP) Time() (time.Time, error) {
+	t, ok := a.dataOf().(*Time)
+	if !ok {
+		return time.Time{}, a.conversionError("time")
+	}
+	seconds := int64(t.Data)
+	if t.Data&0x80000000 == 0 {
+		seconds += 1 << 32
+	}
+	return time.Unix(seconds-ntpEraOffset, 0).UTC(), nil
+}
+
+// Group returns the value of a Grouped AVP.
+func (a *AVP) Group() (*Grouped, error) {
+	if g, ok := a.dataOf().(*Grouped); ok {
+		return g, nil
+	}
+	return nil, a.conversionError("grouped")
+}
+
+// dataOf returns the data of a, or nil for a nil AVP.
+func (a *AVP) dataOf() AVPData {
+	if a == nil {
+		return nil
+	}
+	return a.Data
+}
@@ -0,0 +1,106 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"testing"
+)
+
+// converters are the AVP accessors, each returning only its error.
+var converters = []struct {
+	name    string
+	convert func(*AVP) error
+}{
+	{"Int64", func(a *AVP) error { _, err := a.Int64(); return err }},
+	{"Int32", func(a *AVP) error { _, err := a.Int32(); return err }},
+	{"Uint64", func(a *AVP) error { _, err := a.Uint64(); return err }},
+	{"Uint32", func(a *AVP) error { _, err := a.Uint32(); return err }},
+	{"Str", func(a *AVP) error { _, err := a.Str(); return err }},
+	{"Bytes", func(a *AVP) error { _, err := a.Bytes(); return err }},
+	{"IP", func(a *AVP) error { _, err := a.IP(); return err }},
+	{"Time", func(a *AVP) error { _, err := a.Time(); return err }},
+	{"Group", func(a *AVP) error { _, err := a.Group(); return err }},
+}
+
+func TestConversionMatrix(t *testing.T) {
+	integers := []string{"Int64", "Int32", "Uint64", "Uint32"}
+	text := []string{"Str", "Bytes"}
+	address := &Address{}
+	if err := address.SetData(net.ParseIP("192.0.2.1")); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		data AVPData
+		// ok lists the accessors that succeed, all others must fail.
+		ok []string
+	}{
+		{&OctetString{Data: []byte("abcd")}, []string{"Str", "Bytes", "IP"}},
+		{&Integer32{Data: 7}, integers},
+		{&Integer64{Data: 7}, integers},
+		{&Unsigned32{Data: 7}, integers},
+		{&Unsigned64{Data: 7}, integers},
+		{&Float32{Data: 7}, nil},
+		{&Float64{Data: 7}, nil},
+		{&Grouped{}, []string{"Group"}},
+		{address, []string{"Bytes", "IP"}},
+		{&UTF8String{Data: "abc"}, text},
+		{&Enumerated{Data: 7}, integers},
+		{&Time{Data: 0xe0000000}, []string{"Bytes", "Time"}},
+		{&DiameterIdentity{Data: "host.example.com"}, text},
+		{&AppId{Data: 7}, integers},
+		{&VendorId{Data: 7}, integers},
+		{&DiameterURI{Data: "aaa://host.example.com"}, text},
+		{&IPFilterRule{Data: 7}, nil},
+	} {
+		avp := &AVP{Code: 1, Data: tc.data}
+		for _, c := range converters {
+			t.Run(fmt.Sprintf("%T/%s", tc.data, c.name), func(t *testing.T) {
+				err := c.convert(avp)
+				want := false
+				for _, name := range tc.ok {
+					want = want || name == c.name
+				}
+				switch {
+				case want && err != nil:
+					t.Errorf("failed: %v", err)
+				case !want && !errors.Is(err, InvalidConversionError):
+					t.Errorf("error %v, want InvalidConversionError", err)
+				}
+			})
+		}
+	}
+	for _, c := range converters {
+		if err := c.convert(nil); !errors.Is(err, InvalidConversionError) {
+			t.Errorf("%s of a missing AVP: error %v, want InvalidConversionError", c.name, err)
+		}
+	}
+}
+
+func TestConversionRange(t *testing.T) {
+	for _, tc := range []struct {
+		data AVPData
+		// outOfRange lists the accessors the value does not fit.
+		outOfRange []string
+	}{
+		{&Integer32{Data: -1}, []string{"Uint64", "Uint32"}},
+		{&Integer64{Data: math.MinInt32 - 1}, []string{"Int32", "Uint64", "Uint32"}},
+		{&Integer64{Data: math.MaxUint32 + 1}, []string{"Int32", "Uint32"}},
+		{&Unsigned32{Data: math.MaxUint32}, []string{"Int32"}},
+		{&Unsigned64{Data: math.MaxUint64}, []string{"Int64", "Int32", "Uint32"}},
+		{&Enumerated{Data: math.MaxUint32}, []string{"Uint64"}},
+	} {
+		avp := &AVP{Code: 1, Data: tc.data}
+		for _, c := range converters[:4] {
+			err := c.convert(avp)
+			want := false
+			for _, name := range tc.outOfRange {
+				want = want || name == c.name
+			}
+			if want != errors.Is(err, ValueOutOfRangeError) || !want && err != nil {
+				t.Errorf("%s of %T %v: error %v, out of range %t", c.name, tc.data, tc.data, err, want)
+			}
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"net"
+	"time"
 )
 
 // TODO: Fix the encoding decoding functions for the derived types
@@ -28,6 +29,13 @@ const (
 	AddressFamilyIPv6Byte = byte(0x02) // 0x02 for IPv6
 )
 
+// Address families of the IANA registry used by Address AVPs.
+const (
+	AddressFamilyIPv4 = uint16(1)
+	AddressFamilyIPv6 = uint16(2)
+	AddressFamilyE164 = uint16(8)
+)
+
 func encode32[T uint32 | int32](data T) ([]byte, error) {
 	buffer := make([]byte, int32Length)
 	for i := 0; i < int32Length; i++ {
@@ -44,16 +52,25 @@ func encode64[T uint64 | int64](data T) ([]byte, error) {
 	return buffer, nil
 }
 
+// decode32 and decode64 decode fixed-size values, which must fill the
+// data of the AVP exactly: an AVP Length longer than the value would
+// otherwise hide the start of the next AVP.
 func decode32[T uint32 | int32](data []byte, t T) (T, error) {
+	if len(data) != int32Length {
+		return t, fmt.Errorf("%w: %d", InvalidAVPDataLengthError, len(data))
+	}
 	for i := 0; i < int32Length; i++ {
-		t = t | T(data[i])<<uint(bitsInByte*i)
+		t = t<<bitsInByte | T(data[i])
 	}
 	return t, nil
 }
 
 func decode64[T uint64 | int64](data []byte, t T) (T, error) {
+	if len(data) != int64Length {
+		return t, fmt.Errorf("%w: %d", InvalidAVPDataLengthError, len(data))
+	}
 	for i := 0; i < int64Length; i++ {
-		t = t | T(data[i])<<uint(bitsInByte*i)
+		t = t<<bitsInByte | T(data[i])
 	}
 	return t, nil
 }
@@ -73,12 +90,18 @@ type OctetString struct {
 	min_length uint32
 }
 
+// SetData sets the value from a []byte, or from a string so that NewAVP
+// can build OctetString AVPs.
 func (o *OctetString) SetData(data interface{}) error {
-	if d, ok := data.([]byte); ok {
+	switch d := data.(type) {
+	case []byte:
 		o.Data = d
-		return nil
+	case string:
+		o.Data = []byte(d)
+	default:
+		return fmt.Errorf("invalid data type: %T", data)
 	}
-	return fmt.Errorf("invalid data type: %T", data)
+	return nil
 }
 
 func (o *OctetString) Length() uint32 {
@@ -90,17 +113,27 @@ func (o *OctetString) Encode() ([]byte, error) {
 	if length < int(o.min_length) {
 		length = int(o.min_length)
 	}
-	buffer := make([]byte, length+getPadding(length))
+	buffer := make([]byte, length)
 	copy(buffer, o.Data)
 	return buffer, nil
 }
 
+// Decode copies data, so the value never aliases the buffer it was read
+// from.
 func (o *OctetString) Decode(data []byte) error {
-	o.Data = data
+	o.Data = append([]byte(nil), data...)
 	return nil
 }
 
+// String renders the value for logs: as text when it is printable UTF-8,
+// as hex otherwise, truncated to the length set by SetMaxDisplayLength.
 func (o *OctetString) String() string {
+	return formatBytes(o.Data)
+}
+
+// FullString returns the value converted to a string without truncation
+// or escaping.
+func (o *OctetString) FullString() string {
 	return string(o.Data)
 }
 
@@ -270,7 +303,7 @@ func (f *Float32) Encode() ([]byte, error) {
 
 func (f *Float32) Decode(data []byte) error {
 	if len(data) != 4 {
-		return fmt.Errorf("invalid data length: %d", len(data))
+		return fmt.Errorf("%w: %d", InvalidAVPDataLengthError, len(data))
 	}
 	f.Data = math.Float32frombits(binary.BigEndian.Uint32(data))
 	return nil
@@ -310,7 +343,7 @@ func (f *Float64) Encode() ([]byte, error) {
 
 func (f *Float64) Decode(data []byte) error {
 	if len(data) != 8 {
-		return fmt.Errorf("invalid data length: %d", len(data))
+		return fmt.Errorf("%w: %d", InvalidAVPDataLengthError, len(data))
 	}
 	f.Data = math.Float64frombits(binary.BigEndian.Uint64(data))
 	return nil
@@ -341,10 +374,12 @@ func (g *Grouped) SetData(data interface{}) error {
 	return fmt.Errorf("invalid data type: %T", data)
 }
 
+// Length returns the length of the included AVPs, each one counted with
+// its padding.
 func (g *Grouped) Length() uint32 {
 	length := uint32(0)
 	for _, avp := range g.AVPs {
-		length += avp.Length()
+		length += avp.Length() + uint32(getPadding(int(avp.Length())))
 	}
 	return length
 }
@@ -362,18 +397,56 @@ func (g *Grouped) Encode() ([]byte, error) {
 }
 
 func (g *Grouped) Decode(data []byte) error {
-	offset := 0
-	for offset < len(data) {
-		avp := &AVP{}
-		if err := avp.Decode(data[offset:]); err != nil {
-			return err
-		}
-		g.AVPs = append(g.AVPs, avp)
-		offset += int(avp.AVPlength)
+	avps, err := extractAVPs(data)
+	if err != nil {
+		return err
 	}
+	g.AVPs = avps
 	return nil
 }
 
+// Get returns the first included AVP with the given code.
+func (g *Grouped) Get(code uint32) (*AVP, bool) {
+	for _, avp := range g.AVPs {
+		if avp.Code == code {
+			return avp, true
+		}
+	}
+	return nil, false
+}
+
+// GetAll returns every included AVP with the given code, in order.
+func (g *Grouped) GetAll(code uint32) []*AVP {
+	var avps []*AVP
+	for _, avp := range g.AVPs {
+		if avp.Code == code {
+			avps = append(avps, avp)
+		}
+	}
+	return avps
+}
+
+// Add appends avps to the group. The length of the owning AVP is brought
+// up to date when it is encoded or refreshed.
+func (g *Grouped) Add(avps ...*AVP) {
+	g.AVPs = append(g.AVPs, avps...)
+}
+
+// Remove deletes every included AVP with the given code and returns how
+// many were removed.
+func (g *Grouped) Remove(code uint32) int {
+	kept := g.AVPs[:0]
+	for _, avp := range g.AVPs {
+		if avp.Code != code {
+			kept = append(kept, avp)
+		}
+	}
+	removed := len(g.AVPs) - len(kept)
+	clear(g.AVPs[len(kept):])
+	g.AVPs = kept
+	return removed
+}
+
 func (g *Grouped) String() string {
 	var str string
 	for _, avp := range g.AVPs {
@@ -395,18 +468,71 @@ func (g *Grouped) String() string {
 type Address struct {
 	Data   net.IP
 	isIPv4 bool
+	// Family and Raw hold addresses of families other than IPv4 and
+	// IPv6, such as E.164 numbers; Data is nil for them.
+	Family uint16
+	Raw    []byte
+}
+
+// RawAddress is an address of any family, as accepted by Address.SetData
+// for addresses that are not IP addresses.
+type RawAddress struct {
+	Family uint16 `json:"family"`
+	Data   []byte `json:"data"`
 }
 
 func (i *Address) SetData(data interface{}) error {
-	if d, ok := data.(net.IP); ok {
+	switch d := data.(type) {
+	case net.IP:
 		i.Data = d
 		i.isIPv4 = i.Data.To4() != nil
+		i.Family, i.Raw = 0, nil
+		return nil
+	case RawAddress:
+		switch {
+		case d.Family == AddressFamilyIPv4 && len(d.Data) == IPv4AddressLength,
+			d.Family == AddressFamilyIPv6 && len(d.Data) == IPv6AddressLength:
+			return i.SetData(append(net.IP(nil), d.Data...))
+		case d.Family == AddressFamilyIPv4 || d.Family == AddressFamilyIPv6:
+			return InvalidAddressLengthError
+		}
+		i.Data, i.isIPv4 = nil, false
+		i.Family, i.Raw = d.Family, d.Data
 		return nil
 	}
 	return fmt.Errorf("invalid data type: %T", data)
 }
 
+// isRaw reports whether the address is of a family other than IPv4 and
+// IPv6.
+func (i *Address) isRaw() bool {
+	return i.Raw != nil || i.Family != 0 && i.Family != AddressFamilyIPv4 && i.Family != AddressFamilyIPv6
+}
+
+// AddressFamily returns the IANA address family of the address.
+func (i *Address) AddressFamily() uint16 {
+	switch {
+	case i.isRaw():
+		return i.Family
+	case i.isIPv4:
+		return AddressFamilyIPv4
+	}
+	return AddressFamilyIPv6
+}
+
+// IP returns the address of an IPv4 or IPv6 Address, nil for other
+// families.
+func (i *Address) IP() net.IP {
+	if i.isRaw() {
+		return nil
+	}
+	return i.Data
+}
+
 func (i *Address) Length() uint32 {
+	if i.isRaw() {
+		return IPAddressTypeLength + uint32(len(i.Raw))
+	}
 	if i.isIPv4 {
 		return IPAddressTypeLength + IPv4AddressLength
 	}
@@ -416,49 +542,60 @@ func (i *Address) Length() uint32 {
 func (i *Address) Encode() ([]byte, error) {
 
 	buffer := make([]byte, i.Length())
-	if i.isIPv4 {
+	if i.isRaw() {
+		binary.BigEndian.PutUint16(buffer, i.Family)
+		copy(buffer[IPAddressTypeLength:], i.Raw)
+	} else if i.isIPv4 {
 		ip := i.Data.To4()
 		if ip == nil {
 			return nil, InvalidIPv4AddressError
 		}
-		copy(buffer, []byte{AddressFamilyIPv4Byte})
-		copy(buffer[AddressFamilyIPv4Byte:], ip)
+		buffer[1] = AddressFamilyIPv4Byte
+		copy(buffer[IPAddressTypeLength:], ip)
 	} else {
 		ip := i.Data.To16()
 		if ip == nil {
 			return nil, InvalidIPv6AddressError
 		}
-		copy(buffer, []byte{AddressFamilyIPv6Byte})
-		copy(buffer[AddressFamilyIPv6Byte:], ip)
+		buffer[1] = AddressFamilyIPv6Byte
+		copy(buffer[IPAddressTypeLength:], ip)
 	}
-	return append(buffer, make([]byte, getPadding(len(buffer)))...), nil
+	return buffer, nil
 }
 
+// Decode decodes an Address. Families other than IPv4 and IPv6 are kept
+// as Family and Raw rather than rejected.
 func (i *Address) Decode(data []byte) error {
 	// check for ip type in first 2 bytes + length of ip4 address
 	if len(data) < IPAddressTypeLength {
 		return InvalidAddressLengthError
 	}
 
+	i.Family, i.Raw = 0, nil
 	if data[0] == 0 && data[1] == 1 {
 		if len(data) != IPAddressTypeLength+IPv4AddressLength {
 			return InvalidIPv4AddressLengthError
 		}
 		i.isIPv4 = true
-		i.Data = net.IP(data[IPAddressTypeLength : IPAddressTypeLength+IPv4AddressLength])
+		i.Data = append(net.IP(nil), data[IPAddressTypeLength:IPAddressTypeLength+IPv4AddressLength]...)
 	} else if data[0] == 0 && data[1] == 2 {
 		if len(data) != IPAddressTypeLength+IPv6AddressLength {
 			return InvalidIPv6AddressLengthError
 		}
 		i.isIPv4 = false
-		i.Data = net.IP(data[IPAddressTypeLength : IPAddressTypeLength+IPv6AddressLength])
+		i.Data = append(net.IP(nil), data[IPAddressTypeLength:IPAddressTypeLength+IPv6AddressLength]...)
 	} else {
-		return UnknownAddressTypeError
+		i.Data, i.isIPv4 = nil, false
+		i.Family = binary.BigEndian.Uint16(data)
+		i.Raw = append(make([]byte, 0, len(data)-IPAddressTypeLength), data[IPAddressTypeLength:]...)
 	}
 	return nil
 }
 
 func (i *Address) String() string {
+	if i.isRaw() {
+		return fmt.Sprintf("family=%d 0x%x", i.Family, i.Raw)
+	}
 	return i.Data.String()
 }
 
@@ -519,7 +656,15 @@ func (u *UTF8String) Decode(data []byte) error {
 	return nil
 }
 
+// String renders the value for logs, truncated to the length set by
+// SetMaxDisplayLength. Values that are not valid UTF-8 or hold control
+// characters are shown as hex.
 func (u *UTF8String) String() string {
+	return formatBytes([]byte(u.Data))
+}
+
+// FullString returns the value without truncation or escaping.
+func (u *UTF8String) FullString() string {
 	return u.Data
 }
 
@@ -577,12 +722,25 @@ type Time struct {
 	Data uint32
 }
 
+// SetData sets the value from the NTP seconds as a uint32 or from a
+// time.Time, which is truncated to the second.
 func (t *Time) SetData(data interface{}) error {
-	if d, ok := data.(uint32); ok {
+	switch d := data.(type) {
+	case uint32:
 		t.Data = d
-		return nil
+	case time.Time:
+		t.SetTime(d)
+	default:
+		return fmt.Errorf("invalid data type: %T", data)
 	}
-	return fmt.Errorf("invalid data type: %T", data)
+	return nil
+}
+
+// SetTime sets the value to tm. Times from 7 February 2036 on wrap around
+// to values with the most significant bit clear, which AVP.Time reads back
+// as such, following RFC 5905.
+func (t *Time) SetTime(tm time.Time) {
+	t.Data = uint32(tm.Unix() + ntpEraOffset)
 }
 
 func (t *Time) Length() uint32 {
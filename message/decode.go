@@ -0,0 +1,255 @@
+// Decoding and encoding options and per-AVP decode errors
+package message
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// DecodeMode selects how DecodeMessage handles AVP values that cannot be
+// decoded as their registered type.
+type DecodeMode int
+
+const (
+	// DecodeAuto is strict for requests and tolerant for answers, so that
+	// one malformed AVP does not cost an otherwise usable answer.
+	DecodeAuto DecodeMode = iota
+	// DecodeStrict fails the whole message on the first bad AVP value.
+	DecodeStrict
+	// DecodeTolerant keeps bad AVP values as raw OctetStrings and reports
+	// them through DiameterMessage.DecodeErrors.
+	DecodeTolerant
+)
+
+// DefaultMaxGroupDepth is the default nesting limit of Grouped AVPs.
+const DefaultMaxGroupDepth = 16
+
+// DecodeOptions gathers the knobs of the decoder. The zero value is the
+// default: DecodeAuto, unknown AVPs kept as OctetString whatever their 'M'
+// bit, no AVP length limit, no UTF-8 validation and Grouped AVPs nested at
+// most DefaultMaxGroupDepth deep.
+type DecodeOptions struct {
+	Mode DecodeMode
+	// MaxGroupDepth bounds the nesting of Grouped AVPs, a top-level
+	// Grouped AVP having depth 1. 0 means DefaultMaxGroupDepth.
+	MaxGroupDepth int
+	// RejectUnknownMandatory treats an AVP with the 'M' bit set and no
+	// dictionary entry as an error, reported as DIAMETER_AVP_UNSUPPORTED.
+	RejectUnknownMandatory bool
+	// MaxAVPLength, when not 0, bounds the AVP Length of every AVP.
+	MaxAVPLength uint32
+	// ValidateUTF8 rejects UTF8String values that are not valid UTF-8.
+	ValidateUTF8 bool
+	// RetainRawAVPBytes keeps a copy of the wire bytes of every AVP,
+	// Grouped members included, available from AVP.Raw, for digests that
+	// must cover the AVPs as received.
+	RetainRawAVPBytes bool
+}
+
+// StrictDecodeOptions fails on any AVP the dictionary does not fully
+// account for.
+func StrictDecodeOptions() DecodeOptions {
+	return DecodeOptions{
+		Mode:                   DecodeStrict,
+		RejectUnknownMandatory: true,
+		ValidateUTF8:           true,
+	}
+}
+
+// LenientDecodeOptions decodes as much of every message as possible and
+// reports the rest through DiameterMessage.DecodeErrors.
+func LenientDecodeOptions() DecodeOptions {
+	return DecodeOptions{Mode: DecodeTolerant}
+}
+
+// DecodeOption configures DecodeMessage and DecodeAVP.
+type DecodeOption func(*DecodeOptions)
+
+// WithDecodeOptions replaces all decode options with o.
+func WithDecodeOptions(o DecodeOptions) DecodeOption {
+	return func(d *DecodeOptions) {
+		*d = o
+	}
+}
+
+// WithDecodeMode sets the decode mode.
+func WithDecodeMode(mode DecodeMode) DecodeOption {
+	return func(o *DecodeOptions) {
+		o.Mode = mode
+	}
+}
+
+// WithRejectUnknownMandatory rejects unknown AVPs with the 'M' bit set.
+func WithRejectUnknownMandatory() DecodeOption {
+	return func(o *DecodeOptions) {
+		o.RejectUnknownMandatory = true
+	}
+}
+
+// WithMaxAVPLength bounds the AVP Length of every AVP.
+func WithMaxAVPLength(length uint32) DecodeOption {
+	return func(o *DecodeOptions) {
+		o.MaxAVPLength = length
+	}
+}
+
+// WithMaxGroupDepth bounds the nesting of Grouped AVPs.
+func WithMaxGroupDepth(depth int) DecodeOption {
+	return func(o *DecodeOptions) {
+		o.MaxGroupDepth = depth
+	}
+}
+
+// WithUTF8Validation rejects UTF8String values that are not valid UTF-8.
+func WithUTF8Validation() DecodeOption {
+	return func(o *DecodeOptions) {
+		o.ValidateUTF8 = true
+	}
+}
+
+// WithRetainRawAVPBytes keeps the wire bytes of every AVP for AVP.Raw.
+func WithRetainRawAVPBytes() DecodeOption {
+	return func(o *DecodeOptions) {
+		o.RetainRawAVPBytes = true
+	}
+}
+
+func newDecodeOptions(opts []DecodeOption) DecodeOptions {
+	var o DecodeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func (o DecodeOptions) maxGroupDepth() int {
+	if o.MaxGroupDepth > 0 {
+		return o.MaxGroupDepth
+	}
+	return DefaultMaxGroupDepth
+}
+
+// tolerant reports whether value errors are tolerated in a message with
+// the given 'R' bit.
+func (o DecodeOptions) tolerant(request bool) bool {
+	return o.Mode == DecodeTolerant || (o.Mode == DecodeAuto && !request)
+}
+
+// checkValue applies the optional checks of o to an AVP whose value
+// decoded without error.
+func (o DecodeOptions) checkValue(a *AVP, known bool) error {
+	if o.RejectUnknownMandatory && !known && a.Flags&MANDATORY_FLAG != 0 {
+		return UnknownMandatoryAVPError
+	}
+	if o.MaxAVPLength > 0 && a.AVPlength > o.MaxAVPLength {
+		return fmt.Errorf("%w: %d exceeds %d", InvalidAVPDataLengthError, a.AVPlength, o.MaxAVPLength)
+	}
+	if s, ok := a.Data.(*UTF8String); ok && o.ValidateUTF8 && !utf8.ValidString(s.Data) {
+		return InvalidUTF8Error
+	}
+	return nil
+}
+
+// DecodeAVP decodes a single AVP from data. Value errors are returned
+// unless the options select DecodeTolerant, in which case they are
+// available from AVP.DecodeError.
+func DecodeAVP(data []byte, opts ...DecodeOption) (*AVP, error) {
+	o := newDecodeOptions(opts)
+	avp := &AVP{}
+	if err := avp.decode(data, o, o.Mode == DecodeTolerant, 0); err != nil {
+		return nil, err
+	}
+	return avp, nil
+}
+
+// EncodeOptions gathers the knobs of the encoder. The zero value is the
+// default: fixed-position AVPs are moved into place, messages up to the
+// protocol limit are accepted and Grouped AVPs may be nested
+// DefaultMaxGroupDepth deep, so that nothing is sent that the default
+// decoder would refuse.
+type EncodeOptions struct {
+	// MaxGroupDepth bounds the nesting of Grouped AVPs. 0 means
+	// DefaultMaxGroupDepth.
+	MaxGroupDepth int
+	// KeepOrder encodes the AVPs exactly in the order given, skipping
+	// Normalize.
+	KeepOrder bool
+	// MaxMessageLength, when not 0, bounds the length of the encoded
+	// message below the 24-bit protocol limit.
+	MaxMessageLength uint32
+	// Canonical makes the encoding depend only on the content of the
+	// message, for golden files and caches keyed by the encoding. By
+	// default the message is emitted exactly as set by the caller; in
+	// canonical form, at every level of nesting:
+	//   - reserved command and AVP flag bits are cleared,
+	//   - AVPs without flags, the 'V' bit aside, get their registered
+	//     default flags, see RegisterAVPFlags,
+	//   - the 'V' bit is set exactly on AVPs with a Vendor-ID, and
+	//   - after the AVPs with a fixed position, AVPs are sorted by
+	//     vendor and code, keeping repeated AVPs in their order.
+	// KeepOrder is ignored. Padding is zero in both forms, and the
+	// AVPs of the message are not reordered.
+	Canonical bool
+}
+
+// EncodeOption configures EncodeMessage.
+type EncodeOption func(*EncodeOptions)
+
+// WithEncodeOptions replaces all encode options with o.
+func WithEncodeOptions(o EncodeOptions) EncodeOption {
+	return func(e *EncodeOptions) {
+		*e = o
+	}
+}
+
+// WithKeepOrder encodes the AVPs in the order given.
+func WithKeepOrder() EncodeOption {
+	return func(o *EncodeOptions) {
+		o.KeepOrder = true
+	}
+}
+
+// WithCanonical encodes the message in canonical form.
+func WithCanonical() EncodeOption {
+	return func(o *EncodeOptions) {
+		o.Canonical = true
+	}
+}
+
+// WithMaxMessageLength bounds the length of the encoded message.
+func WithMaxMessageLength(length uint32) EncodeOption {
+	return func(o *EncodeOptions) {
+		o.MaxMessageLength = length
+	}
+}
+
+// checkGroupDepth returns GroupTooDeepError if any of avps nests Grouped
+// AVPs deeper than maxDepth. The tree is walked iteratively so that a
+// pathological message built in memory cannot exhaust the stack.
+func checkGroupDepth(avps []*AVP, maxDepth int) error {
+	var err error
+	walkAVPs(avps, func(path []uint32, avp *AVP) bool {
+		if _, ok := avp.Data.(*Grouped); ok && len(path) > maxDepth {
+			err = GroupTooDeepError
+			return false
+		}
+		return true
+	})
+	return err
+}
+
+// AVPDecodeError reports an AVP whose value was kept raw by tolerant
+// decoding.
+type AVPDecodeError struct {
+	// AVP is the AVP as received, with its value as an OctetString.
+	AVP *AVP
+	Err error
+}
+
+func (e AVPDecodeError) Error() string {
+	return fmt.Sprintf("AVP %d (vendor %d): %v", e.AVP.Code, e.AVP.VendorID, e.Err)
+}
+
+func (e AVPDecodeError) Unwrap() error {
+	return e.Err
+}
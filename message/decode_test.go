@@ -0,0 +1,360 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// rawAVP returns the encoding of an AVP with the given header fields and
+// data, padded, whatever the dictionary says about code.
+func rawAVP(code uint32, flags uint8, length uint32, data []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, code)
+	b = append(b, flags)
+	b = append(b, byte(length>>16), byte(length>>8), byte(length))
+	b = append(b, data...)
+	return append(b, make([]byte, getPadding(len(b)))...)
+}
+
+// fixture returns an encoded CCR, a request when request is set and an
+// answer otherwise, with the raw AVPs appended.
+func fixture(t *testing.T, request bool, raw ...[]byte) []byte {
+	t.Helper()
+	msg, err := NewRequest(COMMAND_CODE_CREDIT_CONTROL, WithAVPs(
+		MustNewAVP(AVP_SESSION_ID, "client.example.com;1;1", MANDATORY_FLAG),
+		MustNewAVP(AVP_ORIGIN_HOST, "client.example.com", MANDATORY_FLAG),
+		MustNewAVP(AVP_ORIGIN_REALM, "example.com", MANDATORY_FLAG),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !request {
+		msg.Header.CommandFlags = 0
+	}
+	data, err := msg.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, avp := range raw {
+		data = append(data, avp...)
+	}
+	length := uint32(len(data))
+	data[1], data[2], data[3] = byte(length>>16), byte(length>>8), byte(length)
+	return data
+}
+
+// TestDecodeProfiles decodes the same malformed fixtures with the strict
+// and the lenient profile and checks where their outcomes diverge.
+func TestDecodeProfiles(t *testing.T) {
+	shortAppID := rawAVP(AVP_AUTH_APPLICATION_ID, MANDATORY_FLAG, AVPHeaderLength+3, []byte{0, 0, 4})
+	unknownMandatory := rawAVP(99999, MANDATORY_FLAG, AVPHeaderLength+2, []byte{1, 2})
+	unknownOptional := rawAVP(99999, 0, AVPHeaderLength+2, []byte{1, 2})
+	badUTF8 := rawAVP(AVP_USER_NAME, MANDATORY_FLAG, AVPHeaderLength+2, []byte{0xc3, 0x28})
+	overrun := rawAVP(AVP_USER_NAME, MANDATORY_FLAG, 200, []byte("user"))
+
+	profiles := map[string]DecodeOptions{
+		"strict":  StrictDecodeOptions(),
+		"lenient": LenientDecodeOptions(),
+	}
+	for _, tc := range []struct {
+		name  string
+		frame []byte
+		// strict is the error of the strict profile, nil for success.
+		strict error
+		// lenient is the error of the lenient profile, and lenientAVPs
+		// the number of AVPs it keeps raw when it succeeds.
+		lenient     error
+		lenientAVPs int
+	}{
+		{"well formed", fixture(t, true), nil, nil, 0},
+		{"unknown optional AVP", fixture(t, true, unknownOptional), nil, nil, 0},
+		{"short Unsigned32", fixture(t, true, shortAppID), InvalidAVPDataLengthError, nil, 1},
+		{"short Unsigned32 in an answer", fixture(t, false, shortAppID), InvalidAVPDataLengthError, nil, 1},
+		{"unknown mandatory AVP", fixture(t, true, unknownMandatory), UnknownMandatoryAVPError, nil, 0},
+		{"invalid UTF-8", fixture(t, true, badUTF8), InvalidUTF8Error, nil, 0},
+		{"several bad values", fixture(t, true, shortAppID, unknownMandatory, shortAppID), InvalidAVPDataLengthError, nil, 2},
+		{"AVP overrunning the message", fixture(t, true, overrun), InvalidAVPLengthError, InvalidAVPLengthError, 0},
+	} {
+		for name, opts := range profiles {
+			want, wantRaw := tc.strict, 0
+			if name == "lenient" {
+				want, wantRaw = tc.lenient, tc.lenientAVPs
+			}
+			msg, err := DecodeMessage(tc.frame, WithDecodeOptions(opts))
+			if want != nil {
+				if !errors.Is(err, want) {
+					t.Errorf("%s, %s: error %v, want %v", tc.name, name, err, want)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s, %s: %v", tc.name, name, err)
+				continue
+			}
+			if got := len(msg.DecodeErrors()); got != wantRaw {
+				t.Errorf("%s, %s: %d AVPs kept raw, want %d", tc.name, name, got, wantRaw)
+			}
+		}
+	}
+}
+
+// TestDecodeAutoMode checks that the default mode is strict for requests
+// and tolerant for answers.
+func TestDecodeAutoMode(t *testing.T) {
+	shortAppID := rawAVP(AVP_AUTH_APPLICATION_ID, MANDATORY_FLAG, AVPHeaderLength+3, []byte{0, 0, 4})
+	if _, err := DecodeMessage(fixture(t, true, shortAppID)); !errors.Is(err, InvalidAVPDataLengthError) {
+		t.Errorf("request: %v, want InvalidAVPDataLengthError", err)
+	}
+	msg, err := DecodeMessage(fixture(t, false, shortAppID))
+	if err != nil {
+		t.Fatalf("answer: %v", err)
+	}
+	errs := msg.DecodeErrors()
+	if len(errs) != 1 || errs[0].AVP.Code != AVP_AUTH_APPLICATION_ID {
+		t.Errorf("answer decode errors %v, want Auth-Application-Id", errs)
+	}
+	if _, ok := errs[0].AVP.Data.(*OctetString); !ok {
+		t.Errorf("bad value kept as %T, want OctetString", errs[0].AVP.Data)
+	}
+}
+
+// TestDecodeCorruptAnswer decodes an answer with one corrupt AVP after its
+// Result-Code: tolerant decoding still yields the Result-Code and reports
+// the corrupt AVP, strict decoding fails the whole message.
+func TestDecodeCorruptAnswer(t *testing.T) {
+	resultCode := rawAVP(AVP_RESULT_CODE, MANDATORY_FLAG, AVPHeaderLength+4, []byte{0, 0, 0x07, 0xd1})
+	corrupt := rawAVP(AVP_ORIGIN_STATE_ID, MANDATORY_FLAG, AVPHeaderLength+2, []byte{1, 2})
+	frame := fixture(t, false, resultCode, corrupt)
+
+	msg, err := DecodeMessage(frame)
+	if err != nil {
+		t.Fatalf("tolerant: %v", err)
+	}
+	if code, _, err := GetResultCode(msg); err != nil || code != DIAMETER_SUCCESS {
+		t.Errorf("Result-Code %v, %v; want DIAMETER_SUCCESS", code, err)
+	}
+	errs := msg.DecodeErrors()
+	if len(errs) != 1 || errs[0].AVP.Code != AVP_ORIGIN_STATE_ID || !errors.Is(errs[0], InvalidAVPDataLengthError) {
+		t.Fatalf("decode errors %v, want Origin-State-Id of invalid length", errs)
+	}
+	if raw, ok := errs[0].AVP.Data.(*OctetString); !ok || !bytes.Equal(raw.Data, []byte{1, 2}) {
+		t.Errorf("corrupt value kept as %v, want the raw bytes", errs[0].AVP.Data)
+	}
+	var verr *ValidationError
+	if !errors.As(ValidateDecoding(msg), &verr) || verr.ResultCode != DIAMETER_INVALID_AVP_LENGTH || verr.AVPCode != AVP_ORIGIN_STATE_ID {
+		t.Errorf("ValidateDecoding = %v, want DIAMETER_INVALID_AVP_LENGTH for Origin-State-Id", verr)
+	}
+
+	if _, err := DecodeMessage(frame, WithDecodeMode(DecodeStrict)); !errors.Is(err, InvalidAVPDataLengthError) {
+		t.Errorf("strict: %v, want InvalidAVPDataLengthError", err)
+	}
+}
+
+func TestDecodeOptionFuncs(t *testing.T) {
+	long := rawAVP(AVP_USER_NAME, MANDATORY_FLAG, AVPHeaderLength+64, make([]byte, 64))
+	for _, tc := range []struct {
+		name string
+		opt  DecodeOption
+		avp  []byte
+		want error
+	}{
+		{"max AVP length", WithMaxAVPLength(32), long, InvalidAVPDataLengthError},
+		{"UTF-8", WithUTF8Validation(), rawAVP(AVP_USER_NAME, 0, AVPHeaderLength+1, []byte{0xff}), InvalidUTF8Error},
+		{"unknown mandatory", WithRejectUnknownMandatory(), rawAVP(99999, MANDATORY_FLAG, AVPHeaderLength, nil), UnknownMandatoryAVPError},
+	} {
+		if _, err := DecodeAVP(tc.avp); err != nil {
+			t.Errorf("%s: default options: %v", tc.name, err)
+		}
+		if _, err := DecodeAVP(tc.avp, tc.opt); !errors.Is(err, tc.want) {
+			t.Errorf("%s: %v, want %v", tc.name, err, tc.want)
+		}
+		avp, err := DecodeAVP(tc.avp, tc.opt, WithDecodeMode(DecodeTolerant))
+		if err != nil || !errors.Is(avp.DecodeError(), tc.want) {
+			t.Errorf("%s, tolerant: %v, decode error %v", tc.name, err, avp.DecodeError())
+		}
+	}
+}
+
+// nestedGroups returns the encoding of depth Vendor-Specific-Application-Id
+// AVPs, each the only member of the one before, the innermost empty.
+func nestedGroups(depth int) []byte {
+	var data []byte
+	for range depth {
+		data = append(rawAVP(AVP_VENDOR_SPECIFIC_APPLICATION_ID, MANDATORY_FLAG, uint32(AVPHeaderLength+len(data)), nil), data...)
+	}
+	return data
+}
+
+// nestedGroupAVP builds the AVP nestedGroups encodes.
+func nestedGroupAVP(t *testing.T, depth int) *AVP {
+	t.Helper()
+	avp, err := NewGroupedAVP(AVP_VENDOR_SPECIFIC_APPLICATION_ID, MANDATORY_FLAG, 0)
+	for range depth - 1 {
+		if err != nil {
+			t.Fatal(err)
+		}
+		avp, err = NewGroupedAVP(AVP_VENDOR_SPECIFIC_APPLICATION_ID, MANDATORY_FLAG, 0, avp)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return avp
+}
+
+func TestGroupDepthBoundary(t *testing.T) {
+	for _, tc := range []struct {
+		depth, limit int
+	}{
+		{DefaultMaxGroupDepth, 0},
+		{4, 4},
+		{1, 1},
+	} {
+		if _, err := DecodeAVP(nestedGroups(tc.depth), WithMaxGroupDepth(tc.limit)); err != nil {
+			t.Errorf("decoding depth %d with limit %d: %v", tc.depth, tc.limit, err)
+		}
+		if _, err := DecodeAVP(nestedGroups(tc.depth+1), WithMaxGroupDepth(tc.limit)); !errors.Is(err, GroupTooDeepError) {
+			t.Errorf("decoding depth %d with limit %d: %v, want GroupTooDeepError", tc.depth+1, tc.limit, err)
+		}
+		msg, err := DecodeMessage(fixture(t, true, nestedGroups(tc.depth+1)), WithMaxGroupDepth(tc.limit), WithDecodeMode(DecodeTolerant))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if errs := msg.DecodeErrors(); len(errs) != 1 || !errors.Is(errs[0].Err, GroupTooDeepError) {
+			t.Errorf("tolerant decoding of depth %d with limit %d: decode errors %v, want GroupTooDeepError", tc.depth+1, tc.limit, errs)
+		}
+
+		for depth, want := range map[int]error{tc.depth: nil, tc.depth + 1: GroupTooDeepError} {
+			msg, err := NewRequest(COMMAND_CODE_CREDIT_CONTROL, WithAVPs(nestedGroupAVP(t, depth)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := EncodeMessage(msg, WithEncodeOptions(EncodeOptions{MaxGroupDepth: tc.limit}))
+			if !errors.Is(err, want) {
+				t.Errorf("encoding depth %d with limit %d: %v, want %v", depth, tc.limit, err, want)
+			}
+			if err == nil && !bytes.Equal(data[DIAMETER_HEADER_SIZE:], nestedGroups(depth)) {
+				t.Errorf("depth %d encodes as %x, want %x", depth, data[DIAMETER_HEADER_SIZE:], nestedGroups(depth))
+			}
+		}
+	}
+}
+
+// TestGroupDepthPathological decodes 10,000 nested groups, which would
+// exhaust the stack of a decoder recursing without limit.
+func TestGroupDepthPathological(t *testing.T) {
+	if _, err := DecodeMessage(fixture(t, true, nestedGroups(10_000))); !errors.Is(err, GroupTooDeepError) {
+		t.Errorf("decoding 10,000 nested groups: %v, want GroupTooDeepError", err)
+	}
+}
+
+// TestDecodeAVPLengthMismatch decodes AVPs whose AVP Length declares more
+// or fewer bytes than their value has, for types of fixed and of variable
+// size, alone and followed by another AVP.
+func TestDecodeAVPLengthMismatch(t *testing.T) {
+	next := rawAVP(AVP_ORIGIN_REALM, MANDATORY_FLAG, AVPHeaderLength+11, []byte("example.com"))
+	for _, tc := range []struct {
+		name string
+		data []byte
+		code uint32
+		want error
+	}{
+		{"Unsigned32 over-declared", rawAVP(AVP_RESULT_CODE, MANDATORY_FLAG, AVPHeaderLength+12, make([]byte, 12)), AVP_RESULT_CODE, InvalidAVPDataLengthError},
+		{"Unsigned32 under-declared", rawAVP(AVP_RESULT_CODE, MANDATORY_FLAG, AVPHeaderLength+2, []byte{0, 0, 7, 0xd1}), AVP_RESULT_CODE, InvalidAVPDataLengthError},
+		{"Unsigned64 under-declared", rawAVP(AVP_ACCOUNTING_SUB_SESSION_ID, MANDATORY_FLAG, AVPHeaderLength+4, make([]byte, 8)), AVP_ACCOUNTING_SUB_SESSION_ID, InvalidAVPDataLengthError},
+		{"Unsigned32 over-declared into the next AVP", append(rawAVP(AVP_RESULT_CODE, MANDATORY_FLAG, AVPHeaderLength+8, []byte{0, 0, 7, 0xd1}), next...), AVP_RESULT_CODE, InvalidAVPDataLengthError},
+		{"over-declared past the data", rawAVP(AVP_ORIGIN_HOST, MANDATORY_FLAG, AVPHeaderLength+64, []byte("host")), AVP_ORIGIN_HOST, InvalidAVPLengthError},
+		{"under-declared header", rawAVP(AVP_ORIGIN_HOST, MANDATORY_FLAG, AVPHeaderLength-1, []byte("host")), AVP_ORIGIN_HOST, InvalidAVPLengthError},
+		{"under-declared vendor header", rawAVP(AVP_ORIGIN_HOST, VENDOR_FLAG|MANDATORY_FLAG, AVPHeaderLength, []byte("\x00\x00\x28\xafhost")), AVP_ORIGIN_HOST, InvalidAVPLengthError},
+		{"short header", []byte{0, 0, 1, 8, MANDATORY_FLAG, 0}, AVP_ORIGIN_HOST, InvalidAVPLengthError},
+		{"short code", []byte{0, 0, 1}, 0, InvalidAVPLengthError},
+	} {
+		_, err := DecodeAVP(tc.data)
+		var avpErr *AVPError
+		if !errors.As(err, &avpErr) || !errors.Is(err, tc.want) {
+			t.Errorf("%s: %v, want an AVPError for %v", tc.name, err, tc.want)
+			continue
+		}
+		if avpErr.Code != tc.code {
+			t.Errorf("%s: error for AVP %d, want %d", tc.name, avpErr.Code, tc.code)
+		}
+	}
+
+	// A variable-size value takes exactly the declared bytes: an
+	// under-declared Origin-Host ends early and the bytes it left are
+	// read as the next AVP, whose header is then invalid.
+	host := rawAVP(AVP_ORIGIN_HOST, MANDATORY_FLAG, AVPHeaderLength+4, []byte("host.example.com"))
+	if _, err := DecodeMessage(fixture(t, true, host)); !errors.Is(err, InvalidAVPLengthError) {
+		t.Errorf("under-declared Origin-Host in a message: %v, want InvalidAVPLengthError", err)
+	}
+	avp, err := DecodeAVP(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := avp.Data.String(); got != "host" {
+		t.Errorf("under-declared Origin-Host decodes as %q, want %q", got, "host")
+	}
+}
+
+// checkRaw checks that avps, decoded from wire, each hold the segment of
+// wire they were decoded from, and so do the members of Grouped AVPs.
+func checkRaw(t *testing.T, avps []*AVP, wire []byte) {
+	t.Helper()
+	for _, avp := range avps {
+		n := int(avp.AVPlength) + getPadding(int(avp.AVPlength))
+		if !bytes.Equal(avp.Raw(), wire[:n]) {
+			t.Errorf("AVP %d: Raw() = %x, want %x", avp.Code, avp.Raw(), wire[:n])
+		}
+		if g, err := avp.Group(); err == nil {
+			header := AVPHeaderLength
+			if avp.Flags&VENDOR_FLAG != 0 {
+				header += 4
+			}
+			checkRaw(t, g.AVPs, wire[header:avp.AVPlength])
+		}
+		wire = wire[n:]
+	}
+	if len(wire) != 0 {
+		t.Errorf("%d bytes left after the AVPs", len(wire))
+	}
+}
+
+func TestRetainRawAVPBytes(t *testing.T) {
+	// An odd-length User-Name whose padding is not zero, which encoding
+	// again would not reproduce.
+	userName := rawAVP(AVP_USER_NAME, MANDATORY_FLAG, AVPHeaderLength+3, []byte("bob"))
+	userName[len(userName)-1] = 0xff
+	for name, frame := range map[string][]byte{
+		"Wireshark CCR": wiresharkCCR(t),
+		"odd padding":   fixture(t, true, userName),
+		"Wireshark CCA": wiresharkCCA(t),
+		"Huawei CEA":    huaweiCEA(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			input := bytes.Clone(frame)
+			msg, err := DecodeMessage(input, WithRetainRawAVPBytes(), WithDecodeMode(DecodeTolerant))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkRaw(t, msg.AVPs, frame[DIAMETER_HEADER_SIZE:msg.Header.MessageLength])
+
+			// The bytes are a copy, unaffected by the input buffer being
+			// reused or the AVPs being changed.
+			clear(input)
+			for _, avp := range msg.AVPs {
+				avp.Flags ^= PROTECTED_FLAG
+			}
+			checkRaw(t, msg.AVPs, frame[DIAMETER_HEADER_SIZE:msg.Header.MessageLength])
+
+			plain, err := DecodeMessage(frame, WithDecodeMode(DecodeTolerant))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, avp := range plain.AVPs {
+				if avp.Raw() != nil {
+					t.Errorf("AVP %d retained %x without the option", avp.Code, avp.Raw())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Components of DiameterURIs
+package message
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Default ports of RFC 6733 section 4.3.1.
+const (
+	DefaultDiameterPort       = 3868
+	DefaultSecureDiameterPort = 5658
+)
+
+// DiameterURIParts are the components of a DiameterURI in the format of
+// RFC 6733 section 4.3.1:
+//
+//	"aaa://" FQDN [ port ] [ transport ] [ protocol ]
+//	"aaas://" FQDN [ port ] [ transport ] [ protocol ]
+//
+// ParseDiameterURI fills in the defaults of the components that are
+// absent.
+type DiameterURIParts struct {
+	// Secure is set for the "aaas" scheme, which uses transport security.
+	Secure bool
+	FQDN   string
+	Port   uint16
+	// Transport is "tcp", "sctp" or "udp".
+	Transport string
+	// Protocol is "diameter", "radius" or "tacacs+".
+	Protocol string
+}
+
+// ParseDiameterURI splits uri into its components. It returns an error
+// wrapping InvalidDiameterURIError if uri does not follow the grammar of
+// RFC 6733 section 4.3.1, if its FQDN is not a valid DiameterIdentity, or
+// if it selects UDP for the Diameter protocol, which the RFC forbids.
+// Scheme, transport and protocol are matched without regard to case.
+func ParseDiameterURI(uri string) (DiameterURIParts, error) {
+	var parts DiameterURIParts
+	rest, ok := cutPrefixFold(uri, "aaa://")
+	if !ok {
+		if rest, ok = cutPrefixFold(uri, "aaas://"); !ok {
+			return parts, fmt.Errorf("%w: %q has no aaa or aaas scheme", InvalidDiameterURIError, uri)
+		}
+		parts.Secure = true
+	}
+	authority, params, more := strings.Cut(rest, ";")
+	host, port, hasPort := strings.Cut(authority, ":")
+	if err := ValidateDiameterIdentity(host); err != nil {
+		return parts, fmt.Errorf("%w %q: %w", InvalidDiameterURIError, uri, err)
+	}
+	parts.FQDN = host
+	switch {
+	case hasPort:
+		n, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return parts, fmt.Errorf("%w: port %q of %q", InvalidDiameterURIError, port, uri)
+		}
+		parts.Port = uint16(n)
+	case parts.Secure:
+		parts.Port = DefaultSecureDiameterPort
+	default:
+		parts.Port = DefaultDiameterPort
+	}
+
+	// The transport, when present, comes before the protocol.
+	parts.Transport, parts.Protocol = "tcp", "diameter"
+	seen := 0
+	for more {
+		var param string
+		param, params, more = strings.Cut(params, ";")
+		name, value, _ := strings.Cut(param, "=")
+		value = strings.ToLower(value)
+		switch {
+		case strings.EqualFold(name, "transport") && seen < 1 && (value == "tcp" || value == "sctp" || value == "udp"):
+			parts.Transport, seen = value, 1
+		case strings.EqualFold(name, "protocol") && seen < 2 && (value == "diameter" || value == "radius" || value == "tacacs+"):
+			parts.Protocol, seen = value, 2
+		default:
+			return parts, fmt.Errorf("%w: parameter %q of %q", InvalidDiameterURIError, param, uri)
+		}
+	}
+	if parts.Transport == "udp" && parts.Protocol == "diameter" {
+		return parts, fmt.Errorf("%w: %q runs Diameter over UDP", InvalidDiameterURIError, uri)
+	}
+	return parts, nil
+}
+
+// cutPrefixFold is strings.CutPrefix matching prefix without regard to
+// case.
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
+// String returns the DiameterURI made of p, with every component spelt
+// out.
+func (p DiameterURIParts) String() string {
+	scheme := "aaa://"
+	if p.Secure {
+		scheme = "aaas://"
+	}
+	return scheme + p.FQDN + ":" + strconv.FormatUint(uint64(p.Port), 10) +
+		";transport=" + p.Transport + ";protocol=" + p.Protocol
+}
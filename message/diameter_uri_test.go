@@ -0,0 +1,53 @@
+package message
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseDiameterURI(t *testing.T) {
+	for _, tc := range []struct {
+		uri  string
+		want DiameterURIParts
+	}{
+		{"aaa://host.example.com;transport=tcp", DiameterURIParts{false, "host.example.com", 3868, "tcp", "diameter"}},
+		{"aaa://host.example.com:6666;transport=tcp", DiameterURIParts{false, "host.example.com", 6666, "tcp", "diameter"}},
+		{"aaa://host.example.com;protocol=diameter", DiameterURIParts{false, "host.example.com", 3868, "tcp", "diameter"}},
+		{"aaa://host.example.com:6666;transport=tcp;protocol=diameter", DiameterURIParts{false, "host.example.com", 6666, "tcp", "diameter"}},
+		{"aaa://host.example.com:1813;transport=udp;protocol=radius", DiameterURIParts{false, "host.example.com", 1813, "udp", "radius"}},
+		{"aaas://host.example.com", DiameterURIParts{true, "host.example.com", 5658, "tcp", "diameter"}},
+		{"AAA://host.example.com;Transport=SCTP", DiameterURIParts{false, "host.example.com", 3868, "sctp", "diameter"}},
+	} {
+		got, err := ParseDiameterURI(tc.uri)
+		if err != nil {
+			t.Errorf("ParseDiameterURI(%q): %v", tc.uri, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseDiameterURI(%q) = %+v, want %+v", tc.uri, got, tc.want)
+		}
+		if again, err := ParseDiameterURI(got.String()); err != nil || again != got {
+			t.Errorf("%q formats as %q, parsing as %+v, %v", tc.uri, got.String(), again, err)
+		}
+	}
+
+	for _, uri := range []string{
+		"host.example.com",
+		"http://host.example.com",
+		"aaa://",
+		"aaa://:3868",
+		"aaa://host..example.com",
+		"aaa://host.example.com:",
+		"aaa://host.example.com:65536",
+		"aaa://host.example.com:+1",
+		"aaa://host.example.com;",
+		"aaa://host.example.com;transport=quic",
+		"aaa://host.example.com;protocol=diameter;transport=tcp",
+		"aaa://host.example.com;transport=tcp;transport=tcp",
+		"aaa://host.example.com;transport=udp",
+	} {
+		if _, err := ParseDiameterURI(uri); !errors.Is(err, InvalidDiameterURIError) {
+			t.Errorf("ParseDiameterURI(%q) = %v, want InvalidDiameterURIError", uri, err)
+		}
+	}
+}
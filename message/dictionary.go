@@ -0,0 +1,344 @@
+// Command dictionary
+package message
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// Command describes a command registered in the command dictionary.
+type Command struct {
+	Code          uint32
+	ApplicationID uint32
+	// Accounting marks commands that are routed using the accounting
+	// rather than the authentication applications of a peer.
+	Accounting bool
+	// FixedAVPs lists the AVPs that must lead the message, in this order,
+	// when they are present. Unregistered commands use Session-Id.
+	FixedAVPs []uint32
+	// ForbiddenAVPs lists the AVPs that must not appear in the command.
+	ForbiddenAVPs []uint32
+	// RequiredAVPs and OptionalAVPs describe the AVPs of the request.
+	RequiredAVPs []uint32
+	OptionalAVPs []uint32
+	// Sessions, when set, tells which requests of the command open and
+	// end sessions, see RequestSessionRole.
+	Sessions *SessionRule
+}
+
+// defaultFixedAVPs applies to commands without a dictionary entry: RFC 6733
+// section 8.8 requires Session-Id to be the first AVP whenever present.
+var defaultFixedAVPs = []uint32{AVP_SESSION_ID}
+
+// commandKey identifies a command in the dictionary: applications such as
+// Credit-Control and Gx share command codes.
+type commandKey struct {
+	applicationID uint32
+	code          uint32
+}
+
+var (
+	commandsMu sync.RWMutex
+	commands   = map[commandKey]Command{
+		{APPLICATION_ID_DIAMETER_COMMON_MESSAGES, COMMAND_CODE_CAPABILITIES_EXCHANGE}: {
+			Code:          COMMAND_CODE_CAPABILITIES_EXCHANGE,
+			ApplicationID: APPLICATION_ID_DIAMETER_COMMON_MESSAGES,
+			ForbiddenAVPs: []uint32{AVP_SESSION_ID},
+			RequiredAVPs: []uint32{
+				AVP_ORIGIN_HOST,
+				AVP_ORIGIN_REALM,
+				AVP_HOST_IP_ADDRESS,
+				AVP_VENDOR_ID,
+				AVP_PRODUCT_NAME,
+			},
+			OptionalAVPs: []uint32{
+				AVP_ORIGIN_STATE_ID,
+				AVP_SUPPORTED_VENDOR_ID,
+				AVP_AUTH_APPLICATION_ID,
+				AVP_INBAND_SECURITY_ID,
+				AVP_ACCT_APPLICATION_ID,
+				AVP_VENDOR_SPECIFIC_APPLICATION_ID,
+				AVP_FIRMWARE_REVISION,
+			},
+		},
+		{APPLICATION_ID_DIAMETER_COMMON_MESSAGES, COMMAND_CODE_DEVICE_WATCHDOG}: {
+			Code:          COMMAND_CODE_DEVICE_WATCHDOG,
+			ApplicationID: APPLICATION_ID_DIAMETER_COMMON_MESSAGES,
+			ForbiddenAVPs: []uint32{AVP_SESSION_ID},
+			RequiredAVPs:  []uint32{AVP_ORIGIN_HOST, AVP_ORIGIN_REALM},
+			OptionalAVPs:  []uint32{AVP_ORIGIN_STATE_ID},
+		},
+		{APPLICATION_ID_DIAMETER_COMMON_MESSAGES, COMMAND_CODE_DISCONNECT_PEER}: {
+			Code:          COMMAND_CODE_DISCONNECT_PEER,
+			ApplicationID: APPLICATION_ID_DIAMETER_COMMON_MESSAGES,
+			ForbiddenAVPs: []uint32{AVP_SESSION_ID},
+			RequiredAVPs:  []uint32{AVP_ORIGIN_HOST, AVP_ORIGIN_REALM, AVP_DISCONNECT_CAUSE},
+		},
+		{APPLICATION_ID_BASE_ACCOUNTING, COMMAND_CODE_ACCOUNTING}: {
+			Code:          COMMAND_CODE_ACCOUNTING,
+			ApplicationID: APPLICATION_ID_BASE_ACCOUNTING,
+			Accounting:    true,
+			FixedAVPs:     []uint32{AVP_SESSION_ID},
+			RequiredAVPs: []uint32{
+				AVP_SESSION_ID,
+				AVP_ORIGIN_HOST,
+				AVP_ORIGIN_REALM,
+				AVP_DESTINATION_REALM,
+				AVP_ACCOUNTING_RECORD_TYPE,
+				AVP_ACCOUNTING_RECORD_NUMBER,
+			},
+			Sessions: &SessionRule{
+				AVP: AVP_ACCOUNTING_RECORD_TYPE,
+				Roles: map[uint32]SessionRole{
+					START_RECORD:   SessionOpen,
+					INTERIM_RECORD: SessionUpdate,
+					STOP_RECORD:    SessionEnd,
+				},
+			},
+			OptionalAVPs: []uint32{
+				AVP_ACCT_APPLICATION_ID,
+				AVP_VENDOR_SPECIFIC_APPLICATION_ID,
+				AVP_USER_NAME,
+				AVP_DESTINATION_HOST,
+				AVP_EVENT_TIMESTAMP,
+				AVP_ORIGIN_STATE_ID,
+			},
+		},
+		{APPLICATION_ID_CREDIT_CONTROL, COMMAND_CODE_CREDIT_CONTROL}: {
+			Code:          COMMAND_CODE_CREDIT_CONTROL,
+			ApplicationID: APPLICATION_ID_CREDIT_CONTROL,
+			FixedAVPs:     []uint32{AVP_SESSION_ID},
+			RequiredAVPs: []uint32{
+				AVP_SESSION_ID,
+				AVP_ORIGIN_HOST,
+				AVP_ORIGIN_REALM,
+				AVP_DESTINATION_REALM,
+				AVP_AUTH_APPLICATION_ID,
+			},
+			OptionalAVPs: []uint32{
+				AVP_DESTINATION_HOST,
+				AVP_USER_NAME,
+				AVP_ORIGIN_STATE_ID,
+				AVP_EVENT_TIMESTAMP,
+			},
+			Sessions: &SessionRule{
+				AVP: AVP_CC_REQUEST_TYPE,
+				Roles: map[uint32]SessionRole{
+					INITIAL_REQUEST:     SessionOpen,
+					UPDATE_REQUEST:      SessionUpdate,
+					TERMINATION_REQUEST: SessionEnd,
+				},
+			},
+		},
+	}
+)
+
+// RegisterCommand adds cmd to the command dictionary, replacing any
+// previous registration of the same command code for the same
+// application. A command code may be registered for several applications.
+func RegisterCommand(cmd Command) {
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+	commands[commandKey{cmd.ApplicationID, cmd.Code}] = cmd
+}
+
+// LookupCommand returns the dictionary entry for code when a single
+// application registers it.
+func LookupCommand(code uint32) (Command, bool) {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+	return lookupCommand(code)
+}
+
+// lookupCommand is LookupCommand for callers holding commandsMu.
+func lookupCommand(code uint32) (Command, bool) {
+	var found Command
+	n := 0
+	for key, cmd := range commands {
+		if key.code == code {
+			found = cmd
+			n++
+		}
+	}
+	return found, n == 1
+}
+
+// LookupApplicationCommand returns the dictionary entry for code in
+// application appID, or the one of LookupCommand when appID does not
+// register code.
+func LookupApplicationCommand(appID, code uint32) (Command, bool) {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+	if cmd, ok := commands[commandKey{appID, code}]; ok {
+		return cmd, true
+	}
+	return lookupCommand(code)
+}
+
+// lookupMessageCommand returns the dictionary entry for the command and
+// application of msg.
+func lookupMessageCommand(msg *DiameterMessage) (Command, bool) {
+	return LookupApplicationCommand(msg.Header.ApplicationID, msg.Header.CommandCode)
+}
+
+// commandApplications returns the applications registering code, and
+// whether appID registers any command at all.
+func commandApplications(code, appID uint32) (apps []uint32, knownApp bool) {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+	for key := range commands {
+		if key.code == code {
+			apps = append(apps, key.applicationID)
+		}
+		knownApp = knownApp || key.applicationID == appID
+	}
+	slices.Sort(apps)
+	return apps, knownApp
+}
+
+// CheckDictionary reports every AVP referenced by a registered command
+// that has no type in the AVP dictionary, and so would only ever be
+// decoded as an OctetString.
+func CheckDictionary() error {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+	var errs []error
+	keys := slices.SortedFunc(maps.Keys(commands), func(a, b commandKey) int {
+		return cmp.Or(cmp.Compare(a.code, b.code), cmp.Compare(a.applicationID, b.applicationID))
+	})
+	for _, key := range keys {
+		cmd := commands[key]
+		avps := slices.Concat(cmd.FixedAVPs, cmd.ForbiddenAVPs, cmd.RequiredAVPs, cmd.OptionalAVPs)
+		slices.Sort(avps)
+		for _, avp := range slices.Compact(avps) {
+			if _, ok := avpTypeMap[avp]; !ok {
+				errs = append(errs, fmt.Errorf("command %d of application %d: %w", key.code, key.applicationID, &AVPError{Code: avp, Reason: UntypedAVPError}))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fixedAVPs returns the AVPs that must lead msg.
+func fixedAVPs(msg *DiameterMessage) []uint32 {
+	if cmd, ok := lookupMessageCommand(msg); ok {
+		return cmd.FixedAVPs
+	}
+	return defaultFixedAVPs
+}
+
+// IsAccountingCommand reports whether code is registered as an accounting
+// command by a single application.
+func IsAccountingCommand(code uint32) bool {
+	cmd, ok := LookupCommand(code)
+	return ok && cmd.Accounting
+}
+
+type requestOptions struct {
+	applicationID    uint32
+	hasApplicationID bool
+	strict           bool
+	stateless        bool
+	avps             []*AVP
+	ids              IDGenerator
+}
+
+// RequestOption configures a request built by NewRequest.
+type RequestOption func(*requestOptions)
+
+// WithApplication sets the Application-ID of the request explicitly.
+func WithApplication(id uint32) RequestOption {
+	return func(o *requestOptions) {
+		o.applicationID = id
+		o.hasApplicationID = true
+	}
+}
+
+// WithAVPs adds AVPs to the request.
+func WithAVPs(avps ...*AVP) RequestOption {
+	return func(o *requestOptions) {
+		o.avps = append(o.avps, avps...)
+	}
+}
+
+// WithIDGenerator sets the generator of the Hop-by-Hop and End-to-End
+// Identifiers of the request.
+func WithIDGenerator(ids IDGenerator) RequestOption {
+	return func(o *requestOptions) {
+		o.ids = ids
+	}
+}
+
+// WithStrictApplication makes NewRequest fail when an explicit
+// Application-ID contradicts the command dictionary instead of logging a
+// warning. An Application-ID contradicts the dictionary when the
+// application registers commands, but not the one requested.
+func WithStrictApplication() RequestOption {
+	return func(o *requestOptions) {
+		o.strict = true
+	}
+}
+
+// WithStateless marks the request as belonging to a stateless session by
+// adding Auth-Session-State set to NO_STATE_MAINTAINED, unless the request
+// already carries an Auth-Session-State. Base protocol and accounting
+// commands, which have no authorization session, are left alone.
+func WithStateless() RequestOption {
+	return func(o *requestOptions) {
+		o.stateless = true
+	}
+}
+
+// NewRequest creates a request for the given command code. The
+// Application-ID is taken from WithApplication when given, otherwise it is
+// inferred from the command dictionary when a single application registers
+// the command, falling back to 0.
+func NewRequest(code uint32, opts ...RequestOption) (*DiameterMessage, error) {
+	o := requestOptions{ids: DefaultIDGenerator}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	appID := o.applicationID
+	apps, knownApp := commandApplications(code, appID)
+	switch {
+	case !o.hasApplicationID && len(apps) == 1:
+		appID = apps[0]
+	case o.hasApplicationID && knownApp && len(apps) > 0 && !slices.Contains(apps, appID):
+		if o.strict {
+			return nil, ApplicationMismatchError
+		}
+		log.Printf(
+			"Command %d is registered for applications %v but was built for application %d",
+			code,
+			apps,
+			appID,
+		)
+	}
+
+	if o.stateless && appID != APPLICATION_ID_DIAMETER_COMMON_MESSAGES && !IsAccountingCommand(code) &&
+		!slices.ContainsFunc(o.avps, func(a *AVP) bool { return a.Code == AVP_AUTH_SESSION_STATE }) {
+		state, err := NewAVP(AVP_AUTH_SESSION_STATE, NO_STATE_MAINTAINED, MANDATORY_FLAG)
+		if err != nil {
+			return nil, err
+		}
+		o.avps = append(o.avps, state)
+	}
+
+	return &DiameterMessage{
+		Header: &DiameterHeader{
+			Version:       DIAMETER_VERSION,
+			CommandFlags:  FlagRequest,
+			CommandCode:   code,
+			ApplicationID: appID,
+			HopByHopID:    o.ids.HopByHopID(),
+			EndToEndID:    o.ids.EndToEndID(),
+		},
+		AVPs: o.avps,
+	}, nil
+}
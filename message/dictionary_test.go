@@ -0,0 +1,121 @@
+package message
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// registerTestCommand registers cmd for the duration of the test.
+func registerTestCommand(t *testing.T, cmd Command) {
+	t.Helper()
+	RegisterCommand(cmd)
+	t.Cleanup(func() {
+		commandsMu.Lock()
+		defer commandsMu.Unlock()
+		delete(commands, commandKey{cmd.ApplicationID, cmd.Code})
+	})
+}
+
+func TestNewRequestApplication(t *testing.T) {
+	const unknownCommand, sharedCommand = 9999, 9997
+	// sharedCommand is registered for two applications, so its application
+	// cannot be inferred.
+	registerTestCommand(t, Command{Code: sharedCommand, ApplicationID: APPLICATION_ID_CREDIT_CONTROL})
+	registerTestCommand(t, Command{Code: sharedCommand, ApplicationID: APPLICATION_ID_3GPP_RX})
+	for _, tc := range []struct {
+		name string
+		code uint32
+		opts []RequestOption
+		want uint32
+		err  error
+	}{
+		{"inferred", COMMAND_CODE_CREDIT_CONTROL, nil, APPLICATION_ID_CREDIT_CONTROL, nil},
+		{"inferred base protocol", COMMAND_CODE_DWR, nil, APPLICATION_ID_DIAMETER_COMMON_MESSAGES, nil},
+		{"explicit", COMMAND_CODE_CREDIT_CONTROL, []RequestOption{WithApplication(APPLICATION_ID_CREDIT_CONTROL)}, APPLICATION_ID_CREDIT_CONTROL, nil},
+		{"unknown command", unknownCommand, nil, 0, nil},
+		{"unknown command explicit", unknownCommand, []RequestOption{WithApplication(APPLICATION_ID_3GPP_GX)}, APPLICATION_ID_3GPP_GX, nil},
+		// Gx reuses the Credit-Control command code.
+		{"unknown application", COMMAND_CODE_CREDIT_CONTROL, []RequestOption{WithApplication(APPLICATION_ID_3GPP_GX), WithStrictApplication()}, APPLICATION_ID_3GPP_GX, nil},
+		{"shared command", sharedCommand, nil, 0, nil},
+		{"shared command explicit", sharedCommand, []RequestOption{WithApplication(APPLICATION_ID_3GPP_RX), WithStrictApplication()}, APPLICATION_ID_3GPP_RX, nil},
+		{"conflicting", COMMAND_CODE_CREDIT_CONTROL, []RequestOption{WithApplication(APPLICATION_ID_BASE_ACCOUNTING)}, APPLICATION_ID_BASE_ACCOUNTING, nil},
+		{"conflicting strict", COMMAND_CODE_CREDIT_CONTROL, []RequestOption{WithApplication(APPLICATION_ID_BASE_ACCOUNTING), WithStrictApplication()}, 0, ApplicationMismatchError},
+		{"matching strict", COMMAND_CODE_CREDIT_CONTROL, []RequestOption{WithApplication(APPLICATION_ID_CREDIT_CONTROL), WithStrictApplication()}, APPLICATION_ID_CREDIT_CONTROL, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := NewRequest(tc.code, tc.opts...)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("NewRequest: error %v, want %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewRequest: %v", err)
+			}
+			if req.Header.ApplicationID != tc.want {
+				t.Errorf("Application-ID %d, want %d", req.Header.ApplicationID, tc.want)
+			}
+			if !req.IsRequest() || req.Header.CommandCode != tc.code {
+				t.Errorf("built %s with flags %s", req.CommandName(), req.Header.CommandFlags)
+			}
+		})
+	}
+}
+
+func TestWithStateless(t *testing.T) {
+	maintained := MustNewAVP(AVP_AUTH_SESSION_STATE, STATE_MAINTAINED, MANDATORY_FLAG)
+	for _, tc := range []struct {
+		name string
+		code uint32
+		opts []RequestOption
+		// want is the Auth-Session-State of the request, none when empty.
+		want []uint32
+	}{
+		{"stateful", COMMAND_CODE_CREDIT_CONTROL, nil, nil},
+		{"stateless", COMMAND_CODE_CREDIT_CONTROL, []RequestOption{WithStateless()}, []uint32{NO_STATE_MAINTAINED}},
+		{"already set", COMMAND_CODE_CREDIT_CONTROL, []RequestOption{WithStateless(), WithAVPs(maintained)}, []uint32{STATE_MAINTAINED}},
+		{"base protocol", COMMAND_CODE_DWR, []RequestOption{WithStateless()}, nil},
+		{"accounting", COMMAND_CODE_ACCOUNTING, []RequestOption{WithStateless()}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := NewRequest(tc.code, tc.opts...)
+			if err != nil {
+				t.Fatalf("NewRequest: %v", err)
+			}
+			var states []uint32
+			for _, avp := range req.AVPs {
+				if avp.Code == AVP_AUTH_SESSION_STATE {
+					state, err := avp.Uint32()
+					if err != nil {
+						t.Fatal(err)
+					}
+					states = append(states, state)
+				}
+			}
+			if !slices.Equal(states, tc.want) {
+				t.Errorf("Auth-Session-State %v, want %v", states, tc.want)
+			}
+			if got, want := req.IsStateless(), slices.Equal(tc.want, []uint32{NO_STATE_MAINTAINED}); got != want {
+				t.Errorf("IsStateless() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestCheckDictionary(t *testing.T) {
+	if err := CheckDictionary(); err != nil {
+		t.Fatalf("CheckDictionary of the built-in dictionary: %v", err)
+	}
+	const code, untyped = 9999, 4_000_000
+	registerTestCommand(t, Command{Code: code, RequiredAVPs: []uint32{AVP_SESSION_ID, untyped}, OptionalAVPs: []uint32{untyped}})
+	err := CheckDictionary()
+	var avpErr *AVPError
+	if !errors.Is(err, UntypedAVPError) || !errors.As(err, &avpErr) || avpErr.Code != untyped {
+		t.Fatalf("CheckDictionary = %v, want AVP %d reported untyped", err, untyped)
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 1 {
+		t.Errorf("%d problems reported, want AVP %d once: %v", n, untyped, err)
+	}
+}
@@ -0,0 +1,34 @@
+// Disconnect-Cause of Disconnect-Peer-Requests
+package message
+
+import "fmt"
+
+var disconnectCauseNames = map[uint32]string{
+	DISCONNECT_CAUSE_REBOOTING:                  "REBOOTING",
+	DISCONNECT_CAUSE_BUSY:                       "BUSY",
+	DISCONNECT_CAUSE_DO_NOT_WANT_TO_TALK_TO_YOU: "DO_NOT_WANT_TO_TALK_TO_YOU",
+}
+
+// DisconnectCauseName returns the name RFC 6733 section 5.4.3 gives
+// cause, or its number for values it does not define.
+func DisconnectCauseName(cause uint32) string {
+	if name, ok := disconnectCauseNames[cause]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", cause)
+}
+
+// NewDisconnectCauseAVP builds the Disconnect-Cause AVP of a DPR, cause
+// being one of the DISCONNECT_CAUSE values.
+func NewDisconnectCauseAVP(cause uint32) (*AVP, error) {
+	return NewAVP(AVP_DISCONNECT_CAUSE, cause, MANDATORY_FLAG)
+}
+
+// GetDisconnectCause returns the Disconnect-Cause of msg, a DPR.
+func GetDisconnectCause(msg *DiameterMessage) (uint32, error) {
+	avp := msg.GetAVP(AVP_DISCONNECT_CAUSE)
+	if avp == nil {
+		return 0, MissingDisconnectCauseError
+	}
+	return avp.Uint32()
+}
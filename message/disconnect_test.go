@@ -0,0 +1,44 @@
+package message
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGetDisconnectCause(t *testing.T) {
+	node := Node{OriginHost: "server.example.com", OriginRealm: "example.com"}
+	for _, cause := range []uint32{DISCONNECT_CAUSE_REBOOTING, DISCONNECT_CAUSE_BUSY, DISCONNECT_CAUSE_DO_NOT_WANT_TO_TALK_TO_YOU, 7} {
+		dpr, err := node.BuildDPR(cause)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := GetDisconnectCause(roundTrip(t, dpr)); err != nil || got != cause {
+			t.Errorf("GetDisconnectCause = %d, %v; want %d", got, err, cause)
+		}
+	}
+	dpr, err := node.BuildDPR(DISCONNECT_CAUSE_BUSY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dpr.AVPs = dpr.AVPs[:0]
+	if _, err := GetDisconnectCause(dpr); !errors.Is(err, MissingDisconnectCauseError) {
+		t.Errorf("DPR without Disconnect-Cause: %v, want MissingDisconnectCauseError", err)
+	}
+	avp, err := NewDisconnectCauseAVP(DISCONNECT_CAUSE_BUSY)
+	if err != nil || avp.Code != AVP_DISCONNECT_CAUSE || avp.Flags != MANDATORY_FLAG {
+		t.Errorf("NewDisconnectCauseAVP = %+v, %v", avp, err)
+	}
+}
+
+func TestDisconnectCauseName(t *testing.T) {
+	for cause, want := range map[uint32]string{
+		DISCONNECT_CAUSE_REBOOTING:                  "REBOOTING",
+		DISCONNECT_CAUSE_BUSY:                       "BUSY",
+		DISCONNECT_CAUSE_DO_NOT_WANT_TO_TALK_TO_YOU: "DO_NOT_WANT_TO_TALK_TO_YOU",
+		7: "7",
+	} {
+		if got := DisconnectCauseName(cause); got != want {
+			t.Errorf("DisconnectCauseName(%d) = %q, want %q", cause, got, want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Message equality and field-by-field differences
+package message
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// EqualOption changes what Equal and Diff compare.
+type EqualOption func(*equalOptions)
+
+type equalOptions struct {
+	ignoreIDs   bool
+	ignoreOrder bool
+	only        map[uint32]bool
+}
+
+// IgnoreIDs leaves the Hop-by-Hop and End-to-End Identifiers out of the
+// comparison, as they differ between a message and its retransmission
+// through another path.
+func IgnoreIDs() EqualOption {
+	return func(o *equalOptions) {
+		o.ignoreIDs = true
+	}
+}
+
+// IgnoreAVPOrder compares AVPs, including the members of Grouped AVPs,
+// regardless of their position. Repeated AVPs are still paired in order.
+func IgnoreAVPOrder() EqualOption {
+	return func(o *equalOptions) {
+		o.ignoreOrder = true
+	}
+}
+
+// OnlyAVPs compares only the top-level AVPs with the given codes. The
+// header is still compared.
+func OnlyAVPs(codes ...uint32) EqualOption {
+	return func(o *equalOptions) {
+		if o.only == nil {
+			o.only = make(map[uint32]bool, len(codes))
+		}
+		for _, code := range codes {
+			o.only[code] = true
+		}
+	}
+}
+
+// Equal reports whether a and b carry the same header fields and AVPs.
+// Message and AVP lengths are not compared, as they follow from the
+// contents; AVP values are compared by their encoding.
+func Equal(a, b *DiameterMessage, opts ...EqualOption) bool {
+	return len(differences(a, b, opts)) == 0
+}
+
+// Diff describes how b differs from a, one field per line, or returns an
+// empty string when Equal reports them equal with the same options.
+func Diff(a, b *DiameterMessage, opts ...EqualOption) string {
+	return strings.Join(differences(a, b, opts), "\n")
+}
+
+func differences(a, b *DiameterMessage, opts []EqualOption) []string {
+	var o equalOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	switch {
+	case a == nil && b == nil:
+		return nil
+	case a == nil:
+		return []string{"message: nil != non-nil"}
+	case b == nil:
+		return []string{"message: non-nil != nil"}
+	}
+	d := &differ{opts: o}
+	d.header(a.Header, b.Header)
+	d.avps("", filterAVPs(a.AVPs, o.only), filterAVPs(b.AVPs, o.only))
+	return d.lines
+}
+
+type differ struct {
+	opts  equalOptions
+	lines []string
+}
+
+func (d *differ) addf(format string, args ...interface{}) {
+	d.lines = append(d.lines, fmt.Sprintf(format, args...))
+}
+
+func (d *differ) header(a, b *DiameterHeader) {
+	if a == nil || b == nil {
+		if a != b {
+			d.addf("header: missing in one message")
+		}
+		return
+	}
+	if a.Version != b.Version {
+		d.addf("header Version: %d != %d", a.Version, b.Version)
+	}
+	if a.CommandFlags != b.CommandFlags {
+		d.addf("header CommandFlags: %s != %s", a.CommandFlags, b.CommandFlags)
+	}
+	if a.CommandCode != b.CommandCode {
+		d.addf("header CommandCode: %d != %d", a.CommandCode, b.CommandCode)
+	}
+	if a.ApplicationID != b.ApplicationID {
+		d.addf("header ApplicationID: %d != %d", a.ApplicationID, b.ApplicationID)
+	}
+	if d.opts.ignoreIDs {
+		return
+	}
+	if a.HopByHopID != b.HopByHopID {
+		d.addf("header HopByHopID: %d != %d", a.HopByHopID, b.HopByHopID)
+	}
+	if a.EndToEndID != b.EndToEndID {
+		d.addf("header EndToEndID: %d != %d", a.EndToEndID, b.EndToEndID)
+	}
+}
+
+// avps pairs the n-th occurrence of an AVP in a with the n-th occurrence
+// of the same AVP in b, compares the pairs and reports the AVPs left
+// over. Unless order is ignored, the sequences are then checked position
+// by position.
+func (d *differ) avps(path string, a, b []*AVP) {
+	type key struct{ vendor, code uint32 }
+	byKey := make(map[key][]*AVP)
+	for _, avp := range b {
+		k := key{avp.VendorID, avp.Code}
+		byKey[k] = append(byKey[k], avp)
+	}
+	seen := make(map[key]int)
+	for _, avp := range a {
+		k := key{avp.VendorID, avp.Code}
+		n := seen[k]
+		seen[k]++
+		name := avpPath(path, avp, n)
+		if n >= len(byKey[k]) {
+			d.addf("%s: only in first message", name)
+			continue
+		}
+		d.avp(name, avp, byKey[k][n])
+	}
+	extra := make(map[key]int)
+	for _, avp := range b {
+		k := key{avp.VendorID, avp.Code}
+		n := extra[k]
+		extra[k]++
+		if n >= seen[k] {
+			d.addf("%s: only in second message", avpPath(path, avp, n))
+		}
+	}
+	if d.opts.ignoreOrder || len(a) != len(b) {
+		return
+	}
+	for i := range a {
+		if a[i].Code != b[i].Code || a[i].VendorID != b[i].VendorID {
+			d.addf("%sAVP order: position %d holds %s != %s", pathPrefix(path), i,
+				avpLabel(a[i]), avpLabel(b[i]))
+			return
+		}
+	}
+}
+
+func (d *differ) avp(name string, a, b *AVP) {
+	if a.Flags != b.Flags {
+		d.addf("%s flags: 0x%02x != 0x%02x", name, a.Flags, b.Flags)
+	}
+	ga, aok := a.Data.(*Grouped)
+	gb, bok := b.Data.(*Grouped)
+	if aok && bok {
+		d.avps(name, ga.AVPs, gb.AVPs)
+		return
+	}
+	if !sameData(a.Data, b.Data) {
+		d.addf("%s: %s != %s", name, dataString(a.Data), dataString(b.Data))
+	}
+}
+
+func sameData(a, b AVPData) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	ea, errA := a.Encode()
+	eb, errB := b.Encode()
+	if errA != nil || errB != nil {
+		return a.String() == b.String()
+	}
+	return bytes.Equal(ea, eb)
+}
+
+func dataString(data AVPData) string {
+	if data == nil {
+		return "<nil>"
+	}
+	return data.String()
+}
+
+func filterAVPs(avps []*AVP, only map[uint32]bool) []*AVP {
+	if only == nil {
+		return avps
+	}
+	var kept []*AVP
+	for _, avp := range avps {
+		if only[avp.Code] {
+			kept = append(kept, avp)
+		}
+	}
+	return kept
+}
+
+func avpLabel(avp *AVP) string {
+	return fmt.Sprintf("%s(%d)", AVPName(avp.Code, avp.VendorID), avp.Code)
+}
+
+// avpPath names the n-th occurrence of avp below path, adding the index
+// only for repeated AVPs.
+func avpPath(path string, avp *AVP, n int) string {
+	label := avpLabel(avp)
+	if n > 0 {
+		label = fmt.Sprintf("%s[%d]", label, n)
+	}
+	return pathPrefix(path) + label
+}
+
+func pathPrefix(path string) string {
+	if path == "" {
+		return ""
+	}
+	return path + "/"
+}
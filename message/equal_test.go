@@ -0,0 +1,172 @@
+package message
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// sortAVPs orders avps, and the members of the Grouped ones, by
+// descending code, keeping repeated AVPs in their order. It reports
+// whether any order changed.
+func sortAVPs(avps []*AVP) bool {
+	changed := false
+	for _, avp := range avps {
+		if g, ok := avp.Data.(*Grouped); ok && sortAVPs(g.AVPs) {
+			changed = true
+		}
+	}
+	before := slices.Clone(avps)
+	slices.SortStableFunc(avps, func(a, b *AVP) int { return cmp.Compare(b.Code, a.Code) })
+	return changed || !slices.Equal(before, avps)
+}
+
+// TestEqualCorpus compares every fixture that decodes with a second
+// decoding of it, reordered or with other identifiers.
+func TestEqualCorpus(t *testing.T) {
+	decode := func(frame []byte) *DiameterMessage {
+		msg, err := DecodeMessage(frame, WithDecodeMode(DecodeTolerant))
+		if err != nil {
+			return nil
+		}
+		return msg
+	}
+	decoded, reordered := 0, 0
+	for name, frame := range corpus(t) {
+		a := decode(frame)
+		if a == nil {
+			continue
+		}
+		decoded++
+		b := decode(frame)
+		if !Equal(a, b) {
+			t.Errorf("%s: not equal to itself:\n%s", name, Diff(a, b))
+		}
+
+		b.Header.HopByHopID++
+		b.Header.EndToEndID++
+		if Equal(a, b) {
+			t.Errorf("%s: equal with other identifiers", name)
+		}
+		if !Equal(a, b, IgnoreIDs()) {
+			t.Errorf("%s: not equal ignoring the identifiers:\n%s", name, Diff(a, b, IgnoreIDs()))
+		}
+
+		b = decode(frame)
+		if !sortAVPs(b.AVPs) {
+			continue
+		}
+		reordered++
+		if diff := Diff(a, b); !strings.Contains(diff, "AVP order") {
+			t.Errorf("%s: reordered AVPs reported as %q", name, diff)
+		}
+		if !Equal(a, b, IgnoreAVPOrder()) {
+			t.Errorf("%s: not equal ignoring the order:\n%s", name, Diff(a, b, IgnoreAVPOrder()))
+		}
+	}
+	if decoded < 4 || reordered == 0 {
+		t.Errorf("only %d fixtures decoded, %d reordered", decoded, reordered)
+	}
+}
+
+// TestEqualPadding checks that messages differing only in the padding of
+// an AVP are equal, where their encodings are not.
+func TestEqualPadding(t *testing.T) {
+	frame := fixture(t, true, rawAVP(AVP_USER_NAME, MANDATORY_FLAG, 11, []byte("bob")))
+	padded := slices.Clone(frame)
+	padded[len(padded)-1] = 0xff
+	a, err := DecodeMessage(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := DecodeMessage(padded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(a, b) {
+		t.Errorf("not equal:\n%s", Diff(a, b))
+	}
+}
+
+func TestDiff(t *testing.T) {
+	base := func() *DiameterMessage {
+		msg, err := DecodeMessage(wiresharkCCR(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	qos := func(msg *DiameterMessage) *Grouped { return group(t, msg.GetAVP(AVP_QOS_INFORMATION)) }
+	for _, tc := range []struct {
+		name   string
+		change func(*DiameterMessage)
+		opts   []EqualOption
+		want   []string
+	}{
+		{"unchanged", func(*DiameterMessage) {}, nil, nil},
+		{"command", func(m *DiameterMessage) { m.Header.CommandCode = COMMAND_CODE_ACCOUNTING }, nil,
+			[]string{"header CommandCode: 272 != 271"}},
+		{"flags", func(m *DiameterMessage) { m.Header.CommandFlags |= COMMAND_FLAG_RETRANSMITTED }, nil,
+			[]string{"header CommandFlags: "}},
+		{"value", func(m *DiameterMessage) {
+			m.AVPs[1] = MustNewAVP(AVP_ORIGIN_HOST, "other.example.com", MANDATORY_FLAG)
+		}, nil, []string{"Origin-Host(264): client.example.com != other.example.com"}},
+		{"AVP flags", func(m *DiameterMessage) { m.GetAVP(AVP_ORIGIN_REALM).Flags = 0 }, nil,
+			[]string{"Origin-Realm(296) flags: 0x40 != 0x00"}},
+		{"grouped member", func(m *DiameterMessage) {
+			g := qos(m)
+			g.AVPs[0] = MustNewAVP(AVP_QOS_CLASS_IDENTIFIER, uint32(5), flags3GPP, VENDOR_3GPP)
+		}, nil, []string{"QoS-Information(1016)/QoS-Class-Identifier(1028): 9 != 5"}},
+		{"member added", func(m *DiameterMessage) {
+			g := qos(m)
+			g.AVPs = append(g.AVPs, MustNewAVP(AVP_MAX_REQUESTED_BANDWIDTH_UL, uint32(1), flags3GPP, VENDOR_3GPP))
+		}, nil, []string{"QoS-Information(1016)/Max-Requested-Bandwidth-UL(516): only in second message"}},
+		{"repeated AVP", func(m *DiameterMessage) {
+			m.AVPs = append(m.AVPs,
+				MustNewAVP(AVP_ROUTE_RECORD, "a.example.com", MANDATORY_FLAG),
+				MustNewAVP(AVP_ROUTE_RECORD, "b.example.com", MANDATORY_FLAG))
+		}, nil, []string{"Route-Record(282): only in second message", "Route-Record(282)[1]: only in second message"}},
+		{"other AVP ignored", func(m *DiameterMessage) {
+			m.AVPs[1] = MustNewAVP(AVP_ORIGIN_HOST, "other.example.com", MANDATORY_FLAG)
+		}, []EqualOption{OnlyAVPs(AVP_SESSION_ID, AVP_ORIGIN_REALM)}, nil},
+		{"header compared with OnlyAVPs", func(m *DiameterMessage) { m.Header.ApplicationID = 0 },
+			[]EqualOption{OnlyAVPs(AVP_SESSION_ID)}, []string{"header ApplicationID: 4 != 0"}},
+		{"order", func(m *DiameterMessage) { m.AVPs[1], m.AVPs[2] = m.AVPs[2], m.AVPs[1] }, nil,
+			[]string{"AVP order: position 1 holds Origin-Host(264) != Origin-Realm(296)"}},
+		{"member order", func(m *DiameterMessage) { slices.Reverse(qos(m).AVPs) }, nil,
+			[]string{"QoS-Information(1016)/AVP order: position 0 holds"}},
+		{"member order ignored", func(m *DiameterMessage) { slices.Reverse(qos(m).AVPs) },
+			[]EqualOption{IgnoreAVPOrder()}, nil},
+	} {
+		a, b := base(), base()
+		tc.change(b)
+		diff := Diff(a, b, tc.opts...)
+		if equal := Equal(a, b, tc.opts...); equal != (diff == "") {
+			t.Errorf("%s: Equal = %v with the difference %q", tc.name, equal, diff)
+		}
+		lines := strings.Split(diff, "\n")
+		if diff == "" {
+			lines = nil
+		}
+		if len(lines) != len(tc.want) {
+			t.Errorf("%s: difference\n%s\nwant %d lines", tc.name, diff, len(tc.want))
+			continue
+		}
+		for i, want := range tc.want {
+			if !strings.HasPrefix(lines[i], want) {
+				t.Errorf("%s: line %q, want %q", tc.name, lines[i], want)
+			}
+		}
+	}
+}
+
+func TestEqualNil(t *testing.T) {
+	msg := newTestCCR(t)
+	if !Equal(nil, nil) || Equal(msg, nil) || Equal(nil, msg) {
+		t.Error("nil messages compared wrongly")
+	}
+	if diff := Diff(nil, msg); diff != "message: nil != non-nil" {
+		t.Errorf("Diff(nil, msg) = %q", diff)
+	}
+}
@@ -1,21 +1,32 @@
 package message
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // Diameter errors
 var (
 	InvalidDiameterVersionError      = errors.New("invalid version")
 	InvalidDiameterHeaderLengthError = errors.New("invalid header length")
+	InvalidCommandFlagsError         = errors.New("invalid command flags")
+	// UnexpectedRequestError reports a message with the 'R' bit set where
+	// an answer was expected, such as a request looped back by the peer.
+	UnexpectedRequestError = errors.New("request received where an answer was expected")
 )
 
 // datatype errors
 var (
-	UnsupportedTypeError = errors.New("unsupported type")
+	UnsupportedTypeError   = errors.New("unsupported type")
+	InvalidConversionError = errors.New("AVP value cannot be converted")
+	ValueOutOfRangeError   = errors.New("AVP value out of range")
 )
 
 // AVP errors
 var (
-	VendorIDRequiredError = errors.New("VendorID is required for vendor specific AVP")
+	VendorIDRequiredError     = errors.New("VendorID is required for vendor specific AVP")
+	InvalidAVPLengthError     = errors.New("invalid AVP length")
+	InvalidAVPDataLengthError = errors.New("invalid AVP data length")
 )
 
 // IPAddr errors
@@ -31,8 +42,162 @@ var (
 // Decoding errors
 var (
 	InvalidMessageLengthError = errors.New("invalid message length for decoding")
+	UnknownMandatoryAVPError  = errors.New("unknown AVP with the mandatory bit set")
+	InvalidUTF8Error          = errors.New("invalid UTF-8 in UTF8String")
+	GroupTooDeepError         = errors.New("grouped AVPs nested too deep")
+)
+
+// Encoding errors
+var (
+	MessageTooLargeError = errors.New("message exceeds the maximum length")
 )
 
 var (
-	InvalidCommandCodeError = errors.New("invalid command code")
+	InvalidCommandCodeError  = errors.New("invalid command code")
+	ApplicationMismatchError = errors.New("application id does not match the command dictionary")
+	MissingOriginHostError   = errors.New("missing Origin-Host AVP")
+	MissingOriginRealmError  = errors.New("missing Origin-Realm AVP")
+	MissingSessionIDError    = errors.New("missing Session-Id AVP")
+	OriginMismatchError      = errors.New("Origin-Host does not match the capabilities exchange")
+	// InvalidDiameterIdentityError reports a name that is not a valid
+	// FQDN and so cannot be used as a DiameterIdentity.
+	InvalidDiameterIdentityError = errors.New("invalid DiameterIdentity")
+	// UntypedAVPError reports an AVP referenced by a registered command
+	// that has no type in the AVP dictionary.
+	UntypedAVPError = errors.New("AVP has no dictionary type")
+	// InvalidDiameterURIError is returned by ParseDiameterURI for a
+	// DiameterURI not in the format of RFC 6733 section 4.3.1.
+	InvalidDiameterURIError = errors.New("invalid DiameterURI")
+	// InvalidVendorApplicationError reports a Vendor-Specific-Application-Id
+	// without a Vendor-Id or without exactly one of Auth-Application-Id
+	// and Acct-Application-Id.
+	InvalidVendorApplicationError = errors.New("invalid Vendor-Specific-Application-Id")
+	// MissingMemberError reports a Grouped AVP lacking a required member.
+	MissingMemberError = errors.New("missing required member AVP")
+	// MissingOriginStateIDError is returned by GetOriginStateID for a
+	// message without Origin-State-Id, which is optional.
+	MissingOriginStateIDError = errors.New("missing Origin-State-Id AVP")
+	// MissingDisconnectCauseError is returned by GetDisconnectCause for a
+	// message without Disconnect-Cause.
+	MissingDisconnectCauseError = errors.New("missing Disconnect-Cause AVP")
+	// InvalidSessionIDError is returned by ParseSessionID for a Session-Id
+	// not in the format of RFC 6733 section 8.8.
+	InvalidSessionIDError = errors.New("invalid Session-Id")
 )
+
+// AVPError reports a problem with a specific AVP. Reason is the underlying
+// error, usually one of the sentinel errors above, which errors.Is
+// matches through the AVPError.
+type AVPError struct {
+	Code   uint32
+	Vendor uint32
+	Reason error
+}
+
+func (e *AVPError) Error() string {
+	if e.Vendor != 0 {
+		return fmt.Sprintf("AVP %d (vendor %d): %v", e.Code, e.Vendor, e.Reason)
+	}
+	return fmt.Sprintf("AVP %d: %v", e.Code, e.Reason)
+}
+
+func (e *AVPError) Unwrap() error {
+	return e.Reason
+}
+
+// PeerError reports an operation on a peer that failed with Err.
+type PeerError struct {
+	Identity PeerIdentity
+	Op       string
+	Err      error
+}
+
+func (e *PeerError) Error() string {
+	if e.Identity.IsZero() {
+		return fmt.Sprintf("%s: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Identity, e.Err)
+}
+
+func (e *PeerError) Unwrap() error {
+	return e.Err
+}
+
+// ProtocolError reports a failure that an answer conveys with ResultCode.
+// Err, when set, is the cause.
+type ProtocolError struct {
+	ResultCode ResultCode
+	Err        error
+}
+
+func (e *ProtocolError) Error() string {
+	name := ResultCodeToName[e.ResultCode]
+	if name == "" {
+		name = fmt.Sprintf("result code %d", e.ResultCode)
+	}
+	if e.Err == nil {
+		return name
+	}
+	return fmt.Sprintf("%s: %v", name, e.Err)
+}
+
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// ResultCodeForError returns the Result-Code of the answer reporting err:
+// the code carried by a ProtocolError or ValidationError, the code RFC
+// 6733 assigns to the sentinel errors of this package, and
+// DIAMETER_UNABLE_TO_COMPLY for anything else. A nil error maps to
+// DIAMETER_SUCCESS.
+func ResultCodeForError(err error) ResultCode {
+	if err == nil {
+		return DIAMETER_SUCCESS
+	}
+	var perr *ProtocolError
+	if errors.As(err, &perr) {
+		return perr.ResultCode
+	}
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		return verr.ResultCode
+	}
+	switch {
+	case errors.Is(err, UnknownMandatoryAVPError):
+		return DIAMETER_AVP_UNSUPPORTED
+	case isLengthError(err):
+		return DIAMETER_INVALID_AVP_LENGTH
+	case errors.Is(err, InvalidDiameterVersionError):
+		return DIAMETER_UNSUPPORTED_VERSION
+	case errors.Is(err, InvalidCommandFlagsError), errors.Is(err, UnexpectedRequestError):
+		return DIAMETER_INVALID_HDR_BITS
+	case errors.Is(err, InvalidMessageLengthError), errors.Is(err, InvalidDiameterHeaderLengthError):
+		return DIAMETER_INVALID_MESSAGE_LENGTH
+	case errors.Is(err, InvalidCommandCodeError):
+		return DIAMETER_COMMAND_UNSUPPORTED
+	case errors.Is(err, ApplicationMismatchError):
+		return DIAMETER_APPLICATION_UNSUPPORTED
+	case errors.Is(err, MissingOriginHostError), errors.Is(err, MissingOriginRealmError), errors.Is(err, MissingMemberError):
+		return DIAMETER_MISSING_AVP
+	}
+	var aerr *AVPError
+	if errors.As(err, &aerr) {
+		return DIAMETER_INVALID_AVP_VALUE
+	}
+	return DIAMETER_UNABLE_TO_COMPLY
+}
+
+func isLengthError(err error) bool {
+	for _, target := range []error{
+		InvalidAVPLengthError,
+		InvalidAVPDataLengthError,
+		InvalidAddressLengthError,
+		InvalidIPv4AddressLengthError,
+		InvalidIPv6AddressLengthError,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
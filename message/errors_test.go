@@ -0,0 +1,125 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// TestDecodeErrorsIsAs checks that decoding errors carry the offending AVP
+// while still matching the sentinel errors.
+func TestDecodeErrorsIsAs(t *testing.T) {
+	shortAppID := rawAVP(AVP_AUTH_APPLICATION_ID, MANDATORY_FLAG, AVPHeaderLength+3, []byte{0, 0, 4})
+	_, err := DecodeMessage(fixture(t, true, shortAppID), WithDecodeMode(DecodeStrict))
+	if !errors.Is(err, InvalidAVPDataLengthError) {
+		t.Errorf("error %v does not match InvalidAVPDataLengthError", err)
+	}
+	var aerr *AVPError
+	if !errors.As(err, &aerr) || aerr.Code != AVP_AUTH_APPLICATION_ID || aerr.Vendor != 0 {
+		t.Fatalf("error %v carries no AVPError for Auth-Application-Id", err)
+	}
+	if ResultCodeForError(err) != DIAMETER_INVALID_AVP_LENGTH {
+		t.Errorf("error %v maps to %v", err, ResultCodeForError(err))
+	}
+
+	vendor := rawAVP(1, VENDOR_FLAG|MANDATORY_FLAG, 200, []byte{0, 0, 0x28, 0xaf})
+	_, err = DecodeMessage(fixture(t, true, vendor))
+	if !errors.As(err, &aerr) || aerr.Code != 1 || aerr.Vendor != 10415 || !errors.Is(err, InvalidAVPLengthError) {
+		t.Errorf("error %v, want an AVPError for AVP 1 of vendor 10415 of invalid length", err)
+	}
+	if got := aerr.Error(); got != fmt.Sprintf("AVP 1 (vendor 10415): %v", aerr.Reason) {
+		t.Errorf("Error() = %q", got)
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	id := NewPeerIdentity("peer.example.com", "example.com")
+	for _, tc := range []struct {
+		name   string
+		err    error
+		target error
+		as     func(error) bool
+		text   string
+	}{
+		{
+			name:   "AVP error",
+			err:    &AVPError{Code: AVP_ORIGIN_HOST, Reason: InvalidDiameterIdentityError},
+			target: InvalidDiameterIdentityError,
+			as:     func(err error) bool { var e *AVPError; return errors.As(err, &e) && e.Code == AVP_ORIGIN_HOST },
+			text:   "AVP 264: invalid DiameterIdentity",
+		},
+		{
+			name:   "peer error",
+			err:    &PeerError{Identity: id, Op: "request", Err: io.EOF},
+			target: io.EOF,
+			as:     func(err error) bool { var e *PeerError; return errors.As(err, &e) && e.Identity == id },
+			text:   "request peer.example.com@example.com: EOF",
+		},
+		{
+			name:   "peer error without identity",
+			err:    &PeerError{Op: "dial", Err: io.EOF},
+			target: io.EOF,
+			as:     func(err error) bool { var e *PeerError; return errors.As(err, &e) && e.Identity.IsZero() },
+			text:   "dial: EOF",
+		},
+		{
+			name:   "protocol error",
+			err:    &ProtocolError{ResultCode: DIAMETER_UNABLE_TO_DELIVER, Err: io.EOF},
+			target: io.EOF,
+			as: func(err error) bool {
+				var e *ProtocolError
+				return errors.As(err, &e) && e.ResultCode == DIAMETER_UNABLE_TO_DELIVER
+			},
+			text: "DIAMETER_UNABLE_TO_DELIVER: EOF",
+		},
+	} {
+		// The typed errors are found through further wrapping too.
+		for _, err := range []error{tc.err, fmt.Errorf("handling: %w", tc.err)} {
+			if !errors.Is(err, tc.target) {
+				t.Errorf("%s: %v does not match %v", tc.name, err, tc.target)
+			}
+			if !tc.as(err) {
+				t.Errorf("%s: errors.As does not find the typed error in %v", tc.name, err)
+			}
+		}
+		if got := tc.err.Error(); got != tc.text {
+			t.Errorf("%s: Error() = %q, want %q", tc.name, got, tc.text)
+		}
+	}
+}
+
+func TestResultCodeForError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want ResultCode
+	}{
+		{nil, DIAMETER_SUCCESS},
+		{errors.New("no credit"), DIAMETER_UNABLE_TO_COMPLY},
+		{&ProtocolError{ResultCode: DIAMETER_TOO_BUSY}, DIAMETER_TOO_BUSY},
+		{fmt.Errorf("forwarding: %w", &ProtocolError{ResultCode: DIAMETER_UNABLE_TO_DELIVER, Err: io.EOF}), DIAMETER_UNABLE_TO_DELIVER},
+		{&ValidationError{ResultCode: DIAMETER_MISSING_AVP, AVPCode: AVP_SESSION_ID}, DIAMETER_MISSING_AVP},
+		{UnknownMandatoryAVPError, DIAMETER_AVP_UNSUPPORTED},
+		{&AVPError{Code: 99999, Reason: UnknownMandatoryAVPError}, DIAMETER_AVP_UNSUPPORTED},
+		{InvalidAVPLengthError, DIAMETER_INVALID_AVP_LENGTH},
+		{InvalidAVPDataLengthError, DIAMETER_INVALID_AVP_LENGTH},
+		{InvalidAddressLengthError, DIAMETER_INVALID_AVP_LENGTH},
+		{InvalidIPv4AddressLengthError, DIAMETER_INVALID_AVP_LENGTH},
+		{InvalidIPv6AddressLengthError, DIAMETER_INVALID_AVP_LENGTH},
+		{InvalidDiameterVersionError, DIAMETER_UNSUPPORTED_VERSION},
+		{InvalidCommandFlagsError, DIAMETER_INVALID_HDR_BITS},
+		{UnexpectedRequestError, DIAMETER_INVALID_HDR_BITS},
+		{InvalidMessageLengthError, DIAMETER_INVALID_MESSAGE_LENGTH},
+		{InvalidDiameterHeaderLengthError, DIAMETER_INVALID_MESSAGE_LENGTH},
+		{InvalidCommandCodeError, DIAMETER_COMMAND_UNSUPPORTED},
+		{ApplicationMismatchError, DIAMETER_APPLICATION_UNSUPPORTED},
+		{MissingOriginHostError, DIAMETER_MISSING_AVP},
+		{MissingOriginRealmError, DIAMETER_MISSING_AVP},
+		{&AVPError{Code: AVP_PRIORITY_LEVEL, Vendor: VENDOR_3GPP, Reason: MissingMemberError}, DIAMETER_MISSING_AVP},
+		{&AVPError{Code: AVP_USER_NAME, Reason: InvalidUTF8Error}, DIAMETER_INVALID_AVP_VALUE},
+	} {
+		if got := ResultCodeForError(tc.err); got != tc.want {
+			t.Errorf("ResultCodeForError(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
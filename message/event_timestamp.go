@@ -0,0 +1,41 @@
+// Event-Timestamp stamping of encoded messages
+package message
+
+import (
+	"encoding/binary"
+	"slices"
+	"time"
+)
+
+// StampEventTimestamp appends an Event-Timestamp AVP holding now, in NTP
+// seconds, to encoded, the wire form of msg, and updates the message
+// length. msg itself is left unchanged. Messages that already carry an
+// Event-Timestamp, or whose command forbids it, are returned as they are.
+func StampEventTimestamp(encoded []byte, msg *DiameterMessage, now time.Time) ([]byte, error) {
+	if msg.GetAVP(AVP_EVENT_TIMESTAMP) != nil {
+		return encoded, nil
+	}
+	if cmd, ok := lookupMessageCommand(msg); ok && slices.Contains(cmd.ForbiddenAVPs, AVP_EVENT_TIMESTAMP) {
+		return encoded, nil
+	}
+	if len(encoded) < DIAMETER_HEADER_SIZE {
+		return nil, InvalidMessageLengthError
+	}
+	avp, err := NewAVP(AVP_EVENT_TIMESTAMP, now, MANDATORY_FLAG)
+	if err != nil {
+		return nil, err
+	}
+	b, err := avp.Encode()
+	if err != nil {
+		return nil, err
+	}
+	length := len(encoded) + len(b)
+	if length > maxMessageLength {
+		return nil, MessageTooLargeError
+	}
+	stamped := append(encoded[:len(encoded):len(encoded)], b...)
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(length))
+	copy(stamped[1:4], size[1:])
+	return stamped, nil
+}
@@ -0,0 +1,86 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestStampEventTimestamp(t *testing.T) {
+	for _, tc := range []struct {
+		now time.Time
+		// ntp is the value of the AVP on the wire.
+		ntp uint32
+	}{
+		{time.Unix(0, 0), ntpEraOffset},
+		{time.Unix(1_700_000_000, 999_000_000), 1_700_000_000 + ntpEraOffset},
+		// The NTP seconds wrap around on 7 February 2036.
+		{time.Date(2036, 2, 7, 6, 28, 16, 0, time.UTC), 0},
+		{time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC), uint32(time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC).Unix() + ntpEraOffset - 1<<32)},
+	} {
+		msg := newTestCCR(t)
+		encoded, err := msg.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		original := bytes.Clone(encoded)
+		stamped, err := StampEventTimestamp(encoded, msg, tc.now)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.now, err)
+		}
+		if !bytes.Equal(encoded, original) {
+			t.Errorf("%v: stamping changed the encoded message", tc.now)
+		}
+		if msg.GetAVP(AVP_EVENT_TIMESTAMP) != nil {
+			t.Errorf("%v: stamping changed the message", tc.now)
+		}
+		if want := len(original) + 12; len(stamped) != want || binary.BigEndian.Uint32(stamped)&0xffffff != uint32(want) {
+			t.Errorf("%v: stamped %d bytes with length %d, want %d", tc.now, len(stamped), binary.BigEndian.Uint32(stamped)&0xffffff, want)
+		}
+		decoded, err := DecodeMessage(stamped)
+		if err != nil {
+			t.Fatalf("%v: decoding: %v", tc.now, err)
+		}
+		avp := decoded.GetAVP(AVP_EVENT_TIMESTAMP)
+		if avp == nil || avp.Flags != MANDATORY_FLAG {
+			t.Fatalf("%v: Event-Timestamp %v, want one with the M bit", tc.now, avp)
+		}
+		if got := avp.Data.(*Time).Data; got != tc.ntp {
+			t.Errorf("%v: NTP seconds %d, want %d", tc.now, got, tc.ntp)
+		}
+		if got, err := avp.Time(); err != nil || !got.Equal(tc.now.Truncate(time.Second)) {
+			t.Errorf("%v: read back as %v, %v", tc.now, got, err)
+		}
+	}
+}
+
+// TestStampEventTimestampSkips checks that messages already carrying an
+// Event-Timestamp, and those of commands forbidding it, are not stamped.
+func TestStampEventTimestampSkips(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	stamped := newTestCCR(t)
+	stamped.AVPs = append(stamped.AVPs, MustNewAVP(AVP_EVENT_TIMESTAMP, now.Add(-time.Hour), MANDATORY_FLAG))
+
+	const code = 9998
+	registerTestCommand(t, Command{Code: code, ForbiddenAVPs: []uint32{AVP_EVENT_TIMESTAMP}})
+	forbidden, err := NewRequest(code, WithAVPs(MustNewAVP(AVP_SESSION_ID, "client.example.com;1;1", MANDATORY_FLAG)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, msg := range []*DiameterMessage{stamped, forbidden} {
+		encoded, err := msg.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := StampEventTimestamp(encoded, msg, now)
+		if err != nil || !bytes.Equal(got, encoded) {
+			t.Errorf("%s stamped as\n%x, %v\nwant\n%x", msg.CommandName(), got, err, encoded)
+		}
+	}
+
+	if _, err := StampEventTimestamp(make([]byte, DIAMETER_HEADER_SIZE-1), newTestCCR(t), now); err != InvalidMessageLengthError {
+		t.Errorf("truncated message stamped with %v, want InvalidMessageLengthError", err)
+	}
+}
@@ -0,0 +1,99 @@
+// Typed command flags of the Diameter header
+package message
+
+// CommandFlags holds the flags octet of the Diameter header.
+//
+//	 0 1 2 3 4 5 6 7
+//	+-+-+-+-+-+-+-+-+
+//	|R P E T r r r r|
+//	+-+-+-+-+-+-+-+-+
+type CommandFlags uint8
+
+const (
+	FlagRequest       CommandFlags = COMMAND_FLAG_REQUEST
+	FlagProxiable     CommandFlags = COMMAND_FLAG_PROXIABLE
+	FlagError         CommandFlags = COMMAND_FLAG_ERROR
+	FlagRetransmitted CommandFlags = COMMAND_FLAG_RETRANSMITTED
+
+	// flagsReserved are the bits that must be zero on the wire.
+	flagsReserved CommandFlags = 0x0f
+)
+
+// NewCommandFlags returns the flags with the given bits set.
+func NewCommandFlags(request, proxiable, errorBit, retransmitted bool) CommandFlags {
+	var f CommandFlags
+	if request {
+		f |= FlagRequest
+	}
+	if proxiable {
+		f |= FlagProxiable
+	}
+	if errorBit {
+		f |= FlagError
+	}
+	if retransmitted {
+		f |= FlagRetransmitted
+	}
+	return f
+}
+
+// Request reports whether the 'R' bit is set.
+func (f CommandFlags) Request() bool {
+	return f&FlagRequest != 0
+}
+
+// Proxiable reports whether the 'P' bit is set.
+func (f CommandFlags) Proxiable() bool {
+	return f&FlagProxiable != 0
+}
+
+// Error reports whether the 'E' bit is set.
+func (f CommandFlags) Error() bool {
+	return f&FlagError != 0
+}
+
+// Retransmitted reports whether the 'T' bit is set.
+func (f CommandFlags) Retransmitted() bool {
+	return f&FlagRetransmitted != 0
+}
+
+// With returns f with bits set.
+func (f CommandFlags) With(bits CommandFlags) CommandFlags {
+	return f | bits
+}
+
+// Without returns f with bits cleared.
+func (f CommandFlags) Without(bits CommandFlags) CommandFlags {
+	return f &^ bits
+}
+
+// Validate checks that no reserved bit is set and that the 'E' bit is not
+// set on a request, as required by RFC 6733 section 3.
+func (f CommandFlags) Validate() error {
+	if f&flagsReserved != 0 {
+		return InvalidCommandFlagsError
+	}
+	if f.Request() && f.Error() {
+		return InvalidCommandFlagsError
+	}
+	return nil
+}
+
+// String renders the flags as four characters, one per defined bit, with
+// '-' for bits that are clear, e.g. "RP--".
+func (f CommandFlags) String() string {
+	b := []byte("----")
+	if f.Request() {
+		b[0] = 'R'
+	}
+	if f.Proxiable() {
+		b[1] = 'P'
+	}
+	if f.Error() {
+		b[2] = 'E'
+	}
+	if f.Retransmitted() {
+		b[3] = 'T'
+	}
+	return string(b)
+}
@@ -0,0 +1,69 @@
+package message
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCommandFlagsCombinations(t *testing.T) {
+	bits := []struct {
+		flag CommandFlags
+		char byte
+		set  func(CommandFlags) bool
+	}{
+		{FlagRequest, 'R', CommandFlags.Request},
+		{FlagProxiable, 'P', CommandFlags.Proxiable},
+		{FlagError, 'E', CommandFlags.Error},
+		{FlagRetransmitted, 'T', CommandFlags.Retransmitted},
+	}
+	for combo := range 16 {
+		want := [4]bool{combo&8 != 0, combo&4 != 0, combo&2 != 0, combo&1 != 0}
+		f := NewCommandFlags(want[0], want[1], want[2], want[3])
+		wantString := []byte("----")
+		for i, b := range bits {
+			if want[i] {
+				wantString[i] = b.char
+			}
+		}
+		t.Run(string(wantString), func(t *testing.T) {
+			if f.String() != string(wantString) {
+				t.Errorf("String() = %q", f.String())
+			}
+			for i, b := range bits {
+				if b.set(f) != want[i] {
+					t.Errorf("%c bit reported %t, want %t", b.char, b.set(f), want[i])
+				}
+				if !b.set(f.With(b.flag)) || b.set(f.Without(b.flag)) {
+					t.Errorf("With and Without do not set and clear the %c bit", b.char)
+				}
+				// Changing one bit leaves the others alone.
+				if f.With(b.flag).Without(b.flag) != f.Without(b.flag) || f.Without(b.flag).With(b.flag) != f.With(b.flag) {
+					t.Errorf("%c bit does not round-trip", b.char)
+				}
+				if want[i] && f.Without(b.flag).With(b.flag) != f {
+					t.Errorf("clearing and setting the %c bit gives %s", b.char, f.Without(b.flag).With(b.flag))
+				}
+			}
+
+			h := DiameterHeader{Version: DIAMETER_VERSION, MessageLength: DIAMETER_HEADER_SIZE, CommandFlags: f}
+			var decoded DiameterHeader
+			if err := decoded.Decode(h.Encode()); err != nil || decoded.CommandFlags != f {
+				t.Errorf("header decodes with flags %s, %v", decoded.CommandFlags, err)
+			}
+
+			err := f.Validate()
+			if invalid := f.Request() && f.Error(); invalid != (err != nil) {
+				t.Errorf("Validate() = %v", err)
+			}
+		})
+	}
+}
+
+func TestCommandFlagsReservedBits(t *testing.T) {
+	for bit := CommandFlags(1); bit < FlagRetransmitted; bit <<= 1 {
+		f := FlagProxiable.With(bit)
+		if err := f.Validate(); !errors.Is(err, InvalidCommandFlagsError) {
+			t.Errorf("reserved bit %#x: Validate() = %v, want InvalidCommandFlagsError", uint8(bit), err)
+		}
+	}
+}
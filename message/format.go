@@ -0,0 +1,70 @@
+// Display formatting of string and octet values
+package message
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultMaxDisplayLength is the number of bytes of an OctetString or
+// UTF8String value shown by String before it is truncated.
+const DefaultMaxDisplayLength = 64
+
+var maxDisplayLength atomic.Int64
+
+func init() {
+	maxDisplayLength.Store(DefaultMaxDisplayLength)
+}
+
+// SetMaxDisplayLength sets the number of bytes of an OctetString or
+// UTF8String value shown by String. A length of 0 disables truncation.
+func SetMaxDisplayLength(n int) {
+	maxDisplayLength.Store(int64(n))
+}
+
+// formatBytes renders b as text when it is printable UTF-8 and as hex
+// otherwise, truncated to the display length with a count of the bytes
+// left out.
+func formatBytes(b []byte) string {
+	limit := int(maxDisplayLength.Load())
+	if isPrintable(b) {
+		shown := b
+		if limit > 0 && len(b) > limit {
+			shown = b[:limit]
+			// Do not cut a multi-byte character in half.
+			for len(shown) > 0 && !utf8.Valid(shown) {
+				shown = shown[:len(shown)-1]
+			}
+		}
+		return string(shown) + truncationSuffix(len(b)-len(shown))
+	}
+	shown := b
+	if limit > 0 && len(b) > limit {
+		shown = b[:limit]
+	}
+	return "0x" + hex.EncodeToString(shown) + truncationSuffix(len(b)-len(shown))
+}
+
+func truncationSuffix(omitted int) string {
+	if omitted == 0 {
+		return ""
+	}
+	return fmt.Sprintf("…(+%d bytes)", omitted)
+}
+
+// isPrintable reports whether b is valid UTF-8 without control characters
+// other than tabs and line breaks.
+func isPrintable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && r != '\t' && r != '\n' && r != '\r' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package message
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestOctetStringString(t *testing.T) {
+	long := strings.Repeat("a", 100)
+	cert := append([]byte{0x30, 0x82, 0x01, 0xf0}, bytes.Repeat([]byte{0x00, 0x1b}, 248)...)
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"empty", nil, ""},
+		{"text", []byte("client.example.com;1;1"), "client.example.com;1;1"},
+		{"text with line breaks", []byte("a\tb\r\nc"), "a\tb\r\nc"},
+		{"long text", []byte(long), strings.Repeat("a", 64) + "…(+36 bytes)"},
+		{"binary", []byte{0xde, 0xad, 0xbe, 0xef}, "0xdeadbeef"},
+		{"control characters", []byte("ab\x1b[2Jcd"), "0x61621b5b324a6364"},
+		{"invalid UTF-8", []byte{'a', 0xff, 'b'}, "0x61ff62"},
+		{"long binary", cert, "0x" + "308201f0" + strings.Repeat("001b", 30) + "…(+436 bytes)"},
+		// 63 bytes of text and a 2-byte character cut by the limit.
+		{"multi-byte character at the limit", []byte(strings.Repeat("a", 63) + "é" + "b"), strings.Repeat("a", 63) + "…(+3 bytes)"},
+	} {
+		o := &OctetString{Data: tc.data}
+		if got := o.String(); got != tc.want {
+			t.Errorf("%s: String = %q, want %q", tc.name, got, tc.want)
+		}
+		if got := o.FullString(); got != string(tc.data) {
+			t.Errorf("%s: FullString = %q, want the raw value", tc.name, got)
+		}
+		u := &UTF8String{Data: string(tc.data)}
+		if got := u.String(); got != tc.want {
+			t.Errorf("%s: UTF8String.String = %q, want %q", tc.name, got, tc.want)
+		}
+		if got := u.FullString(); got != string(tc.data) {
+			t.Errorf("%s: UTF8String.FullString = %q, want the raw value", tc.name, got)
+		}
+	}
+}
+
+func TestSetMaxDisplayLength(t *testing.T) {
+	t.Cleanup(func() { SetMaxDisplayLength(DefaultMaxDisplayLength) })
+	o := &OctetString{Data: []byte(strings.Repeat("x", 200))}
+
+	SetMaxDisplayLength(8)
+	if got := o.String(); got != "xxxxxxxx…(+192 bytes)" {
+		t.Errorf("String with a limit of 8 = %q", got)
+	}
+	SetMaxDisplayLength(0)
+	if got := o.String(); got != string(o.Data) {
+		t.Errorf("String without a limit = %q", got)
+	}
+}
+
+// TestAVPStringBinary checks that dumping a message with a binary AVP does
+// not write its raw bytes.
+func TestAVPStringBinary(t *testing.T) {
+	value := append([]byte("\x00\x01\x1b"), bytes.Repeat([]byte{0xff}, 500)...)
+	msg := newTestCCR(t)
+	msg.AVPs = append(msg.AVPs, &AVP{Code: AVP_PROXY_STATE, Flags: MANDATORY_FLAG, Data: &OctetString{Data: value}})
+	s := msg.String()
+	if strings.ContainsAny(s, "\x00\x1b") || !utf8.ValidString(s) {
+		t.Errorf("dump carries the raw value:\n%q", s)
+	}
+	if !strings.Contains(s, "0x00011bffff") || !strings.Contains(s, "…(+439 bytes)") {
+		t.Errorf("binary value not rendered as truncated hex:\n%s", s)
+	}
+}
@@ -0,0 +1,124 @@
+package message
+
+import (
+	"bytes"
+	"testing"
+)
+
+// maxFuzzInput bounds the inputs of the fuzz targets, so that time goes
+// into the structure of messages rather than their size.
+const maxFuzzInput = 64 << 10
+
+// FuzzDecodeMessage decodes arbitrary bytes as a message. What decodes
+// must encode again, in the order it came, to a message encoding the same.
+func FuzzDecodeMessage(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) > maxFuzzInput {
+			return
+		}
+		msg, err := DecodeMessage(data)
+		if err != nil {
+			return
+		}
+		if int(msg.Header.MessageLength) > len(data) {
+			t.Fatalf("message length %d for %d bytes", msg.Header.MessageLength, len(data))
+		}
+		encoded, err := EncodeMessage(msg, WithKeepOrder())
+		if err != nil {
+			t.Fatalf("encoding the decoded message: %v", err)
+		}
+		again, err := DecodeMessage(encoded, WithDecodeMode(DecodeTolerant))
+		if err != nil {
+			t.Fatalf("decoding the encoded message: %v", err)
+		}
+		reencoded, err := EncodeMessage(again, WithKeepOrder())
+		if err != nil {
+			t.Fatalf("encoding the message again: %v", err)
+		}
+		if !bytes.Equal(encoded, reencoded) {
+			t.Fatalf("message changed by encoding:\n%x\n%x", encoded, reencoded)
+		}
+	})
+}
+
+// FuzzDecodeAVP decodes arbitrary bytes as an AVP: it must not panic,
+// what it accepts stays within the nesting limit, and encodes to an AVP
+// encoding the same.
+func FuzzDecodeAVP(f *testing.F) {
+	f.Add(nestedGroups(DefaultMaxGroupDepth))
+	f.Add(nestedGroups(DefaultMaxGroupDepth + 1))
+	f.Add(nestedGroups(10_000))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) > maxFuzzInput && len(data) != len(nestedGroups(10_000)) {
+			return
+		}
+		avp, err := DecodeAVP(data)
+		if err != nil {
+			return
+		}
+		if err := checkGroupDepth([]*AVP{avp}, DefaultMaxGroupDepth); err != nil {
+			t.Errorf("decoded AVP nested too deep: %v", err)
+		}
+		encoded, err := avp.Encode()
+		if err != nil {
+			t.Fatalf("encoding the decoded AVP: %v", err)
+		}
+		again, err := DecodeAVP(encoded)
+		if err != nil {
+			t.Fatalf("decoding the encoded AVP %x: %v", encoded, err)
+		}
+		reencoded, err := again.Encode()
+		if err != nil {
+			t.Fatalf("encoding the AVP again: %v", err)
+		}
+		if !bytes.Equal(encoded, reencoded) {
+			t.Fatalf("AVP changed by encoding:\n%x\n%x", encoded, reencoded)
+		}
+	})
+}
+
+// FuzzDecodeAddress decodes arbitrary bytes as an Address, which must
+// encode back to the same bytes.
+func FuzzDecodeAddress(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) > maxFuzzInput {
+			return
+		}
+		var a Address
+		if err := a.Decode(data); err != nil {
+			return
+		}
+		if a.Length() != uint32(len(data)) {
+			t.Fatalf("Address %s from %d bytes has length %d", &a, len(data), a.Length())
+		}
+		encoded, err := a.Encode()
+		if err != nil {
+			t.Fatalf("encoding Address %s: %v", &a, err)
+		}
+		if !bytes.Equal(encoded, data) {
+			t.Fatalf("Address %x encodes as %x", data, encoded)
+		}
+		_ = a.String()
+	})
+}
+
+// FuzzParseDiameterURI parses arbitrary strings as DiameterURIs. What
+// parses must format to a URI parsing the same.
+func FuzzParseDiameterURI(f *testing.F) {
+	f.Fuzz(func(t *testing.T, uri string) {
+		if len(uri) > maxFuzzInput {
+			return
+		}
+		parts, err := ParseDiameterURI(uri)
+		if err != nil {
+			return
+		}
+		again, err := ParseDiameterURI(parts.String())
+		if err != nil {
+			t.Fatalf("%q parses as %+v, formatted %q: %v", uri, parts, parts.String(), err)
+		}
+		if again != parts {
+			t.Fatalf("%q parses as %+v, formatted %q as %+v", uri, parts, parts.String(), again)
+		}
+	})
+}
@@ -0,0 +1,40 @@
+// Accessors of common base protocol AVPs
+package message
+
+// GetSessionID returns the Session-Id of msg.
+func GetSessionID(msg *DiameterMessage) (string, error) {
+	avp := msg.GetAVP(AVP_SESSION_ID)
+	if avp == nil {
+		return "", MissingSessionIDError
+	}
+	return avp.Str()
+}
+
+// GetOriginHost returns the Origin-Host of msg as sent, without the
+// normalization of OriginIdentity.
+func GetOriginHost(msg *DiameterMessage) (string, error) {
+	avp := msg.GetAVP(AVP_ORIGIN_HOST)
+	if avp == nil {
+		return "", MissingOriginHostError
+	}
+	return avp.Str()
+}
+
+// GetOriginRealm returns the Origin-Realm of msg as sent, without the
+// normalization of OriginIdentity.
+func GetOriginRealm(msg *DiameterMessage) (string, error) {
+	avp := msg.GetAVP(AVP_ORIGIN_REALM)
+	if avp == nil {
+		return "", MissingOriginRealmError
+	}
+	return avp.Str()
+}
+
+// GetOriginStateID returns the Origin-State-Id of msg.
+func GetOriginStateID(msg *DiameterMessage) (uint32, error) {
+	avp := msg.GetAVP(AVP_ORIGIN_STATE_ID)
+	if avp == nil {
+		return 0, MissingOriginStateIDError
+	}
+	return avp.Uint32()
+}
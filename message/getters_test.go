@@ -0,0 +1,60 @@
+package message
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGetters(t *testing.T) {
+	msg := newTestCCR(t)
+	msg.AVPs = append(msg.AVPs, MustNewAVP(AVP_ORIGIN_STATE_ID, uint32(1_700_000_000), MANDATORY_FLAG))
+	if id, err := GetSessionID(msg); err != nil || id != "client.example.com;1;2" {
+		t.Errorf("GetSessionID = %q, %v", id, err)
+	}
+	if host, err := GetOriginHost(msg); err != nil || host != "client.example.com" {
+		t.Errorf("GetOriginHost = %q, %v", host, err)
+	}
+	if realm, err := GetOriginRealm(msg); err != nil || realm != "example.com" {
+		t.Errorf("GetOriginRealm = %q, %v", realm, err)
+	}
+	if state, err := GetOriginStateID(msg); err != nil || state != 1_700_000_000 {
+		t.Errorf("GetOriginStateID = %d, %v", state, err)
+	}
+
+	// The AVPs are returned as sent.
+	msg.GetAVP(AVP_ORIGIN_HOST).Data.(*DiameterIdentity).Data = "Client.Example.COM."
+	if host, err := GetOriginHost(msg); err != nil || host != "Client.Example.COM." {
+		t.Errorf("GetOriginHost = %q, %v; want the host as sent", host, err)
+	}
+}
+
+func TestGettersMissing(t *testing.T) {
+	empty, err := NewRequest(COMMAND_CODE_CREDIT_CONTROL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetSessionID(empty); !errors.Is(err, MissingSessionIDError) {
+		t.Errorf("GetSessionID = %v, want MissingSessionIDError", err)
+	}
+	if _, err := GetOriginHost(empty); !errors.Is(err, MissingOriginHostError) {
+		t.Errorf("GetOriginHost = %v, want MissingOriginHostError", err)
+	}
+	if _, err := GetOriginRealm(empty); !errors.Is(err, MissingOriginRealmError) {
+		t.Errorf("GetOriginRealm = %v, want MissingOriginRealmError", err)
+	}
+	if _, err := GetOriginStateID(empty); !errors.Is(err, MissingOriginStateIDError) {
+		t.Errorf("GetOriginStateID = %v, want MissingOriginStateIDError", err)
+	}
+
+	// An AVP of the wrong type is an error, not a zero value.
+	wrong := &DiameterMessage{Header: empty.Header, AVPs: []*AVP{
+		{Code: AVP_SESSION_ID, Flags: MANDATORY_FLAG, Data: &Unsigned32{Data: 1}},
+		{Code: AVP_ORIGIN_STATE_ID, Flags: MANDATORY_FLAG, Data: &UTF8String{Data: "1"}},
+	}}
+	if _, err := GetSessionID(wrong); err == nil {
+		t.Error("GetSessionID of an Unsigned32 succeeded")
+	}
+	if _, err := GetOriginStateID(wrong); err == nil {
+		t.Error("GetOriginStateID of a UTF8String succeeded")
+	}
+}
@@ -0,0 +1,182 @@
+package message
+
+import (
+	"testing"
+)
+
+// failedAVP builds Failed-AVP{Proxy-Info{Proxy-Host, Proxy-State},
+// Vendor-Specific-Application-Id{Vendor-Id, Auth-Application-Id}}, three
+// levels of nesting.
+func failedAVP(t *testing.T) *AVP {
+	t.Helper()
+	newAVP := func(avp *AVP, err error) *AVP {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return avp
+	}
+	proxyInfo := newAVP(NewGroupedAVP(AVP_PROXY_INFO, MANDATORY_FLAG, 0,
+		newAVP(NewAVP(AVP_PROXY_HOST, "proxy.example.com", MANDATORY_FLAG)),
+		newAVP(NewAVP(AVP_PROXY_STATE, "abcd", MANDATORY_FLAG)),
+	))
+	vsai := newAVP(NewGroupedAVP(AVP_VENDOR_SPECIFIC_APPLICATION_ID, MANDATORY_FLAG, 0,
+		newAVP(NewAVP(AVP_VENDOR_ID, uint32(10415), MANDATORY_FLAG)),
+		newAVP(NewAVP(AVP_AUTH_APPLICATION_ID, uint32(16777238), MANDATORY_FLAG)),
+	))
+	return newAVP(NewGroupedAVP(AVP_FAILED_AVP, MANDATORY_FLAG, 0, proxyInfo, vsai))
+}
+
+func group(t *testing.T, avp *AVP) *Grouped {
+	t.Helper()
+	g, err := avp.Group()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestGroupedGet(t *testing.T) {
+	g := group(t, failedAVP(t))
+	if avp, ok := g.Get(AVP_PROXY_INFO); !ok || avp.Code != AVP_PROXY_INFO {
+		t.Errorf("Get(Proxy-Info) = %v, %v", avp, ok)
+	}
+	if avp, ok := g.Get(AVP_PROXY_HOST); ok {
+		t.Errorf("Get found %v one level too deep", avp)
+	}
+
+	g.Add(g.AVPs[0].Clone())
+	all := g.GetAll(AVP_PROXY_INFO)
+	if len(all) != 2 || all[0] != g.AVPs[0] || all[1] != g.AVPs[2] {
+		t.Errorf("GetAll(Proxy-Info) = %v, want the first and last member", all)
+	}
+	if all := g.GetAll(AVP_USER_NAME); all != nil {
+		t.Errorf("GetAll(User-Name) = %v, want nil", all)
+	}
+}
+
+func TestGroupedAddRemove(t *testing.T) {
+	g := group(t, failedAVP(t))
+	extra, err := NewAVP(AVP_USER_NAME, "alice", MANDATORY_FLAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Add(extra, extra.Clone())
+	if len(g.AVPs) != 4 || g.AVPs[2] != extra {
+		t.Fatalf("after Add the group holds %d AVPs: %v", len(g.AVPs), g.AVPs)
+	}
+
+	if n := g.Remove(AVP_USER_NAME); n != 2 {
+		t.Errorf("Remove(User-Name) = %d, want 2", n)
+	}
+	if n := g.Remove(AVP_USER_NAME); n != 0 {
+		t.Errorf("second Remove(User-Name) = %d, want 0", n)
+	}
+	if len(g.AVPs) != 2 || g.AVPs[0].Code != AVP_PROXY_INFO || g.AVPs[1].Code != AVP_VENDOR_SPECIFIC_APPLICATION_ID {
+		t.Errorf("after Remove the group holds %v", g.AVPs)
+	}
+	if n := g.Remove(AVP_PROXY_INFO); n != 1 || len(g.AVPs) != 1 {
+		t.Errorf("Remove(Proxy-Info) = %d, leaving %d AVPs", n, len(g.AVPs))
+	}
+}
+
+// mutate changes the group at every nesting level: a longer Proxy-State
+// that needs padding, a removed Proxy-Host, and an added
+// Acct-Application-Id.
+func mutate(t *testing.T, avp *AVP) {
+	t.Helper()
+	proxyInfo, _ := group(t, avp).Get(AVP_PROXY_INFO)
+	state, _ := group(t, proxyInfo).Get(AVP_PROXY_STATE)
+	if err := state.Data.SetData([]byte("state-0001")); err != nil {
+		t.Fatal(err)
+	}
+	group(t, proxyInfo).Remove(AVP_PROXY_HOST)
+
+	vsai, _ := group(t, avp).Get(AVP_VENDOR_SPECIFIC_APPLICATION_ID)
+	acct, err := NewAVP(AVP_ACCT_APPLICATION_ID, uint32(3), MANDATORY_FLAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group(t, vsai).Add(acct)
+}
+
+// checkLengths compares the length of got, and of every AVP it includes,
+// with want.
+func checkLengths(t *testing.T, got, want *AVP) {
+	t.Helper()
+	if got.Length() != want.Length() {
+		t.Errorf("%s length %d, want %d", AVPName(got.Code, 0), got.Length(), want.Length())
+	}
+	gg, ok := got.Data.(*Grouped)
+	if !ok {
+		return
+	}
+	wg := group(t, want)
+	if len(gg.AVPs) != len(wg.AVPs) {
+		t.Fatalf("%s holds %d AVPs, want %d", AVPName(got.Code, 0), len(gg.AVPs), len(wg.AVPs))
+	}
+	for i := range gg.AVPs {
+		checkLengths(t, gg.AVPs[i], wg.AVPs[i])
+	}
+}
+
+func TestGroupedRefreshLengths(t *testing.T) {
+	avp := failedAVP(t)
+	mutate(t, avp)
+
+	// Proxy-State: 8 + 10 = 18, padded to 20.
+	// Proxy-Info: 8 + 20 = 28.
+	// Vendor-Specific-Application-Id: 8 + 3*12 = 44.
+	// Failed-AVP: 8 + 28 + 44 = 80.
+	if got := avp.Refresh(); got != 80 {
+		t.Fatalf("Refresh = %d, want 80", got)
+	}
+	proxyInfo, _ := group(t, avp).Get(AVP_PROXY_INFO)
+	state, _ := group(t, proxyInfo).Get(AVP_PROXY_STATE)
+	vsai, _ := group(t, avp).Get(AVP_VENDOR_SPECIFIC_APPLICATION_ID)
+	for _, tc := range []struct {
+		avp  *AVP
+		want uint32
+	}{
+		{state, 18},
+		{proxyInfo, 28},
+		{vsai, 44},
+	} {
+		if tc.avp.Length() != tc.want {
+			t.Errorf("%s length %d, want %d", AVPName(tc.avp.Code, 0), tc.avp.Length(), tc.want)
+		}
+	}
+
+	encoded, err := avp.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encoded) != 80 {
+		t.Errorf("encoded %d bytes, want 80", len(encoded))
+	}
+	decoded, err := DecodeAVP(encoded)
+	if err != nil {
+		t.Fatalf("DecodeAVP: %v", err)
+	}
+	checkLengths(t, avp, decoded)
+}
+
+// TestGroupedEncodeLengths checks that Encode alone brings the lengths at
+// every nesting level up to date.
+func TestGroupedEncodeLengths(t *testing.T) {
+	avp := failedAVP(t)
+	mutate(t, avp)
+
+	encoded, err := avp.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeAVP(encoded)
+	if err != nil {
+		t.Fatalf("DecodeAVP: %v", err)
+	}
+	checkLengths(t, avp, decoded)
+	if decoded.Length() != 80 {
+		t.Errorf("decoded length %d, want 80", decoded.Length())
+	}
+}
@@ -0,0 +1,40 @@
+// Experimental-Result-Codes of the Gx application, 3GPP TS 29.212
+package message
+
+// Experimental-Result-Codes of 3GPP TS 29.212 section 5.5, under
+// VENDOR_3GPP.
+const (
+	DIAMETER_PCC_BEARER_EVENT                           ResultCode = 4141
+	DIAMETER_BEARER_EVENT                               ResultCode = 4142
+	DIAMETER_AN_GW_FAILED                               ResultCode = 4143
+	DIAMETER_PENDING_TRANSACTION                        ResultCode = 4144
+	DIAMETER_UE_STATUS_SUSPEND                          ResultCode = 4145
+	DIAMETER_ERROR_INITIAL_PARAMETERS                   ResultCode = 5140
+	DIAMETER_ERROR_TRIGGER_EVENT                        ResultCode = 5141
+	DIAMETER_PCC_RULE_EVENT                             ResultCode = 5142
+	DIAMETER_ERROR_BEARER_NOT_AUTHORIZED                ResultCode = 5143
+	DIAMETER_ERROR_TRAFFIC_MAPPING_INSTRUCTION_REJECTED ResultCode = 5144
+	DIAMETER_ERROR_CONFLICTING_REQUEST                  ResultCode = 5147
+	DIAMETER_ADC_RULE_EVENT                             ResultCode = 5148
+	DIAMETER_ERROR_NBIFOM_NOT_AUTHORIZED                ResultCode = 5149
+)
+
+func init() {
+	for code, name := range map[ResultCode]string{
+		DIAMETER_PCC_BEARER_EVENT:                           "DIAMETER_PCC_BEARER_EVENT",
+		DIAMETER_BEARER_EVENT:                               "DIAMETER_BEARER_EVENT",
+		DIAMETER_AN_GW_FAILED:                               "DIAMETER_AN_GW_FAILED",
+		DIAMETER_PENDING_TRANSACTION:                        "DIAMETER_PENDING_TRANSACTION",
+		DIAMETER_UE_STATUS_SUSPEND:                          "DIAMETER_UE_STATUS_SUSPEND",
+		DIAMETER_ERROR_INITIAL_PARAMETERS:                   "DIAMETER_ERROR_INITIAL_PARAMETERS",
+		DIAMETER_ERROR_TRIGGER_EVENT:                        "DIAMETER_ERROR_TRIGGER_EVENT",
+		DIAMETER_PCC_RULE_EVENT:                             "DIAMETER_PCC_RULE_EVENT",
+		DIAMETER_ERROR_BEARER_NOT_AUTHORIZED:                "DIAMETER_ERROR_BEARER_NOT_AUTHORIZED",
+		DIAMETER_ERROR_TRAFFIC_MAPPING_INSTRUCTION_REJECTED: "DIAMETER_ERROR_TRAFFIC_MAPPING_INSTRUCTION_REJECTED",
+		DIAMETER_ERROR_CONFLICTING_REQUEST:                  "DIAMETER_ERROR_CONFLICTING_REQUEST",
+		DIAMETER_ADC_RULE_EVENT:                             "DIAMETER_ADC_RULE_EVENT",
+		DIAMETER_ERROR_NBIFOM_NOT_AUTHORIZED:                "DIAMETER_ERROR_NBIFOM_NOT_AUTHORIZED",
+	} {
+		RegisterExperimentalResult(VENDOR_3GPP, code, name)
+	}
+}
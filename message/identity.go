@@ -0,0 +1,101 @@
+// Diameter peer identity
+package message
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PeerIdentity identifies a Diameter node by its Origin-Host and
+// Origin-Realm. Unlike a transport address it survives NAT and reconnects,
+// so it is the key used for peers throughout the library.
+type PeerIdentity struct {
+	Host  string
+	Realm string
+}
+
+// NewPeerIdentity returns the normalized identity of host in realm.
+// DiameterIdentity values are FQDNs, so they are compared the way DNS
+// compares names: case-insensitively and ignoring a trailing dot.
+func NewPeerIdentity(host, realm string) PeerIdentity {
+	return PeerIdentity{
+		Host:  normalizeDiameterIdentity(host),
+		Realm: normalizeDiameterIdentity(realm),
+	}
+}
+
+func normalizeDiameterIdentity(s string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), ".")
+}
+
+// IsZero reports whether the identity is unset.
+func (id PeerIdentity) IsZero() bool {
+	return id.Host == "" && id.Realm == ""
+}
+
+func (id PeerIdentity) String() string {
+	return id.Host + "@" + id.Realm
+}
+
+// OriginIdentity returns the identity found in the Origin-Host and
+// Origin-Realm AVPs of msg.
+func OriginIdentity(msg *DiameterMessage) (PeerIdentity, error) {
+	host, err := msg.GetAVP(AVP_ORIGIN_HOST).Str()
+	if err != nil {
+		return PeerIdentity{}, MissingOriginHostError
+	}
+	realm, err := msg.GetAVP(AVP_ORIGIN_REALM).Str()
+	if err != nil {
+		return PeerIdentity{}, MissingOriginRealmError
+	}
+	return NewPeerIdentity(host, realm), nil
+}
+
+// CheckOrigin verifies that msg carries Origin-Host and Origin-Realm and
+// that its Origin-Host is the one of expected, the identity the peer
+// announced in the capabilities exchange. A different Origin-Host on an
+// established connection points to a misrouted or spoofed connection. Only
+// the presence of the AVPs is checked when expected is zero.
+func CheckOrigin(msg *DiameterMessage, expected PeerIdentity) error {
+	id, err := OriginIdentity(msg)
+	if err != nil {
+		return err
+	}
+	if !expected.IsZero() && id.Host != expected.Host {
+		return fmt.Errorf("%w: %s, expected %s", OriginMismatchError, id.Host, expected.Host)
+	}
+	return nil
+}
+
+// ValidateDiameterIdentity checks that s has the FQDN form RFC 6733
+// section 4.3.1 requires of a DiameterIdentity: at most 255 octets of dot
+// separated labels of letters, digits and hyphens, each 1 to 63 octets
+// long and neither starting nor ending with a hyphen. A trailing dot is
+// accepted.
+func ValidateDiameterIdentity(s string) error {
+	name := strings.TrimSuffix(s, ".")
+	if name == "" {
+		return fmt.Errorf("%w: empty name", InvalidDiameterIdentityError)
+	}
+	if len(name) > 255 {
+		return fmt.Errorf("%w %q: longer than 255 octets", InvalidDiameterIdentityError, s)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("%w %q: label length must be 1 to 63 octets", InvalidDiameterIdentityError, s)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("%w %q: label %q starts or ends with a hyphen", InvalidDiameterIdentityError, s, label)
+		}
+		for _, r := range label {
+			if !isLDH(r) {
+				return fmt.Errorf("%w %q: invalid character %q", InvalidDiameterIdentityError, s, r)
+			}
+		}
+	}
+	return nil
+}
+
+func isLDH(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-'
+}
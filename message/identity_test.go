@@ -0,0 +1,123 @@
+package message
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewPeerIdentityNormalizes(t *testing.T) {
+	tests := []struct {
+		host, realm string
+		want        PeerIdentity
+	}{
+		{"mme01.example.com", "example.com", PeerIdentity{"mme01.example.com", "example.com"}},
+		{"MME01.Example.COM", "EXAMPLE.com", PeerIdentity{"mme01.example.com", "example.com"}},
+		{"mme01.example.com.", "example.com.", PeerIdentity{"mme01.example.com", "example.com"}},
+		{" mme01.example.com ", "example.com", PeerIdentity{"mme01.example.com", "example.com"}},
+		{"", "", PeerIdentity{}},
+	}
+	for _, tt := range tests {
+		if got := NewPeerIdentity(tt.host, tt.realm); got != tt.want {
+			t.Errorf("NewPeerIdentity(%q, %q) = %#v, want %#v", tt.host, tt.realm, got, tt.want)
+		}
+	}
+}
+
+func TestPeerIdentityEquality(t *testing.T) {
+	a := NewPeerIdentity("HSS.example.com.", "Example.com")
+	b := NewPeerIdentity("hss.EXAMPLE.com", "example.COM.")
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+	m := map[PeerIdentity]int{a: 1}
+	if m[b] != 1 {
+		t.Error("equal identities are different map keys")
+	}
+	if NewPeerIdentity("hss.example.com", "other.com") == a {
+		t.Error("identities in different realms are equal")
+	}
+}
+
+func TestPeerIdentityZeroAndString(t *testing.T) {
+	if !(PeerIdentity{}).IsZero() {
+		t.Error("zero identity is not IsZero")
+	}
+	id := NewPeerIdentity("hss.example.com", "example.com")
+	if id.IsZero() {
+		t.Error("identity is IsZero")
+	}
+	if got := id.String(); got != "hss.example.com@example.com" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestOriginIdentity(t *testing.T) {
+	msg := &DiameterMessage{Header: &DiameterHeader{Version: DIAMETER_VERSION}}
+	if _, err := OriginIdentity(msg); !errors.Is(err, MissingOriginHostError) {
+		t.Errorf("without Origin-Host: %v", err)
+	}
+	msg.AVPs = append(msg.AVPs, MustNewAVP(AVP_ORIGIN_HOST, "HSS.example.com", MANDATORY_FLAG))
+	if _, err := OriginIdentity(msg); !errors.Is(err, MissingOriginRealmError) {
+		t.Errorf("without Origin-Realm: %v", err)
+	}
+	msg.AVPs = append(msg.AVPs, MustNewAVP(AVP_ORIGIN_REALM, "Example.com", MANDATORY_FLAG))
+	id, err := OriginIdentity(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := NewPeerIdentity("hss.example.com", "example.com"); id != want {
+		t.Errorf("OriginIdentity() = %v, want %v", id, want)
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	msg := &DiameterMessage{
+		Header: &DiameterHeader{Version: DIAMETER_VERSION},
+		AVPs: []*AVP{
+			MustNewAVP(AVP_ORIGIN_HOST, "hss.example.com", MANDATORY_FLAG),
+			MustNewAVP(AVP_ORIGIN_REALM, "example.com", MANDATORY_FLAG),
+		},
+	}
+	if err := CheckOrigin(msg, NewPeerIdentity("HSS.example.com", "example.com")); err != nil {
+		t.Errorf("same host: %v", err)
+	}
+	if err := CheckOrigin(msg, PeerIdentity{}); err != nil {
+		t.Errorf("no expected identity: %v", err)
+	}
+	if err := CheckOrigin(msg, NewPeerIdentity("other.example.com", "example.com")); !errors.Is(err, OriginMismatchError) {
+		t.Errorf("other host: %v, want OriginMismatchError", err)
+	}
+
+	expected := NewPeerIdentity("hss.example.com", "example.com")
+	for _, tc := range []struct {
+		name string
+		avps []*AVP
+		want error
+	}{
+		{"no Origin-Host", msg.AVPs[1:], MissingOriginHostError},
+		{"no Origin-Realm", msg.AVPs[:1], MissingOriginRealmError},
+	} {
+		partial := &DiameterMessage{Header: msg.Header, AVPs: tc.avps}
+		if err := CheckOrigin(partial, expected); !errors.Is(err, tc.want) {
+			t.Errorf("%s: %v, want %v", tc.name, err, tc.want)
+		}
+		if err := CheckOrigin(partial, PeerIdentity{}); !errors.Is(err, tc.want) {
+			t.Errorf("%s without expected identity: %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestValidateDiameterIdentity(t *testing.T) {
+	valid := []string{"hss.example.com", "hss.example.com.", "a", "mme-01.epc.mnc001.mcc001.3gppnetwork.org"}
+	for _, s := range valid {
+		if err := ValidateDiameterIdentity(s); err != nil {
+			t.Errorf("ValidateDiameterIdentity(%q) = %v", s, err)
+		}
+	}
+	invalid := []string{"", ".", "hss..example.com", "-hss.example.com", "hss-.example.com", "hss_1.example.com", "hss example.com"}
+	for _, s := range invalid {
+		if err := ValidateDiameterIdentity(s); !errors.Is(err, InvalidDiameterIdentityError) {
+			t.Errorf("ValidateDiameterIdentity(%q) = %v, want InvalidDiameterIdentityError", s, err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Hop-by-Hop and End-to-End identifier generation
+package message
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+
+	"github.com/IbrahimShahzad/diameter/clock"
+)
+
+// IDGenerator produces the identifiers of new requests. Hop-by-Hop
+// Identifiers must be unique per connection; End-to-End Identifiers must
+// stay unique across restarts long enough for peers' duplicate detection.
+type IDGenerator interface {
+	HopByHopID() uint32
+	EndToEndID() uint32
+}
+
+const (
+	endToEndTimeShift   = 20
+	endToEndCounterMask = 1<<endToEndTimeShift - 1
+)
+
+// idGenerator implements the scheme suggested by RFC 6733 section 3: the
+// high order 12 bits of the End-to-End Identifier hold the low order 12
+// bits of the time at startup and the low order 20 bits a counter starting
+// at a random value. Hop-by-Hop Identifiers are a counter starting at a
+// random value. Both counters are atomic, so identifiers handed out
+// concurrently are unique and increase monotonically.
+type idGenerator struct {
+	endToEndPrefix uint32
+	endToEnd       atomic.Uint32
+	hopByHop       atomic.Uint32
+}
+
+// NewIDGenerator returns an IDGenerator whose End-to-End prefix is taken
+// from clk.
+func NewIDGenerator(clk clock.Clock) IDGenerator {
+	g := &idGenerator{
+		endToEndPrefix: uint32(clk.Now().Unix()) << endToEndTimeShift,
+	}
+	g.endToEnd.Store(rand.Uint32() & endToEndCounterMask)
+	g.hopByHop.Store(rand.Uint32())
+	return g
+}
+
+func (g *idGenerator) HopByHopID() uint32 {
+	return g.hopByHop.Add(1)
+}
+
+func (g *idGenerator) EndToEndID() uint32 {
+	return g.endToEndPrefix | g.endToEnd.Add(1)&endToEndCounterMask
+}
+
+// DefaultIDGenerator is used by NewRequest unless WithIDGenerator is given.
+var DefaultIDGenerator = NewIDGenerator(clock.Real)
@@ -0,0 +1,69 @@
+package message
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/clock"
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+)
+
+func TestHopByHopIDWraparound(t *testing.T) {
+	g := NewIDGenerator(clock.Real).(*idGenerator)
+	g.hopByHop.Store(math.MaxUint32 - 1)
+	for _, want := range []uint32{math.MaxUint32, 0, 1} {
+		if got := g.HopByHopID(); got != want {
+			t.Errorf("HopByHopID() = %d, want %d", got, want)
+		}
+	}
+}
+
+func TestEndToEndIDTimePrefix(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	clk := fakeclock.New(start)
+	g := NewIDGenerator(clk)
+	want := uint32(start.Unix()) & 0xfff
+	if got := g.EndToEndID() >> 20; got != want {
+		t.Errorf("high 12 bits %#x, want %#x from the clock", got, want)
+	}
+	// The prefix is the time at startup, not at the time of the request.
+	clk.Advance(time.Hour)
+	if got := g.EndToEndID() >> 20; got != want {
+		t.Errorf("high 12 bits %#x after an hour, want %#x", got, want)
+	}
+	if got := NewIDGenerator(clk).EndToEndID() >> 20; got != uint32(start.Add(time.Hour).Unix())&0xfff {
+		t.Errorf("high 12 bits %#x for a generator started an hour later", got)
+	}
+}
+
+func TestIDsUniqueConcurrently(t *testing.T) {
+	const goroutines, perGoroutine = 8, 125_000
+	g := NewIDGenerator(clock.Real)
+	hopByHop := make([][]uint32, goroutines)
+	endToEnd := make([][]uint32, goroutines)
+	var wg sync.WaitGroup
+	for i := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range perGoroutine {
+				hopByHop[i] = append(hopByHop[i], g.HopByHopID())
+				endToEnd[i] = append(endToEnd[i], g.EndToEndID())
+			}
+		}()
+	}
+	wg.Wait()
+	for name, ids := range map[string][][]uint32{"Hop-by-Hop": hopByHop, "End-to-End": endToEnd} {
+		seen := make(map[uint32]bool, goroutines*perGoroutine)
+		for _, batch := range ids {
+			for _, id := range batch {
+				if seen[id] {
+					t.Fatalf("%s Identifier %#x handed out twice", name, id)
+				}
+				seen[id] = true
+			}
+		}
+	}
+}
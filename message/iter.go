@@ -0,0 +1,70 @@
+// Iteration over the AVPs of a message
+package message
+
+import (
+	"iter"
+	"slices"
+)
+
+// All returns an iterator over the top-level AVPs of msg in message order.
+func (msg *DiameterMessage) All() iter.Seq[*AVP] {
+	return func(yield func(*AVP) bool) {
+		for _, avp := range msg.AVPs {
+			if !yield(avp) {
+				return
+			}
+		}
+	}
+}
+
+// Walk returns an iterator over every AVP of msg, the members of Grouped
+// AVPs included, in the order they appear on the wire: a Grouped AVP
+// comes before its members. Each AVP is yielded with its path, the codes
+// of the Grouped AVPs enclosing it followed by its own. The path may be
+// kept but must not be modified. The tree is walked iteratively, so deep
+// nesting cannot exhaust the stack, and stopping early is safe.
+func (msg *DiameterMessage) Walk() iter.Seq2[[]uint32, *AVP] {
+	return func(yield func([]uint32, *AVP) bool) {
+		walkAVPs(msg.AVPs, yield)
+	}
+}
+
+func walkAVPs(avps []*AVP, yield func([]uint32, *AVP) bool) {
+	type level struct {
+		avps []*AVP
+		path []uint32
+	}
+	stack := []level{{avps: avps}}
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if len(top.avps) == 0 {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		avp := top.avps[0]
+		top.avps = top.avps[1:]
+		// Clipping makes append copy, so paths never share an array.
+		path := append(slices.Clip(top.path), avp.Code)
+		if !yield(path, avp) {
+			return
+		}
+		if g, ok := avp.Data.(*Grouped); ok && len(g.AVPs) > 0 {
+			stack = append(stack, level{avps: g.AVPs, path: path})
+		}
+	}
+}
+
+// FindAVP returns the first AVP, in message order, found at path: the
+// top-level AVP with the first code, the member of that Grouped AVP with
+// the second, and so on. It returns nil when there is none.
+func (msg *DiameterMessage) FindAVP(path ...uint32) *AVP {
+	if len(path) == 0 {
+		return nil
+	}
+	for p, avp := range msg.Walk() {
+		if slices.Equal(p, path) {
+			return avp
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package message
+
+import (
+	"slices"
+	"testing"
+)
+
+// iterFixture returns a message holding a Session-Id, failedAVP and an
+// Origin-Host, in that order.
+func iterFixture(t *testing.T) *DiameterMessage {
+	t.Helper()
+	return &DiameterMessage{Header: &DiameterHeader{CommandCode: COMMAND_CODE_CREDIT_CONTROL}, AVPs: []*AVP{
+		MustNewAVP(AVP_SESSION_ID, "client.example.com;1;1", MANDATORY_FLAG),
+		failedAVP(t),
+		MustNewAVP(AVP_ORIGIN_HOST, "client.example.com", MANDATORY_FLAG),
+	}}
+}
+
+func TestAll(t *testing.T) {
+	msg := iterFixture(t)
+	var got []*AVP
+	for avp := range msg.All() {
+		got = append(got, avp)
+	}
+	if !slices.Equal(got, msg.AVPs) {
+		t.Errorf("All yielded %v, want %v", codes(got), codes(msg.AVPs))
+	}
+	for avp := range msg.All() {
+		if avp.Code != AVP_SESSION_ID {
+			t.Errorf("first AVP %d", avp.Code)
+		}
+		break
+	}
+}
+
+func TestWalk(t *testing.T) {
+	msg := iterFixture(t)
+	want := [][]uint32{
+		{AVP_SESSION_ID},
+		{AVP_FAILED_AVP},
+		{AVP_FAILED_AVP, AVP_PROXY_INFO},
+		{AVP_FAILED_AVP, AVP_PROXY_INFO, AVP_PROXY_HOST},
+		{AVP_FAILED_AVP, AVP_PROXY_INFO, AVP_PROXY_STATE},
+		{AVP_FAILED_AVP, AVP_VENDOR_SPECIFIC_APPLICATION_ID},
+		{AVP_FAILED_AVP, AVP_VENDOR_SPECIFIC_APPLICATION_ID, AVP_VENDOR_ID},
+		{AVP_FAILED_AVP, AVP_VENDOR_SPECIFIC_APPLICATION_ID, AVP_AUTH_APPLICATION_ID},
+		{AVP_ORIGIN_HOST},
+	}
+	var paths [][]uint32
+	for path, avp := range msg.Walk() {
+		if avp.Code != path[len(path)-1] {
+			t.Errorf("AVP %d yielded at %v", avp.Code, path)
+		}
+		// The paths are kept, so later ones must not overwrite them.
+		paths = append(paths, path)
+	}
+	if !slices.EqualFunc(paths, want, slices.Equal) {
+		t.Errorf("Walk yielded\n%v\nwant\n%v", paths, want)
+	}
+
+	// Stopping early does not yield any further.
+	var n int
+	for path := range msg.Walk() {
+		n++
+		if len(path) == 3 {
+			break
+		}
+	}
+	if n != 4 {
+		t.Errorf("Walk yielded %d AVPs before the break, want 4", n)
+	}
+}
+
+func TestFindAVP(t *testing.T) {
+	msg := iterFixture(t)
+	for _, tc := range []struct {
+		path []uint32
+		// want is the code of the AVP found, 0 for none.
+		want uint32
+	}{
+		{[]uint32{AVP_ORIGIN_HOST}, AVP_ORIGIN_HOST},
+		{[]uint32{AVP_FAILED_AVP, AVP_PROXY_INFO, AVP_PROXY_HOST}, AVP_PROXY_HOST},
+		{[]uint32{AVP_FAILED_AVP, AVP_VENDOR_SPECIFIC_APPLICATION_ID}, AVP_VENDOR_SPECIFIC_APPLICATION_ID},
+		// The path is anchored at the top level.
+		{[]uint32{AVP_PROXY_INFO}, 0},
+		{[]uint32{AVP_FAILED_AVP, AVP_PROXY_HOST}, 0},
+		{nil, 0},
+	} {
+		avp := msg.FindAVP(tc.path...)
+		switch {
+		case tc.want == 0 && avp != nil:
+			t.Errorf("FindAVP(%v) = %d, want none", tc.path, avp.Code)
+		case tc.want != 0 && (avp == nil || avp.Code != tc.want):
+			t.Errorf("FindAVP(%v) = %v, want AVP %d", tc.path, avp, tc.want)
+		}
+	}
+	if got, want := msg.FindAVP(AVP_FAILED_AVP, AVP_PROXY_INFO, AVP_PROXY_STATE), group(t, group(t, msg.AVPs[1]).AVPs[0]).AVPs[1]; got != want {
+		t.Errorf("FindAVP returned %v, want the AVP of the message", got)
+	}
+}
@@ -0,0 +1,101 @@
+package message
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestMessageJSONRoundTrip(t *testing.T) {
+	subscription, err := NewGroupedAVP(AVP_VENDOR_SPECIFIC_APPLICATION_ID, MANDATORY_FLAG, 0,
+		MustNewAVP(AVP_VENDOR_ID, uint32(VENDOR_3GPP), MANDATORY_FLAG),
+		MustNewAVP(AVP_AUTH_APPLICATION_ID, APPLICATION_ID_CREDIT_CONTROL, MANDATORY_FLAG),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := NewRequest(COMMAND_CODE_RE_AUTH, WithApplication(APPLICATION_ID_CREDIT_CONTROL), WithAVPs(
+		MustNewAVP(AVP_SESSION_ID, "server.example.com;1;1", MANDATORY_FLAG),
+		MustNewAVP(AVP_ORIGIN_HOST, "server.example.com", MANDATORY_FLAG),
+		MustNewAVP(AVP_RE_AUTH_REQUEST_TYPE, uint32(1), MANDATORY_FLAG),
+		MustNewAVP(AVP_HOST_IP_ADDRESS, net.ParseIP("192.0.2.1"), MANDATORY_FLAG),
+		MustNewAVP(AVP_CLASS, "\x00\xffbytes", MANDATORY_FLAG),
+		subscription,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := msg.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("marshalling: %v", err)
+	}
+	var decoded DiameterMessage
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unmarshalling %s: %v", data, err)
+	}
+	got, err := decoded.Encode()
+	if err != nil {
+		t.Fatalf("encoding the unmarshalled message: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("JSON %s encodes as\n%x\nwant\n%x", data, got, want)
+	}
+}
+
+func TestAVPJSONValues(t *testing.T) {
+	for _, tc := range []struct {
+		avp  *AVP
+		json string
+	}{
+		{MustNewAVP(AVP_ORIGIN_HOST, "peer.example.com", MANDATORY_FLAG), `{"code":264,"flags":64,"value":"peer.example.com"}`},
+		{MustNewAVP(AVP_RESULT_CODE, uint32(2001), MANDATORY_FLAG), `{"code":268,"flags":64,"value":2001}`},
+		{MustNewAVP(AVP_HOST_IP_ADDRESS, net.ParseIP("2001:db8::1"), MANDATORY_FLAG), `{"code":257,"flags":64,"value":"2001:db8::1"}`},
+	} {
+		data, err := json.Marshal(tc.avp)
+		if err != nil {
+			t.Fatalf("AVP %d: %v", tc.avp.Code, err)
+		}
+		if string(data) != tc.json {
+			t.Errorf("AVP %d marshals as %s, want %s", tc.avp.Code, data, tc.json)
+		}
+	}
+}
+
+func TestAVPJSONUnknownAndInvalid(t *testing.T) {
+	var unknown AVP
+	if err := json.Unmarshal([]byte(`{"code":99999,"flags":0,"value":"AQI="}`), &unknown); err != nil {
+		t.Fatalf("unknown AVP: %v", err)
+	}
+	if octets, ok := unknown.Data.(*OctetString); !ok || !bytes.Equal(octets.Data, []byte{1, 2}) {
+		t.Errorf("unknown AVP decoded as %T %v, want OctetString 0102", unknown.Data, unknown.Data)
+	}
+	if unknown.AVPlength != AVPHeaderLength+2 {
+		t.Errorf("unknown AVP length %d, want %d", unknown.AVPlength, AVPHeaderLength+2)
+	}
+
+	var invalid AVP
+	err := json.Unmarshal([]byte(`{"code":268,"flags":64,"value":"success"}`), &invalid)
+	if err == nil || !strings.Contains(err.Error(), "AVP 268") {
+		t.Errorf("string for Result-Code: %v, want an error naming the AVP", err)
+	}
+
+	var vendor AVP
+	if err := json.Unmarshal([]byte(`{"code":99999,"flags":0,"vendor_id":10415,"value":"AQ=="}`), &vendor); err != nil {
+		t.Fatal(err)
+	}
+	if vendor.Flags&VENDOR_FLAG == 0 || vendor.AVPlength != AVPHeaderLengthWithV+1 {
+		t.Errorf("vendor AVP flags %#x and length %d", vendor.Flags, vendor.AVPlength)
+	}
+	var syntax *json.SyntaxError
+	if err := json.Unmarshal([]byte(`{"code":`), &vendor); !errors.As(err, &syntax) {
+		t.Errorf("truncated JSON: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+// Load information of RFC 8583
+package message
+
+// AVPs of the Diameter Overload Indication Conveyance load extension.
+const (
+	AVP_SOURCE_ID  = uint32(649) // Type: DiameterIdentity
+	AVP_LOAD       = uint32(650) // Type: Grouped
+	AVP_LOAD_TYPE  = uint32(651) // Type: Enumerated
+	AVP_LOAD_VALUE = uint32(652) // Type: Unsigned64
+)
+
+// Load-Type values.
+const (
+	LOAD_TYPE_HOST = uint32(0)
+	LOAD_TYPE_PEER = uint32(1)
+)
+
+func init() {
+	avpTypeMap[AVP_SOURCE_ID] = func() AVPData { return &DiameterIdentity{} }
+	avpTypeMap[AVP_LOAD] = func() AVPData { return &Grouped{} }
+	avpTypeMap[AVP_LOAD_TYPE] = func() AVPData { return &Enumerated{} }
+	avpTypeMap[AVP_LOAD_VALUE] = func() AVPData { return &Unsigned64{} }
+}
+
+// Load is the content of a Load AVP. Value is relative: 0 means idle and
+// 65535 fully loaded.
+type Load struct {
+	Type     uint32
+	Value    uint64
+	SourceID string
+}
+
+// NewLoadAVP builds a Load AVP for load. SourceID is only included when
+// set, which RFC 8583 requires for Load-Type PEER.
+func NewLoadAVP(load Load) (*AVP, error) {
+	loadType := &AVP{Code: AVP_LOAD_TYPE, Data: &Enumerated{Data: load.Type}}
+	loadValue := &AVP{Code: AVP_LOAD_VALUE, Data: &Unsigned64{Data: load.Value}}
+	avps := []*AVP{loadType, loadValue}
+	if load.SourceID != "" {
+		avps = append(avps, &AVP{Code: AVP_SOURCE_ID, Data: &DiameterIdentity{Data: load.SourceID}})
+	}
+	for _, avp := range avps {
+		avp.Refresh()
+	}
+	group, err := NewGroupedAVP(AVP_LOAD, 0, 0, avps...)
+	if err != nil {
+		return nil, err
+	}
+	group.Refresh()
+	return group, nil
+}
+
+// ParseLoad returns the first Load AVP of msg.
+func ParseLoad(msg *DiameterMessage) (Load, bool) {
+	avp := msg.GetAVP(AVP_LOAD)
+	if avp == nil {
+		return Load{}, false
+	}
+	group, err := avp.Group()
+	if err != nil {
+		return Load{}, false
+	}
+	var load Load
+	if a, ok := group.Get(AVP_LOAD_TYPE); ok {
+		load.Type, _ = a.Uint32()
+	}
+	a, ok := group.Get(AVP_LOAD_VALUE)
+	if !ok {
+		return Load{}, false
+	}
+	if load.Value, err = a.Uint64(); err != nil {
+		return Load{}, false
+	}
+	if a, ok := group.Get(AVP_SOURCE_ID); ok {
+		load.SourceID, _ = a.Str()
+	}
+	return load, true
+}
@@ -0,0 +1,38 @@
+package message
+
+import "testing"
+
+func TestLoadRoundTrip(t *testing.T) {
+	for _, load := range []Load{
+		{Type: LOAD_TYPE_HOST, Value: 0},
+		{Type: LOAD_TYPE_HOST, Value: 65535},
+		{Type: LOAD_TYPE_PEER, Value: 1234, SourceID: "agent.example.com"},
+	} {
+		avp, err := NewLoadAVP(load)
+		if err != nil {
+			t.Fatalf("NewLoadAVP(%+v): %v", load, err)
+		}
+		msg := newTestCCR(t)
+		msg.AVPs = append(msg.AVPs, avp)
+		got, ok := ParseLoad(roundTrip(t, msg))
+		if !ok || got != load {
+			t.Errorf("ParseLoad = %+v, %v; want %+v", got, ok, load)
+		}
+	}
+}
+
+func TestParseLoadAbsent(t *testing.T) {
+	msg := newTestCCR(t)
+	if load, ok := ParseLoad(msg); ok {
+		t.Errorf("ParseLoad = %+v without a Load AVP", load)
+	}
+	// Load-Value is required.
+	group, err := NewGroupedAVP(AVP_LOAD, 0, 0, &AVP{Code: AVP_LOAD_TYPE, Data: &Enumerated{Data: LOAD_TYPE_HOST}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.AVPs = append(msg.AVPs, group)
+	if load, ok := ParseLoad(msg); ok {
+		t.Errorf("ParseLoad = %+v without Load-Value", load)
+	}
+}
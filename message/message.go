@@ -4,7 +4,6 @@ package message
 import (
 	"fmt"
 	"github.com/IbrahimShahzad/diameter/utils"
-	"math/rand/v2"
 )
 
 const (
@@ -31,15 +30,23 @@ const (
 const (
 	COMMAND_CODE_CER = uint32(257)
 	COMMAND_CODE_DWR = uint32(280)
+	COMMAND_CODE_CCR = uint32(272)
 )
 
+// GetCommandNameFromCode returns the registered request name of code.
+//
+// Deprecated: use CommandName, which also names answers.
 func GetCommandNameFromCode(code uint32) string {
-	return CommandCodeToName[code]
+	name, _ := LookupCommandName(code, FlagRequest)
+	return name
 }
 
+// CommandCodeToName maps a few command codes to their request name.
+//
+// Deprecated: use CommandName and RegisterCommandName.
 var CommandCodeToName map[uint32]string = map[uint32]string{
 	COMMAND_CODE_CER: "Capabilities-Exchange-Request",
-	COMMAND_CODE_DWR: "Diameter-Watchdog-Request",
+	COMMAND_CODE_DWR: "Device-Watchdog-Request",
 }
 
 const DIAMETER_VERSION = 1
@@ -56,7 +63,7 @@ const DIAMETER_VERSION = 1
 type DiameterHeader struct {
 	Version       uint8
 	MessageLength uint32
-	CommandFlags  uint8
+	CommandFlags  CommandFlags
 	CommandCode   uint32
 	ApplicationID uint32
 	HopByHopID    uint32
@@ -65,11 +72,12 @@ type DiameterHeader struct {
 
 func (h *DiameterHeader) String() string {
 	return fmt.Sprintf(
-		"Version: %d\nMessageLength: %d\nCommandFlags: %d\nCommandCode: %d\nApplicationID: %d\nHopByHopID: %d\nEndToEndID: %d\n",
+		"Version: %d\nMessageLength: %d\nCommandFlags: %s\nCommandCode: %d (%s)\nApplicationID: %d\nHopByHopID: %d\nEndToEndID: %d\n",
 		h.Version,
 		h.MessageLength,
 		h.CommandFlags,
 		h.CommandCode,
+		CommandName(h.CommandCode, h.CommandFlags),
 		h.ApplicationID,
 		h.HopByHopID,
 		h.EndToEndID,
@@ -85,22 +93,22 @@ func (h *DiameterHeader) Encode() []byte {
 	header[byteCount] = h.Version
 	byteCount += DIAMETER_VERSION_SIZE
 
-	copy(header[byteCount:], utils.ToBytes(h.MessageLength, DIAMETER_MESSAGE_SIZE))
+	utils.PutUint24(header[byteCount:], h.MessageLength)
 	byteCount += DIAMETER_MESSAGE_SIZE
 
-	header[byteCount] = h.CommandFlags
+	header[byteCount] = byte(h.CommandFlags)
 	byteCount += DIAMETER_COMMAND_FLAGS_SIZE
 
-	copy(header[byteCount:], utils.ToBytes(h.CommandCode, DIAMETER_COMMAND_CODE_SIZE))
+	utils.PutUint24(header[byteCount:], h.CommandCode)
 	byteCount += DIAMETER_COMMAND_CODE_SIZE
 
-	copy(header[byteCount:], utils.ToBytes(h.ApplicationID, DIAMETER_APPLICATION_ID_SIZE))
+	utils.PutUint32(header[byteCount:], h.ApplicationID)
 	byteCount += DIAMETER_APPLICATION_ID_SIZE
 
-	copy(header[byteCount:], utils.ToBytes(h.HopByHopID, DIAMETER_HOP_BY_HOP_ID_SIZE))
+	utils.PutUint32(header[byteCount:], h.HopByHopID)
 	byteCount += DIAMETER_HOP_BY_HOP_ID_SIZE
 
-	copy(header[byteCount:], utils.ToBytes(h.EndToEndID, DIAMETER_END_TO_END_ID_SIZE))
+	utils.PutUint32(header[byteCount:], h.EndToEndID)
 	byteCount += DIAMETER_END_TO_END_ID_SIZE
 
 	return header
@@ -118,41 +126,54 @@ func (h *DiameterHeader) Decode(data []byte) error {
 		return InvalidDiameterVersionError
 	}
 
-	h.MessageLength = utils.FromBytes(data[byteCount : byteCount+DIAMETER_MESSAGE_SIZE])
+	h.MessageLength = utils.Uint24(data[byteCount:])
 	byteCount += DIAMETER_MESSAGE_SIZE
 
-	h.CommandFlags = data[byteCount]
+	h.CommandFlags = CommandFlags(data[byteCount])
 	byteCount += DIAMETER_COMMAND_FLAGS_SIZE
 
-	h.CommandCode = utils.FromBytes(data[byteCount : byteCount+DIAMETER_COMMAND_CODE_SIZE])
+	h.CommandCode = utils.Uint24(data[byteCount:])
 	byteCount += DIAMETER_COMMAND_CODE_SIZE
 
-	h.ApplicationID = utils.FromBytes(data[byteCount : byteCount+DIAMETER_APPLICATION_ID_SIZE])
+	h.ApplicationID = utils.Uint32(data[byteCount:])
 	byteCount += DIAMETER_APPLICATION_ID_SIZE
 
-	h.HopByHopID = utils.FromBytes(data[byteCount : byteCount+DIAMETER_HOP_BY_HOP_ID_SIZE])
+	h.HopByHopID = utils.Uint32(data[byteCount:])
 	byteCount += DIAMETER_HOP_BY_HOP_ID_SIZE
 
-	h.EndToEndID = utils.FromBytes(data[byteCount : byteCount+DIAMETER_END_TO_END_ID_SIZE])
+	h.EndToEndID = utils.Uint32(data[byteCount:])
 	byteCount += DIAMETER_END_TO_END_ID_SIZE
 
 	return nil
 }
 
-func generateHopByHopID() uint32 {
-	return rand.Uint32()
-}
-
-func generateEndToEndID() uint32 {
-	return rand.Uint32()
-}
-
 // DiameterMessage represents a Diameter message with header and AVPs.
+//
+// AVPs are kept in the order they were decoded or added, down to the AVPs
+// of Grouped values, and the helpers of this package that add, replace or
+// remove AVPs leave the others where they are. Normalize is the only
+// function that reorders AVPs, to put those with a fixed position in
+// place. Encode writes them in that order, unless WithKeepOrder is given,
+// but leaves the message itself as it is. A decoded
+// message encodes back to the bytes it was decoded from as long as its
+// fixed-position AVPs were in place and its padding was zero.
 type DiameterMessage struct {
 	Header *DiameterHeader
 	AVPs   []*AVP
 }
 
+// DecodeErrors returns an error for every AVP whose value tolerant
+// decoding kept raw, in message order.
+func (msg *DiameterMessage) DecodeErrors() []AVPDecodeError {
+	var errs []AVPDecodeError
+	for _, avp := range msg.Walk() {
+		if avp.decodeErr != nil {
+			errs = append(errs, AVPDecodeError{AVP: avp, Err: avp.decodeErr})
+		}
+	}
+	return errs
+}
+
 func (m *DiameterMessage) String() string {
 	var avps string
 	for _, avp := range m.AVPs {
@@ -165,113 +186,210 @@ func (m *DiameterMessage) String() string {
 	)
 }
 
+// maxMessageLength is the largest length the 24-bit Message Length field
+// can carry.
+const maxMessageLength = 1<<24 - 1
+
+// Encode returns the wire form of the message. AVPs with a fixed position,
+// such as Session-Id, are encoded in their place without reordering the
+// AVPs of msg, so that a message can be encoded by several goroutines at
+// once, as when it is retransmitted.
 func (msg *DiameterMessage) Encode() ([]byte, error) {
-	// Encode the header
-	header := msg.Header.Encode()
+	return msg.encode(EncodeOptions{})
+}
+
+// EncodeMessage returns the wire form of msg encoded with opts.
+func EncodeMessage(msg *DiameterMessage, opts ...EncodeOption) ([]byte, error) {
+	var o EncodeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return msg.encode(o)
+}
+
+func (msg *DiameterMessage) encode(opts EncodeOptions) ([]byte, error) {
+	list := msg.AVPs
+	switch {
+	case opts.Canonical:
+		list = canonicalOrder(msg.AVPs, fixedAVPs(msg))
+	case !opts.KeepOrder:
+		list = normalizedOrder(msg.AVPs, fixedAVPs(msg))
+	}
+	maxDepth := opts.MaxGroupDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxGroupDepth
+	}
+	if err := checkGroupDepth(list, maxDepth); err != nil {
+		return nil, err
+	}
 
 	// Encode each AVP
 	avps := make([]byte, 0)
-	for _, avp := range msg.AVPs {
-		encoded, err := avp.Encode()
+	for _, avp := range list {
+		encoded, err := avp.encode(opts.Canonical)
 		if err != nil {
 			return nil, err
 		}
 		avps = append(avps, encoded...)
 	}
 
+	// Encode the header now that the message length is known
+	limit := uint32(maxMessageLength)
+	if opts.MaxMessageLength > 0 && opts.MaxMessageLength < limit {
+		limit = opts.MaxMessageLength
+	}
+	if DIAMETER_HEADER_SIZE+len(avps) > int(limit) {
+		return nil, MessageTooLargeError
+	}
+	// The length is only stored when it changed, which keeps concurrent
+	// encodings of a message from writing to it.
+	if length := uint32(DIAMETER_HEADER_SIZE + len(avps)); msg.Header.MessageLength != length {
+		msg.Header.MessageLength = length
+	}
+	header := msg.Header.Encode()
+	if opts.Canonical {
+		header[4] &= byte(commandFlagsMask)
+	}
+
 	// Concatenate the header and AVPs
 	return append(header, avps...), nil
 }
 
+// Decode decodes msg from data with the default DecodeOptions, that is
+// tolerating undecodable AVP values in answers only.
 func (msg *DiameterMessage) Decode(data []byte) error {
-	if len(data) < DIAMETER_HEADER_SIZE {
-		return InvalidMessageLengthError
-	}
+	return msg.decode(data, DecodeOptions{})
+}
 
-	version := data[0]
-	msgStart := DIAMETER_VERSION_SIZE
-	msgLenEnd := msgStart + DIAMETER_MESSAGE_SIZE
-	msgLen := utils.FromBytes(data[msgStart : msgLenEnd+1])
-	if len(data) < int(msgLen) {
+func (msg *DiameterMessage) decode(data []byte, opts DecodeOptions) error {
+	if len(data) < DIAMETER_HEADER_SIZE {
 		return InvalidMessageLengthError
 	}
 
-	cFlags := data[msgLenEnd]
-
-	cmdCodeStart := msgLenEnd + DIAMETER_COMMAND_FLAGS_SIZE
-	cmdCodeEnd := cmdCodeStart + DIAMETER_COMMAND_CODE_SIZE
-	cmdCode := utils.FromBytes(data[cmdCodeStart : cmdCodeEnd+1])
-
-	appIDEnd := cmdCodeEnd + DIAMETER_APPLICATION_ID_SIZE
-	appID := utils.FromBytes(data[cmdCodeEnd:appIDEnd])
-
-	hopByHopIDEnd := appIDEnd + DIAMETER_HOP_BY_HOP_ID_SIZE
-	hopByHopID := utils.FromBytes(data[appIDEnd:hopByHopIDEnd])
-
-	endToEndIDEnd := hopByHopIDEnd + DIAMETER_END_TO_END_ID_SIZE
-	endToEndID := utils.FromBytes(data[hopByHopIDEnd:endToEndIDEnd])
-
-	if version != DIAMETER_VERSION {
-		return InvalidDiameterVersionError
-	}
-
 	// Decode the header
-	msg.Header = &DiameterHeader{
-		Version:       version,
-		MessageLength: msgLen,
-		CommandFlags:  cFlags,
-		CommandCode:   cmdCode,
-		ApplicationID: appID,
-		HopByHopID:    hopByHopID,
-		EndToEndID:    endToEndID,
+	header := &DiameterHeader{}
+	if err := header.Decode(data); err != nil {
+		return err
 	}
-	// fmt.Println("DiameterMessage Header: ", msg.Header)
+	if header.MessageLength < DIAMETER_HEADER_SIZE || len(data) < int(header.MessageLength) {
+		return InvalidMessageLengthError
+	}
+	msg.Header = header
 
 	// Decode each AVP
-	offset := DIAMETER_HEADER_SIZE
-	avps, err := extractAVPs(data[offset:])
+	tolerant := opts.tolerant(header.CommandFlags.Request())
+	avps, err := decodeAVPs(data[DIAMETER_HEADER_SIZE:header.MessageLength], opts, tolerant, 0)
 	if err != nil {
 		return err
 	}
-	// fmt.Println("DiameterMessage AVPs: ", avps)
 	msg.AVPs = avps
 
 	return nil
 }
 
-// NewCER generates a Capabilities-Exchange-Request message.
-func NewCER(avps ...*AVP) (*DiameterMessage, error) {
-	return &DiameterMessage{
+// DecodeMessage decodes a complete Diameter message from data. Without
+// options it uses the defaults documented on DecodeOptions. data may come
+// from an untrusted peer: truncated headers, AVP Lengths shorter than the
+// AVP header, past the end of data or not matching the size of a
+// fixed-size value, and Grouped AVPs nested deeper than the maximum group
+// depth are reported as errors, and values are only allocated up to the
+// length of data. ResultCodeForError maps the length errors to
+// DIAMETER_INVALID_AVP_LENGTH.
+func DecodeMessage(data []byte, opts ...DecodeOption) (*DiameterMessage, error) {
+	msg := &DiameterMessage{}
+	if err := msg.decode(data, newDecodeOptions(opts)); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// NewAnswer creates an answer to req. The answer carries the same command
+// code, application and identifiers as the request with the 'R' bit
+// cleared; the 'P' bit is copied from the request. The Session-Id of req,
+// unless avps carry one, is copied to the front of the answer as RFC 6733
+// section 8.8 requires. The Proxy-Info AVPs of req are echoed after avps,
+// in their original order.
+func NewAnswer(req *DiameterMessage, avps ...*AVP) *DiameterMessage {
+	if sessionID := req.GetAVP(AVP_SESSION_ID); sessionID != nil && !hasAVP(avps, AVP_SESSION_ID) {
+		avps = append([]*AVP{sessionID.Clone()}, avps...)
+	}
+	ans := &DiameterMessage{
 		Header: &DiameterHeader{
 			Version:       DIAMETER_VERSION,
-			CommandFlags:  COMMAND_FLAG_REQUEST, // Set 'R' bit for request
-			CommandCode:   COMMAND_CODE_CER,     // CER Command Code
-			ApplicationID: 0,                    // Base Protocol Application ID
-			HopByHopID:    generateHopByHopID(),
-			EndToEndID:    generateEndToEndID(),
+			CommandFlags:  NewCommandFlags(false, req.Header.CommandFlags.Proxiable(), false, false),
+			CommandCode:   req.Header.CommandCode,
+			ApplicationID: req.Header.ApplicationID,
+			HopByHopID:    req.Header.HopByHopID,
+			EndToEndID:    req.Header.EndToEndID,
 		},
 		AVPs: avps,
-	}, nil
+	}
+	if infos := ExtractProxyInfo(req); len(infos) > 0 {
+		AttachProxyInfo(ans, infos)
+	}
+	return ans
+}
+
+// hasAVP reports whether avps include one with code at the top level.
+func hasAVP(avps []*AVP, code uint32) bool {
+	for _, avp := range avps {
+		if avp.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRequest reports whether the 'R' bit is set in the message header.
+func (msg *DiameterMessage) IsRequest() bool {
+	return msg.Header.CommandFlags.Request()
+}
+
+// IsAnswer reports whether the 'R' bit is clear in the message header.
+func (msg *DiameterMessage) IsAnswer() bool {
+	return !msg.IsRequest()
+}
+
+// CheckAnswer verifies that msg is an answer to a request with command
+// code. The 'R' bit must be clear, so that a request looped back to its
+// sender is not taken for the answer; UnexpectedRequestError is returned
+// otherwise, which ResultCodeForError maps to DIAMETER_INVALID_HDR_BITS.
+func CheckAnswer(msg *DiameterMessage, code uint32) error {
+	if msg.IsRequest() {
+		return fmt.Errorf("%w: %s", UnexpectedRequestError, msg.CommandName())
+	}
+	if msg.Header.CommandCode != code {
+		return InvalidCommandCodeError
+	}
+	return nil
+}
+
+// IsStateless reports whether the message carries Auth-Session-State set
+// to NO_STATE_MAINTAINED, so that no session state is kept for it.
+func (msg *DiameterMessage) IsStateless() bool {
+	avp := msg.GetAVP(AVP_AUTH_SESSION_STATE)
+	if avp == nil {
+		return false
+	}
+	state, err := avp.Uint32()
+	return err == nil && state == NO_STATE_MAINTAINED
+}
+
+// NewCER generates a Capabilities-Exchange-Request message.
+func NewCER(avps ...*AVP) (*DiameterMessage, error) {
+	return NewRequest(COMMAND_CODE_CER, WithAVPs(avps...))
 }
 
 func NewDWR(avps ...*AVP) (*DiameterMessage, error) {
-	return &DiameterMessage{
-		Header: &DiameterHeader{
-			Version:       DIAMETER_VERSION,
-			CommandFlags:  COMMAND_FLAG_REQUEST, // Set 'R' bit for request
-			CommandCode:   COMMAND_CODE_DWR,     // DWR Command Code
-			ApplicationID: 0,                    // Base Protocol Application ID
-			HopByHopID:    generateHopByHopID(),
-			EndToEndID:    generateEndToEndID(),
-		},
-		AVPs: avps,
-	}, nil
+	return NewRequest(COMMAND_CODE_DWR, WithAVPs(avps...))
 }
 
 // read CEA message, check Success or Failure and return AVPs
+//
+// Deprecated: Use ParseCEA, which returns the content of the CEA.
 func ReadCEA(cea DiameterMessage) ([]*AVP, error) {
-	if cea.Header.CommandCode != COMMAND_CODE_CER {
-		return nil, InvalidCommandCodeError
+	if err := CheckAnswer(&cea, COMMAND_CODE_CER); err != nil {
+		return nil, err
 	}
 
 	// check for mandatory AVPs
@@ -288,3 +406,17 @@ func ReadCEA(cea DiameterMessage) ([]*AVP, error) {
 	}
 	return cea.AVPs, nil
 }
+
+// Clone returns a deep copy of msg. Modifying the copy, including the
+// values of its AVPs, never affects msg.
+func (msg *DiameterMessage) Clone() *DiameterMessage {
+	clone := &DiameterMessage{}
+	if msg.Header != nil {
+		header := *msg.Header
+		clone.Header = &header
+	}
+	if msg.AVPs != nil {
+		clone.AVPs = cloneAVPs(msg.AVPs)
+	}
+	return clone
+}
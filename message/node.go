@@ -0,0 +1,178 @@
+// Local node identity and builders for the base protocol messages
+package message
+
+import (
+	"net"
+	"runtime/debug"
+	"strconv"
+	"sync"
+)
+
+// FirmwareRevision is the default Firmware-Revision, as a decimal string so
+// that it can be injected at link time:
+//
+//	go build -ldflags "-X github.com/IbrahimShahzad/diameter/message.FirmwareRevision=42"
+var FirmwareRevision string
+
+// DefaultProductName returns the Product-Name used when none is configured:
+// the path and version of the main module of the binary, read from its
+// build info, or "diameter" when they are not available. It is the same
+// for the whole life of the process.
+var DefaultProductName = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Path == "" {
+		return "diameter"
+	}
+	if version := info.Main.Version; version != "" && version != "(devel)" {
+		return info.Main.Path + " " + version
+	}
+	return info.Main.Path
+})
+
+// DefaultFirmwareRevision returns FirmwareRevision as a number, or 0, for
+// no Firmware-Revision AVP, when it is not set or not a valid Unsigned32.
+func DefaultFirmwareRevision() uint32 {
+	revision, err := strconv.ParseUint(FirmwareRevision, 10, 32)
+	if err != nil {
+		return 0
+	}
+	return uint32(revision)
+}
+
+// Node is the identity a Diameter node advertises in the base protocol
+// messages it sends. Optional fields left at their zero value are not
+// sent.
+type Node struct {
+	OriginHost      string
+	OriginRealm     string
+	HostIPAddresses []net.IP
+	VendorID        uint32
+	ProductName     string
+	// OriginStateID, when not 0, is sent in CER, CEA, DWR and DWA.
+	OriginStateID uint32
+	// FirmwareRevision, when not 0, is sent in CER and CEA.
+	FirmwareRevision uint32
+}
+
+// Identity returns the normalized identity of the node.
+func (n Node) Identity() PeerIdentity {
+	return NewPeerIdentity(n.OriginHost, n.OriginRealm)
+}
+
+// IdentityAVPs returns the Origin-Host and Origin-Realm AVPs of the node.
+func (n Node) IdentityAVPs() ([]*AVP, error) {
+	var b MessageBuilder
+	return b.Add(AVP_ORIGIN_HOST, n.OriginHost, MANDATORY_FLAG).
+		Add(AVP_ORIGIN_REALM, n.OriginRealm, MANDATORY_FLAG).
+		AVPs()
+}
+
+// originStateIDAVPs returns the Origin-State-Id AVP of the node, or nothing
+// when it is not set.
+func (n Node) originStateIDAVPs() ([]*AVP, error) {
+	if n.OriginStateID == 0 {
+		return nil, nil
+	}
+	var b MessageBuilder
+	return b.Add(AVP_ORIGIN_STATE_ID, n.OriginStateID, MANDATORY_FLAG).AVPs()
+}
+
+// capabilityAVPs returns the AVPs that CER and CEA share after the
+// identity, in the order of RFC 6733 sections 5.3.1 and 5.3.2.
+func (n Node) capabilityAVPs(apps Applications) ([]*AVP, error) {
+	var b MessageBuilder
+	for _, ip := range n.HostIPAddresses {
+		b.Add(AVP_HOST_IP_ADDRESS, ip, MANDATORY_FLAG)
+	}
+	b.Add(AVP_VENDOR_ID, n.VendorID, MANDATORY_FLAG).
+		Add(AVP_PRODUCT_NAME, n.ProductName, 0).
+		AddAVPs(n.originStateIDAVPs()).
+		AddAVPs(apps.AVPs())
+	if n.FirmwareRevision != 0 {
+		b.Add(AVP_FIRMWARE_REVISION, n.FirmwareRevision, 0)
+	}
+	return b.AVPs()
+}
+
+// request builds a request for code carrying the node identity followed by
+// avps.
+func (n Node) request(code uint32, avps []*AVP, opts []RequestOption) (*DiameterMessage, error) {
+	identity, err := n.IdentityAVPs()
+	if err != nil {
+		return nil, err
+	}
+	all := append(identity, avps...)
+	return NewRequest(code, append([]RequestOption{WithAVPs(all...)}, opts...)...)
+}
+
+// BuildAnswer builds an answer to req carrying resultCode, the node
+// identity and avps. Protocol errors get the 'E' bit.
+func (n Node) BuildAnswer(req *DiameterMessage, resultCode ResultCode, avps ...*AVP) (*DiameterMessage, error) {
+	result, err := NewAVP(AVP_RESULT_CODE, uint32(resultCode), MANDATORY_FLAG)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := n.IdentityAVPs()
+	if err != nil {
+		return nil, err
+	}
+	all := append(append([]*AVP{result}, identity...), avps...)
+	ans := NewAnswer(req, all...)
+	if resultCode.IsProtocolError() {
+		ans.Header.CommandFlags = ans.Header.CommandFlags.With(FlagError)
+	}
+	return ans, nil
+}
+
+// BuildCER builds a Capabilities-Exchange-Request advertising apps.
+func (n Node) BuildCER(apps Applications, opts ...RequestOption) (*DiameterMessage, error) {
+	avps, err := n.capabilityAVPs(apps)
+	if err != nil {
+		return nil, err
+	}
+	return n.request(COMMAND_CODE_CER, avps, opts)
+}
+
+// BuildCEA builds the Capabilities-Exchange-Answer to req advertising apps.
+// extra, such as Error-Message or Failed-AVP, follows the capabilities.
+func (n Node) BuildCEA(req *DiameterMessage, apps Applications, resultCode ResultCode, extra ...*AVP) (*DiameterMessage, error) {
+	avps, err := n.capabilityAVPs(apps)
+	if err != nil {
+		return nil, err
+	}
+	return n.BuildAnswer(req, resultCode, append(avps, extra...)...)
+}
+
+// BuildDWR builds a Device-Watchdog-Request.
+func (n Node) BuildDWR(opts ...RequestOption) (*DiameterMessage, error) {
+	avps, err := n.originStateIDAVPs()
+	if err != nil {
+		return nil, err
+	}
+	return n.request(COMMAND_CODE_DWR, avps, opts)
+}
+
+// BuildDWA builds a successful Device-Watchdog-Answer to req. extra
+// follows the Origin-State-Id.
+func (n Node) BuildDWA(req *DiameterMessage, extra ...*AVP) (*DiameterMessage, error) {
+	avps, err := n.originStateIDAVPs()
+	if err != nil {
+		return nil, err
+	}
+	return n.BuildAnswer(req, DIAMETER_SUCCESS, append(avps, extra...)...)
+}
+
+// BuildDPR builds a Disconnect-Peer-Request giving cause, one of the
+// DISCONNECT_CAUSE values.
+func (n Node) BuildDPR(cause uint32, opts ...RequestOption) (*DiameterMessage, error) {
+	avp, err := NewDisconnectCauseAVP(cause)
+	if err != nil {
+		return nil, err
+	}
+	return n.request(COMMAND_CODE_DISCONNECT_PEER, []*AVP{avp}, opts)
+}
+
+// BuildDPA builds a successful Disconnect-Peer-Answer to req.
+func (n Node) BuildDPA(req *DiameterMessage, extra ...*AVP) (*DiameterMessage, error) {
+	return n.BuildAnswer(req, DIAMETER_SUCCESS, extra...)
+}
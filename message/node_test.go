@@ -0,0 +1,139 @@
+package message
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// answerAVPs may appear in every answer of the base protocol commands, on
+// top of the AVPs of their request, RFC 6733 sections 5.3.2, 5.4.2 and
+// 5.5.2.
+var answerAVPs = []uint32{AVP_RESULT_CODE, AVP_ERROR_MESSAGE, AVP_FAILED_AVP}
+
+// requestOnly are the AVPs of requests that their answers do not carry.
+var requestOnly = []uint32{AVP_DISCONNECT_CAUSE}
+
+// repeatedAVPs may appear more than once in the base protocol commands.
+var repeatedAVPs = []uint32{
+	AVP_HOST_IP_ADDRESS,
+	AVP_SUPPORTED_VENDOR_ID,
+	AVP_AUTH_APPLICATION_ID,
+	AVP_ACCT_APPLICATION_ID,
+	AVP_VENDOR_SPECIFIC_APPLICATION_ID,
+}
+
+func TestNodeBuildersFollowCommandRules(t *testing.T) {
+	node := Node{
+		OriginHost:       "client.example.com",
+		OriginRealm:      "example.com",
+		HostIPAddresses:  []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")},
+		VendorID:         10415,
+		ProductName:      "test",
+		OriginStateID:    1_700_000_000,
+		FirmwareRevision: 42,
+	}
+	apps := Applications{
+		Auth:           NewApplicationSet(APPLICATION_ID_CREDIT_CONTROL),
+		Acct:           NewApplicationSet(APPLICATION_ID_BASE_ACCOUNTING),
+		VendorSpecific: []VendorApplication{{VendorID: 10415, ApplicationID: 16777238}},
+	}
+	build := func(msg *DiameterMessage, err error) *DiameterMessage {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	cer := build(node.BuildCER(apps))
+	dwr := build(node.BuildDWR())
+	dpr := build(node.BuildDPR(DISCONNECT_CAUSE_REBOOTING))
+
+	for _, tc := range []struct {
+		name string
+		msg  *DiameterMessage
+		code uint32
+		// want are the AVPs a node with every field set must send,
+		// beyond those the command requires.
+		want []uint32
+	}{
+		{"CER", cer, COMMAND_CODE_CER, []uint32{AVP_ORIGIN_STATE_ID, AVP_AUTH_APPLICATION_ID, AVP_ACCT_APPLICATION_ID, AVP_VENDOR_SPECIFIC_APPLICATION_ID, AVP_FIRMWARE_REVISION}},
+		{"CEA", build(node.BuildCEA(cer, apps, DIAMETER_SUCCESS)), COMMAND_CODE_CER, []uint32{AVP_RESULT_CODE, AVP_ORIGIN_STATE_ID, AVP_AUTH_APPLICATION_ID, AVP_ACCT_APPLICATION_ID, AVP_VENDOR_SPECIFIC_APPLICATION_ID, AVP_FIRMWARE_REVISION}},
+		{"DWR", dwr, COMMAND_CODE_DWR, []uint32{AVP_ORIGIN_STATE_ID}},
+		{"DWA", build(node.BuildDWA(dwr)), COMMAND_CODE_DWR, []uint32{AVP_RESULT_CODE, AVP_ORIGIN_STATE_ID}},
+		{"DPR", dpr, COMMAND_CODE_DISCONNECT_PEER, nil},
+		{"DPA", build(node.BuildDPA(dpr)), COMMAND_CODE_DISCONNECT_PEER, []uint32{AVP_RESULT_CODE}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd, ok := LookupCommand(tc.code)
+			if !ok {
+				t.Fatalf("command %d not registered", tc.code)
+			}
+			if tc.msg.Header.CommandCode != tc.code || tc.msg.Header.ApplicationID != cmd.ApplicationID {
+				t.Errorf("command %d application %d, want %d application %d", tc.msg.Header.CommandCode, tc.msg.Header.ApplicationID, tc.code, cmd.ApplicationID)
+			}
+			request := tc.msg.IsRequest()
+			if request != strings.HasSuffix(tc.name, "R") || tc.msg.Header.CommandFlags.Proxiable() || tc.msg.Header.CommandFlags.Error() {
+				t.Errorf("command flags %s", tc.msg.Header.CommandFlags)
+			}
+			if err := ValidateOutbound(tc.msg); err != nil {
+				t.Errorf("ValidateOutbound: %v", err)
+			}
+
+			required := cmd.RequiredAVPs
+			allowed := slices.Concat(cmd.RequiredAVPs, cmd.OptionalAVPs)
+			if !request {
+				notInAnswer := func(code uint32) bool { return slices.Contains(requestOnly, code) }
+				required = slices.DeleteFunc(slices.Clone(required), notInAnswer)
+				allowed = append(slices.DeleteFunc(allowed, notInAnswer), answerAVPs...)
+			}
+			counts := make(map[uint32]int)
+			for _, avp := range tc.msg.AVPs {
+				counts[avp.Code]++
+				if !slices.Contains(allowed, avp.Code) {
+					t.Errorf("AVP %d not allowed", avp.Code)
+				}
+				if counts[avp.Code] == 2 && !slices.Contains(repeatedAVPs, avp.Code) {
+					t.Errorf("AVP %d more than once", avp.Code)
+				}
+			}
+			for _, code := range slices.Concat(required, tc.want) {
+				if counts[code] == 0 {
+					t.Errorf("AVP %d missing", code)
+				}
+			}
+		})
+	}
+}
+
+func TestDefaultProductName(t *testing.T) {
+	name := DefaultProductName()
+	if name == "" {
+		t.Fatal("empty default Product-Name")
+	}
+	if again := DefaultProductName(); again != name {
+		t.Errorf("default Product-Name changed from %q to %q", name, again)
+	}
+}
+
+func TestDefaultFirmwareRevision(t *testing.T) {
+	saved := FirmwareRevision
+	t.Cleanup(func() { FirmwareRevision = saved })
+	for _, tc := range []struct {
+		injected string
+		want     uint32
+	}{
+		{"", 0},
+		{"42", 42},
+		{"4294967295", 4294967295},
+		{"4294967296", 0},
+		{"-1", 0},
+		{"v1.2", 0},
+	} {
+		FirmwareRevision = tc.injected
+		if got := DefaultFirmwareRevision(); got != tc.want {
+			t.Errorf("DefaultFirmwareRevision() with %q = %d, want %d", tc.injected, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package message
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// corpus returns every encoded message fixture of the package: the
+// Huawei CEA, the Gx CCA, the Wireshark fixtures and the seed corpus of
+// FuzzDecodeMessage.
+func corpus(t *testing.T) map[string][]byte {
+	t.Helper()
+	frames := map[string][]byte{
+		"huawei-cea":    huaweiCEA(t),
+		"gx-cca":        gxCCA(t),
+		"wireshark-ccr": wiresharkCCR(t),
+		"wireshark-cca": wiresharkCCA(t),
+	}
+	dir := filepath.Join("testdata", "fuzz", "FuzzDecodeMessage")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		text, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The files hold one []byte("...") value after the version line.
+		_, value, _ := strings.Cut(strings.TrimSpace(string(text)), "\n")
+		quoted, ok := strings.CutPrefix(value, "[]byte(")
+		if !ok {
+			t.Fatalf("%s: unexpected value %q", entry.Name(), value)
+		}
+		data, err := strconv.Unquote(strings.TrimSuffix(quoted, ")"))
+		if err != nil {
+			t.Fatalf("%s: %v", entry.Name(), err)
+		}
+		frames["fuzz/"+entry.Name()] = []byte(data)
+	}
+	return frames
+}
+
+// TestEncodeStable decodes every fixture that decodes and checks that
+// encoding it again, without changing anything, gives back the same bytes.
+func TestEncodeStable(t *testing.T) {
+	decoded := 0
+	for name, frame := range corpus(t) {
+		msg, err := DecodeMessage(frame, WithDecodeMode(DecodeTolerant))
+		if err != nil {
+			continue
+		}
+		decoded++
+		frame = frame[:msg.Header.MessageLength]
+		encoded, err := EncodeMessage(msg, WithKeepOrder())
+		if err != nil {
+			t.Errorf("%s: encoding: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(encoded, frame) {
+			t.Errorf("%s: encoded in order\n%x\nwant\n%x", name, encoded, frame)
+		}
+		// The fixtures have their fixed-position AVPs in place, so
+		// normalizing keeps the order too.
+		if encoded, err := msg.Encode(); err != nil || !bytes.Equal(encoded, frame) {
+			t.Errorf("%s: encoded\n%x, %v\nwant\n%x", name, encoded, err, frame)
+		}
+	}
+	if decoded < 3 {
+		t.Errorf("only %d fixtures decoded", decoded)
+	}
+}
+
+func codes(avps []*AVP) []uint32 {
+	var c []uint32
+	for _, avp := range avps {
+		c = append(c, avp.Code)
+	}
+	return c
+}
+
+// TestHelpersKeepOrder checks that the helpers adding or replacing AVPs,
+// and Normalize on a normalized message, leave the AVPs in their order.
+func TestHelpersKeepOrder(t *testing.T) {
+	newAnswer := func() *DiameterMessage {
+		return &DiameterMessage{Header: &DiameterHeader{CommandCode: COMMAND_CODE_CREDIT_CONTROL}, AVPs: []*AVP{
+			MustNewAVP(AVP_SESSION_ID, "client.example.com;1;1", MANDATORY_FLAG),
+			MustNewAVP(AVP_RESULT_CODE, uint32(DIAMETER_UNABLE_TO_COMPLY), MANDATORY_FLAG),
+			MustNewAVP(AVP_ERROR_REPORTING_HOST, "old.example.com", 0),
+			MustNewAVP(AVP_ORIGIN_HOST, "server.example.com", MANDATORY_FLAG),
+			MustNewAVP(AVP_ORIGIN_REALM, "example.com", MANDATORY_FLAG),
+		}}
+	}
+	want := []uint32{AVP_SESSION_ID, AVP_RESULT_CODE, AVP_ERROR_REPORTING_HOST, AVP_ORIGIN_HOST, AVP_ORIGIN_REALM}
+
+	ans := newAnswer()
+	if err := SetErrorReportingHost(ans, "relay.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if got := codes(ans.AVPs); !slices.Equal(got, want) {
+		t.Errorf("after SetErrorReportingHost: %v, want %v", got, want)
+	}
+
+	ans = newAnswer()
+	if err := AddRouteRecord(ans, "client.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := codes(ans.AVPs), append(slices.Clone(want), AVP_ROUTE_RECORD); !slices.Equal(got, want) {
+		t.Errorf("after AddRouteRecord: %v, want %v", got, want)
+	}
+
+	ans = newAnswer()
+	ans.Normalize()
+	if got := codes(ans.AVPs); !slices.Equal(got, want) {
+		t.Errorf("Normalize reordered a normalized message: %v", got)
+	}
+}
+
+// TestNormalizeOnlyMovesFixed checks that Normalize brings Session-Id to
+// the front and leaves the other AVPs in their order.
+func TestNormalizeOnlyMovesFixed(t *testing.T) {
+	msg, err := NewRequest(COMMAND_CODE_CREDIT_CONTROL, WithAVPs(
+		MustNewAVP(AVP_ORIGIN_HOST, "client.example.com", MANDATORY_FLAG),
+		MustNewAVP(AVP_USER_NAME, "bob", MANDATORY_FLAG),
+		MustNewAVP(AVP_SESSION_ID, "client.example.com;1;1", MANDATORY_FLAG),
+		MustNewAVP(AVP_ORIGIN_REALM, "example.com", MANDATORY_FLAG),
+		MustNewAVP(AVP_USER_NAME, "alice", MANDATORY_FLAG),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := slices.Clone(msg.AVPs)
+
+	encoded, err := EncodeMessage(msg, WithKeepOrder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := DecodeMessage(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := codes(kept.AVPs), codes(original); !slices.Equal(got, want) {
+		t.Errorf("encoded with WithKeepOrder as %v, want %v", got, want)
+	}
+
+	msg.Normalize()
+	want := []*AVP{original[2], original[0], original[1], original[3], original[4]}
+	if !slices.Equal(msg.AVPs, want) {
+		t.Errorf("normalized to %v, want %v", codes(msg.AVPs), codes(want))
+	}
+}
@@ -0,0 +1,103 @@
+// Proxy-Info and Route-Record handling for agents
+package message
+
+// ProxyInfo is the content of a Proxy-Info AVP: the identity of the agent
+// that added it and the opaque state it needs when the answer comes back.
+type ProxyInfo struct {
+	Host  string
+	State []byte
+}
+
+// NewProxyInfoAVP builds a Proxy-Info AVP for info.
+func NewProxyInfoAVP(info ProxyInfo) (*AVP, error) {
+	host, err := NewAVP(AVP_PROXY_HOST, info.Host, MANDATORY_FLAG)
+	if err != nil {
+		return nil, err
+	}
+	state := &AVP{
+		Code:  AVP_PROXY_STATE,
+		Flags: MANDATORY_FLAG,
+		Data:  &OctetString{Data: info.State},
+	}
+	state.Refresh()
+	return NewGroupedAVP(AVP_PROXY_INFO, MANDATORY_FLAG, 0, host, state)
+}
+
+// ParseProxyInfo returns the Proxy-Host and Proxy-State of a Proxy-Info
+// AVP.
+func ParseProxyInfo(avp *AVP) (ProxyInfo, bool) {
+	group, err := avp.Group()
+	if err != nil || avp.Code != AVP_PROXY_INFO {
+		return ProxyInfo{}, false
+	}
+	var info ProxyInfo
+	if host, ok := group.Get(AVP_PROXY_HOST); ok {
+		info.Host, _ = host.Str()
+	}
+	if state, ok := group.Get(AVP_PROXY_STATE); ok {
+		info.State, _ = state.Bytes()
+	}
+	return info, true
+}
+
+// ExtractProxyInfo returns copies of the Proxy-Info AVPs of req in the
+// order they were received.
+func ExtractProxyInfo(req *DiameterMessage) []*AVP {
+	var infos []*AVP
+	for _, avp := range req.AVPs {
+		if avp.Code == AVP_PROXY_INFO {
+			infos = append(infos, avp.Clone())
+		}
+	}
+	return infos
+}
+
+// AttachProxyInfo replaces the Proxy-Info AVPs of ans with infos, keeping
+// their order. RFC 6733 section 6.2 requires an answer to carry the
+// Proxy-Info AVPs of its request unchanged and in the same order. infos
+// take the place of the first Proxy-Info AVP of ans, or go last when it
+// has none, so the other AVPs keep their order.
+func AttachProxyInfo(ans *DiameterMessage, infos []*AVP) {
+	avps := make([]*AVP, 0, len(ans.AVPs)+len(infos))
+	attached := false
+	for _, avp := range ans.AVPs {
+		if avp.Code != AVP_PROXY_INFO {
+			avps = append(avps, avp)
+			continue
+		}
+		if !attached {
+			avps = append(avps, infos...)
+			attached = true
+		}
+	}
+	if !attached {
+		avps = append(avps, infos...)
+	}
+	ans.AVPs = avps
+}
+
+// RouteRecords returns the Route-Record identities of req in the order the
+// agents added them.
+func RouteRecords(req *DiameterMessage) []string {
+	var hosts []string
+	for _, avp := range req.AVPs {
+		if avp.Code != AVP_ROUTE_RECORD {
+			continue
+		}
+		if host, err := avp.Str(); err == nil {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// AddRouteRecord appends a Route-Record AVP for host, as an agent does
+// before forwarding req.
+func AddRouteRecord(req *DiameterMessage, host string) error {
+	avp, err := NewAVP(AVP_ROUTE_RECORD, host, MANDATORY_FLAG)
+	if err != nil {
+		return err
+	}
+	req.AVPs = append(req.AVPs, avp)
+	return nil
+}
@@ -0,0 +1,126 @@
+package message
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+// roundTrip encodes and decodes msg, as sending it to the next hop does.
+func roundTrip(t *testing.T, msg *DiameterMessage) *DiameterMessage {
+	t.Helper()
+	data, err := msg.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+// relayHop does what an agent named host does to req before forwarding
+// it: add a Route-Record for the peer it came from and its own Proxy-Info.
+func relayHop(t *testing.T, req *DiameterMessage, from, host string, state []byte) *DiameterMessage {
+	t.Helper()
+	if err := AddRouteRecord(req, from); err != nil {
+		t.Fatal(err)
+	}
+	info, err := NewProxyInfoAVP(ProxyInfo{Host: host, State: state})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AVPs = append(req.AVPs, info)
+	return roundTrip(t, req)
+}
+
+func proxyInfos(t *testing.T, msg *DiameterMessage) []ProxyInfo {
+	t.Helper()
+	var infos []ProxyInfo
+	for _, avp := range msg.AVPs {
+		if avp.Code != AVP_PROXY_INFO {
+			continue
+		}
+		info, ok := ParseProxyInfo(avp)
+		if !ok {
+			t.Fatalf("Proxy-Info %v does not parse", avp)
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// TestProxyInfoTwoHops relays a request through two agents and checks that
+// the answer carries both Proxy-Info AVPs, unchanged and in order, and
+// that the request carries both Route-Records.
+func TestProxyInfoTwoHops(t *testing.T) {
+	req := roundTrip(t, newTestCCR(t))
+	req = relayHop(t, req, "client.example.com", "agent1.example.com", []byte{1, 2, 3})
+	req = relayHop(t, req, "agent1.example.com", "agent2.example.com", []byte("state-2"))
+
+	if got, want := RouteRecords(req), []string{"client.example.com", "agent1.example.com"}; !slices.Equal(got, want) {
+		t.Errorf("Route-Records %q, want %q", got, want)
+	}
+	want := []ProxyInfo{
+		{Host: "agent1.example.com", State: []byte{1, 2, 3}},
+		{Host: "agent2.example.com", State: []byte("state-2")},
+	}
+
+	ans := roundTrip(t, NewAnswer(req, MustNewAVP(AVP_RESULT_CODE, uint32(DIAMETER_SUCCESS), MANDATORY_FLAG)))
+	got := proxyInfos(t, ans)
+	if len(got) != len(want) {
+		t.Fatalf("answer carries %d Proxy-Info AVPs, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Host != want[i].Host || !bytes.Equal(got[i].State, want[i].State) {
+			t.Errorf("Proxy-Info %d is %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if RouteRecords(ans) != nil {
+		t.Error("answer carries Route-Records")
+	}
+}
+
+func TestAttachProxyInfo(t *testing.T) {
+	info := func(host string) *AVP {
+		avp, err := NewProxyInfoAVP(ProxyInfo{Host: host, State: []byte(host)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return avp
+	}
+	resultCode := MustNewAVP(AVP_RESULT_CODE, uint32(DIAMETER_SUCCESS), MANDATORY_FLAG)
+	originHost := MustNewAVP(AVP_ORIGIN_HOST, "server.example.com", MANDATORY_FLAG)
+	infos := []*AVP{info("a.example.com"), info("b.example.com")}
+
+	for _, tc := range []struct {
+		name string
+		avps []*AVP
+		want []*AVP
+	}{
+		{"none", []*AVP{resultCode, originHost}, []*AVP{resultCode, originHost, infos[0], infos[1]}},
+		{"replaced in place", []*AVP{resultCode, info("old.example.com"), originHost, info("old.example.com")}, []*AVP{resultCode, infos[0], infos[1], originHost}},
+	} {
+		ans := &DiameterMessage{AVPs: tc.avps}
+		AttachProxyInfo(ans, infos)
+		if !slices.Equal(ans.AVPs, tc.want) {
+			t.Errorf("%s: AVPs %v, want %v", tc.name, ans.AVPs, tc.want)
+		}
+	}
+}
+
+func TestExtractProxyInfoCopies(t *testing.T) {
+	req := relayHop(t, roundTrip(t, newTestCCR(t)), "client.example.com", "agent.example.com", []byte("s"))
+	infos := ExtractProxyInfo(req)
+	if len(infos) != 1 {
+		t.Fatalf("%d Proxy-Info AVPs, want 1", len(infos))
+	}
+	group(t, infos[0]).Remove(AVP_PROXY_STATE)
+	if got := proxyInfos(t, req); len(got) != 1 || string(got[0].State) != "s" {
+		t.Errorf("changing the extracted Proxy-Info changed the request: %+v", got)
+	}
+	if _, ok := ParseProxyInfo(req.GetAVP(AVP_ROUTE_RECORD)); ok {
+		t.Error("Route-Record parsed as Proxy-Info")
+	}
+}
@@ -0,0 +1,265 @@
+// QoS-Information and AMBR of 3GPP TS 29.212 and TS 29.272
+package message
+
+// AVPs of 3GPP TS 29.214 carried in QoS-Information and AMBR.
+const (
+	AVP_MAX_REQUESTED_BANDWIDTH_DL = uint32(515) // Type: Unsigned32
+	AVP_MAX_REQUESTED_BANDWIDTH_UL = uint32(516) // Type: Unsigned32
+)
+
+func init() {
+	avpTypeMap[AVP_MAX_REQUESTED_BANDWIDTH_DL] = func() AVPData { return &Unsigned32{} }
+	avpTypeMap[AVP_MAX_REQUESTED_BANDWIDTH_UL] = func() AVPData { return &Unsigned32{} }
+	for _, code := range []uint32{AVP_QOS_INFORMATION, AVP_ALLOCATION_RETENTION_PRIORITY, AVP_AMBR} {
+		avpTypeMap[code] = func() AVPData { return &Grouped{} }
+	}
+	for code, avp := range map[uint32]struct {
+		name  string
+		flags uint8
+	}{
+		AVP_QOS_INFORMATION:               {"QoS-Information", flags3GPP},
+		AVP_QOS_CLASS_IDENTIFIER:          {"QoS-Class-Identifier", flags3GPP},
+		AVP_MAX_REQUESTED_BANDWIDTH_UL:    {"Max-Requested-Bandwidth-UL", flags3GPP},
+		AVP_MAX_REQUESTED_BANDWIDTH_DL:    {"Max-Requested-Bandwidth-DL", flags3GPP},
+		AVP_GUARANTEED_BITRATE_UL:         {"Guaranteed-Bitrate-UL", flags3GPP},
+		AVP_GUARANTEED_BITRATE_DL:         {"Guaranteed-Bitrate-DL", flags3GPP},
+		AVP_ALLOCATION_RETENTION_PRIORITY: {"Allocation-Retention-Priority", flags3GPPOptional},
+		AVP_PRIORITY_LEVEL:                {"Priority-Level", flags3GPPOptional},
+		AVP_PRE_EMPTION_CAPABILITY:        {"Pre-emption-Capability", flags3GPPOptional},
+		AVP_PRE_EMPTION_VULNERABILITY:     {"Pre-emption-Vulnerability", flags3GPPOptional},
+		AVP_APN_AGGREGATE_MAX_BITRATE_UL:  {"APN-Aggregate-Max-Bitrate-UL", flags3GPP},
+		AVP_APN_AGGREGATE_MAX_BITRATE_DL:  {"APN-Aggregate-Max-Bitrate-DL", flags3GPP},
+		AVP_AMBR:                          {"AMBR", flags3GPP},
+	} {
+		RegisterAVPName(code, VENDOR_3GPP, avp.name)
+		RegisterAVPFlags(code, VENDOR_3GPP, avp.flags)
+	}
+}
+
+// Flags of the 3GPP AVPs below. The Allocation-Retention-Priority AVPs
+// are sent without the 'M' bit, as TS 29.212 requires.
+const (
+	flags3GPP         = VENDOR_FLAG | MANDATORY_FLAG
+	flags3GPPOptional = VENDOR_FLAG
+)
+
+// QoSInformation is the content of a QoS-Information AVP. Nil fields are
+// absent from the AVP. Members without a field are kept in Extra and
+// encoded after the others.
+type QoSInformation struct {
+	QCI          *uint32
+	MaxUL        *uint32
+	MaxDL        *uint32
+	GuaranteedUL *uint32
+	GuaranteedDL *uint32
+	ARP          *AllocationRetentionPriority
+	Extra        []*AVP
+}
+
+// AllocationRetentionPriority is the content of an
+// Allocation-Retention-Priority AVP.
+type AllocationRetentionPriority struct {
+	PriorityLevel           uint32
+	PreemptionCapability    *uint32
+	PreemptionVulnerability *uint32
+	Extra                   []*AVP
+}
+
+// AMBR is the content of an AMBR AVP, both members being required.
+type AMBR struct {
+	MaxUL uint32
+	MaxDL uint32
+	Extra []*AVP
+}
+
+// FromAVP sets q from a QoS-Information AVP.
+func (q *QoSInformation) FromAVP(a *AVP) error {
+	g, err := group3GPP(a, AVP_QOS_INFORMATION)
+	if err != nil {
+		return err
+	}
+	*q = QoSInformation{}
+	for _, m := range g.AVPs {
+		switch m.Code {
+		case AVP_QOS_CLASS_IDENTIFIER:
+			q.QCI, err = optionalUint32(m)
+		case AVP_MAX_REQUESTED_BANDWIDTH_UL:
+			q.MaxUL, err = optionalUint32(m)
+		case AVP_MAX_REQUESTED_BANDWIDTH_DL:
+			q.MaxDL, err = optionalUint32(m)
+		case AVP_GUARANTEED_BITRATE_UL:
+			q.GuaranteedUL, err = optionalUint32(m)
+		case AVP_GUARANTEED_BITRATE_DL:
+			q.GuaranteedDL, err = optionalUint32(m)
+		case AVP_ALLOCATION_RETENTION_PRIORITY:
+			q.ARP = &AllocationRetentionPriority{}
+			err = q.ARP.FromAVP(m)
+		default:
+			q.Extra = append(q.Extra, m)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ToAVP builds the QoS-Information AVP of q.
+func (q QoSInformation) ToAVP() (*AVP, error) {
+	var avps []*AVP
+	for _, f := range []struct {
+		code  uint32
+		value *uint32
+	}{
+		{AVP_QOS_CLASS_IDENTIFIER, q.QCI},
+		{AVP_MAX_REQUESTED_BANDWIDTH_UL, q.MaxUL},
+		{AVP_MAX_REQUESTED_BANDWIDTH_DL, q.MaxDL},
+		{AVP_GUARANTEED_BITRATE_UL, q.GuaranteedUL},
+		{AVP_GUARANTEED_BITRATE_DL, q.GuaranteedDL},
+	} {
+		if f.value == nil {
+			continue
+		}
+		avp, err := NewAVP(f.code, *f.value, flags3GPP, VENDOR_3GPP)
+		if err != nil {
+			return nil, err
+		}
+		avps = append(avps, avp)
+	}
+	if q.ARP != nil {
+		arp, err := q.ARP.ToAVP()
+		if err != nil {
+			return nil, err
+		}
+		avps = append(avps, arp)
+	}
+	return newGroup3GPP(AVP_QOS_INFORMATION, flags3GPP, append(avps, q.Extra...))
+}
+
+// FromAVP sets arp from an Allocation-Retention-Priority AVP.
+func (arp *AllocationRetentionPriority) FromAVP(a *AVP) error {
+	g, err := group3GPP(a, AVP_ALLOCATION_RETENTION_PRIORITY)
+	if err != nil {
+		return err
+	}
+	*arp = AllocationRetentionPriority{}
+	var level bool
+	for _, m := range g.AVPs {
+		switch m.Code {
+		case AVP_PRIORITY_LEVEL:
+			arp.PriorityLevel, err = m.Uint32()
+			level = true
+		case AVP_PRE_EMPTION_CAPABILITY:
+			arp.PreemptionCapability, err = optionalUint32(m)
+		case AVP_PRE_EMPTION_VULNERABILITY:
+			arp.PreemptionVulnerability, err = optionalUint32(m)
+		default:
+			arp.Extra = append(arp.Extra, m)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !level {
+		return &AVPError{Code: AVP_PRIORITY_LEVEL, Vendor: VENDOR_3GPP, Reason: MissingMemberError}
+	}
+	return nil
+}
+
+// ToAVP builds the Allocation-Retention-Priority AVP of arp.
+func (arp AllocationRetentionPriority) ToAVP() (*AVP, error) {
+	level, err := NewAVP(AVP_PRIORITY_LEVEL, arp.PriorityLevel, flags3GPPOptional, VENDOR_3GPP)
+	if err != nil {
+		return nil, err
+	}
+	avps := []*AVP{level}
+	for _, f := range []struct {
+		code  uint32
+		value *uint32
+	}{
+		{AVP_PRE_EMPTION_CAPABILITY, arp.PreemptionCapability},
+		{AVP_PRE_EMPTION_VULNERABILITY, arp.PreemptionVulnerability},
+	} {
+		if f.value == nil {
+			continue
+		}
+		avp, err := NewAVP(f.code, *f.value, flags3GPPOptional, VENDOR_3GPP)
+		if err != nil {
+			return nil, err
+		}
+		avps = append(avps, avp)
+	}
+	return newGroup3GPP(AVP_ALLOCATION_RETENTION_PRIORITY, flags3GPPOptional, append(avps, arp.Extra...))
+}
+
+// FromAVP sets m from an AMBR AVP.
+func (m *AMBR) FromAVP(a *AVP) error {
+	g, err := group3GPP(a, AVP_AMBR)
+	if err != nil {
+		return err
+	}
+	*m = AMBR{}
+	var ul, dl bool
+	for _, member := range g.AVPs {
+		switch member.Code {
+		case AVP_MAX_REQUESTED_BANDWIDTH_UL:
+			m.MaxUL, err = member.Uint32()
+			ul = true
+		case AVP_MAX_REQUESTED_BANDWIDTH_DL:
+			m.MaxDL, err = member.Uint32()
+			dl = true
+		default:
+			m.Extra = append(m.Extra, member)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !ul {
+		return &AVPError{Code: AVP_MAX_REQUESTED_BANDWIDTH_UL, Vendor: VENDOR_3GPP, Reason: MissingMemberError}
+	}
+	if !dl {
+		return &AVPError{Code: AVP_MAX_REQUESTED_BANDWIDTH_DL, Vendor: VENDOR_3GPP, Reason: MissingMemberError}
+	}
+	return nil
+}
+
+// ToAVP builds the AMBR AVP of m.
+func (m AMBR) ToAVP() (*AVP, error) {
+	ul, err := NewAVP(AVP_MAX_REQUESTED_BANDWIDTH_UL, m.MaxUL, flags3GPP, VENDOR_3GPP)
+	if err != nil {
+		return nil, err
+	}
+	dl, err := NewAVP(AVP_MAX_REQUESTED_BANDWIDTH_DL, m.MaxDL, flags3GPP, VENDOR_3GPP)
+	if err != nil {
+		return nil, err
+	}
+	return newGroup3GPP(AVP_AMBR, flags3GPP, append([]*AVP{ul, dl}, m.Extra...))
+}
+
+// group3GPP returns the members of a, which must be the Grouped AVP code.
+func group3GPP(a *AVP, code uint32) (*Grouped, error) {
+	if a != nil && a.Code != code {
+		return nil, &AVPError{Code: a.Code, Vendor: a.VendorID, Reason: InvalidConversionError}
+	}
+	return a.Group()
+}
+
+// optionalUint32 returns the value of the optional member m.
+func optionalUint32(m *AVP) (*uint32, error) {
+	v, err := m.Uint32()
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// newGroup3GPP builds the 3GPP Grouped AVP code holding avps.
+func newGroup3GPP(code uint32, flags uint8, avps []*AVP) (*AVP, error) {
+	group, err := NewGroupedAVP(code, flags, VENDOR_3GPP, avps...)
+	if err != nil {
+		return nil, err
+	}
+	group.Refresh()
+	return group, nil
+}
@@ -0,0 +1,184 @@
+package message
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// gxCCA returns the CCA-I of testdata/gx-cca.hex, as sent by a PCRF over
+// Gx: its QoS-Information carries, after the members QoSInformation has
+// fields for, the APN-AMBR of the default bearer.
+func gxCCA(t *testing.T) []byte {
+	t.Helper()
+	return hexFixture(t, "gx-cca.hex")
+}
+
+// encodeAVP returns the bytes of avp.
+func encodeAVP(t *testing.T, avp *AVP) []byte {
+	t.Helper()
+	data, err := avp.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// roundTripAVP encodes and decodes avp.
+func roundTripAVP(t *testing.T, avp *AVP) *AVP {
+	t.Helper()
+	decoded, err := DecodeAVP(encodeAVP(t, avp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func TestQoSInformationGx(t *testing.T) {
+	frame := gxCCA(t)
+	cca, err := DecodeMessage(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	avp := cca.GetAVP(AVP_QOS_INFORMATION)
+	var qos QoSInformation
+	if err := qos.FromAVP(avp); err != nil {
+		t.Fatalf("FromAVP: %v", err)
+	}
+	for _, f := range []struct {
+		name  string
+		value *uint32
+		want  uint32
+	}{
+		{"QCI", qos.QCI, 9},
+		{"MaxUL", qos.MaxUL, 2_000_000},
+		{"MaxDL", qos.MaxDL, 8_000_000},
+	} {
+		if f.value == nil || *f.value != f.want {
+			t.Errorf("%s %v, want %d", f.name, f.value, f.want)
+		}
+	}
+	if qos.GuaranteedUL != nil || qos.GuaranteedDL != nil {
+		t.Errorf("guaranteed bitrates %v and %v, want none", qos.GuaranteedUL, qos.GuaranteedDL)
+	}
+	arp := qos.ARP
+	if arp == nil || arp.PriorityLevel != 8 || arp.PreemptionCapability == nil || *arp.PreemptionCapability != 1 ||
+		arp.PreemptionVulnerability == nil || *arp.PreemptionVulnerability != 0 || arp.Extra != nil {
+		t.Errorf("ARP %+v", arp)
+	}
+	if got := codes(qos.Extra); len(got) != 2 || got[0] != AVP_APN_AGGREGATE_MAX_BITRATE_UL || got[1] != AVP_APN_AGGREGATE_MAX_BITRATE_DL {
+		t.Errorf("extra members %v, want the APN-AMBR", got)
+	}
+
+	// Replacing the AVP with the one built from the struct gives back the
+	// bytes of the PCRF.
+	rebuilt, err := qos.ToAVP()
+	if err != nil {
+		t.Fatalf("ToAVP: %v", err)
+	}
+	for i, a := range cca.AVPs {
+		if a == avp {
+			cca.AVPs[i] = rebuilt
+		}
+	}
+	encoded, err := EncodeMessage(cca, WithKeepOrder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, frame) {
+		t.Errorf("re-encoded as\n%x\nwant\n%x", encoded, frame)
+	}
+}
+
+func TestQoSInformationOptionalMembers(t *testing.T) {
+	qci := uint32(5)
+	avp, err := QoSInformation{QCI: &qci}.ToAVP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := codes(group(t, avp).AVPs); len(got) != 1 || got[0] != AVP_QOS_CLASS_IDENTIFIER {
+		t.Errorf("members %v, want QoS-Class-Identifier alone", got)
+	}
+	var qos QoSInformation
+	if err := qos.FromAVP(roundTripAVP(t, avp)); err != nil {
+		t.Fatalf("FromAVP: %v", err)
+	}
+	if qos.QCI == nil || *qos.QCI != qci || qos.MaxUL != nil || qos.MaxDL != nil || qos.ARP != nil || qos.Extra != nil {
+		t.Errorf("decoded as %+v", qos)
+	}
+}
+
+func TestAMBR(t *testing.T) {
+	// An unknown vendor member, as an HSS may add, is kept in place.
+	extra, err := DecodeAVP(rawAVP(4000, VENDOR_FLAG, 16, []byte{0, 0x01, 0x86, 0x9f, 0, 0, 0, 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	avp, err := AMBR{MaxUL: 100_000_000, MaxDL: 300_000_000, Extra: []*AVP{extra}}.ToAVP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if avp.Code != AVP_AMBR || avp.VendorID != VENDOR_3GPP || avp.Flags != VENDOR_FLAG|MANDATORY_FLAG {
+		t.Errorf("AMBR AVP %d/%d with flags %#x", avp.Code, avp.VendorID, avp.Flags)
+	}
+	decoded := roundTripAVP(t, avp)
+	var ambr AMBR
+	if err := ambr.FromAVP(decoded); err != nil {
+		t.Fatalf("FromAVP: %v", err)
+	}
+	if ambr.MaxUL != 100_000_000 || ambr.MaxDL != 300_000_000 || len(ambr.Extra) != 1 || ambr.Extra[0].Code != 4000 || ambr.Extra[0].VendorID != 99999 {
+		t.Errorf("decoded as %+v", ambr)
+	}
+	again, err := ambr.ToAVP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := encodeAVP(t, avp), encodeAVP(t, again); !bytes.Equal(got, want) {
+		t.Errorf("re-encoded as %x, want %x", got, want)
+	}
+}
+
+func TestQoSMissingMembers(t *testing.T) {
+	level := func(v uint32) *AVP { return MustNewAVP(AVP_PRIORITY_LEVEL, v, flags3GPPOptional, VENDOR_3GPP) }
+	ul := MustNewAVP(AVP_MAX_REQUESTED_BANDWIDTH_UL, uint32(1), flags3GPP, VENDOR_3GPP)
+	dl := MustNewAVP(AVP_MAX_REQUESTED_BANDWIDTH_DL, uint32(1), flags3GPP, VENDOR_3GPP)
+	grouped := func(code uint32, avps ...*AVP) *AVP {
+		t.Helper()
+		avp, err := newGroup3GPP(code, flags3GPP, avps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return avp
+	}
+	for _, tc := range []struct {
+		name string
+		from func() error
+		code uint32
+		want error
+	}{
+		{"ARP without Priority-Level", func() error {
+			return new(AllocationRetentionPriority).FromAVP(grouped(AVP_ALLOCATION_RETENTION_PRIORITY))
+		}, AVP_PRIORITY_LEVEL, MissingMemberError},
+		{"QoS-Information with an ARP without Priority-Level", func() error {
+			return new(QoSInformation).FromAVP(grouped(AVP_QOS_INFORMATION, grouped(AVP_ALLOCATION_RETENTION_PRIORITY)))
+		}, AVP_PRIORITY_LEVEL, MissingMemberError},
+		{"AMBR without UL", func() error {
+			return new(AMBR).FromAVP(grouped(AVP_AMBR, dl))
+		}, AVP_MAX_REQUESTED_BANDWIDTH_UL, MissingMemberError},
+		{"AMBR without DL", func() error {
+			return new(AMBR).FromAVP(grouped(AVP_AMBR, ul))
+		}, AVP_MAX_REQUESTED_BANDWIDTH_DL, MissingMemberError},
+		{"AMBR given an ARP", func() error {
+			return new(AMBR).FromAVP(grouped(AVP_ALLOCATION_RETENTION_PRIORITY, level(1)))
+		}, AVP_ALLOCATION_RETENTION_PRIORITY, InvalidConversionError},
+	} {
+		err := tc.from()
+		var avpErr *AVPError
+		if !errors.Is(err, tc.want) || !errors.As(err, &avpErr) || avpErr.Code != tc.code {
+			t.Errorf("%s: error %v, want %v for AVP %d", tc.name, err, tc.want, tc.code)
+		}
+	}
+	if code := ResultCodeForError(&AVPError{Code: AVP_PRIORITY_LEVEL, Reason: MissingMemberError}); code != DIAMETER_MISSING_AVP {
+		t.Errorf("missing member answered with %v, want DIAMETER_MISSING_AVP", code)
+	}
+}
@@ -0,0 +1,145 @@
+// Result reporting of answers
+package message
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Result gathers the AVPs describing the outcome of an answer.
+type Result struct {
+	Code ResultCode
+	Name string
+	// ErrorMessage is the human-readable Error-Message, if any.
+	ErrorMessage string
+	// ErrorReportingHost is the node that set the Result-Code when it is
+	// not the Origin-Host of the answer, typically a relay.
+	ErrorReportingHost string
+	// Experimental is set when Code is the Experimental-Result-Code of
+	// VendorID rather than a Result-Code.
+	Experimental bool
+	VendorID     uint32
+}
+
+// Class returns the class of the result, taking the registered ranges of
+// the vendor into account for experimental results.
+func (r Result) Class() ResultClass {
+	if r.Experimental {
+		return ExperimentalResultClass(r.VendorID, r.Code)
+	}
+	return r.Code.Class()
+}
+
+func (r Result) String() string {
+	name := r.Name
+	if name == "" {
+		name = "UNKNOWN"
+	}
+	if r.Experimental {
+		return fmt.Sprintf("%s (%d, vendor %d)", name, r.Code, r.VendorID)
+	}
+	return fmt.Sprintf("%s (%d)", name, r.Code)
+}
+
+type experimentalKey struct {
+	vendorID uint32
+	code     ResultCode
+}
+
+var (
+	experimentalNamesMu sync.RWMutex
+	experimentalNames   = make(map[experimentalKey]string)
+)
+
+// RegisterExperimentalResult names the Experimental-Result-Code code of
+// vendorID. Applications reuse the numbers of base Result-Codes for
+// their own outcomes, so experimental codes are only named through the
+// vendor, never through ResultCode.String.
+func RegisterExperimentalResult(vendorID uint32, code ResultCode, name string) {
+	experimentalNamesMu.Lock()
+	defer experimentalNamesMu.Unlock()
+	experimentalNames[experimentalKey{vendorID, code}] = name
+}
+
+// ExperimentalResultName returns the name registered for the
+// Experimental-Result-Code code of vendorID, or an empty string.
+func ExperimentalResultName(vendorID uint32, code ResultCode) string {
+	experimentalNamesMu.RLock()
+	defer experimentalNamesMu.RUnlock()
+	return experimentalNames[experimentalKey{vendorID, code}]
+}
+
+// GetResult returns the Result-Code of msg together with its
+// Error-Message and Error-Reporting-Host. Answers without a Result-Code
+// report the code of their Experimental-Result instead, named as
+// registered with RegisterExperimentalResult.
+func GetResult(msg *DiameterMessage) (Result, error) {
+	code, name, err := GetResultCode(msg)
+	var result Result
+	if err == nil {
+		result = Result{Code: code, Name: name}
+	} else if experimental, ok := experimentalResult(msg); ok {
+		result = experimental
+	} else {
+		return Result{}, err
+	}
+	if avp := msg.GetAVP(AVP_ERROR_MESSAGE); avp != nil {
+		result.ErrorMessage, _ = avp.Str()
+	}
+	if avp := msg.GetAVP(AVP_ERROR_REPORTING_HOST); avp != nil {
+		result.ErrorReportingHost, _ = avp.Str()
+	}
+	return result, nil
+}
+
+// experimentalResult reads the Experimental-Result AVP of msg.
+func experimentalResult(msg *DiameterMessage) (Result, bool) {
+	group, err := msg.GetAVP(AVP_EXPERIMENTAL_RESULT).Group()
+	if err != nil {
+		return Result{}, false
+	}
+	codeAVP, ok := group.Get(AVP_EXPERIMENTAL_RESULT_CODE)
+	if !ok {
+		return Result{}, false
+	}
+	code, err := codeAVP.Uint32()
+	if err != nil {
+		return Result{}, false
+	}
+	result := Result{Code: ResultCode(code), Experimental: true}
+	if vendor, ok := group.Get(AVP_VENDOR_ID); ok {
+		result.VendorID, _ = vendor.Uint32()
+	}
+	result.Name = ExperimentalResultName(result.VendorID, result.Code)
+	return result, true
+}
+
+// NewErrorMessageAVP builds an Error-Message AVP. The 'M' bit must not be
+// set on it.
+func NewErrorMessageAVP(text string) (*AVP, error) {
+	return NewAVP(AVP_ERROR_MESSAGE, text, 0)
+}
+
+// SetErrorReportingHost records host as the node that set the Result-Code
+// of ans. Nothing is added when host is the Origin-Host of ans, as RFC
+// 6733 section 7.1 only requires the AVP when they differ.
+func SetErrorReportingHost(ans *DiameterMessage, host string) error {
+	if origin := ans.GetAVP(AVP_ORIGIN_HOST); origin != nil {
+		if id, ok := origin.Data.(*DiameterIdentity); ok &&
+			normalizeDiameterIdentity(id.Data) == normalizeDiameterIdentity(host) {
+			return nil
+		}
+	}
+	avp, err := NewAVP(AVP_ERROR_REPORTING_HOST, host, 0)
+	if err != nil {
+		return err
+	}
+	for i, existing := range ans.AVPs {
+		if existing.Code == AVP_ERROR_REPORTING_HOST {
+			ans.AVPs[i] = avp
+			return nil
+		}
+	}
+	ans.AVPs = append(ans.AVPs, avp)
+	return nil
+}
@@ -0,0 +1,107 @@
+// Result-Code classes
+package message
+
+import "sync"
+
+// ResultClass is the class of a Result-Code, given by its thousands digit
+// per RFC 6733 section 7.1.
+type ResultClass int
+
+const (
+	ResultClassUnknown ResultClass = iota
+	ResultClassInformational
+	ResultClassSuccess
+	ResultClassProtocolError
+	ResultClassTransient
+	ResultClassPermanent
+)
+
+func (c ResultClass) String() string {
+	switch c {
+	case ResultClassInformational:
+		return "informational"
+	case ResultClassSuccess:
+		return "success"
+	case ResultClassProtocolError:
+		return "protocol error"
+	case ResultClassTransient:
+		return "transient failure"
+	case ResultClassPermanent:
+		return "permanent failure"
+	}
+	return "unknown"
+}
+
+// Class returns the class of the code.
+func (r ResultCode) Class() ResultClass {
+	switch r / 1000 {
+	case 1:
+		return ResultClassInformational
+	case 2:
+		return ResultClassSuccess
+	case 3:
+		return ResultClassProtocolError
+	case 4:
+		return ResultClassTransient
+	case 5:
+		return ResultClassPermanent
+	}
+	return ResultClassUnknown
+}
+
+// IsSuccess reports whether the code is a 2xxx success.
+func (r ResultCode) IsSuccess() bool {
+	return r.Class() == ResultClassSuccess
+}
+
+// IsProtocolError reports whether the code is a 3xxx protocol error.
+func (r ResultCode) IsProtocolError() bool {
+	return r.Class() == ResultClassProtocolError
+}
+
+// IsTransient reports whether the code is a 4xxx transient failure, after
+// which the request may succeed if sent again.
+func (r ResultCode) IsTransient() bool {
+	return r.Class() == ResultClassTransient
+}
+
+// IsPermanent reports whether the code is a 5xxx permanent failure, after
+// which the request must not be sent again unchanged.
+func (r ResultCode) IsPermanent() bool {
+	return r.Class() == ResultClassPermanent
+}
+
+type experimentalRange struct {
+	low, high ResultCode
+	class     ResultClass
+}
+
+var (
+	experimentalRangesMu sync.RWMutex
+	experimentalRanges   = make(map[uint32][]experimentalRange)
+)
+
+// RegisterExperimentalResultRange sets the class of the
+// Experimental-Result-Codes of vendorID from low to high inclusive, for
+// vendors whose codes do not follow the thousands digit convention.
+// Later registrations take precedence over earlier overlapping ones.
+func RegisterExperimentalResultRange(vendorID uint32, low, high ResultCode, class ResultClass) {
+	experimentalRangesMu.Lock()
+	defer experimentalRangesMu.Unlock()
+	experimentalRanges[vendorID] = append(experimentalRanges[vendorID], experimentalRange{low, high, class})
+}
+
+// ExperimentalResultClass returns the class of an Experimental-Result-Code
+// of vendorID: the class of the latest registered range holding code, or
+// the thousands digit class when no range does.
+func ExperimentalResultClass(vendorID uint32, code ResultCode) ResultClass {
+	experimentalRangesMu.RLock()
+	defer experimentalRangesMu.RUnlock()
+	ranges := experimentalRanges[vendorID]
+	for i := len(ranges) - 1; i >= 0; i-- {
+		if code >= ranges[i].low && code <= ranges[i].high {
+			return ranges[i].class
+		}
+	}
+	return code.Class()
+}
@@ -0,0 +1,245 @@
+package message
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+)
+
+// newTestAnswer returns an answer of server.example.com carrying
+// resultCode and extra.
+func newTestAnswer(t *testing.T, resultCode ResultCode, extra ...*AVP) *DiameterMessage {
+	t.Helper()
+	node := Node{OriginHost: "server.example.com", OriginRealm: "example.com"}
+	ans, err := node.BuildAnswer(newTestCCR(t), resultCode, extra...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ans
+}
+
+// TestErrorMessage checks a locally generated DIAMETER_MISSING_AVP
+// carrying Error-Message.
+func TestErrorMessage(t *testing.T) {
+	errorMessage, err := NewErrorMessageAVP("Origin-Host missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errorMessage.Flags&MANDATORY_FLAG != 0 {
+		t.Error("'M' bit set on Error-Message")
+	}
+	ans := roundTrip(t, newTestAnswer(t, DIAMETER_MISSING_AVP, errorMessage))
+	result, err := GetResult(ans)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Result{Code: DIAMETER_MISSING_AVP, Name: ResultCodeToName[DIAMETER_MISSING_AVP], ErrorMessage: "Origin-Host missing"}
+	if result != want {
+		t.Errorf("GetResult = %+v, want %+v", result, want)
+	}
+	if s := ans.String(); !strings.Contains(s, "Origin-Host missing") {
+		t.Errorf("Error-Message missing from\n%s", s)
+	}
+}
+
+// TestErrorReportingHost checks a DIAMETER_UNABLE_TO_DELIVER set by a
+// relay on an answer of another node.
+func TestErrorReportingHost(t *testing.T) {
+	ans := newTestAnswer(t, DIAMETER_UNABLE_TO_DELIVER)
+	if err := SetErrorReportingHost(ans, "relay.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	// Setting it again replaces the AVP.
+	if err := SetErrorReportingHost(ans, "relay2.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	ans = roundTrip(t, ans)
+	n := 0
+	for avp := range ans.All() {
+		if avp.Code == AVP_ERROR_REPORTING_HOST {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("%d Error-Reporting-Host AVPs, want 1", n)
+	}
+	result, err := GetResult(ans)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != DIAMETER_UNABLE_TO_DELIVER || result.ErrorReportingHost != "relay2.example.com" {
+		t.Errorf("GetResult = %+v, want DIAMETER_UNABLE_TO_DELIVER reported by relay2.example.com", result)
+	}
+	if s := ans.String(); !strings.Contains(s, "relay2.example.com") {
+		t.Errorf("Error-Reporting-Host missing from\n%s", s)
+	}
+
+	// The Origin-Host of the answer set the Result-Code itself.
+	own := newTestAnswer(t, DIAMETER_UNABLE_TO_DELIVER)
+	if err := SetErrorReportingHost(own, "Server.Example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if avp := own.GetAVP(AVP_ERROR_REPORTING_HOST); avp != nil {
+		t.Errorf("Error-Reporting-Host %v added for the Origin-Host", avp)
+	}
+}
+
+// resultCodeConstants returns the ResultCode constants declared in
+// codes.go by name, type-checking the file on its own.
+func resultCodeConstants(t *testing.T) map[string]ResultCode {
+	t.Helper()
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "codes.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The rest of the package is missing, so only the constants check.
+	conf := types.Config{Error: func(error) {}}
+	pkg, _ := conf.Check("message", fset, []*ast.File{file}, nil)
+	consts := make(map[string]ResultCode)
+	for _, name := range pkg.Scope().Names() {
+		c, ok := pkg.Scope().Lookup(name).(*types.Const)
+		if !ok || c.Type().String() != "message.ResultCode" {
+			continue
+		}
+		value, ok := constant.Uint64Val(c.Val())
+		if !ok {
+			t.Fatalf("%s has value %v", name, c.Val())
+		}
+		consts[name] = ResultCode(value)
+	}
+	return consts
+}
+
+// TestResultCodeNames checks that every ResultCode constant is named in
+// ResultCodeToName by its own name, and that every name of the map is a
+// constant.
+func TestResultCodeNames(t *testing.T) {
+	consts := resultCodeConstants(t)
+	if alias := consts["DIMAETER_LIMITED_SUCCESS"]; alias != DIAMETER_LIMITED_SUCCESS {
+		t.Errorf("DIMAETER_LIMITED_SUCCESS = %d, want DIAMETER_LIMITED_SUCCESS", alias)
+	}
+	delete(consts, "DIMAETER_LIMITED_SUCCESS")
+	if len(consts) == 0 {
+		t.Fatal("no ResultCode constants found")
+	}
+	for name, code := range consts {
+		if got := ResultCodeToName[code]; got != name {
+			t.Errorf("ResultCodeToName[%d] = %q, want %q", code, got, name)
+		}
+	}
+	for code, name := range ResultCodeToName {
+		if got, ok := consts[name]; !ok || got != code {
+			t.Errorf("ResultCodeToName[%d] = %q, which is not a constant of that value", code, name)
+		}
+	}
+}
+
+func TestResultCodeString(t *testing.T) {
+	for _, tc := range []struct {
+		code ResultCode
+		want string
+	}{
+		{DIAMETER_SUCCESS, "DIAMETER_SUCCESS"},
+		{DIAMETER_REALM_REDIRECT_INDICATION, "DIAMETER_REALM_REDIRECT_INDICATION"},
+		{DIAMETER_RATING_FAILED, "DIAMETER_RATING_FAILED"},
+		{3999, "UNKNOWN(3999)"},
+		{0, "UNKNOWN(0)"},
+	} {
+		if got := tc.code.String(); got != tc.want {
+			t.Errorf("ResultCode(%d).String() = %q, want %q", uint32(tc.code), got, tc.want)
+		}
+	}
+}
+
+// experimentalULA returns an encoded S6a Update-Location-Answer carrying
+// the Experimental-Result code of vendor.
+func experimentalULA(t *testing.T, vendor uint32, code ResultCode) []byte {
+	t.Helper()
+	result, err := NewGroupedAVP(AVP_EXPERIMENTAL_RESULT, MANDATORY_FLAG, 0,
+		MustNewAVP(AVP_VENDOR_ID, vendor, MANDATORY_FLAG),
+		MustNewAVP(AVP_EXPERIMENTAL_RESULT_CODE, uint32(code), MANDATORY_FLAG),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ula := &DiameterMessage{
+		Header: &DiameterHeader{
+			Version:       DIAMETER_VERSION,
+			CommandFlags:  FlagProxiable,
+			CommandCode:   COMMAND_CODE_3GPP_UPDATE_LOCATION,
+			ApplicationID: APPLICATION_ID_3GPP_S6A,
+		},
+		AVPs: []*AVP{
+			MustNewAVP(AVP_SESSION_ID, "mme.example.com;1;1", MANDATORY_FLAG),
+			MustNewAVP(AVP_ORIGIN_HOST, "hss.example.com", MANDATORY_FLAG),
+			MustNewAVP(AVP_ORIGIN_REALM, "example.com", MANDATORY_FLAG),
+			result,
+		},
+	}
+	data, err := ula.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestExperimentalResultNames decodes S6a answers with the Experimental-
+// Result-Code 5001, which is DIAMETER_AVP_UNSUPPORTED as a Result-Code,
+// and checks that it is named through its vendor.
+func TestExperimentalResultNames(t *testing.T) {
+	const otherVendor = 99999
+	RegisterExperimentalResult(otherVendor, 5001, "OTHER_VENDOR_5001")
+	t.Cleanup(func() {
+		experimentalNamesMu.Lock()
+		defer experimentalNamesMu.Unlock()
+		delete(experimentalNames, experimentalKey{otherVendor, 5001})
+	})
+	for _, tc := range []struct {
+		vendor uint32
+		name   string
+		str    string
+	}{
+		{VENDOR_3GPP, "DIAMETER_ERROR_USER_UNKNOWN", "DIAMETER_ERROR_USER_UNKNOWN (5001, vendor 10415)"},
+		{otherVendor, "OTHER_VENDOR_5001", "OTHER_VENDOR_5001 (5001, vendor 99999)"},
+		{12345, "", "UNKNOWN (5001, vendor 12345)"},
+	} {
+		ula, err := DecodeMessage(experimentalULA(t, tc.vendor, DIAMETER_ERROR_USER_UNKNOWN))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := GetResult(ula)
+		if err != nil {
+			t.Fatalf("vendor %d: %v", tc.vendor, err)
+		}
+		if !result.Experimental || result.Code != 5001 || result.VendorID != tc.vendor || result.Name != tc.name {
+			t.Errorf("vendor %d: GetResult = %+v, want %q", tc.vendor, result, tc.name)
+		}
+		if got := result.String(); got != tc.str {
+			t.Errorf("vendor %d: String() = %q, want %q", tc.vendor, got, tc.str)
+		}
+		text := ula.WiresharkText()
+		if strings.Contains(text, "DIAMETER_AVP_UNSUPPORTED") {
+			t.Errorf("vendor %d: named as a Result-Code in\n%s", tc.vendor, text)
+		}
+		if tc.name != "" && !strings.Contains(text, "val="+tc.name+" (5001)") {
+			t.Errorf("vendor %d: %s missing from\n%s", tc.vendor, tc.name, text)
+		}
+	}
+
+	// As a Result-Code, 5001 keeps its base name.
+	result, err := GetResult(newTestAnswer(t, DIAMETER_AVP_UNSUPPORTED))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Experimental || result.Name != "DIAMETER_AVP_UNSUPPORTED" || result.String() != "DIAMETER_AVP_UNSUPPORTED (5001)" {
+		t.Errorf("GetResult = %+v, want DIAMETER_AVP_UNSUPPORTED", result)
+	}
+	if got := ExperimentalResultName(VENDOR_3GPP, DIAMETER_PCC_RULE_EVENT); got != "DIAMETER_PCC_RULE_EVENT" {
+		t.Errorf("Gx 5142 named %q", got)
+	}
+}
@@ -0,0 +1,31 @@
+// Experimental-Result-Codes of the S6a/S6d application, 3GPP TS 29.272
+package message
+
+// Experimental-Result-Codes of 3GPP TS 29.272 section 7.4, under
+// VENDOR_3GPP. They are not Result-Codes: 5001 is
+// DIAMETER_AVP_UNSUPPORTED as a Result-Code.
+const (
+	DIAMETER_AUTHENTICATION_DATA_UNAVAILABLE  ResultCode = 4181
+	DIAMETER_ERROR_CAMEL_SUBSCRIPTION_PRESENT ResultCode = 4182
+	DIAMETER_ERROR_USER_UNKNOWN               ResultCode = 5001
+	DIAMETER_ERROR_ROAMING_NOT_ALLOWED        ResultCode = 5004
+	DIAMETER_ERROR_UNKNOWN_EPS_SUBSCRIPTION   ResultCode = 5420
+	DIAMETER_ERROR_RAT_NOT_ALLOWED            ResultCode = 5421
+	DIAMETER_ERROR_EQUIPMENT_UNKNOWN          ResultCode = 5422
+	DIAMETER_ERROR_UNKNOWN_SERVING_NODE       ResultCode = 5423
+)
+
+func init() {
+	for code, name := range map[ResultCode]string{
+		DIAMETER_AUTHENTICATION_DATA_UNAVAILABLE:  "DIAMETER_AUTHENTICATION_DATA_UNAVAILABLE",
+		DIAMETER_ERROR_CAMEL_SUBSCRIPTION_PRESENT: "DIAMETER_ERROR_CAMEL_SUBSCRIPTION_PRESENT",
+		DIAMETER_ERROR_USER_UNKNOWN:               "DIAMETER_ERROR_USER_UNKNOWN",
+		DIAMETER_ERROR_ROAMING_NOT_ALLOWED:        "DIAMETER_ERROR_ROAMING_NOT_ALLOWED",
+		DIAMETER_ERROR_UNKNOWN_EPS_SUBSCRIPTION:   "DIAMETER_ERROR_UNKNOWN_EPS_SUBSCRIPTION",
+		DIAMETER_ERROR_RAT_NOT_ALLOWED:            "DIAMETER_ERROR_RAT_NOT_ALLOWED",
+		DIAMETER_ERROR_EQUIPMENT_UNKNOWN:          "DIAMETER_ERROR_EQUIPMENT_UNKNOWN",
+		DIAMETER_ERROR_UNKNOWN_SERVING_NODE:       "DIAMETER_ERROR_UNKNOWN_SERVING_NODE",
+	} {
+		RegisterExperimentalResult(VENDOR_3GPP, code, name)
+	}
+}
@@ -0,0 +1,92 @@
+// Components of Session-Ids
+package message
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// SessionIDParts are the components of a Session-Id in the format of RFC
+// 6733 section 8.8:
+//
+//	<DiameterIdentity>;<high 32 bits>;<low 32 bits>[;<optional value>]
+type SessionIDParts struct {
+	// NodeIdentity is the DiameterIdentity of the node that created the
+	// session.
+	NodeIdentity string
+	High32       uint32
+	Low32        uint32
+	// Optional holds the fields following the low 32 bits, if any. The
+	// RFC allows a single optional value, but other stacks add several
+	// separated by semicolons, so each field is kept on its own.
+	Optional []string
+}
+
+// ParseSessionID splits id into its components. It returns an error
+// wrapping InvalidSessionIDError if id lacks a node identity or if the
+// high and low 32 bits are not decimal numbers.
+func ParseSessionID(id string) (SessionIDParts, error) {
+	fields := strings.Split(id, ";")
+	if len(fields) < 3 {
+		return SessionIDParts{}, fmt.Errorf("%w: %q has fewer than 3 fields", InvalidSessionIDError, id)
+	}
+	if fields[0] == "" {
+		return SessionIDParts{}, fmt.Errorf("%w: %q has no node identity", InvalidSessionIDError, id)
+	}
+	high, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return SessionIDParts{}, fmt.Errorf("%w: high 32 bits %q of %q", InvalidSessionIDError, fields[1], id)
+	}
+	low, err := strconv.ParseUint(fields[2], 10, 32)
+	if err != nil {
+		return SessionIDParts{}, fmt.Errorf("%w: low 32 bits %q of %q", InvalidSessionIDError, fields[2], id)
+	}
+	parts := SessionIDParts{NodeIdentity: fields[0], High32: uint32(high), Low32: uint32(low)}
+	if len(fields) > 3 {
+		parts.Optional = fields[3:]
+	}
+	return parts, nil
+}
+
+// String returns the Session-Id made of p.
+func (p SessionIDParts) String() string {
+	var b strings.Builder
+	b.WriteString(p.NodeIdentity)
+	b.WriteByte(';')
+	b.WriteString(strconv.FormatUint(uint64(p.High32), 10))
+	b.WriteByte(';')
+	b.WriteString(strconv.FormatUint(uint64(p.Low32), 10))
+	for _, field := range p.Optional {
+		b.WriteByte(';')
+		b.WriteString(field)
+	}
+	return b.String()
+}
+
+// ShardKey returns the shard in [0, n) of the session, for partitioning
+// session stores. It hashes every component with FNV-1a, so it is the same
+// across processes and releases. It returns 0 if n is not positive.
+func (p SessionIDParts) ShardKey(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	add := func(s string) {
+		io.WriteString(h, s)
+		// A separator keeps "a;bc" and "ab;c" apart.
+		h.Write([]byte{';'})
+	}
+	add(p.NodeIdentity)
+	var ids [8]byte
+	binary.LittleEndian.PutUint32(ids[:4], p.High32)
+	binary.LittleEndian.PutUint32(ids[4:], p.Low32)
+	h.Write(ids[:])
+	for _, field := range p.Optional {
+		add(field)
+	}
+	return int(h.Sum64() % uint64(n))
+}
@@ -0,0 +1,94 @@
+package message
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParseSessionID(t *testing.T) {
+	for _, tc := range []struct {
+		id   string
+		want SessionIDParts
+	}{
+		// RFC 6733 section 8.8, without and with the optional value.
+		{"client.example.com;1;1", SessionIDParts{NodeIdentity: "client.example.com", High32: 1, Low32: 1}},
+		{"client.example.com;4294967295;0", SessionIDParts{NodeIdentity: "client.example.com", High32: 4294967295}},
+		{"mme01.epc.mnc001.mcc001.3gppnetwork.org;1415;1234;apn=internet",
+			SessionIDParts{NodeIdentity: "mme01.epc.mnc001.mcc001.3gppnetwork.org", High32: 1415, Low32: 1234, Optional: []string{"apn=internet"}}},
+		// Other stacks add several optional fields, some of them empty.
+		{"pcef.example.com;1;2;gx;3;", SessionIDParts{NodeIdentity: "pcef.example.com", High32: 1, Low32: 2, Optional: []string{"gx", "3", ""}}},
+		{"pcef.example.com;1;2;;", SessionIDParts{NodeIdentity: "pcef.example.com", High32: 1, Low32: 2, Optional: []string{"", ""}}},
+	} {
+		got, err := ParseSessionID(tc.id)
+		if err != nil {
+			t.Errorf("%q: %v", tc.id, err)
+			continue
+		}
+		if got.NodeIdentity != tc.want.NodeIdentity || got.High32 != tc.want.High32 || got.Low32 != tc.want.Low32 ||
+			!slices.Equal(got.Optional, tc.want.Optional) {
+			t.Errorf("%q parses as %+v, want %+v", tc.id, got, tc.want)
+		}
+		if s := got.String(); s != tc.id {
+			t.Errorf("%q formats as %q", tc.id, s)
+		}
+	}
+
+	for _, id := range []string{
+		"",
+		"client.example.com",
+		"client.example.com;1",
+		";1;1",
+		"client.example.com;x;1",
+		"client.example.com;1;-1",
+		"client.example.com;4294967296;1",
+		"client.example.com;;1",
+	} {
+		if _, err := ParseSessionID(id); !errors.Is(err, InvalidSessionIDError) {
+			t.Errorf("%q: %v, want InvalidSessionIDError", id, err)
+		}
+	}
+}
+
+func TestShardKey(t *testing.T) {
+	// The keys must not change across releases: session stores are
+	// partitioned by them.
+	for _, tc := range []struct {
+		id   string
+		n    int
+		want int
+	}{
+		{"mme01.epc.mnc001.mcc001.3gppnetwork.org;1415;1234;apn=internet", 64, 45},
+		{"client.example.com;1;1", 64, 34},
+		{"a;0;0;x;y;z", 64, 3},
+		{"client.example.com;1;1", 1 << 30, 257278882},
+	} {
+		parts, err := ParseSessionID(tc.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := parts.ShardKey(tc.n); got != tc.want {
+			t.Errorf("%q: ShardKey(%d) = %d, want %d", tc.id, tc.n, got, tc.want)
+		}
+	}
+
+	parts := SessionIDParts{NodeIdentity: "client.example.com", High32: 1, Low32: 1}
+	for _, n := range []int{0, -1} {
+		if got := parts.ShardKey(n); got != 0 {
+			t.Errorf("ShardKey(%d) = %d, want 0", n, got)
+		}
+	}
+	for low := range uint32(1000) {
+		parts.Low32 = low
+		if got := parts.ShardKey(7); got < 0 || got >= 7 {
+			t.Fatalf("%s: ShardKey(7) = %d", parts, got)
+		}
+	}
+
+	// Moving a character across a separator changes the key.
+	a := SessionIDParts{NodeIdentity: "host", Optional: []string{"a", "bc"}}
+	b := SessionIDParts{NodeIdentity: "host", Optional: []string{"ab", "c"}}
+	if a.ShardKey(1<<30) == b.ShardKey(1<<30) {
+		t.Errorf("%s and %s share a key", a, b)
+	}
+}
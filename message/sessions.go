@@ -0,0 +1,66 @@
+// Session roles of requests in the command dictionary
+package message
+
+// AVP_CC_REQUEST_TYPE is the CC-Request-Type AVP of RFC 4006, which
+// shares its code with a 3GPP AVP of the dictionary.
+const AVP_CC_REQUEST_TYPE = uint32(416)
+
+// CC-Request-Type AVP values (RFC 4006 section 8.3)
+const (
+	INITIAL_REQUEST     = uint32(1)
+	UPDATE_REQUEST      = uint32(2)
+	TERMINATION_REQUEST = uint32(3)
+	EVENT_REQUEST       = uint32(4)
+)
+
+// SessionRole tells what a request does to its session.
+type SessionRole int
+
+const (
+	// SessionNone is the role of requests outside any session, such as
+	// one-time events.
+	SessionNone SessionRole = iota
+	SessionOpen
+	SessionUpdate
+	SessionEnd
+)
+
+// SessionRule tells the role of the requests of a command.
+type SessionRule struct {
+	// Role is the role of every request of the command, unless AVP is
+	// set.
+	Role SessionRole
+	// AVP is the Enumerated or Unsigned32 AVP whose value, looked up in
+	// Roles, gives the role of a request. Other values and a missing AVP
+	// give SessionNone.
+	AVP   uint32
+	Roles map[uint32]SessionRole
+}
+
+func (r *SessionRule) role(req *DiameterMessage) SessionRole {
+	if r.AVP == 0 {
+		return r.Role
+	}
+	value, err := req.GetAVP(r.AVP).Uint32()
+	if err != nil {
+		return SessionNone
+	}
+	return r.Roles[value]
+}
+
+// RequestSessionRole returns the role of req as given by the session rule
+// of its command. A Session-Termination-Request, which any application
+// may send, always ends its session.
+func RequestSessionRole(req *DiameterMessage) SessionRole {
+	if !req.IsRequest() {
+		return SessionNone
+	}
+	if req.Header.CommandCode == COMMAND_CODE_SESSION_TERMINATION {
+		return SessionEnd
+	}
+	cmd, ok := lookupMessageCommand(req)
+	if !ok || cmd.Sessions == nil {
+		return SessionNone
+	}
+	return cmd.Sessions.role(req)
+}
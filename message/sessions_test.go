@@ -0,0 +1,40 @@
+package message
+
+import "testing"
+
+func TestRequestSessionRole(t *testing.T) {
+	withAVP := func(msg *DiameterMessage, code, value uint32) *DiameterMessage {
+		msg.AVPs = append(msg.AVPs, MustNewAVP(code, value, MANDATORY_FLAG))
+		return msg
+	}
+	request := func(code uint32) *DiameterMessage {
+		msg, err := NewRequest(code, WithAVPs(MustNewAVP(AVP_SESSION_ID, "client.example.com;1;1", MANDATORY_FLAG)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	answer := withAVP(newTestCCR(t), AVP_CC_REQUEST_TYPE, INITIAL_REQUEST)
+	answer.Header.CommandFlags = 0
+	for _, tc := range []struct {
+		name string
+		msg  *DiameterMessage
+		want SessionRole
+	}{
+		{"CCR-I", withAVP(newTestCCR(t), AVP_CC_REQUEST_TYPE, INITIAL_REQUEST), SessionOpen},
+		{"CCR-U", withAVP(newTestCCR(t), AVP_CC_REQUEST_TYPE, UPDATE_REQUEST), SessionUpdate},
+		{"CCR-T", withAVP(newTestCCR(t), AVP_CC_REQUEST_TYPE, TERMINATION_REQUEST), SessionEnd},
+		{"CCR-E", withAVP(newTestCCR(t), AVP_CC_REQUEST_TYPE, EVENT_REQUEST), SessionNone},
+		{"CCR without CC-Request-Type", newTestCCR(t), SessionNone},
+		{"ACR START", withAVP(request(COMMAND_CODE_ACCOUNTING), AVP_ACCOUNTING_RECORD_TYPE, START_RECORD), SessionOpen},
+		{"ACR STOP", withAVP(request(COMMAND_CODE_ACCOUNTING), AVP_ACCOUNTING_RECORD_TYPE, STOP_RECORD), SessionEnd},
+		{"ACR EVENT", withAVP(request(COMMAND_CODE_ACCOUNTING), AVP_ACCOUNTING_RECORD_TYPE, EVENT_RECORD), SessionNone},
+		{"STR", request(COMMAND_CODE_SESSION_TERMINATION), SessionEnd},
+		{"DWR", request(COMMAND_CODE_DWR), SessionNone},
+		{"CCA", answer, SessionNone},
+	} {
+		if got := RequestSessionRole(tc.msg); got != tc.want {
+			t.Errorf("%s: role %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Message validation against the command dictionary
+package message
+
+import "fmt"
+
+// ValidationError reports a message that breaks a command rule together
+// with the Result-Code an answer to it should carry.
+type ValidationError struct {
+	ResultCode ResultCode
+	AVPCode    uint32
+	Reason     string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: AVP %d %s", e.ResultCode, e.AVPCode, e.Reason)
+}
+
+// Normalize moves the AVPs with a fixed position, such as Session-Id, to
+// the front of the message in the order required by the command
+// dictionary. The relative order of the other AVPs is kept.
+func (msg *DiameterMessage) Normalize() {
+	msg.AVPs = normalizedOrder(msg.AVPs, fixedAVPs(msg))
+}
+
+// normalizedOrder returns avps in the order Normalize gives them. avps is
+// returned as is when it needs no reordering, and is never modified.
+func normalizedOrder(avps []*AVP, fixed []uint32) []*AVP {
+	if len(fixed) == 0 {
+		return avps
+	}
+	leading := make([]*AVP, 0, len(fixed))
+	for _, code := range fixed {
+		for _, avp := range avps {
+			if avp.Code == code {
+				leading = append(leading, avp)
+			}
+		}
+	}
+	if len(leading) == 0 {
+		return avps
+	}
+	rest := make([]*AVP, 0, len(avps)-len(leading))
+	for _, avp := range avps {
+		if !containsCode(fixed, avp.Code) {
+			rest = append(rest, avp)
+		}
+	}
+	return append(leading, rest...)
+}
+
+// ValidateMessage checks msg against the AVP placement rules of its
+// command. A forbidden AVP is reported with DIAMETER_AVP_NOT_ALLOWED and
+// an AVP out of its fixed position with DIAMETER_MISSING_AVP, since the
+// AVP required at that position is absent.
+func ValidateMessage(msg *DiameterMessage) error {
+	if err := ValidateDecoding(msg); err != nil {
+		return err
+	}
+	cmd, registered := lookupMessageCommand(msg)
+	fixed := defaultFixedAVPs
+	if registered {
+		fixed = cmd.FixedAVPs
+		for _, avp := range msg.AVPs {
+			if containsCode(cmd.ForbiddenAVPs, avp.Code) {
+				return &ValidationError{
+					ResultCode: DIAMETER_AVP_NOT_ALLOWED,
+					AVPCode:    avp.Code,
+					Reason:     "is not allowed in this command",
+				}
+			}
+		}
+	}
+
+	position := 0
+	for _, code := range fixed {
+		for i, avp := range msg.AVPs {
+			if avp.Code != code {
+				continue
+			}
+			if i != position {
+				return &ValidationError{
+					ResultCode: DIAMETER_MISSING_AVP,
+					AVPCode:    code,
+					Reason:     fmt.Sprintf("must be at position %d", position),
+				}
+			}
+			position++
+		}
+	}
+	return nil
+}
+
+// ValidationMode selects what a client or server does with an outbound
+// message that fails ValidateOutbound.
+type ValidationMode int
+
+const (
+	// ValidationOff sends messages without checking them.
+	ValidationOff ValidationMode = iota
+	// ValidationWarn logs and counts an invalid message, then sends it.
+	ValidationWarn
+	// ValidationEnforce refuses to send an invalid message and returns
+	// the ValidationError to the sender.
+	ValidationEnforce
+)
+
+func (m ValidationMode) String() string {
+	switch m {
+	case ValidationOff:
+		return "off"
+	case ValidationWarn:
+		return "warn"
+	case ValidationEnforce:
+		return "enforce"
+	}
+	return fmt.Sprintf("ValidationMode(%d)", int(m))
+}
+
+// identityAVPs are required in every message, RFC 6733 section 6.3.
+var identityAVPs = []uint32{AVP_ORIGIN_HOST, AVP_ORIGIN_REALM}
+
+// requestOnlyAVPs route requests and must not appear in answers, RFC 6733
+// sections 6.5 and 6.6.
+var requestOnlyAVPs = []uint32{AVP_DESTINATION_HOST, AVP_DESTINATION_REALM}
+
+// ValidateOutbound checks a message about to be sent against the rules of
+// ValidateMessage and those its peer enforces on receipt. Every message
+// needs Origin-Host and Origin-Realm. A request needs the RequiredAVPs of
+// its command; an answer needs a Result-Code or Experimental-Result and
+// must not carry Destination-Host or Destination-Realm. It only looks at
+// the top-level AVPs, so it is cheap enough for every message sent.
+func ValidateOutbound(msg *DiameterMessage) error {
+	if err := ValidateMessage(msg); err != nil {
+		return err
+	}
+	if err := requireAVPs(msg, identityAVPs); err != nil {
+		return err
+	}
+	if msg.IsRequest() {
+		if cmd, ok := lookupMessageCommand(msg); ok {
+			return requireAVPs(msg, cmd.RequiredAVPs)
+		}
+		return nil
+	}
+	if msg.GetAVP(AVP_RESULT_CODE) == nil && msg.GetAVP(AVP_EXPERIMENTAL_RESULT) == nil {
+		return &ValidationError{
+			ResultCode: DIAMETER_MISSING_AVP,
+			AVPCode:    AVP_RESULT_CODE,
+			Reason:     "is missing, and so is Experimental-Result",
+		}
+	}
+	for _, avp := range msg.AVPs {
+		if containsCode(requestOnlyAVPs, avp.Code) {
+			return &ValidationError{
+				ResultCode: DIAMETER_AVP_NOT_ALLOWED,
+				AVPCode:    avp.Code,
+				Reason:     "is not allowed in answers",
+			}
+		}
+	}
+	return nil
+}
+
+// requireAVPs reports the first of codes missing from msg.
+func requireAVPs(msg *DiameterMessage, codes []uint32) error {
+	for _, code := range codes {
+		if msg.GetAVP(code) == nil {
+			return &ValidationError{
+				ResultCode: DIAMETER_MISSING_AVP,
+				AVPCode:    code,
+				Reason:     "is missing",
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateDecoding reports the first AVP whose value tolerant decoding
+// kept raw. An unknown mandatory AVP is reported with
+// DIAMETER_AVP_UNSUPPORTED, a value of the wrong size with
+// DIAMETER_INVALID_AVP_LENGTH and any other with
+// DIAMETER_INVALID_AVP_VALUE.
+func ValidateDecoding(msg *DiameterMessage) error {
+	errs := msg.DecodeErrors()
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{
+		ResultCode: ResultCodeForError(errs[0].Err),
+		AVPCode:    errs[0].AVP.Code,
+		Reason:     errs[0].Err.Error(),
+	}
+}
+
+func containsCode(codes []uint32, code uint32) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package message
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// newTestCCR returns a CCR carrying its Session-Id last.
+func newTestCCR(t *testing.T) *DiameterMessage {
+	t.Helper()
+	req, err := NewRequest(COMMAND_CODE_CREDIT_CONTROL, WithAVPs(
+		MustNewAVP(AVP_ORIGIN_HOST, "client.example.com", MANDATORY_FLAG),
+		MustNewAVP(AVP_ORIGIN_REALM, "example.com", MANDATORY_FLAG),
+		MustNewAVP(AVP_DESTINATION_REALM, "example.com", MANDATORY_FLAG),
+		MustNewAVP(AVP_AUTH_APPLICATION_ID, APPLICATION_ID_CREDIT_CONTROL, MANDATORY_FLAG),
+		MustNewAVP(AVP_SESSION_ID, "client.example.com;1;2", MANDATORY_FLAG),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestEncodePutsSessionIDFirst(t *testing.T) {
+	req := newTestCCR(t)
+	data, err := req.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := decoded.AVPs[0].Code; code != AVP_SESSION_ID {
+		t.Errorf("first AVP is %d, want Session-Id", code)
+	}
+	if err := ValidateMessage(decoded); err != nil {
+		t.Errorf("encoded CCR fails validation: %v", err)
+	}
+	for i, avp := range decoded.AVPs[1:] {
+		if avp.Code != req.AVPs[i].Code {
+			t.Errorf("AVP %d is %d, want %d: the other AVPs keep their order", i+1, avp.Code, req.AVPs[i].Code)
+		}
+	}
+}
+
+func TestEncodeLeavesMessageOrder(t *testing.T) {
+	req := newTestCCR(t)
+	before := append([]*AVP(nil), req.AVPs...)
+	if _, err := req.Encode(); err != nil {
+		t.Fatal(err)
+	}
+	for i := range before {
+		if req.AVPs[i] != before[i] {
+			t.Fatalf("Encode reordered the AVPs of the message")
+		}
+	}
+}
+
+func TestEncodeKeepOrder(t *testing.T) {
+	req := newTestCCR(t)
+	data, err := EncodeMessage(req, WithKeepOrder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := decoded.AVPs[len(decoded.AVPs)-1].Code; code != AVP_SESSION_ID {
+		t.Errorf("last AVP is %d, want Session-Id where it was put", code)
+	}
+	var invalid *ValidationError
+	if err := ValidateMessage(decoded); !errors.As(err, &invalid) || invalid.ResultCode != DIAMETER_MISSING_AVP {
+		t.Errorf("misplaced Session-Id: %v, want DIAMETER_MISSING_AVP", err)
+	}
+}
+
+func TestConcurrentEncode(t *testing.T) {
+	req := newTestCCR(t)
+	want, err := req.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				got, err := req.Encode()
+				if err != nil || string(got) != string(want) {
+					t.Errorf("concurrent Encode() = %x, %v", got, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestNormalize(t *testing.T) {
+	req := newTestCCR(t)
+	req.Normalize()
+	if req.AVPs[0].Code != AVP_SESSION_ID {
+		t.Errorf("first AVP is %d after Normalize, want Session-Id", req.AVPs[0].Code)
+	}
+	if err := ValidateMessage(req); err != nil {
+		t.Errorf("normalized CCR fails validation: %v", err)
+	}
+}
+
+func TestSessionIDForbiddenInCER(t *testing.T) {
+	cer, err := Node{OriginHost: "client.example.com", OriginRealm: "example.com"}.BuildCER(Applications{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateMessage(cer); err != nil {
+		t.Fatalf("CER fails validation: %v", err)
+	}
+	cer.AVPs = append([]*AVP{MustNewAVP(AVP_SESSION_ID, "client.example.com;1;2", MANDATORY_FLAG)}, cer.AVPs...)
+	var invalid *ValidationError
+	if err := ValidateMessage(cer); !errors.As(err, &invalid) || invalid.ResultCode != DIAMETER_AVP_NOT_ALLOWED || invalid.AVPCode != AVP_SESSION_ID {
+		t.Errorf("CER with Session-Id: %v, want DIAMETER_AVP_NOT_ALLOWED for Session-Id", err)
+	}
+}
+
+func TestValidateOutbound(t *testing.T) {
+	req := newTestCCR(t)
+	req.Normalize()
+	answer := func(avps ...*AVP) *DiameterMessage { return NewAnswer(req, avps...) }
+	host := MustNewAVP(AVP_ORIGIN_HOST, "server.example.com", MANDATORY_FLAG)
+	realm := MustNewAVP(AVP_ORIGIN_REALM, "example.com", MANDATORY_FLAG)
+	result := MustNewAVP(AVP_RESULT_CODE, uint32(DIAMETER_SUCCESS), MANDATORY_FLAG)
+	for _, tc := range []struct {
+		name string
+		msg  *DiameterMessage
+		code ResultCode
+		avp  uint32
+	}{
+		{"request", req, 0, 0},
+		{"answer", answer(result, host, realm), 0, 0},
+		{"answer without Origin-Host", answer(result, realm), DIAMETER_MISSING_AVP, AVP_ORIGIN_HOST},
+		{"answer without Result-Code", answer(host, realm), DIAMETER_MISSING_AVP, AVP_RESULT_CODE},
+		{"answer with Destination-Realm", answer(result, host, realm, MustNewAVP(AVP_DESTINATION_REALM, "example.com", MANDATORY_FLAG)), DIAMETER_AVP_NOT_ALLOWED, AVP_DESTINATION_REALM},
+	} {
+		err := ValidateOutbound(tc.msg)
+		if tc.code == 0 {
+			if err != nil {
+				t.Errorf("%s: %v", tc.name, err)
+			}
+			continue
+		}
+		var verr *ValidationError
+		if !errors.As(err, &verr) || verr.ResultCode != tc.code || verr.AVPCode != tc.avp {
+			t.Errorf("%s: %v, want %v for AVP %d", tc.name, err, tc.code, tc.avp)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Wireshark style text rendering of messages for bug reports
+package message
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// commandFlagNames names the bits of the command flags from the most
+// significant one, as Wireshark does.
+var commandFlagNames = [8]string{
+	"Request",
+	"Proxiable",
+	"Error",
+	"T(Potentially re-transmitted message)",
+	"Reserved",
+	"Reserved",
+	"Reserved",
+	"Reserved",
+}
+
+// WiresharkText renders msg the way Wireshark exports the Diameter
+// protocol tree as text, which vendors usually ask for with interop bug
+// reports: the header with a line per command flag bit, then a line per
+// AVP with its name, code, length, flags, vendor and value, the AVPs of
+// Grouped values indented below their parent. AVPs whose type is not
+// known are shown in hex. The output is close to, not identical with,
+// Wireshark's.
+func (msg *DiameterMessage) WiresharkText() string {
+	var b strings.Builder
+	h := msg.Header
+	b.WriteString("Diameter Protocol\n")
+	fmt.Fprintf(&b, "    Version: 0x%02x\n", h.Version)
+	fmt.Fprintf(&b, "    Length: %d\n", msg.wiresharkLength())
+	fmt.Fprintf(&b, "    Flags: 0x%02x", uint8(h.CommandFlags))
+	for i := range 4 {
+		if uint8(h.CommandFlags)&(0x80>>i) != 0 {
+			b.WriteString(", " + commandFlagNames[i])
+		}
+	}
+	b.WriteString("\n")
+	for i, name := range commandFlagNames {
+		set := uint8(h.CommandFlags)&(0x80>>i) != 0
+		fmt.Fprintf(&b, "        %s = %s: %s\n", bitPattern(uint8(h.CommandFlags), i), name, setOrNot(set))
+	}
+	name := CommandName(h.CommandCode, FlagRequest)
+	fmt.Fprintf(&b, "    Command Code: %d %s\n", h.CommandCode, strings.TrimSuffix(name, "-Request"))
+	fmt.Fprintf(&b, "    ApplicationId: %d\n", h.ApplicationID)
+	fmt.Fprintf(&b, "    Hop-by-Hop Identifier: 0x%08x\n", h.HopByHopID)
+	fmt.Fprintf(&b, "    End-to-End Identifier: 0x%08x\n", h.EndToEndID)
+	// vendor is the Vendor-Id of the Experimental-Result being written.
+	var vendor uint32
+	for path, avp := range msg.Walk() {
+		if avp.Code == AVP_EXPERIMENTAL_RESULT && avp.VendorID == 0 {
+			vendor = experimentalVendor(avp)
+		}
+		writeWiresharkAVP(&b, avp, len(path), vendor)
+	}
+	return b.String()
+}
+
+// wiresharkLength returns the Message Length of the header, or the length
+// the message will have on the wire when it was built rather than decoded.
+func (msg *DiameterMessage) wiresharkLength() uint32 {
+	if msg.Header.MessageLength != 0 {
+		return msg.Header.MessageLength
+	}
+	length := uint32(DIAMETER_HEADER_SIZE)
+	for _, avp := range msg.AVPs {
+		n := avpLength(avp)
+		length += n + uint32(getPadding(int(n)))
+	}
+	return length
+}
+
+// avpLength returns the AVP Length of a, computing it for AVPs whose
+// length was never set.
+func avpLength(a *AVP) uint32 {
+	if a.AVPlength != 0 || a.Data == nil {
+		return a.AVPlength
+	}
+	return uint32(a.getHeaderLength()) + a.Data.Length()
+}
+
+// writeWiresharkAVP writes the summary line of avp at the given depth.
+// The members of a Grouped value follow on their own lines. vendor names
+// an Experimental-Result-Code.
+func writeWiresharkAVP(b *strings.Builder, avp *AVP, depth int, vendor uint32) {
+	indent := strings.Repeat("    ", depth)
+	fmt.Fprintf(b, "%sAVP: %s(%d) l=%d f=%s", indent, AVPName(avp.Code, avp.VendorID), avp.Code, avpLength(avp), avpFlagString(avp.Flags))
+	if avp.isFlagSet(VENDOR_FLAG) {
+		fmt.Fprintf(b, " vnd=%s", VendorName(avp.VendorID))
+	}
+	if _, ok := avp.Data.(*Grouped); ok {
+		b.WriteString("\n")
+		return
+	}
+	fmt.Fprintf(b, " val=%s\n", wiresharkValue(avp, vendor))
+}
+
+// wiresharkValue renders the value of avp: in hex when its type is not
+// known or it could not be decoded, with the name of the code for
+// Result-Code and for an Experimental-Result-Code registered for vendor,
+// and as String renders it otherwise.
+func wiresharkValue(avp *AVP, vendor uint32) string {
+	if avp.Data == nil {
+		return ""
+	}
+	if _, known := newAVPDataFor(avp.Code, avp.VendorID); !known || avp.decodeErr != nil {
+		data, err := avp.Data.Encode()
+		if err != nil {
+			return avp.Data.String()
+		}
+		return "0x" + hex.EncodeToString(data)
+	}
+	if avp.Code == AVP_RESULT_CODE && avp.VendorID == 0 {
+		if code, err := avp.Uint32(); err == nil {
+			return fmt.Sprintf("%s (%d)", ResultCode(code), code)
+		}
+	}
+	if avp.Code == AVP_EXPERIMENTAL_RESULT_CODE && avp.VendorID == 0 {
+		if code, err := avp.Uint32(); err == nil {
+			if name := ExperimentalResultName(vendor, ResultCode(code)); name != "" {
+				return fmt.Sprintf("%s (%d)", name, code)
+			}
+		}
+	}
+	return avp.Data.String()
+}
+
+// experimentalVendor returns the Vendor-Id of an Experimental-Result AVP.
+func experimentalVendor(avp *AVP) uint32 {
+	group, err := avp.Group()
+	if err != nil {
+		return 0
+	}
+	var vendor uint32
+	if id, ok := group.Get(AVP_VENDOR_ID); ok {
+		vendor, _ = id.Uint32()
+	}
+	return vendor
+}
+
+// avpFlagString renders the 'V', 'M' and 'P' bits of flags, with '-' for
+// the bits that are clear.
+func avpFlagString(flags uint8) string {
+	f := []byte("---")
+	if flags&VENDOR_FLAG != 0 {
+		f[0] = 'V'
+	}
+	if flags&MANDATORY_FLAG != 0 {
+		f[1] = 'M'
+	}
+	if flags&PROTECTED_FLAG != 0 {
+		f[2] = 'P'
+	}
+	return string(f)
+}
+
+// bitPattern renders bit i, counted from the most significant one, of b
+// as Wireshark does, e.g. "..0. ....".
+func bitPattern(b uint8, i int) string {
+	p := []byte("........")
+	if b&(0x80>>i) != 0 {
+		p[i] = '1'
+	} else {
+		p[i] = '0'
+	}
+	return string(p[:4]) + " " + string(p[4:])
+}
+
+func setOrNot(set bool) string {
+	if set {
+		return "Set"
+	}
+	return "Not set"
+}
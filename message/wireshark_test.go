@@ -0,0 +1,124 @@
+package message
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the tests")
+
+// wiresharkCCR returns an encoded Credit-Control request with a 3GPP
+// Grouped AVP nested two deep and an AVP missing from the dictionary.
+func wiresharkCCR(t *testing.T) []byte {
+	t.Helper()
+	qci, level := uint32(9), uint32(2)
+	qos, err := QoSInformation{QCI: &qci, ARP: &AllocationRetentionPriority{PriorityLevel: level}}.ToAVP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := NewRequest(COMMAND_CODE_CREDIT_CONTROL, WithAVPs(
+		MustNewAVP(AVP_SESSION_ID, "client.example.com;1;1", MANDATORY_FLAG),
+		MustNewAVP(AVP_ORIGIN_HOST, "client.example.com", MANDATORY_FLAG),
+		MustNewAVP(AVP_ORIGIN_REALM, "example.com", MANDATORY_FLAG),
+		MustNewAVP(AVP_AUTH_APPLICATION_ID, APPLICATION_ID_CREDIT_CONTROL, MANDATORY_FLAG),
+		qos,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Header.HopByHopID, msg.Header.EndToEndID = 0x1234, 0xabcd0001
+	data, err := msg.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, rawAVP(99999, 0, AVPHeaderLength+5, []byte("\x01\x02\x03\x04\x05"))...)
+	length := uint32(len(data))
+	data[1], data[2], data[3] = byte(length>>16), byte(length>>8), byte(length)
+	return data
+}
+
+// wiresharkCCA returns an encoded Credit-Control answer with a 3GPP
+// Experimental-Result and an Auth-Application-Id too short to decode.
+func wiresharkCCA(t *testing.T) []byte {
+	t.Helper()
+	result, err := NewGroupedAVP(AVP_EXPERIMENTAL_RESULT, MANDATORY_FLAG, 0,
+		MustNewAVP(AVP_VENDOR_ID, uint32(VENDOR_3GPP), MANDATORY_FLAG),
+		MustNewAVP(AVP_EXPERIMENTAL_RESULT_CODE, uint32(DIAMETER_ERROR_BEARER_NOT_AUTHORIZED), MANDATORY_FLAG),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := DecodeMessage(wiresharkCCR(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ans := NewAnswer(req,
+		MustNewAVP(AVP_SESSION_ID, "client.example.com;1;1", MANDATORY_FLAG),
+		MustNewAVP(AVP_RESULT_CODE, uint32(DIAMETER_UNABLE_TO_COMPLY), MANDATORY_FLAG),
+		result,
+		MustNewAVP(AVP_ORIGIN_HOST, "server.example.com", MANDATORY_FLAG),
+		MustNewAVP(AVP_ORIGIN_REALM, "example.com", MANDATORY_FLAG),
+	)
+	data, err := ans.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, rawAVP(AVP_AUTH_APPLICATION_ID, MANDATORY_FLAG, AVPHeaderLength+3, []byte{0, 0, 4})...)
+	length := uint32(len(data))
+	data[1], data[2], data[3] = byte(length>>16), byte(length>>8), byte(length)
+	return data
+}
+
+// TestWiresharkTextGolden compares the rendering of decoded fixtures with
+// the files in testdata/wireshark. Run with -update to rewrite them after
+// an intended change of format.
+func TestWiresharkTextGolden(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		frame []byte
+	}{
+		{"ccr", wiresharkCCR(t)},
+		{"cca", wiresharkCCA(t)},
+	} {
+		msg, err := DecodeMessage(tc.frame)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		got := msg.WiresharkText()
+		golden := filepath.Join("testdata", "wireshark", tc.name+".txt")
+		if *update {
+			if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != string(want) {
+			t.Errorf("%s renders as\n%s\nwant\n%s", tc.name, got, want)
+		}
+	}
+}
+
+// TestWiresharkTextBuilt checks that a message built rather than decoded
+// renders with the lengths it will have on the wire.
+func TestWiresharkTextBuilt(t *testing.T) {
+	frame := wiresharkCCR(t)
+	decoded, err := DecodeMessage(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	built := &DiameterMessage{Header: &DiameterHeader{}, AVPs: decoded.AVPs}
+	*built.Header = *decoded.Header
+	built.Header.MessageLength = 0
+	for _, avp := range built.AVPs {
+		avp.AVPlength = 0
+	}
+	if got, want := built.WiresharkText(), decoded.WiresharkText(); got != want {
+		t.Errorf("built message renders as\n%s\nwant\n%s", got, want)
+	}
+}
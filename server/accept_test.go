@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/client"
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/transport"
+)
+
+// scriptedListener returns the errors sent on errs from Accept, one per
+// call, and accepts on listener when nil is sent.
+type scriptedListener struct {
+	listener *transport.DiameterListener
+	errs     chan error
+}
+
+func (l *scriptedListener) Accept() (*transport.DiameterConnection, error) {
+	if err := <-l.errs; err != nil {
+		return nil, err
+	}
+	return l.listener.Accept()
+}
+
+// acceptError returns err as Accept reports a failed accept system call.
+func acceptError(err error) error {
+	return &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", err)}
+}
+
+// newAcceptServer returns a listening server whose accept loop runs on a
+// scripted listener, with the channel feeding it and the result of the
+// loop.
+func newAcceptServer(t *testing.T, opts ...ServerOptionsFunc) (*Server, chan<- error, <-chan error) {
+	t.Helper()
+	defaults := []ServerOptionsFunc{
+		WithServerAddr("127.0.0.1:0"),
+		WithOriginHost("server.example.com"),
+		WithOriginRealm("example.com"),
+		WithAuthApplications(message.APPLICATION_ID_CREDIT_CONTROL),
+	}
+	s, err := NewServer(append(defaults, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	errs := make(chan error)
+	served := make(chan error, 1)
+	go func() { served <- s.serve(&scriptedListener{listener: s.listener, errs: errs}) }()
+	return s, errs, served
+}
+
+// TestAcceptErrors feeds the accept loop temporary errors and expired
+// accept timeouts, then checks that it still accepts a client.
+func TestAcceptErrors(t *testing.T) {
+	s, errs, served := newAcceptServer(t)
+	for _, err := range []error{
+		acceptError(syscall.EMFILE),
+		acceptError(syscall.ECONNABORTED),
+		transport.ErrAcceptTimeout,
+		&net.OpError{Op: "accept", Net: "tcp", Err: os.ErrDeadlineExceeded},
+		nil,
+	} {
+		select {
+		case errs <- err:
+		case err := <-served:
+			t.Fatalf("accept loop ended with %v", err)
+		}
+	}
+	c, err := client.NewClient(
+		client.WithServerAddr(s.ListenerAddrs()[0].String()),
+		client.WithOriginHost("client.example.com"),
+		client.WithOriginRealm("example.com"),
+		client.WithAuthApplications(message.APPLICATION_ID_CREDIT_CONTROL),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.WaitReady(ctx); err != nil {
+		t.Fatalf("client not served after the errors: %v", err)
+	}
+	if n := s.StatsSnapshot().AcceptErrors; n != 2 {
+		t.Errorf("%d accept errors counted, want the 2 temporary ones", n)
+	}
+
+	permanent := errors.New("listener broken")
+	errs <- permanent
+	if err := <-served; err != permanent {
+		t.Errorf("accept loop ended with %v, want %v", err, permanent)
+	}
+}
+
+// TestAcceptBackoff checks that the delay after a temporary error doubles
+// from 5ms up to 1s.
+func TestAcceptBackoff(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	_, errs, served := newAcceptServer(t, WithClock(clk))
+	base := clk.Pending()
+	for _, delay := range []time.Duration{
+		5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond,
+		80 * time.Millisecond, 160 * time.Millisecond, 320 * time.Millisecond, 640 * time.Millisecond,
+		time.Second, time.Second,
+	} {
+		errs <- acceptError(syscall.EMFILE)
+		waitFor(t, "the retry delay", func() bool { return clk.Pending() == base+1 })
+		clk.Advance(delay - time.Nanosecond)
+		if clk.Pending() != base+1 {
+			t.Fatalf("retried before %v", delay)
+		}
+		clk.Advance(time.Nanosecond)
+		if clk.Pending() != base {
+			t.Fatalf("not retried after %v", delay)
+		}
+	}
+	errs <- net.ErrClosed
+	if err := <-served; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("accept loop ended with %v, want net.ErrClosed", err)
+	}
+}
+
+// waitFor waits for cond to hold.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
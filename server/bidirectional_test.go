@@ -0,0 +1,196 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+)
+
+// newRAR returns a RAR of s for session, to the peer id.
+func newRAR(t testing.TB, s *server.Server, id message.PeerIdentity, session string) *message.DiameterMessage {
+	t.Helper()
+	var b message.MessageBuilder
+	avps, err := b.Add(message.AVP_SESSION_ID, session, message.MANDATORY_FLAG).
+		Add(message.AVP_ORIGIN_HOST, serverNode.OriginHost, message.MANDATORY_FLAG).
+		Add(message.AVP_ORIGIN_REALM, serverNode.OriginRealm, message.MANDATORY_FLAG).
+		Add(message.AVP_DESTINATION_REALM, id.Realm, message.MANDATORY_FLAG).
+		Add(message.AVP_DESTINATION_HOST, id.Host, message.MANDATORY_FLAG).
+		Add(message.AVP_AUTH_APPLICATION_ID, message.APPLICATION_ID_CREDIT_CONTROL, message.MANDATORY_FLAG).
+		Add(message.AVP_RE_AUTH_REQUEST_TYPE, uint32(0), message.MANDATORY_FLAG).
+		AVPs()
+	if err != nil {
+		t.Fatalf("building RAR AVPs: %v", err)
+	}
+	req, err := s.NewRequest(message.COMMAND_CODE_RE_AUTH,
+		message.WithApplication(message.APPLICATION_ID_CREDIT_CONTROL), message.WithAVPs(avps...))
+	if err != nil {
+		t.Fatalf("building RAR: %v", err)
+	}
+	return req
+}
+
+// TestInterleavedRequests sends CCRs from the client and RARs from the
+// server at the same time on one connection, and checks that each side
+// gets the answers to its own requests; run with -race.
+func TestInterleavedRequests(t *testing.T) {
+	s, addr := startServer(t)
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, answerSuccess)
+	c := connectClient(t, addr, "client.example.com")
+	id, ok := s.LookupPeer("client.example.com")
+	if !ok {
+		t.Fatal("client not among the peers of the server")
+	}
+
+	const workers, perWorker = 4, 50
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := range perWorker {
+				req := newCCR(t, c, fmt.Sprintf("client.example.com;%d;%d", w, i))
+				ans, err := c.Request(ctx, req)
+				if err != nil {
+					t.Errorf("CCR: %v", err)
+					return
+				}
+				if ans.Header.CommandCode != message.COMMAND_CODE_CREDIT_CONTROL || ans.Header.EndToEndID != req.Header.EndToEndID {
+					t.Errorf("CCR answered with %s, End-to-End %d for %d", ans.CommandName(), ans.Header.EndToEndID, req.Header.EndToEndID)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := range perWorker {
+				req := newRAR(t, s, id, fmt.Sprintf("server.example.com;%d;%d", w, i))
+				ans, err := s.Request(ctx, id, req)
+				if err != nil {
+					t.Errorf("RAR: %v", err)
+					return
+				}
+				if ans.Header.CommandCode != message.COMMAND_CODE_RE_AUTH || ans.Header.EndToEndID != req.Header.EndToEndID {
+					t.Errorf("RAR answered with %s, End-to-End %d for %d", ans.CommandName(), ans.Header.EndToEndID, req.Header.EndToEndID)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got := s.StatsSnapshot().OrphanedAnswers; got != 0 {
+		t.Errorf("%d orphaned answers", got)
+	}
+}
+
+// TestOrphanedAnswer checks that an answer matching no request of the
+// server is counted and dropped without disturbing the connection.
+func TestOrphanedAnswer(t *testing.T) {
+	orphans := make(chan *message.DiameterMessage, 1)
+	s, addr := startServer(t, server.WithOrphanAnswerHandler(func(peer string, msg *message.DiameterMessage) {
+		orphans <- msg
+	}))
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, answerSuccess)
+	conn := dialRaw(t, addr)
+
+	dwr, err := clientNode.BuildDWR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphan, err := clientNode.BuildDWA(dwr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphan.Header.HopByHopID = 0xdead
+	frame, err := orphan.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-orphans:
+		if got.Header.HopByHopID != 0xdead {
+			t.Errorf("orphan handler got Hop-by-Hop %#x", got.Header.HopByHopID)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("orphan handler not called")
+	}
+	if got := s.StatsSnapshot().OrphanedAnswers; got != 1 {
+		t.Errorf("OrphanedAnswers = %d, want 1", got)
+	}
+
+	dwr.Header.HopByHopID = 1
+	if frame, err = dwr.Encode(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	ans, err := message.DecodeMessage(readRawFrame(t, bufio.NewReader(conn), nil))
+	if err != nil {
+		t.Fatalf("decoding DWA: %v", err)
+	}
+	if ans.Header.CommandCode != message.COMMAND_CODE_DEVICE_WATCHDOG || ans.Header.HopByHopID != 1 {
+		t.Errorf("DWR after the orphan answered with %s, Hop-by-Hop %d", ans.CommandName(), ans.Header.HopByHopID)
+	}
+}
+
+// TestLateAnswerOrphaned lets a RAR time out and has the client answer it
+// afterwards: the answer goes to the orphan handler with the identifiers
+// of the request.
+func TestLateAnswerOrphaned(t *testing.T) {
+	orphans := make(chan *message.DiameterMessage, 1)
+	var orphanPeer string
+	s, addr := startServer(t, server.WithOrphanAnswerHandler(func(peer string, msg *message.DiameterMessage) {
+		orphanPeer = peer
+		orphans <- msg
+	}))
+	conn := dialRaw(t, addr)
+	id, _ := s.LookupPeer(clientNode.OriginHost)
+	rar := newRAR(t, s, id, "server.example.com;1;late")
+
+	_, err := s.RequestWithTimeout(id, rar, 50*time.Millisecond)
+	if !errors.Is(err, server.ErrRequestTimeout) {
+		t.Fatalf("RequestWithTimeout = %v, want ErrRequestTimeout", err)
+	}
+	r := bufio.NewReader(conn)
+	received, err := message.DecodeMessage(readRawFrame(t, r, nil))
+	if err != nil {
+		t.Fatalf("decoding RAR: %v", err)
+	}
+	raa, err := clientNode.BuildAnswer(received, message.DIAMETER_SUCCESS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := raa.Encode()
+	if err == nil {
+		_, err = conn.Write(frame)
+	}
+	if err != nil {
+		t.Fatalf("answering RAR: %v", err)
+	}
+
+	select {
+	case got := <-orphans:
+		if got.Header.EndToEndID != rar.Header.EndToEndID || got.Header.HopByHopID != rar.Header.HopByHopID {
+			t.Errorf("orphan handler got identifiers %#x/%#x, want those of the RAR %#x/%#x",
+				got.Header.HopByHopID, got.Header.EndToEndID, rar.Header.HopByHopID, rar.Header.EndToEndID)
+		}
+		if orphanPeer != id.String() {
+			t.Errorf("orphan handler got peer %q, want %q", orphanPeer, id)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("orphan handler not called")
+	}
+	if got := s.StatsSnapshot().OrphanedAnswers; got != 1 {
+		t.Errorf("OrphanedAnswers = %d, want 1", got)
+	}
+}
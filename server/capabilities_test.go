@@ -0,0 +1,322 @@
+package server_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+)
+
+// exchangeCapabilities connects to addr and sends a CER of clientNode
+// advertising apps, returning the connection and the CEA.
+func exchangeCapabilities(t *testing.T, addr string, apps message.Applications) (net.Conn, *bufio.Reader, *message.DiameterMessage) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	cer, err := clientNode.BuildCER(apps)
+	if err != nil {
+		t.Fatalf("building CER: %v", err)
+	}
+	writeMessage(t, conn, cer)
+	r := bufio.NewReader(conn)
+	return conn, r, readMessage(t, conn, r)
+}
+
+func TestCapabilitiesNegotiation(t *testing.T) {
+	const gx = uint32(16777238)
+	for _, tc := range []struct {
+		name string
+		apps message.Applications
+		want message.ResultCode
+		// auth and acct tell whether Credit-Control and base accounting
+		// requests are then accepted.
+		auth, acct bool
+	}{
+		{"auth only", message.Applications{Auth: message.NewApplicationSet(message.APPLICATION_ID_CREDIT_CONTROL)}, message.DIAMETER_SUCCESS, true, false},
+		{"acct only", message.Applications{Acct: message.NewApplicationSet(message.APPLICATION_ID_BASE_ACCOUNTING)}, message.DIAMETER_SUCCESS, false, true},
+		{"relay", message.Applications{Auth: message.NewApplicationSet(message.APPLICATION_ID_RELAY)}, message.DIAMETER_SUCCESS, true, false},
+		{"acct offered as auth", message.Applications{Auth: message.NewApplicationSet(message.APPLICATION_ID_BASE_ACCOUNTING)}, message.DIAMETER_NO_COMMON_APPLICATION, false, false},
+		{"no overlap", message.Applications{Auth: message.NewApplicationSet(gx)}, message.DIAMETER_NO_COMMON_APPLICATION, false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, addr := startServer(t, server.WithAcctApplications(message.APPLICATION_ID_BASE_ACCOUNTING))
+			s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, answerSuccess)
+			s.HandleFunc(message.APPLICATION_ID_BASE_ACCOUNTING, message.COMMAND_CODE_ACCOUNTING, answerSuccess)
+			conn, r, cea := exchangeCapabilities(t, addr, tc.apps)
+			if code, _, err := message.GetResultCode(cea); err != nil || code != tc.want {
+				t.Fatalf("CEA Result-Code %v, %v; want %v", code, err, tc.want)
+			}
+			if tc.want != message.DIAMETER_SUCCESS {
+				return
+			}
+
+			for _, req := range []struct {
+				accepted bool
+				msg      *message.DiameterMessage
+			}{
+				{tc.auth, rawRequest(t, message.COMMAND_CODE_CREDIT_CONTROL, message.APPLICATION_ID_CREDIT_CONTROL, "client.example.com;1;1")},
+				{tc.acct, rawRequest(t, message.COMMAND_CODE_ACCOUNTING, message.APPLICATION_ID_BASE_ACCOUNTING, "client.example.com;1;2")},
+			} {
+				writeMessage(t, conn, req.msg)
+				ans := readMessage(t, conn, r)
+				want := message.DIAMETER_SUCCESS
+				if !req.accepted {
+					want = message.DIAMETER_APPLICATION_UNSUPPORTED
+				}
+				if code, _, err := message.GetResultCode(ans); err != nil || code != want {
+					t.Errorf("%s answered with %v, %v; want %v", req.msg.CommandName(), code, err, want)
+				}
+			}
+		})
+	}
+}
+
+// TestCERMissingOriginHost checks that a CER without Origin-Host is
+// answered with DIAMETER_MISSING_AVP and an Error-Message, and the
+// connection closed.
+func TestCERMissingOriginHost(t *testing.T) {
+	_, addr := startServer(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	defer conn.Close()
+	cer, err := clientNode.BuildCER(message.Applications{Auth: message.NewApplicationSet(message.APPLICATION_ID_CREDIT_CONTROL)})
+	if err != nil {
+		t.Fatalf("building CER: %v", err)
+	}
+	avps := cer.AVPs[:0]
+	for _, avp := range cer.AVPs {
+		if avp.Code != message.AVP_ORIGIN_HOST {
+			avps = append(avps, avp)
+		}
+	}
+	cer.AVPs = avps
+	writeMessage(t, conn, cer)
+	r := bufio.NewReader(conn)
+	result, err := message.GetResult(readMessage(t, conn, r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != message.DIAMETER_MISSING_AVP || result.ErrorMessage == "" {
+		t.Errorf("CEA result %+v, want DIAMETER_MISSING_AVP with an Error-Message", result)
+	}
+	if result.ErrorReportingHost != "" {
+		t.Errorf("Error-Reporting-Host %q on an answer of the node itself", result.ErrorReportingHost)
+	}
+	if _, err := r.ReadByte(); err == nil {
+		t.Error("connection left open")
+	}
+}
+
+// TestValidateHostIP sends CERs advertising various Host-IP-Addresses
+// from 127.0.0.1 and checks the CEA in each mode, and the address named in
+// Failed-AVP when the CER is rejected.
+func TestValidateHostIP(t *testing.T) {
+	local, other := net.IPv4(127, 0, 0, 1), net.IPv4(192, 0, 2, 1)
+	for _, tc := range []struct {
+		name       string
+		mode       server.HostIPMode
+		advertised []net.IP
+		want       message.ResultCode
+		// failed is the address in Failed-AVP when the CER is rejected.
+		failed net.IP
+	}{
+		{"matching", server.HostIPEnforce, []net.IP{local}, message.DIAMETER_SUCCESS, nil},
+		{"one of several matching", server.HostIPEnforce, []net.IP{other, local}, message.DIAMETER_SUCCESS, nil},
+		{"mismatching", server.HostIPEnforce, []net.IP{other}, message.DIAMETER_INVALID_AVP_VALUE, other},
+		{"none", server.HostIPEnforce, nil, message.DIAMETER_MISSING_AVP, net.IPv4zero},
+		{"mismatching with warn", server.HostIPWarn, []net.IP{other}, message.DIAMETER_SUCCESS, nil},
+		{"mismatching with off", server.HostIPOff, []net.IP{other}, message.DIAMETER_SUCCESS, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, addr := startServer(t, server.WithValidateHostIP(tc.mode))
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatalf("dialing: %v", err)
+			}
+			defer conn.Close()
+			cer, err := clientNode.BuildCER(message.Applications{Auth: message.NewApplicationSet(message.APPLICATION_ID_CREDIT_CONTROL)})
+			if err != nil {
+				t.Fatalf("building CER: %v", err)
+			}
+			avps := cer.AVPs[:0]
+			for _, avp := range cer.AVPs {
+				if avp.Code != message.AVP_HOST_IP_ADDRESS {
+					avps = append(avps, avp)
+				}
+			}
+			for _, ip := range tc.advertised {
+				avps = append(avps, message.MustNewAVP(message.AVP_HOST_IP_ADDRESS, ip, message.MANDATORY_FLAG))
+			}
+			cer.AVPs = avps
+			writeMessage(t, conn, cer)
+			r := bufio.NewReader(conn)
+			cea := readMessage(t, conn, r)
+			if code, _, err := message.GetResultCode(cea); err != nil || code != tc.want {
+				t.Fatalf("CEA Result-Code %v, %v; want %v", code, err, tc.want)
+			}
+			if tc.failed == nil {
+				return
+			}
+
+			failed, err := cea.GetAVP(message.AVP_FAILED_AVP).Group()
+			if err != nil {
+				t.Fatalf("Failed-AVP: %v", err)
+			}
+			avp, ok := failed.Get(message.AVP_HOST_IP_ADDRESS)
+			if !ok {
+				t.Fatalf("Failed-AVP %v does not name Host-IP-Address", failed.AVPs)
+			}
+			if ip, err := avp.IP(); err != nil || !ip.Equal(tc.failed) {
+				t.Errorf("Failed-AVP holds address %v, %v; want %v", ip, err, tc.failed)
+			}
+			if _, err := r.ReadByte(); err == nil {
+				t.Error("connection left open")
+			}
+		})
+	}
+}
+
+// TestCEAProductName checks the Product-Name and Firmware-Revision of the
+// CEA, by default and when configured.
+func TestCEAProductName(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		opts     []server.ServerOptionsFunc
+		product  string
+		firmware uint32
+	}{
+		{"default", nil, message.DefaultProductName(), message.DefaultFirmwareRevision()},
+		{"configured", []server.ServerOptionsFunc{server.WithProductName("ocs"), server.WithFirmwareRevision(7)}, "ocs", 7},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, addr := startServer(t, tc.opts...)
+			_, _, cea := exchangeCapabilities(t, addr, message.Applications{Auth: message.NewApplicationSet(message.APPLICATION_ID_CREDIT_CONTROL)})
+			software := message.ParsePeerSoftware(cea)
+			if software.ProductName != tc.product || software.FirmwareRevision != tc.firmware {
+				t.Errorf("CEA advertises %+v, want Product-Name %q and Firmware-Revision %d", software, tc.product, tc.firmware)
+			}
+			if avp := cea.GetAVP(message.AVP_FIRMWARE_REVISION); (avp != nil) != (tc.firmware != 0) {
+				t.Errorf("Firmware-Revision AVP %v, want one only for revision %d", avp, tc.firmware)
+			}
+		})
+	}
+}
+
+// TestPeerSoftware connects a peer advertising its software in its CER and
+// checks what the peer up handler and PeerInfo report of it.
+func TestPeerSoftware(t *testing.T) {
+	up := make(chan server.PeerInfo, 1)
+	s, addr := startServer(t, server.WithPeerUpHandler(func(info server.PeerInfo) { up <- info }))
+	node := clientNode
+	node.VendorID, node.ProductName, node.FirmwareRevision = message.VENDOR_ERICSSON, "pcef", 3
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	defer conn.Close()
+	cer, err := node.BuildCER(message.Applications{Auth: message.NewApplicationSet(message.APPLICATION_ID_CREDIT_CONTROL)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeMessage(t, conn, cer)
+	readMessage(t, conn, bufio.NewReader(conn))
+
+	want := message.PeerSoftwareInfo{VendorID: message.VENDOR_ERICSSON, VendorName: "Ericsson", ProductName: "pcef", FirmwareRevision: 3}
+	check := func(what string, got message.PeerSoftwareInfo) {
+		t.Helper()
+		if got.VendorID != want.VendorID || got.VendorName != want.VendorName || got.ProductName != want.ProductName || got.FirmwareRevision != want.FirmwareRevision {
+			t.Errorf("%s reports %+v, want %+v", what, got, want)
+		}
+	}
+	var info server.PeerInfo
+	select {
+	case info = <-up:
+	case <-time.After(testTimeout):
+		t.Fatal("peer up handler not called")
+	}
+	check("the peer up handler", info.Software)
+	id := message.NewPeerIdentity(node.OriginHost, node.OriginRealm)
+	if info, ok := s.PeerInfo(id); !ok {
+		t.Error("PeerInfo found no peer")
+	} else {
+		check("PeerInfo", info.Software)
+	}
+	b, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct{ Software message.PeerSoftwareInfo }
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	check("the JSON of PeerInfo", decoded.Software)
+}
+
+// TestEarlyMessages sends messages other than a CER as the first message
+// of a connection: a request is answered with DIAMETER_UNKNOWN_PEER unless
+// automatic error answers are disabled, and the connection is closed
+// without the handler running.
+func TestEarlyMessages(t *testing.T) {
+	ccr := rawRequest(t, message.COMMAND_CODE_CREDIT_CONTROL, message.APPLICATION_ID_CREDIT_CONTROL, "client.example.com;1;1")
+	cca, err := clientNode.BuildAnswer(ccr, message.DIAMETER_SUCCESS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		opts []server.ServerOptionsFunc
+		msg  *message.DiameterMessage
+		// answered tells whether the server answers before closing.
+		answered bool
+	}{
+		{"request", nil, ccr, true},
+		{"request without error answers", []server.ServerOptionsFunc{server.WithAutoErrorAnswers(false)}, ccr, false},
+		{"answer", nil, cca, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, addr := startServer(t, tc.opts...)
+			var handled atomic.Int32
+			s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, func(w server.ResponseWriter, req *message.DiameterMessage) {
+				handled.Add(1)
+				answerSuccess(w, req)
+			})
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatalf("dialing: %v", err)
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(testTimeout))
+			r := bufio.NewReader(conn)
+			writeMessage(t, conn, tc.msg)
+			if tc.answered {
+				ans := readMessage(t, conn, r)
+				if code, _, err := message.GetResultCode(ans); err != nil || code != message.DIAMETER_UNKNOWN_PEER {
+					t.Errorf("answer Result-Code %v, %v; want DIAMETER_UNKNOWN_PEER", code, err)
+				}
+				if ans.Header.HopByHopID != tc.msg.Header.HopByHopID {
+					t.Error("answer does not match the request")
+				}
+			}
+			if _, err := r.ReadByte(); err != io.EOF {
+				t.Errorf("read %v, want the connection closed", err)
+			}
+			if n := s.StatsSnapshot().EarlyMessages; n != 1 {
+				t.Errorf("EarlyMessages %d, want 1", n)
+			}
+			if n := handled.Load(); n != 0 {
+				t.Errorf("handler ran %d times", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package server_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+)
+
+// TestDuplicateCacheSeparatesPeers sends requests with the same
+// End-to-End Identifier from two peers, which RFC 6733 allows, and checks
+// that each is handled and answered on its own, while a retransmission
+// from the same peer is answered from the cache.
+func TestDuplicateCacheSeparatesPeers(t *testing.T) {
+	var handled atomic.Int64
+	s, addr := startServer(t, server.WithDuplicateCache(16, time.Minute))
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL,
+		func(w server.ResponseWriter, req *message.DiameterMessage) {
+			handled.Add(1)
+			session, err := message.GetSessionID(req)
+			if err != nil {
+				panic(err)
+			}
+			ans, err := serverNode.BuildAnswer(req, message.DIAMETER_SUCCESS,
+				message.MustNewAVP(message.AVP_SESSION_ID, session, message.MANDATORY_FLAG))
+			if err == nil {
+				err = w.WriteMessage(ans)
+			}
+			if err != nil {
+				panic(err)
+			}
+		})
+
+	const endToEndID = 42
+	for _, host := range []string{"first.example.com", "second.example.com"} {
+		c := connectClient(t, addr, host)
+		session := host + ";1;1"
+		req := newCCR(t, c, session)
+		req.Header.EndToEndID = endToEndID
+		ans := request(t, c, req)
+		if got, _ := message.GetSessionID(ans); got != session {
+			t.Errorf("%s got the answer for session %q, want %q", host, got, session)
+		}
+	}
+	if got := handled.Load(); got != 2 {
+		t.Fatalf("handler called %d times for two peers, want 2", got)
+	}
+
+	c := connectClient(t, addr, "first.example.com")
+	retransmission := newCCR(t, c, "first.example.com;1;1")
+	retransmission.Header.EndToEndID = endToEndID
+	retransmission.Header.CommandFlags = retransmission.Header.CommandFlags.With(message.FlagRetransmitted)
+	request(t, c, retransmission)
+	if got := handled.Load(); got != 2 {
+		t.Errorf("retransmission handled again: %d handler calls", got)
+	}
+	st := s.StatsSnapshot().DuplicateCache
+	if st == nil || st.Hits != 1 || st.Misses != 2 {
+		t.Errorf("duplicate cache stats %+v, want 1 hit and 2 misses", st)
+	}
+}
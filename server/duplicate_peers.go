@@ -0,0 +1,99 @@
+// Policy for a second connection from an identity already connected
+package server
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+// DuplicatePeerPolicy selects what the server does when a peer completes
+// the capabilities exchange with an Origin-Host and Origin-Realm that
+// another connection has already registered. The RFC 6733 election does
+// not apply, since it only arbitrates between a connection each side
+// initiated and the server never initiates connections.
+type DuplicatePeerPolicy int
+
+const (
+	// DuplicatePeerAllow keeps both connections, the newer one being used
+	// for the requests originated with Server.Request. It is the default,
+	// and lets a client replace its connection with client.Rotate.
+	DuplicatePeerAllow DuplicatePeerPolicy = iota
+	// DuplicatePeerRejectNew answers the CER of the new connection with
+	// DIAMETER_UNABLE_TO_COMPLY and closes it.
+	DuplicatePeerRejectNew
+	// DuplicatePeerReplaceOld accepts the new connection and sends a DPR
+	// on the old one, which is closed once the DPA arrives or the request
+	// timeout expires.
+	DuplicatePeerReplaceOld
+)
+
+func (p DuplicatePeerPolicy) String() string {
+	switch p {
+	case DuplicatePeerAllow:
+		return "allow"
+	case DuplicatePeerRejectNew:
+		return "reject-new"
+	case DuplicatePeerReplaceOld:
+		return "replace-old"
+	}
+	return fmt.Sprintf("DuplicatePeerPolicy(%d)", int(p))
+}
+
+// registerPeer records the identity learned from the peer's CER, applying
+// the duplicate peer policy when another connection registered it already.
+// The check and the registration happen under the same lock, so of two
+// connections completing the exchange at once only one can win. It returns
+// false when the CER must be rejected, and the connection to disconnect
+// when p replaces it.
+func (s *Server) registerPeer(p *peer, id message.PeerIdentity, caps message.PeerCapabilities) (replaced *peer, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old := s.peers[id]; old != nil && old != p {
+		switch s.duplicatePeerPolicy {
+		case DuplicatePeerRejectNew:
+			return nil, false
+		case DuplicatePeerReplaceOld:
+			replaced = old
+		}
+	}
+	p.mu.Lock()
+	p.identity = id
+	p.capabilities = caps
+	p.mu.Unlock()
+	s.peers[id] = p
+	return replaced, true
+}
+
+// disconnectReplaced sends a DPR to a connection replaced by a newer one
+// from the same identity and closes it once the DPA arrives or the request
+// timeout expires.
+func (s *Server) disconnectReplaced(p *peer) {
+	s.disconnect(p, message.DISCONNECT_CAUSE_REBOOTING, "replaced by a new connection")
+}
+
+// disconnect sends a DPR with cause to p and closes the connection once
+// the DPA arrives or the request timeout expires. reason is logged.
+func (s *Server) disconnect(p *peer, cause uint32, reason string) {
+	defer p.conn.Close()
+	log.Printf("Sending Disconnect-Peer-Request (DPR) to %s, %s.", p.addr, reason)
+	dpr, err := s.node().BuildDPR(cause, message.WithIDGenerator(s.idGenerator))
+	if err != nil {
+		log.Printf("Error creating DPR: %v", err)
+		return
+	}
+	ch, err := p.pending.Reserve(dpr, s.idGenerator.HopByHopID, s.requestTimeout)
+	if err != nil {
+		log.Printf("Error sending DPR to %s: %v", p.addr, err)
+		return
+	}
+	if err := p.WriteMessage(dpr); err != nil {
+		p.pending.Remove(dpr.Header.HopByHopID)
+		log.Printf("Error sending DPR to %s: %v", p.addr, err)
+		return
+	}
+	if r := <-ch; r.Err != nil {
+		log.Printf("No DPA from %s: %v", p.addr, r.Err)
+	}
+}
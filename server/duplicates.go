@@ -0,0 +1,215 @@
+// Duplicate request detection
+package server
+
+import (
+	"container/list"
+	"encoding/binary"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/clock"
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/stats"
+)
+
+// DuplicateKey identifies a request across retransmissions: RFC 6733
+// section 6.2 makes the End-to-End Identifier unique per Origin-Host. The
+// origin is the normalized identity of the request, so that differences in
+// case do not defeat the cache.
+type DuplicateKey struct {
+	Origin     message.PeerIdentity
+	EndToEndID uint32
+}
+
+// DuplicateStore keeps the encoded answers of recent requests so that
+// retransmitted requests are answered without running the handler again.
+// Implementations must be safe for concurrent use; they may be backed by
+// an external store shared between server instances.
+type DuplicateStore interface {
+	Get(key DuplicateKey) ([]byte, bool)
+	Put(key DuplicateKey, answer []byte)
+}
+
+// lruDuplicateStore is the in-memory DuplicateStore used by
+// WithDuplicateCache. Entries expire after ttl and the least recently
+// used entry is evicted when size is exceeded.
+type lruDuplicateStore struct {
+	mu        sync.Mutex
+	clock     clock.Clock
+	size      int
+	ttl       time.Duration
+	order     *list.List
+	entries   map[DuplicateKey]*list.Element
+	evictions atomic.Uint64
+}
+
+type lruEntry struct {
+	key     DuplicateKey
+	answer  []byte
+	expires time.Time
+}
+
+func newLRUDuplicateStore(clk clock.Clock, size int, ttl time.Duration) *lruDuplicateStore {
+	return &lruDuplicateStore{
+		clock:   clk,
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[DuplicateKey]*list.Element),
+	}
+}
+
+func (c *lruDuplicateStore) Get(key DuplicateKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*lruEntry)
+	if c.ttl > 0 && !c.clock.Now().Before(entry.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return entry.answer, true
+}
+
+func (c *lruDuplicateStore) Put(key DuplicateKey, answer []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := c.clock.Now().Add(c.ttl)
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*lruEntry)
+		entry.answer = answer
+		entry.expires = expires
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, answer: answer, expires: expires})
+	for c.size > 0 && c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove drops el and counts it as evicted. Callers must hold c.mu.
+func (c *lruDuplicateStore) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*lruEntry).key)
+	c.evictions.Add(1)
+}
+
+// Evictions returns the number of entries dropped because of their TTL
+// or the size limit.
+func (c *lruDuplicateStore) Evictions() uint64 {
+	return c.evictions.Load()
+}
+
+// duplicateCache wraps the configured store with hit and miss counters.
+type duplicateCache struct {
+	store  DuplicateStore
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func (d *duplicateCache) stats() *stats.DuplicateCacheStats {
+	if d == nil {
+		return nil
+	}
+	st := &stats.DuplicateCacheStats{
+		Hits:   d.hits.Load(),
+		Misses: d.misses.Load(),
+	}
+	if e, ok := d.store.(interface{ Evictions() uint64 }); ok {
+		st.Evictions = e.Evictions()
+	}
+	return st
+}
+
+// duplicateKey returns the cache key of req.
+func duplicateKey(req *message.DiameterMessage) (DuplicateKey, bool) {
+	id, err := message.OriginIdentity(req)
+	if err != nil {
+		return DuplicateKey{}, false
+	}
+	return DuplicateKey{Origin: id, EndToEndID: req.Header.EndToEndID}, true
+}
+
+// replayDuplicate answers req from the duplicate cache and reports whether
+// it did. The cached answer gets the Hop-by-Hop Identifier of req, which
+// differs between retransmissions.
+func (s *Server) replayDuplicate(p *peer, req *message.DiameterMessage) bool {
+	if s.duplicates == nil {
+		return false
+	}
+	key, ok := duplicateKey(req)
+	if !ok {
+		return false
+	}
+	cached, ok := s.duplicates.store.Get(key)
+	if !ok {
+		s.duplicates.misses.Add(1)
+		return false
+	}
+	s.duplicates.hits.Add(1)
+	if len(cached) < message.DIAMETER_HEADER_SIZE {
+		return false
+	}
+
+	answer := append([]byte(nil), cached...)
+	binary.BigEndian.PutUint32(answer[12:16], req.Header.HopByHopID)
+	log.Printf(
+		"Answering duplicate of command %d from %s (End-to-End Identifier %d) from cache",
+		req.Header.CommandCode,
+		key.Origin,
+		key.EndToEndID,
+	)
+	if err := p.writeEncoded(answer, nil); err != nil {
+		log.Printf("Error sending cached answer to %s: %v", p.addr, err)
+	}
+	return true
+}
+
+// cachingWriter stores the encoded answer of a handler in the duplicate
+// cache before sending it.
+type cachingWriter struct {
+	peer  *peer
+	cache *duplicateCache
+	key   DuplicateKey
+}
+
+func (w *cachingWriter) WriteMessage(msg *message.DiameterMessage) error {
+	encoded, err := msg.Encode()
+	if err != nil {
+		return err
+	}
+	if !msg.IsRequest() {
+		w.checkConsistent(msg)
+		w.cache.store.Put(w.key, encoded)
+	}
+	return w.peer.writeEncoded(encoded, msg)
+}
+
+// checkConsistent logs how answer differs from the answer already cached
+// under the same key, which happens when copies of a request raced past
+// the cache and were handled twice.
+func (w *cachingWriter) checkConsistent(answer *message.DiameterMessage) {
+	cached, ok := w.cache.store.Get(w.key)
+	if !ok {
+		return
+	}
+	previous, err := message.DecodeMessage(cached)
+	if err != nil {
+		return
+	}
+	if diff := message.Diff(previous, answer, message.IgnoreIDs()); diff != "" {
+		log.Printf(
+			"Answer to %s (End-to-End Identifier %d) differs from the cached answer:\n%s",
+			w.key.Origin,
+			w.key.EndToEndID,
+			diff,
+		)
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+func newKeyedRequest(host, realm string, endToEndID uint32) *message.DiameterMessage {
+	return &message.DiameterMessage{
+		Header: &message.DiameterHeader{
+			Version:      message.DIAMETER_VERSION,
+			CommandFlags: message.COMMAND_FLAG_REQUEST,
+			CommandCode:  message.COMMAND_CODE_CREDIT_CONTROL,
+			EndToEndID:   endToEndID,
+		},
+		AVPs: []*message.AVP{
+			message.MustNewAVP(message.AVP_ORIGIN_HOST, host, message.MANDATORY_FLAG),
+			message.MustNewAVP(message.AVP_ORIGIN_REALM, realm, message.MANDATORY_FLAG),
+		},
+	}
+}
+
+func TestDuplicateKeyUsesPeerIdentity(t *testing.T) {
+	a, ok := duplicateKey(newKeyedRequest("Client.Example.COM", "example.com", 7))
+	if !ok {
+		t.Fatal("no key for a request with an origin")
+	}
+	b, _ := duplicateKey(newKeyedRequest("client.example.com.", "EXAMPLE.com", 7))
+	if a != b {
+		t.Errorf("keys differ by the case of the origin: %v, %v", a, b)
+	}
+	if want := message.NewPeerIdentity("client.example.com", "example.com"); a.Origin != want {
+		t.Errorf("Origin = %v, want %v", a.Origin, want)
+	}
+	if c, _ := duplicateKey(newKeyedRequest("other.example.com", "example.com", 7)); c == a {
+		t.Error("requests of different origins share a key")
+	}
+	if _, ok := duplicateKey(&message.DiameterMessage{Header: &message.DiameterHeader{}}); ok {
+		t.Error("key for a request without origin")
+	}
+}
+
+func newTestStore(size int, ttl time.Duration) (*lruDuplicateStore, *fakeclock.Clock) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	return newLRUDuplicateStore(clk, size, ttl), clk
+}
+
+func storeKey(host string, endToEndID uint32) DuplicateKey {
+	return DuplicateKey{Origin: message.NewPeerIdentity(host, "example.com"), EndToEndID: endToEndID}
+}
+
+func TestDuplicateStoreTTL(t *testing.T) {
+	store, clk := newTestStore(0, time.Minute)
+	key := storeKey("a.example.com", 1)
+	store.Put(key, []byte("answer"))
+
+	clk.Advance(time.Minute - time.Second)
+	if got, ok := store.Get(key); !ok || string(got) != "answer" {
+		t.Fatalf("Get before the TTL = %q, %v", got, ok)
+	}
+	clk.Advance(time.Second)
+	if _, ok := store.Get(key); ok {
+		t.Fatal("entry served after its TTL")
+	}
+	if got := store.Evictions(); got != 1 {
+		t.Errorf("Evictions = %d, want 1", got)
+	}
+
+	store.Put(key, []byte("again"))
+	clk.Advance(time.Minute / 2)
+	store.Put(key, []byte("refreshed"))
+	clk.Advance(time.Minute / 2)
+	if got, ok := store.Get(key); !ok || string(got) != "refreshed" {
+		t.Errorf("Get after a refreshing Put = %q, %v", got, ok)
+	}
+}
+
+func TestDuplicateStoreLRU(t *testing.T) {
+	store, _ := newTestStore(2, 0)
+	a, b, c := storeKey("a.example.com", 1), storeKey("b.example.com", 1), storeKey("c.example.com", 1)
+	store.Put(a, []byte("a"))
+	store.Put(b, []byte("b"))
+	// Using a makes b the least recently used entry.
+	store.Get(a)
+	store.Put(c, []byte("c"))
+
+	if _, ok := store.Get(b); ok {
+		t.Error("least recently used entry kept")
+	}
+	for _, key := range []DuplicateKey{a, c} {
+		if _, ok := store.Get(key); !ok {
+			t.Errorf("entry of %v evicted", key.Origin)
+		}
+	}
+	if got := store.Evictions(); got != 1 {
+		t.Errorf("Evictions = %d, want 1", got)
+	}
+}
+
+// TestCachedAnswerConsistency checks that an answer differing from the
+// one cached under the same key, identifiers aside, is logged with the
+// difference.
+func TestCachedAnswerConsistency(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	store, _ := newTestStore(0, time.Minute)
+	w := &cachingWriter{cache: &duplicateCache{store: store}, key: storeKey("client.example.com", 7)}
+	answer := func(code message.ResultCode, hopByHopID uint32) *message.DiameterMessage {
+		req := newKeyedRequest("client.example.com", "example.com", 7)
+		req.Header.HopByHopID = hopByHopID
+		return message.NewAnswer(req, message.MustNewAVP(message.AVP_RESULT_CODE, uint32(code), message.MANDATORY_FLAG))
+	}
+	first := answer(message.DIAMETER_SUCCESS, 1)
+	w.checkConsistent(first)
+	encoded, err := first.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put(w.key, encoded)
+
+	w.checkConsistent(answer(message.DIAMETER_SUCCESS, 2))
+	if logged.Len() != 0 {
+		t.Errorf("same answer with another Hop-by-Hop Identifier logged: %s", logged.String())
+	}
+	w.checkConsistent(answer(message.DIAMETER_UNABLE_TO_COMPLY, 1))
+	if got := logged.String(); !strings.Contains(got, "differs from the cached answer") || !strings.Contains(got, "Result-Code(268): 2001 != 5012") {
+		t.Errorf("differing answer logged as %q", got)
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/IbrahimShahzad/diameter/internal/pending"
+	"github.com/IbrahimShahzad/diameter/transport"
+)
+
+var (
+	ErrUnknownPeer      = errors.New("unknown peer")
+	ErrPeerDisconnected = errors.New("peer disconnected")
+	// ErrAlreadyAnswered is returned to a handler writing an answer to a
+	// request that was answered already, for instance after the handler
+	// timeout expired.
+	ErrAlreadyAnswered = errors.New("request already answered")
+	// ErrHandlerTimeout is the cause reported in the Error-Message of the
+	// answer sent on behalf of a handler that timed out.
+	ErrHandlerTimeout = errors.New("handler timed out")
+	// ErrRequestTimeout is returned by Request when no answer arrived
+	// within the request timeout.
+	ErrRequestTimeout = pending.ErrTimeout
+	// ErrInvalidOptions is wrapped by the errors NewServer returns for
+	// options that are out of range or contradict each other.
+	ErrInvalidOptions = errors.New("invalid server options")
+	// ErrStreamDesync is the read error closing a connection on which a
+	// frame header does not parse, see WithStreamResync.
+	ErrStreamDesync = transport.ErrStreamDesync
+	// ErrInvalidAnswer is returned to a handler writing an answer that
+	// fails validation, see WithOutboundValidation.
+	ErrInvalidAnswer = errors.New("invalid answer")
+	// ErrNotListening is returned by Serve before Listen.
+	ErrNotListening = errors.New("server is not listening")
+	// ErrAlreadyListening is returned by Listen when the server already
+	// has a listener.
+	ErrAlreadyListening = errors.New("server is already listening")
+	// ErrServerClosed is returned by Listen after Shutdown.
+	ErrServerClosed = errors.New("server closed")
+)
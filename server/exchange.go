@@ -0,0 +1,240 @@
+// Per-peer handler concurrency, handler timeouts and answer ordering
+package server
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/clock"
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+// exchange tracks a request handed to a handler until it is answered, by
+// the handler or on its behalf when the handler timeout expires. It is the
+// ResponseWriter of the handler.
+type exchange struct {
+	p     *peer
+	req   *message.DiameterMessage
+	start time.Time
+	next  ResponseWriter
+	timer clock.Timer
+	// answered is closed once the exchange is complete.
+	answered chan struct{}
+	// sent receives the result of writing the answer when answers are
+	// ordered.
+	sent chan error
+	// released makes release free the handler slot only once.
+	released sync.Once
+
+	mu     sync.Mutex
+	done   bool
+	answer *message.DiameterMessage
+	w      ResponseWriter
+}
+
+// tracksExchanges reports whether handlers run through exchanges, which
+// only costs anything when one of the options needing them is set.
+func (s *Server) tracksExchanges() bool {
+	return s.peerConcurrency > 0 || s.orderedAnswers || s.handlerTimeout > 0
+}
+
+// effectiveHandlerTimeout returns the handler timeout, which defaults to
+// the request timeout when answers are ordered so that a stuck handler
+// cannot hold back the answers behind it forever.
+func (s *Server) effectiveHandlerTimeout() time.Duration {
+	if s.handlerTimeout == 0 && s.orderedAnswers {
+		return s.requestTimeout
+	}
+	return s.handlerTimeout
+}
+
+// newExchange starts tracking req. It is called from the read loop, so
+// exchanges take their place in the answer order as requests arrive.
+func (s *Server) newExchange(p *peer, req *message.DiameterMessage) *exchange {
+	e := &exchange{p: p, req: req, start: s.clock.Now(), answered: make(chan struct{})}
+	if s.orderedAnswers {
+		e.sent = make(chan error, 1)
+		p.orderMu.Lock()
+		p.order = append(p.order, e)
+		p.orderMu.Unlock()
+	}
+	if timeout := s.effectiveHandlerTimeout(); timeout > 0 {
+		e.mu.Lock()
+		e.timer = s.clock.AfterFunc(timeout, func() { s.expireExchange(e) })
+		e.mu.Unlock()
+	}
+	return e
+}
+
+// acquire waits for a handler slot of the peer. It returns false if the
+// exchange was answered while waiting, in which case the handler must not
+// run. Otherwise the caller must call release once the handler returns,
+// even if the request was answered on its behalf meanwhile: the slot
+// counts handlers running, not requests unanswered.
+func (e *exchange) acquire() bool {
+	if e.p.slots != nil {
+		select {
+		case e.p.slots <- struct{}{}:
+		case <-e.answered:
+			return false
+		}
+	}
+	e.mu.Lock()
+	done := e.done
+	e.mu.Unlock()
+	if done {
+		e.release()
+		return false
+	}
+	return true
+}
+
+// release frees the handler slot taken by acquire. Calls after the first
+// do nothing.
+func (e *exchange) release() {
+	e.released.Do(func() {
+		if e.p.slots != nil {
+			<-e.p.slots
+		}
+	})
+}
+
+// WriteMessage sends the answer of the handler. When answers are ordered
+// it waits until the answers to the earlier requests have been sent, and
+// gives up the handler slot meanwhile, as the handlers of those requests
+// may be waiting for it. An answer written after the request was answered
+// for the handler is discarded.
+func (e *exchange) WriteMessage(msg *message.DiameterMessage) error {
+	err := e.complete(msg, e.next)
+	if err == nil && e.sent != nil {
+		e.release()
+		return <-e.sent
+	}
+	if errors.Is(err, ErrAlreadyAnswered) {
+		s := e.p.server
+		s.lateAnswers.Add(1)
+		log.Printf(
+			"Discarding late answer to %s from %s after %v",
+			e.req.CommandName(),
+			e.p.addr,
+			s.clock.Now().Sub(e.start),
+		)
+	}
+	return err
+}
+
+// finish completes the exchange when the handler returns without
+// answering.
+func (e *exchange) finish() {
+	e.complete(nil, nil)
+}
+
+// complete records msg, which may be nil, as the answer to be written with
+// w. When answers are ordered it is sent once the earlier answers are,
+// whether the handler is still running or not, and the result of the write
+// is delivered on e.sent.
+func (e *exchange) complete(msg *message.DiameterMessage, w ResponseWriter) error {
+	e.mu.Lock()
+	if e.done {
+		e.mu.Unlock()
+		return ErrAlreadyAnswered
+	}
+	e.done = true
+	e.answer, e.w = msg, w
+	timer := e.timer
+	e.mu.Unlock()
+	close(e.answered)
+	if timer != nil {
+		timer.Stop()
+	}
+
+	if !e.p.server.orderedAnswers {
+		if msg == nil {
+			return nil
+		}
+		return w.WriteMessage(msg)
+	}
+	e.p.flushAnswers()
+	return nil
+}
+
+// result returns the answer of a complete exchange.
+func (e *exchange) result() (msg *message.DiameterMessage, w ResponseWriter, done bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.answer, e.w, e.done
+}
+
+// expireExchange answers the request of e with the handler timeout result
+// on behalf of a handler that did not answer within the handler timeout.
+func (s *Server) expireExchange(e *exchange) {
+	var ans *message.DiameterMessage
+	if s.autoErrorAnswers {
+		var err error
+		ans, err = s.ErrorAnswer(e.req, &message.ProtocolError{ResultCode: s.handlerTimeoutResult, Err: ErrHandlerTimeout})
+		if err != nil {
+			log.Printf("Error creating %s answer: %v", s.handlerTimeoutResult, err)
+		}
+	}
+	if err := e.complete(ans, e.p); err != nil {
+		return
+	}
+	s.handlerTimeouts.Add(1)
+	log.Printf(
+		"Handler for %s from %s did not answer within %v",
+		e.req.CommandName(),
+		e.p.addr,
+		s.clock.Now().Sub(e.start),
+	)
+}
+
+// flushAnswers sends the answers at the head of the order that are
+// complete, stopping at the first request still being handled. The
+// answers are written without holding orderMu, so that a slow write does
+// not block the exchanges completing meanwhile; one caller at a time
+// writes, taking over the answers completed by the others, so that the
+// order is kept.
+func (p *peer) flushAnswers() {
+	p.orderMu.Lock()
+	if p.flushing {
+		p.orderMu.Unlock()
+		return
+	}
+	p.flushing = true
+	for {
+		ready := p.readyAnswers()
+		if len(ready) == 0 {
+			p.flushing = false
+			p.orderMu.Unlock()
+			return
+		}
+		p.orderMu.Unlock()
+		for _, e := range ready {
+			var err error
+			if msg, w, _ := e.result(); msg != nil {
+				if err = w.WriteMessage(msg); err != nil {
+					log.Printf("Error sending %s to %s: %v", msg.CommandName(), p.addr, err)
+				}
+			}
+			e.sent <- err
+		}
+		p.orderMu.Lock()
+	}
+}
+
+// readyAnswers removes the complete exchanges at the head of the order and
+// returns them. orderMu must be held.
+func (p *peer) readyAnswers() []*exchange {
+	var ready []*exchange
+	for len(p.order) > 0 {
+		if _, _, done := p.order[0].result(); !done {
+			break
+		}
+		ready = append(ready, p.order[0])
+		p.order[0] = nil
+		p.order = p.order[1:]
+	}
+	return ready
+}
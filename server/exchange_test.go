@@ -0,0 +1,355 @@
+package server_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+)
+
+// gatedHandler answers each CCR once the gate of the
+// session, if any, is closed. It reports on started, answering and
+// returned when a handler starts, writes its answer and returns, with the
+// error of the write on written, and counts the handlers running at once.
+type gatedHandler struct {
+	gates     map[string]chan struct{}
+	started   chan string
+	answering chan string
+	returned  chan string
+	written   chan error
+	running   atomic.Int32
+	peak      atomic.Int32
+}
+
+func newGatedHandler(gated ...string) *gatedHandler {
+	h := &gatedHandler{
+		gates:     make(map[string]chan struct{}),
+		started:   make(chan string, 16),
+		answering: make(chan string, 16),
+		returned:  make(chan string, 16),
+		written:   make(chan error, 16),
+	}
+	for _, session := range gated {
+		h.gates[session] = make(chan struct{})
+	}
+	return h
+}
+
+func (h *gatedHandler) ServeDiameter(w server.ResponseWriter, req *message.DiameterMessage) {
+	n := h.running.Add(1)
+	for peak := h.peak.Load(); n > peak && !h.peak.CompareAndSwap(peak, n); peak = h.peak.Load() {
+	}
+	defer h.running.Add(-1)
+	session, _ := message.GetSessionID(req)
+	h.started <- session
+	if gate, ok := h.gates[session]; ok {
+		<-gate
+	}
+	ans, err := serverNode.BuildAnswer(req, message.DIAMETER_SUCCESS)
+	if err == nil {
+		h.answering <- session
+		h.written <- w.WriteMessage(ans)
+	}
+	h.returned <- session
+}
+
+// receive waits for the next session on ch.
+func receive(t *testing.T, what string, ch <-chan string) string {
+	t.Helper()
+	select {
+	case session := <-ch:
+		return session
+	case <-time.After(testTimeout):
+		t.Fatalf("timed out waiting for %s", what)
+		return ""
+	}
+}
+
+// sendCCR writes a CCR for session on conn.
+func sendCCR(t *testing.T, conn net.Conn, session string, id uint32) {
+	t.Helper()
+	ccr, err := message.NewRequest(message.COMMAND_CODE_CREDIT_CONTROL, message.WithAVPs(
+		message.MustNewAVP(message.AVP_SESSION_ID, session, message.MANDATORY_FLAG),
+		message.MustNewAVP(message.AVP_ORIGIN_HOST, clientNode.OriginHost, message.MANDATORY_FLAG),
+		message.MustNewAVP(message.AVP_ORIGIN_REALM, clientNode.OriginRealm, message.MANDATORY_FLAG),
+		message.MustNewAVP(message.AVP_DESTINATION_REALM, serverNode.OriginRealm, message.MANDATORY_FLAG),
+		message.MustNewAVP(message.AVP_AUTH_APPLICATION_ID, message.APPLICATION_ID_CREDIT_CONTROL, message.MANDATORY_FLAG),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ccr.Header.HopByHopID, ccr.Header.EndToEndID = id, id
+	frame, err := ccr.Encode()
+	if err == nil {
+		_, err = conn.Write(frame)
+	}
+	if err != nil {
+		t.Fatalf("sending CCR: %v", err)
+	}
+}
+
+// answers reads n answers from r and returns their Hop-by-Hop
+// Identifiers and result codes in the order they arrived.
+func answers(t *testing.T, conn net.Conn, r *bufio.Reader, n int) ([]uint32, []message.ResultCode) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(testTimeout))
+	var ids []uint32
+	var codes []message.ResultCode
+	for range n {
+		ans, err := message.DecodeMessage(readRawFrame(t, r, nil))
+		if err != nil {
+			t.Fatalf("decoding answer: %v", err)
+		}
+		code, _, _ := message.GetResultCode(ans)
+		ids = append(ids, ans.Header.HopByHopID)
+		codes = append(codes, code)
+	}
+	return ids, codes
+}
+
+// TestAnswerOrder has the handler of the first of two requests complete
+// after the second, and checks the order of the answers on the wire.
+func TestAnswerOrder(t *testing.T) {
+	const slow, fast = 1, 2
+	for _, tc := range []struct {
+		ordered bool
+		want    []uint32
+	}{
+		{false, []uint32{fast, slow}},
+		{true, []uint32{slow, fast}},
+	} {
+		h := newGatedHandler("slow")
+		s, addr := startServer(t, server.WithOrderedAnswers(tc.ordered))
+		s.Handle(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, h)
+		conn := dialRaw(t, addr)
+
+		sendCCR(t, conn, "slow", slow)
+		receive(t, "the slow handler", h.started)
+		sendCCR(t, conn, "fast", fast)
+		if got := receive(t, "the fast handler", h.answering); got != "fast" {
+			t.Fatalf("handler for %s answered first", got)
+		}
+		close(h.gates["slow"])
+		got, _ := answers(t, conn, bufio.NewReader(conn), 2)
+		if got[0] != tc.want[0] || got[1] != tc.want[1] {
+			t.Errorf("ordered %v: answers for %v, want %v", tc.ordered, got, tc.want)
+		}
+	}
+}
+
+// TestOrderedAnswerTimeout checks that a handler blocking past the handler
+// timeout does not hold back the answers behind it: its request is
+// answered with DIAMETER_TOO_BUSY in its place in the order.
+func TestOrderedAnswerTimeout(t *testing.T) {
+	h := newGatedHandler("stuck")
+	s, addr := startServer(t, server.WithOrderedAnswers(true), server.WithHandlerTimeout(100*time.Millisecond))
+	s.Handle(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, h)
+	conn := dialRaw(t, addr)
+	defer close(h.gates["stuck"])
+
+	sendCCR(t, conn, "stuck", 1)
+	sendCCR(t, conn, "fast", 2)
+	ids, codes := answers(t, conn, bufio.NewReader(conn), 2)
+	if ids[0] != 1 || codes[0] != message.DIAMETER_TOO_BUSY {
+		t.Errorf("first answer to request %d with %v, want the stuck request 1 with DIAMETER_TOO_BUSY", ids[0], codes[0])
+	}
+	if ids[1] != 2 || codes[1] != message.DIAMETER_SUCCESS {
+		t.Errorf("second answer to request %d with %v, want the fast request 2 with DIAMETER_SUCCESS", ids[1], codes[1])
+	}
+	if got := s.StatsSnapshot().HandlerTimeouts; got != 1 {
+		t.Errorf("HandlerTimeouts = %d, want 1", got)
+	}
+}
+
+// TestPeerConcurrencyTimedOutHandler checks that a handler answered for
+// on timeout keeps its slot until it returns, so that no more than the
+// limit of handlers run at once.
+func TestPeerConcurrencyTimedOutHandler(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	h := newGatedHandler("stuck")
+	s, addr := startServer(t, server.WithClock(clk), server.WithPeerConcurrency(1), server.WithHandlerTimeout(timeout))
+	s.Handle(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, h)
+	conn := dialRaw(t, addr)
+	r := bufio.NewReader(conn)
+	var release sync.Once
+	defer release.Do(func() { close(h.gates["stuck"]) })
+
+	sendCCR(t, conn, "stuck", 1)
+	receive(t, "the stuck handler", h.started)
+	// The second request arrives half a timeout later, so that its own
+	// timeout has not expired when the first one is answered for.
+	clk.Advance(timeout / 2)
+	timers := clk.Pending()
+	sendCCR(t, conn, "waiting", 2)
+	eventually(t, "the second request to arrive", func() bool { return clk.Pending() > timers })
+
+	clk.Advance(timeout / 2)
+	if ids, codes := answers(t, conn, r, 1); ids[0] != 1 || codes[0] != message.DIAMETER_TOO_BUSY {
+		t.Fatalf("answer to request %d with %v, want the stuck request 1 with DIAMETER_TOO_BUSY", ids[0], codes[0])
+	}
+	select {
+	case session := <-h.started:
+		t.Fatalf("handler for %q started while the timed out handler was running", session)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release.Do(func() { close(h.gates["stuck"]) })
+	if got := receive(t, "the waiting handler", h.started); got != "waiting" {
+		t.Fatalf("handler started for %q", got)
+	}
+	if ids, codes := answers(t, conn, r, 1); ids[0] != 2 || codes[0] != message.DIAMETER_SUCCESS {
+		t.Errorf("answer to request %d with %v, want the waiting request 2 with DIAMETER_SUCCESS", ids[0], codes[0])
+	}
+	if peak := h.peak.Load(); peak != 1 {
+		t.Errorf("%d handlers ran at once, limit 1", peak)
+	}
+	eventually(t, "the late answer to be counted", func() bool { return s.StatsSnapshot().LateAnswers == 1 })
+}
+
+// TestOrderedAnswerWriteError checks that a handler whose ordered answer
+// cannot be sent gets the error of the write from WriteMessage.
+func TestOrderedAnswerWriteError(t *testing.T) {
+	h := newGatedHandler("closed")
+	s, addr := startServer(t, server.WithOrderedAnswers(true), server.WithLinger(0))
+	s.Handle(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, h)
+	conn := dialRaw(t, addr)
+
+	sendCCR(t, conn, "closed", 1)
+	receive(t, "the handler", h.started)
+	conn.Close()
+	eventually(t, "the peer to go", func() bool { return len(s.Peers()) == 0 })
+	close(h.gates["closed"])
+	select {
+	case err := <-h.written:
+		if err == nil {
+			t.Error("WriteMessage succeeded on a closed connection")
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the answer to be written")
+	}
+}
+
+// TestHandlerTimeoutAnswer has a handler answer after the handler timeout
+// and checks that only the answer sent in its place reaches the peer, and
+// that the late answer is discarded and counted.
+func TestHandlerTimeoutAnswer(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	for _, tc := range []struct {
+		name string
+		opts []server.ServerOptionsFunc
+		want message.ResultCode
+		// protocolError is whether the answer has the E bit set.
+		protocolError bool
+	}{
+		{"default", nil, message.DIAMETER_TOO_BUSY, true},
+		{"configured", []server.ServerOptionsFunc{server.WithHandlerTimeoutResult(message.DIAMETER_UNABLE_TO_COMPLY)}, message.DIAMETER_UNABLE_TO_COMPLY, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+			h := newGatedHandler("late")
+			s, addr := startServer(t, append(tc.opts, server.WithClock(clk), server.WithHandlerTimeout(timeout))...)
+			s.Handle(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, h)
+			conn := dialRaw(t, addr)
+			r := bufio.NewReader(conn)
+
+			sendCCR(t, conn, "late", 1)
+			receive(t, "the late handler", h.started)
+			clk.Advance(timeout)
+			ans := readMessage(t, conn, r)
+			result, err := message.GetResult(ans)
+			if err != nil || ans.Header.HopByHopID != 1 || result.Code != tc.want {
+				t.Fatalf("answer to request %d with %+v, %v; want request 1 with %v", ans.Header.HopByHopID, result, err, tc.want)
+			}
+			if ans.Header.CommandFlags.Error() != tc.protocolError {
+				t.Errorf("answer flags %v, want the E bit set %v", ans.Header.CommandFlags, tc.protocolError)
+			}
+			if !strings.Contains(result.ErrorMessage, server.ErrHandlerTimeout.Error()) {
+				t.Errorf("Error-Message %q does not report %q", result.ErrorMessage, server.ErrHandlerTimeout)
+			}
+
+			close(h.gates["late"])
+			select {
+			case err := <-h.written:
+				if !errors.Is(err, server.ErrAlreadyAnswered) {
+					t.Errorf("late WriteMessage = %v, want ErrAlreadyAnswered", err)
+				}
+			case <-time.After(testTimeout):
+				t.Fatal("timed out waiting for the late answer")
+			}
+			// The late answer would arrive before the answer to a request
+			// sent after it was discarded.
+			sendCCR(t, conn, "next", 2)
+			if ids, codes := answers(t, conn, r, 1); ids[0] != 2 || codes[0] != message.DIAMETER_SUCCESS {
+				t.Errorf("next answer to request %d with %v, want request 2 with DIAMETER_SUCCESS", ids[0], codes[0])
+			}
+			eventually(t, "the timeout to be counted", func() bool { return s.StatsSnapshot().HandlerTimeouts == 1 })
+			if got := s.StatsSnapshot().LateAnswers; got != 1 {
+				t.Errorf("LateAnswers = %d, want 1", got)
+			}
+		})
+	}
+}
+
+// TestHalfClosedPeer has the peer shut down its side of the connection
+// after a request and checks that the answer still reaches it within the
+// linger time, and that the server closes the connection after it.
+func TestHalfClosedPeer(t *testing.T) {
+	const linger = time.Second
+	for _, tc := range []struct {
+		name string
+		// advance is how long the handler takes, on the fake clock.
+		advance time.Duration
+		// answered tells whether the answer is sent before the close.
+		answered bool
+	}{
+		{"within linger", linger / 2, true},
+		{"past linger", linger, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+			h := newGatedHandler("half-closed")
+			s, addr := startServer(t, server.WithClock(clk), server.WithLinger(linger))
+			s.Handle(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, h)
+			conn := dialRaw(t, addr)
+			r := bufio.NewReader(conn)
+
+			sendCCR(t, conn, "half-closed", 1)
+			receive(t, "the handler", h.started)
+			timers := clk.Pending()
+			if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+				t.Fatal(err)
+			}
+			eventually(t, "the linger timer", func() bool { return clk.Pending() > timers })
+			clk.Advance(tc.advance)
+			closed := func() {
+				t.Helper()
+				conn.SetReadDeadline(time.Now().Add(testTimeout))
+				if _, err := r.ReadByte(); !errors.Is(err, io.EOF) {
+					t.Errorf("read %v, want the connection closed", err)
+				}
+			}
+			if tc.answered {
+				close(h.gates["half-closed"])
+				if ids, codes := answers(t, conn, r, 1); ids[0] != 1 || codes[0] != message.DIAMETER_SUCCESS {
+					t.Errorf("answer to request %d with %v, want request 1 with DIAMETER_SUCCESS", ids[0], codes[0])
+				}
+				closed()
+			} else {
+				// The handler only returns once the connection is gone.
+				closed()
+				close(h.gates["half-closed"])
+			}
+			eventually(t, "the peer to go", func() bool { return len(s.Peers()) == 0 })
+		})
+	}
+}
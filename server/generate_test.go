@@ -0,0 +1,106 @@
+package server_test
+
+import (
+	"bufio"
+	"math/rand/v2"
+	"sync/atomic"
+	"testing"
+
+	"github.com/IbrahimShahzad/diameter/diametertest"
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+)
+
+// withClientOrigin replaces the random Origin-Host and Origin-Realm of a
+// generated request with those of clientNode, which the server checks
+// against the capabilities exchange. Oversized values are left alone.
+func withClientOrigin(t *testing.T, msg *message.DiameterMessage) {
+	t.Helper()
+	for i, avp := range msg.AVPs {
+		var value string
+		switch avp.Code {
+		case message.AVP_ORIGIN_HOST:
+			value = clientNode.OriginHost
+		case message.AVP_ORIGIN_REALM:
+			value = clientNode.OriginRealm
+		default:
+			continue
+		}
+		if _, ok := avp.Data.(*message.DiameterIdentity); !ok {
+			continue
+		}
+		replaced, err := message.NewAVP(avp.Code, value, avp.Flags)
+		if err != nil {
+			t.Fatalf("replacing AVP %d: %v", avp.Code, err)
+		}
+		msg.AVPs[i] = replaced
+	}
+}
+
+// TestDispatchGeneratedRequests checks that the server answers every
+// request generated from the dictionary, rule violations included, once:
+// with the answer of the handler, or with an automatic error answer, which
+// values of the wrong type always get.
+func TestDispatchGeneratedRequests(t *testing.T) {
+	var handled atomic.Int64
+	s, addr := startServer(t)
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL,
+		func(w server.ResponseWriter, req *message.DiameterMessage) {
+			handled.Add(1)
+			answerSuccess(w, req)
+		})
+	conn := dialRaw(t, addr)
+	r := bufio.NewReader(conn)
+
+	const requests = 300
+	var answered, failed int
+	violations := map[diametertest.Violation]int{}
+	for i := range uint32(requests) {
+		rng := rand.New(rand.NewPCG(uint64(i), 2380))
+		req, violation, err := diametertest.Generate(rng, message.APPLICATION_ID_CREDIT_CONTROL,
+			message.COMMAND_CODE_CREDIT_CONTROL, diametertest.WithViolations())
+		if err != nil {
+			t.Fatalf("request %d: generating: %v", i, err)
+		}
+		violations[violation]++
+		withClientOrigin(t, req)
+		req.Header.HopByHopID = i
+		req.Header.EndToEndID = i
+		frame, err := req.Encode()
+		if err != nil {
+			t.Fatalf("request %d (%v): encoding: %v", i, violation, err)
+		}
+		if _, err := conn.Write(frame); err != nil {
+			t.Fatalf("request %d (%v): writing: %v", i, violation, err)
+		}
+
+		ans, err := message.DecodeMessage(readRawFrame(t, r, nil))
+		if err != nil {
+			t.Fatalf("request %d (%v): decoding the answer: %v", i, violation, err)
+		}
+		if ans.Header.CommandFlags.Request() || ans.Header.HopByHopID != i || ans.Header.EndToEndID != i {
+			t.Fatalf("request %d (%v): got %s with identifiers %d/%d", i, violation,
+				ans.CommandName(), ans.Header.HopByHopID, ans.Header.EndToEndID)
+		}
+		code, _, err := message.GetResultCode(ans)
+		if err != nil {
+			t.Fatalf("request %d (%v): answer without Result-Code: %v", i, violation, err)
+		}
+		if code == message.DIAMETER_SUCCESS {
+			answered++
+		} else {
+			failed++
+		}
+		if violation == diametertest.WrongType && code != message.DIAMETER_INVALID_AVP_LENGTH {
+			t.Errorf("request %d: value of the wrong type answered with %v", i, code)
+		}
+	}
+
+	if got := handled.Load(); got != int64(answered) {
+		t.Errorf("%d handler calls for %d successful answers", got, answered)
+	}
+	if answered == 0 || failed == 0 {
+		t.Errorf("%d handler answers and %d error answers, want some of both", answered, failed)
+	}
+	t.Logf("%d handler answers, %d error answers, violations %v", answered, failed, violations)
+}
@@ -1,2 +1,513 @@
 // Request/response handling and connection managemen
 package server
+
+import (
+	"errors"
+	"log"
+	"net"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/stats"
+	"github.com/IbrahimShahzad/diameter/transport"
+)
+
+// ResponseWriter is used by a Handler to send its answer back to the peer
+// the request came from.
+type ResponseWriter interface {
+	WriteMessage(msg *message.DiameterMessage) error
+}
+
+// Handler answers Diameter requests for one application command.
+type Handler interface {
+	ServeDiameter(w ResponseWriter, req *message.DiameterMessage)
+}
+
+// HandlerFunc adapts an ordinary function to the Handler interface.
+type HandlerFunc func(w ResponseWriter, req *message.DiameterMessage)
+
+func (f HandlerFunc) ServeDiameter(w ResponseWriter, req *message.DiameterMessage) {
+	f(w, req)
+}
+
+type handlerKey struct {
+	applicationID uint32
+	commandCode   uint32
+}
+
+// Handle registers h for requests with the given application and command.
+func (s *Server) Handle(applicationID, commandCode uint32, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[handlerKey{applicationID, commandCode}] = h
+}
+
+// HandleFunc registers f for requests with the given application and
+// command.
+func (s *Server) HandleFunc(
+	applicationID, commandCode uint32,
+	f func(w ResponseWriter, req *message.DiameterMessage),
+) {
+	s.Handle(applicationID, commandCode, HandlerFunc(f))
+}
+
+func (s *Server) handler(applicationID, commandCode uint32) (Handler, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.handlers[handlerKey{applicationID, commandCode}]
+	return h, ok
+}
+
+// OnInboundAnswer registers fn to observe every answer received from a
+// peer to a request originated by the server, including answers matching
+// no outstanding request. fn is called with its own clone of the answer
+// once it has been delivered to the waiting Request, so it cannot change
+// what the caller sees. Observers run on the read loop of the peer, in the
+// order they were registered, and must not block; a panicking observer is
+// logged and counted in the ObserverPanics of StatsSnapshot.
+func (s *Server) OnInboundAnswer(fn func(msg *message.DiameterMessage)) {
+	s.inboundAnswers.Add(fn)
+}
+
+// handleMessage processes one message read from p.
+func (s *Server) handleMessage(p *peer, msg *message.DiameterMessage) {
+	if !p.exchanged() && !(msg.IsRequest() && msg.Header.CommandCode == message.COMMAND_CODE_CER) {
+		s.rejectEarly(p, msg)
+		return
+	}
+
+	if !msg.IsRequest() {
+		// The answer is copied before it is delivered, as the caller
+		// of Request owns it from then on.
+		var observed *message.DiameterMessage
+		if s.inboundAnswers.Len() > 0 {
+			observed = msg.Clone()
+		}
+		if !p.pending.Deliver(msg) {
+			s.orphanedAnswers.Add(1)
+			s.errorLog.Printf(p.host, "orphaned answer",
+				"Received unsolicited answer %d with Hop-by-Hop Identifier %d from %s",
+				msg.Header.CommandCode,
+				msg.Header.HopByHopID,
+				p.addr,
+			)
+			if fn := s.orphanAnswerHandler; fn != nil {
+				name, orphan := p.name(), msg.Clone()
+				s.spawn(func() { fn(name, orphan) })
+			}
+		}
+		if observed != nil {
+			s.inboundAnswers.Notify(observed)
+		}
+		return
+	}
+
+	if err := msg.Header.CommandFlags.Validate(); err != nil {
+		s.errorLog.Printf(p.host, "command flags",
+			"Invalid command flags %s in command %d from %s",
+			msg.Header.CommandFlags,
+			msg.Header.CommandCode,
+			p.addr,
+		)
+		s.checkViolation(p, "invalid command flags "+msg.Header.CommandFlags.String())
+		s.answerUnsupported(p, msg, message.ResultCodeForError(err), "invalid command flags "+msg.Header.CommandFlags.String())
+		return
+	}
+
+	if errs := msg.DecodeErrors(); len(errs) > 0 {
+		s.errorLog.Printf(p.host, "invalid AVP", "Invalid AVP in %s from %s: %v", msg.CommandName(), p.addr, errs[0])
+		s.checkViolation(p, errs[0].Error())
+		s.answerInvalidAVP(p, msg)
+		return
+	}
+
+	// Reserved AVP flag bits are to be ignored by receivers, so they only
+	// count against the peer.
+	if s.violationThreshold > 0 && reservedAVPFlags(msg.AVPs) {
+		s.checkViolation(p, "reserved AVP flags set in "+msg.CommandName())
+	}
+
+	switch msg.Header.CommandCode {
+	case message.COMMAND_CODE_CER:
+		s.answerCER(p, msg)
+	case message.COMMAND_CODE_DWR:
+		s.answerDWR(p, msg)
+	case message.COMMAND_CODE_DISCONNECT_PEER:
+		s.answerDPR(p, msg)
+	default:
+		if !p.supports(msg) {
+			s.errorLog.Printf(p.host, "application unsupported",
+				"Application %d was not negotiated with %s, rejecting command %d",
+				msg.Header.ApplicationID,
+				p.addr,
+				msg.Header.CommandCode,
+			)
+			s.answerUnsupported(p, msg, message.DIAMETER_APPLICATION_UNSUPPORTED, "application not negotiated")
+			return
+		}
+		h, ok := s.handler(msg.Header.ApplicationID, msg.Header.CommandCode)
+		if !ok {
+			s.errorLog.Printf(p.host, "command unsupported",
+				"No handler for command %d application %d from %s",
+				msg.Header.CommandCode,
+				msg.Header.ApplicationID,
+				p.addr,
+			)
+			s.answerUnsupported(p, msg, message.DIAMETER_COMMAND_UNSUPPORTED, "no handler for command")
+			return
+		}
+		if s.replayDuplicate(p, msg) || !s.admitSession(p, msg) {
+			return
+		}
+		var e *exchange
+		if s.tracksExchanges() {
+			e = s.newExchange(p, msg)
+		}
+		p.handlers.Add(1)
+		s.spawn(func() { s.dispatch(p, h, msg, e) })
+	}
+}
+
+// rejectEarly handles a message other than a CER received before the
+// capabilities exchange completed. RFC 6733 section 5.3 makes the CER the
+// first message of a connection, so the connection is closed, after
+// answering a request with DIAMETER_UNKNOWN_PEER unless automatic error
+// answers are disabled.
+func (s *Server) rejectEarly(p *peer, msg *message.DiameterMessage) {
+	s.earlyMessages.Add(1)
+	s.errorLog.Printf(p.host, "early message", "Received %s from %s before the capabilities exchange, closing the connection.", msg.CommandName(), p.addr)
+	s.violation(p, msg.CommandName()+" before the capabilities exchange")
+	if msg.IsRequest() {
+		s.answerUnsupported(p, msg, message.DIAMETER_UNKNOWN_PEER, "capabilities exchange not completed")
+	}
+	p.conn.Close()
+}
+
+// dispatch runs h for req, recording its latency. Unless zero-copy requests
+// are enabled the handler receives a clone, so req stays a stable snapshot
+// for the slow-request log whatever the handler does with its copy. e, when
+// not nil, is the exchange the answer of the handler goes through.
+func (s *Server) dispatch(p *peer, h Handler, req *message.DiameterMessage, e *exchange) {
+	defer p.handlers.Done()
+	handlerReq := req
+	if !s.zeroCopyRequests {
+		handlerReq = req.Clone()
+	}
+	var w ResponseWriter = p
+	if s.duplicates != nil {
+		if key, ok := duplicateKey(req); ok {
+			w = &cachingWriter{peer: p, cache: s.duplicates, key: key}
+		}
+	}
+	if e != nil {
+		if !e.acquire() {
+			return
+		}
+		defer e.release()
+		defer e.finish()
+		e.next = w
+		w = e
+	}
+	if s.outboundValidation != message.ValidationOff {
+		w = &validatingWriter{peer: p, next: w}
+	}
+	start := s.clock.Now()
+	h.ServeDiameter(w, handlerReq)
+	elapsed := s.clock.Now().Sub(start)
+
+	s.commands.Observe(stats.CommandKey{
+		ApplicationID: req.Header.ApplicationID,
+		CommandCode:   req.Header.CommandCode,
+	}, elapsed)
+	if s.slowRequestThreshold > 0 && elapsed > s.slowRequestThreshold {
+		s.logSlowRequest(p, req, elapsed)
+	}
+}
+
+// node returns the identity the server advertises.
+func (s *Server) node() message.Node {
+	return message.Node{
+		OriginHost:       s.originHost,
+		OriginRealm:      s.originRealm,
+		VendorID:         s.vendorID,
+		ProductName:      s.productName,
+		OriginStateID:    s.originStateID,
+		FirmwareRevision: s.firmwareRevision,
+	}
+}
+
+// loadAVPs returns the Load AVP reported in every answer, or nothing when
+// no load reporter is configured.
+func (s *Server) loadAVPs() ([]*message.AVP, error) {
+	if s.loadReporter == nil {
+		return nil, nil
+	}
+	load, err := message.NewLoadAVP(message.Load{Type: message.LOAD_TYPE_HOST, Value: s.loadReporter()})
+	if err != nil {
+		return nil, err
+	}
+	return []*message.AVP{load}, nil
+}
+
+// newAnswer creates an answer to req carrying resultCode, the server
+// identity and any extra AVPs.
+func (s *Server) newAnswer(req *message.DiameterMessage, resultCode message.ResultCode, extra ...*message.AVP) (*message.DiameterMessage, error) {
+	load, err := s.loadAVPs()
+	if err != nil {
+		return nil, err
+	}
+	return s.node().BuildAnswer(req, resultCode, append(extra, load...)...)
+}
+
+// ErrorAnswer builds the answer reporting err to req, with the Result-Code
+// chosen by message.ResultCodeForError and err as Error-Message. Protocol
+// errors get the 'E' bit. Handlers use it to turn their errors into
+// answers.
+func (s *Server) ErrorAnswer(req *message.DiameterMessage, err error) (*message.DiameterMessage, error) {
+	resultCode := message.ResultCodeForError(err)
+	var extra []*message.AVP
+	if err != nil {
+		var avpErr error
+		if extra, avpErr = errorMessageAVPs(err.Error()); avpErr != nil {
+			return nil, avpErr
+		}
+	}
+	return s.newAnswer(req, resultCode, extra...)
+}
+
+// answer sends an answer to req carrying resultCode, the server identity
+// and any extra AVPs.
+func (s *Server) answer(p *peer, req *message.DiameterMessage, resultCode message.ResultCode, extra ...*message.AVP) error {
+	ans, err := s.newAnswer(req, resultCode, extra...)
+	if err != nil {
+		return err
+	}
+	return p.WriteMessage(ans)
+}
+
+// answerUnsupported sends a protocol error answer with the 'E' bit set for
+// a request the server cannot serve or accept, unless automatic error answers are
+// disabled in which case the request is dropped. errorMessage, when not
+// empty, is sent as Error-Message.
+func (s *Server) answerUnsupported(p *peer, req *message.DiameterMessage, resultCode message.ResultCode, errorMessage string) {
+	if !s.autoErrorAnswers {
+		return
+	}
+	extra, err := errorMessageAVPs(errorMessage)
+	if err != nil {
+		log.Printf("Error creating Error-Message AVP: %v", err)
+		return
+	}
+	ans, err := s.newAnswer(req, resultCode, extra...)
+	if err != nil {
+		log.Printf("Error creating %s answer: %v", resultCode, err)
+		return
+	}
+	ans.Header.CommandFlags = ans.Header.CommandFlags.With(message.FlagError)
+	if err := p.WriteMessage(ans); err != nil {
+		log.Printf("Error sending %s answer to %s: %v", resultCode, p.addr, err)
+	}
+}
+
+// answerInvalidAVP answers a request whose AVP values could not all be
+// decoded with the Result-Code chosen by message.ValidateDecoding and the
+// offending AVP in Failed-AVP, unless automatic error answers are
+// disabled.
+func (s *Server) answerInvalidAVP(p *peer, req *message.DiameterMessage) {
+	if !s.autoErrorAnswers {
+		return
+	}
+	var verr *message.ValidationError
+	if !errors.As(message.ValidateDecoding(req), &verr) {
+		return
+	}
+	failed, err := message.NewGroupedAVP(message.AVP_FAILED_AVP, message.MANDATORY_FLAG, 0, req.DecodeErrors()[0].AVP)
+	if err != nil {
+		log.Printf("Error creating Failed-AVP: %v", err)
+		return
+	}
+	extra, err := errorMessageAVPs(verr.Reason)
+	if err != nil {
+		log.Printf("Error creating Error-Message AVP: %v", err)
+		return
+	}
+	if err := s.answer(p, req, verr.ResultCode, append(extra, failed)...); err != nil {
+		log.Printf("Error sending %s answer to %s: %v", verr.ResultCode, p.addr, err)
+	}
+}
+
+// errorMessageAVPs returns an Error-Message AVP for text, or nothing when
+// text is empty.
+func errorMessageAVPs(text string) ([]*message.AVP, error) {
+	if text == "" {
+		return nil, nil
+	}
+	avp, err := message.NewErrorMessageAVP(text)
+	if err != nil {
+		return nil, err
+	}
+	return []*message.AVP{avp}, nil
+}
+
+// localApplications returns the configured applications together with the
+// applications of every registered handler.
+func (s *Server) localApplications() message.Applications {
+	apps := message.Applications{
+		Auth:           message.NewApplicationSet(s.applications.Auth.IDs()...),
+		Acct:           message.NewApplicationSet(s.applications.Acct.IDs()...),
+		VendorSpecific: append([]message.VendorApplication(nil), s.applications.VendorSpecific...),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.handlers {
+		if key.applicationID == message.APPLICATION_ID_DIAMETER_COMMON_MESSAGES {
+			continue
+		}
+		if message.IsAccountingCommand(key.commandCode) {
+			apps.Acct[key.applicationID] = struct{}{}
+		} else {
+			apps.Auth[key.applicationID] = struct{}{}
+		}
+	}
+	return apps
+}
+
+// answerCER negotiates the applications with the peer and answers the CER.
+// Authentication and accounting applications are negotiated independently;
+// the exchange only fails when no application of any kind is shared.
+func (s *Server) answerCER(p *peer, req *message.DiameterMessage) {
+	id, err := message.OriginIdentity(req)
+	if err != nil {
+		log.Printf("Invalid CER from %s: %v", p.addr, err)
+		extra, avpErr := errorMessageAVPs(err.Error())
+		if avpErr != nil {
+			log.Printf("Error creating Error-Message AVP: %v", avpErr)
+		}
+		if err := s.answer(p, req, message.DIAMETER_MISSING_AVP, extra...); err != nil {
+			log.Printf("Error sending CEA to %s: %v", p.addr, err)
+		}
+		p.conn.Close()
+		return
+	}
+	if s.isQuarantined(id.String()) {
+		s.quarantineRejections.Add(1)
+		log.Printf("%s is in quarantine, rejecting CER from %s.", id, p.addr)
+		s.answerUnsupported(p, req, message.DIAMETER_UNABLE_TO_COMPLY, "peer quarantined")
+		p.conn.Close()
+		return
+	}
+	if err := s.checkTLSIdentity(p, id.Host); err != nil {
+		s.answerUnsupported(p, req, message.DIAMETER_UNKNOWN_PEER, "certificate does not match Origin-Host")
+		p.conn.Close()
+		return
+	}
+	if s.checkHostIP(p, req) {
+		p.conn.Close()
+		return
+	}
+	local := s.localApplications()
+	offer, err := message.ParseOffer(req)
+	if err != nil {
+		log.Printf("Ignoring invalid capabilities in CER from %s: %v", p.addr, err)
+	}
+	negotiated := message.Capabilities{Applications: local}.Intersect(offer)
+	if negotiated.IsEmpty() {
+		log.Printf("No common application with %s, rejecting CER.", p.addr)
+		if err := s.answer(p, req, message.DIAMETER_NO_COMMON_APPLICATION); err != nil {
+			log.Printf("Error sending CEA to %s: %v", p.addr, err)
+		}
+		p.conn.Close()
+		return
+	}
+	replaced, ok := s.registerPeer(p, id, message.ParseCapabilities(req))
+	if !ok {
+		log.Printf("%s is already connected, rejecting CER from %s.", id, p.addr)
+		extra, err := errorMessageAVPs("peer already connected")
+		if err != nil {
+			log.Printf("Error creating Error-Message AVP: %v", err)
+		}
+		if err := s.answer(p, req, message.DIAMETER_UNABLE_TO_COMPLY, extra...); err != nil {
+			log.Printf("Error sending CEA to %s: %v", p.addr, err)
+		}
+		p.conn.Close()
+		return
+	}
+	p.setApplications(negotiated.Applications)
+
+	log.Printf("Sending Capabilities-Exchange-Answer (CEA) to %s (%s).", id, p.addr)
+	node := s.node()
+	if ip := transport.HostIP(p.conn.LocalAddr()); ip != nil {
+		node.HostIPAddresses = []net.IP{ip}
+	}
+	load, err := s.loadAVPs()
+	if err != nil {
+		log.Printf("Error creating Load AVP: %v", err)
+		return
+	}
+	ans, err := node.BuildCEA(req, local, message.DIAMETER_SUCCESS, load...)
+	if err != nil {
+		log.Printf("Error creating CEA: %v", err)
+		return
+	}
+	if err := p.WriteMessage(ans); err != nil {
+		log.Printf("Error sending CEA to %s: %v", p.addr, err)
+	} else if fn := s.peerUpHandler; fn != nil {
+		info := p.info()
+		s.spawn(func() { fn(info) })
+	}
+	if replaced != nil {
+		s.spawn(func() { s.disconnectReplaced(replaced) })
+	}
+}
+
+// checkTLSIdentity compares the certificate of p with host, the
+// Origin-Host of its CER, as set with WithTLSIdentityBinding. An error is
+// only returned in TLSIdentityEnforce mode.
+func (s *Server) checkTLSIdentity(p *peer, host string) error {
+	if s.tlsIdentity == transport.TLSIdentityOff || !p.conn.IsTLS() {
+		return nil
+	}
+	err := p.conn.VerifyPeerIdentity(host)
+	if err == nil {
+		return nil
+	}
+	log.Printf("Invalid TLS identity of %s: %v", p.addr, err)
+	if s.tlsIdentity != transport.TLSIdentityEnforce {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) answerDWR(p *peer, req *message.DiameterMessage) {
+	if err := message.CheckOrigin(req, p.getIdentity()); err != nil {
+		log.Printf("Invalid DWR from %s: %v", p.addr, err)
+		if s.strictWatchdog {
+			if err := s.answer(p, req, message.DIAMETER_UNABLE_TO_COMPLY); err != nil {
+				log.Printf("Error sending DWA to %s: %v", p.addr, err)
+			}
+			p.conn.Close()
+			return
+		}
+	}
+	log.Printf("Sending Device-Watchdog-Answer (DWA) to %s.", p.addr)
+	load, err := s.loadAVPs()
+	if err != nil {
+		log.Printf("Error creating Load AVP: %v", err)
+		return
+	}
+	ans, err := s.node().BuildDWA(req, load...)
+	if err != nil {
+		log.Printf("Error creating DWA: %v", err)
+		return
+	}
+	if err := p.WriteMessage(ans); err != nil {
+		log.Printf("Error sending DWA to %s: %v", p.addr, err)
+	}
+}
+
+func (s *Server) answerDPR(p *peer, req *message.DiameterMessage) {
+	log.Printf("Sending Disconnect-Peer-Answer (DPA) to %s.", p.addr)
+	if err := s.answer(p, req, message.DIAMETER_SUCCESS); err != nil {
+		log.Printf("Error sending DPA to %s: %v", p.addr, err)
+	}
+	p.conn.Close()
+}
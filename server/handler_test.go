@@ -0,0 +1,318 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+)
+
+// rawRequest returns a request of clientNode for session, with command
+// code and application, carrying extra after the mandatory AVPs of a CCR.
+func rawRequest(t *testing.T, code, app uint32, session string, extra ...*message.AVP) *message.DiameterMessage {
+	t.Helper()
+	avps := []*message.AVP{
+		message.MustNewAVP(message.AVP_SESSION_ID, session, message.MANDATORY_FLAG),
+		message.MustNewAVP(message.AVP_ORIGIN_HOST, clientNode.OriginHost, message.MANDATORY_FLAG),
+		message.MustNewAVP(message.AVP_ORIGIN_REALM, clientNode.OriginRealm, message.MANDATORY_FLAG),
+		message.MustNewAVP(message.AVP_DESTINATION_REALM, serverNode.OriginRealm, message.MANDATORY_FLAG),
+		message.MustNewAVP(message.AVP_AUTH_APPLICATION_ID, app, message.MANDATORY_FLAG),
+	}
+	req, err := message.NewRequest(code, message.WithApplication(app), message.WithAVPs(append(avps, extra...)...))
+	if err != nil {
+		t.Fatalf("building request: %v", err)
+	}
+	return req
+}
+
+// writeMessage encodes msg and writes it on conn.
+func writeMessage(t *testing.T, conn net.Conn, msg *message.DiameterMessage) {
+	t.Helper()
+	frame, err := msg.Encode()
+	if err == nil {
+		_, err = conn.Write(frame)
+	}
+	if err != nil {
+		t.Fatalf("sending %s: %v", msg.CommandName(), err)
+	}
+}
+
+// readMessage reads and decodes the next message from r.
+func readMessage(t *testing.T, conn net.Conn, r *bufio.Reader) *message.DiameterMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(testTimeout))
+	msg, err := message.DecodeMessage(readRawFrame(t, r, nil))
+	if err != nil {
+		t.Fatalf("decoding message: %v", err)
+	}
+	return msg
+}
+
+// nextAfterDWR sends a DWR on conn and returns the first message read
+// from r: the answer to what was sent before, if anything was answered,
+// otherwise the DWA.
+func nextAfterDWR(t *testing.T, conn net.Conn, r *bufio.Reader) *message.DiameterMessage {
+	t.Helper()
+	dwr, err := clientNode.BuildDWR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeMessage(t, conn, dwr)
+	return readMessage(t, conn, r)
+}
+
+func TestAutoErrorAnswers(t *testing.T) {
+	const gx = uint32(16777238)
+	answer, err := serverNode.BuildAnswer(rawRequest(t, message.COMMAND_CODE_CREDIT_CONTROL, message.APPLICATION_ID_CREDIT_CONTROL, "client.example.com;1;1"), message.DIAMETER_SUCCESS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name     string
+		disabled bool
+		msg      *message.DiameterMessage
+		// want is the Result-Code of the error answer, 0 when the
+		// message must not be answered.
+		want message.ResultCode
+	}{
+		{"unknown command", false, rawRequest(t, 9999, message.APPLICATION_ID_CREDIT_CONTROL, "client.example.com;1;1"), message.DIAMETER_COMMAND_UNSUPPORTED},
+		{"unknown application", false, rawRequest(t, message.COMMAND_CODE_CREDIT_CONTROL, gx, "client.example.com;1;2"), message.DIAMETER_APPLICATION_UNSUPPORTED},
+		{"inbound answer", false, answer, 0},
+		{"disabled", true, rawRequest(t, 9999, message.APPLICATION_ID_CREDIT_CONTROL, "client.example.com;1;3"), 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, addr := startServer(t, server.WithAutoErrorAnswers(!tc.disabled))
+			s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, answerSuccess)
+			conn := dialRaw(t, addr)
+			r := bufio.NewReader(conn)
+
+			writeMessage(t, conn, tc.msg)
+			got := nextAfterDWR(t, conn, r)
+			if tc.want == 0 {
+				if got.Header.CommandCode != message.COMMAND_CODE_DWR {
+					t.Fatalf("%s answered with %s", tc.msg.CommandName(), got.CommandName())
+				}
+				if n := s.StatsSnapshot().OrphanedAnswers; tc.msg.IsAnswer() && n != 1 {
+					t.Errorf("%d unsolicited answers counted, want 1", n)
+				}
+				return
+			}
+			if got.Header.CommandCode != tc.msg.Header.CommandCode || got.Header.HopByHopID != tc.msg.Header.HopByHopID {
+				t.Fatalf("got %s, want the answer to %s", got.CommandName(), tc.msg.CommandName())
+			}
+			if code, _, err := message.GetResultCode(got); err != nil || code != tc.want {
+				t.Errorf("Result-Code %v, %v; want %v", code, err, tc.want)
+			}
+			if !got.Header.CommandFlags.Error() {
+				t.Error("'E' bit clear")
+			}
+			if result, _ := message.GetResult(got); result.ErrorMessage == "" {
+				t.Error("no Error-Message")
+			}
+			if id, err := message.OriginIdentity(got); err != nil || id != message.NewPeerIdentity(serverNode.OriginHost, serverNode.OriginRealm) {
+				t.Errorf("origin %v, %v; want %s in %s", id, err, serverNode.OriginHost, serverNode.OriginRealm)
+			}
+		})
+	}
+}
+
+// checkSessionIDFirst checks that ans carries the Session-Id of req as its
+// first AVP.
+func checkSessionIDFirst(t *testing.T, req, ans *message.DiameterMessage) {
+	t.Helper()
+	want, _ := message.GetSessionID(req)
+	if len(ans.AVPs) == 0 || ans.AVPs[0].Code != message.AVP_SESSION_ID {
+		t.Fatalf("%s does not start with a Session-Id", ans.CommandName())
+	}
+	if got, _ := ans.AVPs[0].Str(); got != want {
+		t.Errorf("Session-Id %q, want %q", got, want)
+	}
+}
+
+// TestGeneratedAnswersSessionID checks that every answer the server
+// generates on its own carries the Session-Id of the request first.
+func TestGeneratedAnswersSessionID(t *testing.T) {
+	const session = "client.example.com;42;7"
+	badLength := &message.AVP{Code: message.AVP_ORIGIN_STATE_ID, Flags: message.MANDATORY_FLAG, Data: &message.OctetString{Data: []byte{1, 2}}}
+	badUTF8 := &message.AVP{Code: message.AVP_USER_NAME, Flags: message.MANDATORY_FLAG, Data: &message.OctetString{Data: []byte{0xff, 0xfe}}}
+	initial := message.MustNewAVP(message.AVP_CC_REQUEST_TYPE, message.INITIAL_REQUEST, message.MANDATORY_FLAG)
+	for _, tc := range []struct {
+		name string
+		opts []server.ServerOptionsFunc
+		// setup sends what must come before req, returning once it is
+		// answered.
+		setup func(t *testing.T, conn net.Conn, r *bufio.Reader)
+		req   *message.DiameterMessage
+		want  message.ResultCode
+	}{
+		{
+			name: "command unsupported",
+			req:  rawRequest(t, 9999, message.APPLICATION_ID_CREDIT_CONTROL, session),
+			want: message.DIAMETER_COMMAND_UNSUPPORTED,
+		},
+		{
+			name: "application unsupported",
+			req:  rawRequest(t, message.COMMAND_CODE_CREDIT_CONTROL, 16777238, session),
+			want: message.DIAMETER_APPLICATION_UNSUPPORTED,
+		},
+		{
+			name: "invalid AVP length",
+			req:  rawRequest(t, message.COMMAND_CODE_CREDIT_CONTROL, message.APPLICATION_ID_CREDIT_CONTROL, session, badLength),
+			want: message.DIAMETER_INVALID_AVP_LENGTH,
+		},
+		{
+			name: "invalid AVP value",
+			opts: []server.ServerOptionsFunc{server.WithDecodeOptions(message.DecodeOptions{Mode: message.DecodeTolerant, ValidateUTF8: true})},
+			req:  rawRequest(t, message.COMMAND_CODE_CREDIT_CONTROL, message.APPLICATION_ID_CREDIT_CONTROL, session, badUTF8),
+			want: message.DIAMETER_INVALID_AVP_VALUE,
+		},
+		{
+			name: "handler timeout",
+			opts: []server.ServerOptionsFunc{server.WithHandlerTimeout(50 * time.Millisecond)},
+			req:  rawRequest(t, message.COMMAND_CODE_CREDIT_CONTROL, message.APPLICATION_ID_CREDIT_CONTROL, "stuck"),
+			want: message.DIAMETER_TOO_BUSY,
+		},
+		{
+			name: "resources exceeded",
+			opts: []server.ServerOptionsFunc{server.WithMaxSessions(1, 0)},
+			setup: func(t *testing.T, conn net.Conn, r *bufio.Reader) {
+				writeMessage(t, conn, rawRequest(t, message.COMMAND_CODE_CREDIT_CONTROL, message.APPLICATION_ID_CREDIT_CONTROL, "client.example.com;1;1", initial))
+				readMessage(t, conn, r)
+			},
+			req:  rawRequest(t, message.COMMAND_CODE_CREDIT_CONTROL, message.APPLICATION_ID_CREDIT_CONTROL, session, initial),
+			want: message.DIAMETER_RESOURCES_EXCEEDED,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newGatedHandler("stuck")
+			defer close(h.gates["stuck"])
+			s, addr := startServer(t, tc.opts...)
+			s.Handle(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, h)
+			conn := dialRaw(t, addr)
+			r := bufio.NewReader(conn)
+			if tc.setup != nil {
+				tc.setup(t, conn, r)
+			}
+
+			writeMessage(t, conn, tc.req)
+			ans := readMessage(t, conn, r)
+			if code, _, err := message.GetResultCode(ans); err != nil || code != tc.want {
+				t.Fatalf("Result-Code %v, %v; want %v", code, err, tc.want)
+			}
+			checkSessionIDFirst(t, tc.req, ans)
+		})
+	}
+}
+
+func TestErrorAnswerSessionID(t *testing.T) {
+	s, _ := startServer(t)
+	req := rawRequest(t, message.COMMAND_CODE_CREDIT_CONTROL, message.APPLICATION_ID_CREDIT_CONTROL, "client.example.com;42;8")
+	ans, err := s.ErrorAnswer(req, errors.New("no credit"))
+	if err != nil {
+		t.Fatalf("ErrorAnswer: %v", err)
+	}
+	checkSessionIDFirst(t, req, ans)
+	n := 0
+	for avp := range ans.All() {
+		if avp.Code == message.AVP_SESSION_ID {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("%d Session-Ids, want 1", n)
+	}
+}
+
+// TestHandlerMutatesRequest has a handler rewrite the request it is given
+// and checks that the slow-request log and the duplicate cache still see
+// the request as received.
+func TestHandlerMutatesRequest(t *testing.T) {
+	const session = "client.example.com;1;1"
+	logs := captureLog(t)
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	s, addr := startServer(t, server.WithClock(clk),
+		server.WithSlowRequestThreshold(time.Second),
+		server.WithDuplicateCache(16, time.Minute))
+	var calls atomic.Int32
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, func(w server.ResponseWriter, req *message.DiameterMessage) {
+		calls.Add(1)
+		answerSuccess(w, req)
+		for avp := range req.All() {
+			switch avp.Code {
+			case message.AVP_SESSION_ID:
+				avp.Data.SetData("mutated;session")
+			case message.AVP_ORIGIN_HOST:
+				avp.Data.SetData("mutated.example.com")
+			}
+		}
+		req.Header.EndToEndID++
+		req.AVPs = append(req.AVPs, message.MustNewAVP(message.AVP_USER_NAME, "mutated-user", message.MANDATORY_FLAG))
+		clk.Advance(2 * time.Second)
+	})
+	conn := dialRaw(t, addr)
+	r := bufio.NewReader(conn)
+
+	req := rawRequest(t, message.COMMAND_CODE_CREDIT_CONTROL, message.APPLICATION_ID_CREDIT_CONTROL, session)
+	writeMessage(t, conn, req)
+	first := readMessage(t, conn, r)
+	checkSessionIDFirst(t, req, first)
+
+	retransmit := req.Clone()
+	retransmit.Header.CommandFlags = retransmit.Header.CommandFlags.With(message.FlagRetransmitted)
+	writeMessage(t, conn, retransmit)
+	again := readMessage(t, conn, r)
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler called %d times, want the retransmission answered from the cache", n)
+	}
+	checkSessionIDFirst(t, req, again)
+
+	out := logs.String()
+	if !strings.Contains(out, session) || strings.Contains(out, "mutated") {
+		t.Errorf("slow-request log shows the request as changed by the handler:\n%s", out)
+	}
+}
+
+// TestInvalidAVPAnswer sends a request with an AVP value of the wrong size
+// and checks that it is answered with DIAMETER_INVALID_AVP_LENGTH and the
+// AVP as received in Failed-AVP, without reaching the handler.
+func TestInvalidAVPAnswer(t *testing.T) {
+	var handled atomic.Bool
+	s, addr := startServer(t)
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, func(w server.ResponseWriter, req *message.DiameterMessage) {
+		handled.Store(true)
+		answerSuccess(w, req)
+	})
+	conn := dialRaw(t, addr)
+	r := bufio.NewReader(conn)
+
+	bad := &message.AVP{Code: message.AVP_ORIGIN_STATE_ID, Flags: message.MANDATORY_FLAG, Data: &message.OctetString{Data: []byte{1, 2}}}
+	writeMessage(t, conn, rawRequest(t, message.COMMAND_CODE_CREDIT_CONTROL, message.APPLICATION_ID_CREDIT_CONTROL, "client.example.com;1;1", bad))
+	ans := readMessage(t, conn, r)
+	if code, _, err := message.GetResultCode(ans); err != nil || code != message.DIAMETER_INVALID_AVP_LENGTH {
+		t.Fatalf("Result-Code %v, %v; want DIAMETER_INVALID_AVP_LENGTH", code, err)
+	}
+	// The member of Failed-AVP has the wrong size, so decoding the answer
+	// keeps the whole group raw.
+	failed := ans.GetAVP(message.AVP_FAILED_AVP)
+	if failed == nil {
+		t.Fatal("no Failed-AVP")
+	}
+	want, err := bad.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := failed.Data.(*message.OctetString); !ok || !bytes.Equal(got.Data, want) {
+		t.Errorf("Failed-AVP holds %v, want %x", failed.Data, want)
+	}
+	if handled.Load() {
+		t.Error("request reached the handler")
+	}
+}
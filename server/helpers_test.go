@@ -0,0 +1,114 @@
+package server_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/client"
+	"github.com/IbrahimShahzad/diameter/diametertest"
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+)
+
+// testTimeout bounds every wait on the network in the tests.
+const testTimeout = 5 * time.Second
+
+// serverNode is the identity of the servers of the tests.
+var serverNode = message.Node{OriginHost: "server.example.com", OriginRealm: "example.com"}
+
+// startServer starts a server supporting the Diameter Credit Control
+// application, configured further by opts.
+func startServer(t testing.TB, opts ...server.ServerOptionsFunc) (*server.Server, string) {
+	t.Helper()
+	defaults := []server.ServerOptionsFunc{
+		server.WithOriginHost(serverNode.OriginHost),
+		server.WithOriginRealm(serverNode.OriginRealm),
+		server.WithAuthApplications(message.APPLICATION_ID_CREDIT_CONTROL),
+	}
+	return diametertest.StartServer(t, append(defaults, opts...)...)
+}
+
+// answerSuccess is a handler answering every request with
+// DIAMETER_SUCCESS.
+func answerSuccess(w server.ResponseWriter, req *message.DiameterMessage) {
+	ans, err := serverNode.BuildAnswer(req, message.DIAMETER_SUCCESS)
+	if err == nil {
+		err = w.WriteMessage(ans)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+// connectClient returns a client with Origin-Host host connected to addr
+// and in I-Open. It is closed when the test ends.
+func connectClient(t testing.TB, addr, host string, opts ...client.ClientOptionsFunc) *client.Client {
+	t.Helper()
+	defaults := []client.ClientOptionsFunc{
+		client.WithServerAddr(addr),
+		client.WithOriginHost(host),
+		client.WithOriginRealm("example.com"),
+		client.WithAuthApplications(message.APPLICATION_ID_CREDIT_CONTROL),
+	}
+	c, err := client.NewClient(append(defaults, opts...)...)
+	if err != nil {
+		t.Fatalf("creating client: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if err := c.Connect(); err != nil {
+		t.Fatalf("connecting: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := c.WaitReady(ctx); err != nil {
+		t.Fatalf("waiting for I-Open: %v", err)
+	}
+	return c
+}
+
+// newCCR returns a CCR of c for session.
+func newCCR(t testing.TB, c *client.Client, session string) *message.DiameterMessage {
+	t.Helper()
+	local := c.LocalIdentity()
+	var b message.MessageBuilder
+	avps, err := b.Add(message.AVP_SESSION_ID, session, message.MANDATORY_FLAG).
+		Add(message.AVP_ORIGIN_HOST, local.Host, message.MANDATORY_FLAG).
+		Add(message.AVP_ORIGIN_REALM, local.Realm, message.MANDATORY_FLAG).
+		Add(message.AVP_DESTINATION_REALM, serverNode.OriginRealm, message.MANDATORY_FLAG).
+		Add(message.AVP_AUTH_APPLICATION_ID, message.APPLICATION_ID_CREDIT_CONTROL, message.MANDATORY_FLAG).
+		AVPs()
+	if err != nil {
+		t.Fatalf("building CCR AVPs: %v", err)
+	}
+	req, err := c.NewRequest(message.COMMAND_CODE_CREDIT_CONTROL, message.WithAVPs(avps...))
+	if err != nil {
+		t.Fatalf("building CCR: %v", err)
+	}
+	return req
+}
+
+// request sends req with c and returns its answer.
+func request(t testing.TB, c *client.Client, req *message.DiameterMessage) *message.DiameterMessage {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	ans, err := c.Request(ctx, req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	return ans
+}
+
+// eventually waits for cond to hold, failing the test with what after
+// testTimeout.
+func eventually(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
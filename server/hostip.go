@@ -0,0 +1,92 @@
+// Validation of the Host-IP-Address AVPs of a CER
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/transport"
+)
+
+// HostIPMode selects how the server treats a CER whose Host-IP-Address
+// AVPs do not include the source address of the connection.
+type HostIPMode int
+
+const (
+	// HostIPWarn logs the mismatch and accepts the CER. It is the default,
+	// since peers behind NAT cannot advertise the address they connect
+	// from.
+	HostIPWarn HostIPMode = iota
+	// HostIPOff skips the check.
+	HostIPOff
+	// HostIPEnforce rejects the CER with DIAMETER_INVALID_AVP_VALUE, or
+	// DIAMETER_MISSING_AVP when it has no Host-IP-Address at all.
+	HostIPEnforce
+)
+
+func (m HostIPMode) String() string {
+	switch m {
+	case HostIPWarn:
+		return "warn"
+	case HostIPOff:
+		return "off"
+	case HostIPEnforce:
+		return "enforce"
+	}
+	return fmt.Sprintf("HostIPMode(%d)", int(m))
+}
+
+// checkHostIP compares the Host-IP-Address AVPs of the CER req with the
+// source address of p. In enforce mode a mismatch is answered and true is
+// returned, in which case the caller must close the connection.
+func (s *Server) checkHostIP(p *peer, req *message.DiameterMessage) bool {
+	if s.validateHostIP == HostIPOff {
+		return false
+	}
+	remote := transport.HostIP(p.conn.RemoteAddr())
+	if remote == nil {
+		return false
+	}
+	var advertised []*message.AVP
+	for _, avp := range req.AVPs {
+		if avp.Code != message.AVP_HOST_IP_ADDRESS {
+			continue
+		}
+		if ip, err := avp.IP(); err == nil && ip.Equal(remote) {
+			return false
+		}
+		advertised = append(advertised, avp)
+	}
+
+	log.Printf("CER from %s does not advertise its source address %s in Host-IP-Address.", p.addr, remote)
+	if s.validateHostIP != HostIPEnforce {
+		return false
+	}
+	resultCode := message.DIAMETER_INVALID_AVP_VALUE
+	failed := advertised
+	if len(advertised) == 0 {
+		resultCode = message.DIAMETER_MISSING_AVP
+		example, err := message.NewAVP(message.AVP_HOST_IP_ADDRESS, net.IPv4zero, message.MANDATORY_FLAG)
+		if err != nil {
+			log.Printf("Error creating Host-IP-Address AVP: %v", err)
+			return true
+		}
+		failed = []*message.AVP{example}
+	}
+	failedAVP, err := message.NewGroupedAVP(message.AVP_FAILED_AVP, message.MANDATORY_FLAG, 0, failed[0])
+	if err != nil {
+		log.Printf("Error creating Failed-AVP: %v", err)
+		return true
+	}
+	extra, err := errorMessageAVPs("Host-IP-Address does not match the source address " + remote.String())
+	if err != nil {
+		log.Printf("Error creating Error-Message AVP: %v", err)
+		return true
+	}
+	if err := s.answer(p, req, resultCode, append(extra, failedAVP)...); err != nil {
+		log.Printf("Error sending CEA to %s: %v", p.addr, err)
+	}
+	return true
+}
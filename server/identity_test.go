@@ -0,0 +1,198 @@
+package server_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+)
+
+func TestPeersKeyedByIdentity(t *testing.T) {
+	s, addr := startServer(t)
+	want := message.NewPeerIdentity("client.example.com", "example.com")
+
+	first := connectClient(t, addr, "Client.Example.COM.")
+	eventually(t, "the peer to register", func() bool { return slices.Contains(s.Peers(), want) })
+	if got := first.PeerIdentity(); got != s.LocalIdentity() {
+		t.Errorf("client sees the server as %v, want %v", got, s.LocalIdentity())
+	}
+	if got := first.LocalIdentity(); got != want {
+		t.Errorf("LocalIdentity() = %v, want %v", got, want)
+	}
+	first.Close()
+	eventually(t, "the peer to leave", func() bool { return !slices.Contains(s.Peers(), want) })
+
+	// A reconnection from another port is the same peer.
+	connectClient(t, addr, "client.example.com")
+	eventually(t, "the peer to register again", func() bool { return slices.Contains(s.Peers(), want) })
+	if got, ok := s.LookupPeer("CLIENT.example.com"); !ok || got != want {
+		t.Errorf("LookupPeer() = %v, %v; want %v", got, ok, want)
+	}
+	info, ok := s.PeerInfo(want)
+	if !ok {
+		t.Fatal("PeerInfo() found no peer")
+	}
+	if info.Identity != want {
+		t.Errorf("PeerInfo().Identity = %v, want %v", info.Identity, want)
+	}
+}
+
+// TestWatchdogOrigin sends DWRs whose Origin-Host matches the CER or not
+// and checks that WithStrictWatchdog refuses the others and closes the
+// connection, while by default they are only logged.
+func TestWatchdogOrigin(t *testing.T) {
+	dwr := func(avps ...*message.AVP) *message.DiameterMessage {
+		t.Helper()
+		req, err := message.NewRequest(message.COMMAND_CODE_DWR, message.WithAVPs(avps...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	realm := message.MustNewAVP(message.AVP_ORIGIN_REALM, clientNode.OriginRealm, message.MANDATORY_FLAG)
+	host := func(name string) *message.AVP {
+		return message.MustNewAVP(message.AVP_ORIGIN_HOST, name, message.MANDATORY_FLAG)
+	}
+	for _, tc := range []struct {
+		name   string
+		strict bool
+		dwr    *message.DiameterMessage
+		want   message.ResultCode
+	}{
+		{"matching", true, dwr(host(clientNode.OriginHost), realm), message.DIAMETER_SUCCESS},
+		{"other host", false, dwr(host("spoofed.example.com"), realm), message.DIAMETER_SUCCESS},
+		{"other host strict", true, dwr(host("spoofed.example.com"), realm), message.DIAMETER_UNABLE_TO_COMPLY},
+		{"no Origin-Host strict", true, dwr(realm), message.DIAMETER_UNABLE_TO_COMPLY},
+		{"no Origin-Realm strict", true, dwr(host(clientNode.OriginHost)), message.DIAMETER_UNABLE_TO_COMPLY},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var opts []server.ServerOptionsFunc
+			if tc.strict {
+				opts = append(opts, server.WithStrictWatchdog())
+			}
+			_, addr := startServer(t, opts...)
+			conn := dialRaw(t, addr)
+			r := bufio.NewReader(conn)
+			writeMessage(t, conn, tc.dwr)
+			dwa := readMessage(t, conn, r)
+			if code, _, err := message.GetResultCode(dwa); err != nil || code != tc.want {
+				t.Fatalf("DWA Result-Code %v, %v; want %v", code, err, tc.want)
+			}
+			conn.SetReadDeadline(time.Now().Add(testTimeout))
+			if tc.want == message.DIAMETER_SUCCESS {
+				// The connection is still usable.
+				if code, _, _ := message.GetResultCode(nextAfterDWR(t, conn, r)); code != message.DIAMETER_SUCCESS {
+					t.Errorf("next DWA Result-Code %v", code)
+				}
+				return
+			}
+			if _, err := r.ReadByte(); err != io.EOF {
+				t.Errorf("read %v after the refused DWR, want EOF", err)
+			}
+		})
+	}
+}
+
+// TestDuplicatePeerPolicy connects the same identity twice under each
+// policy and checks which connections survive.
+func TestDuplicatePeerPolicy(t *testing.T) {
+	apps := message.Applications{Auth: message.NewApplicationSet(message.APPLICATION_ID_CREDIT_CONTROL)}
+	for _, tc := range []struct {
+		policy server.DuplicatePeerPolicy
+		// want is the Result-Code of the second CEA.
+		want message.ResultCode
+		// oldOpen and newOpen tell which connections survive.
+		oldOpen, newOpen bool
+	}{
+		{server.DuplicatePeerAllow, message.DIAMETER_SUCCESS, true, true},
+		{server.DuplicatePeerRejectNew, message.DIAMETER_UNABLE_TO_COMPLY, true, false},
+		{server.DuplicatePeerReplaceOld, message.DIAMETER_SUCCESS, false, true},
+	} {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			_, addr := startServer(t, server.WithDuplicatePeerPolicy(tc.policy))
+			oldConn, oldR, cea := exchangeCapabilities(t, addr, apps)
+			if code, _, err := message.GetResultCode(cea); err != nil || code != message.DIAMETER_SUCCESS {
+				t.Fatalf("first CEA Result-Code %v, %v", code, err)
+			}
+			newConn, newR, cea := exchangeCapabilities(t, addr, apps)
+			if code, _, err := message.GetResultCode(cea); err != nil || code != tc.want {
+				t.Fatalf("second CEA Result-Code %v, %v; want %v", code, err, tc.want)
+			}
+
+			for _, c := range []struct {
+				name string
+				conn net.Conn
+				r    *bufio.Reader
+				open bool
+			}{
+				{"old", oldConn, oldR, tc.oldOpen},
+				{"new", newConn, newR, tc.newOpen},
+			} {
+				c.conn.SetReadDeadline(time.Now().Add(testTimeout))
+				if c.open {
+					if code, _, _ := message.GetResultCode(nextAfterDWR(t, c.conn, c.r)); code != message.DIAMETER_SUCCESS {
+						t.Errorf("%s connection: DWA Result-Code %v", c.name, code)
+					}
+					continue
+				}
+				if tc.policy == server.DuplicatePeerReplaceOld {
+					dpr := readMessage(t, c.conn, c.r)
+					if dpr.Header.CommandCode != message.COMMAND_CODE_DISCONNECT_PEER || !dpr.IsRequest() {
+						t.Fatalf("%s connection: read %s, want a DPR", c.name, dpr.CommandName())
+					}
+					if cause, err := dpr.GetAVP(message.AVP_DISCONNECT_CAUSE).Uint32(); err != nil || cause != message.DISCONNECT_CAUSE_REBOOTING {
+						t.Errorf("DPR Disconnect-Cause %d, %v; want REBOOTING", cause, err)
+					}
+					dpa, err := clientNode.BuildDPA(dpr)
+					if err != nil {
+						t.Fatal(err)
+					}
+					writeMessage(t, c.conn, dpa)
+				}
+				if _, err := c.r.ReadByte(); err != io.EOF {
+					t.Errorf("%s connection: read %v, want EOF", c.name, err)
+				}
+			}
+		})
+	}
+}
+
+// TestDuplicatePeerSimultaneous has several connections of one identity
+// send their CER at once under DuplicatePeerRejectNew, the server
+// handling each on its own goroutine: only one of them may win.
+func TestDuplicatePeerSimultaneous(t *testing.T) {
+	_, addr := startServer(t, server.WithDuplicatePeerPolicy(server.DuplicatePeerRejectNew))
+	cer, err := clientNode.BuildCER(message.Applications{Auth: message.NewApplicationSet(message.APPLICATION_ID_CREDIT_CONTROL)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make([]net.Conn, 8)
+	for i := range conns {
+		if conns[i], err = net.Dial("tcp", addr); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conns[i].Close() })
+	}
+	for _, conn := range conns {
+		writeMessage(t, conn, cer)
+	}
+	won := 0
+	for _, conn := range conns {
+		switch code, _, err := message.GetResultCode(readMessage(t, conn, bufio.NewReader(conn))); {
+		case err != nil:
+			t.Error(err)
+		case code == message.DIAMETER_SUCCESS:
+			won++
+		case code != message.DIAMETER_UNABLE_TO_COMPLY:
+			t.Errorf("CEA Result-Code %v", code)
+		}
+	}
+	if won != 1 {
+		t.Errorf("%d connections won, want 1", won)
+	}
+}
@@ -0,0 +1,96 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+)
+
+// TestListenEphemeralPort binds port 0, checks the port reported between
+// Listen and Serve, and connects a client to it.
+func TestListenEphemeralPort(t *testing.T) {
+	s, err := server.NewServer(
+		server.WithServerAddr("127.0.0.1:0"),
+		server.WithOriginHost(serverNode.OriginHost),
+		server.WithOriginRealm(serverNode.OriginRealm),
+		server.WithAuthApplications(message.APPLICATION_ID_CREDIT_CONTROL),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, answerSuccess)
+	if addrs := s.ListenerAddrs(); addrs != nil {
+		t.Errorf("ListenerAddrs before Listen = %v", addrs)
+	}
+	if err := s.Serve(); !errors.Is(err, server.ErrNotListening) {
+		t.Errorf("Serve before Listen = %v, want ErrNotListening", err)
+	}
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Listen(); !errors.Is(err, server.ErrAlreadyListening) {
+		t.Errorf("second Listen = %v, want ErrAlreadyListening", err)
+	}
+	addrs := s.ListenerAddrs()
+	if len(addrs) != 1 {
+		t.Fatalf("ListenerAddrs = %v, want one address", addrs)
+	}
+	tcp, ok := addrs[0].(*net.TCPAddr)
+	if !ok || tcp.Port == 0 || !tcp.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("ListenerAddrs = %v, want 127.0.0.1 with the port chosen", addrs)
+	}
+	if s.Addr() != "127.0.0.1:0" {
+		t.Errorf("Addr = %q, want the configured address", s.Addr())
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- s.Serve() }()
+	c := connectClient(t, addrs[0].String(), "client.example.com")
+	ans := request(t, c, newCCR(t, c, "client.example.com;1;1"))
+	if code, _, _ := message.GetResultCode(ans); code != message.DIAMETER_SUCCESS {
+		t.Errorf("answer with %v", code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-served
+	if err := s.Listen(); !errors.Is(err, server.ErrServerClosed) {
+		t.Errorf("Listen after Shutdown = %v, want ErrServerClosed", err)
+	}
+}
+
+// TestParallelEphemeralServers runs tests in parallel, each with a server
+// of its own on a port chosen by the system.
+func TestParallelEphemeralServers(t *testing.T) {
+	ports := make(chan string, 4)
+	t.Run("group", func(t *testing.T) {
+		for range cap(ports) {
+			t.Run("server", func(t *testing.T) {
+				t.Parallel()
+				s, addr := startServer(t)
+				s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, answerSuccess)
+				c := connectClient(t, addr, "client.example.com")
+				request(t, c, newCCR(t, c, "client.example.com;1;1"))
+				ports <- addr
+			})
+		}
+	})
+	close(ports)
+	seen := make(map[string]bool)
+	for addr := range ports {
+		if seen[addr] {
+			t.Errorf("two servers on %s", addr)
+		}
+		seen[addr] = true
+	}
+	if len(seen) != cap(ports) {
+		t.Errorf("%d servers served, want %d", len(seen), cap(ports))
+	}
+}
@@ -0,0 +1,131 @@
+package server_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/client"
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+	"github.com/IbrahimShahzad/diameter/tap"
+)
+
+// originStateID returns the Origin-State-Id of msg, failing the test when
+// it has none.
+func originStateID(t *testing.T, msg *message.DiameterMessage) uint32 {
+	t.Helper()
+	id, err := msg.GetAVP(message.AVP_ORIGIN_STATE_ID).Uint32()
+	if err != nil {
+		t.Fatalf("%s Origin-State-Id: %v", msg.CommandName(), err)
+	}
+	return id
+}
+
+func TestOriginStateID(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	for _, tc := range []struct {
+		name string
+		opts []server.ServerOptionsFunc
+		want uint32
+	}{
+		{"start time", []server.ServerOptionsFunc{server.WithClock(clk)}, 1_700_000_000},
+		{"configured", []server.ServerOptionsFunc{server.WithOriginStateID(7)}, 7},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, addr := startServer(t, tc.opts...)
+			conn, r, cea := exchangeCapabilities(t, addr, message.Applications{Auth: message.NewApplicationSet(message.APPLICATION_ID_CREDIT_CONTROL)})
+			if id := originStateID(t, cea); id != tc.want {
+				t.Errorf("CEA Origin-State-Id %d, want %d", id, tc.want)
+			}
+			if id := originStateID(t, nextAfterDWR(t, conn, r)); id != tc.want {
+				t.Errorf("DWA Origin-State-Id %d, want %d", id, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadReporting(t *testing.T) {
+	t.Run("absent by default", func(t *testing.T) {
+		_, addr := startServer(t)
+		conn, r, cea := exchangeCapabilities(t, addr, message.Applications{Auth: message.NewApplicationSet(message.APPLICATION_ID_CREDIT_CONTROL)})
+		for _, msg := range []*message.DiameterMessage{
+			cea,
+			nextAfterDWR(t, conn, r),
+			func() *message.DiameterMessage {
+				writeMessage(t, conn, rawRequest(t, 9999, message.APPLICATION_ID_CREDIT_CONTROL, "client.example.com;1;1"))
+				return readMessage(t, conn, r)
+			}(),
+		} {
+			if avp := msg.GetAVP(message.AVP_LOAD); avp != nil {
+				t.Errorf("%s carries %v", msg.CommandName(), avp)
+			}
+		}
+	})
+
+	t.Run("sampled per answer", func(t *testing.T) {
+		var load atomic.Uint64
+		load.Store(100)
+		_, addr := startServer(t, server.WithLoadReporting(load.Load))
+		conn, r, _ := exchangeCapabilities(t, addr, message.Applications{Auth: message.NewApplicationSet(message.APPLICATION_ID_CREDIT_CONTROL)})
+		check := func(msg *message.DiameterMessage, want uint64) {
+			t.Helper()
+			got, ok := message.ParseLoad(msg)
+			if !ok {
+				t.Fatalf("%s carries no Load", msg.CommandName())
+			}
+			if got.Type != message.LOAD_TYPE_HOST || got.Value != want || got.SourceID != "" {
+				t.Errorf("%s Load %+v, want HOST %d without Source-ID", msg.CommandName(), got, want)
+			}
+		}
+		check(nextAfterDWR(t, conn, r), 100)
+		load.Store(65535)
+		check(nextAfterDWR(t, conn, r), 65535)
+		writeMessage(t, conn, rawRequest(t, 9999, message.APPLICATION_ID_CREDIT_CONTROL, "client.example.com;1;1"))
+		check(readMessage(t, conn, r), 65535)
+	})
+}
+
+// TestPeerLoad checks that a client exposes the load its server reports,
+// first in the CEA and then in every DWA, through PeerStatus.
+func TestPeerLoad(t *testing.T) {
+	t.Run("not reported", func(t *testing.T) {
+		_, addr := startServer(t)
+		c := connectClient(t, addr, "client.example.com")
+		if load := c.PeerStatus().Load; load != nil {
+			t.Errorf("load %+v, want none", load)
+		}
+	})
+
+	t.Run("reported", func(t *testing.T) {
+		var value atomic.Uint64
+		value.Store(42)
+		s, addr := startServer(t, server.WithLoadReporting(value.Load))
+		s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, answerSuccess)
+		var dwas atomic.Int32
+		clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+		c := connectClient(t, addr, "client.example.com", client.WithClock(clk),
+			client.WithMessageTap(func(direction tap.Direction, _ string, _ []byte, msg *message.DiameterMessage) {
+				if direction == tap.Inbound && msg.Header.CommandCode == message.COMMAND_CODE_DWR {
+					dwas.Add(1)
+				}
+			}))
+		check := func(want uint64) {
+			t.Helper()
+			load := c.PeerStatus().Load
+			if load == nil || *load != (message.Load{Type: message.LOAD_TYPE_HOST, Value: want}) {
+				t.Errorf("load %v, want HOST %d", load, want)
+			}
+		}
+		check(42)
+
+		value.Store(1000)
+		clk.Advance(time.Minute)
+		eventually(t, "the DWA", func() bool { return dwas.Load() > 0 })
+		// The client reads its messages in order, so the DWA has been
+		// handled once the answer to a later request arrived.
+		request(t, c, newCCR(t, c, "client.example.com;1;1"))
+		check(1000)
+	})
+}
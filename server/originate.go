@@ -0,0 +1,95 @@
+// Helpers for requests originated by the server
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/internal/pending"
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+// AnswerError reports an answer whose Result-Code is not a success.
+type AnswerError struct {
+	ResultCode message.ResultCode
+	Answer     *message.DiameterMessage
+}
+
+func (e *AnswerError) Error() string {
+	name := message.ResultCodeToName[e.ResultCode]
+	if name == "" {
+		return fmt.Sprintf("answer with result code %d", e.ResultCode)
+	}
+	return fmt.Sprintf("answer with result code %d (%s)", e.ResultCode, name)
+}
+
+// Unwrap returns the Result-Code as a message.ProtocolError, so that
+// message.ResultCodeForError maps an AnswerError to the code it carries.
+func (e *AnswerError) Unwrap() error {
+	return &message.ProtocolError{ResultCode: e.ResultCode}
+}
+
+// ProtocolError reports whether the answer carries a 3xxx protocol error.
+func (e *AnswerError) ProtocolError() bool {
+	return e.ResultCode >= 3000 && e.ResultCode < 4000
+}
+
+// DisconnectPeer sends a Disconnect-Peer-Request giving cause, one of the
+// message.DISCONNECT_CAUSE values, to the peer with identity id, and
+// closes the connection once the DPA arrives or the request timeout
+// expires. A client of this package told the server is BUSY waits before
+// reconnecting, see client.WithReconnectDelay.
+func (s *Server) DisconnectPeer(id message.PeerIdentity, cause uint32) error {
+	s.mu.Lock()
+	p, ok := s.peers[id]
+	s.mu.Unlock()
+	if !ok {
+		return &message.PeerError{Identity: id, Op: "disconnect", Err: ErrUnknownPeer}
+	}
+	s.disconnect(p, cause, "Disconnect-Cause "+message.DisconnectCauseName(cause))
+	return nil
+}
+
+// LookupPeer returns the identity of the connected peer whose Origin-Host
+// is host, in whatever realm.
+func (s *Server) LookupPeer(host string) (message.PeerIdentity, bool) {
+	want := message.NewPeerIdentity(host, "").Host
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id := range s.peers {
+		if id.Host == want {
+			return id, true
+		}
+	}
+	return message.PeerIdentity{}, false
+}
+
+// RequestWithTimeout sends req to the peer and waits at most timeout for
+// the answer. Unlike Request it treats answers without a 2xxx Result-Code
+// as failures, returning them wrapped in an AnswerError.
+func (s *Server) RequestWithTimeout(id message.PeerIdentity, req *message.DiameterMessage, timeout time.Duration) (*message.DiameterMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ans, err := s.Request(ctx, id, req)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		s.timedOutRequests.Add(1)
+		return nil, ErrRequestTimeout
+	case errors.Is(err, pending.ErrTimeout):
+		// Swept by the request timeout of the server, which Request
+		// counted.
+		return nil, ErrRequestTimeout
+	case err != nil:
+		return nil, err
+	}
+	code, _, err := message.GetResultCode(ans)
+	if err != nil {
+		return ans, err
+	}
+	if code < 2000 || code >= 3000 {
+		return ans, &AnswerError{ResultCode: code, Answer: ans}
+	}
+	return ans, nil
+}
@@ -0,0 +1,195 @@
+package server_test
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/client"
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+)
+
+// answerRARs answers the RARs received on conn with the result codes of
+// codes in turn, skipping those that are 0, until codes is closed.
+func answerRARs(t *testing.T, conn net.Conn, codes <-chan message.ResultCode) {
+	r := bufio.NewReader(conn)
+	for code := range codes {
+		rar, err := message.DecodeMessage(readRawFrame(t, r, nil))
+		if err != nil {
+			t.Errorf("decoding RAR: %v", err)
+			return
+		}
+		if code == 0 {
+			continue
+		}
+		raa, err := clientNode.BuildAnswer(rar, code)
+		if err == nil {
+			var frame []byte
+			if frame, err = raa.Encode(); err == nil {
+				_, err = conn.Write(frame)
+			}
+		}
+		if err != nil {
+			t.Errorf("answering RAR: %v", err)
+			return
+		}
+	}
+}
+
+func TestRequestWithTimeout(t *testing.T) {
+	s, addr := startServer(t, server.WithRequestTimeout(time.Minute))
+	conn := dialRaw(t, addr)
+	id, ok := s.LookupPeer(clientNode.OriginHost)
+	if !ok {
+		t.Fatal("LookupPeer found no peer")
+	}
+	codes := make(chan message.ResultCode)
+	defer close(codes)
+	go answerRARs(t, conn, codes)
+
+	for _, tc := range []struct {
+		name     string
+		code     message.ResultCode
+		protocol bool
+	}{
+		{"success", message.DIAMETER_SUCCESS, false},
+		{"protocol error", message.DIAMETER_UNABLE_TO_DELIVER, true},
+		{"permanent failure", message.DIAMETER_UNKNOWN_SESSION_ID, false},
+	} {
+		codes <- tc.code
+		ans, err := s.RequestWithTimeout(id, newRAR(t, s, id, "server.example.com;1;"+tc.name), testTimeout)
+		if ans == nil {
+			t.Fatalf("%s: no answer: %v", tc.name, err)
+		}
+		if got, _, _ := message.GetResultCode(ans); got != tc.code {
+			t.Errorf("%s: answer with %v", tc.name, got)
+		}
+		var answerErr *server.AnswerError
+		if tc.code == message.DIAMETER_SUCCESS {
+			if err != nil {
+				t.Errorf("%s: %v", tc.name, err)
+			}
+			continue
+		}
+		if !errors.As(err, &answerErr) || answerErr.ResultCode != tc.code || answerErr.ProtocolError() != tc.protocol {
+			t.Errorf("%s: error %v, want an AnswerError with %v", tc.name, err, tc.code)
+		}
+	}
+
+	codes <- 0
+	_, err := s.RequestWithTimeout(id, newRAR(t, s, id, "server.example.com;1;unanswered"), 50*time.Millisecond)
+	if !errors.Is(err, server.ErrRequestTimeout) {
+		t.Errorf("unanswered RAR: %v, want ErrRequestTimeout", err)
+	}
+	if got := s.StatsSnapshot().TimedOutRequests; got != 1 {
+		t.Errorf("TimedOutRequests = %d, want 1", got)
+	}
+
+	_, err = s.RequestWithTimeout(message.NewPeerIdentity("unknown.example.com", "example.com"),
+		newRAR(t, s, id, "server.example.com;1;unknown"), testTimeout)
+	if !errors.Is(err, server.ErrUnknownPeer) {
+		t.Errorf("RAR to an unknown peer: %v, want ErrUnknownPeer", err)
+	}
+}
+
+// TestRequestWithTimeoutServerTimeout checks that a request swept by the
+// request timeout of the server, before the timeout of the call, is
+// reported as ErrRequestTimeout and counted once.
+func TestRequestWithTimeoutServerTimeout(t *testing.T) {
+	s, addr := startServer(t, server.WithRequestTimeout(50*time.Millisecond))
+	conn := dialRaw(t, addr)
+	id, _ := s.LookupPeer(clientNode.OriginHost)
+	codes := make(chan message.ResultCode, 1)
+	codes <- 0
+	close(codes)
+	go answerRARs(t, conn, codes)
+
+	_, err := s.RequestWithTimeout(id, newRAR(t, s, id, "server.example.com;1;1"), testTimeout)
+	if !errors.Is(err, server.ErrRequestTimeout) {
+		t.Errorf("RequestWithTimeout = %v, want ErrRequestTimeout", err)
+	}
+	if got := s.StatsSnapshot().TimedOutRequests; got != 1 {
+		t.Errorf("TimedOutRequests = %d, want 1", got)
+	}
+}
+
+// TestInboundAnswerObservers originates an RAR and receives an orphaned
+// RAA with two answer observers, the first panicking, and checks that both
+// answers are observed without changing what Request returns.
+func TestInboundAnswerObservers(t *testing.T) {
+	s, addr := startServer(t)
+	observed := make(chan *message.DiameterMessage, 4)
+	s.OnInboundAnswer(func(msg *message.DiameterMessage) { panic("audit pipeline down") })
+	s.OnInboundAnswer(func(msg *message.DiameterMessage) {
+		msg.AVPs = nil
+		observed <- msg
+	})
+	conn := dialRaw(t, addr)
+	id, ok := s.LookupPeer(clientNode.OriginHost)
+	if !ok {
+		t.Fatal("LookupPeer found no peer")
+	}
+	codes := make(chan message.ResultCode, 1)
+	defer close(codes)
+	go answerRARs(t, conn, codes)
+
+	codes <- message.DIAMETER_SUCCESS
+	ans, err := s.RequestWithTimeout(id, newRAR(t, s, id, "server.example.com;1;1"), testTimeout)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if code, _, _ := message.GetResultCode(ans); code != message.DIAMETER_SUCCESS {
+		t.Errorf("Request returned %v, want the answer untouched by the observers", code)
+	}
+	next := func() *message.DiameterMessage {
+		t.Helper()
+		select {
+		case msg := <-observed:
+			return msg
+		case <-time.After(testTimeout):
+			t.Fatal("answer not observed")
+		}
+		return nil
+	}
+	if msg := next(); msg.Header.HopByHopID != ans.Header.HopByHopID {
+		t.Errorf("observed answer %d, want %d", msg.Header.HopByHopID, ans.Header.HopByHopID)
+	}
+
+	orphan, err := clientNode.BuildAnswer(newRAR(t, s, id, "server.example.com;1;2"), message.DIAMETER_SUCCESS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeMessage(t, conn, orphan)
+	if msg := next(); msg.Header.HopByHopID != orphan.Header.HopByHopID {
+		t.Errorf("observed answer %d, want the orphan %d", msg.Header.HopByHopID, orphan.Header.HopByHopID)
+	}
+	eventually(t, "the panics to be counted", func() bool { return s.StatsSnapshot().ObserverPanics == 2 })
+	if n := s.StatsSnapshot().OrphanedAnswers; n != 1 {
+		t.Errorf("%d orphaned answers, want 1", n)
+	}
+}
+
+// TestDisconnectPeerBusy disconnects a client as BUSY and checks that it
+// learns the cause and stays away for its reconnect delay.
+func TestDisconnectPeerBusy(t *testing.T) {
+	s, addr := startServer(t)
+	c := connectClient(t, addr, "client.example.com", client.WithReconnectDelay(message.DISCONNECT_CAUSE_BUSY, time.Hour))
+	if err := s.DisconnectPeer(message.NewPeerIdentity("other.example.com", "example.com"), message.DISCONNECT_CAUSE_BUSY); !errors.Is(err, server.ErrUnknownPeer) {
+		t.Errorf("DisconnectPeer of an unknown peer: %v, want ErrUnknownPeer", err)
+	}
+
+	if err := s.DisconnectPeer(message.NewPeerIdentity("client.example.com", "example.com"), message.DISCONNECT_CAUSE_BUSY); err != nil {
+		t.Fatalf("DisconnectPeer: %v", err)
+	}
+	eventually(t, "the client to be disconnected", func() bool {
+		cause := c.PeerStatus().DisconnectCause
+		return len(s.Peers()) == 0 && cause != nil && *cause == message.DISCONNECT_CAUSE_BUSY
+	})
+	time.Sleep(50 * time.Millisecond)
+	if peers := s.Peers(); len(peers) != 0 {
+		t.Errorf("client reconnected as %v before its reconnect delay", peers)
+	}
+}
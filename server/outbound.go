@@ -0,0 +1,30 @@
+// Validation of the answers written by handlers
+package server
+
+import (
+	"fmt"
+
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+// validatingWriter checks the answers a handler writes before passing
+// them on, see WithOutboundValidation.
+type validatingWriter struct {
+	peer *peer
+	next ResponseWriter
+}
+
+func (w *validatingWriter) WriteMessage(msg *message.DiameterMessage) error {
+	err := message.ValidateOutbound(msg)
+	if err == nil {
+		return w.next.WriteMessage(msg)
+	}
+	s := w.peer.server
+	s.invalidAnswers.Add(1)
+	if s.outboundValidation == message.ValidationEnforce {
+		s.errorLog.Printf(w.peer.host, "invalid answer", "Refusing to send invalid %s to %s: %v", msg.CommandName(), w.peer.addr, err)
+		return fmt.Errorf("%w %s: %w", ErrInvalidAnswer, msg.CommandName(), err)
+	}
+	s.errorLog.Printf(w.peer.host, "invalid answer", "Sending invalid %s to %s: %v", msg.CommandName(), w.peer.addr, err)
+	return w.next.WriteMessage(msg)
+}
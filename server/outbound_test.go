@@ -0,0 +1,93 @@
+package server_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+)
+
+// TestOutboundValidationModes has a handler write an answer missing
+// Origin-Host under each mode, and a well-formed answer under the
+// strictest. A refused answer is replaced by one the handler builds with
+// the server identity.
+func TestOutboundValidationModes(t *testing.T) {
+	for _, tc := range []struct {
+		mode message.ValidationMode
+		// valid makes the handler write a well-formed answer.
+		valid bool
+		// refused is set when the handler's answer must not be sent.
+		refused bool
+		invalid uint64
+	}{
+		{mode: message.ValidationOff},
+		{mode: message.ValidationWarn, invalid: 1},
+		{mode: message.ValidationEnforce, refused: true, invalid: 1},
+		{mode: message.ValidationEnforce, valid: true},
+	} {
+		s, addr := startServer(t, server.WithOutboundValidation(tc.mode))
+		written := make(chan error, 1)
+		s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL,
+			func(w server.ResponseWriter, req *message.DiameterMessage) {
+				ans, err := serverNode.BuildAnswer(req, message.DIAMETER_SUCCESS)
+				if err != nil {
+					panic(err)
+				}
+				if !tc.valid {
+					ans = message.NewAnswer(req,
+						message.MustNewAVP(message.AVP_RESULT_CODE, uint32(message.DIAMETER_SUCCESS), message.MANDATORY_FLAG),
+						message.MustNewAVP(message.AVP_ORIGIN_REALM, serverNode.OriginRealm, message.MANDATORY_FLAG),
+					)
+				}
+				err = w.WriteMessage(ans)
+				written <- err
+				if err != nil {
+					fallback, buildErr := serverNode.BuildAnswer(req, message.DIAMETER_UNABLE_TO_COMPLY)
+					if buildErr != nil {
+						panic(buildErr)
+					}
+					w.WriteMessage(fallback)
+				}
+			})
+		conn := dialRaw(t, addr)
+		sendCCR(t, conn, "client.example.com;1;1", 1)
+		conn.SetReadDeadline(time.Now().Add(testTimeout))
+		ans, err := message.DecodeMessage(readRawFrame(t, conn, nil))
+		if err != nil {
+			t.Fatalf("%v: decoding answer: %v", tc.mode, err)
+		}
+
+		err = <-written
+		var verr *message.ValidationError
+		switch {
+		case tc.refused:
+			if !errors.Is(err, server.ErrInvalidAnswer) || !errors.As(err, &verr) || verr.AVPCode != message.AVP_ORIGIN_HOST {
+				t.Errorf("%v: WriteMessage = %v, want ErrInvalidAnswer for Origin-Host", tc.mode, err)
+			}
+		case err != nil:
+			t.Errorf("%v: WriteMessage = %v", tc.mode, err)
+		}
+
+		host, _ := message.GetOriginHost(ans)
+		code, _, _ := message.GetResultCode(ans)
+		switch {
+		case tc.refused:
+			if host != serverNode.OriginHost || code != message.DIAMETER_UNABLE_TO_COMPLY {
+				t.Errorf("%v: answer from %q with %v, want the fallback", tc.mode, host, code)
+			}
+		case tc.valid:
+			if host != serverNode.OriginHost || code != message.DIAMETER_SUCCESS {
+				t.Errorf("%v: well-formed answer from %q with %v", tc.mode, host, code)
+			}
+		default:
+			if host != "" || code != message.DIAMETER_SUCCESS {
+				t.Errorf("%v: answer from %q with %v, want the invalid answer sent", tc.mode, host, code)
+			}
+		}
+		if got := s.StatsSnapshot().InvalidAnswers; got != tc.invalid {
+			t.Errorf("%v: InvalidAnswers = %d, want %d", tc.mode, got, tc.invalid)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+// Per-connection state and read loop
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/internal/pending"
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/stats"
+	"github.com/IbrahimShahzad/diameter/tap"
+	"github.com/IbrahimShahzad/diameter/transport"
+)
+
+// peer is a connection accepted by the server.
+type peer struct {
+	server   *Server
+	conn     *transport.DiameterConnection
+	addr     string
+	host     string // addr without the port, keying rate-limited errors
+	writer   *transport.BatchWriter
+	pending  *pending.Table
+	counters transport.Counters
+	// ctx is cancelled when the peer is removed or the server shuts down.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// violations scores the protocol violations of the peer.
+	violations violationScore
+	// slots limits the handlers running for the peer. It is nil without
+	// a concurrency limit.
+	slots chan struct{}
+	// handlers counts the handlers running for the peer. It is only
+	// incremented by the read loop.
+	handlers sync.WaitGroup
+
+	// order holds the exchanges whose answers are still to be sent, in
+	// arrival order, when answers are ordered.
+	orderMu sync.Mutex
+	order   []*exchange
+	// flushing is set while an exchange writes the answers at the head
+	// of the order.
+	flushing bool
+
+	mu           sync.Mutex
+	identity     message.PeerIdentity
+	capabilities message.PeerCapabilities
+	applications message.Applications
+}
+
+// PeerInfo describes a peer that completed the capabilities exchange.
+type PeerInfo struct {
+	Identity     message.PeerIdentity
+	Addr         string
+	Capabilities message.PeerCapabilities
+	Applications message.Applications
+	// Software describes the software of the peer, from its CER.
+	Software message.PeerSoftwareInfo
+	// Counters reports the traffic exchanged on the connection.
+	Counters stats.PeerCounters
+	// ViolationScore is the current protocol violation score of the
+	// connection, see WithViolationThreshold.
+	ViolationScore int
+	// Sessions is the number of sessions open with the peer, counted
+	// when WithMaxSessions is set.
+	Sessions int
+}
+
+func (p *peer) info() PeerInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PeerInfo{
+		Identity:       p.identity,
+		Addr:           p.addr,
+		Capabilities:   p.capabilities,
+		Applications:   p.applications,
+		Software:       p.capabilities.Software(),
+		Counters:       p.counters.Snapshot(),
+		ViolationScore: p.violations.value(p.server.clock.Now(), p.server.violationDecay),
+		Sessions:       p.server.sessions.peerSessions(p.identity),
+	}
+}
+
+// down is called by the writer when it gives up on the peer. Outstanding
+// requests fail with err, which wraps transport.ErrPeerDown, and closing
+// the connection ends the read loop, which removes the peer.
+func (p *peer) down(err error) {
+	log.Printf("Peer %s is down: %v", p.addr, err)
+	p.pending.FailAll(&message.PeerError{Identity: p.getIdentity(), Op: "request", Err: err})
+	p.conn.Close()
+}
+
+// linger gives the handlers still running for a peer that closed its side
+// of the connection up to timeout to send their answers, and the writer
+// the rest of it to write them.
+func (p *peer) linger(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	clk := p.server.clock
+	start := clk.Now()
+	done := make(chan struct{})
+	go func() {
+		p.handlers.Wait()
+		close(done)
+	}()
+	timer := clk.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C():
+		log.Printf("Handlers for %s still running after %v, closing the connection.", p.addr, timeout)
+		return
+	case <-p.ctx.Done():
+		return
+	}
+	if err := p.writer.Drain(timeout - clk.Now().Sub(start)); err != nil {
+		log.Printf("Error flushing answers to %s: %v", p.addr, err)
+	}
+}
+
+// name returns the identity of the peer, or its address before the
+// capabilities exchange.
+func (p *peer) name() string {
+	if id := p.getIdentity(); !id.IsZero() {
+		return id.String()
+	}
+	return p.addr
+}
+
+// exchanged reports whether the capabilities exchange with p completed.
+func (p *peer) exchanged() bool {
+	return !p.getIdentity().IsZero()
+}
+
+func (p *peer) setApplications(apps message.Applications) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.applications = apps
+}
+
+func (p *peer) getIdentity() message.PeerIdentity {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.identity
+}
+
+// supports reports whether the applications negotiated with the peer allow
+// req, checking the accounting set for accounting commands.
+func (p *peer) supports(req *message.DiameterMessage) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.applications.Supports(
+		req.Header.ApplicationID,
+		message.IsAccountingCommand(req.Header.CommandCode),
+	)
+}
+
+// WriteMessage encodes msg and writes it to the peer.
+func (p *peer) WriteMessage(msg *message.DiameterMessage) error {
+	encoded, err := msg.Encode()
+	if err != nil {
+		return err
+	}
+	return p.writeEncoded(encoded, msg)
+}
+
+// writeEncoded writes an already encoded message. msg is its decoded form
+// for the message tap and Event-Timestamp stamping, and may be nil.
+func (p *peer) writeEncoded(encoded []byte, msg *message.DiameterMessage) error {
+	if p.server.eventTimestamps && msg != nil {
+		stamped, err := message.StampEventTimestamp(encoded, msg, p.server.clock.Now())
+		if err != nil {
+			return err
+		}
+		encoded = stamped
+	}
+	p.server.tap.Observe(tap.Outbound, p.addr, encoded, msg)
+	return p.writer.Write(encoded)
+}
+
+func (s *Server) addConn(p *peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[p] = struct{}{}
+}
+
+func (s *Server) removeConn(p *peer) {
+	id := p.getIdentity()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, p)
+	if s.peers[id] == p {
+		delete(s.peers, id)
+	}
+}
+
+// PeerInfo returns what is known about the peer with the given identity.
+func (s *Server) PeerInfo(id message.PeerIdentity) (PeerInfo, bool) {
+	s.mu.Lock()
+	p, ok := s.peers[id]
+	s.mu.Unlock()
+	if !ok {
+		return PeerInfo{}, false
+	}
+	return p.info(), true
+}
+
+// Peers returns the identities of the peers that completed the
+// capabilities exchange.
+func (s *Server) Peers() []message.PeerIdentity {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]message.PeerIdentity, 0, len(s.peers))
+	for id := range s.peers {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// handlePeer reads messages from conn until the connection fails.
+func (s *Server) handlePeer(conn *transport.DiameterConnection) {
+	p := &peer{
+		server:  s,
+		conn:    conn,
+		addr:    conn.RemoteAddr().String(),
+		pending: pending.New(s.clock),
+	}
+	p.host = p.addr
+	if host, _, err := net.SplitHostPort(p.addr); err == nil {
+		p.host = host
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	defer p.cancel()
+	if err := conn.ApplySocketOptions(s.socketOptions); err != nil {
+		log.Printf("Error applying socket options to %s: %v", p.addr, err)
+		conn.Close()
+		return
+	}
+	if s.peerConcurrency > 0 {
+		p.slots = make(chan struct{}, s.peerConcurrency)
+	}
+	conn.SetCounters(&p.counters)
+	conn.SetTimeouts(0, s.writeTimeout)
+	conn.SetResyncWindow(s.resyncWindow)
+	if fn := s.connEvents; fn != nil {
+		conn.SetEventHandler(func(ev transport.ConnEvent) {
+			fn(p.name(), ev)
+		})
+	}
+	if ip := transport.HostIP(conn.RemoteAddr()); ip != nil && s.isQuarantined(ip.String()) {
+		s.quarantineRejections.Add(1)
+		log.Printf("Rejecting connection from %s, which is in quarantine.", p.addr)
+		conn.Close()
+		return
+	}
+	opts := s.writeBatch
+	opts.OnPeerDown = p.down
+	p.writer = transport.NewBatchWriter(conn, opts)
+	s.addConn(p)
+	defer func() {
+		s.removeConn(p)
+		p.pending.FailAll(&message.PeerError{Identity: p.getIdentity(), Op: "request", Err: ErrPeerDisconnected})
+		p.cancel()
+		p.writer.Close()
+		conn.Close()
+		p.writer.Wait()
+	}()
+	log.Printf("Accepted connection from %s", p.addr)
+
+	for {
+		frame, err := conn.ReadPooledFrame(s.buffers)
+		if errors.Is(err, io.EOF) {
+			log.Printf("Peer %s closed its side of the connection.", p.addr)
+			p.linger(s.linger)
+			return
+		}
+		if err != nil {
+			s.errorLog.Printf(p.host, "read", "Error reading from peer %s: %v", p.addr, err)
+			if errors.Is(err, ErrStreamDesync) {
+				s.violation(p, err.Error())
+			}
+			return
+		}
+		if s.violationThreshold > 0 && len(frame) > s.maxMessageSize {
+			s.checkViolation(p, fmt.Sprintf("message of %d bytes exceeds %d", len(frame), s.maxMessageSize))
+		}
+		// The builtin types copy what they keep of the frame, and types
+		// registered with message.RegisterAVPType decode from a copy, so
+		// the buffer can be recycled even if the message outlives this
+		// iteration.
+		msg, err := message.DecodeMessage(frame, message.WithDecodeOptions(s.decodeOptions))
+		if err != nil {
+			s.buffers.Put(frame)
+			s.errorLog.Printf(p.host, "decode", "Error decoding message from peer %s: %v", p.addr, err)
+			s.violation(p, err.Error())
+			return
+		}
+		s.tap.Observe(tap.Inbound, p.addr, frame, msg)
+		s.buffers.Put(frame)
+		s.handleMessage(p, msg)
+	}
+}
+
+// NewRequest builds a request for code with identifiers taken from the
+// server's IDGenerator.
+func (s *Server) NewRequest(code uint32, opts ...message.RequestOption) (*message.DiameterMessage, error) {
+	return message.NewRequest(code, append([]message.RequestOption{message.WithIDGenerator(s.idGenerator)}, opts...)...)
+}
+
+// Request sends req to the peer with the given identity and waits for the
+// answer, for instance to originate a RAR or ASR. Answers are matched by
+// Hop-by-Hop Identifier, so several requests may be outstanding on the
+// same connection in both directions. If the Hop-by-Hop Identifier of req
+// is still in use on the connection, a fresh one is assigned.
+func (s *Server) Request(ctx context.Context, id message.PeerIdentity, req *message.DiameterMessage) (*message.DiameterMessage, error) {
+	s.mu.Lock()
+	p, ok := s.peers[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, &message.PeerError{Identity: id, Op: "request", Err: ErrUnknownPeer}
+	}
+
+	ch, err := p.pending.Reserve(req, s.idGenerator.HopByHopID, s.requestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	hopByHopID := req.Header.HopByHopID
+	if err := p.WriteMessage(req); err != nil {
+		p.pending.Remove(hopByHopID)
+		return nil, &message.PeerError{Identity: id, Op: "request", Err: err}
+	}
+	select {
+	case r := <-ch:
+		if errors.Is(r.Err, pending.ErrTimeout) {
+			s.timedOutRequests.Add(1)
+		}
+		return r.Answer, r.Err
+	case <-ctx.Done():
+		p.pending.Remove(hopByHopID)
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,191 @@
+// Proxy mode forwarding requests with rewriting hooks
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+// ProxyHook transforms req, a copy of a request received from the peer
+// from, and chooses the peer it is forwarded to. It may modify and return
+// req or return another message; nil forwards req. An error is answered
+// to from with the Result-Code chosen by message.ResultCodeForError.
+type ProxyHook func(req *message.DiameterMessage, from message.PeerIdentity) (*message.DiameterMessage, message.PeerIdentity, error)
+
+// ProxyAnswerHook transforms the answer returned to the peer to, for
+// instance to undo the rewriting of its request. An error is answered to
+// to instead.
+type ProxyAnswerHook func(ans *message.DiameterMessage, to message.PeerIdentity) (*message.DiameterMessage, error)
+
+// Forwarder sends req to the peer with identity id and returns its
+// answer. Server.Request is the default, forwarding to the peers
+// connected to the server.
+type Forwarder func(ctx context.Context, id message.PeerIdentity, req *message.DiameterMessage) (*message.DiameterMessage, error)
+
+// ProxyOption configures a Proxy.
+type ProxyOption func(*Proxy)
+
+// WithProxyAnswerHook sets the hook applied to answers before they are
+// returned.
+func WithProxyAnswerHook(hook ProxyAnswerHook) ProxyOption {
+	return func(p *Proxy) {
+		p.answerHook = hook
+	}
+}
+
+// WithForwarder forwards requests with fn, for instance through a
+// client.Pool connected to the next hops, instead of Server.Request.
+func WithForwarder(fn Forwarder) ProxyOption {
+	return func(p *Proxy) {
+		p.forward = fn
+	}
+}
+
+// WithProxyTimeout bounds the time to wait for the answer of the next
+// hop. Without it the request timeout of the forwarder applies.
+func WithProxyTimeout(timeout time.Duration) ProxyOption {
+	return func(p *Proxy) {
+		p.timeout = timeout
+	}
+}
+
+// Proxy is a Handler forwarding the requests it serves to a peer chosen,
+// and possibly rewritten, by a ProxyHook, as a proxy agent of RFC 6733
+// section 2.8.2 does. The Proxy assigns the forwarded request a new
+// Hop-by-Hop Identifier, adds a Route-Record with the identity of the
+// peer it came from and a Proxy-Info whose Proxy-State correlates the
+// answer, and removes that Proxy-Info from the answer before returning
+// it with the identifiers of the original request.
+type Proxy struct {
+	server     *Server
+	hook       ProxyHook
+	answerHook ProxyAnswerHook
+	forward    Forwarder
+	timeout    time.Duration
+	tokens     atomic.Uint64
+}
+
+// NewProxy returns a Proxy forwarding requests as chosen by hook. It
+// serves the commands it is registered for with Handle.
+func (s *Server) NewProxy(hook ProxyHook, opts ...ProxyOption) *Proxy {
+	p := &Proxy{server: s, hook: hook, forward: s.Request}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+func (p *Proxy) ServeDiameter(w ResponseWriter, req *message.DiameterMessage) {
+	s := p.server
+	from := peerIdentity(w)
+	ans, err := p.relay(req, from)
+	if err != nil {
+		log.Printf("Error proxying %s from %s: %v", req.CommandName(), from, err)
+		if ans, err = s.ErrorAnswer(req, err); err != nil {
+			log.Printf("Error creating answer to %s: %v", req.CommandName(), err)
+			return
+		}
+	}
+	if err := w.WriteMessage(ans); err != nil {
+		log.Printf("Error sending %s to %s: %v", ans.CommandName(), from, err)
+	}
+}
+
+// relay forwards req, received from the peer from, and returns the
+// answer to send back.
+func (p *Proxy) relay(req *message.DiameterMessage, from message.PeerIdentity) (*message.DiameterMessage, error) {
+	self := p.server.LocalIdentity()
+	for _, host := range message.RouteRecords(req) {
+		if message.NewPeerIdentity(host, "").Host == self.Host {
+			return nil, &message.ProtocolError{ResultCode: message.DIAMETER_LOOP_DETECTED}
+		}
+	}
+	clone := req.Clone()
+	fwd, next, err := p.hook(clone, from)
+	if err != nil {
+		return nil, err
+	}
+	if fwd == nil {
+		fwd = clone
+	}
+	token := binary.BigEndian.AppendUint64(nil, p.tokens.Add(1))
+	if err := p.prepare(fwd, from, token); err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	ans, err := p.forward(ctx, next, fwd)
+	if err != nil {
+		return nil, &message.ProtocolError{
+			ResultCode: message.DIAMETER_UNABLE_TO_DELIVER,
+			Err:        fmt.Errorf("forwarding to %s: %w", next, err),
+		}
+	}
+	if !p.removeProxyInfo(ans, token) {
+		log.Printf("Answer to %s from %s lost the Proxy-Info of the request", req.CommandName(), next)
+	}
+	ans.Header.HopByHopID = req.Header.HopByHopID
+	ans.Header.EndToEndID = req.Header.EndToEndID
+	if p.answerHook == nil {
+		return ans, nil
+	}
+	return p.answerHook(ans, from)
+}
+
+// prepare gives fwd, the request received from the peer from, a new
+// Hop-by-Hop Identifier, a Route-Record and a Proxy-Info carrying token.
+func (p *Proxy) prepare(fwd *message.DiameterMessage, from message.PeerIdentity, token []byte) error {
+	s := p.server
+	fwd.Header.HopByHopID = s.idGenerator.HopByHopID()
+	if !from.IsZero() {
+		if err := message.AddRouteRecord(fwd, from.Host); err != nil {
+			return err
+		}
+	}
+	info, err := message.NewProxyInfoAVP(message.ProxyInfo{Host: s.originHost, State: token})
+	if err != nil {
+		return err
+	}
+	fwd.AVPs = append(fwd.AVPs, info)
+	return nil
+}
+
+// removeProxyInfo removes the Proxy-Info carrying token from ans and
+// reports whether it was there.
+func (p *Proxy) removeProxyInfo(ans *message.DiameterMessage, token []byte) bool {
+	host := p.server.LocalIdentity().Host
+	for i, avp := range ans.AVPs {
+		info, ok := message.ParseProxyInfo(avp)
+		if ok && message.NewPeerIdentity(info.Host, "").Host == host && bytes.Equal(info.State, token) {
+			ans.AVPs = append(ans.AVPs[:i:i], ans.AVPs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// peerIdentity returns the identity of the peer w answers.
+func peerIdentity(w ResponseWriter) message.PeerIdentity {
+	switch v := w.(type) {
+	case *peer:
+		return v.getIdentity()
+	case *cachingWriter:
+		return v.peer.getIdentity()
+	case *exchange:
+		return v.p.getIdentity()
+	case *validatingWriter:
+		return v.peer.getIdentity()
+	}
+	return message.PeerIdentity{}
+}
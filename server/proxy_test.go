@@ -0,0 +1,146 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+)
+
+// proxyNode is the identity of the proxy in front of the server.
+var proxyNode = message.Node{OriginHost: "proxy.example.com", OriginRealm: "example.com"}
+
+// recordingHandler answers every request with DIAMETER_SUCCESS after
+// sending a copy of it on reqs.
+func recordingHandler(reqs chan<- *message.DiameterMessage) server.HandlerFunc {
+	return func(w server.ResponseWriter, req *message.DiameterMessage) {
+		reqs <- req.Clone()
+		answerSuccess(w, req)
+	}
+}
+
+// TestProxyRewrite forwards a CCR through a proxy rewriting its
+// Destination-Realm on the way in and hiding the Origin-Host of the
+// server on the way out, and checks what the server and the client see.
+func TestProxyRewrite(t *testing.T) {
+	downstream, downstreamAddr := startServer(t)
+	reqs := make(chan *message.DiameterMessage, 1)
+	downstream.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, recordingHandler(reqs))
+	next := connectClient(t, downstreamAddr, proxyNode.OriginHost)
+
+	s, addr := startServer(t, server.WithOriginHost(proxyNode.OriginHost))
+	// The peers the hooks are called for.
+	hookFrom, answerTo := make(chan message.PeerIdentity, 1), make(chan message.PeerIdentity, 1)
+	proxy := s.NewProxy(func(req *message.DiameterMessage, from message.PeerIdentity) (*message.DiameterMessage, message.PeerIdentity, error) {
+		hookFrom <- from
+		req.GetAVP(message.AVP_DESTINATION_REALM).Data = &message.DiameterIdentity{Data: "home.example.net"}
+		return nil, next.PeerIdentity(), nil
+	},
+		server.WithForwarder(func(ctx context.Context, id message.PeerIdentity, req *message.DiameterMessage) (*message.DiameterMessage, error) {
+			return next.Request(ctx, req)
+		}),
+		server.WithProxyAnswerHook(func(ans *message.DiameterMessage, to message.PeerIdentity) (*message.DiameterMessage, error) {
+			answerTo <- to
+			ans.GetAVP(message.AVP_ORIGIN_HOST).Data = &message.DiameterIdentity{Data: proxyNode.OriginHost}
+			return ans, nil
+		}))
+	s.Handle(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, proxy)
+
+	c := connectClient(t, addr, "client.example.com")
+	req := newCCR(t, c, "client.example.com;1;1")
+	ans := request(t, c, req)
+
+	fwd := <-reqs
+	if realm, _ := fwd.GetAVP(message.AVP_DESTINATION_REALM).Str(); realm != "home.example.net" {
+		t.Errorf("server got Destination-Realm %q, want the rewritten one", realm)
+	}
+	if realm, _ := req.GetAVP(message.AVP_DESTINATION_REALM).Str(); realm != serverNode.OriginRealm {
+		t.Errorf("rewriting changed the request of the client to %q", realm)
+	}
+	if records := message.RouteRecords(fwd); !slices.Equal(records, []string{"client.example.com"}) {
+		t.Errorf("server got Route-Records %q, want the client", records)
+	}
+	infos := message.ExtractProxyInfo(fwd)
+	if len(infos) != 1 {
+		t.Fatalf("server got %d Proxy-Info AVPs, want 1", len(infos))
+	}
+	if info, ok := message.ParseProxyInfo(infos[0]); !ok || info.Host != proxyNode.OriginHost || len(info.State) == 0 {
+		t.Errorf("server got Proxy-Info %+v, want one of the proxy with a state", info)
+	}
+	if id, _ := message.GetSessionID(fwd); id != "client.example.com;1;1" {
+		t.Errorf("server got Session-Id %q", id)
+	}
+	client := message.NewPeerIdentity("client.example.com", "example.com")
+	if from, to := <-hookFrom, <-answerTo; from != client || to != client {
+		t.Errorf("hooks called for %v and %v, want %v", from, to, client)
+	}
+
+	if code, _, _ := message.GetResultCode(ans); code != message.DIAMETER_SUCCESS {
+		t.Errorf("client got %v", code)
+	}
+	if host, _ := message.GetOriginHost(ans); host != proxyNode.OriginHost {
+		t.Errorf("client got Origin-Host %q, want the one set by the answer hook", host)
+	}
+	if infos := message.ExtractProxyInfo(ans); len(infos) != 0 {
+		t.Errorf("client got the Proxy-Info of the proxy")
+	}
+	if ans.Header.HopByHopID != req.Header.HopByHopID || ans.Header.EndToEndID != req.Header.EndToEndID {
+		t.Errorf("client got identifiers %#x/%#x, want those of its request %#x/%#x", ans.Header.HopByHopID, ans.Header.EndToEndID, req.Header.HopByHopID, req.Header.EndToEndID)
+	}
+}
+
+// TestProxyErrors checks the answers of a proxy that cannot forward a
+// request.
+func TestProxyErrors(t *testing.T) {
+	echo := func(ctx context.Context, id message.PeerIdentity, req *message.DiameterMessage) (*message.DiameterMessage, error) {
+		return serverNode.BuildAnswer(req, message.DIAMETER_SUCCESS)
+	}
+	forward := func(req *message.DiameterMessage, from message.PeerIdentity) (*message.DiameterMessage, message.PeerIdentity, error) {
+		return nil, message.NewPeerIdentity(serverNode.OriginHost, serverNode.OriginRealm), nil
+	}
+	for _, tc := range []struct {
+		name    string
+		hook    server.ProxyHook
+		forward server.Forwarder
+		// routed is the host of a Route-Record already in the request.
+		routed string
+		want   message.ResultCode
+	}{
+		{name: "forwarded", hook: forward, forward: echo, want: message.DIAMETER_SUCCESS},
+		{name: "loop", hook: forward, forward: echo, routed: "Proxy.Example.com", want: message.DIAMETER_LOOP_DETECTED},
+		{
+			name: "hook error",
+			hook: func(req *message.DiameterMessage, from message.PeerIdentity) (*message.DiameterMessage, message.PeerIdentity, error) {
+				return nil, message.PeerIdentity{}, &message.ProtocolError{ResultCode: message.DIAMETER_REALM_NOT_SERVED}
+			},
+			forward: echo,
+			want:    message.DIAMETER_REALM_NOT_SERVED,
+		},
+		{
+			name: "next hop down",
+			hook: forward,
+			forward: func(ctx context.Context, id message.PeerIdentity, req *message.DiameterMessage) (*message.DiameterMessage, error) {
+				return nil, errors.New("no connection")
+			},
+			want: message.DIAMETER_UNABLE_TO_DELIVER,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, addr := startServer(t, server.WithOriginHost(proxyNode.OriginHost))
+			s.Handle(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, s.NewProxy(tc.hook, server.WithForwarder(tc.forward)))
+			c := connectClient(t, addr, "client.example.com")
+			req := newCCR(t, c, "client.example.com;1;1")
+			if tc.routed != "" {
+				if err := message.AddRouteRecord(req, tc.routed); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if code, _, _ := message.GetResultCode(request(t, c, req)); code != tc.want {
+				t.Errorf("answered with %v, want %v", code, tc.want)
+			}
+		})
+	}
+}
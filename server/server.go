@@ -2,18 +2,929 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/IbrahimShahzad/diameter/clock"
+	"github.com/IbrahimShahzad/diameter/internal/observers"
+	"github.com/IbrahimShahzad/diameter/internal/ratelog"
+	"github.com/IbrahimShahzad/diameter/message"
 	fsm "github.com/IbrahimShahzad/diameter/state"
+	"github.com/IbrahimShahzad/diameter/stats"
+	"github.com/IbrahimShahzad/diameter/tap"
 	"github.com/IbrahimShahzad/diameter/transport"
 )
 
+type ServerOptionsFunc func(*ServerOptions)
+
+type ServerOptions struct {
+	serverAddr           string
+	protocol             transport.ProtocolType
+	connectionTimeout    time.Duration
+	watchdogTTL          time.Duration
+	clock                clock.Clock
+	originHost           string
+	originRealm          string
+	productName          string
+	vendorID             uint32
+	firmwareRevision     uint32
+	slowRequestThreshold time.Duration
+	redactedAVPs         []uint32
+	zeroCopyRequests     bool
+	applications         message.Applications
+	messageTap           tap.Func
+	orphanAnswerHandler  func(peer string, msg *message.DiameterMessage)
+	connEvents           func(peer string, ev transport.ConnEvent)
+	autoErrorAnswers     bool
+	maxMessageSize       int
+	socketOptions        transport.SocketOptions
+	idGenerator          message.IDGenerator
+	requestTimeout       time.Duration
+	duplicateStore       DuplicateStore
+	duplicateCacheSize   int
+	duplicateCacheTTL    time.Duration
+	writeBatch           transport.BatchOptions
+	writeTimeout         time.Duration
+	linger               time.Duration
+	peerConcurrency      int
+	orderedAnswers       bool
+	handlerTimeout       time.Duration
+	handlerTimeoutResult message.ResultCode
+	validateHostIP       HostIPMode
+	decodeOptions        message.DecodeOptions
+	originStateID        uint32
+	loadReporter         func() uint64
+	strictWatchdog       bool
+	duplicatePeerPolicy  DuplicatePeerPolicy
+	skipSelfTest         bool
+	tlsConfig            *tls.Config
+	tlsIdentity          transport.TLSIdentityMode
+	violationThreshold   int
+	violationDecay       time.Duration
+	quarantinePeriod     time.Duration
+	eventTimestamps      bool
+	maxSessions          int
+	maxPeerSessions      int
+	sessionIdleTimeout   time.Duration
+	statsDumpWriter      io.Writer
+	statsDumpInterval    time.Duration
+	statsDumpFormat      stats.Format
+	peerUpHandler        func(info PeerInfo)
+	resyncWindow         int
+	errorLogInterval     time.Duration
+	outboundValidation   message.ValidationMode
+}
+
+func defaultServerOptions() ServerOptions {
+	return ServerOptions{
+		serverAddr:           "localhost:3868",
+		protocol:             transport.Proto_TCP,
+		connectionTimeout:    0,
+		watchdogTTL:          30 * time.Second,
+		clock:                clock.Real,
+		originHost:           "localhost",
+		originRealm:          "localdomain",
+		productName:          message.DefaultProductName(),
+		firmwareRevision:     message.DefaultFirmwareRevision(),
+		autoErrorAnswers:     true,
+		maxMessageSize:       transport.DefaultMaxMessageSize,
+		requestTimeout:       30 * time.Second,
+		linger:               transport.DefaultLinger,
+		handlerTimeoutResult: message.DIAMETER_TOO_BUSY,
+		decodeOptions:        message.LenientDecodeOptions(),
+		errorLogInterval:     10 * time.Second,
+		outboundValidation:   message.ValidationWarn,
+		applications: message.Applications{
+			Auth: message.NewApplicationSet(),
+			Acct: message.NewApplicationSet(),
+		},
+	}
+}
+
+func WithServerAddr(serverAddr string) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.serverAddr = serverAddr
+	}
+}
+
+func WithSCTP() ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.protocol = transport.Proto_SCTP
+	}
+}
+
+func WithTCP() ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.protocol = transport.Proto_TCP
+	}
+}
+
+// WithConnectionTimeout sets the accept timeout of the listener. It
+// defaults to 0, meaning no timeout.
+func WithConnectionTimeout(timeout time.Duration) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.connectionTimeout = timeout
+	}
+}
+
+// WithWatchdogTTL sets the Device-Watchdog interval. It defaults to 30
+// seconds and must be at least MinWatchdogTTL.
+func WithWatchdogTTL(ttl time.Duration) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.watchdogTTL = ttl
+	}
+}
+
+// WithClock sets the clock used for all server timers and latency
+// measurements. It defaults to the real clock and is intended for tests.
+func WithClock(c clock.Clock) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.clock = c
+	}
+}
+
+// WithOriginHost sets the Origin-Host the server advertises.
+func WithOriginHost(host string) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.originHost = host
+	}
+}
+
+// WithOriginRealm sets the Origin-Realm the server advertises.
+func WithOriginRealm(realm string) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.originRealm = realm
+	}
+}
+
+// WithProductName sets the Product-Name sent in CEAs. It defaults to
+// message.DefaultProductName.
+func WithProductName(name string) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.productName = name
+	}
+}
+
+// WithFirmwareRevision sets the Firmware-Revision sent in CEAs. It
+// defaults to message.DefaultFirmwareRevision; 0 leaves the AVP out.
+func WithFirmwareRevision(revision uint32) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.firmwareRevision = revision
+	}
+}
+
+// WithVendorID sets the Vendor-Id sent in CEAs.
+func WithVendorID(vendorID uint32) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.vendorID = vendorID
+	}
+}
+
+// WithSlowRequestThreshold logs a dump of every request whose handler takes
+// longer than threshold. A threshold of 0 disables the slow-request log.
+func WithSlowRequestThreshold(threshold time.Duration) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.slowRequestThreshold = threshold
+	}
+}
+
+// WithRedactedAVPs lists AVP codes whose values are masked in the
+// slow-request log.
+func WithRedactedAVPs(codes ...uint32) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.redactedAVPs = append(o.redactedAVPs, codes...)
+	}
+}
+
+// WithZeroCopyRequests hands handlers the request decoded by the server
+// instead of a private copy. This saves an allocation per request but the
+// handler must then treat the request as read-only, since the slow-request
+// log reads the same message after the handler returns.
+func WithZeroCopyRequests() ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.zeroCopyRequests = true
+	}
+}
+
+// WithAuthApplications adds authentication applications advertised in
+// CEAs, on top of those derived from the registered handlers.
+func WithAuthApplications(ids ...uint32) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		for _, id := range ids {
+			o.applications.Auth[id] = struct{}{}
+		}
+	}
+}
+
+// WithAcctApplications adds accounting applications advertised in CEAs, on
+// top of those derived from the registered handlers.
+func WithAcctApplications(ids ...uint32) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		for _, id := range ids {
+			o.applications.Acct[id] = struct{}{}
+		}
+	}
+}
+
+// WithVendorSpecificApplication advertises a Vendor-Specific-Application-Id.
+func WithVendorSpecificApplication(vendorID, applicationID uint32, accounting bool) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.applications.VendorSpecific = append(o.applications.VendorSpecific, message.VendorApplication{
+			VendorID:      vendorID,
+			ApplicationID: applicationID,
+			Accounting:    accounting,
+		})
+	}
+}
+
+// WithAutoErrorAnswers controls whether requests for unknown commands or
+// applications are answered with DIAMETER_COMMAND_UNSUPPORTED (3001) and
+// DIAMETER_APPLICATION_UNSUPPORTED (3007). It is enabled by default; when
+// disabled such requests are logged and dropped.
+func WithAutoErrorAnswers(enabled bool) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.autoErrorAnswers = enabled
+	}
+}
+
+// WithMaxMessageSize sets the size of the pooled read buffers. Messages
+// larger than size are still accepted but use a dedicated allocation.
+func WithMaxMessageSize(size int) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.maxMessageSize = size
+	}
+}
+
+// WithTCPKeepalive enables TCP keepalive probes every interval so that
+// idle connections through firewalls are not silently dropped.
+func WithTCPKeepalive(interval time.Duration) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.socketOptions.KeepAlive = interval
+	}
+}
+
+// WithNoDelay enables or disables Nagle's algorithm on the connection.
+func WithNoDelay(noDelay bool) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.socketOptions.NoDelay = &noDelay
+	}
+}
+
+// WithSendBuffer sets the size of the socket send buffer in bytes.
+func WithSendBuffer(bytes int) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.socketOptions.SendBuffer = bytes
+	}
+}
+
+// WithReceiveBuffer sets the size of the socket receive buffer in bytes.
+func WithReceiveBuffer(bytes int) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.socketOptions.ReceiveBuffer = bytes
+	}
+}
+
+// WithIDGenerator sets the generator of the Hop-by-Hop and End-to-End
+// Identifiers of requests originated by the server. It defaults to a
+// generator seeded from the server clock.
+func WithIDGenerator(ids message.IDGenerator) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.idGenerator = ids
+	}
+}
+
+// WithRequestTimeout sets how long a request originated by the server
+// waits for its answer before it is swept from the pending table.
+func WithRequestTimeout(timeout time.Duration) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.requestTimeout = timeout
+	}
+}
+
+// WithPeerConcurrency limits the handlers running at once for requests from
+// one peer to n. Further requests wait for a slot without holding up the
+// connection, so watchdog traffic and answers to requests originated by
+// the server still flow. A handler that timed out keeps its slot until it
+// returns, while its request is answered on its behalf in its place in
+// the answer order; requests still waiting for a slot when their own
+// handler timeout expires are answered without running. It defaults to
+// 0, no limit.
+func WithPeerConcurrency(n int) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.peerConcurrency = n
+	}
+}
+
+// WithOrderedAnswers makes the answers of handlers to a peer go out in the
+// order its requests arrived, holding back answers that complete early.
+// Answers the server sends itself, to base protocol and rejected requests,
+// are not held back. A handler that does not answer within the handler
+// timeout, which defaults to the request timeout in this mode, is answered
+// for with DIAMETER_TOO_BUSY in its place.
+func WithOrderedAnswers(ordered bool) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.orderedAnswers = ordered
+	}
+}
+
+// WithHandlerTimeout sets how long a handler may take to answer, counted
+// from the arrival of the request. When it expires the request is answered
+// with the handler timeout result, unless automatic error answers are
+// disabled, and a later answer from the handler is discarded: its
+// WriteMessage fails with ErrAlreadyAnswered. It defaults to 0, no limit,
+// unless answers are ordered.
+func WithHandlerTimeout(timeout time.Duration) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.handlerTimeout = timeout
+	}
+}
+
+// WithHandlerTimeoutResult sets the Result-Code of the answer sent on
+// behalf of a handler that timed out. It defaults to DIAMETER_TOO_BUSY,
+// which lets the peer try another server; DIAMETER_UNABLE_TO_COMPLY is the
+// usual alternative.
+func WithHandlerTimeoutResult(code message.ResultCode) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.handlerTimeoutResult = code
+	}
+}
+
+// WithValidateHostIP sets how a CER whose Host-IP-Address AVPs do not
+// include the source address of the connection is treated. It defaults to
+// HostIPWarn, which only logs the mismatch.
+func WithValidateHostIP(mode HostIPMode) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.validateHostIP = mode
+	}
+}
+
+// WithDuplicatePeerPolicy sets what happens when a peer identity that is
+// already connected completes a capabilities exchange on another
+// connection. It defaults to DuplicatePeerAllow.
+func WithDuplicatePeerPolicy(policy DuplicatePeerPolicy) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.duplicatePeerPolicy = policy
+	}
+}
+
+// WithDuplicateCache enables duplicate request detection with an
+// in-memory cache of up to size answers kept for ttl. Retransmitted
+// requests, identified by Origin-Host and End-to-End Identifier, are
+// answered from the cache without running the handler again.
+func WithDuplicateCache(size int, ttl time.Duration) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.duplicateCacheSize = size
+		o.duplicateCacheTTL = ttl
+	}
+}
+
+// WithDuplicateStore enables duplicate request detection backed by store,
+// for instance to share the cache between several server instances.
+func WithDuplicateStore(store DuplicateStore) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.duplicateStore = store
+	}
+}
+
+// WithWriteBatching sets how outbound messages to a peer are coalesced into
+// a single write: up to maxBytes per write, waiting at most flushInterval
+// for further messages after the first. With a flushInterval of 0, the
+// default, only messages already queued are coalesced.
+func WithWriteBatching(maxBytes int, flushInterval time.Duration) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.writeBatch.MaxBytes = maxBytes
+		o.writeBatch.FlushInterval = flushInterval
+	}
+}
+
+// WithWriteTimeout bounds every write to a peer, so that a peer which stops
+// reading cannot hold its answers forever. It defaults to 0, no timeout.
+func WithWriteTimeout(timeout time.Duration) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.writeTimeout = timeout
+	}
+}
+
+// WithLinger sets how long the handlers still running for a peer that
+// closed its side of the connection get to send their answers before the
+// connection is closed. It defaults to transport.DefaultLinger; 0 closes
+// the connection at once.
+func WithLinger(linger time.Duration) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.linger = linger
+	}
+}
+
+// WithWriteFailureThreshold sets how many consecutive writes to a peer may
+// time out before its connection is dropped. A reset or broken connection
+// is dropped at once. It defaults to
+// transport.DefaultWriteFailureThreshold.
+func WithWriteFailureThreshold(n int) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.writeBatch.FailureThreshold = n
+	}
+}
+
+// WithDecodeOptions sets how messages from peers are decoded. The default
+// is message.LenientDecodeOptions, so that requests with bad AVP values
+// are answered with 5001/5004/5014 instead of dropping the connection.
+func WithDecodeOptions(opts message.DecodeOptions) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.decodeOptions = opts
+	}
+}
+
+// WithOriginStateID sets the Origin-State-Id sent in CEAs and DWAs. It
+// defaults to the server start time in seconds, so that peers notice a
+// restart.
+func WithOriginStateID(id uint32) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.originStateID = id
+	}
+}
+
+// WithTLS accepts connections over TLS with config, as RFC 6733 section
+// 2.1 expects on port 5868. Only TCP is supported.
+func WithTLS(config *tls.Config) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.tlsConfig = config
+	}
+}
+
+// WithTLSIdentityBinding compares the certificate of a client with the
+// Origin-Host of its CER. In TLSIdentityEnforce mode a client whose
+// certificate does not name it is answered with DIAMETER_UNKNOWN_PEER and
+// disconnected. The TLS configuration must request client certificates.
+func WithTLSIdentityBinding(mode transport.TLSIdentityMode) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.tlsIdentity = mode
+	}
+}
+
+// WithoutSelfTest stops ListenAndServe from running Validate before
+// listening.
+func WithoutSelfTest() ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.skipSelfTest = true
+	}
+}
+
+// WithStrictWatchdog answers a DWR whose Origin-Host differs from the one
+// announced in the peer's CER, or which lacks Origin-Host or Origin-Realm,
+// with DIAMETER_UNABLE_TO_COMPLY and closes the connection. Without it
+// such DWRs are only logged.
+func WithStrictWatchdog() ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.strictWatchdog = true
+	}
+}
+
+// WithLoadReporting includes an RFC 8583 Load AVP of Load-Type HOST in
+// the answers generated by the server, DWAs included, with the value
+// returned by fn at the time the answer is built. fn should return a
+// value between 0 (idle) and 65535 (fully loaded).
+func WithLoadReporting(fn func() uint64) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.loadReporter = fn
+	}
+}
+
+// WithMessageTap invokes fn for every frame received from or sent to any
+// peer. fn runs on a dedicated goroutine and never blocks the connections;
+// frames are dropped when it falls behind.
+func WithMessageTap(fn tap.Func) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.messageTap = fn
+	}
+}
+
+// WithOrphanAnswerHandler calls fn for every answer whose Hop-by-Hop
+// Identifier matches no outstanding request, such as an answer arriving
+// after its request timed out. fn runs on its own goroutine with a copy of
+// the answer; peer is the identity of the peer, or its address before the
+// capabilities exchange.
+func WithOrphanAnswerHandler(fn func(peer string, msg *message.DiameterMessage)) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.orphanAnswerHandler = fn
+	}
+}
+
+// WithPeerUpHandler calls fn every time a peer completes the capabilities
+// exchange, once its CEA has been sent, for instance to keep an inventory
+// of the software the peers run. fn runs on its own goroutine.
+func WithPeerUpHandler(fn func(info PeerInfo)) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.peerUpHandler = fn
+	}
+}
+
+// WithConnEventHandler subscribes accepted SCTP connections to
+// association and peer address change notifications, such as a failover
+// to another path of a multihomed peer, and calls fn for each. fn runs on
+// the read loop of the peer and must not block; peer is the identity of
+// the peer, or its address before the capabilities exchange. Where
+// transport.SCTPEventsSupported is false no events are delivered.
+func WithConnEventHandler(fn func(peer string, ev transport.ConnEvent)) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.connEvents = fn
+	}
+}
+
+// WithEventTimestamps adds an Event-Timestamp AVP with the current time
+// to every message sent to peers, answers and requests alike, unless it
+// already carries one or its command forbids it. The time is taken when
+// the message is queued for writing, so write batching delays it by at
+// most the flush interval. Replayed duplicate answers are sent as cached.
+func WithEventTimestamps(enabled bool) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.eventTimestamps = enabled
+	}
+}
+
+// WithViolationThreshold disconnects a peer whose protocol violations
+// reach threshold: messages that fail to decode, reserved or contradictory
+// flags, unknown AVPs marked mandatory and messages over the maximum
+// message size. Each violation counts one point and the score of a
+// connection drops by one point every decay interval. A peer past the
+// capabilities exchange gets a DPR with DO_NOT_WANT_TO_TALK_TO_YOU, other
+// connections are closed. It defaults to 0, no limit.
+func WithViolationThreshold(threshold int, decay time.Duration) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.violationThreshold = threshold
+		o.violationDecay = decay
+	}
+}
+
+// WithQuarantine rejects connections from the address, and CERs from the
+// identity, of a peer disconnected for protocol violations for cooldown.
+// It requires WithViolationThreshold.
+func WithQuarantine(cooldown time.Duration) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.quarantinePeriod = cooldown
+	}
+}
+
+// WithMaxSessions caps the sessions open at once, in total and with each
+// peer. A request that would open a session beyond a cap, as told by the
+// session rule of its command in the message dictionary, such as a CCR-I
+// or an ACR START, is answered with DIAMETER_RESOURCES_EXCEEDED without
+// reaching its handler. A session ends with its ending request, such as
+// a CCR-T, an ACR STOP or an STR, or when it expires, see
+// WithSessionIdleTimeout. Requests with Auth-Session-State set to
+// NO_STATE_MAINTAINED are not counted. A limit of 0 leaves that side
+// unbounded.
+func WithMaxSessions(global, perPeer int) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.maxSessions = global
+		o.maxPeerSessions = perPeer
+	}
+}
+
+// WithStreamResync makes the server look for the next message within
+// window bytes when a frame header read from a peer does not parse, such
+// as after a message with a wrong Message-Length, instead of closing the
+// connection at once. The messages skipped are lost. It defaults to 0,
+// no resynchronization.
+func WithStreamResync(window int) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.resyncWindow = window
+	}
+}
+
+// WithErrorLogInterval logs an error of a kind, such as failing to decode
+// a message, at most once per interval for each peer, so that a peer in a
+// crash loop or sending junk cannot flood the logs. The lines left out are
+// counted in the SuppressedLogs of StatsSnapshot and reported with the
+// next line logged for that error. Peers are told apart by address, so
+// that reconnecting does not reset the limit. It defaults to 10 seconds; 0
+// logs every error.
+func WithErrorLogInterval(interval time.Duration) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.errorLogInterval = interval
+	}
+}
+
+// WithOutboundValidation checks the answers handlers write with
+// message.ValidateOutbound before they are sent, so that an answer
+// missing its Result-Code or carrying a request-only AVP is caught here
+// rather than by the peer. With message.ValidationWarn, the default, an
+// invalid answer is logged, counted in the InvalidAnswers of StatsSnapshot
+// and sent; with message.ValidationEnforce it is counted and not sent, and
+// WriteMessage returns an error wrapping ErrInvalidAnswer and the
+// message.ValidationError.
+func WithOutboundValidation(mode message.ValidationMode) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.outboundValidation = mode
+	}
+}
+
+// WithStatsDump writes StatsSnapshot to w in format every interval, and a
+// last time when Shutdown returns, for soak tests run without a metrics
+// system. Snapshots are written on a goroutine of their own; one due while
+// w is still busy with the previous one is dropped, and the number
+// dropped is reported in the next.
+func WithStatsDump(w io.Writer, interval time.Duration, format stats.Format) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.statsDumpWriter = w
+		o.statsDumpInterval = interval
+		o.statsDumpFormat = format
+	}
+}
+
+// WithSessionIdleTimeout expires the sessions counted by WithMaxSessions
+// that saw no request for timeout, for clients that never end them. It
+// defaults to 0, sessions never expire.
+func WithSessionIdleTimeout(timeout time.Duration) ServerOptionsFunc {
+	return func(o *ServerOptions) {
+		o.sessionIdleTimeout = timeout
+	}
+}
+
 type Server struct {
-	conn              *transport.DiameterConnection
-	fsm               *fsm.FSM
-	EventChan         chan fsm.Event
-	serverAddr        string
-	protocol          transport.ProtocolType
-	connectionTimeout time.Duration
-	watchdogTTL       time.Duration
+	ServerOptions
+	conn      *transport.DiameterConnection
+	fsm       *fsm.FSM
+	EventChan chan fsm.Event
+
+	mu       sync.Mutex
+	listener *transport.DiameterListener
+	conns    map[*peer]struct{}
+	peers    map[message.PeerIdentity]*peer
+	handlers map[handlerKey]Handler
+	// quarantined maps the addresses and identities of peers disconnected
+	// for protocol violations to the end of their quarantine.
+	quarantined map[string]time.Time
+	commands    *stats.Commands
+	tap         *tap.Tap
+	buffers     *transport.BufferPool
+	// dumper is nil unless WithStatsDump is set.
+	dumper *stats.Dumper
+	// duplicates is nil unless duplicate detection is enabled.
+	duplicates *duplicateCache
+	// sessions is nil unless session limits are set.
+	sessions *sessionTable
+	// shutdown is set, under mu, by Shutdown, after which no peer is
+	// accepted.
+	shutdown bool
+	// goroutines counts the goroutines of peers: read loops, with their
+	// writers, handlers and disconnects. Shutdown waits for it.
+	goroutines sync.WaitGroup
+	// inboundAnswers observes the answers received from peers.
+	inboundAnswers observers.List
+	// errorLog rate-limits the logging of errors repeated by a peer.
+	errorLog *ratelog.Limiter
+
+	orphanedAnswers      atomic.Uint64
+	timedOutRequests     atomic.Uint64
+	handlerTimeouts      atomic.Uint64
+	lateAnswers          atomic.Uint64
+	earlyMessages        atomic.Uint64
+	acceptErrors         atomic.Uint64
+	protocolViolations   atomic.Uint64
+	quarantineRejections atomic.Uint64
+	invalidAnswers       atomic.Uint64
+	writeBatches         transport.BatchStats
+}
+
+// NewServer creates a new Server instance with the provided options.
+func NewServer(opts ...ServerOptionsFunc) (*Server, error) {
+	o := defaultServerOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	if o.idGenerator == nil {
+		o.idGenerator = message.NewIDGenerator(o.clock)
+	}
+	if o.originStateID == 0 {
+		o.originStateID = uint32(o.clock.Now().Unix())
+	}
+	s := &Server{
+		ServerOptions: o,
+		conns:         make(map[*peer]struct{}),
+		peers:         make(map[message.PeerIdentity]*peer),
+		handlers:      make(map[handlerKey]Handler),
+		quarantined:   make(map[string]time.Time),
+		commands:      stats.NewCommands(),
+		buffers:       transport.NewBufferPool(o.maxMessageSize),
+	}
+	s.writeBatch.Stats = &s.writeBatches
+	s.errorLog = ratelog.New(o.errorLogInterval, o.clock)
+	if o.messageTap != nil {
+		s.tap = tap.New(o.messageTap, 0)
+	}
+	if o.statsDumpWriter != nil {
+		s.dumper = stats.NewDumper(o.statsDumpWriter, o.statsDumpInterval, o.statsDumpFormat, o.clock, s.StatsSnapshot)
+	}
+	switch {
+	case o.duplicateStore != nil:
+		s.duplicates = &duplicateCache{store: o.duplicateStore}
+	case o.duplicateCacheSize > 0:
+		s.duplicates = &duplicateCache{
+			store: newLRUDuplicateStore(o.clock, o.duplicateCacheSize, o.duplicateCacheTTL),
+		}
+	}
+	if o.maxSessions > 0 || o.maxPeerSessions > 0 {
+		s.sessions = newSessionTable(o.maxSessions, o.maxPeerSessions, o.sessionIdleTimeout)
+	}
+	return s, nil
+}
+
+// LocalIdentity returns the Origin-Host and Origin-Realm of the server.
+func (s *Server) LocalIdentity() message.PeerIdentity {
+	return message.NewPeerIdentity(s.originHost, s.originRealm)
+}
+
+// Addr returns the address the server was configured to listen on. See
+// ListenerAddrs for the addresses it is bound to.
+func (s *Server) Addr() string {
+	return s.serverAddr
+}
+
+// ListenerAddrs returns the addresses the server is bound to once Listen
+// has returned, including the port the system chose for a configured port
+// of 0, or nil before. An SCTP server has one per local address.
+func (s *Server) ListenerAddrs() []net.Addr {
+	s.mu.Lock()
+	listener := s.listener
+	s.mu.Unlock()
+	if listener == nil {
+		return nil
+	}
+	return listener.Addrs()
+}
+
+// Bounds of the delay before accepting again after a temporary error.
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+// ListenAndServe calls Listen and then Serve.
+func (s *Server) ListenAndServe() error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+// Listen opens the listener on the configured address without accepting
+// connections yet, so that ListenerAddrs reports the port chosen for an
+// address with port 0 before Serve starts. Unless WithoutSelfTest is set,
+// a configuration that fails Validate is reported before the listener is
+// opened.
+func (s *Server) Listen() error {
+	s.mu.Lock()
+	listening, shutdown := s.listener != nil, s.shutdown
+	s.mu.Unlock()
+	switch {
+	case shutdown:
+		return ErrServerClosed
+	case listening:
+		return ErrAlreadyListening
+	}
+	if !s.skipSelfTest {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+	var listener *transport.DiameterListener
+	var err error
+	switch {
+	case s.tlsConfig != nil:
+		listener, err = transport.NewDiameterTLSListener(s.serverAddr, s.tlsConfig, s.connectionTimeout)
+	case s.connEvents != nil:
+		listener, err = transport.NewDiameterSCTPEventListener(s.serverAddr, s.connectionTimeout)
+	default:
+		listener, err = transport.NewDiameterListener(s.serverAddr, s.protocol, s.connectionTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown || s.listener != nil {
+		listener.Close()
+		if s.shutdown {
+			return ErrServerClosed
+		}
+		return ErrAlreadyListening
+	}
+	s.listener = listener
+	log.Printf("Listening on %s", listener.Addr())
+	return nil
+}
+
+// Serve accepts connections on the listener opened by Listen and serves
+// them until the listener fails or the server is shut down. Expired
+// accept timeouts are ignored, and temporary errors such as running out
+// of file descriptors are retried with a growing delay, as net/http does.
+func (s *Server) Serve() error {
+	s.mu.Lock()
+	listener := s.listener
+	s.mu.Unlock()
+	if listener == nil {
+		return ErrNotListening
+	}
+	return s.serve(listener)
+}
+
+// acceptor is the part of a listener Serve uses.
+type acceptor interface {
+	Accept() (*transport.DiameterConnection, error)
+}
+
+// serve runs the accept loop of Serve on listener.
+func (s *Server) serve(listener acceptor) error {
+	var delay time.Duration
+	for {
+		conn, err := listener.Accept()
+		switch {
+		case err == nil:
+			delay = 0
+			if !s.goPeer(conn) {
+				conn.Close()
+			}
+		case transport.IsAcceptTimeout(err):
+		case transport.IsTemporary(err):
+			delay = min(max(2*delay, minAcceptDelay), maxAcceptDelay)
+			s.acceptErrors.Add(1)
+			log.Printf("Error accepting connection: %v; retrying in %v", err, delay)
+			<-s.clock.After(delay)
+		default:
+			return err
+		}
+	}
+}
+
+// goPeer serves conn on a goroutine accounted for by Shutdown. It
+// returns false once the server is shutting down.
+func (s *Server) goPeer(conn *transport.DiameterConnection) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown {
+		return false
+	}
+	s.goroutines.Add(1)
+	go func() {
+		defer s.goroutines.Done()
+		s.handlePeer(conn)
+	}()
+	return true
+}
+
+// spawn runs fn on a goroutine accounted for by Shutdown. It is only
+// called from goroutines that are accounted for themselves, so the count
+// cannot drop to zero in between.
+func (s *Server) spawn(fn func()) {
+	s.goroutines.Add(1)
+	go func() {
+		defer s.goroutines.Done()
+		fn()
+	}()
+}
+
+// Shutdown closes the listener and every peer connection, cancelling the
+// context of each peer, then waits until the goroutines of the peers,
+// handlers included, have returned or ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shutdown = true
+	listener := s.listener
+	peers := make([]*peer, 0, len(s.conns))
+	for p := range s.conns {
+		peers = append(peers, p)
+	}
+	s.mu.Unlock()
+
+	var err error
+	if listener != nil {
+		err = listener.Close()
+	}
+	for _, p := range peers {
+		p.cancel()
+		p.conn.Close()
+	}
+	done := make(chan struct{})
+	go func() {
+		s.goroutines.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return errors.Join(err, ctx.Err())
+	}
+	s.tap.Close()
+	s.dumper.Stop()
+	return err
 }
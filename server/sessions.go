@@ -0,0 +1,135 @@
+// Session limits
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/stats"
+)
+
+// sessionTable counts the sessions open with each peer, as told by the
+// session rules of the command dictionary, to enforce WithMaxSessions.
+type sessionTable struct {
+	max, maxPerPeer int
+	idle            time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*sessionEntry
+	perPeer  map[message.PeerIdentity]int
+	rejected uint64
+}
+
+type sessionEntry struct {
+	peer message.PeerIdentity
+	seen time.Time
+}
+
+func newSessionTable(max, maxPerPeer int, idle time.Duration) *sessionTable {
+	return &sessionTable{
+		max:        max,
+		maxPerPeer: maxPerPeer,
+		idle:       idle,
+		sessions:   make(map[string]*sessionEntry),
+		perPeer:    make(map[message.PeerIdentity]int),
+	}
+}
+
+// admit records the effect of req, received from peer, on its session and
+// reports whether it may be handled. Only a request opening a session
+// beyond a cap is refused; sessions idle for too long are expired first.
+// Stateless requests keep no session and are left out.
+func (t *sessionTable) admit(peer message.PeerIdentity, req *message.DiameterMessage, now time.Time) bool {
+	role := message.RequestSessionRole(req)
+	if role == message.SessionNone || req.IsStateless() {
+		return true
+	}
+	id, err := message.GetSessionID(req)
+	if err != nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.sessions[id]
+	switch {
+	case role == message.SessionEnd:
+		if ok {
+			t.remove(id, e)
+		}
+		return true
+	case ok:
+		e.seen = now
+		return true
+	case role != message.SessionOpen:
+		return true
+	}
+	if t.full(peer) {
+		t.expire(now)
+		if t.full(peer) {
+			t.rejected++
+			return false
+		}
+	}
+	t.sessions[id] = &sessionEntry{peer: peer, seen: now}
+	t.perPeer[peer]++
+	return true
+}
+
+func (t *sessionTable) full(peer message.PeerIdentity) bool {
+	return (t.max > 0 && len(t.sessions) >= t.max) ||
+		(t.maxPerPeer > 0 && t.perPeer[peer] >= t.maxPerPeer)
+}
+
+// expire removes the sessions idle for longer than the idle timeout.
+func (t *sessionTable) expire(now time.Time) {
+	if t.idle <= 0 {
+		return
+	}
+	for id, e := range t.sessions {
+		if now.Sub(e.seen) > t.idle {
+			t.remove(id, e)
+		}
+	}
+}
+
+func (t *sessionTable) remove(id string, e *sessionEntry) {
+	delete(t.sessions, id)
+	if t.perPeer[e.peer]--; t.perPeer[e.peer] <= 0 {
+		delete(t.perPeer, e.peer)
+	}
+}
+
+// peerSessions returns the number of sessions open with peer.
+func (t *sessionTable) peerSessions(peer message.PeerIdentity) int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.perPeer[peer]
+}
+
+func (t *sessionTable) stats() *stats.SessionStats {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &stats.SessionStats{Active: len(t.sessions), Rejected: t.rejected}
+}
+
+// admitSession answers a request that would open a session beyond the
+// limits of WithMaxSessions with DIAMETER_RESOURCES_EXCEEDED and reports
+// whether it may be handled.
+func (s *Server) admitSession(p *peer, req *message.DiameterMessage) bool {
+	if s.sessions == nil || s.sessions.admit(p.getIdentity(), req, s.clock.Now()) {
+		return true
+	}
+	log.Printf("Session limit reached, rejecting %s from %s", req.CommandName(), p.addr)
+	if err := s.answer(p, req, message.DIAMETER_RESOURCES_EXCEEDED); err != nil {
+		log.Printf("Error sending %s answer to %s: %v", message.DIAMETER_RESOURCES_EXCEEDED, p.addr, err)
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package server_test
+
+import (
+	"bufio"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/client"
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+)
+
+// TestStatelessSessions opens sessions with stateless and stateful CCR-Is
+// and checks that only the stateful ones are counted against the limit.
+func TestStatelessSessions(t *testing.T) {
+	s, addr := startServer(t, server.WithMaxSessions(1, 0))
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, answerSuccess)
+	conn := dialRaw(t, addr)
+	r := bufio.NewReader(conn)
+	initial := message.MustNewAVP(message.AVP_CC_REQUEST_TYPE, message.INITIAL_REQUEST, message.MANDATORY_FLAG)
+	stateless := message.MustNewAVP(message.AVP_AUTH_SESSION_STATE, message.NO_STATE_MAINTAINED, message.MANDATORY_FLAG)
+	open := func(session string, extra ...*message.AVP) message.ResultCode {
+		t.Helper()
+		writeMessage(t, conn, rawRequest(t, message.COMMAND_CODE_CREDIT_CONTROL, message.APPLICATION_ID_CREDIT_CONTROL, session, append(extra, initial)...))
+		code, _, err := message.GetResultCode(readMessage(t, conn, r))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+	active := func() int {
+		return s.StatsSnapshot().Sessions.Active
+	}
+
+	for _, session := range []string{"client.example.com;1;1", "client.example.com;1;2"} {
+		if code := open(session, stateless); code != message.DIAMETER_SUCCESS {
+			t.Fatalf("stateless %s answered %d", session, code)
+		}
+	}
+	if n := active(); n != 0 {
+		t.Errorf("%d sessions tracked after stateless requests, want 0", n)
+	}
+
+	if code := open("client.example.com;1;3"); code != message.DIAMETER_SUCCESS {
+		t.Fatalf("stateful session answered %d", code)
+	}
+	if n := active(); n != 1 {
+		t.Errorf("%d sessions tracked after a stateful request, want 1", n)
+	}
+	if code := open("client.example.com;1;4"); code != message.DIAMETER_RESOURCES_EXCEEDED {
+		t.Errorf("stateful session beyond the limit answered %d", code)
+	}
+	if code := open("client.example.com;1;5", stateless); code != message.DIAMETER_SUCCESS {
+		t.Errorf("stateless session at the limit answered %d", code)
+	}
+}
+
+// ccrOf returns a CCR of c for session with the CC-Request-Type
+// requestType.
+func ccrOf(t *testing.T, c *client.Client, session string, requestType uint32) *message.DiameterMessage {
+	t.Helper()
+	req := newCCR(t, c, session)
+	req.AVPs = append(req.AVPs, message.MustNewAVP(message.AVP_CC_REQUEST_TYPE, requestType, message.MANDATORY_FLAG))
+	return req
+}
+
+// TestSessionLimits opens sessions from two peers up to the limit of
+// each peer and then the global one, and checks that the sessions beyond
+// are rejected until others end.
+func TestSessionLimits(t *testing.T) {
+	s, addr := startServer(t, server.WithMaxSessions(3, 2))
+	var handled atomic.Int32
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, func(w server.ResponseWriter, req *message.DiameterMessage) {
+		handled.Add(1)
+		answerSuccess(w, req)
+	})
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_SESSION_TERMINATION, answerSuccess)
+	c1 := connectClient(t, addr, "client1.example.com")
+	c2 := connectClient(t, addr, "client2.example.com")
+	send := func(c *client.Client, req *message.DiameterMessage) message.ResultCode {
+		t.Helper()
+		code, _, err := message.GetResultCode(request(t, c, req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+	check := func(when string, peer1, peer2, active int, rejected uint64) {
+		t.Helper()
+		for _, p := range []struct {
+			c    *client.Client
+			want int
+		}{{c1, peer1}, {c2, peer2}} {
+			if info, ok := s.PeerInfo(p.c.LocalIdentity()); !ok || info.Sessions != p.want {
+				t.Errorf("%s: %d sessions with %s, want %d", when, info.Sessions, p.c.LocalIdentity().Host, p.want)
+			}
+		}
+		if got := s.StatsSnapshot().Sessions; got == nil || got.Active != active || got.Rejected != rejected {
+			t.Errorf("%s: session stats %+v, want %d active and %d rejected", when, got, active, rejected)
+		}
+	}
+
+	for _, session := range []string{"client1.example.com;1;1", "client1.example.com;1;2"} {
+		if code := send(c1, ccrOf(t, c1, session, message.INITIAL_REQUEST)); code != message.DIAMETER_SUCCESS {
+			t.Fatalf("CCR-I of %s answered %v", session, code)
+		}
+	}
+	if code := send(c1, ccrOf(t, c1, "client1.example.com;1;3", message.INITIAL_REQUEST)); code != message.DIAMETER_RESOURCES_EXCEEDED {
+		t.Errorf("CCR-I beyond the limit of the peer answered %v", code)
+	}
+	// The sessions already open carry on.
+	if code := send(c1, ccrOf(t, c1, "client1.example.com;1;1", message.UPDATE_REQUEST)); code != message.DIAMETER_SUCCESS {
+		t.Errorf("CCR-U at the limit answered %v", code)
+	}
+	if code := send(c2, ccrOf(t, c2, "client2.example.com;1;1", message.INITIAL_REQUEST)); code != message.DIAMETER_SUCCESS {
+		t.Fatalf("CCR-I of the other peer answered %v", code)
+	}
+	if code := send(c2, ccrOf(t, c2, "client2.example.com;1;2", message.INITIAL_REQUEST)); code != message.DIAMETER_RESOURCES_EXCEEDED {
+		t.Errorf("CCR-I beyond the global limit answered %v", code)
+	}
+	check("at the limits", 2, 1, 3, 2)
+	if n := handled.Load(); n != 4 {
+		t.Errorf("%d CCRs handled, want the 4 admitted", n)
+	}
+
+	// A CCR-T and an STR end a session each, making room for others.
+	if code := send(c1, ccrOf(t, c1, "client1.example.com;1;2", message.TERMINATION_REQUEST)); code != message.DIAMETER_SUCCESS {
+		t.Fatalf("CCR-T answered %v", code)
+	}
+	str, err := c2.NewRequest(message.COMMAND_CODE_SESSION_TERMINATION, message.WithApplication(message.APPLICATION_ID_CREDIT_CONTROL), message.WithAVPs(newCCR(t, c2, "client2.example.com;1;1").AVPs...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := send(c2, str); code != message.DIAMETER_SUCCESS {
+		t.Fatalf("STR answered %v", code)
+	}
+	check("after the sessions ended", 1, 0, 1, 2)
+	for _, session := range []string{"client2.example.com;1;2", "client2.example.com;1;3"} {
+		if code := send(c2, ccrOf(t, c2, session, message.INITIAL_REQUEST)); code != message.DIAMETER_SUCCESS {
+			t.Errorf("CCR-I of %s once sessions ended answered %v", session, code)
+		}
+	}
+	check("after new sessions", 1, 2, 3, 2)
+}
+
+// TestSessionIdleTimeout checks that a session without requests for the
+// idle timeout no longer counts against the limit, and that requests of
+// a session keep it alive.
+func TestSessionIdleTimeout(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	s, addr := startServer(t, server.WithClock(clk), server.WithMaxSessions(1, 0), server.WithSessionIdleTimeout(time.Minute))
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, answerSuccess)
+	c := connectClient(t, addr, "client.example.com")
+	open := func(session string, requestType uint32) message.ResultCode {
+		t.Helper()
+		code, _, _ := message.GetResultCode(request(t, c, ccrOf(t, c, session, requestType)))
+		return code
+	}
+
+	if code := open("client.example.com;1;1", message.INITIAL_REQUEST); code != message.DIAMETER_SUCCESS {
+		t.Fatalf("first CCR-I answered %v", code)
+	}
+	clk.Advance(50 * time.Second)
+	if code := open("client.example.com;1;1", message.UPDATE_REQUEST); code != message.DIAMETER_SUCCESS {
+		t.Fatalf("CCR-U answered %v", code)
+	}
+	clk.Advance(50 * time.Second)
+	if code := open("client.example.com;1;2", message.INITIAL_REQUEST); code != message.DIAMETER_RESOURCES_EXCEEDED {
+		t.Errorf("CCR-I with the session updated 50s ago answered %v", code)
+	}
+	clk.Advance(11 * time.Second)
+	if code := open("client.example.com;1;2", message.INITIAL_REQUEST); code != message.DIAMETER_SUCCESS {
+		t.Errorf("CCR-I once the session expired answered %v", code)
+	}
+	if got := s.StatsSnapshot().Sessions; got.Active != 1 || got.Rejected != 1 {
+		t.Errorf("session stats %+v, want 1 active and 1 rejected", got)
+	}
+}
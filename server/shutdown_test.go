@@ -0,0 +1,110 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+)
+
+// serve starts a server on an ephemeral port that the test shuts down
+// itself, returning its address and the result of Serve.
+func serve(t *testing.T, opts ...server.ServerOptionsFunc) (*server.Server, string, <-chan error) {
+	t.Helper()
+	s, err := server.NewServer(append([]server.ServerOptionsFunc{
+		server.WithServerAddr("127.0.0.1:0"),
+		server.WithOriginHost(serverNode.OriginHost),
+		server.WithOriginRealm(serverNode.OriginRealm),
+		server.WithAuthApplications(message.APPLICATION_ID_CREDIT_CONTROL),
+	}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve() }()
+	return s, s.ListenerAddrs()[0].String(), served
+}
+
+// TestShutdownWaitsForHandlers checks that Shutdown returns only once the
+// handlers of the peers have, or when its context is done.
+func TestShutdownWaitsForHandlers(t *testing.T) {
+	s, addr, served := serve(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, func(w server.ResponseWriter, req *message.DiameterMessage) {
+		close(started)
+		<-release
+	})
+	c := connectClient(t, addr, "client.example.com")
+	go c.Request(context.Background(), newCCR(t, c, "client.example.com;1;1"))
+	select {
+	case <-started:
+	case <-time.After(testTimeout):
+		t.Fatal("handler not called")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown with a handler running = %v, want the deadline exceeded", err)
+	}
+	<-served
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+		done <- s.Shutdown(ctx)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned %v with a handler running", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case err := <-done:
+		if errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Shutdown = %v once the handler returned", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("Shutdown still waiting once the handler returned")
+	}
+}
+
+// TestShutdownLeaksNoGoroutines connects a peer that disconnects and
+// another that stays, then shuts the server down, and checks that no
+// goroutine is left running. The peers are raw connections, whose
+// goroutines would be counted otherwise.
+func TestShutdownLeaksNoGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	s, addr, served := serve(t)
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, answerSuccess)
+	apps := message.Applications{Auth: message.NewApplicationSet(message.APPLICATION_ID_CREDIT_CONTROL)}
+	for i, session := range []string{"client.example.com;1;1", "client.example.com;1;2"} {
+		conn, r, _ := exchangeCapabilities(t, addr, apps)
+		writeMessage(t, conn, rawRequest(t, message.COMMAND_CODE_CREDIT_CONTROL, message.APPLICATION_ID_CREDIT_CONTROL, session))
+		readMessage(t, conn, r)
+		if i == 0 {
+			conn.Close()
+			eventually(t, "the first peer to be removed", func() bool { return len(s.Peers()) == 0 })
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	<-served
+	if peers := s.Peers(); len(peers) != 0 {
+		t.Errorf("peers %v left after Shutdown", peers)
+	}
+	eventually(t, "the goroutines to return", func() bool { return runtime.NumGoroutine() <= before })
+}
@@ -0,0 +1,145 @@
+// Statistics and slow-request logging
+package server
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/stats"
+	"github.com/IbrahimShahzad/diameter/transport"
+)
+
+// StatsSnapshot returns the per-command statistics and the answer
+// correlation counters collected so far.
+func (s *Server) StatsSnapshot() stats.Snapshot {
+	return stats.Snapshot{
+		Timestamp:            s.clock.Now(),
+		Commands:             s.commandStats(),
+		OrphanedAnswers:      s.orphanedAnswers.Load(),
+		TimedOutRequests:     s.timedOutRequests.Load(),
+		HandlerTimeouts:      s.handlerTimeouts.Load(),
+		LateAnswers:          s.lateAnswers.Load(),
+		EarlyMessages:        s.earlyMessages.Load(),
+		AcceptErrors:         s.acceptErrors.Load(),
+		ProtocolViolations:   s.protocolViolations.Load(),
+		QuarantineRejections: s.quarantineRejections.Load(),
+		ObserverPanics:       s.inboundAnswers.Panics(),
+		SuppressedLogs:       s.errorLog.Suppressed(),
+		InvalidAnswers:       s.invalidAnswers.Load(),
+		DuplicateCache:       s.duplicates.stats(),
+		Sessions:             s.sessions.stats(),
+		WriteBatches:         writeBatchStats(&s.writeBatches),
+		Peers:                s.peerCounters(),
+	}
+}
+
+// peerCounters returns the traffic counters of every open connection,
+// labelled with the peer identity once the capabilities exchange is done
+// and with the transport address before.
+func (s *Server) peerCounters() []stats.PeerCounters {
+	s.mu.Lock()
+	peers := make([]*peer, 0, len(s.conns))
+	for p := range s.conns {
+		peers = append(peers, p)
+	}
+	s.mu.Unlock()
+	result := make([]stats.PeerCounters, 0, len(peers))
+	for _, p := range peers {
+		counters := p.counters.Snapshot()
+		counters.Peer = p.name()
+		result = append(result, counters)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Peer < result[j].Peer })
+	return result
+}
+
+// ResetCounters sets the traffic counters of every open connection back to
+// zero.
+func (s *Server) ResetCounters() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for p := range s.conns {
+		p.counters.Reset()
+	}
+}
+
+// commandStats returns the per-command statistics labelled with the
+// request name of each command.
+func (s *Server) commandStats() []stats.CommandStats {
+	commands := s.commands.Snapshot()
+	for i := range commands {
+		commands[i].Name = message.CommandName(commands[i].CommandCode, message.FlagRequest)
+	}
+	return commands
+}
+
+func writeBatchStats(s *transport.BatchStats) stats.WriteBatchStats {
+	batches, messages, bytes := s.Load()
+	return stats.WriteBatchStats{Batches: batches, Messages: messages, Bytes: bytes}
+}
+
+// logSlowRequest logs a dump of req with the configured AVPs redacted.
+func (s *Server) logSlowRequest(p *peer, req *message.DiameterMessage, elapsed time.Duration) {
+	redact := make(map[uint32]bool, len(s.redactedAVPs))
+	for _, code := range s.redactedAVPs {
+		redact[code] = true
+	}
+	log.Printf(
+		"Slow request from %s: %s application %d took %v (threshold %v)\n%s",
+		p.addr,
+		req.CommandName(),
+		req.Header.ApplicationID,
+		elapsed,
+		s.slowRequestThreshold,
+		redactedDump(req, redact),
+	)
+}
+
+// redactedDump renders msg like DiameterMessage.String, replacing the value
+// of every AVP whose code is in redact, at any nesting level.
+func redactedDump(msg *message.DiameterMessage, redact map[uint32]bool) string {
+	var b strings.Builder
+	b.WriteString("DiameterMessage{\nHeader: ")
+	b.WriteString(msg.Header.String())
+	b.WriteString("\nAVPs: ")
+	writeRedactedAVPs(&b, msg.AVPs, redact, "")
+	b.WriteString("}")
+	return b.String()
+}
+
+func writeRedactedAVPs(b *strings.Builder, avps []*message.AVP, redact map[uint32]bool, indent string) {
+	for _, avp := range avps {
+		var data string
+		switch d := avp.Data.(type) {
+		case *message.Grouped:
+			if redact[avp.Code] {
+				data = "<redacted>"
+				break
+			}
+			var inner strings.Builder
+			inner.WriteString("\n")
+			writeRedactedAVPs(&inner, d.AVPs, redact, indent+"  ")
+			data = inner.String() + indent
+		default:
+			if redact[avp.Code] {
+				data = "<redacted>"
+			} else {
+				data = avp.Data.String()
+			}
+		}
+		fmt.Fprintf(
+			b,
+			"%sAVP{Code: %d, Flags: %d, Length: %d, VendorID: %d, Data: %s}\n",
+			indent,
+			avp.Code,
+			avp.Flags,
+			avp.AVPlength,
+			avp.VendorID,
+			data,
+		)
+	}
+}
@@ -0,0 +1,334 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/client"
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+	"github.com/IbrahimShahzad/diameter/stats"
+)
+
+// delayHandler answers every CCR with DIAMETER_SUCCESS after advancing
+// clk by the number of milliseconds ending the Session-Id.
+func delayHandler(clk *fakeclock.Clock) server.HandlerFunc {
+	return func(w server.ResponseWriter, req *message.DiameterMessage) {
+		session, _ := message.GetSessionID(req)
+		ms, err := strconv.Atoi(session[strings.LastIndexByte(session, ';')+1:])
+		if err != nil {
+			panic(err)
+		}
+		clk.Advance(time.Duration(ms) * time.Millisecond)
+		answerSuccess(w, req)
+	}
+}
+
+// ccrStats returns the statistics of the CCRs handled by s.
+func ccrStats(t *testing.T, s *server.Server) stats.CommandStats {
+	t.Helper()
+	for _, c := range s.StatsSnapshot().Commands {
+		if c.ApplicationID == message.APPLICATION_ID_CREDIT_CONTROL && c.CommandCode == message.COMMAND_CODE_CREDIT_CONTROL {
+			return c
+		}
+	}
+	t.Fatal("no statistics for CCRs")
+	return stats.CommandStats{}
+}
+
+func TestCommandLatencyPercentiles(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	s, addr := startServer(t, server.WithClock(clk))
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, delayHandler(clk))
+	c := connectClient(t, addr, "client.example.com")
+
+	// Latencies of 1 to 100 milliseconds, in an order unrelated to their
+	// rank.
+	for i := range 100 {
+		ms := (i*37)%100 + 1
+		request(t, c, newCCR(t, c, "client.example.com;1;"+strconv.Itoa(ms)))
+	}
+
+	got := ccrStats(t, s)
+	if got.Requests != 100 || got.Latency.Count != 100 {
+		t.Errorf("%d requests, %d samples; want 100", got.Requests, got.Latency.Count)
+	}
+	for _, tc := range []struct {
+		name      string
+		got, want time.Duration
+	}{
+		{"min", got.Latency.Min, time.Millisecond},
+		{"max", got.Latency.Max, 100 * time.Millisecond},
+		{"mean", got.Latency.Mean, 50500 * time.Microsecond},
+		{"p50", got.Latency.P50, 50 * time.Millisecond},
+		{"p95", got.Latency.P95, 95 * time.Millisecond},
+		{"p99", got.Latency.P99, 99 * time.Millisecond},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s latency %v, want %v", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+// logBuffer collects log output written from any goroutine.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// captureLog redirects the standard logger to a logBuffer until the test
+// ends.
+func captureLog(t *testing.T) *logBuffer {
+	t.Helper()
+	b := &logBuffer{}
+	log.SetOutput(b)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return b
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestSlowRequestLog(t *testing.T) {
+	const secret = "alice@example.com"
+	logs := captureLog(t)
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	s, addr := startServer(t, server.WithClock(clk),
+		server.WithSlowRequestThreshold(50*time.Millisecond),
+		server.WithRedactedAVPs(message.AVP_USER_NAME))
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, delayHandler(clk))
+	c := connectClient(t, addr, "client.example.com")
+
+	for _, session := range []string{"client.example.com;1;10", "client.example.com;2;50", "client.example.com;3;60"} {
+		req := newCCR(t, c, session)
+		req.AVPs = append(req.AVPs, message.MustNewAVP(message.AVP_USER_NAME, secret, message.MANDATORY_FLAG))
+		request(t, c, req)
+	}
+
+	out := logs.String()
+	if n := strings.Count(out, "Slow request"); n != 1 {
+		t.Fatalf("%d slow requests logged, want 1:\n%s", n, out)
+	}
+	if !strings.Contains(out, "client.example.com;3;60") {
+		t.Errorf("the request over the threshold is not dumped:\n%s", out)
+	}
+	if !strings.Contains(out, ": Credit-Control-Request application 4 took") {
+		t.Errorf("the slow request is not logged by command name:\n%s", out)
+	}
+	if name := ccrStats(t, s).Name; name != "Credit-Control-Request" {
+		t.Errorf("CCR statistics labelled %q", name)
+	}
+	if strings.Contains(out, secret) || !strings.Contains(out, "<redacted>") {
+		t.Errorf("User-Name not redacted:\n%s", out)
+	}
+}
+
+// TestPeerCounters exchanges a known number of messages, with write
+// batching on both sides, and checks that the counters of the client and
+// of the server match them exactly.
+func TestPeerCounters(t *testing.T) {
+	const requests = 200
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	s, addr := startServer(t, server.WithWriteBatching(16*1024, time.Millisecond))
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, answerSuccess)
+	// The fake clock keeps the watchdog from adding DWRs to the count.
+	c := connectClient(t, addr, "client.example.com", client.WithClock(clk), client.WithWriteBatching(16*1024, time.Millisecond))
+
+	var wg sync.WaitGroup
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			request(t, c, newCCR(t, c, "client.example.com;1;"+strconv.Itoa(i)))
+		}()
+	}
+	wg.Wait()
+	unsupported, err := c.NewRequest(9999, message.WithAVPs(newCCR(t, c, "client.example.com;2;1").AVPs...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if ans, _ := c.Request(ctx, unsupported); ans == nil || !ans.Header.CommandFlags.Error() {
+		t.Fatalf("answer %v, want an error answer", ans)
+	}
+
+	// The CER and CEA are counted too.
+	const messages = requests + 2
+	got := c.PeerStatus().Counters
+	want := stats.PeerCounters{
+		MessagesIn: messages, MessagesOut: messages,
+		RequestsOut: messages, AnswersIn: messages, ErrorAnswersIn: 1,
+	}
+	check := func(side string, got, want stats.PeerCounters) {
+		t.Helper()
+		if got.MessagesIn != want.MessagesIn || got.MessagesOut != want.MessagesOut ||
+			got.RequestsIn != want.RequestsIn || got.RequestsOut != want.RequestsOut ||
+			got.AnswersIn != want.AnswersIn || got.AnswersOut != want.AnswersOut ||
+			got.ErrorAnswersIn != want.ErrorAnswersIn || got.ErrorAnswersOut != want.ErrorAnswersOut ||
+			got.ReadErrors != 0 || got.WriteErrors != 0 || got.Retransmissions != 0 {
+			t.Errorf("%s counters %+v, want %+v", side, got, want)
+		}
+		if got.LastReceived.IsZero() || got.LastSent.IsZero() {
+			t.Errorf("%s last activity not recorded: %+v", side, got)
+		}
+	}
+	check("client", got, want)
+	if batches := c.StatsSnapshot().WriteBatches; batches.Messages < requests || batches.Batches >= batches.Messages {
+		t.Errorf("client write batches %+v: the requests did not go through batching", batches)
+	}
+
+	var peer stats.PeerCounters
+	eventually(t, "the server counters", func() bool {
+		peers := s.StatsSnapshot().Peers
+		if len(peers) != 1 {
+			t.Fatalf("server reports %d peers, want 1", len(peers))
+		}
+		peer = peers[0]
+		return peer.MessagesOut == messages
+	})
+	check("server", peer, stats.PeerCounters{
+		MessagesIn: messages, MessagesOut: messages,
+		RequestsIn: messages, AnswersOut: messages, ErrorAnswersOut: 1,
+	})
+	if peer.BytesIn != got.BytesOut || peer.BytesOut != got.BytesIn {
+		t.Errorf("server received %d bytes and sent %d, client sent %d and received %d", peer.BytesIn, peer.BytesOut, got.BytesOut, got.BytesIn)
+	}
+	if id := c.LocalIdentity().String(); peer.Peer != id {
+		t.Errorf("server counters labelled %q, want %q", peer.Peer, id)
+	}
+
+	c.ResetCounters()
+	s.ResetCounters()
+	if got := c.PeerStatus().Counters; got != (stats.PeerCounters{}) {
+		t.Errorf("client counters %+v after reset", got)
+	}
+	if got := s.StatsSnapshot().Peers[0]; got != (stats.PeerCounters{Peer: peer.Peer}) {
+		t.Errorf("server counters %+v after reset", got)
+	}
+}
+
+// TestEventTimestamps checks that answers are stamped with the time they
+// are written, so that the handler latency the statistics report can be
+// read off the wire, and that nothing is stamped by default.
+func TestEventTimestamps(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+		s, addr := startServer(t, server.WithClock(clk), server.WithEventTimestamps(enabled))
+		s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, delayHandler(clk))
+		conn, r, cea := exchangeCapabilities(t, addr, message.Applications{Auth: message.NewApplicationSet(message.APPLICATION_ID_CREDIT_CONTROL)})
+		received := clk.Now()
+		// The handler takes 3 seconds, which the one second resolution of
+		// the Time type shows.
+		writeMessage(t, conn, rawRequest(t, message.COMMAND_CODE_CREDIT_CONTROL, message.APPLICATION_ID_CREDIT_CONTROL, "client.example.com;1;3000"))
+		cca := readMessage(t, conn, r)
+		if !enabled {
+			for _, msg := range []*message.DiameterMessage{cea, cca} {
+				if avp := msg.GetAVP(message.AVP_EVENT_TIMESTAMP); avp != nil {
+					t.Errorf("disabled: %s carries %v", msg.CommandName(), avp)
+				}
+			}
+			continue
+		}
+		if got, err := cea.GetAVP(message.AVP_EVENT_TIMESTAMP).Time(); err != nil || !got.Equal(received) {
+			t.Errorf("CEA Event-Timestamp %v, %v; want %v", got, err, received)
+		}
+		sent, err := cca.GetAVP(message.AVP_EVENT_TIMESTAMP).Time()
+		if err != nil {
+			t.Fatalf("CCA Event-Timestamp: %v", err)
+		}
+		// The latency is recorded once the handler returns, after the
+		// answer is written.
+		eventually(t, "the CCR latency", func() bool { return ccrStats(t, s).Latency.Count == 1 })
+		if wire, latency := sent.Sub(received), ccrStats(t, s).Latency.Max; wire != 3*time.Second || wire != latency {
+			t.Errorf("CCA stamped %v after the request, handler latency %v; want both 3s", wire, latency)
+		}
+	}
+}
+
+// dumpWriter sends every statistics dump written to it on the channel.
+type dumpWriter chan []byte
+
+func (w dumpWriter) Write(p []byte) (int, error) {
+	w <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+// next decodes the next dump written to w.
+func (w dumpWriter) next(t *testing.T) stats.Dump {
+	t.Helper()
+	var dump stats.Dump
+	select {
+	case b := <-w:
+		if err := json.Unmarshal(b, &dump); err != nil {
+			t.Fatalf("dump %q: %v", b, err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("no statistics dumped")
+	}
+	return dump
+}
+
+// TestStatsDump checks that a server dumps its statistics at every
+// interval of its clock and a last time on Shutdown.
+func TestStatsDump(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	w := make(dumpWriter, 4)
+	s, addr, served := serve(t, server.WithClock(clk), server.WithStatsDump(w, time.Minute, stats.FormatJSON))
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, answerSuccess)
+	c := connectClient(t, addr, "client.example.com")
+	request(t, c, newCCR(t, c, "client.example.com;1;1"))
+
+	clk.Advance(time.Minute)
+	dump := w.next(t)
+	if !dump.Timestamp.Equal(clk.Now()) || dump.Final {
+		t.Errorf("dump at %v, final %v; want one at the tick", dump.Timestamp, dump.Final)
+	}
+	if len(dump.Commands) != 1 || dump.Commands[0].Requests != 1 {
+		t.Errorf("dumped commands %+v, want the CCR", dump.Commands)
+	}
+	// The client sent the CER and the CCR.
+	if len(dump.Peers) != 1 || dump.Peers[0].Peer != "client.example.com@example.com" || dump.Peers[0].RequestsIn != 2 {
+		t.Errorf("dumped peers %+v, want the client", dump.Peers)
+	}
+	select {
+	case b := <-w:
+		t.Fatalf("dumped %q between ticks", b)
+	default:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	<-served
+	// Shutdown returns once the final dump is written.
+	select {
+	case b := <-w:
+		var final stats.Dump
+		if err := json.Unmarshal(b, &final); err != nil || !final.Final || len(final.Commands) != 1 {
+			t.Errorf("final dump %q", b)
+		}
+	default:
+		t.Fatal("no final dump on Shutdown")
+	}
+}
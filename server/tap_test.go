@@ -0,0 +1,85 @@
+package server_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/IbrahimShahzad/diameter/client"
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+	"github.com/IbrahimShahzad/diameter/tap"
+)
+
+// tapRecorder records the commands a tap sees, by direction.
+type tapRecorder struct {
+	mu   sync.Mutex
+	seen map[tap.Direction][]string
+}
+
+func (r *tapRecorder) observe(direction tap.Direction, peer string, raw []byte, msg *message.DiameterMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.seen == nil {
+		r.seen = make(map[tap.Direction][]string)
+	}
+	r.seen[direction] = append(r.seen[direction], msg.CommandName())
+}
+
+// saw reports whether the tap has seen name in direction.
+func (r *tapRecorder) saw(direction tap.Direction, name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, seen := range r.seen[direction] {
+		if seen == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMessageTapBothDirections(t *testing.T) {
+	var serverTap, clientTap tapRecorder
+	s, addr := startServer(t, server.WithMessageTap(serverTap.observe))
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, answerSuccess)
+	c := connectClient(t, addr, "client.example.com", client.WithMessageTap(clientTap.observe))
+	request(t, c, newCCR(t, c, "client.example.com;1;1"))
+
+	for _, tc := range []struct {
+		name      string
+		r         *tapRecorder
+		direction tap.Direction
+		command   string
+	}{
+		{"server", &serverTap, tap.Inbound, "Capabilities-Exchange-Request"},
+		{"server", &serverTap, tap.Outbound, "Capabilities-Exchange-Answer"},
+		{"server", &serverTap, tap.Inbound, "Credit-Control-Request"},
+		{"server", &serverTap, tap.Outbound, "Credit-Control-Answer"},
+		{"client", &clientTap, tap.Outbound, "Capabilities-Exchange-Request"},
+		{"client", &clientTap, tap.Inbound, "Capabilities-Exchange-Answer"},
+		{"client", &clientTap, tap.Outbound, "Credit-Control-Request"},
+		{"client", &clientTap, tap.Inbound, "Credit-Control-Answer"},
+	} {
+		eventually(t, tc.name+" tap to see the "+tc.direction.String()+" "+tc.command, func() bool {
+			return tc.r.saw(tc.direction, tc.command)
+		})
+	}
+}
+
+func TestSlowMessageTap(t *testing.T) {
+	release := make(chan struct{})
+	s, addr := startServer(t, server.WithMessageTap(func(tap.Direction, string, []byte, *message.DiameterMessage) {
+		<-release
+	}))
+	// Registered after the server, so it runs before the server closes
+	// and waits for its tap.
+	t.Cleanup(func() { close(release) })
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, answerSuccess)
+	c := connectClient(t, addr, "client.example.com")
+
+	for range 10 {
+		ans := request(t, c, newCCR(t, c, "client.example.com;1;1"))
+		if code, _, err := message.GetResultCode(ans); err != nil || code != message.DIAMETER_SUCCESS {
+			t.Fatalf("Result-Code %v, %v", code, err)
+		}
+	}
+}
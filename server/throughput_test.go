@@ -0,0 +1,126 @@
+package server_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+// clientNode is the identity of the raw peer of the benchmarks.
+var clientNode = message.Node{OriginHost: "client.example.com", OriginRealm: "example.com"}
+
+// dialRaw connects to addr and completes the capabilities exchange with
+// raw frames, so that the benchmarks measure the server alone.
+func dialRaw(b testing.TB, addr string) net.Conn {
+	b.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		b.Fatalf("dialing: %v", err)
+	}
+	b.Cleanup(func() { conn.Close() })
+	cer, err := clientNode.BuildCER(message.Applications{Auth: message.NewApplicationSet(message.APPLICATION_ID_CREDIT_CONTROL)})
+	if err != nil {
+		b.Fatalf("building CER: %v", err)
+	}
+	frame, err := cer.Encode()
+	if err != nil {
+		b.Fatalf("encoding CER: %v", err)
+	}
+	if _, err := conn.Write(frame); err != nil {
+		b.Fatalf("writing CER: %v", err)
+	}
+	cea, err := message.DecodeMessage(readRawFrame(b, conn, nil))
+	if err != nil {
+		b.Fatalf("decoding CEA: %v", err)
+	}
+	if code, _, err := message.GetResultCode(cea); err != nil || code != message.DIAMETER_SUCCESS {
+		b.Fatalf("CEA Result-Code %v, %v", code, err)
+	}
+	return conn
+}
+
+// readRawFrame reads the next frame from r into buf, grown as needed.
+func readRawFrame(b testing.TB, r io.Reader, buf []byte) []byte {
+	b.Helper()
+	var header [message.DIAMETER_HEADER_SIZE]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		b.Fatalf("reading header: %v", err)
+	}
+	length := int(binary.BigEndian.Uint32(header[:]) & 0xffffff)
+	if cap(buf) < length {
+		buf = make([]byte, length)
+	}
+	buf = buf[:length]
+	copy(buf, header[:])
+	if _, err := io.ReadFull(r, buf[len(header):]); err != nil {
+		b.Fatalf("reading frame: %v", err)
+	}
+	return buf
+}
+
+// BenchmarkServerThroughput measures a server answering CCRs pipelined
+// on one connection. The allocations are those of reading, decoding,
+// handling and answering a request; the raw peer reuses its buffers.
+func BenchmarkServerThroughput(b *testing.B) {
+	s, addr := startServer(b)
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, answerSuccess)
+	conn := dialRaw(b, addr)
+
+	var mb message.MessageBuilder
+	avps, err := mb.Add(message.AVP_SESSION_ID, "client.example.com;1;1", message.MANDATORY_FLAG).
+		Add(message.AVP_ORIGIN_HOST, clientNode.OriginHost, message.MANDATORY_FLAG).
+		Add(message.AVP_ORIGIN_REALM, clientNode.OriginRealm, message.MANDATORY_FLAG).
+		Add(message.AVP_DESTINATION_REALM, serverNode.OriginRealm, message.MANDATORY_FLAG).
+		Add(message.AVP_AUTH_APPLICATION_ID, message.APPLICATION_ID_CREDIT_CONTROL, message.MANDATORY_FLAG).
+		AVPs()
+	if err != nil {
+		b.Fatalf("building CCR AVPs: %v", err)
+	}
+	ccr, err := message.NewRequest(message.COMMAND_CODE_CREDIT_CONTROL, message.WithAVPs(avps...))
+	if err != nil {
+		b.Fatalf("building CCR: %v", err)
+	}
+	frame, err := ccr.Encode()
+	if err != nil {
+		b.Fatalf("encoding CCR: %v", err)
+	}
+
+	written := make(chan error, 1)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(frame)))
+	b.ResetTimer()
+	go func() {
+		w := bufio.NewWriter(conn)
+		for i := range b.N {
+			// Fresh identifiers, so that no request is taken for a
+			// retransmission.
+			binary.BigEndian.PutUint32(frame[12:], uint32(i))
+			binary.BigEndian.PutUint32(frame[16:], uint32(i))
+			if _, err := w.Write(frame); err != nil {
+				written <- err
+				return
+			}
+		}
+		written <- w.Flush()
+	}()
+	r := bufio.NewReader(conn)
+	var buf []byte
+	for range b.N {
+		buf = readRawFrame(b, r, buf)
+	}
+	b.StopTimer()
+	if err := <-written; err != nil {
+		b.Fatalf("writing CCRs: %v", err)
+	}
+	ans, err := message.DecodeMessage(buf)
+	if err != nil {
+		b.Fatalf("decoding the last answer: %v", err)
+	}
+	if code, _, err := message.GetResultCode(ans); err != nil || code != message.DIAMETER_SUCCESS {
+		b.Fatalf("last answer Result-Code %v, %v", code, err)
+	}
+}
@@ -0,0 +1,63 @@
+package server_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"testing"
+
+	"github.com/IbrahimShahzad/diameter/internal/testing/testcert"
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+	"github.com/IbrahimShahzad/diameter/transport"
+)
+
+// TestTLSIdentityBinding sends the CER of client.example.com over TLS with
+// certificates naming it or another node, and checks the CEA in each mode.
+func TestTLSIdentityBinding(t *testing.T) {
+	ca := testcert.NewAuthority(t)
+	serverCert := ca.Issue(t, serverNode.OriginHost)
+	for _, tc := range []struct {
+		name  string
+		mode  transport.TLSIdentityMode
+		names []string
+		want  message.ResultCode
+	}{
+		{"off, mismatch", transport.TLSIdentityOff, []string{"other.example.com"}, message.DIAMETER_SUCCESS},
+		{"warn, mismatch", transport.TLSIdentityWarn, []string{"other.example.com"}, message.DIAMETER_SUCCESS},
+		{"enforce, match", transport.TLSIdentityEnforce, []string{clientNode.OriginHost}, message.DIAMETER_SUCCESS},
+		{"enforce, wildcard", transport.TLSIdentityEnforce, []string{"*.example.com"}, message.DIAMETER_SUCCESS},
+		{"enforce, mismatch", transport.TLSIdentityEnforce, []string{"other.example.com"}, message.DIAMETER_UNKNOWN_PEER},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, addr := startServer(t, server.WithTLS(ca.ServerConfig(serverCert)), server.WithTLSIdentityBinding(tc.mode))
+			conn, err := tls.Dial("tcp", addr, ca.ClientConfig(ca.Issue(t, tc.names...), serverNode.OriginHost))
+			if err != nil {
+				t.Fatalf("dialing: %v", err)
+			}
+			defer conn.Close()
+			cer, err := clientNode.BuildCER(message.Applications{Auth: message.NewApplicationSet(message.APPLICATION_ID_CREDIT_CONTROL)})
+			if err != nil {
+				t.Fatalf("building CER: %v", err)
+			}
+			writeMessage(t, conn, cer)
+			r := bufio.NewReader(conn)
+			result, err := message.GetResult(readMessage(t, conn, r))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Code != tc.want {
+				t.Fatalf("CEA result %v, want %v", result.Code, tc.want)
+			}
+			if tc.want != message.DIAMETER_SUCCESS {
+				if result.ErrorMessage != "certificate does not match Origin-Host" {
+					t.Errorf("Error-Message %q", result.ErrorMessage)
+				}
+				if _, err := r.ReadByte(); err == nil {
+					t.Error("connection left open")
+				}
+				return
+			}
+			nextAfterDWR(t, conn, r)
+		})
+	}
+}
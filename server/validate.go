@@ -0,0 +1,188 @@
+// Validation of server options
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/stats"
+	"github.com/IbrahimShahzad/diameter/transport"
+)
+
+// MinWatchdogTTL is the lowest watchdog interval accepted, as recommended
+// by RFC 3539 section 3.4.1.
+const MinWatchdogTTL = 6 * time.Second
+
+// Validate checks the configuration without opening a listener and
+// returns every problem found: options out of range or contradicting each
+// other, an Origin-Host or Origin-Realm that is not a valid
+// DiameterIdentity, a listen address that does not parse for the
+// protocol, AVPs of registered commands missing from the dictionary and a
+// TLS configuration without a certificate. ListenAndServe runs it first
+// unless WithoutSelfTest is set.
+func (s *Server) Validate() error {
+	return errors.Join(s.validate(), s.selfTest())
+}
+
+// selfTest reports the problems validate leaves for startup.
+func (o *ServerOptions) selfTest() error {
+	var errs []error
+	for _, id := range []struct{ name, value string }{
+		{"Origin-Host", o.originHost},
+		{"Origin-Realm", o.originRealm},
+	} {
+		if id.value == "" {
+			continue // reported by validate
+		}
+		if err := message.ValidateDiameterIdentity(id.value); err != nil {
+			errs = append(errs, fmt.Errorf("%w: %s: %w", ErrInvalidOptions, id.name, err))
+		}
+	}
+	if o.serverAddr != "" {
+		if err := transport.CheckAddr(o.serverAddr, o.protocol); err != nil {
+			errs = append(errs, fmt.Errorf("%w: listen address: %w", ErrInvalidOptions, err))
+		}
+	}
+	if cfg := o.tlsConfig; cfg != nil {
+		if len(cfg.Certificates) == 0 && cfg.GetCertificate == nil && cfg.GetConfigForClient == nil {
+			errs = append(errs, fmt.Errorf("%w: TLS configuration has no certificate", ErrInvalidOptions))
+		}
+		if o.tlsIdentity != transport.TLSIdentityOff && cfg.ClientAuth == tls.NoClientCert && cfg.GetConfigForClient == nil {
+			errs = append(errs, fmt.Errorf("%w: TLS identity binding needs client certificates to be requested", ErrInvalidOptions))
+		}
+	}
+	if err := message.CheckDictionary(); err != nil {
+		errs = append(errs, fmt.Errorf("dictionary: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// validate reports every contradictory or out of range option. A
+// connection timeout of 0 means no timeout.
+func (o *ServerOptions) validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: "+format, append([]any{ErrInvalidOptions}, args...)...))
+	}
+	if o.serverAddr == "" {
+		invalid("server address is empty")
+	}
+	if o.protocol != transport.Proto_TCP && o.protocol != transport.Proto_SCTP {
+		invalid("unknown protocol %v", o.protocol)
+	}
+	if o.connectionTimeout < 0 {
+		invalid("connection timeout %v is negative", o.connectionTimeout)
+	}
+	if o.watchdogTTL < MinWatchdogTTL {
+		invalid("watchdog TTL %v is below %v", o.watchdogTTL, MinWatchdogTTL)
+	}
+	if o.requestTimeout <= 0 {
+		invalid("request timeout %v must be positive", o.requestTimeout)
+	}
+	if o.slowRequestThreshold < 0 {
+		invalid("slow request threshold %v is negative", o.slowRequestThreshold)
+	}
+	if o.clock == nil {
+		invalid("clock is nil")
+	}
+	if o.originHost == "" || o.originRealm == "" {
+		invalid("Origin-Host and Origin-Realm must be set")
+	}
+	if o.productName == "" {
+		invalid("Product-Name must be set")
+	}
+	if o.maxMessageSize <= 0 {
+		invalid("maximum message size %d must be positive", o.maxMessageSize)
+	}
+	if o.socketOptions.KeepAlive < 0 || o.socketOptions.SendBuffer < 0 || o.socketOptions.ReceiveBuffer < 0 {
+		invalid("socket options must not be negative")
+	}
+	if o.protocol == transport.Proto_SCTP && o.socketOptions.KeepAlive > 0 {
+		invalid("TCP keepalive cannot be used with SCTP, which has its own heartbeat")
+	}
+	if o.writeBatch.MaxBytes < 0 || o.writeBatch.FlushInterval < 0 {
+		invalid("write batching limits must not be negative")
+	}
+	if o.validateHostIP < HostIPWarn || o.validateHostIP > HostIPEnforce {
+		invalid("unknown Host-IP-Address validation mode %v", o.validateHostIP)
+	}
+	if o.duplicatePeerPolicy < DuplicatePeerAllow || o.duplicatePeerPolicy > DuplicatePeerReplaceOld {
+		invalid("unknown duplicate peer policy %v", o.duplicatePeerPolicy)
+	}
+	if o.peerConcurrency < 0 {
+		invalid("peer concurrency %d is negative", o.peerConcurrency)
+	}
+	if o.handlerTimeout < 0 {
+		invalid("handler timeout %v is negative", o.handlerTimeout)
+	}
+	if o.handlerTimeoutResult < 3000 || o.handlerTimeoutResult >= 6000 {
+		invalid("handler timeout result %d is not an error result", o.handlerTimeoutResult)
+	}
+	if o.writeTimeout < 0 {
+		invalid("write timeout %v is negative", o.writeTimeout)
+	}
+	if o.linger < 0 {
+		invalid("linger %v is negative", o.linger)
+	}
+	if o.writeBatch.FailureThreshold < 0 {
+		invalid("write failure threshold %d is negative", o.writeBatch.FailureThreshold)
+	}
+	if o.decodeOptions.MaxGroupDepth < 0 {
+		invalid("maximum Grouped AVP depth %d is negative", o.decodeOptions.MaxGroupDepth)
+	}
+	if o.duplicateCacheSize < 0 {
+		invalid("duplicate cache size %d is negative", o.duplicateCacheSize)
+	}
+	if o.duplicateCacheSize > 0 && o.duplicateCacheTTL <= 0 {
+		invalid("duplicate cache TTL %v must be positive", o.duplicateCacheTTL)
+	}
+	if o.resyncWindow < 0 {
+		invalid("resync window %d is negative", o.resyncWindow)
+	}
+	if o.outboundValidation < message.ValidationOff || o.outboundValidation > message.ValidationEnforce {
+		invalid("unknown outbound validation mode %v", o.outboundValidation)
+	}
+	if o.errorLogInterval < 0 {
+		invalid("error log interval %v is negative", o.errorLogInterval)
+	}
+	if o.statsDumpWriter != nil && o.statsDumpInterval <= 0 {
+		invalid("statistics dump interval %v must be positive", o.statsDumpInterval)
+	}
+	if o.statsDumpFormat != stats.FormatJSON && o.statsDumpFormat != stats.FormatText {
+		invalid("unknown statistics format %v", o.statsDumpFormat)
+	}
+	if o.tlsConfig != nil && o.protocol != transport.Proto_TCP {
+		invalid("TLS is only supported over TCP")
+	}
+	if o.tlsIdentity < transport.TLSIdentityOff || o.tlsIdentity > transport.TLSIdentityEnforce {
+		invalid("unknown TLS identity binding mode %v", o.tlsIdentity)
+	}
+	if o.tlsIdentity != transport.TLSIdentityOff && o.tlsConfig == nil {
+		invalid("TLS identity binding requires WithTLS")
+	}
+	if o.connEvents != nil && o.protocol != transport.Proto_SCTP {
+		invalid("connection events are only reported for SCTP")
+	}
+	if o.violationThreshold < 0 || o.violationDecay < 0 {
+		invalid("violation threshold %d and decay %v must not be negative", o.violationThreshold, o.violationDecay)
+	}
+	if o.quarantinePeriod < 0 {
+		invalid("quarantine %v is negative", o.quarantinePeriod)
+	}
+	if o.quarantinePeriod > 0 && o.violationThreshold == 0 {
+		invalid("quarantine requires a violation threshold")
+	}
+	if o.maxSessions < 0 || o.maxPeerSessions < 0 {
+		invalid("session limits %d and %d must not be negative", o.maxSessions, o.maxPeerSessions)
+	}
+	if o.sessionIdleTimeout < 0 {
+		invalid("session idle timeout %v is negative", o.sessionIdleTimeout)
+	}
+	if o.duplicateCacheSize > 0 && o.duplicateStore != nil {
+		invalid("WithDuplicateCache and WithDuplicateStore are mutually exclusive")
+	}
+	return errors.Join(errs...)
+}
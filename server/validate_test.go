@@ -0,0 +1,309 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/clock"
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/stats"
+	"github.com/IbrahimShahzad/diameter/transport"
+)
+
+// resolved is the part of the configuration NewServer resolves from its
+// options.
+type resolved struct {
+	protocol          transport.ProtocolType
+	connectionTimeout time.Duration
+	watchdogTTL       time.Duration
+	requestTimeout    time.Duration
+	maxMessageSize    int
+	duplicates        bool
+}
+
+// TestNewServerOptions documents the defaults of the server and the
+// option combinations NewServer rejects.
+func TestNewServerOptions(t *testing.T) {
+	defaults := resolved{
+		protocol:          transport.Proto_TCP,
+		connectionTimeout: 0,
+		watchdogTTL:       30 * time.Second,
+		requestTimeout:    30 * time.Second,
+		maxMessageSize:    transport.DefaultMaxMessageSize,
+	}
+	with := func(change func(*resolved)) resolved {
+		r := defaults
+		change(&r)
+		return r
+	}
+	for _, tc := range []struct {
+		name string
+		opts []ServerOptionsFunc
+		want resolved
+		// errs are the problems reported, none when the options are
+		// valid.
+		errs []string
+	}{
+		{name: "defaults", want: defaults},
+		{
+			name: "connection timeout as a duration",
+			opts: []ServerOptionsFunc{WithConnectionTimeout(1500 * time.Millisecond)},
+			want: with(func(r *resolved) { r.connectionTimeout = 1500 * time.Millisecond }),
+		},
+		{
+			name: "minimum watchdog TTL",
+			opts: []ServerOptionsFunc{WithWatchdogTTL(MinWatchdogTTL)},
+			want: with(func(r *resolved) { r.watchdogTTL = MinWatchdogTTL }),
+		},
+		{
+			name: "SCTP",
+			opts: []ServerOptionsFunc{WithSCTP()},
+			want: with(func(r *resolved) { r.protocol = transport.Proto_SCTP }),
+		},
+		{
+			name: "duplicate cache",
+			opts: []ServerOptionsFunc{WithDuplicateCache(100, time.Minute)},
+			want: with(func(r *resolved) { r.duplicates = true }),
+		},
+		{
+			name: "watchdog TTL of 0",
+			opts: []ServerOptionsFunc{WithWatchdogTTL(0)},
+			errs: []string{"watchdog TTL 0s is below 6s"},
+		},
+		{
+			name: "negative connection timeout",
+			opts: []ServerOptionsFunc{WithConnectionTimeout(-time.Second)},
+			errs: []string{"connection timeout -1s is negative"},
+		},
+		{
+			name: "request timeout of 0",
+			opts: []ServerOptionsFunc{WithRequestTimeout(0)},
+			errs: []string{"request timeout 0s must be positive"},
+		},
+		{
+			name: "message size of 0",
+			opts: []ServerOptionsFunc{WithMaxMessageSize(0)},
+			errs: []string{"maximum message size 0 must be positive"},
+		},
+		{
+			name: "unknown Host-IP-Address validation mode",
+			opts: []ServerOptionsFunc{WithValidateHostIP(HostIPEnforce + 1)},
+			errs: []string{"unknown Host-IP-Address validation mode HostIPMode(3)"},
+		},
+		{
+			name: "unknown duplicate peer policy",
+			opts: []ServerOptionsFunc{WithDuplicatePeerPolicy(DuplicatePeerReplaceOld + 1)},
+			errs: []string{"unknown duplicate peer policy DuplicatePeerPolicy(3)"},
+		},
+		{
+			name: "no Product-Name",
+			opts: []ServerOptionsFunc{WithProductName("")},
+			errs: []string{"Product-Name must be set"},
+		},
+		{
+			name: "negative linger",
+			opts: []ServerOptionsFunc{WithLinger(-time.Second)},
+			errs: []string{"linger -1s is negative"},
+		},
+		{
+			name: "connection events over TCP",
+			opts: []ServerOptionsFunc{WithConnEventHandler(func(string, transport.ConnEvent) {})},
+			errs: []string{"connection events are only reported for SCTP"},
+		},
+		{
+			name: "negative violation threshold",
+			opts: []ServerOptionsFunc{WithViolationThreshold(-1, time.Minute)},
+			errs: []string{"violation threshold -1 and decay 1m0s must not be negative"},
+		},
+		{
+			name: "negative quarantine",
+			opts: []ServerOptionsFunc{WithViolationThreshold(3, time.Minute), WithQuarantine(-time.Second)},
+			errs: []string{"quarantine -1s is negative"},
+		},
+		{
+			name: "quarantine without a violation threshold",
+			opts: []ServerOptionsFunc{WithQuarantine(time.Hour)},
+			errs: []string{"quarantine requires a violation threshold"},
+		},
+		{
+			name: "negative session limit",
+			opts: []ServerOptionsFunc{WithMaxSessions(10, -1)},
+			errs: []string{"session limits 10 and -1 must not be negative"},
+		},
+		{
+			name: "negative session idle timeout",
+			opts: []ServerOptionsFunc{WithMaxSessions(10, 0), WithSessionIdleTimeout(-time.Second)},
+			errs: []string{"session idle timeout -1s is negative"},
+		},
+		{
+			name: "negative resync window",
+			opts: []ServerOptionsFunc{WithStreamResync(-1)},
+			errs: []string{"resync window -1 is negative"},
+		},
+		{
+			name: "statistics dump without interval",
+			opts: []ServerOptionsFunc{WithStatsDump(io.Discard, 0, stats.FormatText)},
+			errs: []string{"statistics dump interval 0s must be positive"},
+		},
+		{
+			name: "unknown statistics format",
+			opts: []ServerOptionsFunc{WithStatsDump(io.Discard, time.Minute, stats.Format(2))},
+			errs: []string{"unknown statistics format Format(2)"},
+		},
+		{
+			name: "TLS over SCTP",
+			opts: []ServerOptionsFunc{WithSCTP(), WithTLS(&tls.Config{})},
+			errs: []string{"TLS is only supported over TCP"},
+		},
+		{
+			name: "TCP keepalive over SCTP",
+			opts: []ServerOptionsFunc{WithSCTP(), WithTCPKeepalive(time.Second)},
+			errs: []string{"TCP keepalive cannot be used with SCTP"},
+		},
+		{
+			name: "duplicate cache and store",
+			opts: []ServerOptionsFunc{WithDuplicateCache(100, time.Minute), WithDuplicateStore(newLRUDuplicateStore(clock.Real, 1, time.Minute))},
+			errs: []string{"WithDuplicateCache and WithDuplicateStore are mutually exclusive"},
+		},
+		{
+			name: "every problem reported",
+			opts: []ServerOptionsFunc{WithServerAddr(""), WithWatchdogTTL(time.Second), WithOriginRealm("")},
+			errs: []string{"server address is empty", "watchdog TTL 1s is below 6s", "Origin-Host and Origin-Realm must be set"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewServer(tc.opts...)
+			if tc.errs != nil {
+				if !errors.Is(err, ErrInvalidOptions) {
+					t.Fatalf("error %v, want ErrInvalidOptions", err)
+				}
+				for _, want := range tc.errs {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("error %q does not report %q", err, want)
+					}
+				}
+				if n := strings.Count(err.Error(), ErrInvalidOptions.Error()); n != len(tc.errs) {
+					t.Errorf("%d problems reported, want %d: %v", n, len(tc.errs), err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewServer: %v", err)
+			}
+			got := resolved{
+				protocol:          s.protocol,
+				connectionTimeout: s.connectionTimeout,
+				watchdogTTL:       s.watchdogTTL,
+				requestTimeout:    s.requestTimeout,
+				maxMessageSize:    s.maxMessageSize,
+				duplicates:        s.duplicates != nil,
+			}
+			if got != tc.want {
+				t.Errorf("resolved %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestSelfTest feeds Validate configurations NewServer accepts but that
+// cannot work, and checks that each problem is reported.
+func TestSelfTest(t *testing.T) {
+	cert := []tls.Certificate{{Certificate: [][]byte{{0}}}}
+	for _, tc := range []struct {
+		name string
+		opts []ServerOptionsFunc
+		errs []string
+	}{
+		{name: "valid", opts: []ServerOptionsFunc{WithTLS(&tls.Config{Certificates: cert})}},
+		{
+			name: "invalid Origin-Host",
+			opts: []ServerOptionsFunc{WithOriginHost("server_1.example.com")},
+			errs: []string{"Origin-Host: invalid DiameterIdentity"},
+		},
+		{
+			name: "invalid Origin-Realm",
+			opts: []ServerOptionsFunc{WithOriginRealm("example..com")},
+			errs: []string{"Origin-Realm: invalid DiameterIdentity"},
+		},
+		{
+			name: "listen address without a port",
+			opts: []ServerOptionsFunc{WithServerAddr("127.0.0.1")},
+			errs: []string{`listen address: invalid address "127.0.0.1"`},
+		},
+		{
+			name: "SCTP listen address with a port",
+			opts: []ServerOptionsFunc{WithSCTP(), WithServerAddr("127.0.0.1:3868")},
+			errs: []string{"SCTP needs an IP address"},
+		},
+		{
+			name: "TLS without a certificate",
+			opts: []ServerOptionsFunc{WithTLS(&tls.Config{})},
+			errs: []string{"TLS configuration has no certificate"},
+		},
+		{
+			name: "TLS identity binding without client certificates",
+			opts: []ServerOptionsFunc{WithTLS(&tls.Config{Certificates: cert}), WithTLSIdentityBinding(transport.TLSIdentityEnforce)},
+			errs: []string{"TLS identity binding needs client certificates to be requested"},
+		},
+		{
+			name: "every problem reported",
+			opts: []ServerOptionsFunc{WithOriginHost("-server.example.com"), WithServerAddr("127.0.0.1"), WithTLS(&tls.Config{})},
+			errs: []string{"Origin-Host: invalid DiameterIdentity", "listen address", "no certificate"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewServer(append([]ServerOptionsFunc{WithOriginHost("server.example.com"), WithOriginRealm("example.com"), WithServerAddr("127.0.0.1:0")}, tc.opts...)...)
+			if err != nil {
+				t.Fatalf("NewServer: %v", err)
+			}
+			err = s.Validate()
+			if tc.errs == nil {
+				if err != nil {
+					t.Errorf("Validate: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidOptions) {
+				t.Fatalf("error %v, want ErrInvalidOptions", err)
+			}
+			for _, want := range tc.errs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not report %q", err, want)
+				}
+			}
+			if n := strings.Count(err.Error(), ErrInvalidOptions.Error()); n != len(tc.errs) {
+				t.Errorf("%d problems reported, want %d: %v", n, len(tc.errs), err)
+			}
+		})
+	}
+}
+
+// TestListenSelfTest checks that Listen runs Validate before opening the
+// listener unless WithoutSelfTest is set.
+func TestListenSelfTest(t *testing.T) {
+	opts := []ServerOptionsFunc{WithServerAddr("127.0.0.1:0"), WithOriginHost("server_1.example.com"), WithOriginRealm("example.com")}
+	s, err := NewServer(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Listen(); !errors.Is(err, message.InvalidDiameterIdentityError) {
+		t.Errorf("Listen = %v, want the invalid Origin-Host reported", err)
+	}
+	if addrs := s.ListenerAddrs(); len(addrs) != 0 {
+		t.Errorf("listening on %v after a failed self-test", addrs)
+	}
+
+	s, err = NewServer(append(opts, WithoutSelfTest())...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Listen(); err != nil {
+		t.Fatalf("Listen without self-test: %v", err)
+	}
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+}
@@ -0,0 +1,148 @@
+// Protocol violation scoring and peer quarantine
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/transport"
+)
+
+// violationScore is the protocol violation score of a connection. Each
+// violation adds a point and the score leaks one point per full decay
+// interval, so that occasional errors never add up to a disconnect.
+type violationScore struct {
+	mu      sync.Mutex
+	score   int
+	updated time.Time
+	tripped bool
+}
+
+// add counts a violation at now and returns the new score.
+func (v *violationScore) add(now time.Time, decay time.Duration) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.decay(now, decay)
+	v.score++
+	return v.score
+}
+
+// value returns the score at now.
+func (v *violationScore) value(now time.Time, decay time.Duration) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.decay(now, decay)
+	return v.score
+}
+
+// trip reports whether the threshold is reached for the first time.
+func (v *violationScore) trip() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	tripped := v.tripped
+	v.tripped = true
+	return !tripped
+}
+
+// decay leaks the score down to now, keeping the time elapsed since the
+// last full interval. Callers must hold v.mu.
+func (v *violationScore) decay(now time.Time, decay time.Duration) {
+	if v.score == 0 || decay <= 0 {
+		v.updated = now
+		return
+	}
+	n := int(now.Sub(v.updated) / decay)
+	if n >= v.score {
+		v.score = 0
+		v.updated = now
+		return
+	}
+	v.score -= n
+	v.updated = v.updated.Add(time.Duration(n) * decay)
+}
+
+// violation records a protocol violation by p and reports whether it
+// brought the score of p to the threshold set with WithViolationThreshold,
+// in which case the peer is quarantined. The threshold is only reported
+// once per connection.
+func (s *Server) violation(p *peer, reason string) bool {
+	if s.violationThreshold <= 0 {
+		return false
+	}
+	s.protocolViolations.Add(1)
+	score := p.violations.add(s.clock.Now(), s.violationDecay)
+	log.Printf("Protocol violation by %s: %s (score %d)", p.addr, reason, score)
+	if score < s.violationThreshold || !p.violations.trip() {
+		return false
+	}
+	log.Printf("Too many protocol violations by %s, disconnecting.", p.addr)
+	s.quarantine(p)
+	return true
+}
+
+// checkViolation records a protocol violation by a connection that is
+// otherwise kept, and disconnects the peer once the threshold is reached:
+// with a DPR carrying DO_NOT_WANT_TO_TALK_TO_YOU after the capabilities
+// exchange, by closing the connection before.
+func (s *Server) checkViolation(p *peer, reason string) {
+	if !s.violation(p, reason) {
+		return
+	}
+	if !p.exchanged() {
+		p.conn.Close()
+		return
+	}
+	s.spawn(func() {
+		s.disconnect(p, message.DISCONNECT_CAUSE_DO_NOT_WANT_TO_TALK_TO_YOU, "too many protocol violations")
+	})
+}
+
+// quarantine rejects new connections from the address and identity of p
+// for the period set with WithQuarantine.
+func (s *Server) quarantine(p *peer) {
+	if s.quarantinePeriod <= 0 {
+		return
+	}
+	until := s.clock.Now().Add(s.quarantinePeriod)
+	id := p.getIdentity()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ip := transport.HostIP(p.conn.RemoteAddr()); ip != nil {
+		s.quarantined[ip.String()] = until
+	}
+	if !id.IsZero() {
+		s.quarantined[id.String()] = until
+	}
+}
+
+// isQuarantined reports whether key, an address or identity, is in
+// quarantine, forgetting it once the quarantine is over.
+func (s *Server) isQuarantined(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	until, ok := s.quarantined[key]
+	if !ok {
+		return false
+	}
+	if !s.clock.Now().Before(until) {
+		delete(s.quarantined, key)
+		return false
+	}
+	return true
+}
+
+// reservedAVPFlags reports whether any of avps, or of their members, has
+// flag bits set that RFC 6733 section 4.1 reserves.
+func reservedAVPFlags(avps []*message.AVP) bool {
+	for _, avp := range avps {
+		if avp.Flags&^(message.VENDOR_FLAG|message.MANDATORY_FLAG|message.PROTECTED_FLAG) != 0 {
+			return true
+		}
+		if g, ok := avp.Data.(*message.Grouped); ok && reservedAVPFlags(g.AVPs) {
+			return true
+		}
+	}
+	return false
+}
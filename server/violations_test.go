@@ -0,0 +1,217 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+	"github.com/IbrahimShahzad/diameter/message"
+	"github.com/IbrahimShahzad/diameter/server"
+)
+
+// reservedFlags returns a CCR for session carrying an AVP with a reserved
+// flag bit set, which the server answers but scores as a violation.
+func reservedFlags(t *testing.T, session string) *message.DiameterMessage {
+	t.Helper()
+	userName := message.MustNewAVP(message.AVP_USER_NAME, "alice", message.MANDATORY_FLAG)
+	userName.Flags |= 0x01
+	return rawRequest(t, message.COMMAND_CODE_CREDIT_CONTROL, message.APPLICATION_ID_CREDIT_CONTROL, session, userName)
+}
+
+// closedByServer checks that the server closes conn without sending
+// anything.
+func closedByServer(t *testing.T, conn net.Conn) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(testTimeout))
+	if n, err := conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("read %d bytes, %v; want the connection closed", n, err)
+	}
+}
+
+// violationScore returns the violation score the server reports for
+// clientNode.
+func violationScore(t *testing.T, s *server.Server) int {
+	t.Helper()
+	info, ok := s.PeerInfo(clientNode.Identity())
+	if !ok {
+		t.Fatal("PeerInfo found no peer")
+	}
+	return info.ViolationScore
+}
+
+// TestViolationQuarantine drives the violation score of a peer past the
+// threshold, with the decay taking a point off on the way, and checks that
+// the peer is disconnected with DO_NOT_WANT_TO_TALK_TO_YOU and that its
+// reconnections are rejected until the quarantine ends.
+func TestViolationQuarantine(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	s, addr := startServer(t, server.WithClock(clk), server.WithViolationThreshold(3, time.Minute), server.WithQuarantine(time.Hour))
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, answerSuccess)
+	apps := message.Applications{Auth: message.NewApplicationSet(message.APPLICATION_ID_CREDIT_CONTROL)}
+	conn, r, _ := exchangeCapabilities(t, addr, apps)
+
+	for i, want := range []int{1, 2} {
+		writeMessage(t, conn, reservedFlags(t, "client.example.com;1;1"))
+		if code, _, err := message.GetResultCode(readMessage(t, conn, r)); err != nil || code != message.DIAMETER_SUCCESS {
+			t.Fatalf("answer %d Result-Code %v, %v; want the request served", i, code, err)
+		}
+		if got := violationScore(t, s); got != want {
+			t.Errorf("score %d after %d violations, want %d", got, i+1, want)
+		}
+	}
+	clk.Advance(time.Minute)
+	if got := violationScore(t, s); got != 1 {
+		t.Fatalf("score %d after the decay interval, want 1", got)
+	}
+	writeMessage(t, conn, reservedFlags(t, "client.example.com;1;2"))
+	writeMessage(t, conn, reservedFlags(t, "client.example.com;1;3"))
+
+	// The DPR and the answers to the requests are written concurrently.
+	var dpr *message.DiameterMessage
+	for dpr == nil {
+		if msg := readMessage(t, conn, r); msg.Header.CommandCode == message.COMMAND_CODE_DISCONNECT_PEER {
+			dpr = msg
+		}
+	}
+	if cause, err := dpr.GetAVP(message.AVP_DISCONNECT_CAUSE).Uint32(); err != nil || cause != message.DISCONNECT_CAUSE_DO_NOT_WANT_TO_TALK_TO_YOU {
+		t.Errorf("DPR Disconnect-Cause %d, %v; want DO_NOT_WANT_TO_TALK_TO_YOU", cause, err)
+	}
+	dpa, err := clientNode.BuildDPA(dpr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeMessage(t, conn, dpa)
+	eventually(t, "the peer to be disconnected", func() bool { return len(s.Peers()) == 0 })
+
+	// The address is in quarantine, and so is the identity when it
+	// connects from another address.
+	again, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	closedByServer(t, again)
+	dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2)}}
+	if other, err := dialer.Dial("tcp", addr); err != nil {
+		t.Logf("not dialing from 127.0.0.2: %v", err)
+	} else {
+		defer other.Close()
+		cer, err := clientNode.BuildCER(apps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeMessage(t, other, cer)
+		if code, _, err := message.GetResultCode(readMessage(t, other, bufio.NewReader(other))); err != nil || code != message.DIAMETER_UNABLE_TO_COMPLY {
+			t.Errorf("CEA from another address Result-Code %v, %v; want DIAMETER_UNABLE_TO_COMPLY", code, err)
+		}
+	}
+
+	snapshot := s.StatsSnapshot()
+	if snapshot.ProtocolViolations != 4 || snapshot.QuarantineRejections == 0 {
+		t.Errorf("%d violations and %d quarantine rejections counted, want 4 and at least 1", snapshot.ProtocolViolations, snapshot.QuarantineRejections)
+	}
+
+	clk.Advance(time.Hour)
+	_, _, cea := exchangeCapabilities(t, addr, apps)
+	if code, _, err := message.GetResultCode(cea); err != nil || code != message.DIAMETER_SUCCESS {
+		t.Errorf("CEA after the quarantine Result-Code %v, %v", code, err)
+	}
+	if got := violationScore(t, s); got != 0 {
+		t.Errorf("new connection starts with score %d", got)
+	}
+}
+
+// TestViolationBeforeCER checks that a connection reaching the threshold
+// before the capabilities exchange is closed without a DPR, and that the
+// address is quarantined.
+func TestViolationBeforeCER(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	_, addr := startServer(t, server.WithClock(clk), server.WithViolationThreshold(1, time.Minute), server.WithQuarantine(time.Hour))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	writeMessage(t, conn, reservedFlags(t, "client.example.com;1;1"))
+	// The early request is answered with DIAMETER_UNKNOWN_PEER before the
+	// connection is closed.
+	r := bufio.NewReader(conn)
+	if code, _, err := message.GetResultCode(readMessage(t, conn, r)); err != nil || code != message.DIAMETER_UNKNOWN_PEER {
+		t.Errorf("early request Result-Code %v, %v; want DIAMETER_UNKNOWN_PEER", code, err)
+	}
+	closedByServer(t, conn)
+
+	again, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	closedByServer(t, again)
+}
+
+// TestViolationsUnlimited checks that violations are neither scored nor
+// acted on without a threshold.
+func TestViolationsUnlimited(t *testing.T) {
+	s, addr := startServer(t)
+	s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, answerSuccess)
+	conn, r, _ := exchangeCapabilities(t, addr, message.Applications{Auth: message.NewApplicationSet(message.APPLICATION_ID_CREDIT_CONTROL)})
+	for range 10 {
+		writeMessage(t, conn, reservedFlags(t, "client.example.com;1;1"))
+		if msg := readMessage(t, conn, r); msg.Header.CommandCode != message.COMMAND_CODE_CREDIT_CONTROL {
+			t.Fatalf("read %s, want the CCA", msg.CommandName())
+		}
+	}
+	if got := violationScore(t, s); got != 0 {
+		t.Errorf("score %d without a threshold", got)
+	}
+	if n := s.StatsSnapshot().ProtocolViolations; n != 0 {
+		t.Errorf("%d violations counted without a threshold", n)
+	}
+}
+
+// TestStreamDesync writes bytes that are not a message header before a
+// request: the server closes the connection and scores a violation, unless
+// a resync window lets it find the request.
+func TestStreamDesync(t *testing.T) {
+	apps := message.Applications{Auth: message.NewApplicationSet(message.APPLICATION_ID_CREDIT_CONTROL)}
+	garbage := func(n int) []byte { return bytes.Repeat([]byte{0xff}, n) }
+	t.Run("closed", func(t *testing.T) {
+		s, addr := startServer(t, server.WithViolationThreshold(5, time.Minute))
+		conn, _, _ := exchangeCapabilities(t, addr, apps)
+		// A whole header, so that the server leaves nothing unread.
+		if _, err := conn.Write(garbage(message.DIAMETER_HEADER_SIZE)); err != nil {
+			t.Fatal(err)
+		}
+		closedByServer(t, conn)
+		if n := s.StatsSnapshot().ProtocolViolations; n != 1 {
+			t.Errorf("%d violations, want the desync scored", n)
+		}
+	})
+	t.Run("resynchronized", func(t *testing.T) {
+		s, addr := startServer(t, server.WithStreamResync(16))
+		s.HandleFunc(message.APPLICATION_ID_CREDIT_CONTROL, message.COMMAND_CODE_CREDIT_CONTROL, answerSuccess)
+		conn, r, _ := exchangeCapabilities(t, addr, apps)
+		data, err := rawRequest(t, message.COMMAND_CODE_CREDIT_CONTROL, message.APPLICATION_ID_CREDIT_CONTROL, "client.example.com;1;1").Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write(append(garbage(6), data...)); err != nil {
+			t.Fatal(err)
+		}
+		if msg := readMessage(t, conn, r); msg.Header.CommandCode != message.COMMAND_CODE_CREDIT_CONTROL || msg.IsRequest() {
+			t.Fatalf("read %s, want the CCA", msg.CommandName())
+		}
+		info, ok := s.PeerInfo(clientNode.Identity())
+		if !ok {
+			t.Fatal("PeerInfo found no peer")
+		}
+		if info.Counters.Resyncs != 1 || info.Counters.ReadErrors != 1 {
+			t.Errorf("%d resyncs and %d read errors, want 1 each", info.Counters.Resyncs, info.Counters.ReadErrors)
+		}
+	})
+}
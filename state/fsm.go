@@ -5,12 +5,19 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/clock"
 )
 
 type State int
 
 type Event int
 
+// ActionFunc is run by Trigger before a transition takes effect; an error
+// aborts the transition. Actions are closures over their owner, such as a
+// client or server, and reach its connection, peer identity and clock as
+// typed fields rather than through an untyped payload.
 type ActionFunc func() error
 
 type Transition struct {
@@ -23,7 +30,10 @@ type Transition struct {
 type FSM struct {
 	mu          sync.Mutex
 	state       State
+	since       time.Time
+	clock       clock.Clock
 	transitions map[State]map[Event]Transition
+	onChange    func(from, to State, event Event, at time.Time)
 }
 
 const (
@@ -37,10 +47,36 @@ const (
 func NewFSM(s State) *FSM {
 	return &FSM{
 		state:       s,
+		since:       clock.Real.Now(),
+		clock:       clock.Real,
 		transitions: make(map[State]map[Event]Transition),
 	}
 }
 
+// SetClock sets the clock used to timestamp state changes.
+func (f *FSM) SetClock(c clock.Clock) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.clock = c
+	f.since = c.Now()
+}
+
+// OnChange registers fn to be called whenever a transition changes the
+// state. fn runs with the FSM locked, after the transition's action, and
+// must not trigger further events.
+func (f *FSM) OnChange(fn func(from, to State, event Event, at time.Time)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.onChange = fn
+}
+
+// Since returns the time at which the FSM entered its current state.
+func (f *FSM) Since() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.since
+}
+
 // Register a transition from one state to another in response to an event.
 func (f *FSM) AddTransition(from State, to State, event Event, action ActionFunc) {
 	f.mu.Lock()
@@ -87,7 +123,14 @@ func (f *FSM) Trigger(event Event) error {
 		}
 	}
 
+	from := f.state
 	f.state = transition.To
+	if from != transition.To {
+		f.since = f.clock.Now()
+		if f.onChange != nil {
+			f.onChange(from, transition.To, event, f.since)
+		}
+	}
 	return nil
 }
 
@@ -102,5 +145,8 @@ func (f *FSM) GetState() State {
 func (f *FSM) SetState(s State) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	if f.state != s {
+		f.since = f.clock.Now()
+	}
 	f.state = s
 }
@@ -0,0 +1,158 @@
+package state
+
+import (
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+)
+
+const (
+	_ State = iota
+	closed
+	opening
+	open
+)
+
+const (
+	_ Event = iota
+	start
+	connected
+	fail
+)
+
+func TestTrigger(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	f := NewFSM(closed)
+	f.SetClock(clk)
+	var actions []Event
+	action := func(e Event) ActionFunc {
+		return func() error {
+			actions = append(actions, e)
+			return nil
+		}
+	}
+	type change struct {
+		from, to State
+		event    Event
+		at       time.Time
+	}
+	var changes []change
+	f.OnChange(func(from, to State, event Event, at time.Time) {
+		changes = append(changes, change{from, to, event, at})
+	})
+	f.AddTransition(closed, opening, start, action(start))
+	f.AddTransition(opening, open, connected, action(connected))
+	f.AddTransition(open, open, connected, nil)
+
+	if err := f.Trigger(start); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Second)
+	if err := f.Trigger(connected); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Second)
+	if err := f.Trigger(connected); err != nil {
+		t.Fatal(err)
+	}
+
+	if f.GetState() != open {
+		t.Errorf("state %d, want %d", f.GetState(), open)
+	}
+	if len(actions) != 2 || actions[0] != start || actions[1] != connected {
+		t.Errorf("actions run for %v", actions)
+	}
+	want := []change{
+		{closed, opening, start, time.Unix(1_700_000_000, 0)},
+		{opening, open, connected, time.Unix(1_700_000_001, 0)},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("changes %v, want %v: a transition to the same state is no change", changes, want)
+	}
+	for i := range want {
+		if changes[i].from != want[i].from || changes[i].to != want[i].to || changes[i].event != want[i].event || !changes[i].at.Equal(want[i].at) {
+			t.Errorf("change %d = %+v, want %+v", i, changes[i], want[i])
+		}
+	}
+	if !f.Since().Equal(want[1].at) {
+		t.Errorf("Since = %v, want %v", f.Since(), want[1].at)
+	}
+}
+
+func TestTriggerActionError(t *testing.T) {
+	f := NewFSM(closed)
+	errAction := errors.New("action failed")
+	f.AddTransition(closed, opening, start, func() error { return errAction })
+	f.OnChange(func(State, State, Event, time.Time) { t.Error("change published for a failed action") })
+	if err := f.Trigger(start); err != errAction {
+		t.Errorf("Trigger = %v, want the error of the action", err)
+	}
+	if f.GetState() != closed {
+		t.Errorf("state %d after a failed action, want %d", f.GetState(), closed)
+	}
+}
+
+func TestTriggerNoTransition(t *testing.T) {
+	f := NewFSM(closed)
+	f.AddTransition(closed, opening, start, nil)
+	for _, e := range []Event{connected, fail} {
+		if err := f.Trigger(e); !errors.Is(err, errNoTransitionRegisteredForState) {
+			t.Errorf("Trigger(%d) = %v, want no transition", e, err)
+		}
+	}
+	f.SetState(open)
+	if err := f.Trigger(start); !errors.Is(err, errNoTransitionRegisteredForState) {
+		t.Errorf("Trigger in a state without transitions = %v", err)
+	}
+}
+
+// TestNoContextValueLookups checks that the packages driving the FSM pass
+// state to actions as typed fields, not through context values looked up
+// by string keys, whose type assertions panic when the value is absent.
+// The FSM itself does not use contexts at all.
+func TestNoContextValueLookups(t *testing.T) {
+	for _, dir := range []string{".", "../client", "../server"} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fset := token.NewFileSet()
+		for _, entry := range entries {
+			name := entry.Name()
+			if !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+				continue
+			}
+			file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, imp := range file.Imports {
+				if dir == "." && imp.Path.Value == `"context"` {
+					t.Errorf("%s: the FSM imports context", fset.Position(imp.Pos()))
+				}
+			}
+			ast.Inspect(file, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok || len(call.Args) != 1 {
+					return true
+				}
+				sel, ok := call.Fun.(*ast.SelectorExpr)
+				if !ok || sel.Sel.Name != "Value" {
+					return true
+				}
+				if lit, ok := call.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+					t.Errorf("%s: context value looked up by the string key %s", fset.Position(call.Pos()), lit.Value)
+				}
+				return true
+			})
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package stats
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// CommandKey identifies a command within an application.
+type CommandKey struct {
+	ApplicationID uint32
+	CommandCode   uint32
+}
+
+type commandEntry struct {
+	requests uint64
+	latency  *Histogram
+}
+
+// Commands records per-command request counts and handler latency.
+type Commands struct {
+	mu      sync.Mutex
+	entries map[CommandKey]*commandEntry
+}
+
+// CommandStats is the snapshot of a single command.
+type CommandStats struct {
+	ApplicationID uint32            `json:"application_id"`
+	CommandCode   uint32            `json:"command_code"`
+	Name          string            `json:"name,omitempty"`
+	Requests      uint64            `json:"requests"`
+	Latency       HistogramSnapshot `json:"latency"`
+}
+
+// NewCommands creates an empty command registry.
+func NewCommands() *Commands {
+	return &Commands{entries: make(map[CommandKey]*commandEntry)}
+}
+
+// Observe records one handled request for key taking d.
+func (c *Commands) Observe(key CommandKey, d time.Duration) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if !ok {
+		e = &commandEntry{latency: NewHistogram(0)}
+		c.entries[key] = e
+	}
+	e.requests++
+	c.mu.Unlock()
+	e.latency.Observe(d)
+}
+
+// Snapshot returns the statistics of every command seen so far, ordered by
+// application and command code.
+func (c *Commands) Snapshot() []CommandStats {
+	c.mu.Lock()
+	keys := make([]CommandKey, 0, len(c.entries))
+	entries := make(map[CommandKey]*commandEntry, len(c.entries))
+	requests := make(map[CommandKey]uint64, len(c.entries))
+	for k, e := range c.entries {
+		keys = append(keys, k)
+		entries[k] = e
+		requests[k] = e.requests
+	}
+	c.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ApplicationID != keys[j].ApplicationID {
+			return keys[i].ApplicationID < keys[j].ApplicationID
+		}
+		return keys[i].CommandCode < keys[j].CommandCode
+	})
+	result := make([]CommandStats, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, CommandStats{
+			ApplicationID: k.ApplicationID,
+			CommandCode:   k.CommandCode,
+			Requests:      requests[k],
+			Latency:       entries[k].latency.Snapshot(),
+		})
+	}
+	return result
+}
@@ -0,0 +1,181 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/clock"
+)
+
+// Format selects how a Dumper writes snapshots.
+type Format int
+
+const (
+	// FormatJSON writes every snapshot as one line of JSON.
+	FormatJSON Format = iota
+	// FormatText writes every snapshot as a compact text table.
+	FormatText
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatJSON:
+		return "json"
+	case FormatText:
+		return "text"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// Dump is a snapshot as written by a Dumper.
+type Dump struct {
+	Snapshot
+	// Dropped counts the snapshots dropped since the previous dump
+	// because the writer was still busy with it.
+	Dropped uint64 `json:"dropped_dumps,omitempty"`
+	// Final marks the snapshot written when the Dumper is stopped.
+	Final bool `json:"final,omitempty"`
+}
+
+// Dumper writes a snapshot to an io.Writer at every tick of an interval
+// and a last one when stopped. Writes happen on a goroutine of their own,
+// so a slow writer never delays the caller of the snapshot function: a
+// snapshot due while the previous one is still being written is dropped
+// and counted in the next Dump.
+type Dumper struct {
+	w        io.Writer
+	format   Format
+	snapshot func() Snapshot
+	ticker   clock.Ticker
+	dumps    chan Dump
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewDumper starts a Dumper writing the snapshots returned by snapshot to
+// w in format, every interval of clk.
+func NewDumper(w io.Writer, interval time.Duration, format Format, clk clock.Clock, snapshot func() Snapshot) *Dumper {
+	d := &Dumper{
+		w:        w,
+		format:   format,
+		snapshot: snapshot,
+		ticker:   clk.NewTicker(interval),
+		dumps:    make(chan Dump, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go d.tick()
+	go d.write()
+	return d
+}
+
+// Stop writes the final snapshot and returns once it has been written.
+// It is safe to call on a nil Dumper and more than once.
+func (d *Dumper) Stop() {
+	if d == nil {
+		return
+	}
+	d.stopOnce.Do(func() { close(d.stop) })
+	<-d.done
+}
+
+func (d *Dumper) tick() {
+	defer close(d.dumps)
+	defer d.ticker.Stop()
+	var dropped uint64
+	for {
+		select {
+		case <-d.ticker.C():
+			select {
+			case d.dumps <- Dump{Snapshot: d.snapshot(), Dropped: dropped}:
+				dropped = 0
+			default:
+				dropped++
+			}
+		case <-d.stop:
+			d.dumps <- Dump{Snapshot: d.snapshot(), Dropped: dropped, Final: true}
+			return
+		}
+	}
+}
+
+func (d *Dumper) write() {
+	defer close(d.done)
+	for dump := range d.dumps {
+		b, err := dump.Encode(d.format)
+		if err == nil {
+			_, err = d.w.Write(b)
+		}
+		if err != nil {
+			log.Printf("Error writing statistics: %v", err)
+		}
+	}
+}
+
+// Encode returns the dump in format, ending with a newline.
+func (dump Dump) Encode(format Format) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		b, err := json.Marshal(dump)
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+	case FormatText:
+		return dump.text(), nil
+	}
+	return nil, fmt.Errorf("unknown statistics format %v", format)
+}
+
+// text renders the dump as a line of counters followed by a table of
+// commands and one of peers, each omitted when empty and aligned on its
+// own.
+func (dump Dump) text() []byte {
+	var buf bytes.Buffer
+	s := dump.Snapshot
+	fmt.Fprintf(&buf, "%s orphaned=%d timed_out=%d handler_timeouts=%d late=%d early=%d accept_errors=%d",
+		s.Timestamp.Format(time.RFC3339), s.OrphanedAnswers, s.TimedOutRequests, s.HandlerTimeouts,
+		s.LateAnswers, s.EarlyMessages, s.AcceptErrors)
+	if s.Sessions != nil {
+		fmt.Fprintf(&buf, " sessions=%d rejected_sessions=%d", s.Sessions.Active, s.Sessions.Rejected)
+	}
+	if dump.Dropped > 0 {
+		fmt.Fprintf(&buf, " dropped_dumps=%d", dump.Dropped)
+	}
+	if dump.Final {
+		buf.WriteString(" final")
+	}
+	buf.WriteByte('\n')
+	tw := tabwriter.NewWriter(&buf, 0, 0, 1, ' ', 0)
+	if len(s.Commands) > 0 {
+		fmt.Fprintln(tw, "  command\tapp\trequests\tp50\tp95\tp99")
+		for _, c := range s.Commands {
+			fmt.Fprintf(tw, "  %s\t%d\t%d\t%v\t%v\t%v\n", commandLabel(c), c.ApplicationID, c.Requests,
+				c.Latency.P50, c.Latency.P95, c.Latency.P99)
+		}
+		tw.Flush()
+	}
+	if len(s.Peers) > 0 {
+		fmt.Fprintln(tw, "  peer\tmsgs_in\tmsgs_out\tbytes_in\tbytes_out\terrors_in\terrors_out")
+		for _, p := range s.Peers {
+			fmt.Fprintf(tw, "  %s\t%d\t%d\t%d\t%d\t%d\t%d\n", p.Peer, p.MessagesIn, p.MessagesOut,
+				p.BytesIn, p.BytesOut, p.ErrorAnswersIn, p.ErrorAnswersOut)
+		}
+	}
+	tw.Flush()
+	return buf.Bytes()
+}
+
+func commandLabel(c CommandStats) string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return fmt.Sprintf("%d", c.CommandCode)
+}
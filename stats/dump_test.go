@@ -0,0 +1,151 @@
+package stats
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/internal/testing/fakeclock"
+)
+
+// blockingWriter sends every write on writes, then blocks until release
+// is closed.
+type blockingWriter struct {
+	writes  chan []byte
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(b []byte) (int, error) {
+	w.writes <- append([]byte(nil), b...)
+	<-w.release
+	return len(b), nil
+}
+
+// TestDumper ticks a Dumper whose writer blocks on the first dump and
+// checks the cadence, that the dumps due meanwhile are dropped and counted,
+// and the final dump.
+func TestDumper(t *testing.T) {
+	clk := fakeclock.New(time.Unix(1_700_000_000, 0))
+	w := &blockingWriter{writes: make(chan []byte), release: make(chan struct{})}
+	// calls lets every snapshot be taken in step with the test.
+	calls := make(chan struct{})
+	var n uint64
+	d := NewDumper(w, time.Minute, FormatJSON, clk, func() Snapshot {
+		calls <- struct{}{}
+		n++
+		return Snapshot{Timestamp: clk.Now(), OrphanedAnswers: n}
+	})
+	next := func() Dump {
+		t.Helper()
+		var dump Dump
+		select {
+		case b := <-w.writes:
+			if !strings.HasSuffix(string(b), "}\n") || strings.Count(string(b), "\n") != 1 {
+				t.Errorf("dump %q is not a line of JSON", b)
+			}
+			if err := json.Unmarshal(b, &dump); err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no dump written")
+		}
+		return dump
+	}
+	tick := func() {
+		t.Helper()
+		clk.Advance(time.Minute)
+		select {
+		case <-calls:
+		case <-time.After(time.Second):
+			t.Fatal("no snapshot taken at the tick")
+		}
+	}
+
+	clk.Advance(59 * time.Second)
+	select {
+	case <-calls:
+		t.Fatal("snapshot taken before the interval")
+	case <-time.After(20 * time.Millisecond):
+	}
+	clk.Advance(time.Second)
+	<-calls
+	if dump := next(); !dump.Timestamp.Equal(time.Unix(1_700_000_060, 0)) || dump.OrphanedAnswers != 1 || dump.Dropped != 0 || dump.Final {
+		t.Errorf("first dump %+v", dump)
+	}
+
+	// The writer is busy with the first dump: the second waits for it and
+	// the next two are dropped.
+	for range 3 {
+		tick()
+	}
+	stopped := make(chan struct{})
+	go func() {
+		d.Stop()
+		close(stopped)
+	}()
+	<-calls
+	close(w.release)
+	if dump := next(); dump.OrphanedAnswers != 2 || dump.Dropped != 0 || dump.Final {
+		t.Errorf("second dump %+v", dump)
+	}
+	if dump := next(); dump.OrphanedAnswers != 5 || dump.Dropped != 2 || !dump.Final {
+		t.Errorf("final dump %+v, want the two dropped counted", dump)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not return once the final dump was written")
+	}
+	d.Stop()
+	(*Dumper)(nil).Stop()
+	if n := clk.Pending(); n != 0 {
+		t.Errorf("%d tickers left running", n)
+	}
+}
+
+func TestDumpText(t *testing.T) {
+	dump := Dump{
+		Snapshot: Snapshot{
+			Timestamp:       time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC),
+			OrphanedAnswers: 3,
+			Sessions:        &SessionStats{Active: 7, Rejected: 1},
+			Commands: []CommandStats{
+				{ApplicationID: 4, CommandCode: 272, Name: "CCR", Requests: 10, Latency: HistogramSnapshot{P50: time.Millisecond, P95: 2 * time.Millisecond, P99: 3 * time.Millisecond}},
+				{ApplicationID: 16777251, CommandCode: 316, Requests: 2},
+			},
+			Peers: []PeerCounters{{Peer: "client.example.com", MessagesIn: 12, MessagesOut: 12, BytesIn: 1200, BytesOut: 1100, ErrorAnswersOut: 1}},
+		},
+		Dropped: 4,
+		Final:   true,
+	}
+	b, err := dump.Encode(FormatText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	want := []string{
+		"2026-10-16T12:00:00Z orphaned=3 timed_out=0 handler_timeouts=0 late=0 early=0 accept_errors=0 sessions=7 rejected_sessions=1 dropped_dumps=4 final",
+		"  command app      requests p50 p95 p99",
+		"  CCR     4        10       1ms 2ms 3ms",
+		"  316     16777251 2        0s  0s  0s",
+		"  peer               msgs_in msgs_out bytes_in bytes_out errors_in errors_out",
+		"  client.example.com 12      12       1200     1100      0         1",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("text dump\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Empty tables and unset counters are left out.
+	b, err = Dump{Snapshot: Snapshot{Timestamp: dump.Timestamp}}.Encode(FormatText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "2026-10-16T12:00:00Z orphaned=0 timed_out=0 handler_timeouts=0 late=0 early=0 accept_errors=0\n"; string(b) != want {
+		t.Errorf("empty text dump %q, want %q", b, want)
+	}
+
+	if _, err := dump.Encode(Format(9)); err == nil {
+		t.Error("Encode in an unknown format did not fail")
+	}
+}
@@ -0,0 +1,106 @@
+// Package stats provides the latency histograms and counters shared by the
+// client and server.
+package stats
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultReservoirSize is the number of samples kept by a Histogram.
+const DefaultReservoirSize = 1024
+
+// Histogram is a streaming latency histogram backed by a uniform reservoir
+// sample. Count, Min, Max and Mean are exact; percentiles are exact until
+// more than the reservoir size has been observed and approximate after.
+type Histogram struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	size    int
+	count   uint64
+	sum     time.Duration
+	min     time.Duration
+	max     time.Duration
+}
+
+// HistogramSnapshot is a point-in-time summary of a Histogram.
+type HistogramSnapshot struct {
+	Count uint64        `json:"count"`
+	Min   time.Duration `json:"min"`
+	Max   time.Duration `json:"max"`
+	Mean  time.Duration `json:"mean"`
+	P50   time.Duration `json:"p50"`
+	P95   time.Duration `json:"p95"`
+	P99   time.Duration `json:"p99"`
+}
+
+// NewHistogram creates a Histogram keeping up to size samples. A size of 0
+// selects DefaultReservoirSize.
+func NewHistogram(size int) *Histogram {
+	if size <= 0 {
+		size = DefaultReservoirSize
+	}
+	return &Histogram{
+		samples: make([]time.Duration, 0, size),
+		size:    size,
+	}
+}
+
+// Observe records one sample.
+func (h *Histogram) Observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.count++
+	h.sum += d
+	if h.count == 1 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	if len(h.samples) < h.size {
+		h.samples = append(h.samples, d)
+		return
+	}
+	// Algorithm R: replace a random slot with probability size/count.
+	if i := rand.Uint64N(h.count); i < uint64(h.size) {
+		h.samples[i] = d
+	}
+}
+
+// Snapshot returns the current summary of the histogram.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	sorted := make([]time.Duration, len(h.samples))
+	copy(sorted, h.samples)
+	snap := HistogramSnapshot{
+		Count: h.count,
+		Min:   h.min,
+		Max:   h.max,
+	}
+	if h.count > 0 {
+		snap.Mean = h.sum / time.Duration(h.count)
+	}
+	h.mu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	snap.P50 = percentile(sorted, 0.50)
+	snap.P95 = percentile(sorted, 0.95)
+	snap.P99 = percentile(sorted, 0.99)
+	return snap
+}
+
+// percentile returns the nearest-rank percentile p of sorted samples.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,101 @@
+package stats
+
+import "time"
+
+// Snapshot is a serializable report of the statistics collected by a client
+// or server.
+type Snapshot struct {
+	Timestamp time.Time      `json:"timestamp"`
+	Commands  []CommandStats `json:"commands"`
+	// OrphanedAnswers counts answers whose Hop-by-Hop Identifier matched
+	// no outstanding request.
+	OrphanedAnswers uint64 `json:"orphaned_answers"`
+	// TimedOutRequests counts requests swept before their answer arrived.
+	TimedOutRequests uint64 `json:"timed_out_requests"`
+	// HandlerTimeouts counts requests answered on behalf of a handler that
+	// did not answer within the handler timeout.
+	HandlerTimeouts uint64 `json:"handler_timeouts"`
+	// LateAnswers counts answers discarded because their handler wrote
+	// them after the request had been answered for it.
+	LateAnswers uint64 `json:"late_answers"`
+	// EarlyMessages counts messages received on a connection before its
+	// capabilities exchange completed, each of which closed it.
+	EarlyMessages uint64 `json:"early_messages"`
+	// AcceptErrors counts temporary errors of the listener after which
+	// accepting connections was retried.
+	AcceptErrors uint64 `json:"accept_errors"`
+	// ProtocolViolations counts the protocol violations scored against
+	// peers when a violation threshold is set.
+	ProtocolViolations uint64 `json:"protocol_violations,omitempty"`
+	// QuarantineRejections counts connections and CERs rejected because
+	// the peer was in quarantine after too many violations.
+	QuarantineRejections uint64 `json:"quarantine_rejections,omitempty"`
+	// ObserverPanics counts the calls of message observers, such as those
+	// registered with OnInboundAnswer, that panicked.
+	ObserverPanics uint64 `json:"observer_panics,omitempty"`
+	// SuppressedLogs counts the error lines left out of the logs because
+	// a peer repeated the error within the error log interval.
+	SuppressedLogs uint64 `json:"suppressed_logs,omitempty"`
+	// InvalidAnswers counts the answers of handlers that failed outbound
+	// validation, whether they were sent anyway or refused.
+	InvalidAnswers uint64 `json:"invalid_answers,omitempty"`
+	// DuplicateCache is nil unless duplicate detection is enabled.
+	DuplicateCache *DuplicateCacheStats `json:"duplicate_cache,omitempty"`
+	// Sessions is nil unless session limits are set.
+	Sessions *SessionStats `json:"sessions,omitempty"`
+	// WriteBatches reports how outbound messages were coalesced.
+	WriteBatches WriteBatchStats `json:"write_batches"`
+	// Peers reports the traffic of every open connection.
+	Peers []PeerCounters `json:"peers,omitempty"`
+}
+
+// WriteBatchStats reports the coalescing of outbound writes. The average
+// batch size is Messages/Batches.
+type WriteBatchStats struct {
+	Batches  uint64 `json:"batches"`
+	Messages uint64 `json:"messages"`
+	Bytes    uint64 `json:"bytes"`
+}
+
+// PeerCounters reports the traffic exchanged with a peer. Requests and
+// answers with the 'E' bit set are counted in both AnswersIn/Out and
+// ErrorAnswersIn/Out; Retransmissions counts received requests with the
+// 'T' bit set. ConnEvents counts SCTP association and peer address
+// changes, which are only reported when events are enabled. Resyncs
+// counts the frame headers that did not parse after which the stream was
+// resynchronized; each also counts as a read error.
+type PeerCounters struct {
+	// Peer identifies the peer in server snapshots.
+	Peer            string    `json:"peer,omitempty"`
+	MessagesIn      uint64    `json:"messages_in"`
+	MessagesOut     uint64    `json:"messages_out"`
+	BytesIn         uint64    `json:"bytes_in"`
+	BytesOut        uint64    `json:"bytes_out"`
+	RequestsIn      uint64    `json:"requests_in"`
+	RequestsOut     uint64    `json:"requests_out"`
+	AnswersIn       uint64    `json:"answers_in"`
+	AnswersOut      uint64    `json:"answers_out"`
+	ErrorAnswersIn  uint64    `json:"error_answers_in"`
+	ErrorAnswersOut uint64    `json:"error_answers_out"`
+	Retransmissions uint64    `json:"retransmissions"`
+	ReadErrors      uint64    `json:"read_errors"`
+	WriteErrors     uint64    `json:"write_errors"`
+	ConnEvents      uint64    `json:"conn_events"`
+	Resyncs         uint64    `json:"resyncs"`
+	LastReceived    time.Time `json:"last_received"`
+	LastSent        time.Time `json:"last_sent"`
+}
+
+// DuplicateCacheStats reports the activity of the duplicate request cache.
+type DuplicateCacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// SessionStats reports the sessions counted for session limits: those
+// open now and the requests rejected for opening one too many.
+type SessionStats struct {
+	Active   int    `json:"active"`
+	Rejected uint64 `json:"rejected"`
+}
@@ -0,0 +1,151 @@
+// Package tap lets applications observe every Diameter frame sent or
+// received by a client or server without blocking the I/O path.
+package tap
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+// Direction tells whether a frame was received or sent.
+type Direction int
+
+const (
+	Inbound Direction = iota
+	Outbound
+)
+
+func (d Direction) String() string {
+	if d == Outbound {
+		return "outbound"
+	}
+	return "inbound"
+}
+
+// Func observes one frame. raw is the encoded message and msg its decoded
+// form; both belong to the tap and may be retained.
+type Func func(direction Direction, peer string, raw []byte, msg *message.DiameterMessage)
+
+// DefaultBufferSize is the number of frames queued before the tap drops.
+const DefaultBufferSize = 1024
+
+type frame struct {
+	direction Direction
+	peer      string
+	raw       []byte
+	msg       *message.DiameterMessage
+}
+
+// Tap delivers frames to a Func on a dedicated goroutine. When the Func
+// falls behind and the buffer is full, frames are dropped and counted
+// rather than stalling the caller.
+type Tap struct {
+	fn     Func
+	frames chan frame
+	// queued counts the frames reserved a place in frames and not yet
+	// taken by run, so that a full buffer is detected before copying.
+	queued  atomic.Int64
+	dropped atomic.Uint64
+	// mu guards closed, so that Observe does not send on frames once
+	// Close has closed it.
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// New starts a Tap invoking fn with a buffer of bufferSize frames. A
+// bufferSize of 0 selects DefaultBufferSize.
+func New(fn Func, bufferSize int) *Tap {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	t := &Tap{
+		fn:     fn,
+		frames: make(chan frame, bufferSize),
+		done:   make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *Tap) run() {
+	defer close(t.done)
+	for f := range t.frames {
+		t.queued.Add(-1)
+		t.fn(f.direction, f.peer, f.raw, f.msg)
+	}
+}
+
+// Observe queues a frame for the tap without blocking. The frame and the
+// message are copied so that the caller may reuse the buffer and later
+// changes are not visible to the tap; a frame that is dropped, because the
+// buffer is full or the tap closed, is not copied.
+func (t *Tap) Observe(direction Direction, peer string, raw []byte, msg *message.DiameterMessage) {
+	if t == nil {
+		return
+	}
+	if t.queued.Add(1) > int64(cap(t.frames)) {
+		t.queued.Add(-1)
+		t.dropped.Add(1)
+		return
+	}
+	f := frame{
+		direction: direction,
+		peer:      peer,
+		raw:       append([]byte(nil), raw...),
+	}
+	if msg != nil {
+		f.msg = msg.Clone()
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		t.dropped.Add(1)
+		return
+	}
+	// The place reserved above keeps the send from blocking.
+	t.frames <- f
+}
+
+// Dropped returns the number of frames dropped because the tap was slow.
+func (t *Tap) Dropped() uint64 {
+	if t == nil {
+		return 0
+	}
+	return t.dropped.Load()
+}
+
+// Close stops the tap after the queued frames have been delivered. Frames
+// observed afterwards are dropped.
+func (t *Tap) Close() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.frames)
+	}
+	t.mu.Unlock()
+	<-t.done
+}
+
+// FrameWriter returns a Func writing every frame to w as a one byte
+// direction, a four byte big-endian length and the raw message, so that a
+// capture can be replayed later. Write errors are ignored.
+func FrameWriter(w io.Writer) Func {
+	var mu sync.Mutex
+	return func(direction Direction, peer string, raw []byte, msg *message.DiameterMessage) {
+		prefix := make([]byte, 5)
+		prefix[0] = byte(direction)
+		binary.BigEndian.PutUint32(prefix[1:], uint32(len(raw)))
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write(prefix)
+		w.Write(raw)
+	}
+}
@@ -0,0 +1,107 @@
+package tap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+func TestTapDeliversInOrder(t *testing.T) {
+	type seen struct {
+		direction Direction
+		peer      string
+		raw       string
+	}
+	var got []seen
+	tp := New(func(direction Direction, peer string, raw []byte, msg *message.DiameterMessage) {
+		got = append(got, seen{direction, peer, string(raw)})
+	}, 0)
+	raw := []byte("first")
+	tp.Observe(Inbound, "a", raw, nil)
+	copy(raw, "xxxxx")
+	tp.Observe(Outbound, "b", []byte("second"), nil)
+	tp.Close()
+
+	want := []seen{{Inbound, "a", "first"}, {Outbound, "b", "second"}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("tap saw %v, want %v", got, want)
+	}
+}
+
+func TestSlowTapDrops(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	delivered := 0
+	tp := New(func(Direction, string, []byte, *message.DiameterMessage) {
+		started <- struct{}{}
+		<-release
+		delivered++
+	}, 1)
+
+	tp.Observe(Inbound, "peer", []byte("1"), nil)
+	<-started
+	done := make(chan struct{})
+	go func() {
+		// One frame fits the buffer, the others are dropped.
+		for range 10 {
+			tp.Observe(Inbound, "peer", []byte("2"), nil)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Observe blocked on a slow tap")
+	}
+	if got := tp.Dropped(); got != 9 {
+		t.Errorf("Dropped() = %d, want 9", got)
+	}
+	close(release)
+	tp.Close()
+	if delivered != 2 {
+		t.Errorf("%d frames delivered, want 2", delivered)
+	}
+}
+
+func TestObserveAfterClose(t *testing.T) {
+	delivered := 0
+	tp := New(func(Direction, string, []byte, *message.DiameterMessage) {
+		delivered++
+	}, 1)
+	tp.Observe(Inbound, "peer", []byte("1"), nil)
+	tp.Close()
+	tp.Observe(Inbound, "peer", []byte("2"), nil)
+	tp.Close()
+	if delivered != 1 || tp.Dropped() != 1 {
+		t.Errorf("%d frames delivered and %d dropped, want 1 and 1", delivered, tp.Dropped())
+	}
+}
+
+func TestNilTap(t *testing.T) {
+	var tp *Tap
+	tp.Observe(Inbound, "peer", []byte("frame"), nil)
+	if tp.Dropped() != 0 {
+		t.Error("nil tap dropped frames")
+	}
+	tp.Close()
+}
+
+func TestFrameWriter(t *testing.T) {
+	var b bytes.Buffer
+	fn := FrameWriter(&b)
+	fn(Inbound, "a", []byte("abc"), nil)
+	fn(Outbound, "b", []byte("de"), nil)
+
+	want := []byte{byte(Inbound)}
+	want = binary.BigEndian.AppendUint32(want, 3)
+	want = append(want, "abc"...)
+	want = append(want, byte(Outbound))
+	want = binary.BigEndian.AppendUint32(want, 2)
+	want = append(want, "de"...)
+	if !bytes.Equal(b.Bytes(), want) {
+		t.Errorf("wrote %x, want %x", b.Bytes(), want)
+	}
+}
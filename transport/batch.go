@@ -0,0 +1,307 @@
+// Coalescing writer for outbound messages
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DefaultBatchBytes is the default byte budget of one coalesced write.
+const DefaultBatchBytes = 64 * 1024
+
+// DefaultLinger is the default time given to the answers still queued for
+// a peer that closed its side of the connection before ours is closed.
+const DefaultLinger = 5 * time.Second
+
+// DefaultWriteFailureThreshold is the default number of consecutive timed
+// out writes after which a BatchWriter declares its peer down.
+const DefaultWriteFailureThreshold = 3
+
+// BatchOptions configures a BatchWriter.
+type BatchOptions struct {
+	// MaxBytes bounds the size of one coalesced write. A message larger
+	// than MaxBytes is written on its own.
+	MaxBytes int
+	// FlushInterval is how long the writer waits for more messages after
+	// the first one of a batch. With 0 only the messages already queued
+	// are coalesced, so a lone message is never delayed.
+	FlushInterval time.Duration
+	// Stats, when set, accumulates the batch metrics of the writer.
+	Stats *BatchStats
+	// FailureThreshold is the number of consecutive timed out writes
+	// after which the peer is declared down. A broken connection, or a
+	// timed out write that sent part of a batch, declares it down at
+	// once. With 0, DefaultWriteFailureThreshold applies.
+	FailureThreshold int
+	// OnPeerDown, when set, is called once from the writer goroutine when
+	// the writer declares its peer down, with an error wrapping
+	// ErrPeerDown. The writer is closed by then.
+	OnPeerDown func(err error)
+}
+
+// BatchStats counts coalesced writes. Messages/Batches is the average
+// batch size.
+type BatchStats struct {
+	Batches  atomic.Uint64
+	Messages atomic.Uint64
+	Bytes    atomic.Uint64
+}
+
+// Load returns the current counter values.
+func (s *BatchStats) Load() (batches, messages, bytes uint64) {
+	return s.Batches.Load(), s.Messages.Load(), s.Bytes.Load()
+}
+
+type writeRequest struct {
+	data []byte
+	done chan error
+	// drain marks the request queued by Drain, which writes nothing.
+	drain bool
+}
+
+// BatchWriter serializes the writes of a connection on a dedicated
+// goroutine and coalesces messages queued concurrently into a single
+// vectored write.
+type BatchWriter struct {
+	conn      *DiameterConnection
+	opts      BatchOptions
+	queue     chan writeRequest
+	closed    chan struct{}
+	closeOnce sync.Once
+	// stopped is closed when the writer goroutine returns.
+	stopped chan struct{}
+	// err is the error of writes on the closed writer. It is set before
+	// closed is closed.
+	err error
+	// timeouts counts consecutive timed out writes. It is only used by
+	// the writer goroutine.
+	timeouts int
+}
+
+// NewBatchWriter starts a BatchWriter for conn.
+func NewBatchWriter(conn *DiameterConnection, opts BatchOptions) *BatchWriter {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultBatchBytes
+	}
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = DefaultWriteFailureThreshold
+	}
+	w := &BatchWriter{
+		conn:    conn,
+		opts:    opts,
+		queue:   make(chan writeRequest, 128),
+		closed:  make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Write queues data and waits until it has been written as part of a
+// batch. data must not be modified until Write returns.
+func (w *BatchWriter) Write(data []byte) error {
+	req := writeRequest{data: data, done: make(chan error, 1)}
+	select {
+	case w.queue <- req:
+	case <-w.closed:
+		return w.err
+	}
+	select {
+	case err := <-req.done:
+		return err
+	case <-w.closed:
+		return w.err
+	}
+}
+
+// Drain waits, for at most timeout, until every message queued before the
+// call has been written. It returns ErrDrainTimeout if they were not, or
+// the error of the writer if it is closed.
+func (w *BatchWriter) Drain(timeout time.Duration) error {
+	req := writeRequest{done: make(chan error, 1), drain: true}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case w.queue <- req:
+	case <-w.closed:
+		return w.err
+	case <-timer.C:
+		return ErrDrainTimeout
+	}
+	select {
+	case err := <-req.done:
+		return err
+	case <-w.closed:
+		return w.err
+	case <-timer.C:
+		return ErrDrainTimeout
+	}
+}
+
+// Close stops the writer. Queued messages that were not written yet fail
+// with ErrWriterClosed.
+func (w *BatchWriter) Close() {
+	w.closeWith(ErrWriterClosed)
+}
+
+// closeWith stops the writer, failing queued messages with err, unless it
+// was already stopped.
+func (w *BatchWriter) closeWith(err error) bool {
+	closed := false
+	w.closeOnce.Do(func() {
+		w.err = err
+		close(w.closed)
+		closed = true
+	})
+	return closed
+}
+
+// Wait blocks until the writer goroutine has returned after Close. A
+// write in progress only ends when the connection is closed or its write
+// deadline expires.
+func (w *BatchWriter) Wait() {
+	<-w.stopped
+}
+
+func (w *BatchWriter) run() {
+	defer close(w.stopped)
+	var timer *time.Timer
+	for {
+		var first writeRequest
+		select {
+		case first = <-w.queue:
+		case <-w.closed:
+			return
+		}
+
+		batch := []writeRequest{first}
+		size := len(first.data)
+		var deadline <-chan time.Time
+		if w.opts.FlushInterval > 0 {
+			if timer == nil {
+				timer = time.NewTimer(w.opts.FlushInterval)
+			} else {
+				timer.Reset(w.opts.FlushInterval)
+			}
+			deadline = timer.C
+		}
+	collect:
+		for size < w.opts.MaxBytes {
+			if deadline == nil {
+				select {
+				case req := <-w.queue:
+					batch = append(batch, req)
+					size += len(req.data)
+				default:
+					break collect
+				}
+				continue
+			}
+			select {
+			case req := <-w.queue:
+				batch = append(batch, req)
+				size += len(req.data)
+			case <-deadline:
+				deadline = nil
+				break collect
+			case <-w.closed:
+				break collect
+			}
+		}
+		if deadline != nil && !timer.Stop() {
+			<-timer.C
+		}
+		w.flush(batch, size)
+	}
+}
+
+// flush writes batch with a single vectored write and reports the result
+// to every caller.
+func (w *BatchWriter) flush(batch []writeRequest, size int) {
+	select {
+	case <-w.closed:
+		for _, req := range batch {
+			req.done <- w.err
+		}
+		return
+	default:
+	}
+	bufs := make(net.Buffers, 0, len(batch))
+	for _, req := range batch {
+		if !req.drain {
+			bufs = append(bufs, req.data)
+		}
+	}
+	var err error
+	if len(bufs) > 0 {
+		err = w.write(bufs, batch)
+		if st := w.opts.Stats; st != nil {
+			st.Batches.Add(1)
+			st.Messages.Add(uint64(len(bufs)))
+			st.Bytes.Add(uint64(size))
+		}
+	}
+	for _, req := range batch {
+		req.done <- err
+	}
+}
+
+// write sends bufs, the messages of batch, with a single vectored write
+// and returns the error to report to the callers of the batch.
+func (w *BatchWriter) write(bufs net.Buffers, batch []writeRequest) error {
+	n, err := w.conn.WriteBuffers(bufs)
+	if err != nil {
+		w.conn.counters.writeFailed(len(bufs))
+		return w.failed(err, n)
+	}
+	w.timeouts = 0
+	for _, req := range batch {
+		if !req.drain {
+			w.conn.counters.sent(req.data)
+		}
+	}
+	return nil
+}
+
+// failed applies the failure policy to a write that sent n bytes before
+// failing with err. It returns the error to report to the callers of the
+// batch, which wraps ErrPeerDown once the peer has been declared down.
+func (w *BatchWriter) failed(err error, n int64) error {
+	switch {
+	case IsTimeout(err) && n == 0:
+		w.timeouts++
+		if w.timeouts < w.opts.FailureThreshold {
+			return err
+		}
+		err = fmt.Errorf("%w: %d consecutive write timeouts: %w", ErrPeerDown, w.timeouts, err)
+	case IsTimeout(err), IsConnectionLost(err):
+		err = fmt.Errorf("%w: %w", ErrPeerDown, err)
+	default:
+		return err
+	}
+	if w.closeWith(err) && w.opts.OnPeerDown != nil {
+		w.opts.OnPeerDown(err)
+	}
+	return err
+}
+
+// IsTimeout reports whether err is a timeout of a read or write deadline.
+func IsTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// IsConnectionLost reports whether err means the peer reset or closed the
+// connection under a write.
+func IsConnectionLost(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED)
+}
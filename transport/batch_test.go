@@ -0,0 +1,285 @@
+package transport
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/message"
+)
+
+// tcpPair returns the two ends of a loopback TCP connection, the first
+// wrapped in a DiameterConnection.
+func tcpPair(t testing.TB) (*DiameterConnection, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	peer, ok := <-accepted
+	if !ok {
+		t.Fatal("accept failed")
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		peer.Close()
+	})
+	return &DiameterConnection{conn: conn, protocol: Proto_TCP}, peer
+}
+
+// testFrame returns an encoded DWR whose identifiers are writer and seq,
+// padded with a Product-Name of size bytes.
+func testFrame(t testing.TB, writer, seq uint32, size int) []byte {
+	t.Helper()
+	req, err := message.NewRequest(message.COMMAND_CODE_DEVICE_WATCHDOG, message.WithAVPs(
+		message.MustNewAVP(message.AVP_ORIGIN_HOST, "client.example.com", message.MANDATORY_FLAG),
+		message.MustNewAVP(message.AVP_ORIGIN_REALM, "example.com", message.MANDATORY_FLAG),
+		message.MustNewAVP(message.AVP_PRODUCT_NAME, string(make([]byte, size)), 0),
+	))
+	if err != nil {
+		t.Fatalf("building DWR: %v", err)
+	}
+	req.Header.HopByHopID = writer
+	req.Header.EndToEndID = seq
+	data, err := req.Encode()
+	if err != nil {
+		t.Fatalf("encoding DWR: %v", err)
+	}
+	return data
+}
+
+// TestBatchWriterStream writes from several goroutines at once and
+// decodes the coalesced stream: every message arrives whole, once, and in
+// the order of its writer.
+func TestBatchWriterStream(t *testing.T) {
+	conn, peer := tcpPair(t)
+	var st BatchStats
+	w := NewBatchWriter(conn, BatchOptions{MaxBytes: 4096, FlushInterval: time.Millisecond, Stats: &st})
+	defer w.Close()
+
+	const writers, perWriter = 8, 300
+	var sent uint64
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for writer := range uint32(writers) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seq := range uint32(perWriter) {
+				frame := testFrame(t, writer, seq, int(seq%7)*100)
+				if err := w.Write(frame); err != nil {
+					t.Errorf("writer %d: %v", writer, err)
+					return
+				}
+				mu.Lock()
+				sent += uint64(len(frame))
+				mu.Unlock()
+			}
+		}()
+	}
+
+	reader := &DiameterConnection{conn: peer, protocol: Proto_TCP}
+	next := make([]uint32, writers)
+	for range writers * perWriter {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("reading: %v", err)
+		}
+		msg, err := message.DecodeMessage(frame)
+		if err != nil {
+			t.Fatalf("decoding: %v", err)
+		}
+		writer, seq := msg.Header.HopByHopID, msg.Header.EndToEndID
+		if writer >= writers || seq != next[writer] {
+			t.Fatalf("message %d of writer %d arrived, want %d", seq, writer, next[writer])
+		}
+		next[writer]++
+	}
+	wg.Wait()
+
+	batches, messages, bytes := st.Load()
+	if messages != writers*perWriter || bytes != sent {
+		t.Errorf("stats count %d messages and %d bytes, want %d and %d", messages, bytes, writers*perWriter, sent)
+	}
+	if batches == 0 || batches > messages {
+		t.Errorf("%d batches for %d messages", batches, messages)
+	}
+	t.Logf("%d messages in %d batches", messages, batches)
+}
+
+func TestBatchWriterLoneMessage(t *testing.T) {
+	const flush = 50 * time.Millisecond
+	conn, peer := tcpPair(t)
+	w := NewBatchWriter(conn, BatchOptions{FlushInterval: flush})
+	defer w.Close()
+
+	frame := testFrame(t, 0, 0, 0)
+	start := time.Now()
+	if err := w.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > flush+time.Second {
+		t.Errorf("lone message written after %v, flush interval %v", elapsed, flush)
+	}
+	got := make([]byte, len(frame))
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(peer, got); err != nil {
+		t.Fatalf("reading: %v", err)
+	}
+}
+
+func TestBatchWriterOversizedMessage(t *testing.T) {
+	conn, peer := tcpPair(t)
+	var st BatchStats
+	w := NewBatchWriter(conn, BatchOptions{MaxBytes: 256, Stats: &st})
+	defer w.Close()
+	go io.Copy(io.Discard, peer)
+
+	big := testFrame(t, 0, 0, 1000)
+	if err := w.Write(big); err != nil {
+		t.Fatal(err)
+	}
+	if batches, messages, _ := st.Load(); batches != 1 || messages != 1 {
+		t.Errorf("%d batches of %d messages, want the message on its own", batches, messages)
+	}
+}
+
+func TestBatchWriterClosed(t *testing.T) {
+	conn, _ := tcpPair(t)
+	w := NewBatchWriter(conn, BatchOptions{})
+	w.Close()
+	w.Wait()
+	if err := w.Write(testFrame(t, 0, 0, 0)); err != ErrWriterClosed {
+		t.Errorf("Write after Close = %v, want ErrWriterClosed", err)
+	}
+}
+
+// benchmarkWrites writes small messages with write from 16 goroutines
+// per CPU, standing for concurrent handlers answering on one connection,
+// while the peer discards them.
+func benchmarkWrites(b *testing.B, write func(conn *DiameterConnection) func([]byte) error) {
+	conn, peer := tcpPair(b)
+	go io.Copy(io.Discard, peer)
+	send := write(conn)
+	frame := testFrame(b, 0, 0, 0)
+	b.SetBytes(int64(len(frame)))
+	b.SetParallelism(16)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := send(frame); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkWritePerMessage writes each message with its own system call,
+// serialized by a mutex.
+func BenchmarkWritePerMessage(b *testing.B) {
+	benchmarkWrites(b, func(conn *DiameterConnection) func([]byte) error {
+		var mu sync.Mutex
+		return func(data []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			_, err := conn.Write(data)
+			return err
+		}
+	})
+}
+
+// BenchmarkBatchWriter coalesces the messages with a 64 KB budget,
+// without and with a flush interval, reporting the average number of
+// messages per write.
+func BenchmarkBatchWriter(b *testing.B) {
+	for _, flush := range []time.Duration{0, time.Millisecond} {
+		b.Run("flush="+flush.String(), func(b *testing.B) {
+			var st BatchStats
+			benchmarkWrites(b, func(conn *DiameterConnection) func([]byte) error {
+				w := NewBatchWriter(conn, BatchOptions{FlushInterval: flush, Stats: &st})
+				b.Cleanup(w.Close)
+				return w.Write
+			})
+			if batches, messages, _ := st.Load(); batches > 0 {
+				b.ReportMetric(float64(messages)/float64(batches), "msgs/batch")
+			}
+		})
+	}
+}
+
+// TestBatchWriterDrain checks that Drain returns once the messages queued
+// before it are written, without counting itself as a message.
+func TestBatchWriterDrain(t *testing.T) {
+	conn, peer := tcpPair(t)
+	var st BatchStats
+	w := NewBatchWriter(conn, BatchOptions{FlushInterval: 100 * time.Millisecond, Stats: &st})
+	defer w.Close()
+
+	const n = 3
+	var wg sync.WaitGroup
+	for seq := range uint32(n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.Write(testFrame(t, 0, seq, 0)); err != nil {
+				t.Errorf("write %d: %v", seq, err)
+			}
+		}()
+	}
+	// The writes are queued, waiting for the flush interval to end.
+	time.Sleep(10 * time.Millisecond)
+	if err := w.Drain(5 * time.Second); err != nil {
+		t.Fatalf("Drain = %v", err)
+	}
+	if _, messages, _ := st.Load(); messages != n {
+		t.Errorf("%d messages written when Drain returned, want %d", messages, n)
+	}
+	reader := &DiameterConnection{conn: peer, protocol: Proto_TCP}
+	for range n {
+		if _, err := reader.ReadFrame(); err != nil {
+			t.Fatalf("reading: %v", err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestBatchWriterDrainTimeout(t *testing.T) {
+	conn, _ := tcpPair(t)
+	w := NewBatchWriter(conn, BatchOptions{})
+	// The peer reads nothing, so the writes fill the socket buffers and
+	// block.
+	big := testFrame(t, 0, 0, 4<<20)
+	for range 8 {
+		go w.Write(big)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := w.Drain(50 * time.Millisecond); err != ErrDrainTimeout {
+		t.Errorf("Drain = %v, want ErrDrainTimeout", err)
+	}
+
+	w.Close()
+	// The write in progress only ends with the connection.
+	conn.Close()
+	w.Wait()
+	if err := w.Drain(time.Second); err != ErrWriterClosed {
+		t.Errorf("Drain after Close = %v, want ErrWriterClosed", err)
+	}
+}
@@ -0,0 +1,48 @@
+// Reusable frame buffers for the read path
+package transport
+
+import "sync"
+
+// DefaultMaxMessageSize is the frame size served from a BufferPool unless
+// a different maximum message size is negotiated.
+const DefaultMaxMessageSize = 64 * 1024
+
+// BufferPool recycles frame buffers between reads. Buffers larger than the
+// maximum message size are allocated on demand and never pooled, so the
+// pool does not grow with occasional oversized messages.
+type BufferPool struct {
+	maxSize int
+	pool    sync.Pool
+}
+
+// NewBufferPool returns a pool of buffers of maxSize bytes. A maxSize of 0
+// selects DefaultMaxMessageSize.
+func NewBufferPool(maxSize int) *BufferPool {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	p := &BufferPool{maxSize: maxSize}
+	p.pool.New = func() any {
+		b := make([]byte, maxSize)
+		return &b
+	}
+	return p
+}
+
+// Get returns a buffer of length n.
+func (p *BufferPool) Get(n int) []byte {
+	if n > p.maxSize {
+		return make([]byte, n)
+	}
+	b := p.pool.Get().(*[]byte)
+	return (*b)[:n]
+}
+
+// Put returns b to the pool. The caller must not use b afterwards.
+func (p *BufferPool) Put(b []byte) {
+	if cap(b) != p.maxSize {
+		return
+	}
+	b = b[:cap(b)]
+	p.pool.Put(&b)
+}
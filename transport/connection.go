@@ -2,6 +2,8 @@
 package transport
 
 import (
+	"context"
+	"fmt"
 	"github.com/ishidawataru/sctp"
 	"log"
 	"net"
@@ -16,6 +18,28 @@ const (
 	Proto_SCTP
 )
 
+// CheckAddr checks, without resolving or dialing it, that addr has the
+// form protocol expects: host:port for TCP and an IP address for SCTP.
+func CheckAddr(addr string, protocol ProtocolType) error {
+	switch protocol {
+	case Proto_TCP:
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("%w %q: %v", ErrInvalidAddress, addr, err)
+		}
+		if _, err := net.LookupPort("tcp", port); err != nil {
+			return fmt.Errorf("%w %q: unknown port %q", ErrInvalidAddress, addr, port)
+		}
+	case Proto_SCTP:
+		if net.ParseIP(addr) == nil {
+			return fmt.Errorf("%w %q: SCTP needs an IP address", ErrInvalidAddress, addr)
+		}
+	default:
+		return UnsupportedProtocol
+	}
+	return nil
+}
+
 // DiameterConnection manages a network connection (TCP or SCTP) for Diameter
 // communication.
 type DiameterConnection struct {
@@ -23,23 +47,33 @@ type DiameterConnection struct {
 	readTimeout  time.Duration
 	writeTimeout time.Duration
 	protocol     ProtocolType
+	counters     *Counters
+	events       func(ConnEvent)
+	resyncWindow int
 }
 
 // NewDiameterConnection establishes a new connection to a server
-// (client-side).
+// (client-side). Over TCP every address the host resolves to is tried in
+// turn within timeout, see DialTCP.
 func NewDiameterConnection(
 	addr string,
 	protocol ProtocolType,
 	timeout time.Duration,
 ) (*DiameterConnection, error) {
+	return DialDiameterConnection(addr, protocol, DialOptions{Timeout: timeout})
+}
+
+// DialDiameterConnection is NewDiameterConnection with control over the
+// attempts on each resolved address. SCTP dials the IP address addr
+// without applying opts.
+func DialDiameterConnection(addr string, protocol ProtocolType, opts DialOptions) (*DiameterConnection, error) {
 
 	var conn net.Conn
 	var err error
 
 	switch protocol {
 	case Proto_TCP:
-		dialer := net.Dialer{Timeout: timeout}
-		conn, err = dialer.Dial("tcp", addr)
+		conn, err = DialTCP(context.Background(), addr, opts)
 	case Proto_SCTP:
 		conn, err = sctp.DialSCTP("sctp", nil, &sctp.SCTPAddr{IPAddrs: []net.IPAddr{{IP: net.ParseIP(addr)}}})
 	}
@@ -76,6 +110,15 @@ func (dc *DiameterConnection) Write(data []byte) (int, error) {
 	return n, nil
 }
 
+// WriteBuffers writes bufs with a single vectored write where the
+// transport supports it.
+func (dc *DiameterConnection) WriteBuffers(bufs net.Buffers) (int64, error) {
+	if dc.writeTimeout > 0 {
+		dc.conn.SetWriteDeadline(time.Now().Add(dc.writeTimeout))
+	}
+	return bufs.WriteTo(dc.conn)
+}
+
 // Close closes the Diameter connection.
 func (dc *DiameterConnection) Close() error {
 	log.Println("Closing connection.")
@@ -88,3 +131,31 @@ func (dc *DiameterConnection) SetTimeouts(
 	dc.readTimeout = readTimeout
 	dc.writeTimeout = writeTimeout
 }
+
+// RemoteAddr returns the remote network address of the connection.
+func (dc *DiameterConnection) RemoteAddr() net.Addr {
+	return dc.conn.RemoteAddr()
+}
+
+// LocalAddr returns the local network address of the connection.
+func (dc *DiameterConnection) LocalAddr() net.Addr {
+	return dc.conn.LocalAddr()
+}
+
+// HostIP returns the IP address of a transport address, or nil if it has
+// none.
+func HostIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,30 @@
+package transport
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckAddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr     string
+		protocol ProtocolType
+		want     error
+	}{
+		{"127.0.0.1:3868", Proto_TCP, nil},
+		{"[::1]:3868", Proto_TCP, nil},
+		{"diameter.example.com:3868", Proto_TCP, nil},
+		{":0", Proto_TCP, nil},
+		{"127.0.0.1", Proto_TCP, ErrInvalidAddress},
+		{"127.0.0.1:diameterx", Proto_TCP, ErrInvalidAddress},
+		{"127.0.0.1:70000", Proto_TCP, ErrInvalidAddress},
+		{"127.0.0.1", Proto_SCTP, nil},
+		{"::1", Proto_SCTP, nil},
+		{"diameter.example.com", Proto_SCTP, ErrInvalidAddress},
+		{"127.0.0.1:3868", ProtocolType(7), UnsupportedProtocol},
+	} {
+		if err := CheckAddr(tc.addr, tc.protocol); !errors.Is(err, tc.want) {
+			t.Errorf("CheckAddr(%q, %d) = %v, want %v", tc.addr, tc.protocol, err, tc.want)
+		}
+	}
+}
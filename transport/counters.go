@@ -0,0 +1,168 @@
+// Per-connection traffic counters
+package transport
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/stats"
+)
+
+// Command flag bits of the Diameter header, see RFC 6733 section 3.
+const (
+	flagRequest       = 0x80
+	flagError         = 0x20
+	flagRetransmitted = 0x10
+)
+
+// Counters accumulates the traffic of a connection. The framing layer
+// updates them once per message, including for messages coalesced into a
+// single write by a BatchWriter. The counters wrap around on overflow, so
+// rates computed from the difference of two snapshots stay correct.
+type Counters struct {
+	messagesIn      atomic.Uint64
+	messagesOut     atomic.Uint64
+	bytesIn         atomic.Uint64
+	bytesOut        atomic.Uint64
+	requestsIn      atomic.Uint64
+	requestsOut     atomic.Uint64
+	answersIn       atomic.Uint64
+	answersOut      atomic.Uint64
+	errorAnswersIn  atomic.Uint64
+	errorAnswersOut atomic.Uint64
+	retransmissions atomic.Uint64
+	readErrors      atomic.Uint64
+	writeErrors     atomic.Uint64
+	connEvents      atomic.Uint64
+	resyncs         atomic.Uint64
+	lastReceived    atomic.Int64
+	lastSent        atomic.Int64
+}
+
+// SetCounters makes the connection record its traffic in c, which may be
+// shared by successive connections to the same peer.
+func (dc *DiameterConnection) SetCounters(c *Counters) {
+	dc.counters = c
+}
+
+func (c *Counters) received(frame []byte) {
+	if c == nil {
+		return
+	}
+	c.messagesIn.Add(1)
+	c.bytesIn.Add(uint64(len(frame)))
+	c.lastReceived.Store(time.Now().UnixNano())
+	flags := frame[4]
+	switch {
+	case flags&flagRequest != 0:
+		c.requestsIn.Add(1)
+		if flags&flagRetransmitted != 0 {
+			c.retransmissions.Add(1)
+		}
+	case flags&flagError != 0:
+		c.answersIn.Add(1)
+		c.errorAnswersIn.Add(1)
+	default:
+		c.answersIn.Add(1)
+	}
+}
+
+func (c *Counters) sent(frame []byte) {
+	if c == nil {
+		return
+	}
+	c.messagesOut.Add(1)
+	c.bytesOut.Add(uint64(len(frame)))
+	c.lastSent.Store(time.Now().UnixNano())
+	flags := frame[4]
+	switch {
+	case flags&flagRequest != 0:
+		c.requestsOut.Add(1)
+	case flags&flagError != 0:
+		c.answersOut.Add(1)
+		c.errorAnswersOut.Add(1)
+	default:
+		c.answersOut.Add(1)
+	}
+}
+
+// readFailed counts a failed read. The end of the connection is not an
+// error.
+func (c *Counters) readFailed(err error) {
+	if c == nil || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return
+	}
+	c.readErrors.Add(1)
+}
+
+func (c *Counters) writeFailed(messages int) {
+	if c == nil {
+		return
+	}
+	c.writeErrors.Add(uint64(messages))
+}
+
+// resynced counts a stream resynchronized after err, which the read that
+// recovered does not report.
+func (c *Counters) resynced(err error) {
+	if c == nil {
+		return
+	}
+	c.readFailed(err)
+	c.resyncs.Add(1)
+}
+
+func (c *Counters) connEvent() {
+	if c == nil {
+		return
+	}
+	c.connEvents.Add(1)
+}
+
+// Snapshot returns the current counter values. Each value is read
+// atomically, but traffic may be counted between two of them.
+func (c *Counters) Snapshot() stats.PeerCounters {
+	return stats.PeerCounters{
+		MessagesIn:      c.messagesIn.Load(),
+		MessagesOut:     c.messagesOut.Load(),
+		BytesIn:         c.bytesIn.Load(),
+		BytesOut:        c.bytesOut.Load(),
+		RequestsIn:      c.requestsIn.Load(),
+		RequestsOut:     c.requestsOut.Load(),
+		AnswersIn:       c.answersIn.Load(),
+		AnswersOut:      c.answersOut.Load(),
+		ErrorAnswersIn:  c.errorAnswersIn.Load(),
+		ErrorAnswersOut: c.errorAnswersOut.Load(),
+		Retransmissions: c.retransmissions.Load(),
+		ReadErrors:      c.readErrors.Load(),
+		WriteErrors:     c.writeErrors.Load(),
+		ConnEvents:      c.connEvents.Load(),
+		Resyncs:         c.resyncs.Load(),
+		LastReceived:    unixNano(c.lastReceived.Load()),
+		LastSent:        unixNano(c.lastSent.Load()),
+	}
+}
+
+// Reset sets every counter back to zero.
+func (c *Counters) Reset() {
+	for _, v := range []*atomic.Uint64{
+		&c.messagesIn, &c.messagesOut, &c.bytesIn, &c.bytesOut,
+		&c.requestsIn, &c.requestsOut, &c.answersIn, &c.answersOut,
+		&c.errorAnswersIn, &c.errorAnswersOut, &c.retransmissions,
+		&c.readErrors, &c.writeErrors, &c.connEvents, &c.resyncs,
+	} {
+		v.Store(0)
+	}
+	c.lastReceived.Store(0)
+	c.lastSent.Store(0)
+}
+
+func unixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
@@ -0,0 +1,105 @@
+package transport
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCountersClassify(t *testing.T) {
+	var c Counters
+	frame := func(flags byte) []byte {
+		f := make([]byte, 20)
+		f[4] = flags
+		return f
+	}
+	c.received(frame(flagRequest))
+	c.received(frame(flagRequest | flagRetransmitted))
+	c.received(frame(0))
+	c.received(frame(flagError))
+	c.sent(frame(flagRequest))
+	c.sent(frame(flagError))
+
+	got := c.Snapshot()
+	if got.MessagesIn != 4 || got.RequestsIn != 2 || got.Retransmissions != 1 || got.AnswersIn != 2 || got.ErrorAnswersIn != 1 || got.BytesIn != 80 {
+		t.Errorf("inbound counters %+v", got)
+	}
+	if got.MessagesOut != 2 || got.RequestsOut != 1 || got.AnswersOut != 1 || got.ErrorAnswersOut != 1 || got.BytesOut != 40 {
+		t.Errorf("outbound counters %+v", got)
+	}
+	if got.LastReceived.IsZero() || got.LastSent.IsZero() {
+		t.Errorf("last activity not recorded: %+v", got)
+	}
+
+	c.Reset()
+	if got := c.Snapshot(); !got.LastReceived.IsZero() || got.MessagesIn != 0 || got.BytesOut != 0 {
+		t.Errorf("counters %+v after Reset", got)
+	}
+}
+
+// TestCountersWrap checks that a counter wraps around on overflow, so that
+// the difference of two snapshots taken across the overflow is still the
+// traffic in between.
+func TestCountersWrap(t *testing.T) {
+	var c Counters
+	c.messagesIn.Store(math.MaxUint64 - 1)
+	c.bytesIn.Store(math.MaxUint64 - 9)
+	before := c.Snapshot()
+	for range 3 {
+		c.received(make([]byte, 20))
+	}
+	after := c.Snapshot()
+	if after.MessagesIn != 1 || after.MessagesIn-before.MessagesIn != 3 {
+		t.Errorf("messages %d after %d, want 1 and a difference of 3", after.MessagesIn, before.MessagesIn)
+	}
+	if after.BytesIn-before.BytesIn != 60 {
+		t.Errorf("bytes %d after %d, want a difference of 60", after.BytesIn, before.BytesIn)
+	}
+}
+
+// TestCountersPerMessage checks that messages coalesced by a BatchWriter
+// are counted one by one, on the writing and on the reading side.
+func TestCountersPerMessage(t *testing.T) {
+	conn, peer := tcpPair(t)
+	var out, in Counters
+	conn.SetCounters(&out)
+	var st BatchStats
+	w := NewBatchWriter(conn, BatchOptions{MaxBytes: 4096, FlushInterval: time.Millisecond, Stats: &st})
+	defer w.Close()
+
+	const writers, perWriter = 8, 100
+	var wg sync.WaitGroup
+	for writer := range uint32(writers) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seq := range uint32(perWriter) {
+				if err := w.Write(testFrame(t, writer, seq, 100)); err != nil {
+					t.Errorf("writer %d: %v", writer, err)
+					return
+				}
+			}
+		}()
+	}
+	reader := &DiameterConnection{conn: peer, protocol: Proto_TCP}
+	reader.SetCounters(&in)
+	for range writers * perWriter {
+		if _, err := reader.ReadFrame(); err != nil {
+			t.Fatalf("reading: %v", err)
+		}
+	}
+	wg.Wait()
+
+	batches, messages, bytes := st.Load()
+	if batches >= messages {
+		t.Fatalf("%d batches for %d messages: nothing was coalesced", batches, messages)
+	}
+	sent, received := out.Snapshot(), in.Snapshot()
+	if sent.MessagesOut != writers*perWriter || sent.RequestsOut != writers*perWriter || sent.BytesOut != bytes {
+		t.Errorf("sent %d messages, %d requests, %d bytes; want %d and %d bytes", sent.MessagesOut, sent.RequestsOut, sent.BytesOut, writers*perWriter, bytes)
+	}
+	if received.MessagesIn != writers*perWriter || received.BytesIn != bytes {
+		t.Errorf("received %d messages of %d bytes, want %d of %d", received.MessagesIn, received.BytesIn, writers*perWriter, bytes)
+	}
+}
@@ -0,0 +1,202 @@
+// Connection establishment across every resolved address
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Resolver looks up the addresses of a host name. *net.Resolver
+// implements it.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// DialOptions controls how a TCP connection is established when the host
+// resolves to several addresses, such as a DRA behind DNS round-robin.
+type DialOptions struct {
+	// Timeout bounds the whole establishment, resolution included. 0
+	// means no limit.
+	Timeout time.Duration
+	// AttemptTimeout bounds each address attempt, so that an unreachable
+	// address does not use up Timeout. 0 leaves only Timeout.
+	AttemptTimeout time.Duration
+	// FallbackDelay, when positive, starts the attempt on the next
+	// address after this delay even though the previous attempt is still
+	// running, as RFC 8305 does. Addresses are then ordered alternating
+	// between IPv6 and IPv4. 0 tries the addresses one after the other.
+	FallbackDelay time.Duration
+	// Resolver defaults to net.DefaultResolver.
+	Resolver Resolver
+	// dialContext, when set, replaces net.Dialer for each attempt.
+	dialContext func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// DialAttempt is the failure of the attempt on one address.
+type DialAttempt struct {
+	Addr string
+	Err  error
+}
+
+// DialError is returned when no address of the host could be connected
+// to, listing each address attempted and its failure in the order they
+// were tried.
+type DialError struct {
+	Addr     string
+	Attempts []DialAttempt
+}
+
+func (e *DialError) Error() string {
+	parts := make([]string, 0, len(e.Attempts))
+	for _, a := range e.Attempts {
+		parts = append(parts, fmt.Sprintf("%s: %v", a.Addr, a.Err))
+	}
+	return fmt.Sprintf("dial %s: %s", e.Addr, strings.Join(parts, "; "))
+}
+
+func (e *DialError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Attempts))
+	for _, a := range e.Attempts {
+		errs = append(errs, a.Err)
+	}
+	return errs
+}
+
+// DialTCP connects to addr, host:port, trying every address the host
+// resolves to as opts describes. The address connected to is the remote
+// address of the connection.
+func DialTCP(ctx context.Context, addr string, opts DialOptions) (net.Conn, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IPAddr
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IPAddr{{IP: ip}}
+	} else {
+		resolver := opts.Resolver
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+		if ips, err = resolver.LookupIPAddr(ctx, host); err != nil {
+			return nil, err
+		}
+		if len(ips) == 0 {
+			return nil, &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+		}
+	}
+	if opts.FallbackDelay > 0 {
+		ips = interleaveFamilies(ips)
+	}
+	return dialAddrs(ctx, addr, port, ips, opts)
+}
+
+type dialResult struct {
+	index int
+	conn  net.Conn
+	err   error
+}
+
+// dialAddrs tries ips in order, starting the next attempt when one fails
+// or, with a fallback delay, when the delay expires. The first connection
+// established wins and the others are closed.
+func dialAddrs(ctx context.Context, addr, port string, ips []net.IPAddr, opts DialOptions) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	targets := make([]string, len(ips))
+	for i, ip := range ips {
+		targets[i] = net.JoinHostPort(ip.String(), port)
+	}
+	results := make(chan dialResult, len(ips))
+	errs := make([]error, len(ips))
+	next, running := 0, 0
+	var fallback <-chan time.Time
+	start := func() {
+		i := next
+		next++
+		running++
+		go func() {
+			attemptCtx := ctx
+			if opts.AttemptTimeout > 0 {
+				var cancel context.CancelFunc
+				attemptCtx, cancel = context.WithTimeout(ctx, opts.AttemptTimeout)
+				defer cancel()
+			}
+			dial := opts.dialContext
+			if dial == nil {
+				var dialer net.Dialer
+				dial = dialer.DialContext
+			}
+			conn, err := dial(attemptCtx, "tcp", targets[i])
+			results <- dialResult{index: i, conn: conn, err: err}
+		}()
+		fallback = nil
+		if opts.FallbackDelay > 0 && next < len(ips) {
+			fallback = time.After(opts.FallbackDelay)
+		}
+	}
+	start()
+	for running > 0 {
+		select {
+		case r := <-results:
+			running--
+			if r.err == nil {
+				// Close the connections of attempts that still succeed.
+				go func(n int) {
+					for ; n > 0; n-- {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(running)
+				return r.conn, nil
+			}
+			errs[r.index] = r.err
+			// Once the budget is spent, the remaining addresses are not
+			// attempted.
+			if next < len(ips) && ctx.Err() == nil {
+				start()
+			}
+		case <-fallback:
+			start()
+		}
+	}
+	dialErr := &DialError{Addr: addr}
+	for i := 0; i < next; i++ {
+		dialErr.Attempts = append(dialErr.Attempts, DialAttempt{Addr: targets[i], Err: errs[i]})
+	}
+	return nil, dialErr
+}
+
+// interleaveFamilies orders ips alternating between address families,
+// starting with the family of the first address, as RFC 8305 section 4
+// recommends.
+func interleaveFamilies(ips []net.IPAddr) []net.IPAddr {
+	var first, second []net.IPAddr
+	firstIsV4 := ips[0].IP.To4() != nil
+	for _, ip := range ips {
+		if (ip.IP.To4() != nil) == firstIsV4 {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+	ordered := make([]net.IPAddr, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			ordered = append(ordered, first[i])
+		}
+		if i < len(second) {
+			ordered = append(ordered, second[i])
+		}
+	}
+	return ordered
+}
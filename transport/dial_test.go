@@ -0,0 +1,217 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// stubResolver resolves every host name to its addresses.
+type stubResolver struct {
+	ips []string
+	err error
+}
+
+func (r stubResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs := make([]net.IPAddr, 0, len(r.ips))
+	for _, ip := range r.ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, r.err
+}
+
+// stubDialer connects to the addresses in live with a pipe and leaves
+// attempts on any other address hanging until they are cancelled. It
+// records the addresses dialed.
+type stubDialer struct {
+	live map[string]bool
+	mu   sync.Mutex
+	// dialed lists the addresses attempted, in order.
+	dialed []string
+}
+
+func (d *stubDialer) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	d.mu.Lock()
+	d.dialed = append(d.dialed, address)
+	d.mu.Unlock()
+	if d.live[address] {
+		conn, peer := net.Pipe()
+		peer.Close()
+		return conn, nil
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (d *stubDialer) attempts() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return slices.Clone(d.dialed)
+}
+
+// TestDialTCPFallback resolves a host to a dead and a live address and
+// checks that the live one is reached within the budget, whether the
+// dead attempt times out or the next attempt is started alongside it.
+func TestDialTCPFallback(t *testing.T) {
+	const budget = time.Second
+	for _, tc := range []struct {
+		name string
+		opts DialOptions
+		// earliest is the least time the fallback takes.
+		earliest time.Duration
+	}{
+		{"attempt timeout", DialOptions{AttemptTimeout: 50 * time.Millisecond}, 50 * time.Millisecond},
+		{"fallback delay", DialOptions{FallbackDelay: 20 * time.Millisecond}, 20 * time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dialer := &stubDialer{live: map[string]bool{"192.0.2.2:3868": true}}
+			opts := tc.opts
+			opts.Timeout = budget
+			opts.Resolver = stubResolver{ips: []string{"192.0.2.1", "192.0.2.2"}}
+			opts.dialContext = dialer.dial
+			start := time.Now()
+			conn, err := DialTCP(context.Background(), "dra.example.com:3868", opts)
+			elapsed := time.Since(start)
+			if err != nil {
+				t.Fatalf("DialTCP: %v", err)
+			}
+			conn.Close()
+			if elapsed < tc.earliest || elapsed >= budget {
+				t.Errorf("connected after %v, want between %v and %v", elapsed, tc.earliest, budget)
+			}
+			if got, want := dialer.attempts(), []string{"192.0.2.1:3868", "192.0.2.2:3868"}; !slices.Equal(got, want) {
+				t.Errorf("dialed %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// TestDialTCPAllFail checks that the error lists every address attempted
+// with its failure, in order.
+func TestDialTCPAllFail(t *testing.T) {
+	dialer := &stubDialer{}
+	_, err := DialTCP(context.Background(), "dra.example.com:3868", DialOptions{
+		Timeout:        time.Second,
+		AttemptTimeout: 20 * time.Millisecond,
+		Resolver:       stubResolver{ips: []string{"2001:db8::1", "192.0.2.1"}},
+		dialContext:    dialer.dial,
+	})
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) {
+		t.Fatalf("DialTCP = %v, want a DialError", err)
+	}
+	want := []string{"[2001:db8::1]:3868", "192.0.2.1:3868"}
+	var got []string
+	for _, a := range dialErr.Attempts {
+		got = append(got, a.Addr)
+		if !errors.Is(a.Err, context.DeadlineExceeded) {
+			t.Errorf("%s failed with %v, want the attempt timed out", a.Addr, a.Err)
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("attempts on %q, want %q", got, want)
+	}
+	if dialErr.Addr != "dra.example.com:3868" || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("DialError %v does not name the host or unwrap to the failures", err)
+	}
+
+	// Without attempt timeouts the budget runs out on the first address.
+	dialer = &stubDialer{}
+	_, err = DialTCP(context.Background(), "dra.example.com:3868", DialOptions{
+		Timeout:     50 * time.Millisecond,
+		Resolver:    stubResolver{ips: []string{"192.0.2.1", "192.0.2.2"}},
+		dialContext: dialer.dial,
+	})
+	if !errors.As(err, &dialErr) || len(dialErr.Attempts) != 1 {
+		t.Errorf("DialTCP = %v, want the first address alone attempted", err)
+	}
+}
+
+// TestDialTCPRefused falls back from an address refusing the connection to
+// a listener, over the loopback interface.
+func TestDialTCPRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	if conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.2", port)); err == nil {
+		conn.Close()
+		t.Skip("127.0.0.2 reaches the listener")
+	}
+
+	conn, err := DialTCP(context.Background(), net.JoinHostPort("dra.example.com", port), DialOptions{
+		Timeout:  time.Second,
+		Resolver: stubResolver{ips: []string{"127.0.0.2", "127.0.0.1"}},
+	})
+	if err != nil {
+		t.Fatalf("DialTCP: %v", err)
+	}
+	defer conn.Close()
+	if got := conn.RemoteAddr().String(); got != ln.Addr().String() {
+		t.Errorf("connected to %s, want %s", got, ln.Addr())
+	}
+
+	ln.Close()
+	_, err = DialTCP(context.Background(), net.JoinHostPort("dra.example.com", port), DialOptions{
+		Timeout:  time.Second,
+		Resolver: stubResolver{ips: []string{"127.0.0.2", "127.0.0.1"}},
+	})
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) || len(dialErr.Attempts) != 2 || !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("DialTCP = %v, want both addresses refused", err)
+	}
+}
+
+func TestDialTCPResolution(t *testing.T) {
+	dialer := &stubDialer{live: map[string]bool{"192.0.2.1:3868": true}}
+	// A literal address is not resolved.
+	conn, err := DialTCP(context.Background(), "192.0.2.1:3868", DialOptions{
+		Resolver:    stubResolver{err: errors.New("resolver called")},
+		dialContext: dialer.dial,
+	})
+	if err != nil {
+		t.Fatalf("DialTCP of an IP address: %v", err)
+	}
+	conn.Close()
+
+	resolverErr := errors.New("SERVFAIL")
+	if _, err := DialTCP(context.Background(), "dra.example.com:3868", DialOptions{Resolver: stubResolver{err: resolverErr}}); !errors.Is(err, resolverErr) {
+		t.Errorf("DialTCP with the resolver failing = %v", err)
+	}
+	var dnsErr *net.DNSError
+	if _, err := DialTCP(context.Background(), "dra.example.com:3868", DialOptions{Resolver: stubResolver{}}); !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("DialTCP of a host without addresses = %v, want not found", err)
+	}
+	if _, err := DialTCP(context.Background(), "dra.example.com", DialOptions{}); err == nil {
+		t.Error("DialTCP of an address without a port succeeded")
+	}
+}
+
+func TestInterleaveFamilies(t *testing.T) {
+	ips := func(addrs ...string) []net.IPAddr {
+		var r []net.IPAddr
+		for _, a := range addrs {
+			r = append(r, net.IPAddr{IP: net.ParseIP(a)})
+		}
+		return r
+	}
+	for _, tc := range []struct {
+		in, want []net.IPAddr
+	}{
+		{ips("2001:db8::1", "2001:db8::2", "192.0.2.1"), ips("2001:db8::1", "192.0.2.1", "2001:db8::2")},
+		{ips("192.0.2.1", "192.0.2.2", "2001:db8::1", "2001:db8::2"), ips("192.0.2.1", "2001:db8::1", "192.0.2.2", "2001:db8::2")},
+		{ips("192.0.2.1", "192.0.2.2"), ips("192.0.2.1", "192.0.2.2")},
+	} {
+		got := interleaveFamilies(tc.in)
+		if !slices.EqualFunc(got, tc.want, func(a, b net.IPAddr) bool { return a.IP.Equal(b.IP) }) {
+			t.Errorf("interleaveFamilies(%v) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
@@ -6,4 +6,22 @@ import "errors"
 var (
 	ErrAcceptTimeout    = errors.New("accept timeout reached")
 	UnsupportedProtocol = errors.New("unsupported protocol")
+	// ErrUnsupportedOption is returned when a socket option is not
+	// available for the transport protocol.
+	ErrUnsupportedOption = errors.New("socket option not supported by transport")
+	// ErrWriterClosed is returned for writes queued on a closed
+	// BatchWriter.
+	ErrWriterClosed = errors.New("writer closed")
+	// ErrPeerDown is returned for writes on a BatchWriter that gave up on
+	// its peer after repeated write timeouts or a broken connection.
+	ErrPeerDown = errors.New("peer down")
+	// ErrDrainTimeout is returned by BatchWriter.Drain when the messages
+	// queued before it were not all written in time.
+	ErrDrainTimeout = errors.New("writer not drained in time")
+	// ErrInvalidAddress is returned by CheckAddr for an address the
+	// protocol cannot listen on or dial.
+	ErrInvalidAddress = errors.New("invalid address")
+	// ErrCertificateIdentity is returned by VerifyPeerIdentity when the
+	// TLS certificate of the peer does not name its Diameter identity.
+	ErrCertificateIdentity = errors.New("peer certificate does not match its identity")
 )
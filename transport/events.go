@@ -0,0 +1,183 @@
+// SCTP association and peer address change events
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/ishidawataru/sctp"
+)
+
+// ConnEventType is the kind of change reported by a ConnEvent.
+type ConnEventType int
+
+const (
+	// Association changes, from struct sctp_assoc_change.
+	ConnEventAssociationUp ConnEventType = iota + 1
+	ConnEventAssociationLost
+	ConnEventAssociationRestart
+	ConnEventShutdownComplete
+	ConnEventCannotStart
+	// Peer address changes, from struct sctp_paddr_change. On failover
+	// the lost path is reported unreachable and the new one made primary.
+	ConnEventAddressAvailable
+	ConnEventAddressUnreachable
+	ConnEventAddressRemoved
+	ConnEventAddressAdded
+	ConnEventAddressMadePrimary
+	ConnEventAddressConfirmed
+	ConnEventAddressPotentiallyFailed
+)
+
+var connEventNames = map[ConnEventType]string{
+	ConnEventAssociationUp:            "association up",
+	ConnEventAssociationLost:          "association lost",
+	ConnEventAssociationRestart:       "association restarted",
+	ConnEventShutdownComplete:         "shutdown complete",
+	ConnEventCannotStart:              "association cannot start",
+	ConnEventAddressAvailable:         "address available",
+	ConnEventAddressUnreachable:       "address unreachable",
+	ConnEventAddressRemoved:           "address removed",
+	ConnEventAddressAdded:             "address added",
+	ConnEventAddressMadePrimary:       "address made primary",
+	ConnEventAddressConfirmed:         "address confirmed",
+	ConnEventAddressPotentiallyFailed: "address potentially failed",
+}
+
+func (t ConnEventType) String() string {
+	if name, ok := connEventNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("ConnEventType(%d)", int(t))
+}
+
+// ConnEvent is a change of an SCTP association or of one of the peer
+// addresses it is multihomed over.
+type ConnEvent struct {
+	Type ConnEventType
+	// Addr is the peer address an address change is about.
+	Addr net.IP
+	// Error is the error code the stack reported with the change, if any.
+	Error uint32
+}
+
+func (e ConnEvent) String() string {
+	s := e.Type.String()
+	if e.Addr != nil {
+		s += " " + e.Addr.String()
+	}
+	if e.Error != 0 {
+		s += fmt.Sprintf(" (error %d)", e.Error)
+	}
+	return s
+}
+
+// SetEventHandler makes the connection deliver its ConnEvents to fn. Only
+// connections opened by NewDiameterSCTPEventConnection or accepted by a
+// listener from NewDiameterSCTPEventListener produce events. They are
+// delivered from Read, so fn must be set before reading starts and must
+// not block.
+func (dc *DiameterConnection) SetEventHandler(fn func(ConnEvent)) {
+	dc.events = fn
+}
+
+// notify is the notification handler of SCTP sockets subscribed to
+// events. Notifications other than association and peer address changes
+// are ignored; returning an error would fail the Read.
+func (dc *DiameterConnection) notify(b []byte) error {
+	ev, ok := parseSCTPNotification(b)
+	if !ok {
+		return nil
+	}
+	dc.counters.connEvent()
+	if fn := dc.events; fn != nil {
+		fn(ev)
+	}
+	return nil
+}
+
+// Offsets into the notifications, which the sctp library only delivers
+// on Linux and therefore follow the Linux layout, in host byte order.
+const (
+	assocChangeSize     = 20
+	assocChangeState    = 8
+	assocChangeError    = 10
+	paddrChangeAddr     = 8
+	paddrChangeState    = 136
+	paddrChangeError    = 140
+	paddrChangeSize     = 148
+	sockaddrStorageSize = 128
+	linuxAFInet         = 2
+	linuxAFInet6        = 10
+	sockaddrInetAddr    = 4
+	sockaddrInet6Addr   = 8
+	notificationHdrSize = 8
+)
+
+var assocChangeEvents = []ConnEventType{
+	ConnEventAssociationUp,
+	ConnEventAssociationLost,
+	ConnEventAssociationRestart,
+	ConnEventShutdownComplete,
+	ConnEventCannotStart,
+}
+
+var paddrChangeEvents = []ConnEventType{
+	ConnEventAddressAvailable,
+	ConnEventAddressUnreachable,
+	ConnEventAddressRemoved,
+	ConnEventAddressAdded,
+	ConnEventAddressMadePrimary,
+	ConnEventAddressConfirmed,
+	ConnEventAddressPotentiallyFailed,
+}
+
+// parseSCTPNotification maps an association or peer address change
+// notification to a ConnEvent.
+func parseSCTPNotification(b []byte) (ConnEvent, bool) {
+	if len(b) < notificationHdrSize {
+		return ConnEvent{}, false
+	}
+	order := binary.NativeEndian
+	switch sctp.SCTPNotificationType(order.Uint16(b)) {
+	case sctp.SCTP_ASSOC_CHANGE:
+		if len(b) < assocChangeSize {
+			return ConnEvent{}, false
+		}
+		state := int(order.Uint16(b[assocChangeState:]))
+		if state >= len(assocChangeEvents) {
+			return ConnEvent{}, false
+		}
+		return ConnEvent{
+			Type:  assocChangeEvents[state],
+			Error: uint32(order.Uint16(b[assocChangeError:])),
+		}, true
+	case sctp.SCTP_PEER_ADDR_CHANGE:
+		if len(b) < paddrChangeSize {
+			return ConnEvent{}, false
+		}
+		state := int(order.Uint32(b[paddrChangeState:]))
+		if state >= len(paddrChangeEvents) {
+			return ConnEvent{}, false
+		}
+		return ConnEvent{
+			Type:  paddrChangeEvents[state],
+			Addr:  sockaddrIP(b[paddrChangeAddr : paddrChangeAddr+sockaddrStorageSize]),
+			Error: order.Uint32(b[paddrChangeError:]),
+		}, true
+	}
+	return ConnEvent{}, false
+}
+
+// sockaddrIP returns the address of a Linux struct sockaddr_storage, or
+// nil for families other than IPv4 and IPv6.
+func sockaddrIP(sa []byte) net.IP {
+	switch binary.NativeEndian.Uint16(sa) {
+	case linuxAFInet:
+		return net.IP(append([]byte(nil), sa[sockaddrInetAddr:sockaddrInetAddr+net.IPv4len]...))
+	case linuxAFInet6:
+		return net.IP(append([]byte(nil), sa[sockaddrInet6Addr:sockaddrInet6Addr+net.IPv6len]...))
+	}
+	return nil
+}
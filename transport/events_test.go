@@ -0,0 +1,124 @@
+package transport
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/ishidawataru/sctp"
+)
+
+// assocChange returns a struct sctp_assoc_change notification in the
+// Linux layout.
+func assocChange(state, errorCode uint16) []byte {
+	b := make([]byte, assocChangeSize)
+	binary.NativeEndian.PutUint16(b, uint16(sctp.SCTP_ASSOC_CHANGE))
+	binary.NativeEndian.PutUint32(b[4:], assocChangeSize)
+	binary.NativeEndian.PutUint16(b[assocChangeState:], state)
+	binary.NativeEndian.PutUint16(b[assocChangeError:], errorCode)
+	return b
+}
+
+// paddrChange returns a struct sctp_paddr_change notification in the
+// Linux layout for ip.
+func paddrChange(ip net.IP, state, errorCode uint32) []byte {
+	b := make([]byte, paddrChangeSize)
+	binary.NativeEndian.PutUint16(b, uint16(sctp.SCTP_PEER_ADDR_CHANGE))
+	binary.NativeEndian.PutUint32(b[4:], paddrChangeSize)
+	sa := b[paddrChangeAddr:]
+	if ip4 := ip.To4(); ip4 != nil {
+		binary.NativeEndian.PutUint16(sa, linuxAFInet)
+		copy(sa[sockaddrInetAddr:], ip4)
+	} else {
+		binary.NativeEndian.PutUint16(sa, linuxAFInet6)
+		copy(sa[sockaddrInet6Addr:], ip.To16())
+	}
+	binary.NativeEndian.PutUint32(b[paddrChangeState:], state)
+	binary.NativeEndian.PutUint32(b[paddrChangeError:], errorCode)
+	return b
+}
+
+func TestParseSCTPNotification(t *testing.T) {
+	v4, v6 := net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")
+	for _, tc := range []struct {
+		name string
+		b    []byte
+		want ConnEvent
+		ok   bool
+	}{
+		{"association up", assocChange(0, 0), ConnEvent{Type: ConnEventAssociationUp}, true},
+		{"association lost", assocChange(1, 3), ConnEvent{Type: ConnEventAssociationLost, Error: 3}, true},
+		{"association restarted", assocChange(2, 0), ConnEvent{Type: ConnEventAssociationRestart}, true},
+		{"shutdown complete", assocChange(3, 0), ConnEvent{Type: ConnEventShutdownComplete}, true},
+		{"cannot start", assocChange(4, 0), ConnEvent{Type: ConnEventCannotStart}, true},
+		{"unknown association state", assocChange(5, 0), ConnEvent{}, false},
+		{"short association change", assocChange(0, 0)[:assocChangeSize-1], ConnEvent{}, false},
+		{"IPv4 unreachable", paddrChange(v4, 1, 110), ConnEvent{Type: ConnEventAddressUnreachable, Addr: v4.To4(), Error: 110}, true},
+		{"IPv6 made primary", paddrChange(v6, 4, 0), ConnEvent{Type: ConnEventAddressMadePrimary, Addr: v6}, true},
+		{"IPv4 available", paddrChange(v4, 0, 0), ConnEvent{Type: ConnEventAddressAvailable, Addr: v4.To4()}, true},
+		{"potentially failed", paddrChange(v4, 6, 0), ConnEvent{Type: ConnEventAddressPotentiallyFailed, Addr: v4.To4()}, true},
+		{"unknown address state", paddrChange(v4, 7, 0), ConnEvent{}, false},
+		{"short address change", paddrChange(v4, 0, 0)[:paddrChangeSize-1], ConnEvent{}, false},
+		{"other notification", func() []byte {
+			b := assocChange(0, 0)
+			binary.NativeEndian.PutUint16(b, uint16(sctp.SCTP_SEND_FAILED))
+			return b
+		}(), ConnEvent{}, false},
+		{"short header", []byte{1, 0}, ConnEvent{}, false},
+	} {
+		got, ok := parseSCTPNotification(tc.b)
+		if ok != tc.ok || got.Type != tc.want.Type || !got.Addr.Equal(tc.want.Addr) || got.Error != tc.want.Error {
+			t.Errorf("%s: parsed as %v, %v; want %v, %v", tc.name, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+// TestNotify feeds notifications to a connection as the sctp library does
+// and checks that the events reach the handler and the counters.
+func TestNotify(t *testing.T) {
+	var counters Counters
+	dc := &DiameterConnection{protocol: Proto_SCTP}
+	dc.SetCounters(&counters)
+	if err := dc.notify(assocChange(0, 0)); err != nil {
+		t.Fatalf("notify without a handler: %v", err)
+	}
+
+	var got []ConnEvent
+	dc.SetEventHandler(func(ev ConnEvent) { got = append(got, ev) })
+	for _, b := range [][]byte{
+		paddrChange(net.ParseIP("192.0.2.1"), 1, 0),
+		assocChange(9, 0),
+		paddrChange(net.ParseIP("192.0.2.2"), 4, 0),
+	} {
+		if err := dc.notify(b); err != nil {
+			t.Fatalf("notify: %v", err)
+		}
+	}
+	want := []string{"address unreachable 192.0.2.1", "address made primary 192.0.2.2"}
+	if len(got) != len(want) {
+		t.Fatalf("events %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Errorf("event %d is %q, want %q", i, got[i], want[i])
+		}
+	}
+	if n := counters.Snapshot().ConnEvents; n != 3 {
+		t.Errorf("%d events counted, want the 3 parsed", n)
+	}
+}
+
+func TestConnEventString(t *testing.T) {
+	for _, tc := range []struct {
+		ev   ConnEvent
+		want string
+	}{
+		{ConnEvent{Type: ConnEventAssociationLost, Error: 3}, "association lost (error 3)"},
+		{ConnEvent{Type: ConnEventAddressConfirmed, Addr: net.ParseIP("2001:db8::1")}, "address confirmed 2001:db8::1"},
+		{ConnEvent{Type: ConnEventType(42)}, "ConnEventType(42)"},
+	} {
+		if got := tc.ev.String(); got != tc.want {
+			t.Errorf("%#v is %q, want %q", tc.ev, got, tc.want)
+		}
+	}
+}
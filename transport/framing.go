@@ -0,0 +1,137 @@
+// Message framing over stream transports
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+)
+
+const (
+	frameHeaderSize = 20
+	frameVersion    = 1
+)
+
+var (
+	ErrInvalidFrameVersion = errors.New("invalid diameter version in frame header")
+	ErrInvalidFrameLength  = errors.New("invalid diameter message length in frame header")
+	// ErrStreamDesync wraps the errors of frame headers that do not
+	// parse, after which the boundaries of the following messages are
+	// unknown and the connection has to be closed.
+	ErrStreamDesync = errors.New("diameter stream out of sync")
+)
+
+// SetResyncWindow makes the connection look for the next message within
+// the window bytes following a frame header that does not parse, instead
+// of failing the read with ErrStreamDesync right away. The bytes skipped
+// are lost, along with any message they held. It defaults to 0, no
+// resynchronization.
+func (dc *DiameterConnection) SetResyncWindow(window int) {
+	dc.resyncWindow = window
+}
+
+// frameLength returns the Message-Length of a frame header.
+func frameLength(header []byte) (int, error) {
+	if header[0] != frameVersion {
+		return 0, fmt.Errorf("%w: %w %d", ErrStreamDesync, ErrInvalidFrameVersion, header[0])
+	}
+	length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+	// Messages are made of 32-bit aligned AVPs, see RFC 6733 section 3.
+	if length < frameHeaderSize || length%4 != 0 {
+		return 0, fmt.Errorf("%w: %w %d", ErrStreamDesync, ErrInvalidFrameLength, length)
+	}
+	return length, nil
+}
+
+// readHeader reads a frame header into header and returns its length,
+// resynchronizing within the resync window if it does not parse.
+func (dc *DiameterConnection) readHeader(header []byte) (int, error) {
+	if _, err := io.ReadFull(dc, header); err != nil {
+		return 0, err
+	}
+	length, err := frameLength(header)
+	if err == nil || dc.resyncWindow <= 0 {
+		return length, err
+	}
+	for skipped := 1; skipped <= dc.resyncWindow; skipped++ {
+		copy(header, header[1:])
+		if _, err := io.ReadFull(dc, header[frameHeaderSize-1:]); err != nil {
+			return 0, err
+		}
+		// Reserved command flags must be clear, which rules out most
+		// false matches in the middle of a message.
+		if header[4]&0x0f != 0 {
+			continue
+		}
+		if length, resyncErr := frameLength(header); resyncErr == nil {
+			log.Printf("Resynchronized the stream from %s after skipping %d bytes.", dc.RemoteAddr(), skipped)
+			dc.counters.resynced(err)
+			return length, nil
+		}
+	}
+	return 0, fmt.Errorf("%w, and no message header within the next %d bytes", err, dc.resyncWindow)
+}
+
+// ReadFrame reads one complete Diameter message from the connection. The
+// Message-Length field of the header decides how many bytes are consumed.
+func (dc *DiameterConnection) ReadFrame() ([]byte, error) {
+	frame, err := dc.readFrame()
+	dc.countRead(frame, err)
+	return frame, err
+}
+
+func (dc *DiameterConnection) readFrame() ([]byte, error) {
+	header := make([]byte, frameHeaderSize)
+	length, err := dc.readHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, length)
+	copy(frame, header)
+	if _, err := io.ReadFull(dc, frame[frameHeaderSize:]); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// ReadPooledFrame is like ReadFrame but takes the frame buffer from pool.
+// The caller owns the returned buffer until it hands it back with
+// pool.Put, which must only happen once nothing references the frame.
+func (dc *DiameterConnection) ReadPooledFrame(pool *BufferPool) ([]byte, error) {
+	frame, err := dc.readPooledFrame(pool)
+	dc.countRead(frame, err)
+	return frame, err
+}
+
+func (dc *DiameterConnection) readPooledFrame(pool *BufferPool) ([]byte, error) {
+	header := pool.Get(frameHeaderSize)
+	length, err := dc.readHeader(header)
+	if err != nil {
+		pool.Put(header)
+		return nil, err
+	}
+	frame := header
+	if length <= cap(header) {
+		frame = header[:length]
+	} else {
+		frame = pool.Get(length)
+		copy(frame, header)
+		pool.Put(header)
+	}
+	if _, err := io.ReadFull(dc, frame[frameHeaderSize:]); err != nil {
+		pool.Put(frame)
+		return nil, err
+	}
+	return frame, nil
+}
+
+// countRead records the outcome of reading a frame in the connection
+// counters.
+func (dc *DiameterConnection) countRead(frame []byte, err error) {
+	if err != nil {
+		dc.counters.readFailed(err)
+		return
+	}
+	dc.counters.received(frame)
+}
@@ -0,0 +1,92 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestReadFrameDesync writes a message, then a stream that does not start
+// with a valid frame header, then another message, and checks that the
+// desynchronization is detected and, with a resync window, recovered.
+func TestReadFrameDesync(t *testing.T) {
+	garbage := bytes.Repeat([]byte{0xff}, 6)
+	header := func(version byte, length uint32) []byte {
+		h := make([]byte, frameHeaderSize)
+		binary.BigEndian.PutUint32(h, length)
+		h[0] = version
+		return h
+	}
+	for _, tc := range []struct {
+		name   string
+		window int
+		// stream follows the first message and precedes the second.
+		stream []byte
+		// longer is added to the Message-Length of the first message.
+		longer uint32
+		// err is nil when the second message is read.
+		err    error
+		errMsg string
+	}{
+		{name: "garbage", stream: garbage, err: ErrInvalidFrameVersion},
+		{name: "short length", stream: header(1, 8), err: ErrInvalidFrameLength},
+		{name: "unaligned length", stream: header(1, 22), err: ErrInvalidFrameLength},
+		// The first message swallows the version and length of the
+		// second, whose command flags are then read as a version.
+		{name: "wrong length", longer: 4, err: ErrInvalidFrameVersion},
+		{name: "resynchronized", window: 16, stream: garbage},
+		{name: "beyond the window", window: 4, stream: garbage, err: ErrInvalidFrameVersion, errMsg: "no message header within the next 4 bytes"},
+	} {
+		for _, pooled := range []bool{false, true} {
+			name := tc.name
+			if pooled {
+				name += " pooled"
+			}
+			t.Run(name, func(t *testing.T) {
+				conn, peer := tcpPair(t)
+				var counters Counters
+				reader := &DiameterConnection{conn: peer, protocol: Proto_TCP}
+				reader.SetCounters(&counters)
+				reader.SetResyncWindow(tc.window)
+				read := reader.ReadFrame
+				if pooled {
+					pool := NewBufferPool(4096)
+					read = func() ([]byte, error) { return reader.ReadPooledFrame(pool) }
+				}
+
+				first, second := testFrame(t, 1, 1, 20), testFrame(t, 1, 2, 20)
+				binary.BigEndian.PutUint32(first, uint32(len(first))+tc.longer)
+				first[0] = frameVersion
+				stream := append(append(append([]byte(nil), first...), tc.stream...), second...)
+				if _, err := conn.conn.Write(stream); err != nil {
+					t.Fatal(err)
+				}
+
+				if frame, err := read(); err != nil || len(frame) != len(first)+int(tc.longer) {
+					t.Fatalf("first read: %d bytes, %v", len(frame), err)
+				}
+				frame, err := read()
+				if tc.err == nil {
+					if err != nil || !bytes.Equal(frame, second) {
+						t.Fatalf("second read: %v, want the second message", err)
+					}
+					if got := counters.Snapshot(); got.Resyncs != 1 || got.ReadErrors != 1 || got.MessagesIn != 2 {
+						t.Errorf("%d resyncs, %d read errors, %d messages; want 1, 1 and 2", got.Resyncs, got.ReadErrors, got.MessagesIn)
+					}
+					return
+				}
+				if !errors.Is(err, ErrStreamDesync) || !errors.Is(err, tc.err) {
+					t.Fatalf("second read: %v, want ErrStreamDesync and %v", err, tc.err)
+				}
+				if !strings.Contains(err.Error(), tc.errMsg) {
+					t.Errorf("error %q does not mention %q", err, tc.errMsg)
+				}
+				if got := counters.Snapshot(); got.Resyncs != 0 {
+					t.Errorf("%d resyncs, want none", got.Resyncs)
+				}
+			})
+		}
+	}
+}
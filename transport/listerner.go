@@ -2,8 +2,11 @@
 package transport
 
 import (
+	"errors"
 	"log"
 	"net"
+	"os"
+	"syscall"
 	"time"
 
 	"github.com/ishidawataru/sctp"
@@ -15,6 +18,11 @@ type DiameterListener struct {
 	addr          string
 	acceptTimeout time.Duration
 	protocol      ProtocolType
+	// tcp is the socket under a TLS listener, which has no deadline.
+	tcp *net.TCPListener
+	// accept replaces the SCTP accept of the library for listeners that
+	// subscribe their connections to events.
+	accept func() (*DiameterConnection, error)
 }
 
 // NewDiameterListener creates a new listener on the specified address.
@@ -26,7 +34,10 @@ func NewDiameterListener(addr string, protocol ProtocolType, acceptTimeout time.
 	case Proto_TCP:
 		listener, err = net.Listen("tcp", addr)
 	case Proto_SCTP:
-		listener, err = sctp.ListenSCTP("sctp", &sctp.SCTPAddr{IPAddrs: []net.IPAddr{{IP: net.ParseIP(addr)}}})
+		var laddr *sctp.SCTPAddr
+		if laddr, err = resolveSCTPAddr(addr); err == nil {
+			listener, err = sctp.ListenSCTP("sctp", laddr)
+		}
 	}
 
 	if err != nil {
@@ -40,12 +51,23 @@ func NewDiameterListener(addr string, protocol ProtocolType, acceptTimeout time.
 	}, nil
 }
 
+// resolveSCTPAddr parses addr, one or more hosts separated by slashes for
+// multihoming followed by the port, as in "10.0.0.1/10.0.1.1:3868". An
+// empty host binds all local addresses.
+func resolveSCTPAddr(addr string) (*sctp.SCTPAddr, error) {
+	return sctp.ResolveSCTPAddr("sctp", addr)
+}
+
 // Accept waits for and returns the next incoming connection, applying a timeout if specified.
 func (dl *DiameterListener) Accept() (*DiameterConnection, error) {
 	// If TCP, apply the standard SetDeadline for accept timeout.
 	if dl.protocol == Proto_TCP {
 		if dl.acceptTimeout > 0 {
-			dl.listener.(*net.TCPListener).SetDeadline(time.Now().Add(dl.acceptTimeout))
+			tcp := dl.tcp
+			if tcp == nil {
+				tcp = dl.listener.(*net.TCPListener)
+			}
+			tcp.SetDeadline(time.Now().Add(dl.acceptTimeout))
 		}
 		conn, err := dl.listener.Accept()
 		if err != nil {
@@ -56,12 +78,12 @@ func (dl *DiameterListener) Accept() (*DiameterConnection, error) {
 
 	// For SCTP, implement a custom timeout mechanism.
 	if dl.protocol == Proto_SCTP {
-		connChan := make(chan net.Conn)
+		connChan := make(chan *DiameterConnection)
 		errChan := make(chan error)
 
 		// Start a goroutine to accept the connection.
 		go func() {
-			conn, err := dl.listener.Accept()
+			conn, err := dl.acceptSCTP()
 			if err != nil {
 				errChan <- err
 				return
@@ -69,13 +91,18 @@ func (dl *DiameterListener) Accept() (*DiameterConnection, error) {
 			connChan <- conn
 		}()
 
-		// Wait for either a connection or a timeout.
+		// Wait for either a connection or a timeout. A timeout of 0
+		// waits forever, as it does for TCP.
+		var timeout <-chan time.Time
+		if dl.acceptTimeout > 0 {
+			timeout = time.After(dl.acceptTimeout)
+		}
 		select {
 		case conn := <-connChan:
-			return &DiameterConnection{conn: conn, protocol: dl.protocol}, nil
+			return conn, nil
 		case err := <-errChan:
 			return nil, err
-		case <-time.After(dl.acceptTimeout):
+		case <-timeout:
 			return nil, ErrAcceptTimeout
 		}
 	}
@@ -83,6 +110,43 @@ func (dl *DiameterListener) Accept() (*DiameterConnection, error) {
 	return nil, UnsupportedProtocol
 }
 
+func (dl *DiameterListener) acceptSCTP() (*DiameterConnection, error) {
+	if dl.accept != nil {
+		return dl.accept()
+	}
+	conn, err := dl.listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &DiameterConnection{conn: conn, protocol: dl.protocol}, nil
+}
+
+// IsAcceptTimeout reports whether err is the expiry of the accept timeout
+// rather than a failure of the listener.
+func IsAcceptTimeout(err error) bool {
+	return errors.Is(err, ErrAcceptTimeout) || errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// IsTemporary reports whether err, returned by Accept, leaves the listener
+// usable once resources are freed or the aborted connection is gone: the
+// process or system running out of descriptors or memory, or a peer
+// aborting its connection before it was accepted.
+func IsTemporary(err error) bool {
+	for _, errno := range []syscall.Errno{
+		syscall.EMFILE,
+		syscall.ENFILE,
+		syscall.ENOBUFS,
+		syscall.ENOMEM,
+		syscall.ECONNABORTED,
+		syscall.EPROTO,
+	} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
 // Close closes the listener, stopping it from accepting any more connections.
 func (dl *DiameterListener) Close() error {
 	log.Printf("Shutting down listener on %s\n", dl.addr)
@@ -93,3 +157,21 @@ func (dl *DiameterListener) Close() error {
 func (dl *DiameterListener) Addr() net.Addr {
 	return dl.listener.Addr()
 }
+
+// Addrs returns the addresses the listener is bound to, with the port the
+// system chose when listening on port 0: one for TCP, and one for each
+// local address an SCTP endpoint is multihomed over.
+func (dl *DiameterListener) Addrs() []net.Addr {
+	switch addr := dl.listener.Addr().(type) {
+	case nil:
+		return nil
+	case *sctp.SCTPAddr:
+		addrs := make([]net.Addr, 0, len(addr.IPAddrs))
+		for _, ip := range addr.IPAddrs {
+			addrs = append(addrs, &sctp.SCTPAddr{IPAddrs: []net.IPAddr{ip}, Port: addr.Port})
+		}
+		return addrs
+	default:
+		return []net.Addr{addr}
+	}
+}
@@ -0,0 +1,64 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestAcceptErrorClassification(t *testing.T) {
+	syscallErr := func(errno syscall.Errno) error {
+		return &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", errno)}
+	}
+	for _, tc := range []struct {
+		name               string
+		err                error
+		timeout, temporary bool
+	}{
+		{"SCTP accept timeout", ErrAcceptTimeout, true, false},
+		{"TCP deadline", &net.OpError{Op: "accept", Net: "tcp", Err: os.ErrDeadlineExceeded}, true, false},
+		{"EMFILE", syscallErr(syscall.EMFILE), false, true},
+		{"ENFILE", syscallErr(syscall.ENFILE), false, true},
+		{"ENOBUFS", syscallErr(syscall.ENOBUFS), false, true},
+		{"ENOMEM", syscallErr(syscall.ENOMEM), false, true},
+		{"ECONNABORTED", syscallErr(syscall.ECONNABORTED), false, true},
+		{"EPROTO", syscallErr(syscall.EPROTO), false, true},
+		{"EBADF", syscallErr(syscall.EBADF), false, false},
+		{"closed listener", &net.OpError{Op: "accept", Net: "tcp", Err: net.ErrClosed}, false, false},
+		{"other", errors.New("listener broken"), false, false},
+	} {
+		if got := IsAcceptTimeout(tc.err); got != tc.timeout {
+			t.Errorf("%s: IsAcceptTimeout = %v, want %v", tc.name, got, tc.timeout)
+		}
+		if got := IsTemporary(tc.err); got != tc.temporary {
+			t.Errorf("%s: IsTemporary = %v, want %v", tc.name, got, tc.temporary)
+		}
+	}
+}
+
+// TestAcceptTimeout checks that the accept timeout of a TCP listener
+// expires with an error classified as such, and that the listener then
+// still accepts connections.
+func TestAcceptTimeout(t *testing.T) {
+	ln, err := NewDiameterListener("127.0.0.1:0", Proto_TCP, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if _, err := ln.Accept(); !IsAcceptTimeout(err) {
+		t.Fatalf("Accept without a connection = %v, want an accept timeout", err)
+	}
+	conn, err := net.Dial("tcp", ln.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	accepted, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept after a timeout: %v", err)
+	}
+	accepted.Close()
+}
@@ -0,0 +1,98 @@
+// Socket options for established connections
+package transport
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+	"unsafe"
+
+	"github.com/ishidawataru/sctp"
+)
+
+// SocketOptions configures the socket of a connection after dial or
+// accept. Zero values leave the system defaults in place.
+type SocketOptions struct {
+	// KeepAlive enables TCP keepalive probes with the given interval.
+	KeepAlive time.Duration
+	// NoDelay, when set, enables or disables Nagle's algorithm.
+	NoDelay *bool
+	// SendBuffer and ReceiveBuffer set the socket buffer sizes in bytes.
+	SendBuffer    int
+	ReceiveBuffer int
+}
+
+// ApplySocketOptions applies opts to the underlying socket, below TLS if
+// any. SCTP sockets support everything but keepalive, for which
+// ErrUnsupportedOption is returned.
+func (dc *DiameterConnection) ApplySocketOptions(opts SocketOptions) error {
+	conn := dc.conn
+	if c, ok := conn.(*tls.Conn); ok {
+		conn = c.NetConn()
+	}
+	switch c := conn.(type) {
+	case *net.TCPConn:
+		return applyTCPOptions(c, opts)
+	case *sctp.SCTPConn:
+		return applySCTPOptions(c, opts)
+	}
+	if opts != (SocketOptions{}) {
+		return ErrUnsupportedOption
+	}
+	return nil
+}
+
+func applyTCPOptions(c *net.TCPConn, opts SocketOptions) error {
+	if opts.KeepAlive > 0 {
+		if err := c.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if err := c.SetKeepAlivePeriod(opts.KeepAlive); err != nil {
+			return err
+		}
+	}
+	if opts.NoDelay != nil {
+		if err := c.SetNoDelay(*opts.NoDelay); err != nil {
+			return err
+		}
+	}
+	if opts.SendBuffer > 0 {
+		if err := c.SetWriteBuffer(opts.SendBuffer); err != nil {
+			return err
+		}
+	}
+	if opts.ReceiveBuffer > 0 {
+		if err := c.SetReadBuffer(opts.ReceiveBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applySCTPOptions(c *sctp.SCTPConn, opts SocketOptions) error {
+	if opts.KeepAlive > 0 {
+		// SCTP has its own heartbeat mechanism which the sctp library
+		// does not expose.
+		return ErrUnsupportedOption
+	}
+	if opts.NoDelay != nil {
+		v := int32(0)
+		if *opts.NoDelay {
+			v = 1
+		}
+		if _, _, err := c.Setsockopt(sctp.SCTP_NODELAY, uintptr(unsafe.Pointer(&v)), unsafe.Sizeof(v)); err != nil {
+			return err
+		}
+	}
+	if opts.SendBuffer > 0 {
+		if err := c.SetWriteBuffer(opts.SendBuffer); err != nil {
+			return err
+		}
+	}
+	if opts.ReceiveBuffer > 0 {
+		if err := c.SetReadBuffer(opts.ReceiveBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package transport
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// sockopt reads an integer socket option of conn.
+func sockopt(t *testing.T, conn net.Conn, level, opt int) int {
+	t.Helper()
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	var optErr error
+	if err := raw.Control(func(fd uintptr) {
+		v, optErr = syscall.GetsockoptInt(int(fd), level, opt)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if optErr != nil {
+		t.Fatalf("reading socket option %d: %v", opt, optErr)
+	}
+	return v
+}
+
+func TestApplySocketOptionsTCP(t *testing.T) {
+	dc, _ := tcpPair(t)
+	noDelay := false
+	opts := SocketOptions{
+		KeepAlive:     42 * time.Second,
+		NoDelay:       &noDelay,
+		SendBuffer:    64 << 10,
+		ReceiveBuffer: 32 << 10,
+	}
+	if err := dc.ApplySocketOptions(opts); err != nil {
+		t.Fatalf("ApplySocketOptions: %v", err)
+	}
+	for _, tc := range []struct {
+		name       string
+		level, opt int
+		// want is the value read back; the kernel doubles buffer sizes
+		// for its bookkeeping, so those are checked as a minimum.
+		want    int
+		atLeast bool
+	}{
+		{"SO_KEEPALIVE", syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1, false},
+		{"TCP_KEEPIDLE", syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, 42, false},
+		{"TCP_NODELAY", syscall.IPPROTO_TCP, syscall.TCP_NODELAY, 0, false},
+		{"SO_SNDBUF", syscall.SOL_SOCKET, syscall.SO_SNDBUF, opts.SendBuffer, true},
+		{"SO_RCVBUF", syscall.SOL_SOCKET, syscall.SO_RCVBUF, opts.ReceiveBuffer, true},
+	} {
+		got := sockopt(t, dc.conn, tc.level, tc.opt)
+		if got != tc.want && !(tc.atLeast && got > tc.want) {
+			t.Errorf("%s = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestApplySocketOptionsUnsupported(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	dc := &DiameterConnection{conn: conn, protocol: Proto_TCP}
+	if err := dc.ApplySocketOptions(SocketOptions{}); err != nil {
+		t.Errorf("no options on a pipe: %v", err)
+	}
+	if err := dc.ApplySocketOptions(SocketOptions{KeepAlive: time.Second}); err != ErrUnsupportedOption {
+		t.Errorf("keepalive on a pipe: %v, want ErrUnsupportedOption", err)
+	}
+}
@@ -0,0 +1,94 @@
+//go:build linux && !386
+
+// SCTP event subscription where the sctp library supports it
+package transport
+
+import (
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/ishidawataru/sctp"
+)
+
+// SCTPEventsSupported reports whether SCTP connections can deliver
+// ConnEvents on this platform.
+const SCTPEventsSupported = true
+
+// sctpEventFlags selects the notifications delivered as ConnEvents.
+const sctpEventFlags = sctp.SCTP_EVENT_ASSOCIATION | sctp.SCTP_EVENT_ADDRESS
+
+// NewDiameterSCTPEventConnection dials addr over SCTP like
+// NewDiameterConnection and subscribes the association to ConnEvents.
+func NewDiameterSCTPEventConnection(addr string) (*DiameterConnection, error) {
+	var fd int
+	cfg := sctp.SocketConfig{
+		InitMsg: sctp.InitMsg{NumOstreams: sctp.SCTP_MAX_STREAM},
+		Control: socketFD(&fd),
+	}
+	// The library needs a local address to call Control; the wildcard
+	// lets the kernel choose as it does without one.
+	laddr := &sctp.SCTPAddr{}
+	raddr := &sctp.SCTPAddr{IPAddrs: []net.IPAddr{{IP: net.ParseIP(addr)}}}
+	if _, err := cfg.Dial("sctp", laddr, raddr); err != nil {
+		return nil, err
+	}
+	return subscribeSCTPEvents(fd)
+}
+
+// NewDiameterSCTPEventListener listens on addr over SCTP like
+// NewDiameterListener and subscribes every accepted association to
+// ConnEvents.
+func NewDiameterSCTPEventListener(addr string, acceptTimeout time.Duration) (*DiameterListener, error) {
+	var fd int
+	cfg := sctp.SocketConfig{
+		InitMsg: sctp.InitMsg{NumOstreams: sctp.SCTP_MAX_STREAM},
+		Control: socketFD(&fd),
+	}
+	laddr, err := resolveSCTPAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := cfg.Listen("sctp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	return &DiameterListener{
+		listener:      listener,
+		addr:          addr,
+		acceptTimeout: acceptTimeout,
+		protocol:      Proto_SCTP,
+		accept: func() (*DiameterConnection, error) {
+			conn, _, err := syscall.Accept4(fd, 0)
+			if err != nil {
+				return nil, err
+			}
+			return subscribeSCTPEvents(conn)
+		},
+	}, nil
+}
+
+// socketFD returns a socket Control function storing the descriptor in
+// fd.
+func socketFD(fd *int) func(network, address string, c syscall.RawConn) error {
+	return func(_, _ string, c syscall.RawConn) error {
+		return c.Control(func(s uintptr) {
+			*fd = int(s)
+		})
+	}
+}
+
+// subscribeSCTPEvents wraps the connected socket fd into a connection
+// whose notifications become ConnEvents. The library only takes a
+// notification handler when the connection is created, which is why the
+// connection returned by Dial is not used.
+func subscribeSCTPEvents(fd int) (*DiameterConnection, error) {
+	dc := &DiameterConnection{protocol: Proto_SCTP}
+	conn := sctp.NewSCTPConn(fd, dc.notify)
+	if err := conn.SubscribeEvents(sctpEventFlags); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	dc.conn = conn
+	return dc, nil
+}
@@ -0,0 +1,22 @@
+//go:build !linux || 386
+
+// SCTP without event subscription
+package transport
+
+import "time"
+
+// SCTPEventsSupported reports whether SCTP connections can deliver
+// ConnEvents on this platform.
+const SCTPEventsSupported = false
+
+// NewDiameterSCTPEventConnection is NewDiameterConnection over SCTP: the
+// sctp library delivers no notifications on this platform.
+func NewDiameterSCTPEventConnection(addr string) (*DiameterConnection, error) {
+	return NewDiameterConnection(addr, Proto_SCTP, 0)
+}
+
+// NewDiameterSCTPEventListener is NewDiameterListener over SCTP: the sctp
+// library delivers no notifications on this platform.
+func NewDiameterSCTPEventListener(addr string, acceptTimeout time.Duration) (*DiameterListener, error) {
+	return NewDiameterListener(addr, Proto_SCTP, acceptTimeout)
+}
@@ -0,0 +1,106 @@
+// TLS connections and peer certificate identity
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"time"
+)
+
+// TLSIdentityMode selects what happens when the certificate of a TLS peer
+// does not name the Origin-Host it announces in the capabilities
+// exchange, as a certificate taken from one node could otherwise be used
+// to impersonate another.
+type TLSIdentityMode int
+
+const (
+	// TLSIdentityOff does not compare the certificate with the identity.
+	TLSIdentityOff TLSIdentityMode = iota
+	// TLSIdentityWarn logs a mismatch and keeps the connection.
+	TLSIdentityWarn
+	// TLSIdentityEnforce rejects the peer with DIAMETER_UNKNOWN_PEER.
+	TLSIdentityEnforce
+)
+
+func (m TLSIdentityMode) String() string {
+	switch m {
+	case TLSIdentityOff:
+		return "off"
+	case TLSIdentityWarn:
+		return "warn"
+	case TLSIdentityEnforce:
+		return "enforce"
+	}
+	return fmt.Sprintf("TLSIdentityMode(%d)", int(m))
+}
+
+// NewDiameterTLSConnection dials addr over TCP and runs the TLS handshake
+// with config, as RFC 6733 section 2.1 expects on port 5868. Without a
+// ServerName in config the host part of addr is verified.
+func NewDiameterTLSConnection(addr string, config *tls.Config, timeout time.Duration) (*DiameterConnection, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+	return &DiameterConnection{
+		conn:     conn,
+		protocol: Proto_TCP,
+	}, nil
+}
+
+// NewDiameterTLSListener listens on addr over TCP and runs the TLS
+// handshake with config on every accepted connection.
+func NewDiameterTLSListener(addr string, config *tls.Config, acceptTimeout time.Duration) (*DiameterListener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &DiameterListener{
+		listener:      tls.NewListener(listener, config),
+		addr:          addr,
+		acceptTimeout: acceptTimeout,
+		protocol:      Proto_TCP,
+		tcp:           listener.(*net.TCPListener),
+	}, nil
+}
+
+// IsTLS reports whether the connection runs over TLS.
+func (dc *DiameterConnection) IsTLS() bool {
+	_, ok := dc.conn.(*tls.Conn)
+	return ok
+}
+
+// PeerCertificates returns the certificate chain presented by the peer,
+// completing the TLS handshake first if needed. It returns nil for a
+// connection without TLS or a peer that sent no certificate.
+func (dc *DiameterConnection) PeerCertificates() ([]*x509.Certificate, error) {
+	c, ok := dc.conn.(*tls.Conn)
+	if !ok {
+		return nil, nil
+	}
+	if err := c.Handshake(); err != nil {
+		return nil, err
+	}
+	return c.ConnectionState().PeerCertificates, nil
+}
+
+// VerifyPeerIdentity checks that the certificate of the peer names host,
+// the DiameterIdentity it announced, following the DNS name and wildcard
+// rules of x509.Certificate.VerifyHostname. It fails with
+// ErrCertificateIdentity when there is no certificate to check.
+func (dc *DiameterConnection) VerifyPeerIdentity(host string) error {
+	certs, err := dc.PeerCertificates()
+	if err != nil {
+		return err
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("%w: no peer certificate", ErrCertificateIdentity)
+	}
+	if err := certs[0].VerifyHostname(host); err != nil {
+		return fmt.Errorf("%w: %v", ErrCertificateIdentity, err)
+	}
+	return nil
+}
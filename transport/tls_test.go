@@ -0,0 +1,103 @@
+package transport
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/IbrahimShahzad/diameter/internal/testing/testcert"
+)
+
+// tlsPair returns both ends of a TLS connection, the client presenting
+// clientCert unless it is nil and the server presenting serverCert.
+func tlsPair(t *testing.T, ca *testcert.Authority, serverCert tls.Certificate, clientCert *tls.Certificate) (client, server *DiameterConnection) {
+	t.Helper()
+	config := ca.ServerConfig(serverCert)
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	ln, err := NewDiameterTLSListener("127.0.0.1:0", config, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.listener.Close() })
+	accepted := make(chan *DiameterConnection, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		// The handshake needs both ends, so the server runs it here.
+		conn.PeerCertificates()
+		accepted <- conn
+	}()
+	clientConfig := &tls.Config{RootCAs: ca.Pool(), ServerName: serverCert.Leaf.DNSNames[0]}
+	if clientCert != nil {
+		clientConfig.Certificates = []tls.Certificate{*clientCert}
+	}
+	client, err = NewDiameterTLSConnection(ln.listener.Addr().String(), clientConfig, time.Second)
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	t.Cleanup(func() { client.conn.Close() })
+	server, ok := <-accepted
+	if !ok {
+		t.Fatal("accepting failed")
+	}
+	t.Cleanup(func() { server.conn.Close() })
+	return client, server
+}
+
+func TestVerifyPeerIdentity(t *testing.T) {
+	ca := testcert.NewAuthority(t)
+	serverCert := ca.Issue(t, "server.example.com", "*.diameter.example.com")
+	clientCert := ca.Issue(t, "client.example.com")
+	client, server := tlsPair(t, ca, serverCert, &clientCert)
+	if !client.IsTLS() || !server.IsTLS() {
+		t.Fatal("connection not reported as TLS")
+	}
+	for _, tc := range []struct {
+		conn *DiameterConnection
+		host string
+		want error
+	}{
+		{client, "server.example.com", nil},
+		{client, "hss.diameter.example.com", nil},
+		{client, "a.hss.diameter.example.com", ErrCertificateIdentity},
+		{client, "diameter.example.com", ErrCertificateIdentity},
+		{client, "client.example.com", ErrCertificateIdentity},
+		{server, "client.example.com", nil},
+		{server, "server.example.com", ErrCertificateIdentity},
+	} {
+		side := "client"
+		if tc.conn == server {
+			side = "server"
+		}
+		if err := tc.conn.VerifyPeerIdentity(tc.host); !errors.Is(err, tc.want) {
+			t.Errorf("%s: VerifyPeerIdentity(%q) = %v, want %v", side, tc.host, err, tc.want)
+		}
+	}
+}
+
+func TestVerifyPeerIdentityWithoutCertificate(t *testing.T) {
+	ca := testcert.NewAuthority(t)
+	_, server := tlsPair(t, ca, ca.Issue(t, "server.example.com"), nil)
+	if certs, err := server.PeerCertificates(); err != nil || len(certs) != 0 {
+		t.Errorf("PeerCertificates() = %v, %v; want none", certs, err)
+	}
+	if err := server.VerifyPeerIdentity("client.example.com"); !errors.Is(err, ErrCertificateIdentity) {
+		t.Errorf("VerifyPeerIdentity without a certificate = %v", err)
+	}
+
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	plain := &DiameterConnection{conn: a, protocol: Proto_TCP}
+	if plain.IsTLS() {
+		t.Error("plain connection reported as TLS")
+	}
+	if certs, err := plain.PeerCertificates(); err != nil || certs != nil {
+		t.Errorf("PeerCertificates() without TLS = %v, %v", certs, err)
+	}
+}
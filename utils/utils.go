@@ -1,5 +1,7 @@
 package utils
 
+import "encoding/binary"
+
 type Encoder interface {
 	Encode() ([]byte, error)
 }
@@ -16,8 +18,19 @@ func Decode(d Decoder, data []byte) error {
 	return d.Decode(data)
 }
 
+// ToBytes returns the count low-order bytes of value in network byte
+// order. It allocates; PutUint24 and PutUint32 write into an existing
+// buffer instead.
 func ToBytes(value uint32, count int) []byte {
 	result := make([]byte, count)
+	switch count {
+	case 3:
+		PutUint24(result, value)
+		return result
+	case 4:
+		PutUint32(result, value)
+		return result
+	}
 	for i := 0; i < count; i++ {
 		sh := (count - i - 1) * 8
 		result[i] = byte(value >> uint(sh))
@@ -25,6 +38,8 @@ func ToBytes(value uint32, count int) []byte {
 	return result
 }
 
+// FromBytes decodes data, at most 4 bytes long, as a network byte order
+// integer.
 func FromBytes(data []byte) uint32 {
 	var result uint32
 	for i := 0; i < len(data); i++ {
@@ -32,3 +47,31 @@ func FromBytes(data []byte) uint32 {
 	}
 	return result
 }
+
+// PutUint24 writes the 24 low-order bits of v to buf in network byte
+// order. It panics if buf is shorter than 3 bytes, like encoding/binary.
+func PutUint24(buf []byte, v uint32) {
+	_ = buf[2] // single bounds check
+	buf[0] = byte(v >> 16)
+	buf[1] = byte(v >> 8)
+	buf[2] = byte(v)
+}
+
+// PutUint32 writes v to buf in network byte order. It panics if buf is
+// shorter than 4 bytes.
+func PutUint32(buf []byte, v uint32) {
+	binary.BigEndian.PutUint32(buf, v)
+}
+
+// Uint24 decodes the first 3 bytes of buf in network byte order. It panics
+// if buf is shorter than 3 bytes.
+func Uint24(buf []byte) uint32 {
+	_ = buf[2] // single bounds check
+	return uint32(buf[0])<<16 | uint32(buf[1])<<8 | uint32(buf[2])
+}
+
+// Uint32 decodes the first 4 bytes of buf in network byte order. It panics
+// if buf is shorter than 4 bytes.
+func Uint32(buf []byte) uint32 {
+	return binary.BigEndian.Uint32(buf)
+}
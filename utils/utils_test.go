@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestByteOrder(t *testing.T) {
+	for _, v := range []uint32{0, 1, 0x0102, 0x010203, 0xffffff, 0x01020304, 0xffffffff} {
+		buf := make([]byte, 4)
+		PutUint32(buf, v)
+		if got := Uint32(buf); got != v {
+			t.Errorf("Uint32 of %x = %x, want %x", buf, got, v)
+		}
+		if want := ToBytes(v, 4); string(buf) != string(want) {
+			t.Errorf("PutUint32(%x) = %x, ToBytes %x", v, buf, want)
+		}
+		if got := FromBytes(buf); got != v {
+			t.Errorf("FromBytes of %x = %x, want %x", buf, got, v)
+		}
+
+		PutUint24(buf, v)
+		if got, want := Uint24(buf), v&0xffffff; got != want {
+			t.Errorf("Uint24 of %x = %x, want %x", buf, got, want)
+		}
+		if want := ToBytes(v, 3); string(buf[:3]) != string(want) {
+			t.Errorf("PutUint24(%x) = %x, ToBytes %x", v, buf[:3], want)
+		}
+		if buf[3] != byte(v) {
+			t.Errorf("PutUint24(%x) wrote past 3 bytes", v)
+		}
+	}
+	if got := ToBytes(0x0102, 2); string(got) != "\x01\x02" {
+		t.Errorf("ToBytes(0x0102, 2) = %x", got)
+	}
+}
+
+// TestByteOrderShortBuffers checks that the helpers panic on buffers too
+// short for the field rather than reading or writing past them.
+func TestByteOrderShortBuffers(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		size int
+		fn   func([]byte)
+	}{
+		{"PutUint24", 3, func(b []byte) { PutUint24(b, 0xffffff) }},
+		{"PutUint32", 4, func(b []byte) { PutUint32(b, 0xffffffff) }},
+		{"Uint24", 3, func(b []byte) { Uint24(b) }},
+		{"Uint32", 4, func(b []byte) { Uint32(b) }},
+	} {
+		for n := range tc.size {
+			// The spare capacity must not be written to either.
+			buf := make([]byte, n, tc.size)
+			panicked := func() (panicked bool) {
+				defer func() { panicked = recover() != nil }()
+				tc.fn(buf)
+				return false
+			}()
+			if !panicked {
+				t.Errorf("%s on %d bytes did not panic", tc.name, n)
+			}
+			if spare := buf[:tc.size]; string(spare) != string(make([]byte, tc.size)) {
+				t.Errorf("%s on %d bytes wrote %x", tc.name, n, spare)
+			}
+		}
+	}
+}
+
+func BenchmarkByteOrder(b *testing.B) {
+	buf := make([]byte, 4)
+	var sink uint32
+	for _, size := range []int{3, 4} {
+		b.Run(fmt.Sprintf("ToBytes/%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := range b.N {
+				sink += FromBytes(ToBytes(uint32(i), size))
+			}
+		})
+	}
+	b.Run("PutUint24", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := range b.N {
+			PutUint24(buf, uint32(i))
+			sink += Uint24(buf)
+		}
+	})
+	b.Run("PutUint32", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := range b.N {
+			PutUint32(buf, uint32(i))
+			sink += Uint32(buf)
+		}
+	})
+	_ = sink
+}